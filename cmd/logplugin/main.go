@@ -1,87 +1,20 @@
+// Command logplugin serves the elastic log provider to OpsOrch Core as a
+// plugin, answering requests on stdin with responses on stdout.
 package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
 	"os"
+	"os/signal"
+	"syscall"
 
-	corelog "github.com/opsorch/opsorch-core/log"
-	"github.com/opsorch/opsorch-core/schema"
-	adapter "github.com/opsorch/opsorch-elastic-adapter/log"
+	"github.com/opsorch/opsorch-elastic-adapter/plugin"
 )
 
-type rpcRequest struct {
-	Method  string          `json:"method"`
-	Config  map[string]any  `json:"config"`
-	Payload json.RawMessage `json:"payload"`
-}
-
-type rpcResponse struct {
-	Result any    `json:"result,omitempty"`
-	Error  string `json:"error,omitempty"`
-}
-
-var provider corelog.Provider
-
+// main serves requests on stdin until it closes or the process is asked to
+// stop, then drains the requests in flight and exits.
 func main() {
-	dec := json.NewDecoder(os.Stdin)
-	enc := json.NewEncoder(os.Stdout)
-
-	for {
-		var req rpcRequest
-		if err := dec.Decode(&req); err != nil {
-			if errors.Is(err, io.EOF) {
-				return
-			}
-			writeErr(enc, err)
-			return
-		}
-
-		prov, err := ensureProvider(req.Config)
-		if err != nil {
-			writeErr(enc, err)
-			continue
-		}
-
-		ctx := context.Background()
-		switch req.Method {
-		case "log.query":
-			var query schema.LogQuery
-			if err := json.Unmarshal(req.Payload, &query); err != nil {
-				writeErr(enc, err)
-				continue
-			}
-			res, err := prov.Query(ctx, query)
-			write(enc, res, err)
-		default:
-			writeErr(enc, fmt.Errorf("unknown method: %s", req.Method))
-		}
-	}
-}
-
-func ensureProvider(cfg map[string]any) (corelog.Provider, error) {
-	if provider != nil {
-		return provider, nil
-	}
-	prov, err := adapter.New(cfg)
-	if err != nil {
-		return nil, err
-	}
-	provider = prov
-	return provider, nil
-}
-
-func write(enc *json.Encoder, result any, err error) {
-	if err != nil {
-		writeErr(enc, err)
-		return
-	}
-	_ = enc.Encode(rpcResponse{Result: result})
-}
-
-func writeErr(enc *json.Encoder, err error) {
-	_ = enc.Encode(rpcResponse{Error: err.Error()})
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	plugin.Serve(os.Stdin, os.Stdout, plugin.WithContext(ctx), plugin.WithLogLevel(os.Getenv(plugin.LogLevelEnv)))
 }
@@ -6,6 +6,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/opsorch/opsorch-core/schema"
@@ -264,9 +266,67 @@ func main() {
 		testResult("Error handling for invalid config", fmt.Errorf("should have rejected config without addresses"))
 	}
 
+	// Test 11: Scope filter on a text-mapped field
+	fmt.Println("\n=== Test 11: Scope Filter on a Text-Mapped Field ===")
+	testResult("Scope filter on text-mapped field", textScopeMatches(ctx))
+
 	printSummary(totalTests, passedTests, failedTests, startTime)
 }
 
+// textScopeMatches indexes a log whose service field is mapped as text, as
+// indices created without a template map it, and checks that a service
+// scope finds it.
+func textScopeMatches(ctx context.Context) error {
+	const base = "http://localhost:9200/opsorch-integ-text-scope"
+	send := func(method, url, body string) error {
+		req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode >= 300 && !(method == http.MethodDelete && res.StatusCode == http.StatusNotFound) {
+			return fmt.Errorf("%s %s: status %d", method, url, res.StatusCode)
+		}
+		return nil
+	}
+	send(http.MethodDelete, base, "")
+	defer send(http.MethodDelete, base, "")
+	if err := send(http.MethodPut, base, `{"mappings":{"properties":{"@timestamp":{"type":"date"},"message":{"type":"text"},"service":{"type":"text"}}}}`); err != nil {
+		return err
+	}
+	doc := fmt.Sprintf(`{"@timestamp":%q,"message":"payment declined","service":"checkout-api"}`, time.Now().UTC().Format(time.RFC3339))
+	if err := send(http.MethodPost, base+"/_doc?refresh=true", doc); err != nil {
+		return err
+	}
+
+	provider, err := elasticlog.New(map[string]any{
+		"addresses":    []any{"http://localhost:9200"},
+		"indexPattern": "opsorch-integ-text-scope",
+	})
+	if err != nil {
+		return err
+	}
+	results, err := provider.Query(ctx, schema.LogQuery{
+		Start: time.Now().Add(-1 * time.Hour),
+		End:   time.Now().Add(time.Minute),
+		Scope: schema.QueryScope{Service: "checkout-api"},
+		Limit: 5,
+	})
+	if err != nil {
+		return err
+	}
+	if len(results.Entries) != 1 {
+		return fmt.Errorf("expected 1 entry for service=checkout-api, got %d", len(results.Entries))
+	}
+	fmt.Println("Found the entry by its text-mapped service")
+	return nil
+}
+
 func printSummary(totalTests, passedTests, failedTests int, startTime time.Time) {
 	// Print summary
 	duration := time.Since(startTime)
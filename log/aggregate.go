@@ -0,0 +1,278 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Aggregate functions.
+const (
+	AggregateAvg         = "avg"
+	AggregateMin         = "min"
+	AggregateMax         = "max"
+	AggregateSum         = "sum"
+	AggregatePercentiles = "percentiles"
+	AggregateCardinality = "cardinality"
+)
+
+const (
+	defaultAggregateGroups = 10
+	maxAggregateGroups     = 100
+)
+
+// defaultPercents are the percentiles computed when none are requested.
+var defaultPercents = []float64{50, 95, 99}
+
+// AggregateSpec describes a numeric aggregation over a log field, such as
+// the p95 of duration_ms per service every minute.
+type AggregateSpec struct {
+	// Function is avg, min, max, sum, percentiles or cardinality.
+	Function string `json:"function"`
+	Field    string `json:"field"`
+	// Percents are the percentiles to compute (default 50, 95, 99).
+	Percents []float64 `json:"percents,omitempty"`
+	// Interval buckets the aggregation over time: a duration such as "1m",
+	// or "auto" for about 100 buckets over the query window. Empty means a
+	// single value over the whole window.
+	Interval string `json:"interval,omitempty"`
+	// GroupBy splits the aggregation by the top values of a field, at most
+	// Size of them (default 10).
+	GroupBy string `json:"groupBy,omitempty"`
+	Size    int    `json:"size,omitempty"`
+}
+
+// AggregateResult holds one series per group and percentile.
+type AggregateResult struct {
+	Series []AggregateSeries `json:"series"`
+}
+
+// AggregateSeries is the aggregation for one set of labels: the GroupBy
+// field's value and, for percentiles, "percentile". Without an interval it
+// has a single Value; with one, a Point per bucket.
+type AggregateSeries struct {
+	Labels map[string]string `json:"labels"`
+	Value  *float64          `json:"value,omitempty"`
+	Points []AggregatePoint  `json:"points,omitempty"`
+}
+
+// AggregatePoint is a series value in the interval starting at Timestamp.
+// Value is nil when the interval has no logs with the field.
+type AggregatePoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     *float64  `json:"value"`
+}
+
+// Aggregate computes a numeric aggregation over a field of the logs
+// matching a query, optionally bucketed over time and grouped by a field.
+func (p *ElasticProvider) Aggregate(ctx context.Context, query schema.LogQuery, agg AggregateSpec) (AggregateResult, error) {
+	ctx = withAudit(ctx, "log.aggregate", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return AggregateResult{}, err
+	}
+	interval, err := agg.validate(query)
+	if err != nil {
+		return AggregateResult{}, err
+	}
+
+	var result esAggregateResponse
+	if err := p.searchInto(ctx, p.buildAggregateQuery(query, agg, interval), &result); err != nil {
+		return AggregateResult{}, err
+	}
+	return result.series(agg), nil
+}
+
+// validate checks the spec and returns its interval, zero when the
+// aggregation is not bucketed over time.
+func (agg AggregateSpec) validate(query schema.LogQuery) (time.Duration, error) {
+	switch agg.Function {
+	case AggregateAvg, AggregateMin, AggregateMax, AggregateSum, AggregatePercentiles, AggregateCardinality:
+	default:
+		return 0, fmt.Errorf("unsupported aggregate function %q", agg.Function)
+	}
+	if agg.Field == "" {
+		return 0, errors.New("aggregate field is required")
+	}
+	for _, percent := range agg.Percents {
+		if percent <= 0 || percent > 100 {
+			return 0, fmt.Errorf("invalid percentile %v: must be above 0 and at most 100", percent)
+		}
+	}
+	if agg.Size < 0 || agg.Size > maxAggregateGroups {
+		return 0, fmt.Errorf("invalid group size %d: must be between 1 and %d", agg.Size, maxAggregateGroups)
+	}
+
+	switch agg.Interval {
+	case "":
+		return 0, nil
+	case "auto":
+		return histogramInterval(query), nil
+	}
+	interval, err := time.ParseDuration(agg.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid aggregate interval: %w", err)
+	}
+	if interval < time.Millisecond {
+		return 0, fmt.Errorf("invalid aggregate interval %s: must be at least 1ms", interval)
+	}
+	return interval, nil
+}
+
+// buildAggregateQuery constructs a size 0 search nesting the metric under
+// an optional date_histogram, itself under an optional terms grouping.
+func (p *ElasticProvider) buildAggregateQuery(query schema.LogQuery, agg AggregateSpec, interval time.Duration) map[string]any {
+	metric := map[string]any{"field": agg.Field}
+	if agg.Function == AggregatePercentiles {
+		percents := agg.Percents
+		if len(percents) == 0 {
+			percents = defaultPercents
+		}
+		metric["percents"] = percents
+		metric["keyed"] = false
+	}
+	aggs := map[string]any{"metric": map[string]any{agg.Function: metric}}
+
+	if interval > 0 {
+		aggs = map[string]any{
+			"over_time": map[string]any{
+				"date_histogram": dateHistogram(query, interval),
+				"aggs":           aggs,
+			},
+		}
+	}
+	if agg.GroupBy != "" {
+		size := agg.Size
+		if size == 0 {
+			size = defaultAggregateGroups
+		}
+		aggs = map[string]any{
+			"groups": map[string]any{
+				"terms": map[string]any{"field": agg.GroupBy, "size": size},
+				"aggs":  aggs,
+			},
+		}
+	}
+
+	return map[string]any{
+		"query": p.boolQuery(query),
+		"size":  0,
+		"aggs":  aggs,
+	}
+}
+
+// esMetric is a metric aggregation result: Value for single-value metrics,
+// Values for percentiles requested with keyed false.
+type esMetric struct {
+	Value  *float64 `json:"value"`
+	Values []struct {
+		Key   float64  `json:"key"`
+		Value *float64 `json:"value"`
+	} `json:"values"`
+}
+
+type esTimeBuckets struct {
+	Buckets []struct {
+		Key    int64    `json:"key"`
+		Metric esMetric `json:"metric"`
+	} `json:"buckets"`
+}
+
+// esAggregateLevel is the level holding either the metric or the
+// date_histogram around it.
+type esAggregateLevel struct {
+	Metric   esMetric       `json:"metric"`
+	OverTime *esTimeBuckets `json:"over_time"`
+}
+
+type esAggregateResponse struct {
+	esSearchResponse
+	Aggregations struct {
+		esAggregateLevel
+		Groups *struct {
+			Buckets []struct {
+				esAggregateLevel
+				Key         any    `json:"key"`
+				KeyAsString string `json:"key_as_string"`
+			} `json:"buckets"`
+		} `json:"groups"`
+	} `json:"aggregations"`
+}
+
+// series flattens the nested aggregation into labeled series, in group
+// order and then percentile order.
+func (r esAggregateResponse) series(agg AggregateSpec) AggregateResult {
+	out := AggregateResult{Series: []AggregateSeries{}}
+	if r.Aggregations.Groups == nil {
+		out.Series = r.Aggregations.series(map[string]string{})
+		return out
+	}
+	for _, group := range r.Aggregations.Groups.Buckets {
+		value := group.KeyAsString
+		if value == "" {
+			if s, ok := group.Key.(string); ok {
+				value = s
+			} else {
+				value, _ = scalarString(group.Key)
+			}
+		}
+		out.Series = append(out.Series, group.series(map[string]string{agg.GroupBy: value})...)
+	}
+	return out
+}
+
+// series converts one level into a series per metric value, each labeled
+// with labels and, for percentiles, the percentile.
+func (l esAggregateLevel) series(labels map[string]string) []AggregateSeries {
+	if l.OverTime == nil {
+		values := l.Metric.values()
+		out := make([]AggregateSeries, 0, len(values))
+		for _, v := range values {
+			out = append(out, AggregateSeries{Labels: v.labels(labels), Value: v.value})
+		}
+		return out
+	}
+
+	var out []AggregateSeries
+	for _, bucket := range l.OverTime.Buckets {
+		timestamp := time.UnixMilli(bucket.Key).UTC()
+		for i, v := range bucket.Metric.values() {
+			if i == len(out) {
+				out = append(out, AggregateSeries{Labels: v.labels(labels), Points: []AggregatePoint{}})
+			}
+			out[i].Points = append(out[i].Points, AggregatePoint{Timestamp: timestamp, Value: v.value})
+		}
+	}
+	return out
+}
+
+// metricValue is one value of a metric, with its percentile if any.
+type metricValue struct {
+	percentile string
+	value      *float64
+}
+
+func (m esMetric) values() []metricValue {
+	if m.Values == nil {
+		return []metricValue{{value: m.Value}}
+	}
+	out := make([]metricValue, 0, len(m.Values))
+	for _, v := range m.Values {
+		out = append(out, metricValue{percentile: strconv.FormatFloat(v.Key, 'f', -1, 64), value: v.Value})
+	}
+	return out
+}
+
+func (v metricValue) labels(base map[string]string) map[string]string {
+	labels := make(map[string]string, len(base)+1)
+	for key, value := range base {
+		labels[key] = value
+	}
+	if v.percentile != "" {
+		labels["percentile"] = v.percentile
+	}
+	return labels
+}
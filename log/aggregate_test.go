@@ -0,0 +1,147 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestBuildAggregateQuery(t *testing.T) {
+	p := &ElasticProvider{}
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	query := schema.LogQuery{Start: start, End: start.Add(time.Hour)}
+
+	tests := []struct {
+		name     string
+		spec     AggregateSpec
+		interval time.Duration
+		want     string
+	}{
+		{
+			name: "single value",
+			spec: AggregateSpec{Function: AggregateAvg, Field: "duration_ms"},
+			want: `{"metric":{"avg":{"field":"duration_ms"}}}`,
+		},
+		{
+			name:     "over time",
+			spec:     AggregateSpec{Function: AggregateCardinality, Field: "user.id"},
+			interval: time.Minute,
+			want:     `{"over_time":{"aggs":{"metric":{"cardinality":{"field":"user.id"}}},"date_histogram":{"extended_bounds":{"max":1696165200000,"min":1696161600000},"field":"@timestamp","fixed_interval":"60000ms","min_doc_count":0}}}`,
+		},
+		{
+			name:     "grouped percentiles over time",
+			spec:     AggregateSpec{Function: AggregatePercentiles, Field: "duration_ms", GroupBy: "service", Size: 3},
+			interval: time.Minute,
+			want:     `{"groups":{"aggs":{"over_time":{"aggs":{"metric":{"percentiles":{"field":"duration_ms","keyed":false,"percents":[50,95,99]}}},"date_histogram":{"extended_bounds":{"max":1696165200000,"min":1696161600000},"field":"@timestamp","fixed_interval":"60000ms","min_doc_count":0}}},"terms":{"field":"service","size":3}}}`,
+		},
+		{
+			name: "grouped",
+			spec: AggregateSpec{Function: AggregateSum, Field: "bytes", GroupBy: "host.name"},
+			want: `{"groups":{"aggs":{"metric":{"sum":{"field":"bytes"}}},"terms":{"field":"host.name","size":10}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := p.buildAggregateQuery(query, tt.spec, tt.interval)
+			if body["size"] != 0 {
+				t.Errorf("size = %v, want 0", body["size"])
+			}
+			aggs, _ := json.Marshal(body["aggs"])
+			if string(aggs) != tt.want {
+				t.Errorf("aggs = %s\nwant %s", aggs, tt.want)
+			}
+		})
+	}
+}
+
+func aggregateResult(t *testing.T, response string, spec AggregateSpec) AggregateResult {
+	t.Helper()
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, response
+	})
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	result, err := p.Aggregate(context.Background(), schema.LogQuery{Start: start, End: start.Add(2 * time.Minute)}, spec)
+	if err != nil {
+		t.Fatalf("aggregate failed: %v", err)
+	}
+	return result
+}
+
+func TestAggregateSingleValue(t *testing.T) {
+	result := aggregateResult(t, `{"hits":{"hits":[]},"aggregations":{"metric":{"value":231.5}}}`,
+		AggregateSpec{Function: AggregateAvg, Field: "duration_ms"})
+
+	encoded, _ := json.Marshal(result)
+	if want := `{"series":[{"labels":{},"value":231.5}]}`; string(encoded) != want {
+		t.Errorf("result = %s, want %s", encoded, want)
+	}
+}
+
+func TestAggregateGroupedPercentilesOverTime(t *testing.T) {
+	result := aggregateResult(t, `{"hits":{"hits":[]},"aggregations":{"groups":{"buckets":[
+		{"key":"checkout","doc_count":30,"over_time":{"buckets":[
+			{"key":1696161600000,"key_as_string":"2023-10-01T12:00:00.000Z","doc_count":20,"metric":{"values":[{"key":50.0,"value":120},{"key":99.9,"value":870.25}]}},
+			{"key":1696161660000,"key_as_string":"2023-10-01T12:01:00.000Z","doc_count":0,"metric":{"values":[{"key":50.0,"value":null},{"key":99.9,"value":null}]}}
+		]}},
+		{"key":404,"doc_count":5,"over_time":{"buckets":[
+			{"key":1696161600000,"doc_count":5,"metric":{"values":[{"key":50.0,"value":3},{"key":99.9,"value":9}]}}
+		]}}
+	]}}}`, AggregateSpec{Function: AggregatePercentiles, Field: "duration_ms", Percents: []float64{50, 99.9}, Interval: "1m", GroupBy: "service"})
+
+	encoded, _ := json.Marshal(result)
+	want := `{"series":[` +
+		`{"labels":{"percentile":"50","service":"checkout"},"points":[{"timestamp":"2023-10-01T12:00:00Z","value":120},{"timestamp":"2023-10-01T12:01:00Z","value":null}]},` +
+		`{"labels":{"percentile":"99.9","service":"checkout"},"points":[{"timestamp":"2023-10-01T12:00:00Z","value":870.25},{"timestamp":"2023-10-01T12:01:00Z","value":null}]},` +
+		`{"labels":{"percentile":"50","service":"404"},"points":[{"timestamp":"2023-10-01T12:00:00Z","value":3}]},` +
+		`{"labels":{"percentile":"99.9","service":"404"},"points":[{"timestamp":"2023-10-01T12:00:00Z","value":9}]}]}`
+	if string(encoded) != want {
+		t.Errorf("result = %s\nwant %s", encoded, want)
+	}
+}
+
+func TestAggregateGroupedEmpty(t *testing.T) {
+	result := aggregateResult(t, `{"hits":{"hits":[]},"aggregations":{"groups":{"buckets":[
+		{"key":"web-1","doc_count":0,"metric":{"value":null}},
+		{"key":"web-2","doc_count":3,"metric":{"value":4096}}
+	]}}}`, AggregateSpec{Function: AggregateMax, Field: "bytes", GroupBy: "host.name"})
+
+	encoded, _ := json.Marshal(result)
+	want := `{"series":[{"labels":{"host.name":"web-1"}},{"labels":{"host.name":"web-2"},"value":4096}]}`
+	if string(encoded) != want {
+		t.Errorf("result = %s, want %s", encoded, want)
+	}
+}
+
+func TestAggregateValidation(t *testing.T) {
+	tests := []struct {
+		spec AggregateSpec
+		want string
+	}{
+		{spec: AggregateSpec{Function: "median", Field: "x"}, want: "unsupported aggregate function"},
+		{spec: AggregateSpec{Function: AggregateAvg}, want: "field is required"},
+		{spec: AggregateSpec{Function: AggregatePercentiles, Field: "x", Percents: []float64{0}}, want: "invalid percentile"},
+		{spec: AggregateSpec{Function: AggregateAvg, Field: "x", GroupBy: "service", Size: 500}, want: "invalid group size"},
+		{spec: AggregateSpec{Function: AggregateAvg, Field: "x", Interval: "soon"}, want: "invalid aggregate interval"},
+	}
+
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 500, `{}`
+	})
+	for _, tt := range tests {
+		if _, err := p.Aggregate(context.Background(), schema.LogQuery{}, tt.spec); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: err = %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+	if len(transport.recorded()) != 0 {
+		t.Errorf("requests = %s, want none", requestLine(transport.recorded()))
+	}
+
+	if interval, err := (AggregateSpec{Function: AggregateAvg, Field: "x", Interval: "auto"}).validate(schema.LogQuery{}); err != nil || interval != defaultHistogramInterval {
+		t.Errorf("auto interval = %v, %v; want the histogram default", interval, err)
+	}
+}
@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// ErrAsyncSearchExpired is returned when an async search id is unknown,
+// usually because its keep-alive elapsed or it was cancelled.
+var ErrAsyncSearchExpired = errors.New("async search not found or expired")
+
+// Async search defaults: how long a submit or poll waits for completion
+// before returning, and how long Elasticsearch keeps the results.
+const (
+	defaultAsyncWaitTimeout = time.Second
+	defaultAsyncKeepAlive   = 5 * time.Minute
+)
+
+// AsyncResult is the state of an async search. Entries hold the hits found
+// so far; they are final once Running is false.
+type AsyncResult struct {
+	ID      string            `json:"id"`
+	Running bool              `json:"running"`
+	Partial bool              `json:"partial"`
+	Entries []schema.LogEntry `json:"entries"`
+	Stats   QueryStats        `json:"stats"`
+}
+
+type esAsyncSearchResponse struct {
+	ID        string           `json:"id"`
+	IsRunning bool             `json:"is_running"`
+	IsPartial bool             `json:"is_partial"`
+	Response  esSearchResponse `json:"response"`
+}
+
+// usage reports the documents and took time of the search, for metering.
+func (r esAsyncSearchResponse) usage() (documents, took int64) {
+	return r.Response.usage()
+}
+
+func (p *ElasticProvider) asyncWaitTimeout() time.Duration {
+	if p.cfg.AsyncWaitTimeout > 0 {
+		return p.cfg.AsyncWaitTimeout
+	}
+	return defaultAsyncWaitTimeout
+}
+
+func (p *ElasticProvider) asyncKeepAlive() time.Duration {
+	if p.cfg.AsyncKeepAlive > 0 {
+		return p.cfg.AsyncKeepAlive
+	}
+	return defaultAsyncKeepAlive
+}
+
+// SubmitAsync starts a query as an async search. Queries that finish within
+// the wait timeout come back complete; otherwise poll the returned id with
+// PollAsync.
+func (p *ElasticProvider) SubmitAsync(ctx context.Context, query schema.LogQuery) (AsyncResult, error) {
+	ctx = withAudit(ctx, "log.querySubmit", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return AsyncResult{}, err
+	}
+
+	queryBody, err := json.Marshal(p.buildQuery(query))
+	if err != nil {
+		return AsyncResult{}, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	res, err := p.client.AsyncSearch.Submit(
+		p.client.AsyncSearch.Submit.WithContext(ctx),
+		p.client.AsyncSearch.Submit.WithIndex(p.cfg.IndexPattern),
+		p.client.AsyncSearch.Submit.WithBody(bytes.NewReader(queryBody)),
+		p.client.AsyncSearch.Submit.WithWaitForCompletionTimeout(p.asyncWaitTimeout()),
+		p.client.AsyncSearch.Submit.WithKeepAlive(p.asyncKeepAlive()),
+		p.client.AsyncSearch.Submit.WithKeepOnCompletion(true),
+	)
+	if err != nil {
+		return AsyncResult{}, fmt.Errorf("async search submit failed: %w", err)
+	}
+	return p.asyncResult(ctx, res)
+}
+
+// PollAsync returns the current state of an async search.
+func (p *ElasticProvider) PollAsync(ctx context.Context, id string) (AsyncResult, error) {
+	ctx = withAudit(ctx, "log.queryPoll", schema.QueryScope{})
+	if id == "" {
+		return AsyncResult{}, errors.New("async search id is required")
+	}
+	res, err := p.client.AsyncSearch.Get(
+		id,
+		p.client.AsyncSearch.Get.WithContext(ctx),
+		p.client.AsyncSearch.Get.WithWaitForCompletionTimeout(p.asyncWaitTimeout()),
+	)
+	if err != nil {
+		return AsyncResult{}, fmt.Errorf("async search poll failed: %w", err)
+	}
+	return p.asyncResult(ctx, res)
+}
+
+// CancelAsync stops an async search and deletes its results.
+func (p *ElasticProvider) CancelAsync(ctx context.Context, id string) error {
+	ctx = withAudit(ctx, "log.queryCancel", schema.QueryScope{})
+	if id == "" {
+		return errors.New("async search id is required")
+	}
+	res, err := p.client.AsyncSearch.Delete(id, p.client.AsyncSearch.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("async search cancel failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrAsyncSearchExpired
+	}
+	if res.IsError() {
+		return responseError(res)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// asyncResult parses an async search response with the same normalization
+// as synchronous queries.
+func (p *ElasticProvider) asyncResult(ctx context.Context, res *esapi.Response) (AsyncResult, error) {
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return AsyncResult{}, ErrAsyncSearchExpired
+	}
+	if res.IsError() {
+		return AsyncResult{}, responseError(res)
+	}
+
+	var async esAsyncSearchResponse
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&async); err != nil {
+		return AsyncResult{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	p.meterResponse(ctx, async)
+	entries := p.appendResult(ctx, nil, async.Response)
+	if p.cfg.DedupeResults {
+		entries = dedupeConsecutive(entries)
+	}
+	if entries == nil {
+		entries = []schema.LogEntry{}
+	}
+	return AsyncResult{
+		ID:      async.ID,
+		Running: async.IsRunning,
+		Partial: async.IsPartial,
+		Entries: entries,
+		Stats:   async.Response.stats(),
+	}, nil
+}
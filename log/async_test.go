@@ -0,0 +1,94 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestAsyncSearchLifecycle(t *testing.T) {
+	polls := 0
+	cancelled := false
+	p, transport := newTestProvider(t, Config{AsyncKeepAlive: 10 * time.Minute}, func(req recordedRequest) (int, string) {
+		switch {
+		case req.Method == "POST" && req.Path == "/logs-*/_async_search":
+			return 200, `{"id":"FmRl","is_running":true,"is_partial":true,"response":{"took":1000,"hits":{"total":{"value":0,"relation":"gte"},"hits":[]}}}`
+		case req.Method == "GET" && req.Path == "/_async_search/FmRl":
+			if cancelled {
+				return 404, `{"error":{"type":"resource_not_found_exception","reason":"FmRl"},"status":404}`
+			}
+			polls++
+			if polls == 1 {
+				return 200, `{"id":"FmRl","is_running":true,"is_partial":true,"response":{"took":2000,"hits":{"total":{"value":1,"relation":"gte"},"hits":[
+					{"_id":"a","_source":{"@timestamp":"2023-10-01T12:00:02Z","message":"first"}}
+				]}}}`
+			}
+			return 200, `{"id":"FmRl","is_running":false,"is_partial":false,"response":{"took":3000,"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+				{"_id":"a","_source":{"@timestamp":"2023-10-01T12:00:02Z","message":"first"}},
+				{"_id":"b","_source":{"@timestamp":"2023-10-01T12:00:01Z","message":"second"}}
+			]}}}`
+		case req.Method == "DELETE" && req.Path == "/_async_search/FmRl":
+			cancelled = true
+			return 200, `{"acknowledged":true}`
+		}
+		t.Errorf("unexpected request %s %s", req.Method, req.Path)
+		return 400, `{}`
+	})
+	ctx := context.Background()
+
+	submitted, err := p.SubmitAsync(ctx, schema.LogQuery{Limit: 50})
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	if submitted.ID != "FmRl" || !submitted.Running || len(submitted.Entries) != 0 {
+		t.Errorf("submit = %+v, want a running search with no entries", submitted)
+	}
+	submit := transport.recorded()[0]
+	if submit.Query.Get("keep_on_completion") != "true" || submit.Query.Get("keep_alive") == "" || submit.Query.Get("wait_for_completion_timeout") == "" {
+		t.Errorf("submit params = %v, want keep_on_completion, keep_alive and wait_for_completion_timeout", submit.Query)
+	}
+	if !contains(submit.Body, `"size":50`) {
+		t.Errorf("submit body = %s, want the regular query DSL", submit.Body)
+	}
+
+	partial, err := p.PollAsync(ctx, submitted.ID)
+	if err != nil {
+		t.Fatalf("first poll failed: %v", err)
+	}
+	if !partial.Running || !partial.Partial || len(partial.Entries) != 1 || partial.Entries[0].Message != "first" {
+		t.Errorf("first poll = %+v, want one partial entry", partial)
+	}
+
+	done, err := p.PollAsync(ctx, submitted.ID)
+	if err != nil {
+		t.Fatalf("second poll failed: %v", err)
+	}
+	if done.Running || done.Partial || len(done.Entries) != 2 {
+		t.Fatalf("second poll = %+v, want two complete entries", done)
+	}
+	if done.Entries[1].Message != "second" || done.Entries[1].Timestamp.IsZero() {
+		t.Errorf("entries[1] = %+v, want normalized like a regular query", done.Entries[1])
+	}
+	if done.Stats.TotalHits != 2 || done.Stats.TookMillis != 3000 {
+		t.Errorf("stats = %+v, want totals from the final response", done.Stats)
+	}
+
+	if err := p.CancelAsync(ctx, submitted.ID); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if _, err := p.PollAsync(ctx, submitted.ID); !errors.Is(err, ErrAsyncSearchExpired) {
+		t.Errorf("poll after cancel err = %v, want ErrAsyncSearchExpired", err)
+	}
+}
+
+func TestCancelAsyncExpired(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 404, `{"error":{"type":"resource_not_found_exception","reason":"gone"},"status":404}`
+	})
+	if err := p.CancelAsync(context.Background(), "gone"); !errors.Is(err, ErrAsyncSearchExpired) {
+		t.Errorf("err = %v, want ErrAsyncSearchExpired", err)
+	}
+}
@@ -0,0 +1,401 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// auditStderr selects stderr as the audit log destination.
+const auditStderr = "stderr"
+
+const (
+	// defaultAuditMaxBytes is the size at which an audit log file is rotated.
+	defaultAuditMaxBytes = 100 << 20
+	// auditErrorBytes bounds how much of an error response is recorded.
+	auditErrorBytes = 512
+	// auditHitsBytes bounds how much of a response is kept to find its
+	// hits; the total comes before the hits themselves.
+	auditHitsBytes = 64 << 10
+)
+
+// auditRecord is one audit log line: a single request sent to
+// Elasticsearch on behalf of a provider method.
+type auditRecord struct {
+	Time           time.Time       `json:"time"`
+	Method         string          `json:"method,omitempty"`
+	Team           string          `json:"team,omitempty"`
+	Service        string          `json:"service,omitempty"`
+	Request        string          `json:"request"`
+	Index          string          `json:"index,omitempty"`
+	Query          json.RawMessage `json:"query,omitempty"`
+	DurationMillis int64           `json:"durationMs"`
+	Status         int             `json:"status,omitempty"`
+	Hits           *int64          `json:"hits,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	TraceID        string          `json:"traceId,omitempty"`
+}
+
+// auditScope identifies the provider method and caller a request is made
+// for.
+type auditScope struct {
+	method  string
+	team    string
+	service string
+	call    *meterCall
+}
+
+type auditKey struct{}
+
+// withAudit tags ctx with the provider method and scope its requests are
+// audited and metered under. A method called by another keeps the caller's tag, so
+// requests are recorded under the method that was invoked.
+func withAudit(ctx context.Context, method string, scope schema.QueryScope) context.Context {
+	if _, ok := ctx.Value(auditKey{}).(auditScope); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, auditKey{}, auditScope{method: method, team: scope.Team, service: scope.Service, call: &meterCall{}})
+}
+
+// auditLogger writes audit records as JSON lines to stderr or to a file
+// that is rotated to path.1 once it reaches maxBytes.
+type auditLogger struct {
+	methods map[string]bool
+	redact  fieldMatcher
+
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+// newAuditLogger returns the logger configured by cfg, or nil when audit
+// logging is off.
+func newAuditLogger(cfg Config) (*auditLogger, error) {
+	if cfg.AuditLog == "" {
+		return nil, nil
+	}
+	l := &auditLogger{
+		redact:   newFieldMatcher(cfg.RedactFields),
+		maxBytes: cfg.AuditMaxBytes,
+	}
+	if l.maxBytes <= 0 {
+		l.maxBytes = defaultAuditMaxBytes
+	}
+	if len(cfg.AuditMethods) > 0 {
+		l.methods = make(map[string]bool, len(cfg.AuditMethods))
+		for _, method := range cfg.AuditMethods {
+			l.methods[method] = true
+		}
+	}
+	if cfg.AuditLog != auditStderr {
+		l.path = cfg.AuditLog
+		if err := l.open(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *auditLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// enabled reports whether requests for method are audited.
+func (l *auditLogger) enabled(method string) bool {
+	return l.methods == nil || l.methods[method]
+}
+
+// write appends a record. Failures are reported to stderr rather than
+// failing the request that was audited.
+func (l *auditLogger) write(record auditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: elastic adapter failed to encode audit record: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		_, err = stderr.Write(line)
+	} else {
+		err = l.writeFile(line)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: elastic adapter failed to write audit record: %v\n", err)
+	}
+}
+
+func (l *auditLogger) writeFile(line []byte) error {
+	if l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// redactBody returns a request body with the values of fields matching the
+// redaction rules masked. NDJSON bodies (_msearch, _bulk) become an array of
+// their lines.
+func (l *auditLogger) redactBody(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return l.redactDocument(body)
+	}
+	var lines []json.RawMessage
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, l.redactDocument(line))
+		}
+	}
+	out, _ := json.Marshal(lines)
+	return out
+}
+
+func (l *auditLogger) redactDocument(doc []byte) json.RawMessage {
+	if !json.Valid(doc) {
+		return json.RawMessage(`null`)
+	}
+	return redactDSL(doc, l.redact)
+}
+
+// redactDSL masks the values of fields matching the redaction rules in a
+// JSON document or query body.
+func redactDSL(doc []byte, matcher fieldMatcher) json.RawMessage {
+	if matcher.empty() {
+		return doc
+	}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return json.RawMessage(`null`)
+	}
+	out, err := json.Marshal(maskKeys(value, matcher))
+	if err != nil {
+		return json.RawMessage(`null`)
+	}
+	return out
+}
+
+// maskKeys masks the value under any object key matching the redaction
+// rules, at any depth. Query DSL names fields as keys, as in
+// {"term":{"user.email":"..."}}, so this reaches filter values as well as
+// documents.
+func maskKeys(value any, matcher fieldMatcher) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, nested := range v {
+			if matcher.match(key) {
+				out[key] = redactedValue
+			} else {
+				out[key] = maskKeys(nested, matcher)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, nested := range v {
+			out[i] = maskKeys(nested, matcher)
+		}
+		return out
+	}
+	return value
+}
+
+// auditTransport records every request it carries to an auditLogger. The
+// record of a successful response is written once its body is closed.
+type auditTransport struct {
+	next http.RoundTripper
+	log  *auditLogger
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scope, _ := req.Context().Value(auditKey{}).(auditScope)
+	if !t.log.enabled(scope.method) {
+		return t.next.RoundTrip(req)
+	}
+
+	record := auditRecord{
+		Time:    time.Now().UTC(),
+		Method:  scope.method,
+		Team:    scope.team,
+		Service: scope.service,
+		Request: req.Method + " " + req.URL.Path,
+		TraceID: TraceID(req.Context()),
+	}
+	if index, _, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/"); index != "" && !strings.HasPrefix(index, "_") {
+		record.Index = index
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		record.Query = t.log.redactBody(body)
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		record.DurationMillis = time.Since(record.Time).Milliseconds()
+		record.Error = err.Error()
+		t.log.write(record)
+		return nil, err
+	}
+	record.Status = res.StatusCode
+	if res.StatusCode >= 400 {
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(data))
+		record.DurationMillis = time.Since(record.Time).Milliseconds()
+		if err != nil {
+			record.Error = err.Error()
+			t.log.write(record)
+			return nil, err
+		}
+		record.Error = truncateUTF8(string(bytes.TrimSpace(data)), auditErrorBytes)
+		t.log.write(record)
+		return res, nil
+	}
+	res.Body = &auditBody{ReadCloser: res.Body, log: t.log, record: record}
+	return res, nil
+}
+
+// auditBody keeps the start of a successful response body as the caller
+// reads it, and writes the request's record, with the hits found there,
+// once the body is closed.
+type auditBody struct {
+	io.ReadCloser
+	log    *auditLogger
+	record auditRecord
+	head   bytes.Buffer
+	err    error
+	read   bool
+	closed bool
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := auditHitsBytes - b.head.Len(); room > 0 {
+		b.head.Write(p[:min(n, room)])
+	}
+	switch {
+	case err == io.EOF && !b.read:
+		b.read = true
+		b.record.DurationMillis = time.Since(b.record.Time).Milliseconds()
+	case err != nil && err != io.EOF:
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *auditBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		if !b.read {
+			b.record.DurationMillis = time.Since(b.record.Time).Milliseconds()
+		}
+		if b.err != nil {
+			b.record.Error = b.err.Error()
+		} else {
+			b.record.Hits = responseHits(b.head.Bytes())
+		}
+		b.log.write(b.record)
+	}
+	return b.ReadCloser.Close()
+}
+
+// responseHits reads the match count from the start of a search or _count
+// response, stopping at the count or hits.total. It returns nil when data
+// ends before either.
+func responseHits(data []byte) *int64 {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		switch key {
+		case "count":
+			var count int64
+			if dec.Decode(&count) != nil {
+				return nil
+			}
+			return &count
+		case "hits":
+			return totalHits(dec)
+		}
+		var skip json.RawMessage
+		if dec.Decode(&skip) != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// totalHits reads hits.total from dec, positioned at the hits object.
+func totalHits(dec *json.Decoder) *int64 {
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if key == "total" {
+			var total struct {
+				Value int64 `json:"value"`
+			}
+			if dec.Decode(&total) != nil {
+				return nil
+			}
+			return &total.Value
+		}
+		var skip json.RawMessage
+		if dec.Decode(&skip) != nil {
+			return nil
+		}
+	}
+	return nil
+}
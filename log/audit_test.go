@@ -0,0 +1,196 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// newAuditedProvider builds a provider whose requests pass through an
+// auditTransport in front of a fakeTransport.
+func newAuditedProvider(t *testing.T, cfg Config, handler func(req recordedRequest) (int, string)) *ElasticProvider {
+	t.Helper()
+
+	cfg.IndexPattern = "logs-*"
+	audit, err := newAuditLogger(cfg)
+	if err != nil {
+		t.Fatalf("newAuditLogger failed: %v", err)
+	}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{"http://elastic.test:9200"},
+		Transport: &auditTransport{next: &fakeTransport{handler: handler}, log: audit},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return &ElasticProvider{cfg: cfg, client: client, redact: newFieldMatcher(cfg.RedactFields)}
+}
+
+func auditRecords(t *testing.T, data []byte) []auditRecord {
+	t.Helper()
+
+	var records []auditRecord
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record auditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("audit line %s is not JSON: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func captureStderr(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	stderr = &buf
+	t.Cleanup(func() { stderr = os.Stderr })
+	return &buf
+}
+
+func auditServer(req recordedRequest) (int, string) {
+	switch req.Path {
+	case "/logs-*/_count":
+		return 200, `{"count":7}`
+	case "/logs-*/_search":
+		return 200, `{"took":2,"hits":{"total":{"value":42,"relation":"eq"},"hits":[{"_id":"a","_source":{"message":"a"}}]}}`
+	}
+	return 400, `{"error":{"type":"illegal_argument_exception","reason":"bad request"},"status":400}`
+}
+
+func TestAuditRecordsRequests(t *testing.T) {
+	out := captureStderr(t)
+	p := newAuditedProvider(t, Config{AuditLog: auditStderr, RedactFields: []string{"user.*"}}, auditServer)
+
+	query := schema.LogQuery{
+		Scope: schema.QueryScope{Team: "payments", Service: "checkout"},
+		Expression: &schema.LogExpression{Filters: []schema.LogFilter{
+			{Field: "user.email", Operator: "=", Value: "jane@example.com"},
+		}},
+	}
+	if _, err := p.Query(context.Background(), query); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if _, err := p.Count(context.Background(), query); err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+
+	records := auditRecords(t, out.Bytes())
+	if len(records) != 2 {
+		t.Fatalf("records = %d, want one per request:\n%s", len(records), out)
+	}
+	search, count := records[0], records[1]
+	if search.Method != "log.query" || search.Team != "payments" || search.Service != "checkout" {
+		t.Errorf("search record = %+v, want the method and scope", search)
+	}
+	if search.Request != "POST /logs-*/_search" || search.Index != "logs-*" || search.Status != 200 {
+		t.Errorf("search record = %+v, want the request, index and status", search)
+	}
+	if search.Hits == nil || *search.Hits != 42 || search.Time.IsZero() {
+		t.Errorf("search record = %+v, want 42 hits and a time", search)
+	}
+	if strings.Contains(string(search.Query), "jane@example.com") || !strings.Contains(string(search.Query), `"user.email":"[REDACTED]"`) {
+		t.Errorf("search query = %s, want the filter value masked", search.Query)
+	}
+	if count.Method != "log.count" || count.Hits == nil || *count.Hits != 7 {
+		t.Errorf("count record = %+v, want log.count with 7 hits", count)
+	}
+}
+
+func TestAuditRecordsErrors(t *testing.T) {
+	out := captureStderr(t)
+	p := newAuditedProvider(t, Config{AuditLog: auditStderr}, auditServer)
+
+	if _, err := p.ListIndices(context.Background(), "missing-*"); err == nil {
+		t.Fatal("ListIndices succeeded, want an error")
+	}
+	records := auditRecords(t, out.Bytes())
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1:\n%s", len(records), out)
+	}
+	if records[0].Method != "log.indices" || records[0].Status != 400 || !strings.Contains(records[0].Error, "illegal_argument_exception") {
+		t.Errorf("record = %+v, want the error response", records[0])
+	}
+}
+
+func TestResponseHits(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int64
+		ok   bool
+	}{
+		{"search", `{"took":2,"_shards":{"failures":[{"reason":{"type":"x"}}]},"hits":{"total":{"value":42},"hits":[`, 42, true},
+		{"count", `{"count":7,"_shards":{"total":1}}`, 7, true},
+		{"total after hits", `{"hits":{"hits":[{"_id":"a"}],"total":{"value":3}}}`, 3, true},
+		{"cut before the total", `{"took":2,"_shards":{"total":1,"fail`, 0, false},
+		{"no total", `{"acknowledged":true}`, 0, false},
+		{"not json", `not json`, 0, false},
+	}
+	for _, tt := range tests {
+		got := responseHits([]byte(tt.data))
+		if (got != nil) != tt.ok || (got != nil && *got != tt.want) {
+			t.Errorf("%s: hits = %v, want %d (found %v)", tt.name, got, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAuditMethods(t *testing.T) {
+	out := captureStderr(t)
+	p := newAuditedProvider(t, Config{AuditLog: auditStderr, AuditMethods: []string{"log.count"}}, auditServer)
+
+	if _, err := p.Query(context.Background(), schema.LogQuery{}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if _, err := p.Count(context.Background(), schema.LogQuery{}); err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	records := auditRecords(t, out.Bytes())
+	if len(records) != 1 || records[0].Method != "log.count" {
+		t.Errorf("records = %+v, want only log.count", records)
+	}
+}
+
+func TestAuditFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	p := newAuditedProvider(t, Config{AuditLog: path, AuditMaxBytes: 450}, auditServer)
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Count(context.Background(), schema.LogQuery{}); err != nil {
+			t.Fatalf("count failed: %v", err)
+		}
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	rotated, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("failed to read rotated audit log: %v", err)
+	}
+	if len(current) > 450 || len(rotated) > 450 {
+		t.Errorf("log sizes = %d and %d, want both within 450 bytes", len(current), len(rotated))
+	}
+	if got := len(auditRecords(t, current)) + len(auditRecords(t, rotated)); got != 3 {
+		t.Errorf("records = %d across both files, want 3", got)
+	}
+}
+
+func TestAuditRedactsNDJSON(t *testing.T) {
+	l := &auditLogger{redact: newFieldMatcher([]string{"token"})}
+	got := string(l.redactBody([]byte("{\"index\":\"logs-*\"}\n{\"query\":{\"term\":{\"token\":\"s3cret\"}}}\n")))
+	want := `[{"index":"logs-*"},{"query":{"term":{"token":"[REDACTED]"}}}]`
+	if got != want {
+		t.Errorf("redactBody = %s, want %s", got, want)
+	}
+}
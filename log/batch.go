@@ -0,0 +1,104 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// defaultMaxBatchSize caps the queries in one QueryBatch when no
+// maxBatchSize is configured.
+const defaultMaxBatchSize = 10
+
+// QueryBatch runs several queries in a single _msearch round trip, such as
+// the panels of a dashboard. Entries and errors are returned positionally:
+// a query that fails, or is rejected before sending, has a nil entry list
+// and its own error while the others still succeed. If the batch as a whole
+// cannot run, every query reports that error.
+//
+// Each query returns one page of at most pageSize entries. When more
+// results exist, the last entry carries a cursor under MetadataNextCursor,
+// which QueryWithStats can resume from. Point-in-time cursors cannot be
+// used in a batch.
+func (p *ElasticProvider) QueryBatch(ctx context.Context, queries []schema.LogQuery) ([][]schema.LogEntry, []error) {
+	ctx = withAudit(ctx, "log.queryBatch", schema.QueryScope{})
+	results := make([][]schema.LogEntry, len(queries))
+	errs := make([]error, len(queries))
+	if len(queries) == 0 {
+		return results, errs
+	}
+	if maxSize := p.maxBatchSize(); len(queries) > maxSize {
+		return results, fill(errs, fmt.Errorf("batch has %d queries, exceeding the maximum of %d", len(queries), maxSize))
+	}
+
+	// pages holds each query as sent; sent maps the searches back to them
+	pages := make([]schema.LogQuery, len(queries))
+	searches := make([]map[string]any, 0, len(queries))
+	sent := make([]int, 0, len(queries))
+	for i, query := range queries {
+		if err := p.validateQuery(query); err != nil {
+			errs[i] = err
+			continue
+		}
+		if cursor, _ := p.queryCursor(query); cursor != nil && cursor.PIT != "" {
+			errs[i] = errors.New("point-in-time cursors cannot be used in a batch")
+			continue
+		}
+		pages[i] = query
+		pages[i].Limit = min(p.querySize(query), p.pageSize())
+		searches = append(searches, p.buildQuery(pages[i]))
+		sent = append(sent, i)
+	}
+	if len(searches) == 0 {
+		return results, errs
+	}
+
+	items, err := p.msearch(ctx, searches)
+	if err != nil {
+		for _, i := range sent {
+			errs[i] = err
+		}
+		return results, errs
+	}
+
+	for n, item := range items {
+		i := sent[n]
+		if errs[i] = item.err(); errs[i] != nil {
+			continue
+		}
+		entries := p.appendResult(ctx, nil, item.esSearchResponse)
+		if p.cfg.DedupeResults {
+			entries = dedupeConsecutive(entries)
+		}
+
+		hits := item.Hits.Hits
+		if len(hits) > 0 && len(hits) >= pages[i].Limit && len(hits[len(hits)-1].Sort) > 0 && len(entries) > 0 {
+			cursor, err := p.encodeCursor(pages[i], pageCursor{After: hits[len(hits)-1].Sort})
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to encode cursor: %w", err)
+				continue
+			}
+			entries[len(entries)-1].Metadata[MetadataNextCursor] = cursor
+		}
+		results[i] = entries
+	}
+	return results, errs
+}
+
+// maxBatchSize returns the most queries one QueryBatch accepts.
+func (p *ElasticProvider) maxBatchSize() int {
+	if p.cfg.MaxBatchSize > 0 {
+		return p.cfg.MaxBatchSize
+	}
+	return defaultMaxBatchSize
+}
+
+// fill sets every element of errs to err.
+func fill(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestQueryBatch(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 2}, func(req recordedRequest) (int, string) {
+		return 200, `{"took":5,"responses":[
+			{"took":2,"hits":{"total":{"value":3,"relation":"eq"},"hits":[
+				{"_index":"logs-a","_id":"1","_source":{"@timestamp":"2023-10-01T12:00:02Z","message":"first"},"sort":[2000,1]},
+				{"_index":"logs-a","_id":"2","_source":{"@timestamp":"2023-10-01T12:00:01Z","message":"second"},"sort":[1000,2]}
+			]},"status":200},
+			{"error":{"root_cause":[],"type":"query_shard_exception","reason":"failed to create query"},"status":400},
+			{"took":1,"hits":{"total":{"value":1,"relation":"eq"},"hits":[
+				{"_index":"logs-b","_id":"3","_source":{"@timestamp":"2023-10-01T12:00:00Z","message":"third"},"sort":[0,3]}
+			]},"status":200}
+		]}`
+	})
+
+	queries := []schema.LogQuery{
+		{Limit: 5},
+		{Expression: &schema.LogExpression{Search: "broken query"}},
+		{Metadata: map[string]any{QueryOptionOrder: "sideways"}},
+		{Limit: 1, Metadata: map[string]any{QueryOptionOrder: "asc"}},
+	}
+	results, errs := p.QueryBatch(context.Background(), queries)
+	if len(results) != 4 || len(errs) != 4 {
+		t.Fatalf("got %d results and %d errors, want 4 of each", len(results), len(errs))
+	}
+
+	if errs[0] != nil || messages(results[0]) != "first,second" {
+		t.Errorf("item 0 = %v, %v; want the first page", messages(results[0]), errs[0])
+	}
+	if cursor, _ := results[0][1].Metadata[MetadataNextCursor].(string); cursor == "" {
+		t.Error("item 0 has no next cursor, want one for the full page")
+	}
+	if results[1] != nil || errs[1] == nil || !strings.Contains(errs[1].Error(), "failed to create query") {
+		t.Errorf("item 1 = %v, %v; want only its search error", results[1], errs[1])
+	}
+	if results[2] != nil || errs[2] == nil || !strings.Contains(errs[2].Error(), "sideways") {
+		t.Errorf("item 2 = %v, %v; want the validation error", results[2], errs[2])
+	}
+	if errs[3] != nil || messages(results[3]) != "third" {
+		t.Errorf("item 3 = %v, %v; want the third entry", messages(results[3]), errs[3])
+	}
+
+	requests := transport.recorded()
+	if len(requests) != 1 || requests[0].Method != "POST" || requests[0].Path != "/_msearch" {
+		t.Fatalf("requests = %s, want one msearch", requestLine(requests))
+	}
+	body := requests[0].Body
+	if !strings.HasSuffix(body, "\n") {
+		t.Errorf("msearch body = %q, want a trailing newline", body)
+	}
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("msearch body has %d lines, want a header and body for each of 3 valid queries", len(lines))
+	}
+	wantSizes := []float64{2, 2, 1}
+	for n := 0; n < 3; n++ {
+		if lines[2*n] != `{"index":"logs-*"}` {
+			t.Errorf("header %d = %s, want the index pattern", n, lines[2*n])
+		}
+		var search map[string]any
+		if err := json.Unmarshal([]byte(lines[2*n+1]), &search); err != nil {
+			t.Fatalf("search %d is not one line of JSON: %v", n, err)
+		}
+		if search["size"] != wantSizes[n] {
+			t.Errorf("search %d size = %v, want %v", n, search["size"], wantSizes[n])
+		}
+	}
+	if !strings.Contains(lines[5], `"order":"asc"`) {
+		t.Errorf("search 2 = %s, want ascending order", lines[5])
+	}
+}
+
+func TestQueryBatchLimits(t *testing.T) {
+	p, transport := newTestProvider(t, Config{MaxBatchSize: 2}, func(req recordedRequest) (int, string) {
+		return 500, `{"error":{"type":"illegal_state_exception","reason":"node closing"},"status":500}`
+	})
+
+	_, errs := p.QueryBatch(context.Background(), make([]schema.LogQuery, 3))
+	for i, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "maximum of 2") {
+			t.Errorf("item %d error = %v, want the batch size error", i, err)
+		}
+	}
+	if len(transport.recorded()) != 0 {
+		t.Errorf("requests = %s, want none for an oversized batch", requestLine(transport.recorded()))
+	}
+
+	_, errs = p.QueryBatch(context.Background(), make([]schema.LogQuery, 2))
+	for i, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "node closing") {
+			t.Errorf("item %d error = %v, want the msearch error", i, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// The hot path's benchmarks read their input from testdata, so results
+// compare across changes, e.g. with benchstat:
+//
+//	go test ./log -run '^$' -bench 'BuildQuery|NormalizeHits|QueryEndToEnd' -count 10 > old.txt
+//
+// testdata/search_1k.json is a search response of 1,000 hits, and
+// testdata/complex_query.json a query with ten filters, metadata and a
+// scope.
+
+// maxAllocsPerHit bounds the allocations normalizing one hit of
+// testdata/search_1k.json takes with the default config. Raise it only
+// for a change that needs the allocations.
+const maxAllocsPerHit = 28
+
+// maxAllocsPerRedactedHit is maxAllocsPerHit with redactFields set, which
+// copies the source before it is read.
+const maxAllocsPerRedactedHit = 42
+
+func readFixture(tb testing.TB, name string) []byte {
+	tb.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		tb.Fatalf("failed to read fixture: %v", err)
+	}
+	return data
+}
+
+// complexQuery is a query with a window, a search, severities, filters,
+// scope and metadata terms, as a dashboard panel sends.
+func complexQuery(tb testing.TB) schema.LogQuery {
+	tb.Helper()
+	var query schema.LogQuery
+	if err := json.Unmarshal(readFixture(tb, "complex_query.json"), &query); err != nil {
+		tb.Fatalf("failed to decode query fixture: %v", err)
+	}
+	return query
+}
+
+func fixtureHits(tb testing.TB) []esHit {
+	tb.Helper()
+	result, err := decodeSearchResponse(bytes.NewReader(readFixture(tb, "search_1k.json")))
+	if err != nil {
+		tb.Fatalf("failed to decode response fixture: %v", err)
+	}
+	return result.Hits.Hits
+}
+
+func BenchmarkBuildQuery(b *testing.B) {
+	p := &ElasticProvider{cfg: parseConfig(map[string]any{})}
+	query := complexQuery(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = p.buildQuery(query)
+	}
+}
+
+func BenchmarkNormalizeHits(b *testing.B) {
+	p := &ElasticProvider{cfg: parseConfig(map[string]any{})}
+	hits := fixtureHits(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, hit := range hits {
+			_ = normalizeHit(p, hit)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(hits)), "ns/hit")
+}
+
+// BenchmarkQueryEndToEnd runs the complex query against a local server
+// answering with the 1,000 hits, decoding included.
+func BenchmarkQueryEndToEnd(b *testing.B) {
+	body := readFixture(b, "search_1k.json")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/_search") {
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+	p, err := NewFromConfig(map[string]any{"addresses": []any{server.URL}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+	query := complexQuery(b)
+	query.Limit = 1000
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := p.Query(context.Background(), query)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(result.Entries) != 1000 {
+			b.Fatalf("entries = %d, want 1000", len(result.Entries))
+		}
+	}
+}
+
+func TestNormalizeHitAllocations(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]any
+		max  int
+	}{
+		{name: "default", cfg: map[string]any{}, max: maxAllocsPerHit},
+		{name: "redactFields", cfg: map[string]any{"redactFields": []any{"host.*", "trace.id"}}, max: maxAllocsPerRedactedHit},
+	}
+	hits := fixtureHits(t)
+	for _, tt := range tests {
+		cfg := parseConfig(tt.cfg)
+		p := &ElasticProvider{cfg: cfg, redact: newFieldMatcher(cfg.RedactFields)}
+		allocs := testing.AllocsPerRun(10, func() {
+			for _, hit := range hits {
+				_ = normalizeHit(p, hit)
+			}
+		})
+		if perHit := allocs / float64(len(hits)); perHit > float64(tt.max) {
+			t.Errorf("%s: allocations per hit = %.1f, want at most %d", tt.name, perHit, tt.max)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+)
+
+// maxPooledBody is the largest buffer kept for reuse, so that one huge
+// request does not pin its memory for the life of the process.
+const maxPooledBody = 1 << 20
+
+// bodyBuffers holds the buffers search bodies are encoded into.
+var bodyBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// encodeBody encodes a request body into a pooled buffer. The buffer goes
+// back with releaseBody once the request is known to have been sent.
+func encodeBody(v any) (*bytes.Buffer, error) {
+	buf := bodyBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		releaseBody(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// releaseBody returns a buffer from encodeBody for reuse. A request is only
+// sure to have been sent in full once it got a successful response: the
+// transport may still be writing the body of a request answered early with
+// an error, whose buffer is then left to the garbage collector instead.
+func releaseBody(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBody {
+		bodyBuffers.Put(buf)
+	}
+}
+
+// Sort clauses for the default tiebreaker, shared by every query sorted by
+// it rather than built for each. They must not be modified.
+var defaultSortClauses = map[string][]map[string]any{
+	orderAsc: {
+		{"@timestamp": map[string]any{"order": orderAsc}},
+		{defaultTiebreakerField: map[string]any{"order": orderAsc}},
+	},
+	orderDesc: {
+		{"@timestamp": map[string]any{"order": orderDesc}},
+		{defaultTiebreakerField: map[string]any{"order": orderDesc}},
+	},
+}
+
+// logSearchBody logs the body of a search at debug level, pretty-printed
+// and redacted, when the logger is enabled for it.
+func (p *ElasticProvider) logSearchBody(ctx context.Context, body []byte) {
+	l := p.logger.Load()
+	if l == nil || !l.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	var dsl bytes.Buffer
+	if err := json.Indent(&dsl, redactDSL(body, p.redact), "", "  "); err != nil {
+		return
+	}
+	l.Debug("elasticsearch search", "index", p.cfg.IndexPattern, "dsl", dsl.String(), "traceId", TraceID(ctx))
+}
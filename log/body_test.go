@@ -0,0 +1,121 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestEncodeBodyMatchesMarshal(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{IndexPattern: "logs-*"}}
+	for _, query := range []schema.LogQuery{{}, complexQuery(t)} {
+		esQuery := p.buildQuery(query)
+		want, err := json.Marshal(esQuery)
+		if err != nil {
+			t.Fatalf("failed to marshal query: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			buf, err := encodeBody(esQuery)
+			if err != nil {
+				t.Fatalf("failed to encode query: %v", err)
+			}
+			if got := bytes.TrimSuffix(buf.Bytes(), []byte("\n")); !bytes.Equal(got, want) {
+				t.Fatalf("body = %s, want %s", got, want)
+			}
+			releaseBody(buf)
+		}
+	}
+}
+
+func TestSortClauseShared(t *testing.T) {
+	p := &ElasticProvider{}
+	asc := schema.LogQuery{Metadata: map[string]any{QueryOptionOrder: "asc"}}
+	if a, b := p.sortClause(schema.LogQuery{}), p.sortClause(schema.LogQuery{}); &a[0] != &b[0] {
+		t.Error("default sort clause built again, want it shared")
+	}
+	if sort := p.sortClause(asc); sort[0]["@timestamp"].(map[string]any)["order"] != orderAsc {
+		t.Errorf("sort = %v, want ascending", sort)
+	}
+	p.cfg.TiebreakerField = "event.sequence"
+	if sort := p.sortClause(asc); sort[1]["event.sequence"] == nil {
+		t.Errorf("sort = %v, want the configured tiebreaker", sort)
+	}
+}
+
+func TestLogSearchBody(t *testing.T) {
+	p, _ := newTestProvider(t, Config{RedactFields: []string{"user.id"}}, func(req recordedRequest) (int, string) {
+		return 200, `{"hits":{"hits":[]}}`
+	})
+	var out bytes.Buffer
+	p.SetLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	query := schema.LogQuery{Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "user.id", Operator: "=", Value: "alice"}}}}
+	if _, err := p.Query(context.Background(), query); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var record struct {
+		Msg string `json:"msg"`
+		DSL string `json:"dsl"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("failed to decode log %q: %v", out.String(), err)
+	}
+	if record.Msg != "elasticsearch search" || !strings.Contains(record.DSL, "\n  \"query\": {") {
+		t.Errorf("log = %+v, want the pretty-printed DSL", record)
+	}
+	if strings.Contains(record.DSL, "alice") {
+		t.Errorf("dsl = %s, want the redacted field masked", record.DSL)
+	}
+
+	// Nothing is logged above debug level
+	out.Reset()
+	p.SetLogger(slog.New(slog.NewJSONHandler(&out, nil)))
+	if _, err := p.Query(context.Background(), query); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("log = %s, want nothing at info level", out.String())
+	}
+}
+
+// BenchmarkSearchBody compares building and marshaling representative
+// search bodies with json.Marshal against encoding them into pooled
+// buffers.
+func BenchmarkSearchBody(b *testing.B) {
+	p := &ElasticProvider{cfg: Config{IndexPattern: "logs-*"}}
+	queries := []struct {
+		name  string
+		query schema.LogQuery
+	}{
+		{name: "simple", query: schema.LogQuery{Limit: 100}},
+		{name: "complex", query: complexQuery(b)},
+	}
+	for _, q := range queries {
+		b.Run(q.name+"/marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				body, err := json.Marshal(p.buildQuery(q.query))
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = bytes.NewReader(body)
+			}
+		})
+		b.Run(q.name+"/pooled", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf, err := encodeBody(p.buildQuery(q.query))
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = bytes.NewReader(buf.Bytes())
+				releaseBody(buf)
+			}
+		})
+	}
+}
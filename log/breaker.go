@@ -0,0 +1,165 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Circuit breaker defaults, used when the config leaves them unset.
+const (
+	defaultCircuitBreakerThreshold = 5
+	defaultCircuitBreakerCooldown  = 30 * time.Second
+)
+
+// Circuit states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is in CircuitOpenError, so callers can match it with
+// errors.Is.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError refuses a request without sending it, because the last
+// requests failed to reach the cluster. It is in ErrConnection. RetryAfter
+// is the time until the next request is let through to probe the cluster,
+// zero while a probe runs.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.RetryAfter == 0 {
+		return fmt.Sprintf("%s: elasticsearch unreachable, a request is probing it", ErrCircuitOpen)
+	}
+	return fmt.Sprintf("%s: elasticsearch unreachable, next attempt in %s", ErrCircuitOpen, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen || target == ErrConnection
+}
+
+// CircuitStats is the state of the circuit breaker.
+type CircuitStats struct {
+	State string `json:"state"`
+	// ConsecutiveFailures counts the failures since the last success.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// Opened counts the times the circuit opened.
+	Opened int64 `json:"opened"`
+	// RetryAfterMs is the time until the next probe while the circuit is
+	// open.
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+}
+
+// circuitBreaker stops sending requests once threshold requests in a row
+// failed to reach the cluster. The circuit then stays open for cooldown,
+// failing requests at once, after which one request is let through as a
+// probe: the circuit closes if it reaches the cluster and opens again if
+// not. Other requests fail while the probe runs.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	opened   int64
+	// probeAt is when the open circuit lets a probe through.
+	probeAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now, state: CircuitClosed}
+}
+
+// allow reports whether a request may be sent, and returns the error to
+// fail it with otherwise. The caller reports the outcome of an allowed
+// request with done.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if wait := b.probeAt.Sub(b.now()); wait > 0 {
+			return &CircuitOpenError{RetryAfter: wait}
+		}
+		b.state = CircuitHalfOpen
+		return nil
+	case CircuitHalfOpen:
+		return &CircuitOpenError{}
+	}
+	return nil
+}
+
+// done records the outcome of an allowed request: reached tells whether it
+// reached the cluster, and unknown whether it ended before that was known,
+// as when it was cancelled or timed out.
+func (b *circuitBreaker) done(reached, unknown bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case reached:
+		b.state, b.failures = CircuitClosed, 0
+	case unknown:
+		// A probe that tells nothing lets the next request probe
+		if b.state == CircuitHalfOpen {
+			b.state = CircuitOpen
+		}
+	default:
+		b.failures++
+		if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+			if b.state != CircuitOpen {
+				b.opened++
+			}
+			b.state, b.probeAt = CircuitOpen, b.now().Add(b.cooldown)
+		}
+	}
+}
+
+func (b *circuitBreaker) stats() CircuitStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := CircuitStats{State: b.state, ConsecutiveFailures: b.failures, Opened: b.opened}
+	if b.state == CircuitOpen {
+		stats.RetryAfterMs = max(b.probeAt.Sub(b.now()), 0).Milliseconds()
+	}
+	return stats
+}
+
+// breakerTransport sends requests through the circuit breaker. A request
+// fails to reach the cluster when it fails in ErrConnection, or gets a
+// response in it.
+type breakerTransport struct {
+	next    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	res, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil:
+		t.breaker.done(false, !errors.Is(err, ErrConnection))
+	default:
+		status := &ResponseError{StatusCode: res.StatusCode}
+		t.breaker.done(!errors.Is(status, ErrConnection), false)
+	}
+	return res, err
+}
+
+// CircuitState returns the state of the circuit breaker, which is shared by
+// every method of the provider.
+func (p *ElasticProvider) CircuitState() (CircuitStats, error) {
+	if p.breaker == nil {
+		return CircuitStats{}, unsupported("the circuit breaker is disabled; set circuitBreakerThreshold to enable it")
+	}
+	return p.breaker.stats(), nil
+}
@@ -0,0 +1,165 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// newTestBreaker returns a breaker whose clock moves only when the returned
+// func advances it.
+func newTestBreaker(threshold int, cooldown time.Duration) (*circuitBreaker, func(time.Duration)) {
+	b := newCircuitBreaker(threshold, cooldown)
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b, advance := newTestBreaker(3, 10*time.Second)
+
+	// Failures below the threshold, or broken by a success, keep it closed
+	b.done(false, false)
+	b.done(false, false)
+	b.done(true, false)
+	b.done(false, false)
+	b.done(false, false)
+	if stats := b.stats(); stats.State != CircuitClosed || stats.ConsecutiveFailures != 2 {
+		t.Fatalf("stats = %+v, want closed after 2 failures", stats)
+	}
+
+	b.done(false, false)
+	var open *CircuitOpenError
+	if err := b.allow(); !errors.As(err, &open) || open.RetryAfter != 10*time.Second {
+		t.Fatalf("err = %v, want the circuit open for 10s", err)
+	}
+	advance(4 * time.Second)
+	if stats := b.stats(); stats.State != CircuitOpen || stats.Opened != 1 || stats.RetryAfterMs != 6000 {
+		t.Errorf("stats = %+v, want open with 6s to the probe", stats)
+	}
+
+	// Once the cooldown passes one probe goes through; a failed probe
+	// opens the circuit again
+	advance(6 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("err = %v, want a probe let through", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) || b.stats().State != CircuitHalfOpen {
+		t.Errorf("err = %v, want other requests refused while the probe runs", err)
+	}
+	b.done(false, false)
+	if stats := b.stats(); stats.State != CircuitOpen || stats.Opened != 2 || stats.RetryAfterMs != 10000 {
+		t.Errorf("stats = %+v, want open again after the failed probe", stats)
+	}
+
+	// A successful probe closes it
+	advance(10 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("err = %v, want a probe let through", err)
+	}
+	b.done(true, false)
+	if stats := b.stats(); stats.State != CircuitClosed || stats.ConsecutiveFailures != 0 {
+		t.Errorf("stats = %+v, want closed after the probe succeeded", stats)
+	}
+	if err := b.allow(); err != nil {
+		t.Errorf("err = %v, want requests sent again", err)
+	}
+}
+
+func TestCircuitBreakerUnknownProbe(t *testing.T) {
+	b, advance := newTestBreaker(1, time.Second)
+	b.done(false, false)
+	advance(time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("err = %v, want a probe let through", err)
+	}
+	// A cancelled probe tells nothing, so the next request probes at once
+	b.done(false, true)
+	if err := b.allow(); err != nil || b.stats().Opened != 1 {
+		t.Errorf("err = %v, stats = %+v; want the next request to probe", err, b.stats())
+	}
+}
+
+// countingTransport answers every request with status, counting them.
+type countingTransport struct {
+	status int
+	calls  atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls.Add(1)
+	return &http.Response{StatusCode: t.status, Header: http.Header{"X-Elastic-Product": {"Elasticsearch"}}, Body: http.NoBody}, nil
+}
+
+func TestCircuitBreakerConcurrentCallers(t *testing.T) {
+	b, _ := newTestBreaker(1, time.Minute)
+	next := &countingTransport{status: 503}
+	transport := &breakerTransport{next: next, breaker: b}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://elastic.test:9200/_search", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatalf("err = %v, want the 503 returned", err)
+	}
+
+	var wg sync.WaitGroup
+	var refused atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := transport.RoundTrip(req); errors.Is(err, ErrCircuitOpen) && errors.Is(err, ErrConnection) {
+				refused.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if refused.Load() != 50 || next.calls.Load() != 1 {
+		t.Errorf("refused = %d, sent = %d; want every caller refused without a request", refused.Load(), next.calls.Load())
+	}
+}
+
+func TestCircuitOpenFailsFast(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, nil)
+	next := &countingTransport{status: 503}
+	p.breaker = newCircuitBreaker(2, time.Minute)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{"http://elastic.test:9200"},
+		Transport:    &breakerTransport{next: &classifyTransport{next: next}, breaker: p.breaker},
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	p.client = client
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Count(context.Background(), schema.LogQuery{}); !errors.Is(err, ErrConnection) || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("err = %v, want the 503", err)
+		}
+	}
+	_, err = p.Count(context.Background(), schema.LogQuery{})
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || open.RetryAfter <= 0 {
+		t.Fatalf("err = %v, want the circuit open", err)
+	}
+	if next.calls.Load() != 2 {
+		t.Errorf("sent = %d, want no request while the circuit is open", next.calls.Load())
+	}
+	if stats, err := p.CircuitState(); err != nil || stats.State != CircuitOpen || stats.Opened != 1 {
+		t.Errorf("stats = %+v, err = %v; want the circuit open", stats, err)
+	}
+}
+
+func TestCircuitStateDisabled(t *testing.T) {
+	p := &ElasticProvider{}
+	if _, err := p.CircuitState(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want the breaker disabled", err)
+	}
+}
@@ -0,0 +1,95 @@
+package log
+
+import "sort"
+
+// Capabilities describes the optional features this adapter supports, so
+// callers can decide which to offer before using them.
+type Capabilities struct {
+	// Methods lists the plugin RPC methods, each backed by an ElasticProvider
+	// method of the same purpose.
+	Methods []string `json:"methods"`
+	// Operators lists the filter operators accepted in LogExpression.Filters.
+	Operators []string `json:"operators"`
+	// MaxLimit is the most entries one query returns.
+	MaxLimit int `json:"maxLimit"`
+	// Pagination lists the ways results can be paged.
+	Pagination []string `json:"pagination"`
+}
+
+// Methods are the RPC methods the plugin serves.
+var Methods = []string{
+	"handshake",
+	"cancel",
+	"configure",
+	"capabilities",
+	"health",
+	"selftest",
+	"stats",
+	"log.query",
+	"log.queryStats",
+	"log.slowQueries",
+	"log.queryBatch",
+	"log.count",
+	"log.histogram",
+	"log.fieldValues",
+	"log.significantTerms",
+	"log.aggregate",
+	"log.fields",
+	"log.indices",
+	"log.context",
+	"log.byTrace",
+	"log.patterns",
+	"log.summarize",
+	"log.compare",
+	"log.esql",
+	"log.sql",
+	"log.sqlNext",
+	"log.write",
+	"log.savedQuery.save",
+	"log.savedQuery.get",
+	"log.savedQuery.list",
+	"log.savedQuery.delete",
+	"log.savedQuery.match",
+	"log.watch.create",
+	"log.watch.list",
+	"log.watch.delete",
+	"log.export",
+	"log.stream",
+	"log.tail",
+	"log.tailCancel",
+	"log.querySubmit",
+	"log.queryPoll",
+	"log.queryCancel",
+}
+
+// paginationOffset names _offset (from/size) pagination in Capabilities.
+const paginationOffset = "offset"
+
+// Capabilities reports the features supported with the current config.
+func (p *ElasticProvider) Capabilities() Capabilities {
+	operators := make([]string, 0, len(filterOperators))
+	for op := range filterOperators {
+		// Script filters are only accepted once enabled
+		if op == "script" && !p.cfg.AllowScriptFilters {
+			continue
+		}
+		operators = append(operators, op)
+	}
+	sort.Strings(operators)
+
+	methods := make([]string, 0, len(Methods))
+	for _, method := range Methods {
+		// ES|QL is only served once enabled
+		if method == "log.esql" && !p.cfg.AllowESQL {
+			continue
+		}
+		methods = append(methods, method)
+	}
+
+	return Capabilities{
+		Methods:    methods,
+		Operators:  operators,
+		MaxLimit:   min(p.pageSize()*p.maxPages(), p.maxEntriesInMemory()),
+		Pagination: []string{paginationOffset, paginationSearchAfter, paginationPIT, paginationScroll},
+	}
+}
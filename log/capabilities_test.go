@@ -0,0 +1,75 @@
+package log
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// filterValues holds a valid value for each operator; operators not listed
+// take a plain string.
+var filterValues = map[string]string{
+	"geo_distance": "52.52,13.40,10km",
+	"script":       `{"source": "doc['bytes'].value > 1000"}`,
+}
+
+func TestCapabilitiesOperatorsMatchFilterBuilder(t *testing.T) {
+	// Candidates cover the supported operators and common ones that are not
+	stderr = io.Discard
+	defer func() { stderr = os.Stderr }()
+
+	candidates := []string{"=", "!=", "contains", "regex", "geo_distance", "script", ">", "<", "in", "exists", "startsWith", ""}
+
+	for _, allowScripts := range []bool{false, true} {
+		p := &ElasticProvider{cfg: Config{AllowScriptFilters: allowScripts}}
+		advertised := map[string]bool{}
+		for _, op := range p.Capabilities().Operators {
+			advertised[op] = true
+		}
+
+		for _, op := range candidates {
+			value, ok := filterValues[op]
+			if !ok {
+				value = "checkout"
+			}
+			_, err := p.buildFilterClause(schema.LogFilter{Field: "service", Operator: op, Value: value})
+			accepted := err == nil
+			if accepted != advertised[op] {
+				t.Errorf("allowScriptFilters=%v: operator %q accepted = %v, advertised = %v", allowScripts, op, accepted, advertised[op])
+			}
+		}
+		for op := range filterOperators {
+			if _, ok := filterValues[op]; !ok && op != "=" && op != "!=" && op != "contains" && op != "regex" {
+				t.Errorf("operator %q has no test value; add it to filterValues", op)
+			}
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	caps := (&ElasticProvider{cfg: Config{PageSize: 500, MaxPages: 4}}).Capabilities()
+
+	if got := strings.Join(caps.Operators, ","); got != "!=,=,contains,geo_distance,regex" {
+		t.Errorf("operators = %s, want the sorted operators without script", got)
+	}
+	if caps.MaxLimit != 2000 {
+		t.Errorf("max limit = %d, want pageSize * maxPages", caps.MaxLimit)
+	}
+	if caps := (&ElasticProvider{cfg: Config{MaxEntriesInMemory: 1500}}).Capabilities(); caps.MaxLimit != 1500 {
+		t.Errorf("max limit = %d, want maxEntriesInMemory below pageSize * maxPages", caps.MaxLimit)
+	}
+	if got := strings.Join(caps.Pagination, ","); got != "offset,search_after,pit,scroll" {
+		t.Errorf("pagination = %s", got)
+	}
+	if got := strings.Join(caps.Methods, ","); strings.Contains(got, "log.esql") {
+		t.Errorf("methods = %s, want log.esql hidden while disabled", got)
+	}
+
+	caps = (&ElasticProvider{cfg: Config{AllowESQL: true}}).Capabilities()
+	if strings.Join(caps.Methods, ",") != strings.Join(Methods, ",") {
+		t.Errorf("methods = %v, want %v", caps.Methods, Methods)
+	}
+}
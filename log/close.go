@@ -0,0 +1,95 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// searchContexts are the points in time and scrolls the provider holds
+// open, so Close can release them.
+type searchContexts struct {
+	pits    map[string]bool
+	scrolls map[string]bool
+}
+
+// holdPIT records a point in time as open.
+func (p *ElasticProvider) holdPIT(id string) {
+	p.openMu.Lock()
+	defer p.openMu.Unlock()
+	if p.open.pits == nil {
+		p.open.pits = make(map[string]bool)
+	}
+	p.open.pits[id] = true
+}
+
+// releasedPIT records a point in time as closed.
+func (p *ElasticProvider) releasedPIT(id string) {
+	p.openMu.Lock()
+	defer p.openMu.Unlock()
+	delete(p.open.pits, id)
+}
+
+// holdScroll records a scroll as open, in place of the one it continues.
+func (p *ElasticProvider) holdScroll(previous, id string) {
+	p.openMu.Lock()
+	defer p.openMu.Unlock()
+	if p.open.scrolls == nil {
+		p.open.scrolls = make(map[string]bool)
+	}
+	delete(p.open.scrolls, previous)
+	p.open.scrolls[id] = true
+}
+
+// releasedScroll records a scroll as cleared.
+func (p *ElasticProvider) releasedScroll(id string) {
+	p.openMu.Lock()
+	defer p.openMu.Unlock()
+	delete(p.open.scrolls, id)
+}
+
+// Close stops the background health probes, reconnection attempts and
+// metering flushes, and releases what the provider holds on the cluster:
+// the points in time of cursors not read to the end, scrolls of reads
+// still running, and, with a metering index, the usage not yet flushed. Cursors holding a
+// released point in time fail with ErrCursorExpired afterwards. Close
+// returns the metering flush error, if any; failures to release are only
+// reported to stderr, as the contexts expire on their own.
+func (p *ElasticProvider) Close() error {
+	if p.prober != nil {
+		p.prober.close()
+	}
+	if p.conn != nil {
+		p.conn.close()
+	}
+
+	p.openMu.Lock()
+	pits, scrolls := sortedKeys(p.open.pits), sortedKeys(p.open.scrolls)
+	p.openMu.Unlock()
+
+	for _, id := range pits {
+		p.closePointInTime(id)
+	}
+	for _, id := range scrolls {
+		p.clearScroll(id)
+	}
+
+	if p.meter != nil && p.meter.flush {
+		p.meter.stopMeteringFlush()
+		ctx, cancel := context.WithTimeout(context.Background(), meteringFlushTimeout)
+		defer cancel()
+		if err := p.flushMetering(ctx); err != nil {
+			return fmt.Errorf("failed to flush usage: %w", err)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package log
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestCloseReleasesCursorPIT(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PointInTime: true}, pitServer(t, 200, ""))
+
+	_, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 2})
+	if err != nil || stats.NextCursor == "" {
+		t.Fatalf("first page: err = %v, cursor %q; want a cursor", err, stats.NextCursor)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	requests := transport.recorded()[2:]
+	if got := strings.Join(pitRequests(requests), ", "); got != "DELETE /_pit" || requests[0].Body != `{"id":"pit-2"}` {
+		t.Fatalf("close requests = %s %v, want the cursor's refreshed pit closed", got, requests)
+	}
+
+	// Nothing is left to release
+	if err := p.Close(); err != nil || len(transport.recorded()) != 3 {
+		t.Errorf("second Close: err = %v, requests = %d; want nothing sent", err, len(transport.recorded()))
+	}
+}
+
+func TestCloseAfterReadsFinish(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PointInTime: true}, pitServer(t, 200, ""))
+
+	// A query read to its last page holds nothing open
+	_, stats, _ := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 2})
+	if _, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 2, Metadata: map[string]any{QueryOptionCursor: stats.NextCursor}}); err != nil {
+		t.Fatalf("second page failed: %v", err)
+	}
+	sent := len(transport.recorded())
+	if err := p.Close(); err != nil || len(transport.recorded()) != sent {
+		t.Errorf("Close: err = %v, requests = %s; want nothing sent", err, requestLine(transport.recorded()[sent:]))
+	}
+}
+
+func TestCloseClearsRunningScroll(t *testing.T) {
+	p, transport := newTestProvider(t, Config{Pagination: paginationScroll, PageSize: 2}, scrollServer(t, "8.11.1", 6, 0))
+
+	batches := 0
+	err := p.QueryStream(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		batches++
+		if batches == 2 {
+			// Shutting down while the read runs
+			return p.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+
+	var clears []string
+	for _, req := range transport.recorded() {
+		if req.Method == "DELETE" && req.Path == "/_search/scroll" {
+			clears = append(clears, req.Body)
+		}
+	}
+	if len(clears) == 0 || clears[0] != `{"scroll_id":"scroll-1"}` {
+		t.Errorf("clears = %v, want Close to clear the running scroll", clears)
+	}
+}
@@ -0,0 +1,135 @@
+package log
+
+import (
+	"context"
+	"slices"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// queryFlight is a search running for every caller sending it.
+type queryFlight struct {
+	done    chan struct{}
+	entries []schema.LogEntry
+	stats   QueryStats
+	err     error
+	// shared counts the callers that joined the search after the first.
+	shared int
+	// cancelled is set when the first caller's context ended the search,
+	// which leaves the others to search again.
+	cancelled bool
+}
+
+// coalesce runs search once for the callers sending the query keyed key at
+// the same time, as dashboard panels sharing a base query do, and gives
+// each of them a copy of the result. Queries with an empty key run alone.
+//
+// The search runs with the first caller's context, and so is sent with its
+// trace ID and metered to its scope. A caller whose context ends while
+// waiting stops waiting, and when the first caller's context ends the
+// search, the others still waiting search again.
+func (p *ElasticProvider) coalesce(ctx context.Context, key string, search func(ctx context.Context) ([]schema.LogEntry, QueryStats, error)) ([]schema.LogEntry, QueryStats, error) {
+	if key == "" {
+		return search(ctx)
+	}
+
+	p.flightMu.Lock()
+	if f, ok := p.flights[key]; ok {
+		f.shared++
+		p.flightMu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, QueryStats{}, transportError(ctx.Err())
+		}
+		if f.cancelled && ctx.Err() == nil {
+			return p.coalesce(ctx, key, search)
+		}
+		if f.err != nil {
+			return nil, QueryStats{}, f.err
+		}
+		return cloneEntries(f.entries), cloneStats(f.stats), nil
+	}
+	f := &queryFlight{done: make(chan struct{})}
+	if p.flights == nil {
+		p.flights = make(map[string]*queryFlight)
+	}
+	p.flights[key] = f
+	p.flightMu.Unlock()
+
+	f.entries, f.stats, f.err = search(ctx)
+	f.cancelled = f.err != nil && ctx.Err() != nil
+
+	p.flightMu.Lock()
+	delete(p.flights, key)
+	shared := f.shared
+	p.flightMu.Unlock()
+	close(f.done)
+
+	// The result cache keeps a copy of its own, so the first caller alone
+	// may have the result itself. Otherwise the others copy it, as the
+	// first caller may be changing it
+	if f.err != nil || shared == 0 {
+		return f.entries, f.stats, f.err
+	}
+	return cloneEntries(f.entries), cloneStats(f.stats), nil
+}
+
+// cloneEntries copies entries deep enough that changing one copy's labels,
+// fields or metadata, nested maps and lists included, leaves the others
+// unchanged.
+func cloneEntries(entries []schema.LogEntry) []schema.LogEntry {
+	if entries == nil {
+		return nil
+	}
+	cloned := make([]schema.LogEntry, len(entries))
+	for i, entry := range entries {
+		if entry.Labels != nil {
+			labels := make(map[string]string, len(entry.Labels))
+			for key, value := range entry.Labels {
+				labels[key] = value
+			}
+			entry.Labels = labels
+		}
+		entry.Fields = cloneMap(entry.Fields)
+		entry.Metadata = cloneMap(entry.Metadata)
+		cloned[i] = entry
+	}
+	return cloned
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	cloned := make(map[string]any, len(m))
+	for key, value := range m {
+		cloned[key] = cloneValue(value)
+	}
+	return cloned
+}
+
+func cloneValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return cloneMap(v)
+	case []any:
+		cloned := make([]any, len(v))
+		for i, item := range v {
+			cloned[i] = cloneValue(item)
+		}
+		return cloned
+	case []string:
+		return slices.Clone(v)
+	default:
+		return value
+	}
+}
+
+// cloneStats copies the lists in stats.
+func cloneStats(stats QueryStats) QueryStats {
+	stats.ShardFailures = slices.Clone(stats.ShardFailures)
+	stats.IndexFailures = slices.Clone(stats.IndexFailures)
+	stats.Hints = slices.Clone(stats.Hints)
+	return stats
+}
@@ -0,0 +1,225 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// heldSearches answers searches once release is closed, counting them.
+func heldSearches(searches *atomic.Int32, release <-chan struct{}) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		if strings.HasSuffix(req.Path, "/_search") {
+			searches.Add(1)
+			<-release
+		}
+		return 200, `{"count":1,"took":3,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"a","_source":{"message":"a","environment":"prod","http":{"status":500,"tags":["x"]}}}]}}`
+	}
+}
+
+// awaitShared waits until n callers joined the one running search.
+func awaitShared(t *testing.T, p *ElasticProvider, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		p.flightMu.Lock()
+		shared := -1
+		for _, f := range p.flights {
+			shared = f.shared
+		}
+		p.flightMu.Unlock()
+		if shared == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("callers did not join the search")
+}
+
+type coalescedResult struct {
+	entries []schema.LogEntry
+	stats   QueryStats
+	err     error
+}
+
+// queryConcurrently sends n queries at once, returning their results once
+// all ended.
+func queryConcurrently(p *ElasticProvider, n int, query schema.LogQuery) ([]coalescedResult, func()) {
+	results := make([]coalescedResult, n)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(r *coalescedResult) {
+			defer wg.Done()
+			r.entries, r.stats, r.err = p.QueryWithStats(context.Background(), query)
+		}(&results[i])
+	}
+	return results, wg.Wait
+}
+
+func TestCoalesceConcurrentQueries(t *testing.T) {
+	for _, cfg := range []Config{{}, {QueryCacheSize: 10}} {
+		var searches atomic.Int32
+		release := make(chan struct{})
+		p, _ := newTestProvider(t, cfg, heldSearches(&searches, release))
+		query := schema.LogQuery{Expression: &schema.LogExpression{Search: "timeout"}, Limit: 10}
+
+		results, wait := queryConcurrently(p, 6, query)
+		awaitShared(t, p, 5)
+		close(release)
+		wait()
+
+		if n := searches.Load(); n != 1 {
+			t.Errorf("cache size %d: searches = %d, want 1 shared by the queries", cfg.QueryCacheSize, n)
+		}
+		for i, r := range results {
+			if r.err != nil || len(r.entries) != 1 || r.stats.TotalHits != 1 || r.stats.Cached {
+				t.Fatalf("result %d = %+v, want the shared search's", i, r)
+			}
+		}
+
+		// Each caller has its own copy
+		results[0].entries[0].Labels["environment"] = "staging"
+		results[0].entries[0].Fields["http.status"] = 200
+		results[0].entries[0].Fields["http.tags"].([]any)[0] = "y"
+		for _, r := range results[1:] {
+			entry := r.entries[0]
+			if entry.Labels["environment"] != "prod" || entry.Fields["http.status"] != json.Number("500") || entry.Fields["http.tags"].([]any)[0] != "x" {
+				t.Errorf("entry = %+v, want it unchanged by another caller", entry)
+			}
+		}
+		if len(p.flights) != 0 {
+			t.Errorf("flights = %v, want none left", p.flights)
+		}
+
+		// Coalesce then cache: the shared result is cached once, as it was
+		// before any caller changed theirs
+		for _, r := range results {
+			r.entries[0].Labels["environment"] = "staging"
+			r.entries[0].Fields["http.tags"].([]any)[0] = "y"
+		}
+		hit, stats, err := p.QueryWithStats(context.Background(), query)
+		if err != nil || stats.Cached != (cfg.QueryCacheSize > 0) {
+			t.Errorf("cache size %d: stats = %+v, err = %v; want the shared result cached when the cache is on", cfg.QueryCacheSize, stats, err)
+		}
+		if err == nil && (hit[0].Labels["environment"] != "prod" || hit[0].Fields["http.tags"].([]any)[0] != "x") {
+			t.Errorf("cache size %d: entry = %+v, want it unchanged by the callers", cfg.QueryCacheSize, hit[0])
+		}
+
+		// A caller searching alone does not share its result with the cache
+		// either
+		alone := schema.LogQuery{Expression: &schema.LogExpression{Search: "refused"}, Limit: 10}
+		entries, _, _ := p.QueryWithStats(context.Background(), alone)
+		entries[0].Labels["environment"] = "staging"
+		if hit, _, _ := p.QueryWithStats(context.Background(), alone); hit[0].Labels["environment"] != "prod" {
+			t.Errorf("cache size %d: entry = %+v, want it unchanged by the caller that searched", cfg.QueryCacheSize, hit[0])
+		}
+	}
+}
+
+func TestCoalesceDistinctQueries(t *testing.T) {
+	var searches atomic.Int32
+	release := make(chan struct{})
+	close(release)
+	held := heldSearches(&searches, release)
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		// Logs are sampled only when more match than the limit; a sample
+		// of all of them is a plain query
+		if strings.HasSuffix(req.Path, "/_count") {
+			return 200, `{"count":100000}`
+		}
+		return held(req)
+	})
+
+	var wg sync.WaitGroup
+	for _, search := range []string{"timeout", "refused", "reset"} {
+		wg.Add(1)
+		go func(search string) {
+			defer wg.Done()
+			p.QueryWithStats(context.Background(), schema.LogQuery{Expression: &schema.LogExpression{Search: search}})
+		}(search)
+	}
+	// Samples are random, so never shared
+	sample := schema.LogQuery{Metadata: map[string]any{QueryOptionSample: true}}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.QueryWithStats(context.Background(), sample)
+		}()
+	}
+	wg.Wait()
+	if n := searches.Load(); n != 5 {
+		t.Errorf("searches = %d, want one per distinct query", n)
+	}
+}
+
+func TestCoalesceCancelled(t *testing.T) {
+	var searches atomic.Int32
+	release := make(chan struct{})
+	p, _ := newTestProvider(t, Config{}, heldSearches(&searches, release))
+	query := schema.LogQuery{Expression: &schema.LogExpression{Search: "timeout"}}
+
+	// A waiting caller giving up leaves the search to the others
+	ctx, cancel := context.WithCancel(context.Background())
+	results, wait := queryConcurrently(p, 1, query)
+	awaitShared(t, p, 0)
+	waited := make(chan error)
+	go func() {
+		_, _, err := p.QueryWithStats(ctx, query)
+		waited <- err
+	}()
+	awaitShared(t, p, 1)
+	cancel()
+	if err := <-waited; !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want the waiting caller cancelled", err)
+	}
+	close(release)
+	wait()
+	if results[0].err != nil || searches.Load() != 1 {
+		t.Errorf("err = %v, searches = %d; want the search finished for the first caller", results[0].err, searches.Load())
+	}
+
+	// The first caller giving up leaves the others to search again
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	search := func(ctx context.Context) ([]schema.LogEntry, QueryStats, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+			return nil, QueryStats{}, transportError(ctx.Err())
+		}
+		return []schema.LogEntry{{Message: "a"}}, QueryStats{TotalHits: 1}, nil
+	}
+	leader := make(chan error)
+	go func() {
+		_, _, err := p.coalesce(leaderCtx, "key", search)
+		leader <- err
+	}()
+	<-started
+	other := make(chan []schema.LogEntry)
+	go func() {
+		entries, _, err := p.coalesce(context.Background(), "key", search)
+		if err != nil {
+			t.Errorf("err = %v, want the other caller to search again", err)
+		}
+		other <- entries
+	}()
+	awaitShared(t, p, 1)
+	cancelLeader()
+	close(release)
+	if err := <-leader; !errors.Is(err, ErrCancelled) {
+		t.Errorf("err = %v, want the first caller cancelled", err)
+	}
+	if entries := <-other; len(entries) != 1 || calls.Load() != 2 {
+		t.Errorf("entries = %v, calls = %d; want the other caller to search again", entries, calls.Load())
+	}
+}
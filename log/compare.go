@@ -0,0 +1,76 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// WindowCount is the number of matching logs in a time window.
+type WindowCount struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int64     `json:"count"`
+}
+
+// CompareResult compares a query's count in its window with a baseline
+// window of the same length.
+type CompareResult struct {
+	Current  WindowCount `json:"current"`
+	Baseline WindowCount `json:"baseline"`
+	// Ratio is Current.Count / Baseline.Count, or nil when the baseline has
+	// no logs.
+	Ratio *float64 `json:"ratio"`
+}
+
+// Compare counts the logs matching a query in its window and in the window
+// of the same length baselineOffset earlier, in a single _msearch round
+// trip. The query must have a Start and End.
+func (p *ElasticProvider) Compare(ctx context.Context, query schema.LogQuery, baselineOffset time.Duration) (CompareResult, error) {
+	ctx = withAudit(ctx, "log.compare", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return CompareResult{}, err
+	}
+	if query.Start.IsZero() || query.End.IsZero() || !query.End.After(query.Start) {
+		return CompareResult{}, errors.New("compare needs a query window with start before end")
+	}
+	if baselineOffset <= 0 {
+		return CompareResult{}, errors.New("baseline offset must be positive")
+	}
+
+	baseline := query
+	baseline.Start = query.Start.Add(-baselineOffset)
+	baseline.End = query.End.Add(-baselineOffset)
+
+	responses, err := p.msearch(ctx, []map[string]any{p.buildCompareQuery(query), p.buildCompareQuery(baseline)})
+	if err != nil {
+		return CompareResult{}, err
+	}
+	for _, r := range responses {
+		if err := r.err(); err != nil {
+			return CompareResult{}, err
+		}
+	}
+
+	result := CompareResult{
+		Current:  WindowCount{Start: query.Start, End: query.End, Count: int64(responses[0].Hits.Total.Value)},
+		Baseline: WindowCount{Start: baseline.Start, End: baseline.End, Count: int64(responses[1].Hits.Total.Value)},
+	}
+	if result.Baseline.Count > 0 {
+		ratio := float64(result.Current.Count) / float64(result.Baseline.Count)
+		result.Ratio = &ratio
+	}
+	return result, nil
+}
+
+// buildCompareQuery counts the logs matching query exactly without
+// fetching any.
+func (p *ElasticProvider) buildCompareQuery(query schema.LogQuery) map[string]any {
+	return map[string]any{
+		"query":            p.boolQuery(query),
+		"size":             0,
+		"track_total_hits": true,
+	}
+}
@@ -0,0 +1,115 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func msearchResponse(current, baseline int) string {
+	return fmt.Sprintf(`{"took":4,"responses":[
+		{"took":2,"timed_out":false,"hits":{"total":{"value":%d,"relation":"eq"},"hits":[]},"status":200},
+		{"took":2,"timed_out":false,"hits":{"total":{"value":%d,"relation":"eq"},"hits":[]},"status":200}
+	]}`, current, baseline)
+}
+
+func TestCompare(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, msearchResponse(30, 10)
+	})
+
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	query := schema.LogQuery{
+		Start:      start,
+		End:        start.Add(time.Hour),
+		Expression: &schema.LogExpression{SeverityIn: []string{"error"}},
+	}
+	result, err := p.Compare(context.Background(), query, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("compare failed: %v", err)
+	}
+
+	if result.Current.Count != 30 || result.Baseline.Count != 10 || result.Ratio == nil || *result.Ratio != 3 {
+		t.Errorf("result = %+v, want 30 against 10 with ratio 3", result)
+	}
+	if !result.Baseline.Start.Equal(start.Add(-24*time.Hour)) || !result.Baseline.End.Equal(start.Add(-23*time.Hour)) {
+		t.Errorf("baseline window = %s to %s, want the same hour a day earlier", result.Baseline.Start, result.Baseline.End)
+	}
+
+	requests := transport.recorded()
+	if len(requests) != 1 || requests[0].Path != "/_msearch" {
+		t.Fatalf("requests = %s, want one msearch", requestLine(requests))
+	}
+	lines := strings.Split(strings.TrimSuffix(requests[0].Body, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("msearch body = %q, want a header and body per search", requests[0].Body)
+	}
+	for _, header := range []string{lines[0], lines[2]} {
+		if header != `{"index":"logs-*"}` {
+			t.Errorf("header = %s, want the index pattern", header)
+		}
+	}
+	for i, want := range []string{`"gte":"2023-10-01T12:00:00Z","lte":"2023-10-01T13:00:00Z"`, `"gte":"2023-09-30T12:00:00Z","lte":"2023-09-30T13:00:00Z"`} {
+		body := lines[1+2*i]
+		var search map[string]any
+		if err := json.Unmarshal([]byte(body), &search); err != nil {
+			t.Fatalf("failed to decode search %d: %v", i, err)
+		}
+		if !strings.Contains(body, want) || search["size"] != float64(0) || search["track_total_hits"] != true || !strings.Contains(body, `"severity"`) {
+			t.Errorf("search %d = %s, want an exact count of the query over %s", i, body, want)
+		}
+	}
+}
+
+func TestCompareRatio(t *testing.T) {
+	tests := []struct {
+		current, baseline int
+		want              string
+	}{
+		{current: 5, baseline: 20, want: "0.25"},
+		{current: 0, baseline: 20, want: "0"},
+		{current: 12, baseline: 0, want: "null"},
+		{current: 0, baseline: 0, want: "null"},
+	}
+
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.current, tt.baseline), func(t *testing.T) {
+			p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+				return 200, msearchResponse(tt.current, tt.baseline)
+			})
+			result, err := p.Compare(context.Background(), schema.LogQuery{Start: start, End: start.Add(time.Hour)}, time.Hour)
+			if err != nil {
+				t.Fatalf("compare failed: %v", err)
+			}
+			if got, _ := json.Marshal(result.Ratio); string(got) != tt.want {
+				t.Errorf("ratio = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareErrors(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, `{"responses":[
+			{"hits":{"total":{"value":1,"relation":"eq"},"hits":[]},"status":200},
+			{"error":{"root_cause":[],"type":"search_phase_execution_exception","reason":"all shards failed"},"status":400}
+		]}`
+	})
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := p.Compare(context.Background(), schema.LogQuery{Start: start, End: start.Add(time.Hour)}, time.Hour); err == nil || !strings.Contains(err.Error(), "all shards failed") {
+		t.Errorf("err = %v, want the baseline search error", err)
+	}
+	if _, err := p.Compare(context.Background(), schema.LogQuery{End: start}, time.Hour); err == nil || !strings.Contains(err.Error(), "window") {
+		t.Errorf("err = %v, want a missing window error", err)
+	}
+	if _, err := p.Compare(context.Background(), schema.LogQuery{Start: start, End: start.Add(time.Hour)}, 0); err == nil || !strings.Contains(err.Error(), "offset") {
+		t.Errorf("err = %v, want an offset error", err)
+	}
+}
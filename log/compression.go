@@ -0,0 +1,130 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Response compressions accepted by the responseCompression config key.
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+	compressionNone = "none"
+)
+
+// Decoder returns a reader decompressing r, a response body in the
+// Content-Encoding it was registered for.
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+// WithDecoder decodes responses in encoding with d. It is needed for the
+// zstd response compression, for which the adapter ships no decoder, so
+// that programs choose their own; a few lines wrap
+// github.com/klauspost/compress/zstd.
+func WithDecoder(encoding string, d Decoder) Option {
+	return func(o *options) {
+		if o.decoders == nil {
+			o.decoders = make(map[string]Decoder)
+		}
+		o.decoders[strings.ToLower(encoding)] = d
+	}
+}
+
+func gzipDecoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// newDecompressTransport returns a transport asking the cluster for
+// responses in the given compression and decompressing them. It asks for
+// zstd before gzip, leaving the choice to the cluster, and fails when no
+// zstd decoder was given.
+func newDecompressTransport(next http.RoundTripper, compression string, decoders map[string]Decoder) (http.RoundTripper, error) {
+	t := &decompressTransport{next: next, accept: compressionGzip, decoders: map[string]Decoder{compressionGzip: gzipDecoder}}
+	for encoding, d := range decoders {
+		t.decoders[encoding] = d
+	}
+	if compression == compressionZstd {
+		if decoders[compressionZstd] == nil {
+			return nil, fmt.Errorf("responseCompression %q needs a zstd decoder, passed with WithDecoder", compression)
+		}
+		t.accept = compressionZstd + ", " + compressionGzip
+	}
+	return t, nil
+}
+
+// decompressTransport sets Accept-Encoding on requests and decompresses
+// the responses, as Go's transport does for gzip alone. Failing to
+// decompress a response is in ErrConnection, like a connection dropped
+// mid-response.
+type decompressTransport struct {
+	next     http.RoundTripper
+	accept   string
+	decoders map[string]Decoder
+}
+
+func (t *decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", t.accept)
+	}
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return res, nil
+	}
+	decoder, ok := t.decoders[encoding]
+	if !ok {
+		res.Body.Close()
+		return nil, transportError(fmt.Errorf("response in unsupported encoding %q", encoding))
+	}
+	res.Body = &decodedBody{body: res.Body, encoding: encoding, decoder: decoder}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+// decodedBody decompresses a response body as it is read. The decoder is
+// only started by the first read, as responses without a body, such as
+// those to HEAD requests, may still name an encoding.
+type decodedBody struct {
+	body     io.ReadCloser
+	encoding string
+	decoder  Decoder
+	r        io.ReadCloser
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.r == nil {
+		r, err := b.decoder(b.body)
+		if err == io.EOF {
+			return 0, err
+		}
+		if err != nil {
+			return 0, b.error(err)
+		}
+		b.r = r
+	}
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = b.error(err)
+	}
+	return n, err
+}
+
+func (b *decodedBody) error(err error) error {
+	return transportError(fmt.Errorf("failed to decompress %s response: %w", b.encoding, err))
+}
+
+func (b *decodedBody) Close() error {
+	if b.r != nil {
+		b.r.Close()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,184 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func gzipped(t testing.TB, body []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodedTransport answers every request with body in encoding, recording
+// the Accept-Encoding asked for.
+type encodedTransport struct {
+	body     []byte
+	encoding string
+	accepted atomic.Value
+}
+
+func (t *encodedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.accepted.Store(req.Header.Get("Accept-Encoding"))
+	header := http.Header{"X-Elastic-Product": {"Elasticsearch"}, "Content-Type": {"application/json"}}
+	if t.encoding != "" {
+		header.Set("Content-Encoding", t.encoding)
+	}
+	return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(bytes.NewReader(t.body))}, nil
+}
+
+// newEncodedProvider returns a provider answered by next through the
+// decompressing transport.
+func newEncodedProvider(t *testing.T, next http.RoundTripper, compression string, decoders map[string]Decoder) *ElasticProvider {
+	transport, err := newDecompressTransport(next, compression, decoders)
+	if err != nil {
+		t.Fatalf("failed to create transport: %v", err)
+	}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{"http://elastic.test:9200"},
+		Transport:    &classifyTransport{next: transport},
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return &ElasticProvider{cfg: Config{IndexPattern: "logs-*"}, client: client}
+}
+
+func TestDecompressResponses(t *testing.T) {
+	body := []byte(`{"hits":{"total":{"value":1},"hits":[{"_id":"1","_source":{"message":"hello"}}]}}`)
+	// The fake zstd decoder only strips a marker, standing in for a real one
+	zstd := func(r io.Reader) (io.ReadCloser, error) {
+		data, err := io.ReadAll(r)
+		return io.NopCloser(bytes.NewReader(bytes.TrimPrefix(data, []byte("zstd:")))), err
+	}
+	tests := []struct {
+		name        string
+		compression string
+		encoding    string
+		body        []byte
+		accept      string
+	}{
+		{name: "gzip", encoding: "gzip", body: gzipped(t, body), accept: "gzip"},
+		{name: "identity", body: body, accept: "gzip"},
+		{name: "zstd", compression: compressionZstd, encoding: "zstd", body: append([]byte("zstd:"), body...), accept: "zstd, gzip"},
+		{name: "zstd answered in gzip", compression: compressionZstd, encoding: "gzip", body: gzipped(t, body), accept: "zstd, gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &encodedTransport{body: tt.body, encoding: tt.encoding}
+			p := newEncodedProvider(t, next, tt.compression, map[string]Decoder{"zstd": zstd})
+
+			result, err := p.Query(context.Background(), schema.LogQuery{Limit: 10})
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if len(result.Entries) != 1 || result.Entries[0].Message != "hello" {
+				t.Errorf("entries = %v, want the decoded hit", result.Entries)
+			}
+			if accepted := next.accepted.Load(); accepted != tt.accept {
+				t.Errorf("Accept-Encoding = %q, want %q", accepted, tt.accept)
+			}
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	corrupt := gzipped(t, cannedSearchBody(50))
+	corrupt = corrupt[:len(corrupt)/2]
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     string
+	}{
+		{name: "truncated gzip", encoding: "gzip", body: corrupt, want: "failed to decompress gzip response"},
+		{name: "not gzip", encoding: "gzip", body: []byte(`{"hits":{}}`), want: "failed to decompress gzip response"},
+		{name: "unsupported encoding", encoding: "br", body: []byte("?"), want: `unsupported encoding "br"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newEncodedProvider(t, &encodedTransport{body: tt.body, encoding: tt.encoding}, "", nil)
+			_, err := p.Query(context.Background(), schema.LogQuery{Limit: 10})
+			if !errors.Is(err, ErrConnection) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q in ErrConnection", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseCompressionConfig(t *testing.T) {
+	if cfg := parseConfig(map[string]any{"responseCompression": "brotli"}); cfg.ResponseCompression != "" {
+		t.Errorf("responseCompression = %q, want unknown values ignored", cfg.ResponseCompression)
+	}
+	if cfg := parseConfig(map[string]any{"responseCompression": "none"}); cfg.ResponseCompression != compressionNone {
+		t.Errorf("responseCompression = %q, want none", cfg.ResponseCompression)
+	}
+	if _, err := newDecompressTransport(http.DefaultTransport, compressionZstd, nil); err == nil || !strings.Contains(err.Error(), "WithDecoder") {
+		t.Errorf("err = %v, want zstd refused without a decoder", err)
+	}
+	var o options
+	WithDecoder("ZSTD", gzipDecoder)(&o)
+	if o.decoders["zstd"] == nil {
+		t.Errorf("decoders = %v, want one for zstd", o.decoders)
+	}
+}
+
+// BenchmarkCompressedResponse reads a page of 1,000 hits from a local
+// server, compressed and not; wire-B/op is the response size sent.
+func BenchmarkCompressedResponse(b *testing.B) {
+	body := cannedSearchBody(1000)
+	compressed := gzipped(b, body)
+	var wire atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodHead {
+			return
+		}
+		out := body
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			out = compressed
+		}
+		wire.Add(int64(len(out)))
+		w.Write(out)
+	}))
+	defer server.Close()
+
+	for _, compression := range []string{compressionGzip, compressionNone} {
+		b.Run(compression, func(b *testing.B) {
+			p, err := NewFromConfig(map[string]any{"addresses": []any{server.URL}, "responseCompression": compression})
+			if err != nil {
+				b.Fatal(err)
+			}
+			wire.Store(0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Query(context.Background(), schema.LogQuery{Limit: 1000}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(wire.Load())/float64(b.N), "wire-B/op")
+		})
+	}
+}
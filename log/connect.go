@@ -0,0 +1,230 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reconnect schedule while a lazily connected provider cannot reach the
+// cluster: the first retry waits reconnectBaseDelay, doubling up to
+// reconnectMaxDelay. Each attempt is bounded by connectTimeout.
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+	connectTimeout     = 10 * time.Second
+)
+
+// Connection states.
+const (
+	connectIdle         = "idle"
+	connectConnecting   = "connecting"
+	connectConnected    = "connected"
+	connectDisconnected = "disconnected"
+)
+
+// ErrDisconnected is in DisconnectedError, so callers can match it with
+// errors.Is.
+var ErrDisconnected = errors.New("not connected to elasticsearch")
+
+// DisconnectedError refuses a request without sending it, because the
+// lazily connected provider has not reached the cluster yet. It is in
+// ErrConnection and wraps the last connection attempt's error. RetryAfter
+// is the time until the next attempt, zero while one runs.
+type DisconnectedError struct {
+	Cause      error
+	RetryAfter time.Duration
+}
+
+func (e *DisconnectedError) Error() string {
+	if e.RetryAfter == 0 {
+		return fmt.Sprintf("%s, reconnecting: %v", ErrDisconnected, e.Cause)
+	}
+	return fmt.Sprintf("%s, next attempt in %s: %v", ErrDisconnected, e.RetryAfter.Round(time.Millisecond), e.Cause)
+}
+
+func (e *DisconnectedError) Is(target error) bool {
+	return target == ErrDisconnected || target == ErrConnection
+}
+
+func (e *DisconnectedError) Unwrap() error {
+	return e.Cause
+}
+
+// connector connects a lazily connected provider to the cluster. The first
+// request starts connecting and waits for the attempt; once it fails, the
+// attempt is retried in the background on an exponential schedule, and
+// requests fail at once until one succeeds. Connected, the provider stays
+// so, leaving later outages to the retries and the circuit breaker.
+type connector struct {
+	// ping reaches the cluster, with a context marked by withConnecting.
+	ping func(ctx context.Context) error
+	now  func() time.Time
+	// after returns a channel receiving a time once d passed.
+	after  func(d time.Duration) <-chan time.Time
+	logger *atomic.Pointer[slog.Logger]
+
+	mu       sync.Mutex
+	state    string
+	err      error
+	attempts int
+	retryAt  time.Time
+	// attempted is closed once the first attempt ended.
+	attempted chan struct{}
+
+	// cancel stops the attempts, which close done once stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newConnector(ping func(ctx context.Context) error, logger *atomic.Pointer[slog.Logger]) *connector {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connector{
+		ping:      ping,
+		now:       time.Now,
+		after:     time.After,
+		logger:    logger,
+		state:     connectIdle,
+		attempted: make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+// ready returns nil once the cluster was reached, starting to connect on
+// the first call, and a DisconnectedError while it cannot be.
+func (c *connector) ready(ctx context.Context) error {
+	c.mu.Lock()
+	switch c.state {
+	case connectConnected:
+		c.mu.Unlock()
+		return nil
+	case connectIdle:
+		c.state = connectConnecting
+		go c.run()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.attempted:
+	case <-ctx.Done():
+		return transportError(ctx.Err())
+	}
+	return c.disconnected()
+}
+
+// disconnected returns the error requests fail with, nil once connected.
+func (c *connector) disconnected() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == connectConnected {
+		return nil
+	}
+	if c.err == nil {
+		// Closed before the first attempt ended
+		return &DisconnectedError{Cause: errors.New("provider closed")}
+	}
+	return &DisconnectedError{Cause: c.err, RetryAfter: max(c.retryAt.Sub(c.now()), 0)}
+}
+
+// run attempts to connect until an attempt succeeds or close stops it.
+func (c *connector) run() {
+	defer close(c.done)
+	delay := reconnectBaseDelay
+	for {
+		ctx, cancel := context.WithTimeout(withConnecting(c.ctx), connectTimeout)
+		err := c.ping(ctx)
+		cancel()
+		if c.ctx.Err() != nil {
+			c.mu.Lock()
+			if c.state == connectConnecting {
+				close(c.attempted)
+				c.state = connectDisconnected
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		c.mu.Lock()
+		c.attempts++
+		attempts := c.attempts
+		if c.state == connectConnecting {
+			close(c.attempted)
+		}
+		if err == nil {
+			c.state, c.err = connectConnected, nil
+			c.mu.Unlock()
+			if l := c.logger.Load(); l != nil && attempts > 1 {
+				l.Info("connected to elasticsearch", "attempts", attempts)
+			}
+			return
+		}
+		c.state, c.err, c.retryAt = connectDisconnected, err, c.now().Add(delay)
+		c.mu.Unlock()
+		if l := c.logger.Load(); l != nil {
+			l.Warn("failed to connect to elasticsearch", "attempts", attempts, "nextAttemptMs", delay.Milliseconds(), "error", err.Error())
+		}
+
+		select {
+		case <-c.after(delay):
+		case <-c.ctx.Done():
+			return
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// close stops the attempts and waits for a running one to end.
+func (c *connector) close() {
+	c.cancel()
+	c.mu.Lock()
+	started := c.state != connectIdle
+	c.mu.Unlock()
+	if started {
+		<-c.done
+	}
+}
+
+// ping pings the cluster for the connector.
+func (p *ElasticProvider) ping(ctx context.Context) error {
+	res, err := p.client.Ping(p.client.Ping.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+type connectingKey struct{}
+
+// withConnecting marks ctx as a connection attempt's, which connectTransport
+// lets through.
+func withConnecting(ctx context.Context) context.Context {
+	return context.WithValue(ctx, connectingKey{}, true)
+}
+
+// connectTransport holds requests back until the connector reached the
+// cluster.
+type connectTransport struct {
+	next http.RoundTripper
+	conn *connector
+}
+
+func (t *connectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context().Value(connectingKey{}) == nil {
+		if err := t.conn.ready(req.Context()); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(req)
+}
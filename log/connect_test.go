@@ -0,0 +1,224 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// outageTransport refuses connections while down, counting the pings and
+// other requests it receives.
+type outageTransport struct {
+	mu       sync.Mutex
+	down     bool
+	pings    int
+	requests int
+}
+
+func (t *outageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if req.Method == http.MethodHead {
+		t.pings++
+	} else {
+		t.requests++
+	}
+	if t.down {
+		return nil, errors.New("dial tcp 10.0.0.1:9200: connect: connection refused")
+	}
+	body := `{"hits":{"total":{"value":1},"hits":[{"_id":"1","_source":{"message":"hello"}}]}}`
+	header := http.Header{"X-Elastic-Product": {"Elasticsearch"}, "Content-Type": {"application/json"}}
+	return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(bytes.NewReader([]byte(body)))}, nil
+}
+
+func (t *outageTransport) set(down bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.down = down
+}
+
+func (t *outageTransport) counts() (pings, requests int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pings, t.requests
+}
+
+// hangingTransport answers no request, until its context ends.
+type hangingTransport struct{}
+
+func (hangingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+// newLazyProvider returns a lazily connected provider whose reconnection
+// waits for the returned channel to be sent on, recording the delays.
+func newLazyProvider(t *testing.T, next http.RoundTripper) (*ElasticProvider, chan<- time.Time, func() []time.Duration) {
+	p := &ElasticProvider{cfg: Config{IndexPattern: "logs-*", LazyConnect: true}}
+	p.conn = newConnector(p.ping, &p.logger)
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	p.conn.now = func() time.Time { return now }
+	ticks := make(chan time.Time)
+	var mu sync.Mutex
+	var delays []time.Duration
+	p.conn.after = func(d time.Duration) <-chan time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		delays = append(delays, d)
+		return ticks
+	}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{"http://elastic.test:9200"},
+		Transport:    &connectTransport{next: &classifyTransport{next: next}, conn: p.conn},
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	p.client = client
+	t.Cleanup(func() { p.conn.close() })
+	return p, ticks, func() []time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Duration(nil), delays...)
+	}
+}
+
+// awaitConnection waits until the connector is in state.
+func awaitConnection(t *testing.T, c *connector, state string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		current := c.state
+		c.mu.Unlock()
+		if current == state {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("connector did not become %s", state)
+}
+
+func TestLazyConnectRecoversAfterOutage(t *testing.T) {
+	transport := &outageTransport{down: true}
+	p, ticks, delays := newLazyProvider(t, transport)
+	if pings, _ := transport.counts(); pings != 0 {
+		t.Fatalf("pings = %d, want none before the first request", pings)
+	}
+
+	// Concurrent first requests wait for one attempt and fail with its cause
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = p.Query(context.Background(), schema.LogQuery{Limit: 10})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		var disconnected *DisconnectedError
+		if !errors.As(err, &disconnected) || !errors.Is(err, ErrConnection) || !strings.Contains(err.Error(), "connection refused") || disconnected.RetryAfter != time.Second {
+			t.Errorf("err = %v, want a DisconnectedError with the refused connection, retried in 1s", err)
+		}
+	}
+	if pings, requests := transport.counts(); pings != 1 || requests != 0 {
+		t.Errorf("pings = %d, requests = %d; want one attempt and nothing else sent", pings, requests)
+	}
+
+	// Retries back off while the outage lasts, and requests fail at once
+	ticks <- time.Time{}
+	ticks <- time.Time{}
+	for deadline := time.Now().Add(5 * time.Second); len(delays()) < 3 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := p.Query(context.Background(), schema.LogQuery{Limit: 10}); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("err = %v, want the request refused while disconnected", err)
+	}
+	if pings, requests := transport.counts(); pings != 3 || requests != 0 {
+		t.Errorf("pings = %d, requests = %d; want three attempts and nothing else sent", pings, requests)
+	}
+
+	transport.set(false)
+	ticks <- time.Time{}
+	awaitConnection(t, p.conn, connectConnected)
+	result, err := p.Query(context.Background(), schema.LogQuery{Limit: 10})
+	if err != nil || len(result.Entries) != 1 {
+		t.Fatalf("result = %+v, err = %v; want the query answered once reconnected", result, err)
+	}
+	if got := delays(); len(got) != 3 || got[0] != time.Second || got[1] != 2*time.Second || got[2] != 4*time.Second {
+		t.Errorf("delays = %v, want 1s, 2s, 4s", got)
+	}
+
+	// Connected, later failures are the requests' own
+	transport.set(true)
+	if _, err := p.Query(context.Background(), schema.LogQuery{Limit: 10}); errors.Is(err, ErrDisconnected) || !errors.Is(err, ErrConnection) {
+		t.Errorf("err = %v, want the request sent and failing", err)
+	}
+}
+
+func TestLazyConnectFirstAttempt(t *testing.T) {
+	transport := &outageTransport{}
+	p, _, delays := newLazyProvider(t, transport)
+	if _, err := p.Query(context.Background(), schema.LogQuery{Limit: 10}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if pings, requests := transport.counts(); pings != 1 || requests != 1 {
+		t.Errorf("pings = %d, requests = %d; want one ping then the search", pings, requests)
+	}
+	if len(delays()) != 0 {
+		t.Errorf("delays = %v, want no reconnection", delays())
+	}
+
+	// A request cancelled while waiting for the first attempt is cancelled
+	q, _, _ := newLazyProvider(t, hangingTransport{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Query(ctx, schema.LogQuery{Limit: 10}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want the request's deadline", err)
+	}
+}
+
+func TestLazyConnectConfig(t *testing.T) {
+	tests := []struct {
+		cfg              map[string]any
+		skipPing, isLazy bool
+	}{
+		{cfg: map[string]any{}},
+		{cfg: map[string]any{"skipStartupPing": true}, skipPing: true, isLazy: true},
+		{cfg: map[string]any{"skipStartupPing": true, "lazyConnect": false}, skipPing: true},
+		{cfg: map[string]any{"lazyConnect": true}, isLazy: true},
+	}
+	for _, tt := range tests {
+		cfg := parseConfig(tt.cfg)
+		if cfg.SkipStartupPing != tt.skipPing || cfg.LazyConnect != tt.isLazy {
+			t.Errorf("parseConfig(%v) = skipStartupPing %v, lazyConnect %v; want %v, %v", tt.cfg, cfg.SkipStartupPing, cfg.LazyConnect, tt.skipPing, tt.isLazy)
+		}
+	}
+
+	// An unreachable cluster fails construction unless the ping is skipped
+	unreachable := map[string]any{"addresses": []any{"http://127.0.0.1:1"}, "circuitBreakerThreshold": 0}
+	if _, err := NewFromConfig(unreachable); err == nil {
+		t.Error("construction against an unreachable cluster succeeded")
+	}
+	unreachable["skipStartupPing"] = true
+	p, err := NewFromConfig(unreachable)
+	if err != nil {
+		t.Fatalf("construction with skipStartupPing failed: %v", err)
+	}
+	defer p.Close()
+	if _, err := p.Query(context.Background(), schema.LogQuery{Limit: 10}); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("err = %v, want the first request to find the cluster unreachable", err)
+	}
+}
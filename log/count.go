@@ -0,0 +1,64 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// buildCountQuery constructs the _count request body: the search's bool
+// query without sorting, paging, or hit tracking.
+func (p *ElasticProvider) buildCountQuery(query schema.LogQuery) map[string]any {
+	return map[string]any{"query": p.boolQuery(query)}
+}
+
+// Count returns the number of logs matching a query without fetching them.
+func (p *ElasticProvider) Count(ctx context.Context, query schema.LogQuery) (int64, error) {
+	ctx = withAudit(ctx, "log.count", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return 0, err
+	}
+
+	queryBody, err := json.Marshal(p.buildCountQuery(query))
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	var count int64
+	err = p.retry(ctx, func(ctx context.Context) (err error) {
+		count, err = p.count(ctx, queryBody)
+		return err
+	})
+	return count, err
+}
+
+// count sends one _count request.
+func (p *ElasticProvider) count(ctx context.Context, queryBody []byte) (int64, error) {
+	res, err := p.client.Count(
+		p.client.Count.WithContext(ctx),
+		p.client.Count.WithIndex(p.cfg.IndexPattern),
+		p.client.Count.WithBody(bytes.NewReader(queryBody)),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("elasticsearch count failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, responseError(res)
+	}
+
+	var result struct {
+		Count *int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.Count == nil {
+		return 0, fmt.Errorf("count response has no count")
+	}
+	return *result.Count, nil
+}
@@ -0,0 +1,85 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestBuildCountQuerySharesSearchClauses(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{ScopeFields: map[string][]string{"service": {"service", "service.name"}}}}
+	query := schema.LogQuery{
+		Start: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		End:   time.Date(2023, 10, 1, 12, 5, 0, 0, time.UTC),
+		Expression: &schema.LogExpression{
+			Search:     "timeout",
+			SeverityIn: []string{"error"},
+			Filters:    []schema.LogFilter{{Field: "http.status", Operator: "=", Value: "503"}},
+		},
+		Scope:    schema.QueryScope{Service: "checkout"},
+		Limit:    50,
+		Metadata: map[string]any{"team": "core", QueryOptionExactTotals: true},
+	}
+
+	search, _ := json.Marshal(p.buildQuery(query)["query"])
+	countQuery := p.buildCountQuery(query)
+	count, _ := json.Marshal(countQuery["query"])
+	if string(search) != string(count) {
+		t.Errorf("count query = %s\nwant the search query %s", count, search)
+	}
+	for _, key := range []string{"sort", "size", "track_total_hits", "search_after", "from"} {
+		if _, ok := countQuery[key]; ok {
+			t.Errorf("count body has %s, want only the query", key)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, `{"count":9007199254,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0}}`
+	})
+
+	n, err := p.Count(context.Background(), schema.LogQuery{Expression: &schema.LogExpression{SeverityIn: []string{"error"}}})
+	if err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	if n != 9007199254 {
+		t.Errorf("count = %d, want 9007199254", n)
+	}
+
+	req := transport.recorded()[0]
+	if req.Path != "/logs-*/_count" {
+		t.Errorf("path = %s, want /logs-*/_count", req.Path)
+	}
+	if !strings.Contains(req.Body, `"severity"`) || strings.Contains(req.Body, `"size"`) {
+		t.Errorf("body = %s, want the severity filter and no size", req.Body)
+	}
+}
+
+func TestCountErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "error response", status: 400, body: `{"error":{"type":"query_shard_exception","reason":"bad query"}}`, wantErr: "bad query"},
+		{name: "missing count", status: 200, body: `{}`, wantErr: "no count"},
+		{name: "malformed", status: 200, body: `{"count":`, wantErr: "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+				return tt.status, tt.body
+			})
+			if _, err := p.Count(context.Background(), schema.LogQuery{}); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package log
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// cursorVersion is the current cursor format. Cursors of other versions are
+// rejected so the format can change without misreading old tokens.
+const cursorVersion = 1
+
+// pageCursor is the state a caller hands back to fetch the next page. It is
+// self-contained so that a restarted plugin can resume from it.
+type pageCursor struct {
+	Version int `json:"v"`
+	// Index is the index pattern the cursor was issued for.
+	Index string `json:"idx"`
+	// QueryHash identifies the query the cursor pages through.
+	QueryHash string `json:"qh"`
+	// PIT is the point-in-time id when paging in point-in-time mode.
+	PIT string `json:"pit,omitempty"`
+	// After holds the last hit's sort values, kept as decoded (json.Number
+	// for numbers) so large integers survive the round trip exactly.
+	After []any `json:"after"`
+}
+
+// encodeCursor renders a cursor for query as an opaque token: the base64
+// JSON payload, followed by "." and an HMAC-SHA256 signature when
+// cursorSecret is configured.
+func (p *ElasticProvider) encodeCursor(query schema.LogQuery, cursor pageCursor) (string, error) {
+	cursor.Version = cursorVersion
+	cursor.Index = p.cfg.IndexPattern
+	cursor.QueryHash = queryHash(query)
+	encoded, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(encoded)
+	if p.cfg.CursorSecret != "" {
+		token += "." + p.signCursor(token)
+	}
+	return token, nil
+}
+
+// decodeCursor verifies and parses a token produced by encodeCursor. It does
+// not check the token against a query; see queryCursor.
+func (p *ElasticProvider) decodeCursor(token string) (pageCursor, error) {
+	payload, signature, signed := strings.Cut(token, ".")
+	if p.cfg.CursorSecret != "" {
+		if !signed {
+			return pageCursor{}, errors.New("cursor is not signed")
+		}
+		if !hmac.Equal([]byte(signature), []byte(p.signCursor(payload))) {
+			return pageCursor{}, errors.New("cursor signature does not match")
+		}
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return pageCursor{}, errors.New("cursor is not valid base64")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var cursor pageCursor
+	if err := decoder.Decode(&cursor); err != nil {
+		return pageCursor{}, errors.New("cursor is malformed")
+	}
+	if cursor.Version != cursorVersion {
+		return pageCursor{}, fmt.Errorf("unsupported cursor version %d", cursor.Version)
+	}
+	if len(cursor.After) == 0 {
+		return pageCursor{}, errors.New("cursor has no sort values")
+	}
+	return cursor, nil
+}
+
+func (p *ElasticProvider) signCursor(payload string) string {
+	mac := hmac.New(sha256.New, []byte(p.cfg.CursorSecret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// queryHash fingerprints the parts of a query that select and order its
+// results. Limits and positions (cursor, offset) are left out so that every
+// page of one query shares a hash.
+func queryHash(query schema.LogQuery) string {
+	metadata := make(map[string]any, len(query.Metadata))
+	for key, value := range query.Metadata {
+		if key != QueryOptionCursor && key != QueryOptionOffset {
+			metadata[key] = value
+		}
+	}
+	encoded, _ := json.Marshal(struct {
+		Expression *schema.LogExpression `json:"expression,omitempty"`
+		Start      time.Time             `json:"start"`
+		End        time.Time             `json:"end"`
+		Scope      schema.QueryScope     `json:"scope"`
+		Metadata   map[string]any        `json:"metadata"`
+	}{query.Expression, query.Start.UTC(), query.End.UTC(), query.Scope, metadata})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+// queryCursor returns the cursor requested through QueryOptionCursor, or nil
+// when the query starts from the first page. Cursors issued for another
+// index pattern or another query are rejected.
+func (p *ElasticProvider) queryCursor(query schema.LogQuery) (*pageCursor, error) {
+	value, ok := query.Metadata[QueryOptionCursor]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s: must be a string", QueryOptionCursor)
+	}
+	if s == "" {
+		return nil, nil
+	}
+	cursor, err := p.decodeCursor(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", QueryOptionCursor, err)
+	}
+	if cursor.Index != p.cfg.IndexPattern {
+		return nil, fmt.Errorf("invalid %s: issued for index pattern %q", QueryOptionCursor, cursor.Index)
+	}
+	if cursor.QueryHash != queryHash(query) {
+		return nil, fmt.Errorf("invalid %s: issued for a different query", QueryOptionCursor)
+	}
+	return &cursor, nil
+}
+
+// ErrResultWindowExceeded is returned when an offset reaches past the
+// index's max_result_window. Deeper results need cursor pagination. It is
+// in ErrTooLarge.
+var ErrResultWindowExceeded error = &categoryError{
+	err:      errors.New("offset pagination exceeds max_result_window; use _cursor pagination to read further"),
+	category: ErrTooLarge,
+}
+
+// defaultMaxResultWindow matches Elasticsearch's index.max_result_window.
+const defaultMaxResultWindow = 10000
+
+// queryOffset returns the number of entries to skip requested through
+// QueryOptionOffset.
+func queryOffset(query schema.LogQuery) (int, error) {
+	value, ok := query.Metadata[QueryOptionOffset]
+	if !ok || value == nil {
+		return 0, nil
+	}
+	offset, ok := intValue(value)
+	if !ok || offset < 0 {
+		return 0, fmt.Errorf("invalid %s %v: must be a non-negative integer", QueryOptionOffset, value)
+	}
+	return offset, nil
+}
+
+func (p *ElasticProvider) maxResultWindow() int {
+	if p.cfg.MaxResultWindow > 0 {
+		return p.cfg.MaxResultWindow
+	}
+	return defaultMaxResultWindow
+}
+
+// validateOffset checks that an offset query stays within the result window.
+// Only the first request uses from; later pages resume with search_after.
+func (p *ElasticProvider) validateOffset(query schema.LogQuery) error {
+	offset, err := queryOffset(query)
+	if err != nil || offset == 0 {
+		return err
+	}
+	if cursor, _ := p.queryCursor(query); cursor != nil {
+		return fmt.Errorf("%s cannot be combined with %s", QueryOptionOffset, QueryOptionCursor)
+	}
+
+	window := p.maxResultWindow()
+	size := min(p.querySize(query), p.pageSize())
+	if offset >= window || (query.Limit > 0 && offset+size > window) {
+		return fmt.Errorf("%w: from %d + size %d > %d", ErrResultWindowExceeded, offset, size, window)
+	}
+	return nil
+}
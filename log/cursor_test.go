@@ -0,0 +1,505 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	sortValues := []any{json.Number("1696161600123"), json.Number("9007199254740993"), "id-7"}
+	query := schema.LogQuery{Expression: &schema.LogExpression{Search: "error"}, Metadata: map[string]any{}}
+
+	for _, secret := range []string{"", "s3cret"} {
+		p := &ElasticProvider{cfg: Config{IndexPattern: "logs-*", CursorSecret: secret}}
+		cursor, err := p.encodeCursor(query, pageCursor{PIT: "pit-1", After: sortValues})
+		if err != nil {
+			t.Fatalf("encodeCursor failed: %v", err)
+		}
+		if signed := strings.Contains(cursor, "."); signed != (secret != "") {
+			t.Errorf("cursor %q signed = %v, want %v", cursor, signed, secret != "")
+		}
+
+		query.Metadata[QueryOptionCursor] = cursor
+		decoded, err := p.queryCursor(query)
+		if err != nil {
+			t.Fatalf("queryCursor failed: %v", err)
+		}
+		if decoded.Version != cursorVersion || decoded.Index != "logs-*" {
+			t.Errorf("decoded = %+v, want the current version and index pattern", decoded)
+		}
+	}
+
+	p := &ElasticProvider{}
+	cursor, err := p.encodeCursor(query, pageCursor{PIT: "pit-1", After: sortValues})
+	if err != nil {
+		t.Fatalf("encodeCursor failed: %v", err)
+	}
+	decoded, err := p.decodeCursor(cursor)
+	if err != nil {
+		t.Fatalf("decodeCursor failed: %v", err)
+	}
+	if decoded.PIT != "pit-1" {
+		t.Errorf("decoded PIT = %q, want pit-1", decoded.PIT)
+	}
+	if len(decoded.After) != len(sortValues) {
+		t.Fatalf("decoded = %#v, want %#v", decoded.After, sortValues)
+	}
+	for i := range sortValues {
+		if decoded.After[i] != sortValues[i] {
+			t.Errorf("decoded[%d] = %#v, want %#v", i, decoded.After[i], sortValues[i])
+		}
+	}
+}
+
+func TestValidateQueryRejectsBadCursor(t *testing.T) {
+	tests := map[string]any{
+		"not base64":     "!!!",
+		"not an object":  "W10",
+		"wrong version":  "eyJ2IjowLCJhZnRlciI6WzFdfQ",
+		"no sort values": "eyJ2IjoxfQ",
+		"not a string":   42,
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := (&ElasticProvider{}).validateQuery(schema.LogQuery{Metadata: map[string]any{QueryOptionCursor: cursor}})
+			if err == nil || !strings.Contains(err.Error(), QueryOptionCursor) {
+				t.Errorf("err = %v, want invalid cursor error", err)
+			}
+		})
+	}
+}
+
+func TestCursorTamperDetection(t *testing.T) {
+	query := schema.LogQuery{Metadata: map[string]any{}}
+	p := &ElasticProvider{cfg: Config{IndexPattern: "logs-*", CursorSecret: "s3cret"}}
+	cursor, err := p.encodeCursor(query, pageCursor{After: []any{json.Number("2000"), json.Number("2")}})
+	if err != nil {
+		t.Fatalf("encodeCursor failed: %v", err)
+	}
+	payload, signature, _ := strings.Cut(cursor, ".")
+
+	// Forge a payload that skips ahead, keeping the original signature
+	forged, _ := (&ElasticProvider{cfg: Config{IndexPattern: "logs-*"}}).encodeCursor(query, pageCursor{After: []any{json.Number("1000"), json.Number("1")}})
+
+	tests := map[string]string{
+		"forged payload":  forged + "." + signature,
+		"unsigned":        payload,
+		"wrong signature": payload + "." + strings.Repeat("A", len(signature)),
+		"other secret":    mustEncodeCursor(t, &ElasticProvider{cfg: Config{IndexPattern: "logs-*", CursorSecret: "other"}}, query),
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			query := schema.LogQuery{Metadata: map[string]any{QueryOptionCursor: token}}
+			if err := p.validateQuery(query); err == nil || !strings.Contains(err.Error(), "sign") {
+				t.Errorf("err = %v, want a signature error", err)
+			}
+		})
+	}
+}
+
+func TestCursorQueryMismatch(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{IndexPattern: "logs-*"}}
+	original := schema.LogQuery{
+		Expression: &schema.LogExpression{Search: "error"},
+		Scope:      schema.QueryScope{Service: "checkout"},
+		Metadata:   map[string]any{},
+	}
+	cursor := mustEncodeCursor(t, p, original)
+
+	same := original
+	same.Limit = 500
+	same.Metadata = map[string]any{QueryOptionCursor: cursor}
+	if err := p.validateQuery(same); err != nil {
+		t.Errorf("same query with another limit: err = %v, want none", err)
+	}
+
+	tests := map[string]func(q *schema.LogQuery){
+		"other search": func(q *schema.LogQuery) { q.Expression = &schema.LogExpression{Search: "warn"} },
+		"other scope":  func(q *schema.LogQuery) { q.Scope.Service = "payments" },
+		"other filter": func(q *schema.LogQuery) { q.Metadata["team"] = "core" },
+		"other order":  func(q *schema.LogQuery) { q.Metadata[QueryOptionOrder] = "asc" },
+		"other window": func(q *schema.LogQuery) { q.Start = time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC) },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			query := original
+			query.Metadata = map[string]any{QueryOptionCursor: cursor}
+			change(&query)
+			if err := p.validateQuery(query); err == nil || !strings.Contains(err.Error(), "different query") {
+				t.Errorf("err = %v, want a query mismatch", err)
+			}
+		})
+	}
+
+	other := &ElasticProvider{cfg: Config{IndexPattern: "metrics-*"}}
+	if err := other.validateQuery(same); err == nil || !strings.Contains(err.Error(), "index pattern") {
+		t.Errorf("err = %v, want an index pattern mismatch", err)
+	}
+}
+
+// mustEncodeCursor issues a cursor for query at sort values [1].
+func mustEncodeCursor(t *testing.T, p *ElasticProvider, query schema.LogQuery) string {
+	t.Helper()
+	cursor, err := p.encodeCursor(query, pageCursor{After: []any{json.Number("1")}})
+	if err != nil {
+		t.Fatalf("encodeCursor failed: %v", err)
+	}
+	return cursor
+}
+
+func TestQueryCursorWalksPages(t *testing.T) {
+	// Seven documents, newest first by (timestamp, doc). Documents 5 and 4
+	// share a timestamp and straddle the first page boundary.
+	type doc struct {
+		millis int64
+		doc    int
+	}
+	docs := []doc{
+		{1696161607000, 7}, {1696161606000, 6}, {1696161604000, 5},
+		{1696161604000, 4}, {1696161603000, 3}, {1696161602000, 2}, {1696161601000, 1},
+	}
+
+	// The handler plays the server: it applies search_after against the
+	// (timestamp, _doc) descending sort and returns size hits with sort values.
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		var body struct {
+			Size        int     `json:"size"`
+			SearchAfter []int64 `json:"search_after"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		hits := []string{}
+		for _, d := range docs {
+			if body.SearchAfter != nil {
+				after := d.millis < body.SearchAfter[0] || (d.millis == body.SearchAfter[0] && int64(d.doc) < body.SearchAfter[1])
+				if !after {
+					continue
+				}
+			}
+			if len(hits) == body.Size {
+				break
+			}
+			hits = append(hits, fmt.Sprintf(`{"_id":"doc-%d","_source":{"message":"doc-%d"},"sort":[%d,%d]}`, d.doc, d.doc, d.millis, d.doc))
+		}
+		return 200, `{"hits":{"total":{"value":7,"relation":"eq"},"hits":[` + strings.Join(hits, ",") + `]}}`
+	})
+
+	var seen []string
+	var pageSizes []int
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		query := schema.LogQuery{Limit: 3, Metadata: map[string]any{}}
+		if cursor != "" {
+			query.Metadata[QueryOptionCursor] = cursor
+		}
+		entries, stats, err := p.QueryWithStats(context.Background(), query)
+		if err != nil {
+			t.Fatalf("page %d: query failed: %v", page, err)
+		}
+		pageSizes = append(pageSizes, len(entries))
+		for _, entry := range entries {
+			seen = append(seen, entry.Message)
+		}
+		if stats.NextCursor != "" && entries[len(entries)-1].Metadata[MetadataNextCursor] != stats.NextCursor {
+			t.Errorf("page %d: last entry cursor = %v, want %s", page, entries[len(entries)-1].Metadata[MetadataNextCursor], stats.NextCursor)
+		}
+		if stats.NextCursor == "" {
+			break
+		}
+		cursor = stats.NextCursor
+	}
+
+	if fmt.Sprint(pageSizes) != "[3 3 1]" {
+		t.Errorf("page sizes = %v, want [3 3 1]", pageSizes)
+	}
+	want := []string{"doc-7", "doc-6", "doc-5", "doc-4", "doc-3", "doc-2", "doc-1"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("entries = %v, want %v without overlap or gaps", seen, want)
+	}
+
+	requests := transport.recorded()
+	if len(requests) != 3 {
+		t.Fatalf("requests = %d, want 3", len(requests))
+	}
+	if strings.Contains(requests[0].Body, "search_after") {
+		t.Errorf("first page body = %s, want no search_after", requests[0].Body)
+	}
+	if !strings.Contains(requests[1].Body, `"search_after":[1696161604000,5]`) {
+		t.Errorf("second page body = %s, want search_after from the last hit", requests[1].Body)
+	}
+}
+
+// searchAfterServer plays Elasticsearch over n documents with descending
+// sort values [n..1], honoring size and search_after.
+func searchAfterServer(t *testing.T, n int) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		if req.Path == "/" {
+			return 200, `{"version":{"number":"8.11.1"}}`
+		}
+		var body struct {
+			Size        int     `json:"size"`
+			SearchAfter []int64 `json:"search_after"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		next := n
+		if body.SearchAfter != nil {
+			next = int(body.SearchAfter[0]) - 1
+		}
+		hits := []string{}
+		for doc := next; doc > 0 && len(hits) < body.Size; doc-- {
+			hits = append(hits, fmt.Sprintf(`{"_id":"doc-%d","_source":{"message":"doc-%d"},"sort":[%d]}`, doc, doc, doc))
+		}
+		return 200, fmt.Sprintf(`{"took":5,"hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, n, strings.Join(hits, ","))
+	}
+}
+
+type pageRequest struct {
+	Size        int     `json:"size"`
+	SearchAfter []int64 `json:"search_after"`
+}
+
+// searchRequests drops requests other than searches, such as the cluster
+// version lookup.
+func searchRequests(requests []recordedRequest) []recordedRequest {
+	out := make([]recordedRequest, 0, len(requests))
+	for _, req := range requests {
+		if strings.HasSuffix(req.Path, "/_search") {
+			out = append(out, req)
+		}
+	}
+	return out
+}
+
+func pageRequests(t *testing.T, requests []recordedRequest) []pageRequest {
+	t.Helper()
+	out := make([]pageRequest, 0, len(requests))
+	for _, req := range requests {
+		var page pageRequest
+		if err := json.Unmarshal([]byte(req.Body), &page); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+		out = append(out, page)
+	}
+	return out
+}
+
+func TestQueryDeepPagination(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4}, searchAfterServer(t, 12))
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	if len(entries) != 10 {
+		t.Fatalf("entries = %d, want 10", len(entries))
+	}
+	for i, entry := range entries {
+		if want := fmt.Sprintf("doc-%d", 12-i); entry.Message != want {
+			t.Errorf("entries[%d] = %s, want %s", i, entry.Message, want)
+		}
+	}
+
+	pages := pageRequests(t, searchRequests(transport.recorded()))
+	if got := fmt.Sprint(pages); got != "[{4 []} {4 [9]} {2 [5]}]" {
+		t.Errorf("pages = %s, want sizes 4, 4, 2 resuming after docs 9 and 5", got)
+	}
+	if stats.TotalHits != 12 || stats.TookMillis != 15 {
+		t.Errorf("stats = %+v, want totals from the first page and summed took", stats)
+	}
+	if stats.NextCursor == "" || entries[9].Metadata[MetadataNextCursor] != stats.NextCursor {
+		t.Errorf("next cursor = %q, want one for the remaining documents on the last entry", stats.NextCursor)
+	}
+	for _, entry := range entries[:9] {
+		if _, ok := entry.Metadata[MetadataNextCursor]; ok {
+			t.Errorf("entry %s carries a cursor, want only the last entry", entry.Message)
+		}
+	}
+}
+
+func TestQueryDeepPaginationExhausted(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4}, searchAfterServer(t, 6))
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(entries) != 6 || stats.NextCursor != "" {
+		t.Errorf("entries = %d, cursor %q; want 6 and no cursor", len(entries), stats.NextCursor)
+	}
+	if len(searchRequests(transport.recorded())) != 2 {
+		t.Errorf("requests = %d, want 2", len(searchRequests(transport.recorded())))
+	}
+}
+
+func TestQueryDeepPaginationMaxPages(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4, MaxPages: 2}, searchAfterServer(t, 12))
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(entries) != 8 || len(searchRequests(transport.recorded())) != 2 {
+		t.Errorf("entries = %d over %d requests, want 8 over 2", len(entries), len(searchRequests(transport.recorded())))
+	}
+	if stats.NextCursor == "" {
+		t.Error("next cursor is empty, want one to resume after the cap")
+	}
+}
+
+func TestQueryDeepPaginationMemoryCap(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4, MaxEntriesInMemory: 6}, searchAfterServer(t, 12))
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	pages := pageRequests(t, searchRequests(transport.recorded()))
+	if len(entries) != 6 || fmt.Sprint(pages) != "[{4 []} {2 [9]}]" {
+		t.Errorf("entries = %d over pages %v, want 6 over pages of 4 and 2", len(entries), pages)
+	}
+	if !stats.Truncated || stats.NextCursor == "" {
+		t.Errorf("stats = %+v, want it truncated with a cursor to continue", stats)
+	}
+	if last := entries[5].Metadata; last[MetadataTruncated] != true || last[MetadataNextCursor] != stats.NextCursor {
+		t.Errorf("last entry metadata = %v, want it flagged truncated with the cursor", last)
+	}
+
+	// Asking for no more than the cap, or running out first, is not cut short
+	for _, tt := range []struct{ limit, docs int }{{6, 12}, {10, 5}} {
+		p, _ := newTestProvider(t, Config{PageSize: 4, MaxEntriesInMemory: 6}, searchAfterServer(t, tt.docs))
+		entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: tt.limit})
+		if err != nil || stats.Truncated || entries[len(entries)-1].Metadata[MetadataTruncated] != nil {
+			t.Errorf("limit %d of %d docs: stats = %+v, err = %v; want it not truncated", tt.limit, tt.docs, stats, err)
+		}
+	}
+}
+
+func TestQueryDeepPaginationCapacity(t *testing.T) {
+	p, _ := newTestProvider(t, Config{PageSize: 4, MaxPages: 1000}, searchAfterServer(t, 10))
+
+	entries, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 1000000})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	// Sized from the first page's total, not the limit
+	if len(entries) != 10 || cap(entries) > 16 {
+		t.Errorf("entries = %d with capacity %d, want 10 in about as much room", len(entries), cap(entries))
+	}
+}
+
+func TestQueryDeepPaginationCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serve := searchAfterServer(t, 12)
+	p, transport := newTestProvider(t, Config{PageSize: 4}, func(req recordedRequest) (int, string) {
+		cancel()
+		return serve(req)
+	})
+
+	if _, _, err := p.QueryWithStats(ctx, schema.LogQuery{Limit: 10}); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if len(searchRequests(transport.recorded())) != 1 {
+		t.Errorf("requests = %d, want no pages after cancellation", len(searchRequests(transport.recorded())))
+	}
+}
+
+func TestBuildQueryOffset(t *testing.T) {
+	p := &ElasticProvider{}
+
+	esQuery := p.buildQuery(schema.LogQuery{Limit: 50, Metadata: map[string]any{QueryOptionOffset: float64(50)}})
+	if esQuery["from"] != 50 || esQuery["size"] != 50 {
+		t.Errorf("from = %v, size = %v; want 50 and 50", esQuery["from"], esQuery["size"])
+	}
+	if strings.Contains(fmt.Sprint(esQuery["query"]), QueryOptionOffset) {
+		t.Errorf("query = %v, reserved %s key must not become a filter", esQuery["query"], QueryOptionOffset)
+	}
+
+	if esQuery := p.buildQuery(schema.LogQuery{Limit: 50}); esQuery["from"] != nil {
+		t.Errorf("from = %v, want none without an offset", esQuery["from"])
+	}
+}
+
+func TestValidateQueryOffset(t *testing.T) {
+	cursor := mustEncodeCursor(t, &ElasticProvider{}, schema.LogQuery{Limit: 10, Metadata: map[string]any{QueryOptionOffset: 10}})
+
+	tests := []struct {
+		name      string
+		limit     int
+		offset    any
+		cursor    string
+		wantErr   string
+		wantSize  int
+		overLimit bool
+	}{
+		{name: "valid offset", limit: 50, offset: 100, wantSize: 50},
+		{name: "boundary", limit: 10, offset: 9990, wantSize: 10},
+		{name: "over the window", limit: 11, offset: 9990, overLimit: true},
+		{name: "offset at the window", offset: 10000, overLimit: true},
+		{name: "default size trimmed to the window", offset: 9500, wantSize: 500},
+		{name: "negative", offset: -1, wantErr: "non-negative"},
+		{name: "not a number", offset: "ten", wantErr: "non-negative"},
+		{name: "combined with a cursor", limit: 10, offset: 10, cursor: cursor, wantErr: "cannot be combined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ElasticProvider{}
+			query := schema.LogQuery{Limit: tt.limit, Metadata: map[string]any{QueryOptionOffset: tt.offset}}
+			if tt.cursor != "" {
+				query.Metadata[QueryOptionCursor] = tt.cursor
+			}
+
+			err := p.validateQuery(query)
+			switch {
+			case tt.overLimit:
+				if !errors.Is(err, ErrResultWindowExceeded) {
+					t.Errorf("err = %v, want ErrResultWindowExceeded", err)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("err = %v, want none", err)
+				}
+				if size := p.buildQuery(query)["size"]; size != tt.wantSize {
+					t.Errorf("size = %v, want %d", size, tt.wantSize)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryOffsetOnlyOnFirstPage(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4}, searchAfterServer(t, 20))
+
+	_, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 8, Metadata: map[string]any{QueryOptionOffset: 2}})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	requests := searchRequests(transport.recorded())
+	if len(requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(requests))
+	}
+	if !strings.Contains(requests[0].Body, `"from":2`) {
+		t.Errorf("first page body = %s, want from", requests[0].Body)
+	}
+	if strings.Contains(requests[1].Body, `"from"`) || !strings.Contains(requests[1].Body, "search_after") {
+		t.Errorf("second page body = %s, want search_after without from", requests[1].Body)
+	}
+}
@@ -0,0 +1,155 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// streamedPage is a search response whose hits were normalized as they were
+// decoded, so Hits.Hits is left empty. hits counts them, and lastSort is the
+// sort values of the last one, for the next page's cursor.
+type streamedPage struct {
+	esSearchResponse
+	hits     int
+	lastSort []any
+}
+
+// streamResult decodes a search response body, appending each hit to
+// entries as soon as it is decoded rather than holding every hit of the
+// response first. It returns the same entries as decodeSearchResponse
+// followed by appendResult.
+func (p *ElasticProvider) streamResult(ctx context.Context, entries []schema.LogEntry, body io.Reader) ([]schema.LogEntry, streamedPage, error) {
+	var page streamedPage
+	var indices []esHit
+	start := len(entries)
+	result, err := streamSearchResponse(body, func(hit *esHit) {
+		entries = append(entries, normalizeHit(p, *hit))
+		page.hits++
+		page.lastSort = hit.Sort
+		if p.cfg.ResolveIndexTier {
+			indices = append(indices, esHit{Index: hit.Index})
+		}
+	})
+	if err != nil {
+		return entries[:start], streamedPage{}, err
+	}
+	page.esSearchResponse = result
+
+	// The total is only known for certain once the whole body is read
+	for _, entry := range entries[start:] {
+		entry.Metadata[MetadataTotalHits] = result.Hits.Total.Value
+		if result.Hits.Total.Relation != "" {
+			entry.Metadata[MetadataTotalHitsRelation] = result.Hits.Total.Relation
+		}
+	}
+	if p.cfg.ResolveIndexTier {
+		p.annotateIndexTiers(ctx, indices, entries[start:])
+	}
+	return entries, page, nil
+}
+
+// streamSearchResponse decodes a search response body like
+// decodeSearchResponse, except that each hit is passed to onHit as it is
+// decoded instead of being kept in Hits.Hits. The hit is reused for the
+// next one, its _source map cleared rather than allocated again, so onHit
+// must not keep it or the map.
+func streamSearchResponse(body io.Reader, onHit func(hit *esHit)) (esSearchResponse, error) {
+	var result esSearchResponse
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "pit_id":
+			return dec.Decode(&result.PITID)
+		case "_scroll_id":
+			return dec.Decode(&result.ScrollID)
+		case "took":
+			return dec.Decode(&result.Took)
+		case "timed_out":
+			return dec.Decode(&result.TimedOut)
+		case "_shards":
+			return dec.Decode(&result.Shards)
+		case "hits":
+			return decodeObject(dec, func(key string) error {
+				switch key {
+				case "total":
+					return dec.Decode(&result.Hits.Total)
+				case "hits":
+					return decodeHits(dec, onHit)
+				}
+				return skipValue(dec)
+			})
+		}
+		return skipValue(dec)
+	})
+	if err != nil {
+		return esSearchResponse{}, err
+	}
+	return result, nil
+}
+
+// decodeHits decodes an array of hits, passing each to onHit.
+func decodeHits(dec *json.Decoder, onHit func(hit *esHit)) error {
+	if open, err := openValue(dec, '['); err != nil || !open {
+		return err
+	}
+	var hit esHit
+	for dec.More() {
+		source := hit.Source
+		clear(source)
+		hit = esHit{Source: source}
+		if err := dec.Decode(&hit); err != nil {
+			return err
+		}
+		onHit(&hit)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// decodeObject walks a JSON object, calling field with each key; field must
+// decode or skip the key's value. A null object has no keys.
+func decodeObject(dec *json.Decoder, field func(key string) error) error {
+	if open, err := openValue(dec, '{'); err != nil || !open {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// openValue reads the opening delimiter of an object or array, reporting
+// false when the value is null instead.
+func openValue(dec *json.Decoder, delim json.Delim) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	if tok == nil {
+		return false, nil
+	}
+	if tok != delim {
+		return false, fmt.Errorf("expected %s, got %v", delim, tok)
+	}
+	return true, nil
+}
+
+// skipValue reads past the next value, such as aggregations in a response
+// whose hits are all that is wanted.
+func skipValue(dec *json.Decoder) error {
+	var skipped json.RawMessage
+	return dec.Decode(&skipped)
+}
@@ -0,0 +1,133 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// cannedSearchBody returns a search response with n hits of a typical
+// nested ECS document.
+func cannedSearchBody(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"took":12,"timed_out":false,"_shards":{"total":3,"successful":2,"skipped":0,"failed":1,"failures":[{"index":"logs-a","shard":1,"reason":{"type":"query_shard_exception","reason":"boom"}}]},`)
+	buf.WriteString(`"hits":{"total":{"value":12345,"relation":"gte"},"max_score":null,"hits":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"_index":".ds-logs-app-2024.01.01-%06d","_id":"doc-%d","_score":null,"_version":%d,"_source":{`+
+			`"@timestamp":"2024-01-01T00:00:%02d.123Z","message":"GET /api/orders/%d 200","level":"info","service":"checkout",`+
+			`"trace":{"id":"trace-%d"},"http":{"request":{"method":"GET"},"response":{"status_code":200,"bytes":%d}},`+
+			`"host":{"name":"web-%d"},"customer_id":9007199254740993,"tags":["a","b"]},"sort":[%d,"doc-%d"]}`,
+			i%3+1, i, i, i%60, i, i, i*17, i%8, 1704067200000-i, i)
+	}
+	buf.WriteString(`]},"aggregations":{"levels":{"buckets":[{"key":"info","doc_count":3}]}}}`)
+	return buf.Bytes()
+}
+
+func TestStreamResultMatchesDecode(t *testing.T) {
+	body := cannedSearchBody(50)
+	for _, cfg := range []Config{
+		{},
+		{EntryMetadataLevel: metadataLevelMinimal, RedactFields: []string{"host.*"}, DropFields: []string{"tags"}},
+	} {
+		p := &ElasticProvider{cfg: cfg, redact: newFieldMatcher(cfg.RedactFields)}
+
+		result, err := decodeSearchResponse(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		want := p.appendResult(context.Background(), nil, result)
+
+		got, page, err := p.streamResult(context.Background(), nil, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to stream response: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("streamed entries differ from decoded ones:\n got %v\nwant %v", got[0], want[0])
+		}
+		if !reflect.DeepEqual(page.stats(), result.stats()) {
+			t.Errorf("stats = %+v, want %+v", page.stats(), result.stats())
+		}
+		last := result.Hits.Hits[len(result.Hits.Hits)-1]
+		if page.hits != 50 || !reflect.DeepEqual(page.lastSort, last.Sort) {
+			t.Errorf("hits = %d, lastSort = %v; want 50 and %v", page.hits, page.lastSort, last.Sort)
+		}
+	}
+}
+
+func TestStreamSearchResponseEdges(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		hits    int
+		wantErr bool
+	}{
+		{name: "no hits", body: `{"took":1,"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`},
+		{name: "null hits", body: `{"took":1,"hits":null}`},
+		{name: "null source", body: `{"hits":{"hits":[{"_id":"1","_source":null},{"_id":"2","_source":{"a":1}}]}}`, hits: 2},
+		{name: "hits before total", body: `{"hits":{"hits":[{"_id":"1"}],"total":{"value":1}}}`, hits: 1},
+		{name: "truncated", body: `{"hits":{"hits":[{"_id":"1"},`, wantErr: true},
+		{name: "not an object", body: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int
+			_, err := streamSearchResponse(strings.NewReader(tt.body), func(hit *esHit) { hits++ })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && hits != tt.hits {
+				t.Errorf("hits = %d, want %d", hits, tt.hits)
+			}
+		})
+	}
+}
+
+func TestStreamResultReusedSourceNotShared(t *testing.T) {
+	body := `{"hits":{"total":{"value":2},"hits":[{"_id":"1","_source":{"a":"one","b":"only"}},{"_id":"2","_source":{"a":"two"}}]}}`
+	entries, _, err := (&ElasticProvider{}).streamResult(context.Background(), nil, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to stream response: %v", err)
+	}
+	if entries[0].Fields["a"] != "one" || entries[0].Fields["b"] != "only" {
+		t.Errorf("first fields = %v, want them kept after the next hit", entries[0].Fields)
+	}
+	if _, ok := entries[1].Fields["b"]; ok || entries[1].Fields["a"] != "two" {
+		t.Errorf("second fields = %v, want none of the first hit's", entries[1].Fields)
+	}
+}
+
+// BenchmarkDecodeResponse compares decoding a 10k-hit response whole and
+// then normalizing it with normalizing each hit as it is decoded.
+func BenchmarkDecodeResponse(b *testing.B) {
+	body := cannedSearchBody(10000)
+	p := &ElasticProvider{}
+
+	b.Run("whole", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			result, err := decodeSearchResponse(bytes.NewReader(body))
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = p.appendResult(context.Background(), make([]schema.LogEntry, 0, 10000), result)
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			if _, _, err := p.streamResult(context.Background(), make([]schema.LogEntry, 0, 10000), bytes.NewReader(body)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,14 +1,29 @@
 package log
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 	corelog "github.com/opsorch/opsorch-core/log"
 	"github.com/opsorch/opsorch-core/schema"
 )
@@ -16,6 +31,153 @@ import (
 // ProviderName is the registry key for the Elasticsearch adapter.
 const ProviderName = "elastic"
 
+// defaultLimit is the result size used when neither the query nor the config sets one.
+const defaultLimit = 1000
+
+// defaultMaxSearchLength caps the size in bytes of a full-text search
+// expression when no maxSearchLength is configured.
+const defaultMaxSearchLength = 32 * 1024
+
+// errorPreviewBytes bounds how much of a user-supplied value is echoed in errors.
+const errorPreviewBytes = 64
+
+// maxScriptLength caps the size in bytes of a script filter's source.
+const maxScriptLength = 4096
+
+// stderr receives operational warnings; tests replace it to capture output.
+var stderr io.Writer = os.Stderr
+
+// defaultLabelFields are the low-cardinality fields copied into entry Labels
+// when no labelFields allowlist is configured.
+var defaultLabelFields = []string{
+	"host.name",
+	"kubernetes.pod.name",
+	"kubernetes.namespace",
+	"container.name",
+	"environment",
+	"team",
+}
+
+// defaultTraceIDFields and defaultSpanIDFields cover flat, ECS, and OTEL
+// naming of tracing IDs.
+var (
+	defaultTraceIDFields = []string{"trace_id", "trace.id", "traceId"}
+	defaultSpanIDFields  = []string{"span_id", "span.id", "spanId"}
+)
+
+// defaultMessageFields are consulted in order for the entry message.
+var defaultMessageFields = []string{"message", "msg", "log", "event.original"}
+
+// defaultTiebreakerField breaks timestamp ties in the sort. Deployments with a
+// unique keyword field (such as event.id) should configure it instead.
+const defaultTiebreakerField = "_doc"
+
+// defaultMaxFieldBytes caps string field values when no maxFieldBytes is configured.
+const defaultMaxFieldBytes = 32 * 1024
+
+// defaultFlattenDepth bounds how many levels of nested objects are flattened
+// into dotted keys when no flattenDepth is configured.
+const defaultFlattenDepth = 5
+
+// Entry metadata keys set by the adapter.
+const (
+	// MetadataTotalHits carries the total number of documents matching the
+	// query, which may exceed the number of returned entries.
+	MetadataTotalHits = "total_hits"
+	// MetadataTotalHitsRelation is "eq" when MetadataTotalHits is exact and
+	// "gte" when it is a lower bound.
+	MetadataTotalHitsRelation = "total_hits_relation"
+	// MetadataRawTimestamp carries the original timestamp value when it
+	// could not be parsed.
+	MetadataRawTimestamp = "raw_timestamp"
+	// MetadataTraceID and MetadataSpanID carry the distributed tracing IDs
+	// found in the document, for correlating logs with traces.
+	MetadataTraceID = "trace_id"
+	MetadataSpanID  = "span_id"
+	// MetadataTruncatedFields lists fields shortened to maxFieldBytes and
+	// MetadataDroppedFields lists fields removed to honor maxEntryBytes.
+	MetadataTruncatedFields = "truncated_fields"
+	MetadataDroppedFields   = "dropped_fields"
+	// MetadataRepeatCount is set on entries standing in for a run of
+	// identical consecutive log lines when dedupeResults is enabled, along
+	// with the timestamps of the oldest and newest lines in the run.
+	MetadataRepeatCount     = "repeat_count"
+	MetadataRepeatFirstSeen = "repeat_first_seen"
+	MetadataRepeatLastSeen  = "repeat_last_seen"
+	// MetadataDataStream and MetadataBackingIndexGeneration describe the
+	// data stream backing index a hit came from; MetadataIndexTier is its
+	// data tier (hot, warm, cold, frozen) when resolveIndexTier is set.
+	MetadataDataStream             = "data_stream"
+	MetadataBackingIndexGeneration = "backing_index_generation"
+	MetadataIndexTier              = "index_tier"
+	// MetadataSort carries the hit's sort values, which a follow-up query
+	// can pass to search_after to resume after this entry.
+	MetadataSort = "sort"
+	// MetadataNextCursor is set on the last entry of a full page and holds
+	// the cursor for the next page.
+	MetadataNextCursor = "next_cursor"
+	// MetadataTruncated is set to true on the last entry of a query that
+	// stopped at MaxEntriesInMemory with more results left.
+	MetadataTruncated = "truncated"
+)
+
+// Reserved query metadata keys. They tune query execution and are never
+// turned into term filters.
+const (
+	// QueryOptionExactTotals requests an exact total hit count for one query.
+	QueryOptionExactTotals = "_exactTotals"
+	// QueryOptionOrder sets the result order by timestamp: "desc" (default,
+	// newest first) or "asc" (oldest first).
+	QueryOptionOrder = "_order"
+	// QueryOptionCursor resumes a query after the page that returned the
+	// cursor; pass QueryStats.NextCursor or MetadataNextCursor unchanged.
+	QueryOptionCursor = "_cursor"
+	// QueryOptionOffset skips that many entries (from/size pagination). It
+	// is limited to maxResultWindow; use QueryOptionCursor beyond that.
+	QueryOptionOffset = "_offset"
+	// QueryOptionBySeverity splits histogram buckets by severity.
+	QueryOptionBySeverity = "_bySeverity"
+	// QueryOptionSample returns a uniform random sample of about limit
+	// entries across the query window instead of the newest ones.
+	QueryOptionSample = "_sample"
+	// QueryOptionStrict fails a fanned-out query when the search of any of
+	// its index groups fails, instead of returning the others' results as
+	// partial.
+	QueryOptionStrict = "_strict"
+	// QueryOptionEndExclusive excludes End from the query window, so that a
+	// document at the boundary of windows tiled end to start is counted once.
+	QueryOptionEndExclusive = "_endExclusive"
+)
+
+var reservedMetadataKeys = map[string]bool{
+	QueryOptionExactTotals:  true,
+	QueryOptionOrder:        true,
+	QueryOptionCursor:       true,
+	QueryOptionOffset:       true,
+	QueryOptionBySeverity:   true,
+	QueryOptionSample:       true,
+	QueryOptionStrict:       true,
+	QueryOptionEndExclusive: true,
+}
+
+// Sort orders accepted by QueryOptionOrder.
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// defaultPageSize and defaultMaxPages bound deep pagination: limits above
+// the page size are fetched with search_after, up to maxPages requests.
+// defaultMaxEntriesInMemory bounds the entries those requests gather.
+const (
+	defaultPageSize           = 1000
+	defaultMaxPages           = 100
+	defaultMaxEntriesInMemory = 100000
+)
+
+// defaultTrackTotalHits bounds total hit counting unless exact totals are requested.
+const defaultTrackTotalHits = 10000
+
 // AdapterVersion and RequiresCore express compatibility.
 const (
 	AdapterVersion = "0.1.0"
@@ -30,6 +192,224 @@ type Config struct {
 	APIKey       string
 	CloudID      string
 	IndexPattern string
+	DefaultLimit int
+	FlattenDepth int
+	// MaxSearchLength is the maximum size in bytes of expression.search.
+	MaxSearchLength int
+	// ExactTotals counts every matching document instead of stopping at
+	// defaultTrackTotalHits.
+	ExactTotals bool
+	// AllowScriptFilters enables the "script" filter operator.
+	AllowScriptFilters bool
+	// AllowESQL enables QueryESQL.
+	AllowESQL bool
+	// LabelFields lists the fields copied into entry Labels. Nil means
+	// defaultLabelFields; an empty list disables labels.
+	LabelFields []string
+	// DropFields lists fields that are never returned.
+	DropFields []string
+	// RedactFields lists sensitive fields, as dotted paths or trailing
+	// wildcard patterns, that are removed or masked per RedactMode.
+	RedactFields []string
+	// RedactMode is "remove" (default) or "mask".
+	RedactMode string
+	// ScopeFields maps a scope ("service", "environment", "team") to the
+	// candidate document fields holding it, in precedence order.
+	ScopeFields map[string][]string
+	// ExactScopeMatch matches scope and metadata filters with term queries
+	// only. By default a string value also matches a text field as a
+	// phrase, or its keyword sub-field exactly.
+	ExactScopeMatch bool
+	// TimestampLayouts are extra time.Parse layouts tried after the built-in
+	// RFC 3339 and epoch formats.
+	TimestampLayouts []string
+	// TraceIDFields and SpanIDFields list candidate fields for tracing IDs,
+	// in precedence order.
+	TraceIDFields []string
+	SpanIDFields  []string
+	// MessageFields lists candidate fields for the entry message, in
+	// precedence order.
+	MessageFields []string
+	// MessageComposition builds the entry message from several fields, e.g.
+	// message followed by error.stack_trace. Absent parts are skipped.
+	MessageComposition []MessagePart
+	// IdentityFields maps canonical identity labels (host, pod, namespace,
+	// container, node) to candidate fields, overriding the defaults per label.
+	IdentityFields map[string][]string
+	// StringifyLabelValues renders numeric and boolean labelFields values as
+	// strings in Labels; Fields keep the typed value.
+	StringifyLabelValues bool
+	// TiebreakerField orders documents that share a timestamp.
+	TiebreakerField string
+	// MaxFieldBytes truncates longer string field values.
+	MaxFieldBytes int
+	// MaxEntryBytes, when set, drops fields from entries whose encoded size
+	// would exceed it.
+	MaxEntryBytes int
+	// DedupeResults collapses identical consecutive entries.
+	DedupeResults bool
+	// ResolveIndexTier annotates entries with their index's data tier.
+	ResolveIndexTier bool
+	// EntryMetadataLevel is "none", "minimal", or "full" (default).
+	EntryMetadataLevel string
+	// SeverityFields lists candidate fields for the entry severity, in
+	// precedence order.
+	SeverityFields []string
+	// SeverityNumberFields maps numeric severity fields to their scheme,
+	// "otel" or "syslog".
+	SeverityNumberFields map[string]string
+	// PointInTime pages through a point in time so results stay consistent
+	// while new documents arrive. PITKeepAlive is how long it stays open
+	// between pages (default "1m").
+	PointInTime  bool
+	PITKeepAlive string
+	// CursorSecret, when set, signs pagination cursors with HMAC-SHA256 and
+	// rejects unsigned or tampered ones.
+	CursorSecret string
+	// Pagination selects how multi-request queries page: "auto" (default),
+	// "search_after", "pit", or "scroll". ScrollTTL is how long a scroll
+	// stays open between batches.
+	Pagination string
+	ScrollTTL  time.Duration
+	// Parallelism splits streamed queries into that many scroll slices read
+	// concurrently. Ordered (default true) merges the slices by timestamp;
+	// when false, batches are delivered per slice as they arrive.
+	Parallelism int
+	Ordered     bool
+	// PageSize is the most entries fetched per search request; larger limits
+	// are fetched over several pages, at most MaxPages of them.
+	PageSize int
+	MaxPages int
+	// MaxEntriesInMemory caps the entries a query holds at once (default
+	// 100,000). A query reaching it stops fetching and returns what it has,
+	// with QueryStats.Truncated set. QueryStream is not capped.
+	MaxEntriesInMemory int
+	// MaxResultWindow is the index's max_result_window; _offset queries
+	// must stay within it.
+	MaxResultWindow int
+	// AsyncWaitTimeout is how long SubmitAsync and PollAsync wait for an
+	// async search to finish; AsyncKeepAlive is how long its results are kept.
+	AsyncWaitTimeout time.Duration
+	AsyncKeepAlive   time.Duration
+	// AllowedIndexPatterns limits the indices ListIndices reveals (default:
+	// IndexPattern). Patterns may use wildcards and "-" exclusions.
+	AllowedIndexPatterns []string
+	// TailInterval is how often Tail polls for new entries (default 2s).
+	// TailOverlap is how far each poll reaches back before the newest entry
+	// seen, to catch entries indexed late or stamped by a skewed clock
+	// (default 5s).
+	TailInterval time.Duration
+	TailOverlap  time.Duration
+	// FieldCacheTTL is how long ListFields results are reused (default 5m).
+	FieldCacheTTL time.Duration
+	// MaxBatchSize is the most queries one QueryBatch accepts (default 10).
+	MaxBatchSize int
+	// AllowWrites enables WriteEntries into indices matching
+	// WriteIndexPatterns. WriteBatchSize is how many entries one bulk
+	// request carries (default 500).
+	AllowWrites        bool
+	WriteIndexPatterns []string
+	WriteBatchSize     int
+	// SavedQueryIndex holds saved queries (default ".opsorch-saved-queries").
+	SavedQueryIndex string
+	// PercolateSavedQueries registers saved queries in PercolatorIndex
+	// (default ".opsorch-saved-query-percolator") for MatchSavedQueries.
+	PercolateSavedQueries bool
+	PercolatorIndex       string
+	// ValidateFields checks the fields a query filters on against the
+	// mapping before it runs: "warn" reports unknown and text-only fields
+	// in QueryStats.Warnings, "error" also rejects queries with unknown
+	// fields, and "off" (default) skips the check.
+	ValidateFields string
+	// SlowQueryThreshold records queries whose searches took at least this
+	// long, keeping the last SlowQueryBuffer (default 100) for SlowQueries,
+	// and gives them QueryStats.Hints. Zero (default) disables it.
+	SlowQueryThreshold time.Duration
+	SlowQueryBuffer    int
+	// AllowWatchManagement enables CreateWatch, ListWatches and
+	// DeleteWatch. Without a Watcher license, watches are Kibana rules
+	// created through KibanaURL with the cluster credentials.
+	AllowWatchManagement bool
+	KibanaURL            string
+	// ExportDir enables ExportToFile for paths inside it.
+	ExportDir string
+	// AuditLog records every request sent to Elasticsearch as JSON lines,
+	// to "stderr" or a file path. Files are rotated once they reach
+	// AuditMaxBytes (default 100 MiB). AuditMethods limits auditing to the
+	// listed methods (default all). RedactFields values are masked.
+	AuditLog      string
+	AuditMaxBytes int64
+	AuditMethods  []string
+	// Metering counts query volume per team and service for UsageStats.
+	// MeteringIndex, which implies Metering, also receives the counts as
+	// documents every MeteringInterval (default 1m).
+	Metering         bool
+	MeteringIndex    string
+	MeteringInterval time.Duration
+	// AssumeVersion skips version detection and takes the cluster to run
+	// this version, e.g. "8.11.1" or "opensearch:2.11.0".
+	AssumeVersion string
+	// RetryAttempts is how many times a failed search is sent in all before
+	// its error is returned (default 3, at most 10; zero or one never
+	// retries). The client's own retries are off while searches are. Retries
+	// wait RetryBaseDelay (default 100ms), doubling up to RetryMaxDelay
+	// (default 2s), each wait varied by up to RetryJitter (0 to 1, default
+	// 0.2) of itself. RetryOn lists the error categories retried (default
+	// ErrConnection); 429 responses are always retried and other 4xx
+	// responses never are.
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	RetryJitter    float64
+	RetryOn        []error
+	// CircuitBreakerThreshold is how many requests in a row may fail to
+	// reach the cluster before the rest fail at once with ErrCircuitOpen,
+	// for CircuitBreakerCooldown (default 30s) until one probes it again
+	// (default 5; zero disables the breaker).
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+	// RateLimit, when set, paces the searches, counts and aggregations
+	// sent to at most that many per second, with bursts of up to
+	// RateLimitBurst (default the rate rounded up). A request that would
+	// wait longer than RateLimitMaxWait (default 1s) for its turn fails at
+	// once with ErrRateLimited instead.
+	RateLimit        float64
+	RateLimitBurst   int
+	RateLimitMaxWait time.Duration
+	// QueryCacheSize, when set, keeps the results of that many recent
+	// queries for QueryCacheTTL (default 30s), keyed by the search they
+	// send, and answers the same query from them, marked with
+	// MetadataCached and QueryStats.Cached.
+	QueryCacheSize int
+	QueryCacheTTL  time.Duration
+	// FanOut, when IndexPattern lists several comma-separated patterns,
+	// sends a query as one search per pattern, FanOutConcurrency (default
+	// 4) at a time, and merges their entries by timestamp.
+	FanOut            bool
+	FanOutConcurrency int
+	// HealthProbeInterval, when set, checks the cluster's health that often
+	// in the background, keeping a connection warm and the circuit breaker
+	// current, and Health returns the last result at once.
+	HealthProbeInterval time.Duration
+	// MaxIdleConns (default 100) and MaxIdleConnsPerHost (default 10) bound
+	// the idle connections kept open to the cluster, for IdleConnTimeout
+	// (default 90s). MaxConnsPerHost, when set, bounds the connections open
+	// to each node; requests beyond it wait for one to be free.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	// ResponseCompression is the compression responses are asked for:
+	// "gzip" (default), "zstd", with a decoder passed with WithDecoder, or
+	// "none".
+	ResponseCompression string
+	// SkipStartupPing constructs the provider without first pinging the
+	// cluster. LazyConnect (default SkipStartupPing) connects on the first
+	// request instead, retrying in the background while the cluster cannot
+	// be reached; requests meanwhile fail at once with a
+	// DisconnectedError.
+	SkipStartupPing bool
+	LazyConnect     bool
 }
 
 // ElasticProvider implements the log.Provider interface for Elasticsearch.
@@ -37,10 +417,94 @@ type ElasticProvider struct {
 	cfg     Config
 	client  *elasticsearch.Client
 	baseURL string
+
+	// redact matches the fields listed in RedactFields.
+	redact fieldMatcher
+
+	// server caches the cluster version and distribution once detected.
+	versionMu sync.Mutex
+	server    *ServerInfo
+
+	// tierCache maps index names to their data tier when resolveIndexTier
+	// is set, and tierFailed is when looking tiers up last failed.
+	tierMu     sync.Mutex
+	tierCache  map[string]string
+	tierFailed time.Time
+
+	// fieldCache maps index patterns to their recently listed fields.
+	fieldMu    sync.Mutex
+	fieldCache map[string]cachedFields
+
+	// results holds recent query results once one is cached.
+	resultMu sync.Mutex
+	results  *resultCache
+
+	// flights holds the searches running for concurrent identical queries.
+	flightMu sync.Mutex
+	flights  map[string]*queryFlight
+
+	// savedIndexReady is set once the saved query index is known to exist.
+	savedMu         sync.Mutex
+	savedIndexReady bool
+	// percolatorIndexReady is set once the percolator index is known to
+	// exist.
+	percolatorIndexReady bool
+
+	// kibana sends Kibana API requests for watches.
+	kibana *http.Client
+
+	// meter counts usage per scope when metering is on.
+	meter *meter
+
+	// breaker stops requests to an unreachable cluster unless disabled.
+	breaker *circuitBreaker
+
+	// limiter paces requests when a rate limit is configured.
+	limiter *rateLimiter
+
+	// prober checks the cluster's health in the background when a probe
+	// interval is configured.
+	prober *healthProber
+
+	// conn connects to the cluster on the first request when LazyConnect
+	// is set.
+	conn *connector
+
+	// slowQueries holds the most recent slow queries once one is recorded.
+	slowMu      sync.Mutex
+	slowQueries *slowQueryRing
+
+	// open tracks the points in time and scrolls held open, for Close.
+	openMu sync.Mutex
+	open   searchContexts
+
+	// logger receives retries and slow queries once SetLogger is called.
+	logger atomic.Pointer[slog.Logger]
+
+	// instrumentation receives queries, retries and cache lookups when set
+	// by WithInstrumentation.
+	instrumentation Instrumentation
+
+	// wait replaces the wait between retries in tests.
+	wait func(ctx context.Context, d time.Duration) error
 }
 
 // New constructs the provider from decrypted config.
 func New(cfg map[string]any) (corelog.Provider, error) {
+	p, err := NewFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewFromConfig constructs the provider from decrypted config, as New
+// does, with options for programs embedding it.
+func NewFromConfig(cfg map[string]any, opts ...Option) (*ElasticProvider, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 	parsed := parseConfig(cfg)
 
 	// Validate configuration
@@ -65,16 +529,90 @@ func New(cfg map[string]any) (corelog.Provider, error) {
 		esCfg.Password = parsed.Password
 	}
 
+	// Decompress responses, categorize failed requests, stop them while
+	// the cluster is unreachable, pace them when rate limited, count usage
+	// when metering is on, record requests when auditing is, log error
+	// response bodies, and hold requests back until connected when
+	// connecting lazily
+	httpTransport := newHTTPTransport(parsed)
+	var base http.RoundTripper = httpTransport
+	if parsed.ResponseCompression == compressionNone {
+		httpTransport.DisableCompression = true
+	} else {
+		decompress, err := newDecompressTransport(httpTransport, parsed.ResponseCompression, o.decoders)
+		if err != nil {
+			return nil, err
+		}
+		base = decompress
+	}
+	var transport http.RoundTripper = &classifyTransport{next: base}
+	var breaker *circuitBreaker
+	if parsed.CircuitBreakerThreshold > 0 {
+		cooldown := parsed.CircuitBreakerCooldown
+		if cooldown <= 0 {
+			cooldown = defaultCircuitBreakerCooldown
+		}
+		breaker = newCircuitBreaker(parsed.CircuitBreakerThreshold, cooldown)
+		transport = &breakerTransport{next: transport, breaker: breaker}
+	}
+	var limiter *rateLimiter
+	if parsed.RateLimit > 0 {
+		maxWait := parsed.RateLimitMaxWait
+		if maxWait <= 0 {
+			maxWait = defaultRateLimitMaxWait
+		}
+		limiter = newRateLimiter(parsed.RateLimit, parsed.RateLimitBurst, maxWait)
+		transport = &limitTransport{next: transport, limiter: limiter}
+	}
+	meter := newMeter(parsed)
+	if meter != nil {
+		transport = &meterTransport{next: transport, meter: meter}
+	}
+	audit, err := newAuditLogger(parsed)
+	if err != nil {
+		return nil, err
+	}
+	if audit != nil {
+		transport = &auditTransport{next: transport, log: audit}
+	}
+
+	p := &ElasticProvider{cfg: parsed, redact: newFieldMatcher(parsed.RedactFields), meter: meter, breaker: breaker, limiter: limiter, instrumentation: o.instrumentation}
+	transport = &errorBodyTransport{next: transport, logger: &p.logger}
+	esCfg.Transport = transport
+	if parsed.LazyConnect {
+		p.conn = newConnector(p.ping, &p.logger)
+		esCfg.Transport = &connectTransport{next: transport, conn: p.conn}
+	}
+	// Searches retried by retry with a backoff are not also retried by the
+	// client without one, which would multiply the attempts
+	if parsed.RetryAttempts > 1 {
+		esCfg.DisableRetry = true
+	} else {
+		esCfg.MaxRetries = maxRetries
+	}
+	esCfg.RetryOnError = func(req *http.Request, err error) bool {
+		// Requests refused before being sent would only be refused again,
+		// and cancelled or timed out ones are out of time
+		return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrDisconnected) &&
+			!errors.Is(err, ErrCancelled) && !errors.Is(err, ErrTimeout)
+	}
+	esCfg.RetryBackoff = func(attempt int) time.Duration {
+		p.logRetry(attempt)
+		return 0
+	}
+
 	// Create Elasticsearch client
 	client, err := elasticsearch.NewClient(esCfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Elasticsearch client: %w", err)
 	}
 
-	// Test connection with a ping
-	_, err = client.Ping()
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Elasticsearch: %w", err)
+	// Test connection with a ping, unless skipped or left to the first
+	// request
+	if !parsed.SkipStartupPing && !parsed.LazyConnect {
+		if _, err := client.Ping(); err != nil {
+			return nil, fmt.Errorf("failed to connect to Elasticsearch: %w", err)
+		}
 	}
 
 	// Extract base URL from first address or cloudID
@@ -83,11 +621,18 @@ func New(cfg map[string]any) (corelog.Provider, error) {
 		baseURL = parsed.Addresses[0]
 	}
 
-	return &ElasticProvider{
-		cfg:     parsed,
-		client:  client,
-		baseURL: baseURL,
-	}, nil
+	// Kibana requests go through the same transport, and so are audited
+	p.client = client
+	p.baseURL = baseURL
+	p.kibana = &http.Client{Transport: transport}
+	if meter != nil && meter.flush {
+		p.startMeteringFlush(p.meteringInterval())
+	}
+	if parsed.HealthProbeInterval > 0 {
+		p.prober = newHealthProber(parsed.HealthProbeInterval)
+		p.startHealthProbes()
+	}
+	return p, nil
 }
 
 func init() {
@@ -96,71 +641,461 @@ func init() {
 
 // Query executes a log query against Elasticsearch and returns normalized log entries.
 func (p *ElasticProvider) Query(ctx context.Context, query schema.LogQuery) (schema.LogEntries, error) {
+	entries, _, err := p.QueryWithStats(ctx, query)
+	if err != nil {
+		return schema.LogEntries{}, err
+	}
+
+	// Build URL to view logs in Kibana
+	kibanaURL := buildKibanaURL(p.baseURL, p.cfg.IndexPattern, query)
+
+	return schema.LogEntries{
+		Entries: entries,
+		URL:     kibanaURL,
+	}, nil
+}
+
+// QueryStats describes how Elasticsearch executed a search.
+type QueryStats struct {
+	// TotalHits is the number of matching documents; it is a lower bound
+	// when TotalHitsRelation is "gte".
+	TotalHits         int            `json:"totalHits"`
+	TotalHitsRelation string         `json:"totalHitsRelation,omitempty"`
+	TookMillis        int            `json:"tookMillis"`
+	TimedOut          bool           `json:"timedOut"`
+	ShardFailures     []ShardFailure `json:"shardFailures,omitempty"`
+	// NextCursor resumes after the last returned entry. It is empty when the
+	// page was not full, meaning there are no further results.
+	NextCursor string `json:"nextCursor,omitempty"`
+	// SampleProbability is the share of matching documents kept by a
+	// QueryOptionSample query; 1 when all of them fit the limit.
+	SampleProbability float64 `json:"sampleProbability,omitempty"`
+	// Warnings describe query fields missing from or unsuited to the
+	// mapping when ValidateFields is "warn".
+	Warnings []string `json:"warnings,omitempty"`
+	// Hints suggest how to speed up a query that took longer than
+	// SlowQueryThreshold.
+	Hints []string `json:"hints,omitempty"`
+	// Cached is set when the result came from the query result cache.
+	Cached bool `json:"cached,omitempty"`
+	// Truncated is set when the query stopped at MaxEntriesInMemory
+	// entries with more results left; NextCursor, when set, continues it.
+	Truncated bool `json:"truncated,omitempty"`
+	// Partial is set when a fanned-out query returned the results of some
+	// index groups only; IndexFailures lists the others.
+	Partial       bool           `json:"partial,omitempty"`
+	IndexFailures []IndexFailure `json:"indexFailures,omitempty"`
+
+	// totalShards is the number of shards searched per page.
+	totalShards int
+}
+
+// ShardFailure describes a shard that failed to answer a search.
+type ShardFailure struct {
+	Index  string `json:"index,omitempty"`
+	Shard  int    `json:"shard"`
+	Type   string `json:"type,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// QueryWithStats executes a log query and returns the normalized entries
+// together with execution statistics, letting callers tell "only 5 logs
+// exist" apart from "5 returned out of 2 million".
+//
+// Limits larger than the page size are fetched page by page with
+// search_after until the limit is reached, results run out, or maxPages
+// pages have been read. A NextCursor in the stats means more results exist.
+//
+// With ValidateFields set, the query's fields are first checked against
+// the mapping; see planFields. With QueryCacheSize set, a query sending the
+// same search as one answered within QueryCacheTTL gets that answer again.
+// Queries sending the same search at the same time share one search, each
+// getting a copy of its result.
+func (p *ElasticProvider) QueryWithStats(ctx context.Context, query schema.LogQuery) ([]schema.LogEntry, QueryStats, error) {
+	start := time.Now()
+	entries, stats, err := p.queryWithStats(ctx, query)
+	p.instrument().ObserveQuery(time.Since(start), len(entries), err)
+	return entries, stats, err
+}
+
+func (p *ElasticProvider) queryWithStats(ctx context.Context, query schema.LogQuery) ([]schema.LogEntry, QueryStats, error) {
+	ctx = withAudit(ctx, "log.query", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return nil, QueryStats{}, err
+	}
+	query, warnings, err := p.planFields(ctx, query)
+	if err != nil {
+		return nil, QueryStats{}, err
+	}
+	// The key also coalesces concurrent queries, so it is taken even with
+	// the result cache off, from the search the first request then sends
+	var built map[string]any
+	key := ""
+	if p.cacheable(query) {
+		built = p.buildQuery(query)
+		key = p.resultKey(built)
+	}
+	if cached, ok := p.cachedResult(key); ok {
+		p.instrument().ObserveCacheHit()
+		stats := cloneStats(cached.stats)
+		stats.Warnings, stats.Cached = warnings, true
+		return markCached(cached.entries), stats, nil
+	}
+	if key != "" && p.cfg.QueryCacheSize > 0 {
+		p.instrument().ObserveCacheMiss()
+	}
+	entries, stats, err := p.coalesce(ctx, key, func(ctx context.Context) ([]schema.LogEntry, QueryStats, error) {
+		var entries []schema.LogEntry
+		var stats QueryStats
+		err := p.retry(ctx, func(ctx context.Context) (err error) {
+			entries, stats, err = p.runQuery(ctx, query, built)
+			return err
+		})
+		if err != nil {
+			return nil, QueryStats{}, err
+		}
+		if key != "" && p.cfg.QueryCacheSize > 0 && !stats.Partial {
+			p.cacheResult(key, entries, stats)
+		}
+		stats.Hints = p.recordSlowQuery(ctx, query, stats)
+		return entries, stats, nil
+	})
+	if err != nil {
+		return nil, QueryStats{}, err
+	}
+	stats.Warnings = warnings
+	return entries, stats, nil
+}
+
+// runQuery executes a validated query for QueryWithStats. built is the
+// query's DSL when it was already built, or nil.
+func (p *ElasticProvider) runQuery(ctx context.Context, query schema.LogQuery, built map[string]any) ([]schema.LogEntry, QueryStats, error) {
+	if sample, _ := boolValue(query.Metadata[QueryOptionSample]); sample {
+		return p.sampleQuery(ctx, query)
+	}
+	if groups := p.fanOutGroups(ctx, query); groups != nil {
+		return p.fanOut(ctx, query, built, groups)
+	}
+
+	// Fetch no more than fits in memory; queries asking for more are cut
+	// short
+	requested := p.querySize(query)
+	limit := min(requested, p.maxEntriesInMemory())
+	pageSize := p.pageSize()
+	maxPages := p.maxPages()
+
+	page := query
+	page.Metadata = make(map[string]any, len(query.Metadata)+1)
+	for key, value := range query.Metadata {
+		page.Metadata[key] = value
+	}
+
+	// Room for the first page; more once its total tells how many to expect
+	entries := make([]schema.LogEntry, 0, min(limit, pageSize))
+	if limit > pageSize && p.useScroll(ctx, query) {
+		stats, err := p.scroll(ctx, query, built, nil, limit, maxPages, func(batch []schema.LogEntry) error {
+			if len(entries) > 0 && len(entries) == cap(entries) {
+				// A second batch: expect as many as may be read
+				entries = slices.Grow(entries, max(min(limit, pageSize*maxPages)-len(entries), 0))
+			}
+			entries = append(entries, batch...)
+			return nil
+		})
+		if err != nil {
+			return nil, QueryStats{}, err
+		}
+		if requested > limit && len(entries) == limit && stats.TotalHits > limit {
+			stats.Truncated = true
+			entries[len(entries)-1].Metadata[MetadataTruncated] = true
+		}
+		if p.cfg.DedupeResults {
+			entries = dedupeConsecutive(entries)
+		}
+		return entries, stats, nil
+	}
+
+	var stats QueryStats
+	for n := 0; ; n++ {
+		if n > 0 {
+			if err := ctx.Err(); err != nil {
+				p.releaseCursor(stats.NextCursor)
+				return nil, QueryStats{}, err
+			}
+			page.Metadata[QueryOptionCursor] = stats.NextCursor
+		}
+		page.Limit = min(pageSize, limit-len(entries))
+
+		var pageStats QueryStats
+		var err error
+		entries, pageStats, err = p.fetchPage(ctx, page, built, entries)
+		built = nil
+		if err != nil {
+			if n > 0 {
+				err = fmt.Errorf("page %d: %w", n+1, err)
+			}
+			return nil, QueryStats{}, err
+		}
+		stats = mergeStats(stats, pageStats, n == 0)
+
+		if stats.NextCursor == "" || len(entries) >= limit || n+1 >= maxPages {
+			break
+		}
+		if n == 0 {
+			entries = slices.Grow(entries, max(expectedEntries(limit, pageSize*maxPages, stats)-len(entries), 0))
+		}
+	}
+
+	truncated := requested > limit && len(entries) >= limit && stats.NextCursor != ""
+	if p.cfg.DedupeResults {
+		entries = dedupeConsecutive(entries)
+	}
+	if stats.NextCursor != "" {
+		entries[len(entries)-1].Metadata[MetadataNextCursor] = stats.NextCursor
+	}
+	if truncated {
+		stats.Truncated = true
+		entries[len(entries)-1].Metadata[MetadataTruncated] = true
+	}
+
+	return entries, stats, nil
+}
+
+// expectedEntries returns how many entries a query reading up to limit
+// entries, at most fetchable over its pages, can expect from the stats of
+// its first page.
+func expectedEntries(limit, fetchable int, first QueryStats) int {
+	expected := min(limit, fetchable)
+	if first.TotalHitsRelation == "eq" {
+		expected = min(expected, first.TotalHits)
+	}
+	return expected
+}
+
+// fetchPage runs one search and appends its normalized hits to entries. The
+// returned stats carry a NextCursor when the page was full. built is the
+// DSL of the query the page is the first of, when it was already built.
+func (p *ElasticProvider) fetchPage(ctx context.Context, query schema.LogQuery, built map[string]any, entries []schema.LogEntry) ([]schema.LogEntry, QueryStats, error) {
 	// Build Elasticsearch query DSL
-	esQuery := p.buildQuery(query)
+	esQuery := p.pageQuery(query, built)
+
+	// In point-in-time mode the first page opens a point in time that later
+	// pages reuse through the cursor. It is closed once pagination ends.
+	pitID := ""
+	if cursor, _ := p.queryCursor(query); cursor != nil {
+		pitID = cursor.PIT
+	}
+	if pitID == "" && p.usePIT() {
+		id, err := p.openPointInTime(ctx)
+		if err != nil {
+			return entries, QueryStats{}, err
+		}
+		pitID = id
+	}
+	keepPIT := false
+	if pitID != "" {
+		esQuery["pit"] = map[string]any{"id": pitID, "keep_alive": p.pitKeepAlive()}
+		defer func() {
+			if !keepPIT {
+				p.closePointInTime(pitID)
+			}
+		}()
+	}
 
 	// Marshal to JSON
-	queryBody, err := json.Marshal(esQuery)
+	trace := p.traceSearch(ctx, esQuery)
+	queryBody, err := encodeBody(esQuery)
 	if err != nil {
-		return schema.LogEntries{}, fmt.Errorf("failed to marshal query: %w", err)
+		return entries, QueryStats{}, fmt.Errorf("failed to marshal query: %w", err)
 	}
+	p.logSearchBody(ctx, queryBody.Bytes())
 
-	// Execute search
-	res, err := p.client.Search(
+	// Execute search; a point in time already names its indices
+	search := append([]func(*esapi.SearchRequest){
 		p.client.Search.WithContext(ctx),
-		p.client.Search.WithIndex(p.cfg.IndexPattern),
-		p.client.Search.WithBody(strings.NewReader(string(queryBody))),
-		p.client.Search.WithTrackTotalHits(true),
-	)
+		p.client.Search.WithBody(bytes.NewReader(queryBody.Bytes())),
+	}, trace...)
+	if pitID == "" {
+		search = append(search, p.client.Search.WithIndex(p.searchIndex(ctx)))
+	}
+	res, err := p.client.Search(search...)
 	if err != nil {
-		return schema.LogEntries{}, fmt.Errorf("elasticsearch query failed: %w", err)
+		return entries, QueryStats{}, fmt.Errorf("elasticsearch query failed: %w", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		return schema.LogEntries{}, fmt.Errorf("elasticsearch returned error: %s", res.String())
+		err := responseError(res)
+		if pitID != "" && isSearchContextMissing(err) {
+			keepPIT = true // nothing left to close
+			p.releasedPIT(pitID)
+			return entries, QueryStats{}, ErrCursorExpired
+		}
+		return entries, QueryStats{}, err
 	}
+	releaseBody(queryBody)
 
-	// Parse response
-	var result esSearchResponse
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return schema.LogEntries{}, fmt.Errorf("failed to parse response: %w", err)
+	// Parse response, normalizing hits as they are decoded
+	entries, page, err := p.streamResult(ctx, entries, res.Body)
+	if err != nil {
+		return entries, QueryStats{}, fmt.Errorf("failed to parse response: %w", err)
 	}
+	p.meterResult(ctx, int64(page.hits), int64(page.Took))
 
-	// Normalize to schema.LogEntry
-	entries := make([]schema.LogEntry, 0, len(result.Hits.Hits))
-	for _, hit := range result.Hits.Hits {
-		entry := normalizeHit(p, hit)
-		entries = append(entries, entry)
+	stats := page.stats()
+	if page.hits > 0 && page.hits >= p.querySize(query) && len(page.lastSort) > 0 {
+		next := pageCursor{PIT: pitID, After: page.lastSort}
+		if page.PITID != "" && pitID != "" {
+			// Elasticsearch may hand back a refreshed id
+			next.PIT = page.PITID
+		}
+		cursor, err := p.encodeCursor(query, next)
+		if err != nil {
+			return entries, QueryStats{}, fmt.Errorf("failed to encode cursor: %w", err)
+		}
+		stats.NextCursor = cursor
+		keepPIT = true
+		if next.PIT != pitID {
+			p.releasedPIT(pitID)
+		}
+		if next.PIT != "" {
+			p.holdPIT(next.PIT)
+		}
 	}
 
-	// Build URL to view logs in Kibana
-	kibanaURL := buildKibanaURL(p.baseURL, p.cfg.IndexPattern, query)
+	return entries, stats, nil
+}
 
-	return schema.LogEntries{
-		Entries: entries,
-		URL:     kibanaURL,
-	}, nil
+// appendResult normalizes a search response onto entries and annotates the
+// new entries with their index tier when configured.
+func (p *ElasticProvider) appendResult(ctx context.Context, entries []schema.LogEntry, result esSearchResponse) []schema.LogEntry {
+	start := len(entries)
+	entries = appendNormalized(entries, p, result)
+	if p.cfg.ResolveIndexTier {
+		p.annotateIndexTiers(ctx, result.Hits.Hits, entries[start:])
+	}
+	return entries
+}
+
+// mergeStats folds one page's stats into the running totals. Hit totals
+// come from the first page; timings and failures accumulate.
+func mergeStats(total, page QueryStats, first bool) QueryStats {
+	if first {
+		return page
+	}
+	total.TookMillis += page.TookMillis
+	total.totalShards = max(total.totalShards, page.totalShards)
+	total.TimedOut = total.TimedOut || page.TimedOut
+	total.ShardFailures = append(total.ShardFailures, page.ShardFailures...)
+	total.NextCursor = page.NextCursor
+	return total
+}
+
+// validateQuery rejects queries that cannot be sent to Elasticsearch
+// safely, as ErrInvalidQuery unless a narrower category applies.
+func (p *ElasticProvider) validateQuery(query schema.LogQuery) error {
+	if err := ValidateQuery(query); err != nil {
+		return err
+	}
+	return categorize(p.checkQuery(query), ErrInvalidQuery)
+}
+
+func (p *ElasticProvider) checkQuery(query schema.LogQuery) error {
+	if _, err := queryOrder(query); err != nil {
+		return err
+	}
+	if _, err := p.queryCursor(query); err != nil {
+		return err
+	}
+	if err := p.validateOffset(query); err != nil {
+		return err
+	}
+	if sample, _ := boolValue(query.Metadata[QueryOptionSample]); sample {
+		if _, ok := query.Metadata[QueryOptionCursor]; ok {
+			return fmt.Errorf("%s cannot be combined with %s", QueryOptionSample, QueryOptionCursor)
+		}
+		if _, ok := query.Metadata[QueryOptionOffset]; ok {
+			return fmt.Errorf("%s cannot be combined with %s", QueryOptionSample, QueryOptionOffset)
+		}
+	}
+	if query.Expression == nil {
+		return nil
+	}
+
+	maxLen := p.cfg.MaxSearchLength
+	if maxLen <= 0 {
+		maxLen = defaultMaxSearchLength
+	}
+	if search := query.Expression.Search; len(search) > maxLen {
+		return fmt.Errorf("search expression is %d bytes, exceeding the maximum of %d: %q",
+			len(search), maxLen, truncateUTF8(search, errorPreviewBytes)+"...")
+	}
+
+	for _, filter := range query.Expression.Filters {
+		switch filter.Operator {
+		case "geo_distance":
+			if _, err := parseGeoDistance(filter.Value); err != nil {
+				return fmt.Errorf("invalid geo_distance filter on %q: %w", filter.Field, err)
+			}
+		case "script":
+			if !p.cfg.AllowScriptFilters {
+				return unsupported("script filters are disabled; set allowScriptFilters to enable them")
+			}
+			if _, err := parseScriptFilter(filter.Value); err != nil {
+				return fmt.Errorf("invalid script filter: %w", err)
+			}
+		}
+	}
+	return nil
 }
 
 // buildQuery constructs an Elasticsearch query DSL from LogQuery.
 func (p *ElasticProvider) buildQuery(query schema.LogQuery) map[string]any {
+	esQuery := map[string]any{
+		"query": p.boolQuery(query),
+		"sort":  p.sortClause(query),
+	}
+
+	// Exact totals are expensive on large patterns, so they are opt-in
+	if wantExact, _ := boolValue(query.Metadata[QueryOptionExactTotals]); wantExact || p.cfg.ExactTotals {
+		esQuery["track_total_hits"] = true
+	} else {
+		esQuery["track_total_hits"] = defaultTrackTotalHits
+	}
+
+	// Resume after the previous page; the tiebreaker keeps sort values
+	// unique. Offsets apply only to a query's first request.
+	if cursor, _ := p.queryCursor(query); cursor != nil {
+		esQuery["search_after"] = cursor.After
+	} else if offset, _ := queryOffset(query); offset > 0 {
+		esQuery["from"] = offset
+	}
+
+	esQuery["size"] = p.querySize(query)
+
+	return esQuery
+}
+
+// pageQuery returns the DSL of one request reading query. built, when not
+// nil, is what buildQuery returned for the query the request reads the
+// first page of; it is copied with the page's size rather than built
+// again, so that filters are only built, and warned about, once.
+func (p *ElasticProvider) pageQuery(query schema.LogQuery, built map[string]any) map[string]any {
+	if built == nil {
+		return p.buildQuery(query)
+	}
+	esQuery := maps.Clone(built)
+	esQuery["size"] = p.querySize(query)
+	return esQuery
+}
+
+// boolQuery builds the bool query selecting a LogQuery's documents. It is
+// shared by searches and counts so both match the same documents.
+func (p *ElasticProvider) boolQuery(query schema.LogQuery) map[string]any {
 	mustClauses := []map[string]any{}
 
 	// Time range filter
 	if !query.Start.IsZero() || !query.End.IsZero() {
-		rangeClause := map[string]any{
-			"range": map[string]any{
-				"@timestamp": map[string]any{},
-			},
-		}
-		if !query.Start.IsZero() {
-			rangeClause["range"].(map[string]any)["@timestamp"].(map[string]any)["gte"] = query.Start.Format(time.RFC3339)
-		}
-		if !query.End.IsZero() {
-			rangeClause["range"].(map[string]any)["@timestamp"].(map[string]any)["lte"] = query.End.Format(time.RFC3339)
-		}
-		mustClauses = append(mustClauses, rangeClause)
+		mustClauses = append(mustClauses, timeRangeClause(query))
 	}
 
 	// Expression filters
@@ -176,188 +1111,816 @@ func (p *ElasticProvider) buildQuery(query schema.LogQuery) map[string]any {
 
 		// Severity filter
 		if len(query.Expression.SeverityIn) > 0 {
-			mustClauses = append(mustClauses, map[string]any{
-				"terms": map[string]any{
-					"severity": query.Expression.SeverityIn,
-				},
-			})
+			mustClauses = append(mustClauses, p.severityClause(query.Expression.SeverityIn))
 		}
 
-		// Structured filters
+		// Structured filters. One that cannot be applied matches nothing
+		// rather than being dropped, which would widen the query.
 		for _, filter := range query.Expression.Filters {
-			clause := p.buildFilterClause(filter)
-			if clause != nil {
-				mustClauses = append(mustClauses, clause)
+			clause, err := p.buildFilterClause(filter)
+			if err != nil {
+				clause = map[string]any{"match_none": map[string]any{}}
 			}
+			mustClauses = append(mustClauses, clause)
 		}
 	}
 
 	// Scope filters
 	if query.Scope.Service != "" {
-		mustClauses = append(mustClauses, map[string]any{
-			"term": map[string]any{
-				"service": query.Scope.Service,
-			},
-		})
+		mustClauses = append(mustClauses, p.equalityClause(p.scopeFields(scopeService), query.Scope.Service))
 	}
 	if query.Scope.Environment != "" {
-		mustClauses = append(mustClauses, map[string]any{
-			"term": map[string]any{
-				"environment": query.Scope.Environment,
-			},
-		})
+		mustClauses = append(mustClauses, p.equalityClause(p.scopeFields(scopeEnvironment), query.Scope.Environment))
 	}
 	if query.Scope.Team != "" {
-		mustClauses = append(mustClauses, map[string]any{
-			"term": map[string]any{
-				"team": query.Scope.Team,
-			},
-		})
+		mustClauses = append(mustClauses, p.equalityClause(p.scopeFields(scopeTeam), query.Scope.Team))
 	}
 
 	// Metadata filters
 	for key, value := range query.Metadata {
-		mustClauses = append(mustClauses, map[string]any{
-			"term": map[string]any{
-				key: value,
-			},
-		})
+		if reservedMetadataKeys[key] {
+			continue
+		}
+		mustClauses = append(mustClauses, p.equalityClause([]string{key}, value))
 	}
 
-	// Build final query
-	esQuery := map[string]any{
-		"query": map[string]any{
-			"bool": map[string]any{
-				"must": mustClauses,
-			},
+	return map[string]any{
+		"bool": map[string]any{
+			"must": mustClauses,
 		},
-		"sort": []map[string]any{
-			{"@timestamp": map[string]any{"order": "desc"}},
+	}
+}
+
+// timeRangeFormat parses the bounds of timeRangeClause, which are RFC 3339
+// with up to nanosecond fractions.
+const timeRangeFormat = "strict_date_optional_time_nanos"
+
+// timeRangeClause bounds @timestamp to the query window, keeping sub-second
+// bounds so that a window of a few hundred milliseconds selects just its
+// documents. Bounds are written in UTC, so equal instants give equal
+// queries. Start is inclusive (gte). End is inclusive (lte) as well,
+// unless QueryOptionEndExclusive makes it exclusive (lt). Elasticsearch
+// truncates the bounds to the resolution of the field, milliseconds for
+// date fields.
+func timeRangeClause(query schema.LogQuery) map[string]any {
+	bounds := map[string]any{"format": timeRangeFormat}
+	if !query.Start.IsZero() {
+		bounds["gte"] = query.Start.UTC().Format(time.RFC3339Nano)
+	}
+	if !query.End.IsZero() {
+		if exclusive, _ := boolValue(query.Metadata[QueryOptionEndExclusive]); exclusive {
+			bounds["lt"] = query.End.UTC().Format(time.RFC3339Nano)
+		} else {
+			bounds["lte"] = query.End.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return map[string]any{
+		"range": map[string]any{
+			"@timestamp": bounds,
 		},
 	}
+}
 
-	// Apply limit
+// querySize returns the page size for a query: its limit, else the
+// configured default. A default size is trimmed so that an offset query
+// stays within the result window.
+func (p *ElasticProvider) querySize(query schema.LogQuery) int {
 	if query.Limit > 0 {
-		esQuery["size"] = query.Limit
-	} else {
-		esQuery["size"] = 1000 // Default limit
+		return query.Limit
+	}
+	size := defaultLimit
+	if p.cfg.DefaultLimit > 0 {
+		size = p.cfg.DefaultLimit
+	}
+	if offset, _ := queryOffset(query); offset > 0 {
+		size = max(min(size, p.maxResultWindow()-offset), 0)
 	}
+	return size
+}
 
-	return esQuery
+// pageSize returns the most entries fetched per search request.
+func (p *ElasticProvider) pageSize() int {
+	if p.cfg.PageSize > 0 {
+		return p.cfg.PageSize
+	}
+	return defaultPageSize
 }
 
-// buildFilterClause converts a LogFilter to an Elasticsearch clause.
-func (p *ElasticProvider) buildFilterClause(filter schema.LogFilter) map[string]any {
-	switch filter.Operator {
-	case "=":
-		return map[string]any{
-			"term": map[string]any{
-				filter.Field: filter.Value,
-			},
-		}
-	case "!=":
-		return map[string]any{
-			"bool": map[string]any{
-				"must_not": map[string]any{
-					"term": map[string]any{
-						filter.Field: filter.Value,
-					},
-				},
-			},
+// maxEntriesInMemory returns the most entries one query holds.
+func (p *ElasticProvider) maxEntriesInMemory() int {
+	if p.cfg.MaxEntriesInMemory > 0 {
+		return p.cfg.MaxEntriesInMemory
+	}
+	return defaultMaxEntriesInMemory
+}
+
+// maxPages returns the most search requests one query makes.
+func (p *ElasticProvider) maxPages() int {
+	if p.cfg.MaxPages > 0 {
+		return p.cfg.MaxPages
+	}
+	return defaultMaxPages
+}
+
+// Scope names used as keys of the scopeFields config.
+const (
+	scopeService     = "service"
+	scopeEnvironment = "environment"
+	scopeTeam        = "team"
+)
+
+// scopeFields returns the candidate document fields for a scope, in
+// precedence order. Without configuration a scope maps to the field of the
+// same name.
+func (p *ElasticProvider) scopeFields(scope string) []string {
+	if fields := p.cfg.ScopeFields[scope]; len(fields) > 0 {
+		return fields
+	}
+	return []string{scope}
+}
+
+// equalityClause matches a scope or metadata value against any one of the
+// candidate fields. Dynamic mappings make string fields text with a keyword
+// sub-field, on which a term query on the field matches nothing, so a string
+// value matches the keyword sub-field exactly or the field as a phrase,
+// whichever way the field is mapped. With ExactScopeMatch, and for other
+// values, the fields' terms are matched exactly.
+func (p *ElasticProvider) equalityClause(fields []string, value any) map[string]any {
+	text, ok := value.(string)
+	if !ok || p.cfg.ExactScopeMatch {
+		return scopeClause(fields, value)
+	}
+
+	should := make([]map[string]any, 0, 2*len(fields))
+	for _, field := range fields {
+		if strings.HasSuffix(field, keywordSuffix) {
+			should = append(should, map[string]any{"term": map[string]any{field: text}})
+			continue
+		}
+		should = append(should,
+			map[string]any{"term": map[string]any{field + keywordSuffix: text}},
+			map[string]any{"match_phrase": map[string]any{field: text}},
+		)
+	}
+	if len(should) == 1 {
+		return should[0]
+	}
+	return map[string]any{
+		"bool": map[string]any{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+// scopeClause matches value against any one of the candidate fields.
+func scopeClause(fields []string, value any) map[string]any {
+	if len(fields) == 1 {
+		return map[string]any{
+			"term": map[string]any{
+				fields[0]: value,
+			},
 		}
-	case "contains":
+	}
+
+	should := make([]map[string]any, 0, len(fields))
+	for _, field := range fields {
+		should = append(should, map[string]any{
+			"term": map[string]any{
+				field: value,
+			},
+		})
+	}
+	return map[string]any{
+		"bool": map[string]any{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+// sortClause orders newest first, with a tiebreaker so documents sharing a
+// timestamp keep a stable order across pages.
+func (p *ElasticProvider) sortClause(query schema.LogQuery) []map[string]any {
+	tiebreaker := p.cfg.TiebreakerField
+	if tiebreaker == "" {
+		tiebreaker = defaultTiebreakerField
+	}
+	// Sorting happens server-side so that with a limit, ascending order
+	// returns the oldest entries in the window rather than a reversed page
+	// of the newest ones.
+	order, _ := queryOrder(query)
+	if tiebreaker == defaultTiebreakerField {
+		return defaultSortClauses[order]
+	}
+	return []map[string]any{
+		{"@timestamp": map[string]any{"order": order}},
+		{tiebreaker: map[string]any{"order": order}},
+	}
+}
+
+// queryOrder returns the requested sort order, defaulting to newest first.
+func queryOrder(query schema.LogQuery) (string, error) {
+	value, ok := query.Metadata[QueryOptionOrder]
+	if !ok || value == nil {
+		return orderDesc, nil
+	}
+	order, _ := value.(string)
+	switch strings.ToLower(order) {
+	case orderAsc:
+		return orderAsc, nil
+	case orderDesc:
+		return orderDesc, nil
+	}
+	return "", fmt.Errorf("invalid %s %v: must be %q or %q", QueryOptionOrder, value, orderAsc, orderDesc)
+}
+
+// buildFilterClause converts a LogFilter to an Elasticsearch clause. It
+// fails for a filter that cannot be applied, as validateQuery does.
+func (p *ElasticProvider) buildFilterClause(filter schema.LogFilter) (map[string]any, error) {
+	build, ok := filterOperators[filter.Operator]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator %q on field %q", filter.Operator, filter.Field)
+	}
+	return build(p, filter)
+}
+
+// filterOperators builds the clause for each supported filter operator. It
+// is the single list of operators: Capabilities reports its keys, and
+// ValidateQuery rejects a query with any other operator before it is built,
+// so no filter is dropped from a query that is sent. A builder fails when
+// the filter cannot be applied.
+var filterOperators = map[string]func(p *ElasticProvider, filter schema.LogFilter) (map[string]any, error){
+	"=": func(p *ElasticProvider, filter schema.LogFilter) (map[string]any, error) {
+		return map[string]any{
+			"term": map[string]any{
+				filter.Field: filter.Value,
+			},
+		}, nil
+	},
+	"!=": func(p *ElasticProvider, filter schema.LogFilter) (map[string]any, error) {
+		return map[string]any{
+			"bool": map[string]any{
+				"must_not": map[string]any{
+					"term": map[string]any{
+						filter.Field: filter.Value,
+					},
+				},
+			},
+		}, nil
+	},
+	"contains": func(p *ElasticProvider, filter schema.LogFilter) (map[string]any, error) {
 		return map[string]any{
 			"wildcard": map[string]any{
 				filter.Field: map[string]any{
 					"value": "*" + filter.Value + "*",
 				},
 			},
-		}
-	case "regex":
+		}, nil
+	},
+	"regex": func(p *ElasticProvider, filter schema.LogFilter) (map[string]any, error) {
 		return map[string]any{
 			"regexp": map[string]any{
 				filter.Field: map[string]any{
 					"value": filter.Value,
 				},
 			},
+		}, nil
+	},
+	"geo_distance": func(p *ElasticProvider, filter schema.LogFilter) (map[string]any, error) {
+		spec, err := parseGeoDistance(filter.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geo_distance filter on %q: %w", filter.Field, err)
 		}
-	default:
-		return nil
+		return map[string]any{
+			"geo_distance": map[string]any{
+				"distance": spec.Distance,
+				filter.Field: map[string]any{
+					"lat": spec.Lat,
+					"lon": spec.Lon,
+				},
+			},
+		}, nil
+	},
+	"script": func(p *ElasticProvider, filter schema.LogFilter) (map[string]any, error) {
+		if !p.cfg.AllowScriptFilters {
+			return nil, unsupported("script filters are disabled; set allowScriptFilters to enable them")
+		}
+		script, err := parseScriptFilter(filter.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script filter: %w", err)
+		}
+		fmt.Fprintf(stderr, "warning: elastic adapter executing script filter: %q\n", truncateUTF8(script.Source, errorPreviewBytes))
+		return map[string]any{
+			"script": map[string]any{
+				"script": script,
+			},
+		}, nil
+	},
+}
+
+// scriptFilter is the JSON value of a "script" filter.
+type scriptFilter struct {
+	Source string         `json:"source"`
+	Lang   string         `json:"lang"`
+	Params map[string]any `json:"params,omitempty"`
+}
+
+// parseScriptFilter decodes a script filter value such as
+// {"source": "doc['a'].value > doc['b'].value", "params": {}}.
+func parseScriptFilter(value string) (scriptFilter, error) {
+	var script scriptFilter
+	if err := json.Unmarshal([]byte(value), &script); err != nil {
+		return scriptFilter{}, fmt.Errorf("value must be a JSON object with source and params: %w", err)
+	}
+	if strings.TrimSpace(script.Source) == "" {
+		return scriptFilter{}, errors.New("script source is required")
 	}
+	if len(script.Source) > maxScriptLength {
+		return scriptFilter{}, fmt.Errorf("script source is %d bytes, exceeding the maximum of %d", len(script.Source), maxScriptLength)
+	}
+	script.Lang = "painless"
+	return script, nil
+}
+
+// geoDistance is a parsed geo_distance filter value.
+type geoDistance struct {
+	Lat      float64
+	Lon      float64
+	Distance string
 }
 
-// normalizeHit converts an Elasticsearch hit to a schema.LogEntry.
+// geoDistanceUnit matches a positive distance with an Elasticsearch distance unit.
+var geoDistanceUnit = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(mi|miles|yd|yards|ft|feet|in|inch|km|kilometers|m|meters|cm|centimeters|mm|millimeters|NM|nmi|nauticalmiles)$`)
+
+// parseGeoDistance parses a "lat,lon,distance" filter value such as "50.1,8.6,50km".
+func parseGeoDistance(value string) (geoDistance, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return geoDistance{}, fmt.Errorf("value %q must have the form lat,lon,distance", value)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return geoDistance{}, fmt.Errorf("latitude %q must be a number between -90 and 90", parts[0])
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return geoDistance{}, fmt.Errorf("longitude %q must be a number between -180 and 180", parts[1])
+	}
+	distance := strings.TrimSpace(parts[2])
+	if !geoDistanceUnit.MatchString(distance) {
+		return geoDistance{}, fmt.Errorf("distance %q must be a number followed by a unit such as km or mi", parts[2])
+	}
+
+	return geoDistance{Lat: lat, Lon: lon, Distance: distance}, nil
+}
+
+// normalizeResponse converts every hit in a search response to a schema.LogEntry
+// and records the total hit count on each entry under MetadataTotalHits and
+// MetadataTotalHitsRelation.
+func normalizeResponse(p *ElasticProvider, result esSearchResponse) []schema.LogEntry {
+	return appendNormalized(make([]schema.LogEntry, 0, len(result.Hits.Hits)), p, result)
+}
+
+// appendNormalized appends the response's normalized hits to entries.
+func appendNormalized(entries []schema.LogEntry, p *ElasticProvider, result esSearchResponse) []schema.LogEntry {
+	for _, hit := range result.Hits.Hits {
+		entry := normalizeHit(p, hit)
+		entry.Metadata[MetadataTotalHits] = result.Hits.Total.Value
+		if result.Hits.Total.Relation != "" {
+			entry.Metadata[MetadataTotalHitsRelation] = result.Hits.Total.Relation
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Entry metadata levels for entryMetadataLevel.
+const (
+	metadataLevelNone    = "none"
+	metadataLevelMinimal = "minimal"
+	metadataLevelFull    = "full"
+)
+
+// hitMetadata returns the document identity metadata for an entry. "none"
+// omits it, "minimal" keeps only _id, and "full" (the default) adds _index,
+// _score when the search computed one, and _routing/_version when present.
+func hitMetadata(level string, hit esHit) map[string]any {
+	switch level {
+	case metadataLevelNone:
+		return map[string]any{}
+	case metadataLevelMinimal:
+		return map[string]any{"_id": hit.ID}
+	}
+
+	metadata := map[string]any{
+		"_index": hit.Index,
+		"_id":    hit.ID,
+	}
+	if hit.Score != nil {
+		metadata["_score"] = *hit.Score
+	}
+	if hit.Routing != "" {
+		metadata["_routing"] = hit.Routing
+	}
+	if hit.Version != nil {
+		metadata["_version"] = *hit.Version
+	}
+	return metadata
+}
+
+// dedupeConsecutive collapses runs of consecutive entries with the same
+// message, service, and severity into one entry. The kept entry carries the
+// newest timestamp, the run length under MetadataRepeatCount, and the span of
+// the run under MetadataRepeatFirstSeen and MetadataRepeatLastSeen.
+func dedupeConsecutive(entries []schema.LogEntry) []schema.LogEntry {
+	out := entries[:0]
+	for i := 0; i < len(entries); {
+		entry := entries[i]
+		first, last := entry.Timestamp, entry.Timestamp
+		j := i + 1
+		for ; j < len(entries) && sameLogLine(entry, entries[j]); j++ {
+			if ts := entries[j].Timestamp; ts.Before(first) {
+				first = ts
+			} else if ts.After(last) {
+				last = ts
+			}
+		}
+
+		if count := j - i; count > 1 {
+			entry.Timestamp = last
+			entry.Metadata[MetadataRepeatCount] = count
+			entry.Metadata[MetadataRepeatFirstSeen] = first
+			entry.Metadata[MetadataRepeatLastSeen] = last
+		}
+		out = append(out, entry)
+		i = j
+	}
+	return out
+}
+
+func sameLogLine(a, b schema.LogEntry) bool {
+	return a.Message == b.Message && a.Service == b.Service && a.Severity == b.Severity
+}
+
+// normalizeHit converts an Elasticsearch hit to a schema.LogEntry. Nested
+// objects are flattened into dotted keys so that Labels and Fields use the
+// same field names that buildFilterClause accepts.
 func normalizeHit(p *ElasticProvider, hit esHit) schema.LogEntry {
-	source := hit.Source
+	// Redact before anything else reads the document
+	if !p.redact.empty() {
+		hit.Source = redactSource(hit.Source, p.redact, p.cfg.RedactMode == redactModeMask)
+	}
+
+	depth := p.cfg.FlattenDepth
+	if depth <= 0 {
+		depth = defaultFlattenDepth
+	}
+	source := flattenSource(hit.Source, depth)
 
 	entry := schema.LogEntry{
-		Metadata: map[string]any{
-			"_index": hit.Index,
-			"_id":    hit.ID,
-			"_score": hit.Score,
-		},
+		Metadata: hitMetadata(p.cfg.EntryMetadataLevel, hit),
+	}
+	if len(hit.Sort) > 0 {
+		entry.Metadata[MetadataSort] = hit.Sort
+	}
+	if dataStream, generation, ok := parseBackingIndex(hit.Index); ok {
+		entry.Metadata[MetadataDataStream] = dataStream
+		entry.Metadata[MetadataBackingIndexGeneration] = generation
 	}
 
-	// Extract timestamp
-	if ts, ok := source["@timestamp"].(string); ok {
-		if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+	// Extract timestamp, keeping the raw value when it cannot be parsed
+	if raw, ok := source["@timestamp"]; ok && raw != nil {
+		if parsed, ok := parseTimestamp(raw, p.cfg.TimestampLayouts); ok {
 			entry.Timestamp = parsed
+		} else {
+			entry.Metadata[MetadataRawTimestamp] = raw
 		}
 	}
 
-	// Extract message
-	if msg, ok := source["message"].(string); ok {
+	// Extract message from the configured composition, else from the first
+	// candidate field present. Fields are looked up in the unflattened source
+	// so object messages stay intact.
+	if msg, ok := composeMessage(p.cfg.MessageComposition, hit.Source); ok {
 		entry.Message = msg
+	} else {
+		messageFields := p.cfg.MessageFields
+		if len(messageFields) == 0 {
+			messageFields = defaultMessageFields
+		}
+		for _, field := range messageFields {
+			if msg, ok := messageText(lookupPath(hit.Source, field)); ok {
+				entry.Message = msg
+				break
+			}
+		}
 	}
 
-	// Extract severity
-	if sev, ok := source["severity"].(string); ok {
-		entry.Severity = sev
-	} else if level, ok := source["level"].(string); ok {
-		entry.Severity = level
+	// Extract severity from the first candidate field holding one
+	severity, severityField := p.entrySeverity(source)
+	entry.Severity = severity
+
+	// Extract service from the first candidate field present
+	for _, field := range p.scopeFields(scopeService) {
+		if svc, ok := source[field].(string); ok {
+			entry.Service = svc
+			break
+		}
 	}
 
-	// Extract service
-	if svc, ok := source["service"].(string); ok {
-		entry.Service = svc
+	// Extract trace correlation IDs
+	if traceID := firstString(source, p.cfg.TraceIDFields, defaultTraceIDFields); traceID != "" {
+		entry.Metadata[MetadataTraceID] = traceID
+	}
+	if spanID := firstString(source, p.cfg.SpanIDFields, defaultSpanIDFields); spanID != "" {
+		entry.Metadata[MetadataSpanID] = spanID
 	}
 
-	// Extract labels (string-valued fields)
-	entry.Labels = make(map[string]string)
-	for key, value := range source {
-		if key == "@timestamp" || key == "message" || key == "severity" || key == "level" || key == "service" {
-			continue
-		}
-		if strVal, ok := value.(string); ok {
+	for _, key := range p.cfg.DropFields {
+		delete(source, key)
+	}
+
+	// Extract labels (allowlisted string-valued fields)
+	labelFields := p.labelFields()
+	entry.Labels = make(map[string]string, len(labelFields))
+	for _, key := range labelFields {
+		if strVal, ok := source[key].(string); ok {
 			entry.Labels[key] = strVal
+		} else if p.cfg.StringifyLabelValues {
+			if strVal, ok := scalarString(source[key]); ok {
+				entry.Labels[key] = strVal
+			}
 		}
 	}
+	p.addIdentityLabels(entry.Labels, source)
+
+	// Extract fields (all structured data); the flattened source is not
+	// read again, so it becomes the fields rather than being copied. Of the
+	// severity candidates, only the field the severity came from is removed.
+	for _, key := range [...]string{"@timestamp", "message", "service", severityField} {
+		delete(source, key)
+	}
+	entry.Fields = source
+
+	p.limitEntrySize(&entry)
 
-	// Extract fields (all structured data)
-	entry.Fields = make(map[string]any)
-	for key, value := range source {
-		if key == "@timestamp" || key == "message" || key == "severity" || key == "level" || key == "service" {
+	return entry
+}
+
+// limitEntrySize truncates oversized string fields to maxFieldBytes and, when
+// maxEntryBytes is set, drops fields once the encoded entry would exceed it.
+// Affected field names are listed in Metadata so callers know data is missing.
+func (p *ElasticProvider) limitEntrySize(entry *schema.LogEntry) {
+	maxField := p.cfg.MaxFieldBytes
+	if maxField <= 0 {
+		maxField = defaultMaxFieldBytes
+	}
+
+	var truncated []string
+	for key, value := range entry.Fields {
+		if str, ok := value.(string); ok && len(str) > maxField {
+			entry.Fields[key] = truncateUTF8(str, maxField) + fmt.Sprintf("...(truncated, %d bytes)", len(str))
+			truncated = append(truncated, key)
+		}
+	}
+	if len(truncated) > 0 {
+		sort.Strings(truncated)
+		entry.Metadata[MetadataTruncatedFields] = truncated
+	}
+
+	if p.cfg.MaxEntryBytes <= 0 {
+		return
+	}
+	fields := entry.Fields
+	entry.Fields = nil
+	size := encodedSize(entry)
+	if size+encodedSize(fields) <= p.cfg.MaxEntryBytes {
+		entry.Fields = fields
+		return
+	}
+
+	// Keep fields in key order until the budget is spent
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kept := make(map[string]any, len(fields))
+	var dropped []string
+	for _, key := range keys {
+		fieldSize := len(key) + encodedSize(fields[key]) + 4 // quotes, colon, comma
+		if len(dropped) == 0 && size+fieldSize <= p.cfg.MaxEntryBytes {
+			kept[key] = fields[key]
+			size += fieldSize
 			continue
 		}
-		entry.Fields[key] = value
+		dropped = append(dropped, key)
 	}
+	entry.Fields = kept
+	entry.Metadata[MetadataDroppedFields] = dropped
+}
 
-	return entry
+// encodedSize returns the JSON-encoded size of v.
+func encodedSize(v any) int {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
+
+// lookupPath returns the value at a dotted path, accepting both literal dotted
+// keys ({"event.original": v}) and nested objects ({"event": {"original": v}}).
+func lookupPath(source map[string]any, path string) any {
+	if v, ok := source[path]; ok {
+		return v
+	}
+	head, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil
+	}
+	if nested, ok := source[head].(map[string]any); ok {
+		return lookupPath(nested, rest)
+	}
+	return nil
+}
+
+// MessagePart is one field of a composed message. Separator is written
+// before the part's text when an earlier part was present.
+type MessagePart struct {
+	Field     string
+	Separator string
+}
+
+// composeMessage joins the present parts in order. It reports false when no
+// part is present so the caller can fall back to the message fields.
+func composeMessage(parts []MessagePart, source map[string]interface{}) (string, bool) {
+	var b strings.Builder
+	present := false
+	for _, part := range parts {
+		text, ok := messageText(lookupPath(source, part.Field))
+		if !ok {
+			continue
+		}
+		if present {
+			b.WriteString(part.Separator)
+		}
+		b.WriteString(text)
+		present = true
+	}
+	return b.String(), present
+}
+
+// messageText renders a message value as text: strings as-is, arrays of
+// lines joined with newlines, and structured values as compact JSON.
+func messageText(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, v != ""
+	case []any:
+		lines := make([]string, 0, len(v))
+		for _, item := range v {
+			if line, ok := messageText(item); ok {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n"), len(lines) > 0
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(encoded), true
+	}
+}
+
+// scalarString renders numbers and booleans as strings. Numbers decoded as
+// json.Number keep their exact digits, so large integer IDs lose no precision.
+func scalarString(value any) (string, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// firstString returns the first non-empty string value among the candidate
+// fields, using defaults when no candidates are configured.
+func firstString(source map[string]any, candidates, defaults []string) string {
+	if len(candidates) == 0 {
+		candidates = defaults
+	}
+	for _, field := range candidates {
+		if v, ok := source[field].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// epochMillisThreshold separates epoch seconds from epoch milliseconds:
+// 1e11 seconds is in the year 5138, while 1e11 milliseconds is in 1973.
+const epochMillisThreshold = 1e11
+
+// epochPattern matches epoch timestamps written as plain decimals, leaving
+// out the NaN, Inf, exponent and hex forms strconv.ParseFloat accepts.
+var epochPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// parseTimestamp parses a document timestamp, trying in order RFC 3339 with
+// nanoseconds, RFC 3339, epoch milliseconds or seconds (as a finite number
+// or a plain decimal string), and finally the custom layouts.
+func parseTimestamp(value any, layouts []string) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, time.RFC3339} {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				return parsed, true
+			}
+		}
+		if epochPattern.MatchString(v) {
+			if epoch, err := strconv.ParseFloat(v, 64); err == nil {
+				return epochTime(epoch), true
+			}
+		}
+		for _, layout := range layouts {
+			if parsed, err := time.Parse(layout, v); err == nil {
+				return parsed, true
+			}
+		}
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return epochTime(v), true
+		}
+	case int64:
+		return epochTime(float64(v)), true
+	case int:
+		return epochTime(float64(v)), true
+	case json.Number:
+		if epoch, err := v.Float64(); err == nil && !math.IsNaN(epoch) && !math.IsInf(epoch, 0) {
+			return epochTime(epoch), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// epochTime converts epoch milliseconds or seconds to UTC time.
+func epochTime(epoch float64) time.Time {
+	if epoch >= epochMillisThreshold || epoch <= -epochMillisThreshold {
+		return time.UnixMilli(int64(epoch)).UTC()
+	}
+	seconds := int64(epoch)
+	nanos := int64((epoch - float64(seconds)) * float64(time.Second))
+	return time.Unix(seconds, nanos).UTC()
+}
+
+// flattenSource flattens nested objects into dotted keys, e.g.
+// {"kubernetes": {"pod": {"name": "x"}}} becomes {"kubernetes.pod.name": "x"}.
+// Keys never exceed maxDepth segments; objects below that depth are kept as-is.
+func flattenSource(source map[string]any, maxDepth int) map[string]any {
+	out := make(map[string]any, len(source))
+	flattenInto(out, "", source, 1, maxDepth)
+	return out
+}
+
+func flattenInto(out map[string]any, prefix string, m map[string]any, depth, maxDepth int) {
+	for key, value := range m {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok && len(nested) > 0 && depth < maxDepth {
+			flattenInto(out, key, nested, depth+1, maxDepth)
+			continue
+		}
+		out[key] = value
+	}
 }
 
 // parseConfig extracts and validates configuration.
 func parseConfig(cfg map[string]any) Config {
 	out := Config{
-		IndexPattern: "logs-*", // Default index pattern
+		IndexPattern:            "logs-*", // Default index pattern
+		StringifyLabelValues:    true,
+		Ordered:                 true,
+		RetryAttempts:           defaultRetryAttempts,
+		RetryJitter:             defaultRetryJitter,
+		CircuitBreakerThreshold: defaultCircuitBreakerThreshold,
 	}
 
 	// Parse addresses
-	if addrs, ok := cfg["addresses"].([]any); ok {
-		for _, addr := range addrs {
-			if strAddr, ok := addr.(string); ok {
-				out.Addresses = append(out.Addresses, strAddr)
-			}
-		}
+	if addrs, ok := stringList(cfg["addresses"]); ok {
+		out.Addresses = addrs
 	}
 
 	// Parse string fields
@@ -376,10 +1939,436 @@ func parseConfig(cfg map[string]any) Config {
 	if v, ok := cfg["indexPattern"].(string); ok && v != "" {
 		out.IndexPattern = v
 	}
+	if v, ok := intValue(cfg["defaultLimit"]); ok && v > 0 {
+		out.DefaultLimit = v
+	}
+	if v, ok := cfg["tiebreakerField"].(string); ok && v != "" {
+		out.TiebreakerField = v
+	}
+	if v, ok := intValue(cfg["flattenDepth"]); ok && v > 0 {
+		out.FlattenDepth = v
+	}
+	if v, ok := intValue(cfg["maxFieldBytes"]); ok && v > 0 {
+		out.MaxFieldBytes = v
+	}
+	if v, ok := intValue(cfg["maxEntryBytes"]); ok && v > 0 {
+		out.MaxEntryBytes = v
+	}
+	if v, ok := intValue(cfg["maxSearchLength"]); ok && v > 0 {
+		out.MaxSearchLength = v
+	}
+	if v, ok := boolValue(cfg["exactTotals"]); ok {
+		out.ExactTotals = v
+	}
+	if v, ok := boolValue(cfg["allowScriptFilters"]); ok {
+		out.AllowScriptFilters = v
+	}
+	if v, ok := boolValue(cfg["allowESQL"]); ok {
+		out.AllowESQL = v
+	}
+	if v, ok := cfg["entryMetadataLevel"].(string); ok {
+		switch v {
+		case metadataLevelNone, metadataLevelMinimal, metadataLevelFull:
+			out.EntryMetadataLevel = v
+		}
+	}
+	if v, ok := boolValue(cfg["resolveIndexTier"]); ok {
+		out.ResolveIndexTier = v
+	}
+	if v, ok := intValue(cfg["pageSize"]); ok && v > 0 {
+		out.PageSize = v
+	}
+	if v, ok := intValue(cfg["maxPages"]); ok && v > 0 {
+		out.MaxPages = v
+	}
+	if v, ok := intValue(cfg["maxEntriesInMemory"]); ok && v > 0 {
+		out.MaxEntriesInMemory = v
+	}
+	if v, ok := intValue(cfg["maxResultWindow"]); ok && v > 0 {
+		out.MaxResultWindow = v
+	}
+	if v, ok := intValue(cfg["maxBatchSize"]); ok && v > 0 {
+		out.MaxBatchSize = v
+	}
+	if v, ok := boolValue(cfg["allowWrites"]); ok {
+		out.AllowWrites = v
+	}
+	if v, ok := stringList(cfg["writeIndexPatterns"]); ok {
+		out.WriteIndexPatterns = v
+	}
+	if v, ok := intValue(cfg["writeBatchSize"]); ok && v > 0 {
+		out.WriteBatchSize = v
+	}
+	if v, ok := cfg["savedQueryIndex"].(string); ok && v != "" {
+		out.SavedQueryIndex = v
+	}
+	if v, ok := boolValue(cfg["percolateSavedQueries"]); ok {
+		out.PercolateSavedQueries = v
+	}
+	if v, ok := cfg["percolatorIndex"].(string); ok && v != "" {
+		out.PercolatorIndex = v
+	}
+	if v, ok := cfg["validateFields"].(string); ok && (v == validateFieldsOff || v == validateFieldsWarn || v == validateFieldsError) {
+		out.ValidateFields = v
+	}
+	if v, ok := cfg["slowQueryThreshold"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.SlowQueryThreshold = d
+		}
+	}
+	if v, ok := intValue(cfg["slowQueryBuffer"]); ok && v > 0 {
+		out.SlowQueryBuffer = v
+	}
+	if v, ok := boolValue(cfg["allowWatchManagement"]); ok {
+		out.AllowWatchManagement = v
+	}
+	if v, ok := cfg["kibanaURL"].(string); ok {
+		out.KibanaURL = v
+	}
+	if v, ok := cfg["exportDir"].(string); ok {
+		out.ExportDir = v
+	}
+	if v, ok := cfg["assumeVersion"].(string); ok {
+		out.AssumeVersion = v
+	}
+	if v, ok := intValue(cfg["retryAttempts"]); ok && v > 0 {
+		out.RetryAttempts = min(v, maxRetryAttempts)
+	}
+	if v, ok := cfg["retryBaseDelay"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.RetryBaseDelay = d
+		}
+	}
+	if v, ok := cfg["retryMaxDelay"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.RetryMaxDelay = d
+		}
+	}
+	if v, ok := cfg["retryJitter"].(float64); ok && v >= 0 && v <= 1 {
+		out.RetryJitter = v
+	}
+	if v, ok := intValue(cfg["circuitBreakerThreshold"]); ok && v >= 0 {
+		out.CircuitBreakerThreshold = v
+	}
+	if v, ok := cfg["circuitBreakerCooldown"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.CircuitBreakerCooldown = d
+		}
+	}
+	if v, ok := cfg["rateLimit"].(float64); ok && v > 0 {
+		out.RateLimit = v
+	} else if v, ok := intValue(cfg["rateLimit"]); ok && v > 0 {
+		out.RateLimit = float64(v)
+	}
+	if v, ok := intValue(cfg["rateLimitBurst"]); ok && v > 0 {
+		out.RateLimitBurst = v
+	}
+	if v, ok := cfg["rateLimitMaxWait"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.RateLimitMaxWait = d
+		}
+	}
+	if v, ok := boolValue(cfg["fanOut"]); ok {
+		out.FanOut = v
+	}
+	if v, ok := intValue(cfg["fanOutConcurrency"]); ok && v > 0 {
+		out.FanOutConcurrency = v
+	}
+	if v, ok := cfg["responseCompression"].(string); ok {
+		switch v {
+		case compressionGzip, compressionZstd, compressionNone:
+			out.ResponseCompression = v
+		}
+	}
+	if v, ok := intValue(cfg["maxIdleConns"]); ok && v > 0 {
+		out.MaxIdleConns = v
+	}
+	if v, ok := intValue(cfg["maxIdleConnsPerHost"]); ok && v > 0 {
+		out.MaxIdleConnsPerHost = v
+	}
+	if v, ok := intValue(cfg["maxConnsPerHost"]); ok && v > 0 {
+		out.MaxConnsPerHost = v
+	}
+	if v, ok := cfg["idleConnTimeout"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.IdleConnTimeout = d
+		}
+	}
+	if v, ok := boolValue(cfg["skipStartupPing"]); ok {
+		out.SkipStartupPing = v
+	}
+	out.LazyConnect = out.SkipStartupPing
+	if v, ok := boolValue(cfg["lazyConnect"]); ok {
+		out.LazyConnect = v
+	}
+	if v, ok := cfg["healthProbeInterval"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.HealthProbeInterval = d
+		}
+	}
+	if v, ok := intValue(cfg["queryCacheSize"]); ok && v > 0 {
+		out.QueryCacheSize = v
+	}
+	if v, ok := cfg["queryCacheTTL"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.QueryCacheTTL = d
+		}
+	}
+	if names, ok := stringList(cfg["retryOn"]); ok {
+		out.RetryOn = []error{}
+		for _, name := range names {
+			if category, ok := retryCategories[name]; ok {
+				out.RetryOn = append(out.RetryOn, category)
+			}
+		}
+	}
+	if v, ok := cfg["auditLog"].(string); ok {
+		out.AuditLog = v
+	}
+	if v, ok := intValue(cfg["auditMaxBytes"]); ok && v > 0 {
+		out.AuditMaxBytes = int64(v)
+	}
+	if v, ok := stringList(cfg["auditMethods"]); ok {
+		out.AuditMethods = v
+	}
+	if v, ok := boolValue(cfg["metering"]); ok {
+		out.Metering = v
+	}
+	if v, ok := cfg["meteringIndex"].(string); ok {
+		out.MeteringIndex = v
+	}
+	if v, ok := cfg["meteringInterval"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.MeteringInterval = d
+		}
+	}
+	if v, ok := cfg["asyncWaitTimeout"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.AsyncWaitTimeout = d
+		}
+	}
+	if v, ok := cfg["asyncKeepAlive"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.AsyncKeepAlive = d
+		}
+	}
+	if patterns, ok := stringList(cfg["allowedIndexPatterns"]); ok {
+		out.AllowedIndexPatterns = patterns
+	}
+	if v, ok := cfg["tailInterval"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.TailInterval = d
+		}
+	}
+	if v, ok := cfg["tailOverlap"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.TailOverlap = d
+		}
+	}
+	if v, ok := cfg["fieldCacheTTL"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.FieldCacheTTL = d
+		}
+	}
+	if v, ok := cfg["pagination"].(string); ok {
+		switch v {
+		case paginationAuto, paginationSearchAfter, paginationPIT, paginationScroll:
+			out.Pagination = v
+		}
+	}
+	if v, ok := intValue(cfg["parallelism"]); ok && v > 0 {
+		out.Parallelism = v
+	}
+	if v, ok := boolValue(cfg["ordered"]); ok {
+		out.Ordered = v
+	}
+	if v, ok := cfg["scrollTTL"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			out.ScrollTTL = d
+		}
+	}
+	if v, ok := boolValue(cfg["pointInTime"]); ok {
+		out.PointInTime = v
+	}
+	if v, ok := cfg["cursorSecret"].(string); ok {
+		out.CursorSecret = v
+	}
+	if v, ok := cfg["pitKeepAlive"].(string); ok {
+		out.PITKeepAlive = v
+	}
+	if v, ok := boolValue(cfg["dedupeResults"]); ok {
+		out.DedupeResults = v
+	}
+	if v, ok := boolValue(cfg["stringifyLabelValues"]); ok {
+		out.StringifyLabelValues = v
+	}
+
+	// Parse field lists
+	if v, ok := stringList(cfg["labelFields"]); ok {
+		out.LabelFields = v
+	}
+	if v, ok := stringList(cfg["dropFields"]); ok {
+		out.DropFields = v
+	}
+	if v, ok := stringList(cfg["redactFields"]); ok {
+		out.RedactFields = v
+	}
+	if v, ok := cfg["redactMode"].(string); ok && (v == redactModeRemove || v == redactModeMask) {
+		out.RedactMode = v
+	}
+
+	if v, ok := stringList(cfg["timestampLayouts"]); ok {
+		out.TimestampLayouts = v
+	}
+
+	if v, ok := stringList(cfg["messageFields"]); ok {
+		out.MessageFields = v
+	}
+	if v, ok := stringList(cfg["severityFields"]); ok {
+		out.SeverityFields = v
+	}
+	// Parse message composition; each part is a field name or an object with
+	// field and separator
+	if parts, ok := cfg["messageComposition"].([]any); ok {
+		for _, item := range parts {
+			switch v := item.(type) {
+			case string:
+				if v != "" {
+					out.MessageComposition = append(out.MessageComposition, MessagePart{Field: v, Separator: "\n"})
+				}
+			case map[string]any:
+				field, _ := v["field"].(string)
+				if field == "" {
+					continue
+				}
+				separator, ok := v["separator"].(string)
+				if !ok {
+					separator = "\n"
+				}
+				out.MessageComposition = append(out.MessageComposition, MessagePart{Field: field, Separator: separator})
+			}
+		}
+	}
+	if v, ok := stringList(cfg["traceIdFields"]); ok {
+		out.TraceIDFields = v
+	}
+	if v, ok := stringList(cfg["spanIdFields"]); ok {
+		out.SpanIDFields = v
+	}
+
+	if fields, ok := cfg["severityNumberFields"].(map[string]any); ok {
+		out.SeverityNumberFields = make(map[string]string, len(fields))
+		for field, scheme := range fields {
+			if scheme, ok := scheme.(string); ok {
+				if _, known := severityTables[scheme]; known {
+					out.SeverityNumberFields[field] = scheme
+				}
+			}
+		}
+	}
+
+	// Parse field mappings; each value is a field name or a list of them
+	if v, ok := fieldMapping(cfg["scopeFields"]); ok {
+		out.ScopeFields = v
+	}
+	if v, ok := boolValue(cfg["exactScopeMatch"]); ok {
+		out.ExactScopeMatch = v
+	}
+	if v, ok := fieldMapping(cfg["identityFields"]); ok {
+		out.IdentityFields = v
+	}
 
 	return out
 }
 
+// truncateUTF8 shortens s to at most maxBytes without splitting a multi-byte rune.
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
+
+// intValue converts a numeric config value to an int. Config decoded from JSON
+// carries numbers as float64, while in-process callers usually pass ints.
+func intValue(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			return 0, false
+		}
+		return int(i), true
+	default:
+		return 0, false
+	}
+}
+
+// stringList converts a config list to []string. JSON-decoded config carries
+// lists as []any; non-string elements are skipped. A present but empty list
+// yields a non-nil empty slice so callers can tell it apart from an absent key.
+func stringList(v any) ([]string, bool) {
+	switch list := v.(type) {
+	case []string:
+		return append([]string{}, list...), true
+	case []any:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if str, ok := item.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// fieldMapping converts a config object whose values are a field name or a
+// list of field names into a map of candidate lists.
+func fieldMapping(v any) (map[string][]string, bool) {
+	mapping, ok := v.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	out := make(map[string][]string, len(mapping))
+	for key, value := range mapping {
+		if field, ok := value.(string); ok && field != "" {
+			out[key] = []string{field}
+		} else if fields, ok := stringList(value); ok {
+			out[key] = fields
+		}
+	}
+	return out, true
+}
+
+// boolValue converts a config or metadata value to a bool, accepting both JSON
+// booleans and their string forms.
+func boolValue(v any) (bool, bool) {
+	switch b := v.(type) {
+	case bool:
+		return b, true
+	case string:
+		switch strings.ToLower(b) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
 // buildKibanaURL constructs a URL to view logs in Kibana Discover.
 func buildKibanaURL(baseURL, indexPattern string, query schema.LogQuery) string {
 	if baseURL == "" {
@@ -440,19 +2429,77 @@ func buildKibanaURL(baseURL, indexPattern string, query schema.LogQuery) string
 	return url
 }
 
+// decodeSearchResponse decodes a search response body. Numbers in _source are
+// decoded as json.Number so integer values survive without float rounding.
+func decodeSearchResponse(body io.Reader) (esSearchResponse, error) {
+	var result esSearchResponse
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return esSearchResponse{}, err
+	}
+	return result, nil
+}
+
 // Elasticsearch response types
 type esSearchResponse struct {
+	PITID    string `json:"pit_id"`
+	ScrollID string `json:"_scroll_id"`
+	Took     int    `json:"took"`
+	TimedOut bool   `json:"timed_out"`
+	Shards   struct {
+		Total    int `json:"total"`
+		Failed   int `json:"failed"`
+		Failures []struct {
+			Index  string `json:"index"`
+			Shard  int    `json:"shard"`
+			Reason struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"reason"`
+		} `json:"failures"`
+	} `json:"_shards"`
 	Hits struct {
 		Total struct {
-			Value int `json:"value"`
+			Value    int    `json:"value"`
+			Relation string `json:"relation"`
 		} `json:"total"`
 		Hits []esHit `json:"hits"`
 	} `json:"hits"`
 }
 
+// usage reports the documents returned and the took time, for metering.
+func (r esSearchResponse) usage() (documents, took int64) {
+	return int64(len(r.Hits.Hits)), int64(r.Took)
+}
+
+// stats extracts execution statistics from a search response.
+func (r esSearchResponse) stats() QueryStats {
+	stats := QueryStats{
+		TotalHits:         r.Hits.Total.Value,
+		TotalHitsRelation: r.Hits.Total.Relation,
+		TookMillis:        r.Took,
+		TimedOut:          r.TimedOut,
+		totalShards:       r.Shards.Total,
+	}
+	for _, failure := range r.Shards.Failures {
+		stats.ShardFailures = append(stats.ShardFailures, ShardFailure{
+			Index:  failure.Index,
+			Shard:  failure.Shard,
+			Type:   failure.Reason.Type,
+			Reason: failure.Reason.Reason,
+		})
+	}
+	return stats
+}
+
 type esHit struct {
-	Index  string                 `json:"_index"`
-	ID     string                 `json:"_id"`
-	Score  float64                `json:"_score"`
-	Source map[string]interface{} `json:"_source"`
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+	// Score is nil when results are sorted by a field rather than relevance.
+	Score   *float64               `json:"_score"`
+	Routing string                 `json:"_routing,omitempty"`
+	Version *int64                 `json:"_version,omitempty"`
+	Source  map[string]interface{} `json:"_source"`
+	Sort    []any                  `json:"sort,omitempty"`
 }
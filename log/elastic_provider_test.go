@@ -1,8 +1,24 @@
 package log
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/opsorch/opsorch-core/schema"
 )
 
@@ -46,6 +62,16 @@ func TestParseConfig(t *testing.T) {
 				IndexPattern: "logs-*",
 			},
 		},
+		{
+			name: "default limit from JSON number",
+			input: map[string]any{
+				"defaultLimit": float64(200),
+			},
+			expected: Config{
+				IndexPattern: "logs-*",
+				DefaultLimit: 200,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -61,144 +87,1546 @@ func TestParseConfig(t *testing.T) {
 				}
 			}
 
-			if result.Username != tt.expected.Username {
-				t.Errorf("username = %s, want %s", result.Username, tt.expected.Username)
+			if result.Username != tt.expected.Username {
+				t.Errorf("username = %s, want %s", result.Username, tt.expected.Username)
+			}
+			if result.Password != tt.expected.Password {
+				t.Errorf("password = %s, want %s", result.Password, tt.expected.Password)
+			}
+			if result.APIKey != tt.expected.APIKey {
+				t.Errorf("apiKey = %s, want %s", result.APIKey, tt.expected.APIKey)
+			}
+			if result.CloudID != tt.expected.CloudID {
+				t.Errorf("cloudID = %s, want %s", result.CloudID, tt.expected.CloudID)
+			}
+			if result.IndexPattern != tt.expected.IndexPattern {
+				t.Errorf("indexPattern = %s, want %s", result.IndexPattern, tt.expected.IndexPattern)
+			}
+			if result.DefaultLimit != tt.expected.DefaultLimit {
+				t.Errorf("defaultLimit = %d, want %d", result.DefaultLimit, tt.expected.DefaultLimit)
+			}
+		})
+	}
+}
+
+func TestValidateQuerySearchLength(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{MaxSearchLength: 16}}
+
+	ok := schema.LogQuery{Expression: &schema.LogExpression{Search: "ошибка🔥"}}
+	if err := p.validateQuery(ok); err != nil {
+		t.Errorf("unexpected error for short multi-byte search: %v", err)
+	}
+
+	tooLong := schema.LogQuery{Expression: &schema.LogExpression{Search: strings.Repeat("🔥", 10)}}
+	err := p.validateQuery(tooLong)
+	if err == nil {
+		t.Fatal("expected error for over-length search")
+	}
+	if !strings.Contains(err.Error(), "exceeding the maximum of 16") {
+		t.Errorf("error = %q, want it to name the limit", err)
+	}
+	if !utf8.ValidString(err.Error()) {
+		t.Errorf("error message is not valid UTF-8: %q", err)
+	}
+
+	defaults := &ElasticProvider{}
+	stackTrace := schema.LogQuery{Expression: &schema.LogExpression{Search: strings.Repeat("x", defaultMaxSearchLength+1)}}
+	if err := defaults.validateQuery(stackTrace); err == nil {
+		t.Error("expected default limit to reject a search over 32KB")
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		maxBytes int
+		expected string
+	}{
+		{name: "short ascii", input: "error", maxBytes: 10, expected: "error"},
+		{name: "ascii cut", input: "connection refused", maxBytes: 10, expected: "connection"},
+		{name: "cut inside two-byte rune", input: "aé", maxBytes: 2, expected: "a"},
+		{name: "cut inside four-byte rune", input: "ab🔥", maxBytes: 5, expected: "ab"},
+		{name: "cut on rune boundary", input: "ab🔥c", maxBytes: 6, expected: "ab🔥"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := truncateUTF8(tt.input, tt.maxBytes)
+			if result != tt.expected {
+				t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tt.input, tt.maxBytes, result, tt.expected)
+			}
+			if !utf8.ValidString(result) {
+				t.Errorf("result %q is not valid UTF-8", result)
+			}
+		})
+	}
+}
+
+func TestBuildFilterClause(t *testing.T) {
+	p := &ElasticProvider{}
+
+	tests := []struct {
+		name     string
+		filter   schema.LogFilter
+		expected map[string]any
+	}{
+		{
+			name: "equals operator",
+			filter: schema.LogFilter{
+				Field:    "status",
+				Operator: "=",
+				Value:    "200",
+			},
+			expected: map[string]any{
+				"term": map[string]any{
+					"status": "200",
+				},
+			},
+		},
+		{
+			name: "not equals operator",
+			filter: schema.LogFilter{
+				Field:    "status",
+				Operator: "!=",
+				Value:    "200",
+			},
+			expected: map[string]any{
+				"bool": map[string]any{
+					"must_not": map[string]any{
+						"term": map[string]any{
+							"status": "200",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "contains operator",
+			filter: schema.LogFilter{
+				Field:    "message",
+				Operator: "contains",
+				Value:    "error",
+			},
+			expected: map[string]any{
+				"wildcard": map[string]any{
+					"message": map[string]any{
+						"value": "*error*",
+					},
+				},
+			},
+		},
+		{
+			name: "regex operator",
+			filter: schema.LogFilter{
+				Field:    "url",
+				Operator: "regex",
+				Value:    "/api/.*",
+			},
+			expected: map[string]any{
+				"regexp": map[string]any{
+					"url": map[string]any{
+						"value": "/api/.*",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := p.buildFilterClause(tt.filter)
+			// Basic structural validation
+			if err != nil || result == nil {
+				t.Fatalf("expected a clause, got %v", err)
+			}
+			// Note: Deep comparison would require more sophisticated testing
+			// This validates the basic structure is created
+		})
+	}
+}
+
+func TestBuildQuerySize(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		limit    int
+		expected int
+	}{
+		{name: "built-in default", expected: 1000},
+		{name: "configured default", cfg: Config{DefaultLimit: 50}, expected: 50},
+		{name: "query limit wins", cfg: Config{DefaultLimit: 50}, limit: 10, expected: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ElasticProvider{cfg: tt.cfg}
+			esQuery := p.buildQuery(schema.LogQuery{Limit: tt.limit})
+			if esQuery["size"] != tt.expected {
+				t.Errorf("size = %v, want %d", esQuery["size"], tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildQueryTrackTotalHits(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		metadata map[string]any
+		expected any
+	}{
+		{name: "bounded by default", expected: 10000},
+		{name: "exact from config", cfg: Config{ExactTotals: true}, expected: true},
+		{name: "exact from query metadata", metadata: map[string]any{QueryOptionExactTotals: true}, expected: true},
+		{name: "exact from string metadata", metadata: map[string]any{QueryOptionExactTotals: "true"}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ElasticProvider{cfg: tt.cfg}
+			esQuery := p.buildQuery(schema.LogQuery{Metadata: tt.metadata})
+			if esQuery["track_total_hits"] != tt.expected {
+				t.Errorf("track_total_hits = %v, want %v", esQuery["track_total_hits"], tt.expected)
+			}
+
+			must := esQuery["query"].(map[string]any)["bool"].(map[string]any)["must"].([]map[string]any)
+			if len(must) != 0 {
+				t.Errorf("reserved metadata key should not become a filter, got %v", must)
+			}
+		})
+	}
+}
+
+func TestBuildQueryScopeFields(t *testing.T) {
+	scopeFields := map[string]any{
+		"service":     []any{"service", "service.name", "app"},
+		"environment": "deployment.environment",
+	}
+	query := schema.LogQuery{
+		Scope:    schema.QueryScope{Service: "checkout", Environment: "prod", Team: "payments"},
+		Metadata: map[string]any{"region.keyword": "eu-west-1", "http.status": 500},
+	}
+	tests := []struct {
+		name  string
+		exact bool
+		want  []string
+	}{
+		{
+			name: "keyword or phrase",
+			want: []string{
+				`{"bool":{"minimum_should_match":1,"should":[{"term":{"service.keyword":"checkout"}},{"match_phrase":{"service":"checkout"}},{"term":{"service.name.keyword":"checkout"}},{"match_phrase":{"service.name":"checkout"}},{"term":{"app.keyword":"checkout"}},{"match_phrase":{"app":"checkout"}}]}}`,
+				`{"bool":{"minimum_should_match":1,"should":[{"term":{"deployment.environment.keyword":"prod"}},{"match_phrase":{"deployment.environment":"prod"}}]}}`,
+				`{"bool":{"minimum_should_match":1,"should":[{"term":{"team.keyword":"payments"}},{"match_phrase":{"team":"payments"}}]}}`,
+				`{"term":{"http.status":500}}`,
+				`{"term":{"region.keyword":"eu-west-1"}}`,
+			},
+		},
+		{
+			name:  "exact",
+			exact: true,
+			want: []string{
+				`{"bool":{"minimum_should_match":1,"should":[{"term":{"service":"checkout"}},{"term":{"service.name":"checkout"}},{"term":{"app":"checkout"}}]}}`,
+				`{"term":{"deployment.environment":"prod"}}`,
+				`{"term":{"team":"payments"}}`,
+				`{"term":{"http.status":500}}`,
+				`{"term":{"region.keyword":"eu-west-1"}}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ElasticProvider{cfg: parseConfig(map[string]any{"scopeFields": scopeFields, "exactScopeMatch": tt.exact})}
+			must := p.buildQuery(query)["query"].(map[string]any)["bool"].(map[string]any)["must"].([]map[string]any)
+			if len(must) != len(tt.want) {
+				t.Fatalf("must clauses = %d, want %d", len(must), len(tt.want))
+			}
+			// Metadata follows the scopes in map order
+			got := make([]string, len(must))
+			for i, clause := range must {
+				data, _ := json.Marshal(clause)
+				got[i] = string(data)
+			}
+			sort.Strings(got[3:])
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("must[%d] = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// rangeServer answers searches with the documents at times that fall in
+// the request's @timestamp range, as Elasticsearch applies it.
+func rangeServer(t *testing.T, times []time.Time) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		var body struct {
+			Query struct {
+				Bool struct {
+					Must []struct {
+						Range struct {
+							Timestamp map[string]string `json:"@timestamp"`
+						} `json:"range"`
+					} `json:"must"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil || len(body.Query.Bool.Must) == 0 {
+			t.Fatalf("body = %s, want a range clause", req.Body)
+		}
+		bounds := body.Query.Bool.Must[0].Range.Timestamp
+		if bounds["format"] != timeRangeFormat {
+			t.Errorf("range = %v, want the format hint", bounds)
+		}
+		bound := func(op string) (time.Time, bool) {
+			v, ok := bounds[op]
+			if !ok {
+				return time.Time{}, false
+			}
+			ts, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				t.Fatalf("range %s = %q: %v", op, v, err)
+			}
+			return ts, true
+		}
+		var hits []string
+		for _, ts := range times {
+			if gte, ok := bound("gte"); ok && ts.Before(gte) {
+				continue
+			}
+			if lte, ok := bound("lte"); ok && ts.After(lte) {
+				continue
+			}
+			if lt, ok := bound("lt"); ok && !ts.Before(lt) {
+				continue
+			}
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_source":{"@timestamp":%q}}`, ts.Format(time.RFC3339Nano), ts.Format(time.RFC3339Nano)))
+		}
+		return 200, fmt.Sprintf(`{"hits":{"total":{"value":%d},"hits":[%s]}}`, len(hits), strings.Join(hits, ","))
+	}
+}
+
+func TestTimeRangeBoundaries(t *testing.T) {
+	spike := time.Date(2023, 10, 1, 12, 0, 0, 250_000_000, time.UTC)
+	start, end := spike.Add(-250*time.Millisecond), spike.Add(250*time.Millisecond)
+	times := []time.Time{
+		start.Add(-time.Millisecond),
+		start,
+		spike,
+		end,
+		end.Add(time.Millisecond),
+	}
+	ids := func(entries []schema.LogEntry) []string {
+		var ids []string
+		for _, entry := range entries {
+			ids = append(ids, entry.Metadata["_id"].(string))
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	format := func(times ...time.Time) []string {
+		var ids []string
+		for _, ts := range times {
+			ids = append(ids, ts.Format(time.RFC3339Nano))
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	p, _ := newTestProvider(t, Config{}, rangeServer(t, times))
+
+	// Both bounds are inclusive, to the millisecond
+	result, err := p.Query(context.Background(), schema.LogQuery{Start: start, End: end})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if got, want := ids(result.Entries), format(start, spike, end); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+
+	// Tiled windows with an exclusive end count the boundary once
+	exclusive := map[string]any{QueryOptionEndExclusive: true}
+	first, err := p.Query(context.Background(), schema.LogQuery{Start: start, End: spike, Metadata: exclusive})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	second, err := p.Query(context.Background(), schema.LogQuery{Start: spike, End: end, Metadata: exclusive})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if got, want := ids(first.Entries), format(start); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("first window = %v, want %v", got, want)
+	}
+	if got, want := ids(second.Entries), format(spike); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("second window = %v, want %v", got, want)
+	}
+}
+
+func TestNormalizeHitServicePrecedence(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{
+		ScopeFields: map[string][]string{"service": {"service.name", "app"}},
+	}}
+
+	tests := []struct {
+		name     string
+		source   map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "first candidate wins",
+			source:   map[string]interface{}{"service": map[string]interface{}{"name": "checkout"}, "app": "legacy"},
+			expected: "checkout",
+		},
+		{
+			name:     "falls back to later candidate",
+			source:   map[string]interface{}{"app": "legacy"},
+			expected: "legacy",
+		},
+		{
+			name:     "no candidate present",
+			source:   map[string]interface{}{"service": "ignored-unless-configured"},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := normalizeHit(p, esHit{Source: tt.source})
+			if entry.Service != tt.expected {
+				t.Errorf("service = %q, want %q", entry.Service, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeResponseTotalRelation(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		value    int
+		relation string
+	}{
+		{
+			name:     "exact",
+			body:     `{"hits":{"total":{"value":42,"relation":"eq"},"hits":[{"_id":"1","_source":{}}]}}`,
+			value:    42,
+			relation: "eq",
+		},
+		{
+			name:     "lower bound",
+			body:     `{"hits":{"total":{"value":10000,"relation":"gte"},"hits":[{"_id":"1","_source":{}}]}}`,
+			value:    10000,
+			relation: "gte",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result esSearchResponse
+			if err := json.Unmarshal([]byte(tt.body), &result); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			entries := normalizeResponse(&ElasticProvider{}, result)
+			if entries[0].Metadata[MetadataTotalHits] != tt.value {
+				t.Errorf("total_hits = %v, want %d", entries[0].Metadata[MetadataTotalHits], tt.value)
+			}
+			if entries[0].Metadata[MetadataTotalHitsRelation] != tt.relation {
+				t.Errorf("total_hits_relation = %v, want %s", entries[0].Metadata[MetadataTotalHitsRelation], tt.relation)
+			}
+		})
+	}
+}
+
+func TestSearchResponseStats(t *testing.T) {
+	body := `{
+		"took": 137,
+		"timed_out": true,
+		"_shards": {
+			"total": 5,
+			"successful": 4,
+			"skipped": 0,
+			"failed": 1,
+			"failures": [{
+				"shard": 3,
+				"index": "logs-2023.10.01",
+				"node": "n1",
+				"reason": {"type": "query_shard_exception", "reason": "failed to create query"}
+			}]
+		},
+		"hits": {"total": {"value": 2000000, "relation": "gte"}, "hits": []}
+	}`
+	result, err := decodeSearchResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	stats := result.stats()
+	if stats.TotalHits != 2000000 {
+		t.Errorf("TotalHits = %d, want 2000000", stats.TotalHits)
+	}
+	if stats.TotalHitsRelation != "gte" {
+		t.Errorf("TotalHitsRelation = %q, want gte", stats.TotalHitsRelation)
+	}
+	if stats.TookMillis != 137 {
+		t.Errorf("TookMillis = %d, want 137", stats.TookMillis)
+	}
+	if !stats.TimedOut {
+		t.Error("TimedOut = false, want true")
+	}
+	if len(stats.ShardFailures) != 1 {
+		t.Fatalf("ShardFailures = %v, want 1 failure", stats.ShardFailures)
+	}
+	failure := stats.ShardFailures[0]
+	if failure.Index != "logs-2023.10.01" || failure.Shard != 3 || failure.Type != "query_shard_exception" || failure.Reason != "failed to create query" {
+		t.Errorf("ShardFailures[0] = %+v", failure)
+	}
+}
+
+func TestBuildQuerySortTiebreaker(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		tiebreaker string
+	}{
+		{name: "default tiebreaker", tiebreaker: "_doc"},
+		{name: "configured tiebreaker", cfg: Config{TiebreakerField: "event.id"}, tiebreaker: "event.id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			esQuery := (&ElasticProvider{cfg: tt.cfg}).buildQuery(schema.LogQuery{})
+			sort := esQuery["sort"].([]map[string]any)
+			if len(sort) != 2 {
+				t.Fatalf("sort = %v, want timestamp and tiebreaker", sort)
+			}
+			if _, ok := sort[0]["@timestamp"]; !ok {
+				t.Errorf("sort[0] = %v, want @timestamp", sort[0])
+			}
+			if _, ok := sort[1][tt.tiebreaker]; !ok {
+				t.Errorf("sort[1] = %v, want %s", sort[1], tt.tiebreaker)
+			}
+		})
+	}
+}
+
+func TestBuildQuerySortOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order any
+		want  string
+	}{
+		{name: "default newest first", want: "desc"},
+		{name: "ascending", order: "asc", want: "asc"},
+		{name: "case insensitive", order: "ASC", want: "asc"},
+		{name: "explicit descending", order: "desc", want: "desc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := schema.LogQuery{Metadata: map[string]any{}}
+			if tt.order != nil {
+				query.Metadata[QueryOptionOrder] = tt.order
+			}
+			esQuery := (&ElasticProvider{}).buildQuery(query)
+
+			sort := esQuery["sort"].([]map[string]any)
+			for i, clause := range sort {
+				for field, opts := range clause {
+					if got := opts.(map[string]any)["order"]; got != tt.want {
+						t.Errorf("sort[%d] %s order = %v, want %s", i, field, got, tt.want)
+					}
+				}
+			}
+			if strings.Contains(fmt.Sprint(esQuery["query"]), QueryOptionOrder) {
+				t.Errorf("query = %v, reserved %s key must not become a filter", esQuery["query"], QueryOptionOrder)
+			}
+		})
+	}
+}
+
+func TestValidateQueryRejectsUnknownOrder(t *testing.T) {
+	p := &ElasticProvider{}
+	err := p.validateQuery(schema.LogQuery{Metadata: map[string]any{QueryOptionOrder: "sideways"}})
+	if err == nil || !strings.Contains(err.Error(), "sideways") {
+		t.Errorf("err = %v, want invalid order error", err)
+	}
+}
+
+func TestQueryAscendingReturnsOldestWithinLimit(t *testing.T) {
+	timestamps := []string{
+		"2023-10-01T12:00:04Z",
+		"2023-10-01T12:00:01Z",
+		"2023-10-01T12:00:05Z",
+		"2023-10-01T12:00:02Z",
+		"2023-10-01T12:00:03Z",
+	}
+	// The handler plays the server: it sorts the whole window by the
+	// requested order and applies size, as Elasticsearch would.
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		var body struct {
+			Size int                         `json:"size"`
+			Sort []map[string]map[string]any `json:"sort"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		sorted := append([]string{}, timestamps...)
+		asc := body.Sort[0]["@timestamp"]["order"] == "asc"
+		for i := range sorted {
+			for j := i + 1; j < len(sorted); j++ {
+				if (sorted[j] < sorted[i]) == asc {
+					sorted[i], sorted[j] = sorted[j], sorted[i]
+				}
+			}
+		}
+		hits := make([]string, 0, body.Size)
+		for _, ts := range sorted[:body.Size] {
+			hits = append(hits, fmt.Sprintf(`{"_source":{"@timestamp":%q}}`, ts))
+		}
+		return 200, `{"hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+	})
+
+	entries, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+		Limit:    2,
+		Metadata: map[string]any{QueryOptionOrder: "asc"},
+	})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	want := []string{"2023-10-01T12:00:01Z", "2023-10-01T12:00:02Z"}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %d, want %d", len(entries), len(want))
+	}
+	for i, ts := range want {
+		if got := entries[i].Timestamp.Format(time.RFC3339); got != ts {
+			t.Errorf("entries[%d] = %s, want %s", i, got, ts)
+		}
+	}
+}
+
+func TestNormalizeHitSortValues(t *testing.T) {
+	body := `{"hits":{"hits":[
+		{"_id":"a","_source":{},"sort":[1696161600123,9007199254740993]},
+		{"_id":"b","_source":{}}
+	]}}`
+	result, err := decodeSearchResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	entries := normalizeResponse(&ElasticProvider{}, result)
+
+	sortValues, ok := entries[0].Metadata[MetadataSort].([]any)
+	if !ok || len(sortValues) != 2 {
+		t.Fatalf("metadata[sort] = %#v, want two sort values", entries[0].Metadata[MetadataSort])
+	}
+	if sortValues[0] != json.Number("1696161600123") || sortValues[1] != json.Number("9007199254740993") {
+		t.Errorf("metadata[sort] = %#v, want exact numeric values", sortValues)
+	}
+
+	encoded, err := json.Marshal(entries[0].Metadata[MetadataSort])
+	if err != nil {
+		t.Fatalf("failed to encode sort values: %v", err)
+	}
+	if string(encoded) != "[1696161600123,9007199254740993]" {
+		t.Errorf("encoded sort = %s, want values unchanged", encoded)
+	}
+
+	if _, ok := entries[1].Metadata[MetadataSort]; ok {
+		t.Error("metadata[sort] should be absent when the hit has no sort values")
+	}
+}
+
+func TestNormalizeResponseTotalHits(t *testing.T) {
+	p := &ElasticProvider{}
+
+	var result esSearchResponse
+	result.Hits.Total.Value = 2000000
+	result.Hits.Hits = []esHit{
+		{ID: "a", Source: map[string]interface{}{"message": "first"}},
+		{ID: "b", Source: map[string]interface{}{"message": "second"}},
+	}
+
+	entries := normalizeResponse(p, result)
+	if len(entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Metadata[MetadataTotalHits] != 2000000 {
+			t.Errorf("entries[%d].metadata[%s] = %v, want 2000000", i, MetadataTotalHits, entry.Metadata[MetadataTotalHits])
+		}
+	}
+}
+
+func TestBuildFilterClauseGeoDistance(t *testing.T) {
+	p := &ElasticProvider{}
+
+	clause, err := p.buildFilterClause(schema.LogFilter{
+		Field:    "geo.location",
+		Operator: "geo_distance",
+		Value:    "50.1, 8.6, 50km",
+	})
+	if err != nil {
+		t.Fatalf("expected geo_distance clause, got %v", err)
+	}
+
+	geo := clause["geo_distance"].(map[string]any)
+	if geo["distance"] != "50km" {
+		t.Errorf("distance = %v, want 50km", geo["distance"])
+	}
+	point := geo["geo.location"].(map[string]any)
+	if point["lat"] != 50.1 || point["lon"] != 8.6 {
+		t.Errorf("point = %v, want lat 50.1 lon 8.6", point)
+	}
+}
+
+func TestBuildFilterClauseUnusable(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		filter schema.LogFilter
+	}{
+		{name: "unknown operator", filter: schema.LogFilter{Field: "service", Operator: "~", Value: "api"}},
+		{name: "bad geo_distance", filter: schema.LogFilter{Field: "geo.location", Operator: "geo_distance", Value: "50.1,8.6"}},
+		{name: "script disabled", filter: schema.LogFilter{Operator: "script", Value: `{"source": "true"}`}},
+		{name: "bad script", cfg: Config{AllowScriptFilters: true}, filter: schema.LogFilter{Operator: "script", Value: `{"source": ""}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ElasticProvider{cfg: tt.cfg}
+			if clause, err := p.buildFilterClause(tt.filter); err == nil {
+				t.Fatalf("clause = %v, want an error", clause)
+			}
+
+			// The query matches nothing rather than dropping the filter
+			query := p.boolQuery(schema.LogQuery{Expression: &schema.LogExpression{Filters: []schema.LogFilter{tt.filter}}})
+			must := query["bool"].(map[string]any)["must"].([]map[string]any)
+			if len(must) != 1 || must[0]["match_none"] == nil {
+				t.Errorf("must = %v, want match_none", must)
+			}
+		})
+	}
+}
+
+func TestValidateQueryGeoDistance(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "valid", value: "50.1,8.6,50km"},
+		{name: "valid miles with decimals", value: "-33.86,151.2,12.5mi"},
+		{name: "missing distance", value: "50.1,8.6", wantErr: true},
+		{name: "latitude out of range", value: "91,8.6,50km", wantErr: true},
+		{name: "longitude not a number", value: "50.1,east,50km", wantErr: true},
+		{name: "unknown unit", value: "50.1,8.6,50parsecs", wantErr: true},
+		{name: "missing unit", value: "50.1,8.6,50", wantErr: true},
+	}
+
+	p := &ElasticProvider{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.validateQuery(schema.LogQuery{
+				Expression: &schema.LogExpression{
+					Filters: []schema.LogFilter{{Field: "geo.location", Operator: "geo_distance", Value: tt.value}},
+				},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateQuery(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScriptFilter(t *testing.T) {
+	query := schema.LogQuery{
+		Expression: &schema.LogExpression{
+			Filters: []schema.LogFilter{{
+				Operator: "script",
+				Value:    `{"source": "doc['bytes_out'].value > doc['bytes_in'].value * params.ratio", "params": {"ratio": 2}}`,
+			}},
+		},
+	}
+
+	disabled := &ElasticProvider{}
+	if err := disabled.validateQuery(query); err == nil || !strings.Contains(err.Error(), "allowScriptFilters") {
+		t.Errorf("validateQuery without allowScriptFilters = %v, want gate error", err)
+	}
+	if _, err := disabled.buildFilterClause(query.Expression.Filters[0]); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("buildFilterClause without allowScriptFilters = %v, want unsupported", err)
+	}
+
+	var warnings bytes.Buffer
+	stderr = &warnings
+	defer func() { stderr = os.Stderr }()
+
+	enabled := &ElasticProvider{cfg: Config{AllowScriptFilters: true}}
+	if err := enabled.validateQuery(query); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	clause, err := enabled.buildFilterClause(query.Expression.Filters[0])
+	if err != nil {
+		t.Fatalf("expected script clause, got %v", err)
+	}
+	script := clause["script"].(map[string]any)["script"].(scriptFilter)
+	if !strings.HasPrefix(script.Source, "doc['bytes_out']") {
+		t.Errorf("source = %q", script.Source)
+	}
+	if script.Lang != "painless" {
+		t.Errorf("lang = %q, want painless", script.Lang)
+	}
+	if script.Params["ratio"] != float64(2) {
+		t.Errorf("params = %v, want ratio 2", script.Params)
+	}
+	if !strings.Contains(warnings.String(), "script filter") {
+		t.Errorf("expected a warning on stderr, got %q", warnings.String())
+	}
+}
+
+func TestValidateQueryScriptFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "not JSON", value: "doc['a'].value > 1"},
+		{name: "empty source", value: `{"source": ""}`},
+		{name: "too long", value: `{"source": "` + strings.Repeat("a", maxScriptLength+1) + `"}`},
+	}
+
+	p := &ElasticProvider{cfg: Config{AllowScriptFilters: true}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.validateQuery(schema.LogQuery{
+				Expression: &schema.LogExpression{
+					Filters: []schema.LogFilter{{Operator: "script", Value: tt.value}},
+				},
+			})
+			if err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+}
+
+func TestDedupeConsecutive(t *testing.T) {
+	base := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(offset int, message string) schema.LogEntry {
+		return schema.LogEntry{
+			Timestamp: base.Add(time.Duration(offset) * time.Second),
+			Message:   message,
+			Service:   "api",
+			Severity:  "error",
+			Metadata:  map[string]any{},
+		}
+	}
+
+	t.Run("runs collapse", func(t *testing.T) {
+		entries := dedupeConsecutive([]schema.LogEntry{
+			entry(5, "crash"),
+			entry(4, "crash"),
+			entry(3, "crash"),
+			entry(2, "starting"),
+			entry(1, "crash"),
+		})
+
+		if len(entries) != 3 {
+			t.Fatalf("entries = %d, want 3", len(entries))
+		}
+		if entries[0].Metadata[MetadataRepeatCount] != 3 {
+			t.Errorf("repeat_count = %v, want 3", entries[0].Metadata[MetadataRepeatCount])
+		}
+		if !entries[0].Timestamp.Equal(base.Add(5 * time.Second)) {
+			t.Errorf("timestamp = %s, want newest of the run", entries[0].Timestamp)
+		}
+		if entries[0].Metadata[MetadataRepeatFirstSeen] != base.Add(3*time.Second) {
+			t.Errorf("repeat_first_seen = %v, want oldest of the run", entries[0].Metadata[MetadataRepeatFirstSeen])
+		}
+		if entries[0].Metadata[MetadataRepeatLastSeen] != base.Add(5*time.Second) {
+			t.Errorf("repeat_last_seen = %v, want newest of the run", entries[0].Metadata[MetadataRepeatLastSeen])
+		}
+		if _, ok := entries[1].Metadata[MetadataRepeatCount]; ok {
+			t.Error("single entries should not carry repeat_count")
+		}
+		if entries[2].Message != "crash" {
+			t.Errorf("entries[2] = %q, want the later crash kept separately", entries[2].Message)
+		}
+	})
+
+	t.Run("interleaved duplicates stay", func(t *testing.T) {
+		entries := dedupeConsecutive([]schema.LogEntry{
+			entry(4, "crash"),
+			entry(3, "retry"),
+			entry(2, "crash"),
+			entry(1, "retry"),
+		})
+		if len(entries) != 4 {
+			t.Errorf("entries = %d, want 4", len(entries))
+		}
+	})
+
+	t.Run("different severity is not a duplicate", func(t *testing.T) {
+		warn := entry(1, "crash")
+		warn.Severity = "warn"
+		entries := dedupeConsecutive([]schema.LogEntry{entry(2, "crash"), warn})
+		if len(entries) != 2 {
+			t.Errorf("entries = %d, want 2", len(entries))
+		}
+	})
+}
+
+func TestNormalizeHit(t *testing.T) {
+	p := &ElasticProvider{}
+
+	score := 1.23
+	hit := esHit{
+		Index: "logs-2023.10.01",
+		ID:    "abc123",
+		Score: &score,
+		Source: map[string]interface{}{
+			"@timestamp":  "2023-10-01T12:00:00Z",
+			"message":     "Test log message",
+			"severity":    "error",
+			"service":     "api-gateway",
+			"environment": "production",
+			"status":      "500",
+		},
+	}
+
+	entry := normalizeHit(p, hit)
+
+	if entry.Message != "Test log message" {
+		t.Errorf("message = %s, want Test log message", entry.Message)
+	}
+	if entry.Severity != "error" {
+		t.Errorf("severity = %s, want error", entry.Severity)
+	}
+	if entry.Service != "api-gateway" {
+		t.Errorf("service = %s, want api-gateway", entry.Service)
+	}
+	if entry.Timestamp.IsZero() {
+		t.Error("timestamp should not be zero")
+	}
+	if entry.Metadata["_index"] != "logs-2023.10.01" {
+		t.Errorf("metadata[_index] = %v, want logs-2023.10.01", entry.Metadata["_index"])
+	}
+	if entry.Labels["environment"] != "production" {
+		t.Errorf("labels[environment] = %s, want production", entry.Labels["environment"])
+	}
+}
+
+func TestNormalizeHitFlattensNestedSource(t *testing.T) {
+	hit := esHit{
+		ID: "nested",
+		Source: map[string]interface{}{
+			"@timestamp": "2023-10-01T12:00:00Z",
+			"message":    "pod restarted",
+			"kubernetes": map[string]interface{}{
+				"pod": map[string]interface{}{
+					"name":     "api-7f9c",
+					"restarts": float64(3),
+				},
+				"namespace": "payments",
+			},
+		},
+	}
+
+	entry := normalizeHit(&ElasticProvider{}, hit)
+
+	if entry.Labels["kubernetes.pod.name"] != "api-7f9c" {
+		t.Errorf("labels[kubernetes.pod.name] = %q, want api-7f9c", entry.Labels["kubernetes.pod.name"])
+	}
+	if entry.Labels["kubernetes.namespace"] != "payments" {
+		t.Errorf("labels[kubernetes.namespace] = %q, want payments", entry.Labels["kubernetes.namespace"])
+	}
+	if entry.Fields["kubernetes.pod.restarts"] != float64(3) {
+		t.Errorf("fields[kubernetes.pod.restarts] = %v, want 3", entry.Fields["kubernetes.pod.restarts"])
+	}
+	if _, ok := entry.Fields["kubernetes"]; ok {
+		t.Error("nested object should not remain under its top-level key")
+	}
+
+	// The flattened key round-trips into a filter on the same field.
+	clause, _ := (&ElasticProvider{}).buildFilterClause(schema.LogFilter{Field: "kubernetes.pod.name", Operator: "=", Value: entry.Labels["kubernetes.pod.name"]})
+	term := clause["term"].(map[string]any)
+	if term["kubernetes.pod.name"] != "api-7f9c" {
+		t.Errorf("filter clause = %v, want term on kubernetes.pod.name", clause)
+	}
+}
+
+func TestNormalizeHitFlattenDepth(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{FlattenDepth: 2}}
+	hit := esHit{
+		Source: map[string]interface{}{
+			"a": map[string]interface{}{
+				"b": map[string]interface{}{
+					"c": "deep",
+				},
+			},
+		},
+	}
+
+	entry := normalizeHit(p, hit)
+
+	nested, ok := entry.Fields["a.b"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("fields[a.b] = %#v, want nested map kept at depth limit", entry.Fields["a.b"])
+	}
+	if nested["c"] != "deep" {
+		t.Errorf("fields[a.b][c] = %v, want deep", nested["c"])
+	}
+	if _, ok := entry.Labels["a.b.c"]; ok {
+		t.Error("labels should not contain keys beyond the depth limit")
+	}
+}
+
+func TestNormalizeHitLabelAndDropFields(t *testing.T) {
+	source := map[string]interface{}{
+		"message":     "request failed",
+		"environment": "production",
+		"host":        map[string]interface{}{"name": "web-01"},
+		"url":         map[string]interface{}{"path": "/checkout"},
+		"event":       map[string]interface{}{"original": "{\"raw\":\"line\"}"},
+	}
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantLabels map[string]string
+	}{
+		{
+			name:       "default allowlist",
+			wantLabels: map[string]string{"environment": "production", "host.name": "web-01", "host": "web-01"},
+		},
+		{
+			name:       "custom allowlist",
+			cfg:        Config{LabelFields: []string{"url.path"}},
+			wantLabels: map[string]string{"url.path": "/checkout", "host": "web-01"},
+		},
+		{
+			name:       "allowlist disabled keeps identity labels",
+			cfg:        Config{LabelFields: []string{}},
+			wantLabels: map[string]string{"host": "web-01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.DropFields = []string{"event.original"}
+			entry := normalizeHit(&ElasticProvider{cfg: tt.cfg}, esHit{Source: source})
+
+			if len(entry.Labels) != len(tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", entry.Labels, tt.wantLabels)
 			}
-			if result.Password != tt.expected.Password {
-				t.Errorf("password = %s, want %s", result.Password, tt.expected.Password)
+			for key, want := range tt.wantLabels {
+				if entry.Labels[key] != want {
+					t.Errorf("labels[%s] = %q, want %q", key, entry.Labels[key], want)
+				}
 			}
-			if result.APIKey != tt.expected.APIKey {
-				t.Errorf("apiKey = %s, want %s", result.APIKey, tt.expected.APIKey)
+			if entry.Fields["url.path"] != "/checkout" {
+				t.Errorf("fields[url.path] = %v, want /checkout", entry.Fields["url.path"])
 			}
-			if result.CloudID != tt.expected.CloudID {
-				t.Errorf("cloudID = %s, want %s", result.CloudID, tt.expected.CloudID)
+			if _, ok := entry.Fields["event.original"]; ok {
+				t.Error("dropped field should not be returned")
 			}
-			if result.IndexPattern != tt.expected.IndexPattern {
-				t.Errorf("indexPattern = %s, want %s", result.IndexPattern, tt.expected.IndexPattern)
+		})
+	}
+}
+
+// BenchmarkNormalizeHitSerializedSize compares the encoded size of a wide ECS
+// document when every string field is a label versus the curated default.
+func BenchmarkNormalizeHitSerializedSize(b *testing.B) {
+	source := map[string]interface{}{
+		"@timestamp": "2023-10-01T12:00:00Z",
+		"message":    "GET /api/orders 200",
+	}
+	attributes := map[string]interface{}{}
+	allFields := []string{}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("attribute_%03d", i)
+		attributes[key] = strings.Repeat("v", 32)
+		allFields = append(allFields, "labels."+key)
+	}
+	source["labels"] = attributes
+	hit := esHit{ID: "wide", Source: source}
+
+	configs := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "all-string-labels", cfg: Config{LabelFields: allFields}},
+		{name: "curated-labels", cfg: Config{}},
+	}
+
+	for _, c := range configs {
+		b.Run(c.name, func(b *testing.B) {
+			p := &ElasticProvider{cfg: c.cfg}
+			var size int
+			for i := 0; i < b.N; i++ {
+				body, err := json.Marshal(normalizeHit(p, hit))
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(body)
 			}
+			b.ReportMetric(float64(size), "bytes/entry")
 		})
 	}
 }
 
-func TestBuildFilterClause(t *testing.T) {
-	p := &ElasticProvider{}
+func TestNormalizeHitStringifyLabelValues(t *testing.T) {
+	body := `{"hits":{"hits":[{"_id":"1","_source":{
+		"status": 500,
+		"retryable": true,
+		"latency": 12.5,
+		"customer_id": 9007199254740993,
+		"tags": ["a", "b"]
+	}}]}}`
+	result, err := decodeSearchResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	hit := result.Hits.Hits[0]
+	labelFields := []string{"status", "retryable", "latency", "customer_id", "tags"}
+
+	entry := normalizeHit(&ElasticProvider{cfg: Config{LabelFields: labelFields, StringifyLabelValues: true}}, hit)
+
+	expected := map[string]string{
+		"status":      "500",
+		"retryable":   "true",
+		"latency":     "12.5",
+		"customer_id": "9007199254740993",
+	}
+	for key, want := range expected {
+		if entry.Labels[key] != want {
+			t.Errorf("labels[%s] = %q, want %q", key, entry.Labels[key], want)
+		}
+	}
+	if _, ok := entry.Labels["tags"]; ok {
+		t.Error("arrays should not become labels")
+	}
+	if entry.Fields["customer_id"] != json.Number("9007199254740993") {
+		t.Errorf("fields[customer_id] = %#v, want typed json.Number", entry.Fields["customer_id"])
+	}
+	if entry.Fields["retryable"] != true {
+		t.Errorf("fields[retryable] = %#v, want typed bool", entry.Fields["retryable"])
+	}
+
+	disabled := normalizeHit(&ElasticProvider{cfg: Config{LabelFields: labelFields}}, hit)
+	if len(disabled.Labels) != 0 {
+		t.Errorf("labels = %v, want none when stringifyLabelValues is off", disabled.Labels)
+	}
+}
 
+func TestScalarString(t *testing.T) {
 	tests := []struct {
-		name     string
-		filter   schema.LogFilter
-		expected map[string]any
+		value any
+		want  string
+	}{
+		{value: float64(500), want: "500"},
+		{value: float64(0.25), want: "0.25"},
+		{value: float64(1e21), want: "1000000000000000000000"},
+		{value: int64(9007199254740993), want: "9007199254740993"},
+		{value: json.Number("123456789012345678"), want: "123456789012345678"},
+		{value: false, want: "false"},
+	}
+
+	for _, tt := range tests {
+		got, ok := scalarString(tt.value)
+		if !ok || got != tt.want {
+			t.Errorf("scalarString(%v) = %q, %v; want %q", tt.value, got, ok, tt.want)
+		}
+	}
+}
+
+func TestNormalizeHitMessageShapes(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      map[string]interface{}
+		wantMessage string
+		wantField   string
+		wantValue   any
 	}{
 		{
-			name: "equals operator",
-			filter: schema.LogFilter{
-				Field:    "status",
-				Operator: "=",
-				Value:    "200",
-			},
-			expected: map[string]any{
-				"term": map[string]any{
-					"status": "200",
-				},
+			name: "object message",
+			source: map[string]interface{}{
+				"message": map[string]interface{}{"event": "login", "user": "alice"},
 			},
+			wantMessage: `{"event":"login","user":"alice"}`,
+			wantField:   "message.user",
+			wantValue:   "alice",
 		},
 		{
-			name: "not equals operator",
-			filter: schema.LogFilter{
-				Field:    "status",
-				Operator: "!=",
-				Value:    "200",
-			},
-			expected: map[string]any{
-				"bool": map[string]any{
-					"must_not": map[string]any{
-						"term": map[string]any{
-							"status": "200",
-						},
-					},
-				},
+			name: "array message",
+			source: map[string]interface{}{
+				"message": []interface{}{"panic: nil map", "goroutine 1 [running]:"},
 			},
+			wantMessage: "panic: nil map\ngoroutine 1 [running]:",
 		},
 		{
-			name: "contains operator",
-			filter: schema.LogFilter{
-				Field:    "message",
-				Operator: "contains",
-				Value:    "error",
+			name: "fallback field",
+			source: map[string]interface{}{
+				"msg": "from msg",
+				"log": "from log",
 			},
-			expected: map[string]any{
-				"wildcard": map[string]any{
-					"message": map[string]any{
-						"value": "*error*",
-					},
-				},
+			wantMessage: "from msg",
+			wantField:   "msg",
+			wantValue:   "from msg",
+		},
+		{
+			name: "nested fallback field",
+			source: map[string]interface{}{
+				"event": map[string]interface{}{"original": "raw line"},
 			},
+			wantMessage: "raw line",
 		},
 		{
-			name: "regex operator",
-			filter: schema.LogFilter{
-				Field:    "url",
-				Operator: "regex",
-				Value:    "/api/.*",
+			name:        "absent message",
+			source:      map[string]interface{}{"status": "200"},
+			wantMessage: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := normalizeHit(&ElasticProvider{}, esHit{Source: tt.source})
+			if entry.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", entry.Message, tt.wantMessage)
+			}
+			if tt.wantField != "" && entry.Fields[tt.wantField] != tt.wantValue {
+				t.Errorf("fields[%s] = %v, want %v", tt.wantField, entry.Fields[tt.wantField], tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestNormalizeHitMessageFieldsConfig(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{MessageFields: []string{"log", "message"}}}
+	entry := normalizeHit(p, esHit{Source: map[string]interface{}{"message": "second", "log": "first"}})
+	if entry.Message != "first" {
+		t.Errorf("message = %q, want first", entry.Message)
+	}
+}
+
+func TestNormalizeHitMessageComposition(t *testing.T) {
+	composition := []MessagePart{
+		{Field: "message"},
+		{Field: "error.stack_trace", Separator: "\n"},
+		{Field: "error.message", Separator: " | "},
+	}
+	stack := "java.lang.NullPointerException\n\tat com.example.Api.handle(Api.java:42)"
+
+	tests := []struct {
+		name   string
+		source map[string]interface{}
+		want   string
+	}{
+		{
+			name: "message and stack trace",
+			source: map[string]interface{}{
+				"message": "request failed",
+				"error":   map[string]interface{}{"stack_trace": stack},
 			},
-			expected: map[string]any{
-				"regexp": map[string]any{
-					"url": map[string]any{
-						"value": "/api/.*",
-					},
-				},
+			want: "request failed\n" + stack,
+		},
+		{
+			name:   "message only",
+			source: map[string]interface{}{"message": "request failed"},
+			want:   "request failed",
+		},
+		{
+			name:   "stack trace only has no leading separator",
+			source: map[string]interface{}{"error": map[string]interface{}{"stack_trace": stack}},
+			want:   stack,
+		},
+		{
+			name: "parts follow configured order",
+			source: map[string]interface{}{
+				"message": "request failed",
+				"error":   map[string]interface{}{"message": "NPE", "stack_trace": stack},
 			},
+			want: "request failed\n" + stack + " | NPE",
+		},
+		{
+			name:   "no parts falls back to message fields",
+			source: map[string]interface{}{"msg": "fallback"},
+			want:   "fallback",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := p.buildFilterClause(tt.filter)
-			// Basic structural validation
-			if result == nil {
-				t.Fatalf("expected non-nil result")
+			entry := normalizeHit(&ElasticProvider{cfg: Config{MessageComposition: composition}}, esHit{Source: tt.source})
+			if entry.Message != tt.want {
+				t.Errorf("message = %q, want %q", entry.Message, tt.want)
+			}
+			if raw, ok := tt.source["error"].(map[string]interface{}); ok && entry.Fields["error.stack_trace"] != raw["stack_trace"] {
+				t.Errorf("fields[error.stack_trace] = %v, want raw part kept", entry.Fields["error.stack_trace"])
 			}
-			// Note: Deep comparison would require more sophisticated testing
-			// This validates the basic structure is created
 		})
 	}
 }
 
-func TestNormalizeHit(t *testing.T) {
-	p := &ElasticProvider{}
+func TestParseConfigMessageComposition(t *testing.T) {
+	cfg := parseConfig(map[string]any{
+		"messageComposition": []any{
+			"message",
+			map[string]any{"field": "error.stack_trace", "separator": "\n---\n"},
+			map[string]any{"separator": "ignored"},
+		},
+	})
 
-	hit := esHit{
-		Index: "logs-2023.10.01",
-		ID:    "abc123",
-		Score: 1.23,
-		Source: map[string]interface{}{
-			"@timestamp":  "2023-10-01T12:00:00Z",
-			"message":     "Test log message",
-			"severity":    "error",
-			"service":     "api-gateway",
-			"environment": "production",
-			"status":      "500",
+	want := []MessagePart{
+		{Field: "message", Separator: "\n"},
+		{Field: "error.stack_trace", Separator: "\n---\n"},
+	}
+	if len(cfg.MessageComposition) != len(want) {
+		t.Fatalf("messageComposition = %#v, want %#v", cfg.MessageComposition, want)
+	}
+	for i, part := range want {
+		if cfg.MessageComposition[i] != part {
+			t.Errorf("messageComposition[%d] = %#v, want %#v", i, cfg.MessageComposition[i], part)
+		}
+	}
+}
+
+func TestNormalizeHitTraceIDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		source    map[string]interface{}
+		wantTrace string
+		wantSpan  string
+	}{
+		{
+			name:      "flat names",
+			source:    map[string]interface{}{"trace_id": "4bf92f3577b34da6", "span_id": "00f067aa0ba902b7"},
+			wantTrace: "4bf92f3577b34da6",
+			wantSpan:  "00f067aa0ba902b7",
+		},
+		{
+			name: "nested ECS names",
+			source: map[string]interface{}{
+				"trace": map[string]interface{}{"id": "4bf92f3577b34da6"},
+				"span":  map[string]interface{}{"id": "00f067aa0ba902b7"},
+			},
+			wantTrace: "4bf92f3577b34da6",
+			wantSpan:  "00f067aa0ba902b7",
+		},
+		{
+			name:      "configured candidates",
+			cfg:       Config{TraceIDFields: []string{"otel.trace"}},
+			source:    map[string]interface{}{"otel": map[string]interface{}{"trace": "abc"}, "trace_id": "ignored"},
+			wantTrace: "abc",
+		},
+		{
+			name:   "absent",
+			source: map[string]interface{}{"message": "no tracing"},
 		},
 	}
 
-	entry := normalizeHit(p, hit)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := normalizeHit(&ElasticProvider{cfg: tt.cfg}, esHit{Source: tt.source})
 
-	if entry.Message != "Test log message" {
-		t.Errorf("message = %s, want Test log message", entry.Message)
+			trace, hasTrace := entry.Metadata[MetadataTraceID]
+			if tt.wantTrace == "" && hasTrace {
+				t.Errorf("metadata[trace_id] = %v, want absent", trace)
+			} else if tt.wantTrace != "" && trace != tt.wantTrace {
+				t.Errorf("metadata[trace_id] = %v, want %s", trace, tt.wantTrace)
+			}
+
+			span, hasSpan := entry.Metadata[MetadataSpanID]
+			if tt.wantSpan == "" && hasSpan {
+				t.Errorf("metadata[span_id] = %v, want absent", span)
+			} else if tt.wantSpan != "" && span != tt.wantSpan {
+				t.Errorf("metadata[span_id] = %v, want %s", span, tt.wantSpan)
+			}
+		})
 	}
-	if entry.Severity != "error" {
-		t.Errorf("severity = %s, want error", entry.Severity)
+}
+
+func TestNormalizeHitTruncatesOversizedFields(t *testing.T) {
+	payload := strings.Repeat("é", 40) // 80 bytes of two-byte runes
+	p := &ElasticProvider{cfg: Config{MaxFieldBytes: 25}}
+
+	entry := normalizeHit(p, esHit{Source: map[string]interface{}{
+		"message": "upload",
+		"payload": map[string]interface{}{"data": payload},
+		"small":   "ok",
+	}})
+
+	truncated := entry.Fields["payload.data"].(string)
+	if !strings.HasSuffix(truncated, "...(truncated, 80 bytes)") {
+		t.Errorf("payload.data = %q, want truncation suffix", truncated)
 	}
-	if entry.Service != "api-gateway" {
-		t.Errorf("service = %s, want api-gateway", entry.Service)
+	if !utf8.ValidString(truncated) {
+		t.Errorf("truncated value is not valid UTF-8: %q", truncated)
+	}
+	if prefix := strings.TrimSuffix(truncated, "...(truncated, 80 bytes)"); len(prefix) > 25 {
+		t.Errorf("kept %d bytes, want at most 25", len(prefix))
+	}
+	if entry.Fields["small"] != "ok" {
+		t.Errorf("fields[small] = %v, want untouched", entry.Fields["small"])
+	}
+	names, _ := entry.Metadata[MetadataTruncatedFields].([]string)
+	if len(names) != 1 || names[0] != "payload.data" {
+		t.Errorf("metadata[truncated_fields] = %v, want [payload.data]", entry.Metadata[MetadataTruncatedFields])
+	}
+}
+
+func TestNormalizeHitDefaultFieldLimit(t *testing.T) {
+	blob := strings.Repeat("A", 5*1024*1024)
+	entry := normalizeHit(&ElasticProvider{}, esHit{Source: map[string]interface{}{"attachment": blob}})
+	if size := len(entry.Fields["attachment"].(string)); size > defaultMaxFieldBytes+64 {
+		t.Errorf("attachment is %d bytes, want it capped near %d", size, defaultMaxFieldBytes)
+	}
+}
+
+func TestNormalizeHitMaxEntryBytes(t *testing.T) {
+	source := map[string]interface{}{"message": "wide"}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		source[key] = strings.Repeat(key, 100)
+	}
+	p := &ElasticProvider{cfg: Config{MaxEntryBytes: 400}}
+
+	entry := normalizeHit(p, esHit{ID: "1", Source: source})
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("failed to encode entry: %v", err)
+	}
+	if len(encoded) > 400+200 { // dropped_fields metadata is added after budgeting
+		t.Errorf("encoded entry is %d bytes, want close to 400", len(encoded))
+	}
+	dropped, _ := entry.Metadata[MetadataDroppedFields].([]string)
+	if len(dropped) == 0 {
+		t.Fatal("expected some fields to be dropped")
+	}
+	for _, key := range dropped {
+		if _, ok := entry.Fields[key]; ok {
+			t.Errorf("field %s is listed as dropped but still present", key)
+		}
+	}
+	if _, ok := entry.Fields["a"]; !ok {
+		t.Error("fields should be kept in key order until the budget is spent")
+	}
+
+	roomy := normalizeHit(&ElasticProvider{cfg: Config{MaxEntryBytes: 1 << 20}}, esHit{ID: "1", Source: source})
+	if len(roomy.Fields) != 4 {
+		t.Errorf("fields = %d, want all 4 under a large budget", len(roomy.Fields))
+	}
+	if _, ok := roomy.Metadata[MetadataDroppedFields]; ok {
+		t.Error("dropped_fields should be absent when nothing was dropped")
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	layouts := []string{"2006-01-02 15:04:05"}
+	expected := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value any
+		want  time.Time
+		ok    bool
+	}{
+		{name: "RFC3339Nano", value: "2023-10-01T12:00:00.123456789Z", want: expected.Add(123456789 * time.Nanosecond), ok: true},
+		{name: "RFC3339 with offset", value: "2023-10-01T14:00:00+02:00", want: expected, ok: true},
+		{name: "epoch millis number", value: float64(1696161600000), want: expected, ok: true},
+		{name: "epoch millis string", value: "1696161600000", want: expected, ok: true},
+		{name: "epoch seconds number", value: float64(1696161600), want: expected, ok: true},
+		{name: "epoch seconds json.Number", value: json.Number("1696161600"), want: expected, ok: true},
+		{name: "epoch millis json.Number exponent", value: json.Number("1.6961616e12"), want: expected, ok: true},
+		{name: "custom layout", value: "2023-10-01 12:00:00", want: expected, ok: true},
+		{name: "garbage", value: "yesterday-ish", ok: false},
+		{name: "NaN string", value: "NaN", ok: false},
+		{name: "Inf string", value: "Inf", ok: false},
+		{name: "infinity string", value: "-infinity", ok: false},
+		{name: "hex string", value: "0x1p30", ok: false},
+		{name: "NaN json.Number", value: json.Number("NaN"), ok: false},
+		{name: "NaN number", value: math.NaN(), ok: false},
+		{name: "Inf number", value: math.Inf(1), ok: false},
+		{name: "unsupported type", value: true, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTimestamp(tt.value, layouts)
+			if ok != tt.ok {
+				t.Fatalf("parseTimestamp(%v) ok = %v, want %v", tt.value, ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("parseTimestamp(%v) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHitRawTimestamp(t *testing.T) {
+	entry := normalizeHit(&ElasticProvider{}, esHit{Source: map[string]interface{}{"@timestamp": "yesterday-ish"}})
+	if !entry.Timestamp.IsZero() {
+		t.Errorf("timestamp = %s, want zero", entry.Timestamp)
+	}
+	if entry.Metadata[MetadataRawTimestamp] != "yesterday-ish" {
+		t.Errorf("metadata[raw_timestamp] = %v, want yesterday-ish", entry.Metadata[MetadataRawTimestamp])
 	}
+
+	entry = normalizeHit(&ElasticProvider{}, esHit{Source: map[string]interface{}{"@timestamp": float64(1696161600000)}})
 	if entry.Timestamp.IsZero() {
-		t.Error("timestamp should not be zero")
+		t.Error("epoch millis timestamp should be parsed")
 	}
-	if entry.Metadata["_index"] != "logs-2023.10.01" {
-		t.Errorf("metadata[_index] = %v, want logs-2023.10.01", entry.Metadata["_index"])
+	if _, ok := entry.Metadata[MetadataRawTimestamp]; ok {
+		t.Error("raw_timestamp should only be set on parse failure")
 	}
-	if entry.Labels["environment"] != "production" {
-		t.Errorf("labels[environment] = %s, want production", entry.Labels["environment"])
+}
+
+func TestNormalizeHitMetadataLevels(t *testing.T) {
+	body := `{"hits":{"hits":[
+		{"_index":"logs-1","_id":"a","_score":null,"_routing":"tenant-7","_version":3,"_source":{}},
+		{"_index":"logs-1","_id":"b","_score":2.5,"_source":{}}
+	]}}`
+	result, err := decodeSearchResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	sorted, scored := result.Hits.Hits[0], result.Hits.Hits[1]
+
+	tests := []struct {
+		name  string
+		level string
+		hit   esHit
+		want  map[string]any
+	}{
+		{name: "none", level: "none", hit: sorted, want: map[string]any{}},
+		{name: "minimal", level: "minimal", hit: sorted, want: map[string]any{"_id": "a"}},
+		{
+			name:  "full omits null score",
+			level: "full",
+			hit:   sorted,
+			want:  map[string]any{"_index": "logs-1", "_id": "a", "_routing": "tenant-7", "_version": int64(3)},
+		},
+		{
+			name: "default keeps computed score",
+			hit:  scored,
+			want: map[string]any{"_index": "logs-1", "_id": "b", "_score": 2.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := normalizeHit(&ElasticProvider{cfg: Config{EntryMetadataLevel: tt.level}}, tt.hit)
+			if len(entry.Metadata) != len(tt.want) {
+				t.Errorf("metadata = %v, want %v", entry.Metadata, tt.want)
+			}
+			for key, want := range tt.want {
+				if entry.Metadata[key] != want {
+					t.Errorf("metadata[%s] = %#v, want %#v", key, entry.Metadata[key], want)
+				}
+			}
+		})
 	}
 }
 
@@ -331,6 +1759,76 @@ func TestBuildKibanaURL(t *testing.T) {
 	}
 }
 
+// fakeTransport answers Elasticsearch requests from a handler and records
+// every request it receives.
+type fakeTransport struct {
+	mu       sync.Mutex
+	requests []recordedRequest
+	handler  func(req recordedRequest) (int, string)
+}
+
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+func (f *fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	recorded := recordedRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query(),
+		Header: req.Header.Clone(),
+		Body:   string(body),
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, recorded)
+	f.mu.Unlock()
+
+	status, respBody := f.handler(recorded)
+	return &http.Response{
+		StatusCode: status,
+		Header: http.Header{
+			"Content-Type":      []string{"application/json"},
+			"X-Elastic-Product": []string{"Elasticsearch"},
+		},
+		Body:    io.NopCloser(strings.NewReader(respBody)),
+		Request: req,
+	}, nil
+}
+
+// recorded returns a copy of the requests received so far.
+func (f *fakeTransport) recorded() []recordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]recordedRequest{}, f.requests...)
+}
+
+// newTestProvider builds a provider whose client talks to a fakeTransport.
+func newTestProvider(t testing.TB, cfg Config, handler func(req recordedRequest) (int, string)) (*ElasticProvider, *fakeTransport) {
+	t.Helper()
+
+	if cfg.IndexPattern == "" {
+		cfg.IndexPattern = "logs-*"
+	}
+	transport := &fakeTransport{handler: handler}
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{"http://elastic.test:9200"},
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return &ElasticProvider{cfg: cfg, client: client, redact: newFieldMatcher(cfg.RedactFields)}, transport
+}
+
 func contains(s, substr string) bool {
 	// Simple substring check
 	if len(substr) == 0 {
@@ -0,0 +1,137 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// EntryRef identifies a log entry to read the surrounding context of.
+type EntryRef struct {
+	Index string `json:"index"`
+	ID    string `json:"id"`
+	// Timestamp narrows the lookup of the entry; optional.
+	Timestamp time.Time `json:"timestamp"`
+	// GroupField limits the context to entries from the same source, such
+	// as "host.name" or "kubernetes.pod.name". GroupValue defaults to the
+	// entry's own value of GroupField.
+	GroupField string `json:"groupField,omitempty"`
+	GroupValue any    `json:"groupValue,omitempty"`
+}
+
+// ErrEntryNotFound is returned when a context anchor entry does not exist.
+var ErrEntryNotFound = errors.New("log entry not found")
+
+// maxContextEntries caps the entries read on each side of an anchor.
+const maxContextEntries = 500
+
+// Context returns up to before entries preceding the referenced entry and
+// up to after entries following it, oldest first, with the entry itself in
+// between. Neighbours are read with search_after from the entry's sort
+// values, so entries sharing its timestamp keep their order.
+func (p *ElasticProvider) Context(ctx context.Context, ref EntryRef, before, after int) ([]schema.LogEntry, error) {
+	ctx = withAudit(ctx, "log.context", schema.QueryScope{})
+	if ref.Index == "" || ref.ID == "" {
+		return nil, errors.New("entry index and id are required")
+	}
+	if before < 0 || after < 0 || before > maxContextEntries || after > maxContextEntries {
+		return nil, fmt.Errorf("before and after must be between 0 and %d", maxContextEntries)
+	}
+
+	anchor, err := p.contextAnchor(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := schema.LogQuery{Metadata: map[string]any{}}
+	if ref.GroupField != "" {
+		value := ref.GroupValue
+		if value == nil {
+			value = lookupPath(anchor.Hits.Hits[0].Source, ref.GroupField)
+		}
+		if value == nil {
+			return nil, fmt.Errorf("entry has no %s to group by", ref.GroupField)
+		}
+		scope.Metadata[ref.GroupField] = value
+	}
+	sortValues := anchor.Hits.Hits[0].Sort
+
+	older, err := p.contextSide(ctx, scope, sortValues, orderDesc, before)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := p.contextSide(ctx, scope, sortValues, orderAsc, after)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]schema.LogEntry, 0, len(older)+1+len(newer))
+	for i := len(older) - 1; i >= 0; i-- {
+		entries = append(entries, older[i])
+	}
+	entries = p.appendResult(ctx, entries, anchor)
+	return append(entries, newer...), nil
+}
+
+// contextAnchor looks up the referenced entry with the sort used for its
+// neighbours, so that its sort values can anchor search_after.
+func (p *ElasticProvider) contextAnchor(ctx context.Context, ref EntryRef) (esSearchResponse, error) {
+	esQuery := map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": []map[string]any{
+					{"ids": map[string]any{"values": []string{ref.ID}}},
+				},
+			},
+		},
+		"sort": p.sortClause(schema.LogQuery{}),
+		"size": 1,
+	}
+	if !ref.Timestamp.IsZero() {
+		// Lets Elasticsearch skip shards outside the entry's time range
+		lookup := schema.LogQuery{Start: ref.Timestamp.Add(-time.Second), End: ref.Timestamp.Add(time.Second)}
+		esQuery["query"].(map[string]any)["bool"].(map[string]any)["must"] = p.boolQuery(lookup)
+	}
+
+	result, err := p.search(ctx, ref.Index, esQuery)
+	if err != nil {
+		return esSearchResponse{}, err
+	}
+	if len(result.Hits.Hits) == 0 {
+		return esSearchResponse{}, fmt.Errorf("%w: %s/%s", ErrEntryNotFound, ref.Index, ref.ID)
+	}
+	if len(result.Hits.Hits[0].Sort) == 0 {
+		return esSearchResponse{}, fmt.Errorf("entry %s/%s has no sort values", ref.Index, ref.ID)
+	}
+	result.Hits.Hits = result.Hits.Hits[:1]
+	return result, nil
+}
+
+// contextSide reads up to size entries after the anchor's sort values in
+// order: descending for older entries, ascending for newer ones. The anchor
+// itself is excluded by search_after.
+func (p *ElasticProvider) contextSide(ctx context.Context, scope schema.LogQuery, sortValues []any, order string, size int) ([]schema.LogEntry, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	side := scope
+	side.Limit = size
+	side.Metadata = make(map[string]any, len(scope.Metadata)+1)
+	for key, value := range scope.Metadata {
+		side.Metadata[key] = value
+	}
+	side.Metadata[QueryOptionOrder] = order
+
+	esQuery := p.buildQuery(side)
+	esQuery["search_after"] = sortValues
+	delete(esQuery, "track_total_hits")
+
+	result, err := p.search(ctx, p.cfg.IndexPattern, esQuery)
+	if err != nil {
+		return nil, err
+	}
+	return p.appendResult(ctx, make([]schema.LogEntry, 0, len(result.Hits.Hits)), result), nil
+}
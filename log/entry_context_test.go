@@ -0,0 +1,175 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// contextServer plays Elasticsearch over docs on one pod, sorted by
+// (timestamp, seq) where docs 4 and 5 share a timestamp. It answers id
+// lookups and search_after in either order.
+func contextServer(t *testing.T) func(req recordedRequest) (int, string) {
+	type doc struct {
+		seq    int64
+		millis int64
+		pod    string
+	}
+	var docs []doc
+	for seq := int64(1); seq <= 9; seq++ {
+		millis := 1696161600000 + seq*1000
+		if seq == 5 {
+			millis -= 1000
+		}
+		pod := "api-1"
+		if seq == 3 {
+			pod = "api-2"
+		}
+		docs = append(docs, doc{seq: seq, millis: millis, pod: pod})
+	}
+	hit := func(d doc) string {
+		return fmt.Sprintf(`{"_index":"logs-app","_id":"doc-%d","_source":{"message":"doc-%d","kubernetes":{"pod":{"name":%q}}},"sort":[%d,%d]}`,
+			d.seq, d.seq, d.pod, d.millis, d.seq)
+	}
+
+	return func(req recordedRequest) (int, string) {
+		if req.Path == "/logs-app/_search" {
+			for _, d := range docs {
+				if strings.Contains(req.Body, fmt.Sprintf(`"doc-%d"`, d.seq)) {
+					return 200, `{"hits":{"hits":[` + hit(d) + `]}}`
+				}
+			}
+			return 200, `{"hits":{"hits":[]}}`
+		}
+
+		var body struct {
+			Size        int     `json:"size"`
+			SearchAfter []int64 `json:"search_after"`
+			Sort        []map[string]struct {
+				Order string `json:"order"`
+			} `json:"sort"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		desc := body.Sort[0]["@timestamp"].Order == orderDesc
+		pod := "api-1"
+		if !strings.Contains(req.Body, `"kubernetes.pod.name":"api-1"`) {
+			pod = ""
+		}
+
+		hits := []string{}
+		for i := range docs {
+			d := docs[i]
+			if desc {
+				d = docs[len(docs)-1-i]
+			}
+			if pod != "" && d.pod != pod {
+				continue
+			}
+			a, b := body.SearchAfter[0], body.SearchAfter[1]
+			later := d.millis > a || (d.millis == a && d.seq > b)
+			earlier := d.millis < a || (d.millis == a && d.seq < b)
+			if (desc && !earlier) || (!desc && !later) || len(hits) == body.Size {
+				continue
+			}
+			hits = append(hits, hit(d))
+		}
+		return 200, `{"hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+	}
+}
+
+func messages(entries []schema.LogEntry) string {
+	out := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, entry.Message)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestContext(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, contextServer(t))
+
+	entries, err := p.Context(context.Background(), EntryRef{Index: "logs-app", ID: "doc-5", GroupField: "kubernetes.pod.name"}, 3, 2)
+	if err != nil {
+		t.Fatalf("context failed: %v", err)
+	}
+	// doc-3 is on another pod; doc-4 shares the anchor's timestamp but sorts
+	// before it
+	if got := messages(entries); got != "doc-1,doc-2,doc-4,doc-5,doc-6,doc-7" {
+		t.Errorf("entries = %s, want three older, the anchor once, then two newer", got)
+	}
+
+	requests := transport.recorded()
+	if len(requests) != 3 || requests[0].Path != "/logs-app/_search" || requests[1].Path != "/logs-*/_search" {
+		t.Fatalf("requests = %s, want the anchor lookup then two context searches", requestLine(requests))
+	}
+	for _, req := range requests[1:] {
+		if !strings.Contains(req.Body, `"search_after":[1696161604000,5]`) {
+			t.Errorf("body = %s, want search_after from the anchor", req.Body)
+		}
+	}
+}
+
+func TestContextWithoutGrouping(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, contextServer(t))
+
+	entries, err := p.Context(context.Background(), EntryRef{Index: "logs-app", ID: "doc-5"}, 2, 0)
+	if err != nil {
+		t.Fatalf("context failed: %v", err)
+	}
+	if got := messages(entries); got != "doc-3,doc-4,doc-5" {
+		t.Errorf("entries = %s, want two older entries from any pod and the anchor", got)
+	}
+}
+
+func TestContextAtEdges(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, contextServer(t))
+
+	entries, err := p.Context(context.Background(), EntryRef{Index: "logs-app", ID: "doc-9", GroupField: "kubernetes.pod.name", GroupValue: "api-1"}, 0, 5)
+	if err != nil {
+		t.Fatalf("context failed: %v", err)
+	}
+	if got := messages(entries); got != "doc-9" {
+		t.Errorf("entries = %s, want only the anchor", got)
+	}
+	if len(transport.recorded()) != 2 {
+		t.Errorf("requests = %d, want no search for zero older entries", len(transport.recorded()))
+	}
+}
+
+func TestContextErrors(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, contextServer(t))
+
+	if _, err := p.Context(context.Background(), EntryRef{Index: "logs-app", ID: "missing"}, 1, 1); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("err = %v, want ErrEntryNotFound", err)
+	}
+	if _, err := p.Context(context.Background(), EntryRef{ID: "doc-1"}, 1, 1); err == nil {
+		t.Error("context without an index succeeded")
+	}
+	if _, err := p.Context(context.Background(), EntryRef{Index: "logs-app", ID: "doc-1"}, 1, maxContextEntries+1); err == nil {
+		t.Error("context over the cap succeeded")
+	}
+	if _, err := p.Context(context.Background(), EntryRef{Index: "logs-app", ID: "doc-1", GroupField: "host.name"}, 1, 1); err == nil || !strings.Contains(err.Error(), "host.name") {
+		t.Errorf("err = %v, want a missing group field error", err)
+	}
+}
+
+func TestContextAnchorTimeRange(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, contextServer(t))
+	ts := time.Date(2023, 10, 1, 12, 0, 4, 500_000_000, time.UTC)
+
+	if _, err := p.Context(context.Background(), EntryRef{Index: "logs-app", ID: "doc-5", Timestamp: ts}, 0, 0); err != nil {
+		t.Fatalf("context failed: %v", err)
+	}
+	body := transport.recorded()[0].Body
+	if !strings.Contains(body, `"gte":"2023-10-01T12:00:03.5Z"`) || !strings.Contains(body, `"lte":"2023-10-01T12:00:05.5Z"`) {
+		t.Errorf("anchor body = %s, want a range around the timestamp", body)
+	}
+}
@@ -0,0 +1,267 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Error categories. A failed call matches at most one of them with
+// errors.Is, so callers can decide whether to retry it; failures matching
+// none are unexpected.
+var (
+	// ErrInvalidQuery means the request was rejected as malformed. Sending
+	// it again unchanged fails again.
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrConnection means the cluster could not be reached or is
+	// overloaded. The request may succeed later.
+	ErrConnection = errors.New("elasticsearch unavailable")
+	// ErrAuth means the credentials were refused or lack a privilege.
+	ErrAuth = errors.New("elasticsearch rejected the credentials")
+	// ErrTimeout means the request did not complete in time.
+	ErrTimeout = errors.New("elasticsearch request timed out")
+	// ErrCancelled means the caller cancelled the request.
+	ErrCancelled = errors.New("request cancelled")
+	// ErrTooLarge means the request or its result exceeds a cluster limit.
+	ErrTooLarge = errors.New("request or result too large")
+	// ErrUnsupported means the cluster, or the adapter as configured, does
+	// not offer what was asked.
+	ErrUnsupported = errors.New("not supported")
+)
+
+var errorCategories = []error{ErrInvalidQuery, ErrConnection, ErrAuth, ErrTimeout, ErrCancelled, ErrTooLarge, ErrUnsupported, ErrRateLimited}
+
+// tooLargeTypes are Elasticsearch exception types reporting an exceeded
+// limit, whatever the status code.
+var tooLargeTypes = map[string]bool{
+	"too_many_buckets_exception": true,
+	"too_many_nested_clauses":    true,
+	"too_many_clauses":           true,
+	"circuit_breaking_exception": true,
+	"content_too_long_exception": true,
+}
+
+// maxErrorReasonBytes bounds the reason quoted in a ResponseError's
+// message.
+const maxErrorReasonBytes = 512
+
+// ResponseError is an error response from Elasticsearch. It matches the
+// error category of its status code and exception type. Its message holds
+// the status and the root cause only, never the response body, which may
+// quote the search sent or, from a proxy, credentials; error bodies are
+// logged at debug level instead.
+type ResponseError struct {
+	StatusCode int
+	// Type and Reason are the root cause Elasticsearch reported, when the
+	// response has one.
+	Type   string
+	Reason string
+
+	// types lists every exception type the response names, causes and
+	// shard failures included.
+	types []string
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("elasticsearch returned error: [%d %s]", e.StatusCode, http.StatusText(e.StatusCode))
+	switch {
+	case e.Type != "" && e.Reason != "":
+		msg += " " + e.Type + ": " + truncateUTF8(e.Reason, maxErrorReasonBytes)
+	case e.Type != "":
+		msg += " " + e.Type
+	case e.Reason != "":
+		msg += " " + truncateUTF8(e.Reason, maxErrorReasonBytes)
+	}
+	return msg
+}
+
+func (e *ResponseError) Is(target error) bool {
+	category := e.category()
+	return category != nil && target == category
+}
+
+func (e *ResponseError) category() error {
+	if tooLargeTypes[e.Type] || strings.Contains(e.Reason, "max_result_window") {
+		return ErrTooLarge
+	}
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return ErrInvalidQuery
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAuth
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	case http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return ErrConnection
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// hasErrorType reports whether err is a ResponseError naming the exception
+// type anywhere in its response.
+func hasErrorType(err error, exception string) bool {
+	var res *ResponseError
+	if !errors.As(err, &res) {
+		return false
+	}
+	for _, t := range res.types {
+		if t == exception {
+			return true
+		}
+	}
+	return false
+}
+
+// responseError reads an error response into a ResponseError.
+func responseError(res *esapi.Response) error {
+	body, _ := io.ReadAll(res.Body)
+	var doc struct {
+		Error json.RawMessage `json:"error"`
+	}
+	_ = json.Unmarshal(body, &doc)
+	return newResponseError(res.StatusCode, doc.Error)
+}
+
+// newResponseError builds a ResponseError from the error object of a
+// response, which may be a string or missing.
+func newResponseError(status int, cause json.RawMessage) *ResponseError {
+	e := &ResponseError{StatusCode: status}
+	type reason struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	var parsed struct {
+		reason
+		RootCause []reason `json:"root_cause"`
+	}
+	if json.Unmarshal(cause, &parsed) == nil {
+		e.Type, e.Reason = parsed.Type, parsed.Reason
+		if len(parsed.RootCause) > 0 {
+			e.Type, e.Reason = parsed.RootCause[0].Type, parsed.RootCause[0].Reason
+		}
+		var envelope any
+		_ = json.Unmarshal(cause, &envelope)
+		e.types = exceptionTypes(e.types, envelope)
+	} else {
+		_ = json.Unmarshal(cause, &e.Reason)
+	}
+	return e
+}
+
+// exceptionTypes appends the "type" of every object in an error envelope.
+func exceptionTypes(types []string, v any) []string {
+	switch v := v.(type) {
+	case map[string]any:
+		if t, ok := v["type"].(string); ok {
+			types = append(types, t)
+		}
+		for _, value := range v {
+			types = exceptionTypes(types, value)
+		}
+	case []any:
+		for _, value := range v {
+			types = exceptionTypes(types, value)
+		}
+	}
+	return types
+}
+
+// errorBodyTransport logs the bodies of error responses at debug level,
+// as the errors they turn into carry their status and root cause only.
+type errorBodyTransport struct {
+	next   http.RoundTripper
+	logger *atomic.Pointer[slog.Logger]
+}
+
+func (t *errorBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode < 400 {
+		return res, err
+	}
+	l := t.logger.Load()
+	if l == nil || !l.Enabled(req.Context(), slog.LevelDebug) {
+		return res, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, transportError(fmt.Errorf("failed to read error response: %w", err))
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	l.Debug("elasticsearch error response", "method", req.Method, "path", req.URL.Path, "status", res.StatusCode, "body", string(body), "traceId", TraceID(req.Context()))
+	return res, nil
+}
+
+// categoryError is an error placed in a category it does not wrap.
+type categoryError struct {
+	err      error
+	category error
+}
+
+func (e *categoryError) Error() string        { return e.err.Error() }
+func (e *categoryError) Unwrap() error        { return e.err }
+func (e *categoryError) Is(target error) bool { return target == e.category }
+
+// unsupported returns an error with msg in ErrUnsupported.
+func unsupported(msg string) error {
+	return &categoryError{err: errors.New(msg), category: ErrUnsupported}
+}
+
+// categorize places err in category unless it is already in one.
+func categorize(err, category error) error {
+	if err == nil {
+		return nil
+	}
+	for _, c := range errorCategories {
+		if errors.Is(err, c) {
+			return err
+		}
+	}
+	return &categoryError{err: err, category: category}
+}
+
+// classifyTransport places errors of requests that got no response in
+// ErrCancelled, ErrTimeout or ErrConnection.
+type classifyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *classifyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err == nil {
+		return res, nil
+	}
+	return nil, transportError(err)
+}
+
+// transportError places the error of a request that got no response in
+// ErrCancelled, ErrTimeout or ErrConnection.
+func transportError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &categoryError{err: err, category: ErrCancelled}
+	case isTimeout(err):
+		return &categoryError{err: err, category: ErrTimeout}
+	default:
+		return &categoryError{err: err, category: ErrConnection}
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,122 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// ESQLColumn names and types one column of an ES|QL result.
+type ESQLColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ESQLResult is the outcome of an ES|QL statement, with each row keyed by
+// column name.
+type ESQLResult struct {
+	Columns []ESQLColumn     `json:"columns"`
+	Rows    []map[string]any `json:"rows"`
+}
+
+// QueryESQL runs an ES|QL statement such as
+// `FROM logs-* | STATS count() BY severity` and returns its rows. Params
+// fill the statement's named placeholders (?name). The statement runs with
+// the adapter's credentials and is not limited to the index pattern, so it
+// must be enabled with AllowESQL.
+func (p *ElasticProvider) QueryESQL(ctx context.Context, statement string, params map[string]any) (ESQLResult, error) {
+	ctx = withAudit(ctx, "log.esql", schema.QueryScope{})
+	if !p.cfg.AllowESQL {
+		return ESQLResult{}, unsupported("ES|QL queries are disabled; set allowESQL to enable them")
+	}
+	if strings.TrimSpace(statement) == "" {
+		return ESQLResult{}, errors.New("ES|QL statement is empty")
+	}
+	info, err := p.ServerInfo(ctx)
+	if err != nil {
+		return ESQLResult{}, err
+	}
+	if !info.supports(featureESQL) {
+		since := featureVersions[DistributionElasticsearch][featureESQL]
+		return ESQLResult{}, fmt.Errorf("ES|QL needs Elasticsearch %d.%d or later; the cluster runs %s %s",
+			since[0], since[1], info.Distribution, info.Version)
+	}
+
+	body, err := json.Marshal(buildESQLQuery(statement, params))
+	if err != nil {
+		return ESQLResult{}, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	res, err := p.client.EsqlQuery(bytes.NewReader(body), p.client.EsqlQuery.WithContext(ctx))
+	if err != nil {
+		return ESQLResult{}, fmt.Errorf("elasticsearch ES|QL query failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return ESQLResult{}, responseError(res)
+	}
+
+	var result struct {
+		Took    int          `json:"took"`
+		Columns []ESQLColumn `json:"columns"`
+		Values  [][]any      `json:"values"`
+	}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return ESQLResult{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	p.meterResult(ctx, int64(len(result.Values)), int64(result.Took))
+	names := make([]string, len(result.Columns))
+	for i, column := range result.Columns {
+		names[i] = column.Name
+	}
+	rows, err := tableRows(names, result.Values)
+	if err != nil {
+		return ESQLResult{}, err
+	}
+	return ESQLResult{Columns: result.Columns, Rows: rows}, nil
+}
+
+// buildESQLQuery constructs the _query body. Named params are sent sorted
+// by name so the body is stable.
+func buildESQLQuery(statement string, params map[string]any) map[string]any {
+	body := map[string]any{"query": statement}
+	if len(params) == 0 {
+		return body
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		list = append(list, map[string]any{name: params[name]})
+	}
+	body["params"] = list
+	return body
+}
+
+// tableRows converts tabular values, as returned by ES|QL and SQL, into
+// rows keyed by column name.
+func tableRows(columns []string, values [][]any) ([]map[string]any, error) {
+	rows := make([]map[string]any, 0, len(values))
+	for i, value := range values {
+		if len(value) != len(columns) {
+			return nil, fmt.Errorf("row %d has %d values for %d columns", i, len(value), len(columns))
+		}
+		row := make(map[string]any, len(columns))
+		for c, column := range columns {
+			row[column] = value[c]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
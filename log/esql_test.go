@@ -0,0 +1,86 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// esqlServer fakes a cluster of the given version answering _query with a
+// canned columnar response.
+func esqlServer(t *testing.T, version string) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		switch req.Path {
+		case "/":
+			return 200, `{"version":{"number":"` + version + `"}}`
+		case "/_query":
+			return 200, `{
+				"columns":[{"name":"count()","type":"long"},{"name":"severity","type":"keyword"}],
+				"values":[[48000,"error"],[2000,"warn"],[12,null]]
+			}`
+		}
+		t.Errorf("unexpected request %s %s", req.Method, req.Path)
+		return 404, `{}`
+	}
+}
+
+func TestQueryESQL(t *testing.T) {
+	p, transport := newTestProvider(t, Config{AllowESQL: true}, esqlServer(t, "8.11.1"))
+
+	statement := `FROM logs-* | WHERE service == ?service | STATS count() BY severity`
+	result, err := p.QueryESQL(context.Background(), statement, map[string]any{"service": "api", "limit": 10})
+	if err != nil {
+		t.Fatalf("ES|QL query failed: %v", err)
+	}
+
+	if len(result.Columns) != 2 || result.Columns[0] != (ESQLColumn{Name: "count()", Type: "long"}) {
+		t.Errorf("columns = %+v, want count() and severity", result.Columns)
+	}
+	rows, _ := json.Marshal(result.Rows)
+	want := `[{"count()":48000,"severity":"error"},{"count()":2000,"severity":"warn"},{"count()":12,"severity":null}]`
+	if string(rows) != want {
+		t.Errorf("rows = %s, want %s", rows, want)
+	}
+
+	requests := transport.recorded()
+	if got := requestLine(requests); got != "GET /, POST /_query" {
+		t.Fatalf("requests = %s, want the version check then the query", got)
+	}
+	var body map[string]any
+	if err := json.Unmarshal([]byte(requests[1].Body), &body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	params, _ := json.Marshal(body["params"])
+	if body["query"] != statement || string(params) != `[{"limit":10},{"service":"api"}]` {
+		t.Errorf("body = %s, want the statement and params sorted by name", requests[1].Body)
+	}
+}
+
+func TestQueryESQLGates(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, esqlServer(t, "8.11.1"))
+	if _, err := p.QueryESQL(context.Background(), "FROM logs-*", nil); err == nil || !strings.Contains(err.Error(), "allowESQL") {
+		t.Errorf("err = %v, want ES|QL disabled", err)
+	}
+	if len(transport.recorded()) != 0 {
+		t.Errorf("requests = %s, want none while disabled", requestLine(transport.recorded()))
+	}
+
+	p, transport = newTestProvider(t, Config{AllowESQL: true}, esqlServer(t, "8.10.4"))
+	if _, err := p.QueryESQL(context.Background(), "FROM logs-*", nil); err == nil || !strings.Contains(err.Error(), "8.11 or later") {
+		t.Errorf("err = %v, want the version gate", err)
+	}
+	if got := requestLine(transport.recorded()); got != "GET /" {
+		t.Errorf("requests = %s, want only the version check", got)
+	}
+
+	if _, err := p.QueryESQL(context.Background(), "  ", nil); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("err = %v, want an empty statement error", err)
+	}
+}
+
+func TestTableRowsMismatch(t *testing.T) {
+	if _, err := tableRows([]string{"a"}, [][]any{{1, 2}}); err == nil {
+		t.Error("tableRows accepted a row wider than its columns")
+	}
+}
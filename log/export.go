@@ -0,0 +1,122 @@
+package log
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Export formats.
+const (
+	ExportNDJSON = "ndjson"
+	ExportCSV    = "csv"
+)
+
+// Export streams every entry matching a query to w, page by page as
+// QueryStream reads them, and returns the number of entries written.
+//
+// "ndjson" writes one JSON entry per line. "csv" writes a header and then
+// the timestamp, severity, service and message of each entry, followed by
+// one column per configured label field.
+func (p *ElasticProvider) Export(ctx context.Context, query schema.LogQuery, format string, w io.Writer) (int, error) {
+	ctx = withAudit(ctx, "log.export", query.Scope)
+	var write func(entry schema.LogEntry) error
+	var flush func() error
+	switch format {
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+		write = func(entry schema.LogEntry) error { return enc.Encode(entry) }
+		flush = func() error { return nil }
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		labels := p.labelFields()
+		header := append([]string{"timestamp", "severity", "service", "message"}, labels...)
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+		record := make([]string, len(header))
+		write = func(entry schema.LogEntry) error {
+			record[0] = ""
+			if !entry.Timestamp.IsZero() {
+				record[0] = entry.Timestamp.UTC().Format(time.RFC3339Nano)
+			}
+			record[1], record[2], record[3] = entry.Severity, entry.Service, entry.Message
+			for i, label := range labels {
+				record[4+i] = entry.Labels[label]
+			}
+			return cw.Write(record)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return 0, fmt.Errorf("unsupported export format %q: must be %q or %q", format, ExportNDJSON, ExportCSV)
+	}
+
+	rows := 0
+	err := p.QueryStream(ctx, query, func(batch []schema.LogEntry) error {
+		for _, entry := range batch {
+			if err := write(entry); err != nil {
+				return fmt.Errorf("failed to write export: %w", err)
+			}
+			rows++
+		}
+		return nil
+	})
+	if flushErr := flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("failed to write export: %w", flushErr)
+	}
+	return rows, err
+}
+
+// ExportToFile exports a query like Export into a new file at path, which
+// must lie inside ExportDir. Existing files are not overwritten, and the
+// file is removed if the export fails.
+func (p *ElasticProvider) ExportToFile(ctx context.Context, query schema.LogQuery, format, path string) (int, error) {
+	ctx = withAudit(ctx, "log.export", query.Scope)
+	if p.cfg.ExportDir == "" {
+		return 0, unsupported("exports to files are disabled; set exportDir to enable them")
+	}
+	dir, err := filepath.Abs(p.cfg.ExportDir)
+	if err != nil {
+		return 0, fmt.Errorf("invalid exportDir: %w", err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return 0, fmt.Errorf("export path %q is outside exportDir", path)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+	rows, err := p.Export(ctx, query, format, f)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write export: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return rows, nil
+}
+
+// labelFields returns the fields copied into entry labels.
+func (p *ElasticProvider) labelFields() []string {
+	if p.cfg.LabelFields != nil {
+		return p.cfg.LabelFields
+	}
+	return defaultLabelFields
+}
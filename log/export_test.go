@@ -0,0 +1,143 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// exportServer serves three entries over two pages of two, including a
+// message with embedded quotes, a comma and a newline.
+func exportServer(t *testing.T) func(req recordedRequest) (int, string) {
+	pages := []string{
+		`{"_index":"logs-a","_id":"3","_source":{"@timestamp":"2023-10-01T12:00:03Z","message":"said \"no\", then\nleft","severity":"error","service":"api","host.name":"web-1"},"sort":[3000,3]},
+		 {"_index":"logs-a","_id":"2","_source":{"@timestamp":"2023-10-01T12:00:02Z","message":"plain","severity":"info","service":"api"},"sort":[2000,2]}`,
+		`{"_index":"logs-a","_id":"1","_source":{"@timestamp":"2023-10-01T12:00:01.25Z","message":"last","service":"web","host.name":"web-2"},"sort":[1000,1]}`,
+	}
+	return func(req recordedRequest) (int, string) {
+		if req.Path != "/logs-*/_search" {
+			t.Errorf("unexpected request %s %s", req.Method, req.Path)
+			return 404, `{}`
+		}
+		page := pages[0]
+		if strings.Contains(req.Body, `"search_after"`) {
+			page = pages[1]
+		}
+		return 200, fmt.Sprintf(`{"hits":{"total":{"value":3,"relation":"eq"},"hits":[%s]}}`, page)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	p, _ := newTestProvider(t, Config{PageSize: 2, Pagination: paginationSearchAfter, LabelFields: []string{"host.name"}}, exportServer(t))
+
+	var out bytes.Buffer
+	rows, err := p.Export(context.Background(), schema.LogQuery{}, ExportCSV, &out)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if rows != 3 {
+		t.Errorf("rows = %d, want 3", rows)
+	}
+
+	wantPrefix := "timestamp,severity,service,message,host.name\n" +
+		"2023-10-01T12:00:03Z,error,api,\"said \"\"no\"\", then\nleft\",web-1\n"
+	if !strings.HasPrefix(out.String(), wantPrefix) {
+		t.Errorf("csv = %q, want it to start with %q", out.String(), wantPrefix)
+	}
+
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	want := [][]string{
+		{"timestamp", "severity", "service", "message", "host.name"},
+		{"2023-10-01T12:00:03Z", "error", "api", "said \"no\", then\nleft", "web-1"},
+		{"2023-10-01T12:00:02Z", "info", "api", "plain", ""},
+		{"2023-10-01T12:00:01.25Z", "", "web", "last", "web-2"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("records = %q, want %q", records, want)
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("record %d = %q, want %q", i, records[i], want[i])
+		}
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	p, _ := newTestProvider(t, Config{PageSize: 2, Pagination: paginationSearchAfter}, exportServer(t))
+
+	var out bytes.Buffer
+	rows, err := p.Export(context.Background(), schema.LogQuery{}, ExportNDJSON, &out)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if rows != 3 || len(lines) != 3 {
+		t.Fatalf("rows = %d, lines = %d; want 3 of each", rows, len(lines))
+	}
+	for i, want := range []string{"said \"no\", then\nleft", "plain", "last"} {
+		var entry schema.LogEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("line %d is not a JSON entry: %v", i, err)
+		}
+		if entry.Message != want {
+			t.Errorf("line %d message = %q, want %q", i, entry.Message, want)
+		}
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, exportServer(t))
+	if _, err := p.Export(context.Background(), schema.LogQuery{}, "xlsx", &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "xlsx") {
+		t.Errorf("err = %v, want an unsupported format error", err)
+	}
+	if len(transport.recorded()) != 0 {
+		t.Errorf("requests = %s, want none", requestLine(transport.recorded()))
+	}
+}
+
+func TestExportToFile(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := newTestProvider(t, Config{PageSize: 2, Pagination: paginationSearchAfter, ExportDir: dir}, exportServer(t))
+	ctx := context.Background()
+
+	rows, err := p.ExportToFile(ctx, schema.LogQuery{}, ExportNDJSON, "inc-42.ndjson")
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "inc-42.ndjson"))
+	if err != nil || rows != 3 || strings.Count(string(data), "\n") != 3 {
+		t.Errorf("file = %q, %v; want 3 lines", data, err)
+	}
+
+	if _, err := p.ExportToFile(ctx, schema.LogQuery{}, ExportNDJSON, "inc-42.ndjson"); err == nil {
+		t.Error("export overwrote an existing file")
+	}
+	for _, path := range []string{"../escape.csv", filepath.Join(filepath.Dir(dir), "escape.csv"), "."} {
+		if _, err := p.ExportToFile(ctx, schema.LogQuery{}, ExportCSV, path); err == nil || !strings.Contains(err.Error(), "outside exportDir") {
+			t.Errorf("path %s: err = %v, want it refused", path, err)
+		}
+	}
+	if _, err := p.ExportToFile(ctx, schema.LogQuery{}, "xlsx", "bad.xlsx"); err == nil {
+		t.Error("export accepted an unknown format")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.xlsx")); !os.IsNotExist(err) {
+		t.Errorf("failed export left its file behind: %v", err)
+	}
+
+	p.cfg.ExportDir = ""
+	if _, err := p.ExportToFile(ctx, schema.LogQuery{}, ExportCSV, filepath.Join(dir, "x.csv")); err == nil || !strings.Contains(err.Error(), "exportDir") {
+		t.Errorf("err = %v, want file exports disabled", err)
+	}
+}
@@ -0,0 +1,192 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// defaultFanOutConcurrency bounds the index groups searched at once unless
+// fanOutConcurrency is configured.
+const defaultFanOutConcurrency = 4
+
+// MetadataPartial is set to true on the entries of a fanned-out query that
+// some index groups failed to answer.
+const MetadataPartial = "partial"
+
+// IndexFailure is an index group a fanned-out query could not search.
+type IndexFailure struct {
+	Index  string `json:"index"`
+	Reason string `json:"reason"`
+}
+
+type searchIndexKey struct{}
+
+// searchIndex returns the indices a search for ctx reads: the index group
+// of a fanned-out query, else the configured pattern.
+func (p *ElasticProvider) searchIndex(ctx context.Context) string {
+	if index, ok := ctx.Value(searchIndexKey{}).(string); ok {
+		return index
+	}
+	return p.cfg.IndexPattern
+}
+
+// fanOutGroups splits a comma-separated index pattern into one group per
+// pattern it includes, each keeping the pattern's exclusions. It returns
+// nil when the query is to be sent as one search: fan-out is off, one
+// pattern applies, or the query resumes from a cursor or offset, which
+// belong to a single search, or reads a point in time.
+func (p *ElasticProvider) fanOutGroups(ctx context.Context, query schema.LogQuery) []string {
+	if !p.cfg.FanOut || ctx.Value(searchIndexKey{}) != nil || p.usePIT() || !p.canScroll(query) {
+		return nil
+	}
+	var includes, excludes []string
+	for _, pattern := range strings.Split(p.cfg.IndexPattern, ",") {
+		switch pattern = strings.TrimSpace(pattern); {
+		case pattern == "":
+		case strings.HasPrefix(pattern, "-"):
+			excludes = append(excludes, pattern)
+		default:
+			includes = append(includes, pattern)
+		}
+	}
+	if len(includes) < 2 {
+		return nil
+	}
+	groups := make([]string, len(includes))
+	for i, include := range includes {
+		groups[i] = strings.Join(append([]string{include}, excludes...), ",")
+	}
+	return groups
+}
+
+// groupResult is what the search of one index group returned.
+type groupResult struct {
+	entries []schema.LogEntry
+	stats   QueryStats
+	err     error
+}
+
+// fanOut runs query against each index group concurrently, at most
+// FanOutConcurrency at a time, and merges their entries by timestamp up to
+// the query's limit. Groups that fail are listed in the stats and their
+// entries flagged MetadataPartial, unless QueryOptionStrict is set, in
+// which case the first failure fails the query. The merged result has no
+// cursor, since there is none to resume every group from. built is the
+// query's DSL when it was already built, or nil.
+func (p *ElasticProvider) fanOut(ctx context.Context, query schema.LogQuery, built map[string]any, groups []string) ([]schema.LogEntry, QueryStats, error) {
+	strict, _ := boolValue(query.Metadata[QueryOptionStrict])
+	concurrency := p.cfg.FanOutConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultFanOutConcurrency
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]groupResult, len(groups))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i].err = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			r := &results[i]
+			r.entries, r.stats, r.err = p.runQuery(context.WithValue(ctx, searchIndexKey{}, group), query, built)
+			if r.err != nil && strict {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var stats QueryStats
+	var merged []schema.LogEntry
+	var firstErr error
+	for i, r := range results {
+		if r.err != nil {
+			if firstErr == nil || (errors.Is(firstErr, context.Canceled) && !errors.Is(r.err, context.Canceled)) {
+				firstErr = r.err
+			}
+			stats.IndexFailures = append(stats.IndexFailures, IndexFailure{Index: groups[i], Reason: r.err.Error()})
+			continue
+		}
+		p.releaseCursor(r.stats.NextCursor)
+		stats = mergeGroupStats(stats, r.stats)
+		merged = append(merged, r.entries...)
+	}
+	if firstErr != nil && (strict || len(stats.IndexFailures) == len(groups)) {
+		return nil, QueryStats{}, firstErr
+	}
+	if ctx.Err() != nil && len(stats.IndexFailures) > 0 {
+		// The caller gave up; what finished is not the answer it waited for
+		return nil, QueryStats{}, firstErr
+	}
+	stats.Partial = len(stats.IndexFailures) > 0
+
+	order, _ := queryOrder(query)
+	sortByTimestamp(merged, order)
+	requested := p.querySize(query)
+	if limit := min(requested, p.maxEntriesInMemory()); len(merged) > limit {
+		stats.Truncated = stats.Truncated || requested > limit
+		merged = merged[:limit]
+	}
+	for _, entry := range merged {
+		delete(entry.Metadata, MetadataNextCursor)
+		delete(entry.Metadata, MetadataTruncated)
+		entry.Metadata[MetadataTotalHits] = stats.TotalHits
+		entry.Metadata[MetadataTotalHitsRelation] = stats.TotalHitsRelation
+		if stats.Partial {
+			entry.Metadata[MetadataPartial] = true
+		}
+	}
+	if stats.Truncated && len(merged) > 0 {
+		merged[len(merged)-1].Metadata[MetadataTruncated] = true
+	}
+	return merged, stats, nil
+}
+
+// mergeGroupStats adds one group's stats to the totals. The groups are
+// searched side by side, so the query took as long as the slowest.
+func mergeGroupStats(total, group QueryStats) QueryStats {
+	total.TotalHits += group.TotalHits
+	if total.TotalHitsRelation != "gte" {
+		total.TotalHitsRelation = group.TotalHitsRelation
+	}
+	if total.TotalHitsRelation == "" {
+		total.TotalHitsRelation = "eq"
+	}
+	total.TookMillis = max(total.TookMillis, group.TookMillis)
+	total.TimedOut = total.TimedOut || group.TimedOut
+	total.Truncated = total.Truncated || group.Truncated
+	total.ShardFailures = append(total.ShardFailures, group.ShardFailures...)
+	total.totalShards += group.totalShards
+	return total
+}
+
+// sortByTimestamp orders entries as Elasticsearch sorts them: by timestamp
+// in order, entries without one last. Entries with the same timestamp keep
+// their order, so each group's tiebreaker order holds.
+func sortByTimestamp(entries []schema.LogEntry, order string) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Timestamp, entries[j].Timestamp
+		switch {
+		case a.IsZero() || b.IsZero():
+			return !a.IsZero() && b.IsZero()
+		case order == orderAsc:
+			return a.Before(b)
+		default:
+			return a.After(b)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// groupHits answers a search of an index group with hits at the given
+// seconds past noon, named after the group's first pattern.
+func groupHits(req recordedRequest, relation string, seconds ...int) string {
+	group := strings.TrimPrefix(strings.SplitN(req.Path, ",", 2)[0], "/")
+	group = strings.TrimSuffix(group, "/_search")
+	hits := make([]string, len(seconds))
+	for i, s := range seconds {
+		timestamp := fmt.Sprintf(`"2024-05-01T12:00:%02dZ"`, s)
+		if s < 0 {
+			timestamp = "null"
+		}
+		hits[i] = fmt.Sprintf(`{"_index":"%s","_id":"%s-%d","_source":{"@timestamp":%s,"message":"%s"}}`, group, group, i, timestamp, group)
+	}
+	return fmt.Sprintf(`{"took":%d,"hits":{"total":{"value":%d,"relation":"%s"},"hits":[%s]}}`, len(seconds)*10, len(seconds), relation, strings.Join(hits, ","))
+}
+
+func TestFanOutMergesByTimestamp(t *testing.T) {
+	cfg := Config{IndexPattern: "app-*,audit-*,-app-old", FanOut: true}
+	p, transport := newTestProvider(t, cfg, func(req recordedRequest) (int, string) {
+		if strings.HasPrefix(req.Path, "/app-*") {
+			return 200, groupHits(req, "eq", 50, 30, 10)
+		}
+		return 200, groupHits(req, "gte", 40, 20)
+	})
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 4})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, fmt.Sprintf("%s@%d", entry.Message, entry.Timestamp.Second()))
+	}
+	if want := "app-*@50 audit-*@40 app-*@30 audit-*@20"; strings.Join(got, " ") != want {
+		t.Errorf("entries = %v, want %s", got, want)
+	}
+	if stats.TotalHits != 5 || stats.TotalHitsRelation != "gte" || stats.TookMillis != 30 || stats.Partial || stats.NextCursor != "" {
+		t.Errorf("stats = %+v, want the groups' totals, the slowest took, and no cursor", stats)
+	}
+	if entries[0].Metadata[MetadataTotalHits] != 5 {
+		t.Errorf("metadata = %v, want the merged total", entries[0].Metadata)
+	}
+
+	paths := map[string]bool{}
+	for _, req := range transport.recorded() {
+		paths[req.Path] = true
+	}
+	if len(paths) != 2 || !paths["/app-*,-app-old/_search"] || !paths["/audit-*,-app-old/_search"] {
+		t.Errorf("paths = %v, want one search per pattern keeping the exclusion", paths)
+	}
+}
+
+func TestFanOutAscendingMissingTimestampsLast(t *testing.T) {
+	p, _ := newTestProvider(t, Config{IndexPattern: "a-*,b-*", FanOut: true}, func(req recordedRequest) (int, string) {
+		if strings.HasPrefix(req.Path, "/a-*") {
+			return 200, groupHits(req, "eq", 10, -1)
+		}
+		return 200, groupHits(req, "eq", 5, 20)
+	})
+
+	query := schema.LogQuery{Limit: 10, Metadata: map[string]any{QueryOptionOrder: "asc"}}
+	entries, _, err := p.QueryWithStats(context.Background(), query)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	var got []int
+	for _, entry := range entries {
+		if entry.Timestamp.IsZero() {
+			got = append(got, -1)
+		} else {
+			got = append(got, entry.Timestamp.Second())
+		}
+	}
+	if fmt.Sprint(got) != "[5 10 20 -1]" {
+		t.Errorf("timestamps = %v, want ascending with the missing one last", got)
+	}
+}
+
+func TestFanOutBoundedConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	release := make(chan struct{})
+	patterns := []string{"a-*", "b-*", "c-*", "d-*", "e-*"}
+	p, transport := newTestProvider(t, Config{IndexPattern: strings.Join(patterns, ","), FanOut: true, FanOutConcurrency: 2}, func(req recordedRequest) (int, string) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		<-release
+		return 200, groupHits(req, "eq", 1)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Query(context.Background(), schema.LogQuery{Limit: 10})
+		done <- err
+	}()
+	deadline := time.After(5 * time.Second)
+	for running.Load() < 2 {
+		select {
+		case <-deadline:
+			t.Fatal("searches never started")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if peak.Load() != 2 || len(transport.recorded()) != 5 {
+		t.Errorf("peak = %d, searches = %d; want 5 searches, 2 at a time", peak.Load(), len(transport.recorded()))
+	}
+}
+
+func TestFanOutPartialResults(t *testing.T) {
+	handler := func(req recordedRequest) (int, string) {
+		if strings.HasPrefix(req.Path, "/broken-*") {
+			return 400, `{"error":{"type":"index_closed_exception","reason":"closed"},"status":400}`
+		}
+		return 200, groupHits(req, "eq", 30, 10)
+	}
+	p, _ := newTestProvider(t, Config{IndexPattern: "app-*,broken-*", FanOut: true}, handler)
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if !stats.Partial || len(stats.IndexFailures) != 1 || stats.IndexFailures[0].Index != "broken-*" || !strings.Contains(stats.IndexFailures[0].Reason, "closed") {
+		t.Errorf("stats = %+v, want the failed group listed", stats)
+	}
+	if len(entries) != 2 || entries[0].Metadata[MetadataPartial] != true {
+		t.Errorf("entries = %v, want the other group's entries flagged partial", entries)
+	}
+
+	// Partial results are not cached
+	p.cfg.QueryCacheSize = 10
+	if _, stats, _ := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10}); stats.Cached {
+		t.Error("first query answered from the cache")
+	}
+	if _, stats, _ := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10}); stats.Cached {
+		t.Error("partial result was cached")
+	}
+
+	strict := schema.LogQuery{Limit: 10, Metadata: map[string]any{QueryOptionStrict: true}}
+	if _, _, err := p.QueryWithStats(context.Background(), strict); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("err = %v, want the group's failure in strict mode", err)
+	}
+}
+
+func TestFanOutAllGroupsFail(t *testing.T) {
+	p, _ := newTestProvider(t, Config{IndexPattern: "a-*,b-*", FanOut: true}, func(req recordedRequest) (int, string) {
+		return 401, `{"error":{"type":"security_exception","reason":"unauthorized"},"status":401}`
+	})
+	if _, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10}); !errors.Is(err, ErrAuth) {
+		t.Errorf("err = %v, want the failure when no group answered", err)
+	}
+}
+
+func TestFanOutSingleSearch(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	handler := func(req recordedRequest) (int, string) {
+		mu.Lock()
+		paths = append(paths, req.Path)
+		mu.Unlock()
+		return 200, groupHits(req, "eq", 1)
+	}
+	tests := []struct {
+		name  string
+		cfg   Config
+		query schema.LogQuery
+	}{
+		{name: "one pattern", cfg: Config{IndexPattern: "app-*,-app-old", FanOut: true}},
+		{name: "fan-out off", cfg: Config{IndexPattern: "app-*,audit-*"}},
+		{name: "offset", cfg: Config{IndexPattern: "app-*,audit-*", FanOut: true}, query: schema.LogQuery{Metadata: map[string]any{QueryOptionOffset: 10}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths = nil
+			p, _ := newTestProvider(t, tt.cfg, handler)
+			tt.query.Limit = 5
+			if _, err := p.Query(context.Background(), tt.query); err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if len(paths) != 1 || paths[0] != "/"+tt.cfg.IndexPattern+"/_search" {
+				t.Errorf("paths = %v, want one search of the whole pattern", paths)
+			}
+		})
+	}
+}
+
+func TestParseFanOutConfig(t *testing.T) {
+	for _, v := range []any{true, "true", "TRUE"} {
+		if cfg := parseConfig(map[string]any{"fanOut": v}); !cfg.FanOut {
+			t.Errorf("fanOut %#v: FanOut = false, want true", v)
+		}
+	}
+	if cfg := parseConfig(map[string]any{"fanOut": "false"}); cfg.FanOut {
+		t.Error("FanOut = true, want false")
+	}
+}
@@ -0,0 +1,150 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// FieldInfo describes a field of the logs matched by an index pattern.
+type FieldInfo struct {
+	Name string `json:"name"`
+	// Type is the field's mapping type, or "conflict" when indices map it
+	// differently; Types then lists every type.
+	Type         string   `json:"type"`
+	Types        []string `json:"types,omitempty"`
+	Searchable   bool     `json:"searchable"`
+	Aggregatable bool     `json:"aggregatable"`
+	// Consistent is false when indices map the field to different types.
+	// ConflictingIndices lists the indices per type in that case.
+	Consistent         bool                `json:"consistent"`
+	ConflictingIndices map[string][]string `json:"conflictingIndices,omitempty"`
+}
+
+// fieldTypeConflict is the Type of a field mapped to several types.
+const fieldTypeConflict = "conflict"
+
+// defaultFieldCacheTTL is how long listed fields are reused.
+const defaultFieldCacheTTL = 5 * time.Minute
+
+type cachedFields struct {
+	fields  []FieldInfo
+	expires time.Time
+}
+
+func (p *ElasticProvider) fieldCacheTTL() time.Duration {
+	if p.cfg.FieldCacheTTL > 0 {
+		return p.cfg.FieldCacheTTL
+	}
+	return defaultFieldCacheTTL
+}
+
+// ListFields returns the fields of the indices matching pattern, sorted by
+// name, using the field capabilities API. An empty pattern means the
+// configured index pattern. Results are cached for FieldCacheTTL.
+func (p *ElasticProvider) ListFields(ctx context.Context, pattern string) ([]FieldInfo, error) {
+	ctx = withAudit(ctx, "log.fields", schema.QueryScope{})
+	if pattern == "" {
+		pattern = p.cfg.IndexPattern
+	}
+
+	p.fieldMu.Lock()
+	cached, ok := p.fieldCache[pattern]
+	p.fieldMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.fields, nil
+	}
+
+	res, err := p.client.FieldCaps(
+		p.client.FieldCaps.WithContext(ctx),
+		p.client.FieldCaps.WithIndex(pattern),
+		p.client.FieldCaps.WithFields("*"),
+		p.client.FieldCaps.WithIgnoreUnavailable(true),
+		p.client.FieldCaps.WithAllowNoIndices(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch field caps failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result esFieldCapsResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	fields := result.fields()
+
+	p.fieldMu.Lock()
+	if p.fieldCache == nil {
+		p.fieldCache = make(map[string]cachedFields)
+	}
+	p.fieldCache[pattern] = cachedFields{fields: fields, expires: time.Now().Add(p.fieldCacheTTL())}
+	p.fieldMu.Unlock()
+
+	return fields, nil
+}
+
+// esFieldCapsResponse maps field names to their capabilities per type.
+type esFieldCapsResponse struct {
+	Fields map[string]map[string]struct {
+		Type          string   `json:"type"`
+		MetadataField bool     `json:"metadata_field"`
+		Searchable    bool     `json:"searchable"`
+		Aggregatable  bool     `json:"aggregatable"`
+		Indices       []string `json:"indices"`
+	} `json:"fields"`
+}
+
+// fields converts the response, skipping metadata fields such as _id and
+// object fields, which hold no values of their own.
+func (r esFieldCapsResponse) fields() []FieldInfo {
+	out := make([]FieldInfo, 0, len(r.Fields))
+	for name, caps := range r.Fields {
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+
+		info := FieldInfo{Name: name, Searchable: true, Aggregatable: true}
+		for typ, c := range caps {
+			if c.MetadataField || typ == "object" || typ == "nested" {
+				continue
+			}
+			info.Types = append(info.Types, typ)
+			info.Searchable = info.Searchable && c.Searchable
+			info.Aggregatable = info.Aggregatable && c.Aggregatable
+			if len(c.Indices) > 0 {
+				if info.ConflictingIndices == nil {
+					info.ConflictingIndices = make(map[string][]string)
+				}
+				info.ConflictingIndices[typ] = c.Indices
+			}
+		}
+		if len(info.Types) == 0 {
+			continue
+		}
+
+		sort.Strings(info.Types)
+		info.Consistent = len(info.Types) == 1
+		if info.Consistent {
+			info.Type = info.Types[0]
+			info.Types = nil
+			// Per-type indices are only listed for conflicts; partial
+			// searchability within one type is not a conflict
+			info.ConflictingIndices = nil
+		} else {
+			info.Type = fieldTypeConflict
+		}
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
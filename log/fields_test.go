@@ -0,0 +1,118 @@
+package log
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fieldCapsResponse = `{
+	"indices": ["logs-app-2023.10.01", "logs-app-2023.10.02", "logs-edge-2023.10.02"],
+	"fields": {
+		"_id": {"_id": {"type": "_id", "metadata_field": true, "searchable": true, "aggregatable": false}},
+		"_index": {"_index": {"type": "_index", "metadata_field": true, "searchable": true, "aggregatable": true}},
+		"@timestamp": {"date": {"type": "date", "metadata_field": false, "searchable": true, "aggregatable": true}},
+		"message": {"text": {"type": "text", "metadata_field": false, "searchable": true, "aggregatable": false}},
+		"service": {"keyword": {"type": "keyword", "metadata_field": false, "searchable": true, "aggregatable": true}},
+		"http": {"object": {"type": "object", "metadata_field": false, "searchable": false, "aggregatable": false}},
+		"http.status": {
+			"long": {"type": "long", "metadata_field": false, "searchable": true, "aggregatable": true, "indices": ["logs-app-2023.10.01", "logs-app-2023.10.02"]},
+			"keyword": {"type": "keyword", "metadata_field": false, "searchable": true, "aggregatable": true, "indices": ["logs-edge-2023.10.02"]}
+		},
+		"user.id": {
+			"keyword": {"type": "keyword", "metadata_field": false, "searchable": true, "aggregatable": true, "indices": ["logs-app-2023.10.01"]},
+			"text": {"type": "text", "metadata_field": false, "searchable": true, "aggregatable": false, "indices": ["logs-app-2023.10.02"]}
+		}
+	}
+}`
+
+func TestListFields(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, fieldCapsResponse
+	})
+
+	fields, err := p.ListFields(context.Background(), "")
+	if err != nil {
+		t.Fatalf("list fields failed: %v", err)
+	}
+
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "@timestamp,http.status,message,service,user.id" {
+		t.Fatalf("fields = %s, want sorted fields without metadata or object fields", got)
+	}
+
+	message := fields[2]
+	if message.Type != "text" || !message.Searchable || message.Aggregatable || !message.Consistent || message.ConflictingIndices != nil {
+		t.Errorf("message = %+v, want a consistent searchable text field", message)
+	}
+
+	status := fields[1]
+	if status.Type != fieldTypeConflict || status.Consistent || strings.Join(status.Types, ",") != "keyword,long" {
+		t.Errorf("http.status = %+v, want a keyword/long conflict", status)
+	}
+	if !status.Aggregatable || strings.Join(status.ConflictingIndices["keyword"], ",") != "logs-edge-2023.10.02" {
+		t.Errorf("http.status = %+v, want aggregatable with the keyword index listed", status)
+	}
+
+	if userID := fields[4]; userID.Aggregatable || userID.Consistent {
+		t.Errorf("user.id = %+v, want an inconsistent field not aggregatable everywhere", userID)
+	}
+
+	req := transport.recorded()[0]
+	if req.Path != "/logs-*/_field_caps" || req.Query.Get("fields") != "*" {
+		t.Errorf("request = %s?%s, want field caps for every field of the index pattern", req.Path, req.Query.Encode())
+	}
+}
+
+func TestListFieldsCache(t *testing.T) {
+	p, transport := newTestProvider(t, Config{FieldCacheTTL: time.Minute}, func(req recordedRequest) (int, string) {
+		return 200, fieldCapsResponse
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.ListFields(context.Background(), ""); err != nil {
+			t.Fatalf("list fields failed: %v", err)
+		}
+	}
+	if len(transport.recorded()) != 1 {
+		t.Errorf("requests = %d, want later calls served from the cache", len(transport.recorded()))
+	}
+
+	if _, err := p.ListFields(context.Background(), "metrics-*"); err != nil {
+		t.Fatalf("list fields failed: %v", err)
+	}
+	if requests := transport.recorded(); len(requests) != 2 || requests[1].Path != "/metrics-*/_field_caps" {
+		t.Errorf("requests = %d, want another pattern cached separately", len(requests))
+	}
+
+	// Expire the entry
+	p.fieldMu.Lock()
+	entry := p.fieldCache["logs-*"]
+	entry.expires = time.Now().Add(-time.Second)
+	p.fieldCache["logs-*"] = entry
+	p.fieldMu.Unlock()
+
+	if _, err := p.ListFields(context.Background(), ""); err != nil {
+		t.Fatalf("list fields failed: %v", err)
+	}
+	if len(transport.recorded()) != 3 {
+		t.Errorf("requests = %d, want a refresh after the TTL", len(transport.recorded()))
+	}
+}
+
+func TestListFieldsError(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 403, `{"error":{"type":"security_exception","reason":"action [indices:data/read/field_caps] is unauthorized"}}`
+	})
+
+	if _, err := p.ListFields(context.Background(), ""); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("err = %v, want the elasticsearch error", err)
+	}
+	if len(p.fieldCache) != 0 {
+		t.Error("failed listing was cached")
+	}
+}
@@ -0,0 +1,89 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Cluster health statuses.
+const (
+	HealthGreen  = "green"
+	HealthYellow = "yellow"
+	HealthRed    = "red"
+)
+
+// HealthStatus reports the cluster's health for the configured index
+// pattern. A yellow or red Status is reported, not returned as an error.
+type HealthStatus struct {
+	ClusterName string `json:"clusterName"`
+	Status      string `json:"status"`
+	Nodes       int    `json:"nodes"`
+	DataNodes   int    `json:"dataNodes"`
+	// ActiveShardsPercent is the share of the pattern's shards that are
+	// active, from 0 to 100.
+	ActiveShardsPercent float64 `json:"activeShardsPercent"`
+	UnassignedShards    int     `json:"unassignedShards"`
+	// LatencyMillis is the round trip of the health request.
+	LatencyMillis int64 `json:"latencyMillis"`
+	// Cached is set when the status is the last background probe's, taken
+	// AgeMillis ago.
+	Cached    bool  `json:"cached,omitempty"`
+	AgeMillis int64 `json:"ageMillis,omitempty"`
+}
+
+// Health checks the cluster health of the indices matching the configured
+// index pattern. It fails only when the cluster cannot be reached or
+// answers with an error. With HealthProbeInterval set, it returns the last
+// background probe's result at once instead, once there is one.
+func (p *ElasticProvider) Health(ctx context.Context) (HealthStatus, error) {
+	if p.prober != nil {
+		if status, err, ok := p.prober.cached(); ok {
+			return status, err
+		}
+	}
+	return p.checkHealth(withAudit(ctx, "health", schema.QueryScope{}))
+}
+
+// checkHealth requests the cluster health.
+func (p *ElasticProvider) checkHealth(ctx context.Context) (HealthStatus, error) {
+	start := time.Now()
+	res, err := p.client.Cluster.Health(
+		p.client.Cluster.Health.WithContext(ctx),
+		p.client.Cluster.Health.WithIndex(p.cfg.IndexPattern),
+	)
+	latency := time.Since(start)
+	if err != nil {
+		return HealthStatus{}, fmt.Errorf("elasticsearch health check failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return HealthStatus{}, responseError(res)
+	}
+
+	var result struct {
+		ClusterName         string  `json:"cluster_name"`
+		Status              string  `json:"status"`
+		NumberOfNodes       int     `json:"number_of_nodes"`
+		NumberOfDataNodes   int     `json:"number_of_data_nodes"`
+		UnassignedShards    int     `json:"unassigned_shards"`
+		ActiveShardsPercent float64 `json:"active_shards_percent_as_number"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return HealthStatus{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return HealthStatus{
+		ClusterName:         result.ClusterName,
+		Status:              result.Status,
+		Nodes:               result.NumberOfNodes,
+		DataNodes:           result.NumberOfDataNodes,
+		ActiveShardsPercent: result.ActiveShardsPercent,
+		UnassignedShards:    result.UnassignedShards,
+		LatencyMillis:       latency.Milliseconds(),
+	}, nil
+}
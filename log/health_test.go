@@ -0,0 +1,60 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		status     string
+		nodes      int
+		percent    float64
+		unassigned int
+	}{
+		{status: HealthGreen, nodes: 3, percent: 100, unassigned: 0},
+		{status: HealthYellow, nodes: 1, percent: 50, unassigned: 5},
+		{status: HealthRed, nodes: 2, percent: 37.5, unassigned: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+				return 200, fmt.Sprintf(`{"cluster_name":"logging","status":%q,"timed_out":false,"number_of_nodes":%d,"number_of_data_nodes":%d,
+					"active_primary_shards":5,"active_shards":5,"relocating_shards":0,"initializing_shards":0,"unassigned_shards":%d,
+					"delayed_unassigned_shards":0,"number_of_pending_tasks":0,"number_of_in_flight_fetch":0,
+					"task_max_waiting_in_queue_millis":0,"active_shards_percent_as_number":%v}`,
+					tt.status, tt.nodes, tt.nodes, tt.unassigned, tt.percent)
+			})
+
+			health, err := p.Health(context.Background())
+			if err != nil {
+				t.Fatalf("health failed: %v, want %s reported as a status", err, tt.status)
+			}
+			if health.Status != tt.status || health.ClusterName != "logging" || health.Nodes != tt.nodes || health.DataNodes != tt.nodes {
+				t.Errorf("health = %+v, want status %s on %d nodes", health, tt.status, tt.nodes)
+			}
+			if health.ActiveShardsPercent != tt.percent || health.UnassignedShards != tt.unassigned {
+				t.Errorf("health = %+v, want %v%% active and %d unassigned", health, tt.percent, tt.unassigned)
+			}
+			if health.LatencyMillis < 0 {
+				t.Errorf("latency = %d, want non-negative", health.LatencyMillis)
+			}
+			if path := transport.recorded()[0].Path; path != "/_cluster/health/logs-*" {
+				t.Errorf("path = %s, want health for the index pattern", path)
+			}
+		})
+	}
+}
+
+func TestHealthError(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 403, `{"error":{"type":"security_exception","reason":"action [cluster:monitor/health] is unauthorized"}}`
+	})
+
+	if _, err := p.Health(context.Background()); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("err = %v, want the elasticsearch error", err)
+	}
+}
@@ -0,0 +1,179 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// HistogramBucket counts the logs in one interval of a volume histogram.
+type HistogramBucket struct {
+	// Timestamp is the start of the interval.
+	Timestamp time.Time `json:"timestamp"`
+	Count     int64     `json:"count"`
+	// Severities splits Count by canonical severity when requested with
+	// QueryOptionBySeverity. Logs without a severity are not counted here.
+	Severities map[string]int64 `json:"severities,omitempty"`
+}
+
+// targetHistogramBuckets is the bucket count an automatic interval aims for.
+const targetHistogramBuckets = 100
+
+// defaultHistogramInterval is used when the query window is open-ended.
+const defaultHistogramInterval = time.Minute
+
+// histogramIntervals are the intervals an automatic interval rounds up to.
+var histogramIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// histogramInterval picks an interval giving about targetHistogramBuckets
+// buckets over the query window.
+func histogramInterval(query schema.LogQuery) time.Duration {
+	if query.Start.IsZero() || query.End.IsZero() || !query.End.After(query.Start) {
+		return defaultHistogramInterval
+	}
+	want := query.End.Sub(query.Start) / targetHistogramBuckets
+	for _, interval := range histogramIntervals {
+		if interval >= want {
+			return interval
+		}
+	}
+	return histogramIntervals[len(histogramIntervals)-1]
+}
+
+// buildHistogramQuery constructs a size 0 search whose date_histogram counts
+// the logs matching query per interval.
+func (p *ElasticProvider) buildHistogramQuery(query schema.LogQuery, interval time.Duration) map[string]any {
+	volume := map[string]any{"date_histogram": dateHistogram(query, interval)}
+	if split, _ := boolValue(query.Metadata[QueryOptionBySeverity]); split {
+		volume["aggs"] = map[string]any{
+			"severity": map[string]any{
+				"terms": map[string]any{"field": "severity", "size": 20},
+			},
+		}
+	}
+
+	return map[string]any{
+		"query": p.boolQuery(query),
+		"size":  0,
+		"aggs":  map[string]any{"volume": volume},
+	}
+}
+
+// dateHistogram is the date_histogram over query's window with the given
+// interval.
+func dateHistogram(query schema.LogQuery, interval time.Duration) map[string]any {
+	histogram := map[string]any{
+		"field":          "@timestamp",
+		"fixed_interval": fmt.Sprintf("%dms", interval.Milliseconds()),
+		"min_doc_count":  0,
+	}
+	// Cover the whole window so empty intervals still get a bucket
+	if !query.Start.IsZero() && !query.End.IsZero() {
+		histogram["extended_bounds"] = map[string]any{
+			"min": query.Start.UnixMilli(),
+			"max": query.End.UnixMilli(),
+		}
+	}
+	return histogram
+}
+
+// Histogram counts the logs matching a query per interval over the query
+// window. A zero interval is chosen automatically for about 100 buckets.
+func (p *ElasticProvider) Histogram(ctx context.Context, query schema.LogQuery, interval time.Duration) ([]HistogramBucket, error) {
+	ctx = withAudit(ctx, "log.histogram", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return nil, err
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid histogram interval %s: must not be negative", interval)
+	}
+	if interval == 0 {
+		interval = histogramInterval(query)
+	}
+	if interval < time.Millisecond {
+		return nil, fmt.Errorf("invalid histogram interval %s: must be at least 1ms", interval)
+	}
+
+	queryBody, err := json.Marshal(p.buildHistogramQuery(query, interval))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	var buckets []HistogramBucket
+	err = p.retry(ctx, func(ctx context.Context) (err error) {
+		buckets, err = p.histogram(ctx, queryBody)
+		return err
+	})
+	return buckets, err
+}
+
+// histogram sends one histogram search.
+func (p *ElasticProvider) histogram(ctx context.Context, queryBody []byte) ([]HistogramBucket, error) {
+	res, err := p.client.Search(
+		p.client.Search.WithContext(ctx),
+		p.client.Search.WithIndex(p.cfg.IndexPattern),
+		p.client.Search.WithBody(bytes.NewReader(queryBody)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch search failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result esHistogramResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	p.meterResponse(ctx, result)
+	return result.buckets(), nil
+}
+
+type esHistogramResponse struct {
+	esSearchResponse
+	Aggregations struct {
+		Volume struct {
+			Buckets []struct {
+				Key      int64 `json:"key"`
+				DocCount int64 `json:"doc_count"`
+				Severity *struct {
+					Buckets []struct {
+						Key      string `json:"key"`
+						DocCount int64  `json:"doc_count"`
+					} `json:"buckets"`
+				} `json:"severity"`
+			} `json:"buckets"`
+		} `json:"volume"`
+	} `json:"aggregations"`
+}
+
+// buckets converts the date_histogram buckets, merging severity spellings
+// that share a canonical name.
+func (r esHistogramResponse) buckets() []HistogramBucket {
+	out := make([]HistogramBucket, 0, len(r.Aggregations.Volume.Buckets))
+	for _, b := range r.Aggregations.Volume.Buckets {
+		bucket := HistogramBucket{
+			Timestamp: time.UnixMilli(b.Key).UTC(),
+			Count:     b.DocCount,
+		}
+		if b.Severity != nil {
+			bucket.Severities = make(map[string]int64, len(b.Severity.Buckets))
+			for _, sev := range b.Severity.Buckets {
+				bucket.Severities[canonicalSeverity(sev.Key)] += sev.DocCount
+			}
+		}
+		out = append(out, bucket)
+	}
+	return out
+}
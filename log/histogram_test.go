@@ -0,0 +1,145 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestHistogramInterval(t *testing.T) {
+	start := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		window time.Duration
+		want   time.Duration
+	}{
+		{name: "15 minutes", window: 15 * time.Minute, want: 10 * time.Second},
+		{name: "1 hour", window: time.Hour, want: time.Minute},
+		{name: "24 hours", window: 24 * time.Hour, want: 30 * time.Minute},
+		{name: "30 days", window: 30 * 24 * time.Hour, want: 12 * time.Hour},
+		{name: "5 years", window: 5 * 365 * 24 * time.Hour, want: 7 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := histogramInterval(schema.LogQuery{Start: start, End: start.Add(tt.window)})
+			if got != tt.want {
+				t.Errorf("interval = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := histogramInterval(schema.LogQuery{End: start}); got != defaultHistogramInterval {
+		t.Errorf("open window interval = %s, want %s", got, defaultHistogramInterval)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, `{"took":3,"hits":{"total":{"value":7,"relation":"eq"},"hits":[]},"aggregations":{"volume":{"buckets":[
+			{"key_as_string":"2023-10-01T12:00:00.000Z","key":1696161600000,"doc_count":5,"severity":{"buckets":[
+				{"key":"error","doc_count":2},{"key":"ERROR","doc_count":1},{"key":"warn","doc_count":2}
+			]}},
+			{"key_as_string":"2023-10-01T12:01:00.000Z","key":1696161660000,"doc_count":0,"severity":{"buckets":[]}},
+			{"key_as_string":"2023-10-01T12:02:00.000Z","key":1696161720000,"doc_count":2,"severity":{"buckets":[
+				{"key":"info","doc_count":2}
+			]}}
+		]}}}`
+	})
+
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	buckets, err := p.Histogram(context.Background(), schema.LogQuery{
+		Start:      start,
+		End:        start.Add(3 * time.Minute),
+		Expression: &schema.LogExpression{Search: "timeout"},
+		Metadata:   map[string]any{QueryOptionBySeverity: true},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("histogram failed: %v", err)
+	}
+
+	if len(buckets) != 3 {
+		t.Fatalf("buckets = %d, want 3", len(buckets))
+	}
+	if !buckets[1].Timestamp.Equal(start.Add(time.Minute)) || buckets[1].Count != 0 {
+		t.Errorf("buckets[1] = %+v, want an empty bucket at 12:01", buckets[1])
+	}
+	if got := buckets[0].Severities; got["error"] != 3 || got["warn"] != 2 || len(got) != 2 {
+		t.Errorf("buckets[0] severities = %v, want error 3 and warn 2", got)
+	}
+
+	var body struct {
+		Size  *int           `json:"size"`
+		Query map[string]any `json:"query"`
+		Aggs  struct {
+			Volume struct {
+				DateHistogram struct {
+					Field          string `json:"field"`
+					FixedInterval  string `json:"fixed_interval"`
+					ExtendedBounds struct {
+						Min int64 `json:"min"`
+						Max int64 `json:"max"`
+					} `json:"extended_bounds"`
+				} `json:"date_histogram"`
+				Aggs map[string]any `json:"aggs"`
+			} `json:"volume"`
+		} `json:"aggs"`
+	}
+	req := transport.recorded()[0]
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	if req.Path != "/logs-*/_search" || body.Size == nil || *body.Size != 0 {
+		t.Errorf("request = %s %s, want a size 0 search", req.Path, req.Body)
+	}
+	histogram := body.Aggs.Volume.DateHistogram
+	if histogram.Field != "@timestamp" || histogram.FixedInterval != "60000ms" {
+		t.Errorf("date_histogram = %+v, want @timestamp every 60000ms", histogram)
+	}
+	if histogram.ExtendedBounds.Min != start.UnixMilli() || histogram.ExtendedBounds.Max != start.Add(3*time.Minute).UnixMilli() {
+		t.Errorf("extended_bounds = %+v, want the query window", histogram.ExtendedBounds)
+	}
+	if body.Aggs.Volume.Aggs["severity"] == nil {
+		t.Error("aggs has no severity split, want one with _bySeverity")
+	}
+	if !strings.Contains(req.Body, `"timeout"`) || strings.Contains(req.Body, QueryOptionBySeverity) {
+		t.Errorf("body = %s, want the search and no reserved key filter", req.Body)
+	}
+}
+
+func TestHistogramWithoutSeveritySplit(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, `{"aggregations":{"volume":{"buckets":[{"key":1696161600000,"doc_count":4}]}}}`
+	})
+
+	buckets, err := p.Histogram(context.Background(), schema.LogQuery{}, 0)
+	if err != nil {
+		t.Fatalf("histogram failed: %v", err)
+	}
+	if len(buckets) != 1 || buckets[0].Count != 4 || buckets[0].Severities != nil {
+		t.Errorf("buckets = %+v, want one bucket of 4 without severities", buckets)
+	}
+	body := transport.recorded()[0].Body
+	if strings.Contains(body, `"severity"`) || strings.Contains(body, "extended_bounds") {
+		t.Errorf("body = %s, want no severity split or bounds", body)
+	}
+	if !strings.Contains(body, `"fixed_interval":"60000ms"`) {
+		t.Errorf("body = %s, want the default interval for an open window", body)
+	}
+}
+
+func TestHistogramErrors(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 400, `{"error":{"type":"illegal_argument_exception","reason":"too many buckets"}}`
+	})
+
+	if _, err := p.Histogram(context.Background(), schema.LogQuery{}, -time.Second); err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Errorf("err = %v, want a negative interval error", err)
+	}
+	if _, err := p.Histogram(context.Background(), schema.LogQuery{}, time.Second); err == nil || !strings.Contains(err.Error(), "too many buckets") {
+		t.Errorf("err = %v, want the elasticsearch error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package log
+
+import "sort"
+
+// defaultIdentityFields lists, per canonical identity label, the fields where
+// common shippers put that identity, in precedence order:
+//   - Filebeat / Elastic Agent (ECS): host.name, kubernetes.pod.name, ...
+//   - Fluent Bit kubernetes filter: hostname, kubernetes.pod_name, ...
+//   - OpenTelemetry collector: k8s.pod.name, resource.attributes.k8s.pod.name, ...
+var defaultIdentityFields = map[string][]string{
+	"host": {
+		"host.name", "host.hostname", "agent.hostname", "hostname",
+		"resource.attributes.host.name",
+	},
+	"pod": {
+		"kubernetes.pod.name", "kubernetes.pod_name", "k8s.pod.name",
+		"resource.attributes.k8s.pod.name",
+	},
+	"namespace": {
+		"kubernetes.namespace", "kubernetes.namespace_name", "k8s.namespace.name",
+		"resource.attributes.k8s.namespace.name",
+	},
+	"container": {
+		"container.name", "kubernetes.container.name", "kubernetes.container_name", "k8s.container.name",
+		"resource.attributes.k8s.container.name",
+	},
+	"node": {
+		"kubernetes.node.name", "kubernetes.host", "k8s.node.name",
+		"resource.attributes.k8s.node.name",
+	},
+}
+
+// identityFields returns the canonical identity labels and their candidate
+// fields; configured identityFields replace the defaults per label.
+func (p *ElasticProvider) identityFields() map[string][]string {
+	if len(p.cfg.IdentityFields) == 0 {
+		return defaultIdentityFields
+	}
+	merged := make(map[string][]string, len(defaultIdentityFields)+len(p.cfg.IdentityFields))
+	for label, fields := range defaultIdentityFields {
+		merged[label] = fields
+	}
+	for label, fields := range p.cfg.IdentityFields {
+		merged[label] = fields
+	}
+	return merged
+}
+
+// addIdentityLabels sets each canonical identity label from the first
+// candidate field present in the flattened source. The original fields are
+// left untouched.
+func (p *ElasticProvider) addIdentityLabels(labels map[string]string, source map[string]any) {
+	identities := p.identityFields()
+	names := make([]string, 0, len(identities))
+	for label := range identities {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	for _, label := range names {
+		if value := firstString(source, identities[label], nil); value != "" {
+			labels[label] = value
+		}
+	}
+}
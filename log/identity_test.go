@@ -0,0 +1,94 @@
+package log
+
+import "testing"
+
+func TestNormalizeHitIdentityLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		source map[string]interface{}
+		want   map[string]string
+	}{
+		{
+			name: "filebeat",
+			source: map[string]interface{}{
+				"host": map[string]interface{}{"name": "node-a"},
+				"kubernetes": map[string]interface{}{
+					"pod":       map[string]interface{}{"name": "api-7f9c"},
+					"namespace": "payments",
+					"container": map[string]interface{}{"name": "api"},
+					"node":      map[string]interface{}{"name": "ip-10-0-0-1"},
+				},
+			},
+			want: map[string]string{"host": "node-a", "pod": "api-7f9c", "namespace": "payments", "container": "api", "node": "ip-10-0-0-1"},
+		},
+		{
+			name: "fluent bit",
+			source: map[string]interface{}{
+				"hostname": "node-b",
+				"kubernetes": map[string]interface{}{
+					"pod_name":       "worker-1",
+					"namespace_name": "jobs",
+					"container_name": "worker",
+					"host":           "ip-10-0-0-2",
+				},
+			},
+			want: map[string]string{"host": "node-b", "pod": "worker-1", "namespace": "jobs", "container": "worker", "node": "ip-10-0-0-2"},
+		},
+		{
+			name: "otel collector",
+			source: map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": map[string]interface{}{
+						"host.name":          "node-c",
+						"k8s.pod.name":       "web-2",
+						"k8s.namespace.name": "frontend",
+						"k8s.container.name": "web",
+						"k8s.node.name":      "ip-10-0-0-3",
+					},
+				},
+			},
+			want: map[string]string{"host": "node-c", "pod": "web-2", "namespace": "frontend", "container": "web", "node": "ip-10-0-0-3"},
+		},
+		{
+			name:   "agent hostname fallback",
+			source: map[string]interface{}{"agent": map[string]interface{}{"hostname": "legacy-host"}},
+			want:   map[string]string{"host": "legacy-host"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := normalizeHit(&ElasticProvider{cfg: Config{LabelFields: []string{}}}, esHit{Source: tt.source})
+			if len(entry.Labels) != len(tt.want) {
+				t.Errorf("labels = %v, want %v", entry.Labels, tt.want)
+			}
+			for label, want := range tt.want {
+				if entry.Labels[label] != want {
+					t.Errorf("labels[%s] = %q, want %q", label, entry.Labels[label], want)
+				}
+			}
+			if len(entry.Fields) == 0 {
+				t.Error("original identity fields should remain in Fields")
+			}
+		})
+	}
+}
+
+func TestIdentityFieldsOverride(t *testing.T) {
+	p := &ElasticProvider{cfg: parseConfig(map[string]any{
+		"labelFields":    []any{},
+		"identityFields": map[string]any{"host": "origin.host"},
+	})}
+	entry := normalizeHit(p, esHit{Source: map[string]interface{}{
+		"origin":   map[string]interface{}{"host": "custom"},
+		"hostname": "default-candidate",
+		"k8s":      map[string]interface{}{"pod": map[string]interface{}{"name": "still-default"}},
+	}})
+
+	if entry.Labels["host"] != "custom" {
+		t.Errorf("labels[host] = %q, want custom", entry.Labels["host"])
+	}
+	if entry.Labels["pod"] != "still-default" {
+		t.Errorf("labels[pod] = %q, want defaults kept for labels not overridden", entry.Labels["pod"])
+	}
+}
@@ -0,0 +1,165 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// tierPreferenceSetting names the index setting that records its data tier.
+const tierPreferenceSetting = "index.routing.allocation.include._tier_preference"
+
+const (
+	// maxTierCacheSize bounds the index tiers cached. Once reached the
+	// cache starts over, as indices rolled over are no longer searched.
+	maxTierCacheSize = 1000
+	// tierRetryDelay is how long index tiers are not looked up after a
+	// lookup failed.
+	tierRetryDelay = time.Minute
+)
+
+// parseBackingIndex extracts the data stream name and generation from a
+// backing index name such as ".ds-logs-app-default-2023.10.01-000042" (8.x)
+// or ".ds-logs-app-default-000042" (7.x). Classic index names return ok=false.
+func parseBackingIndex(index string) (dataStream string, generation int, ok bool) {
+	rest, found := strings.CutPrefix(index, ".ds-")
+	if !found {
+		return "", 0, false
+	}
+
+	cut := strings.LastIndexByte(rest, '-')
+	if cut <= 0 {
+		return "", 0, false
+	}
+	generation, err := strconv.Atoi(rest[cut+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	rest = rest[:cut]
+
+	// Drop the creation date inserted by 8.x, if present
+	if cut := strings.LastIndexByte(rest, '-'); cut > 0 && isBackingIndexDate(rest[cut+1:]) {
+		rest = rest[:cut]
+	}
+	return rest, generation, true
+}
+
+// isBackingIndexDate reports whether s has the yyyy.MM.dd shape used in
+// backing index names.
+func isBackingIndexDate(s string) bool {
+	if len(s) != len("2006.01.02") || s[4] != '.' || s[7] != '.' {
+		return false
+	}
+	for i, c := range s {
+		if i != 4 && i != 7 && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// tierFromPreference returns the preferred tier ("hot", "warm", "cold",
+// "frozen", "content") from a _tier_preference value like "data_warm,data_hot".
+func tierFromPreference(preference string) string {
+	first, _, _ := strings.Cut(preference, ",")
+	return strings.TrimPrefix(strings.TrimSpace(first), "data_")
+}
+
+// annotateIndexTiers sets MetadataIndexTier on each entry from the index of
+// the hit at the same position, looking up every index not yet cached with a
+// single _settings request, sent without holding tierMu. Lookup failures
+// leave entries unannotated rather than failing the query, are logged, and
+// hold off further lookups for tierRetryDelay.
+func (p *ElasticProvider) annotateIndexTiers(ctx context.Context, hits []esHit, entries []schema.LogEntry) {
+	if indices := p.uncachedTiers(hits); len(indices) > 0 {
+		tiers, err := p.fetchIndexTiers(ctx, indices)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			p.tierMu.Lock()
+			p.tierFailed = time.Now()
+			p.tierMu.Unlock()
+			if l := p.logger.Load(); l != nil {
+				l.Warn("failed to look up index tiers", "error", err.Error(), "retryAfter", tierRetryDelay.String(), "traceId", TraceID(ctx))
+			}
+		case err == nil:
+			p.tierMu.Lock()
+			if p.tierCache == nil || len(p.tierCache)+len(indices) > maxTierCacheSize {
+				p.tierCache = make(map[string]string)
+			}
+			for _, index := range indices {
+				// Cache misses too, so indices without a tier are not re-fetched
+				p.tierCache[index] = tiers[index]
+			}
+			p.tierMu.Unlock()
+		}
+	}
+
+	p.tierMu.Lock()
+	defer p.tierMu.Unlock()
+	for i, hit := range hits {
+		if tier := p.tierCache[hit.Index]; tier != "" && i < len(entries) {
+			entries[i].Metadata[MetadataIndexTier] = tier
+		}
+	}
+}
+
+// uncachedTiers returns the indices of hits whose tier is not cached, in
+// order, or none while lookups are held off after a failure.
+func (p *ElasticProvider) uncachedTiers(hits []esHit) []string {
+	p.tierMu.Lock()
+	defer p.tierMu.Unlock()
+	if !p.tierFailed.IsZero() && time.Since(p.tierFailed) < tierRetryDelay {
+		return nil
+	}
+	missing := map[string]bool{}
+	for _, hit := range hits {
+		if _, cached := p.tierCache[hit.Index]; !cached && hit.Index != "" {
+			missing[hit.Index] = true
+		}
+	}
+	indices := make([]string, 0, len(missing))
+	for index := range missing {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices
+}
+
+// fetchIndexTiers reads the tier preference of the given indices.
+func (p *ElasticProvider) fetchIndexTiers(ctx context.Context, indices []string) (map[string]string, error) {
+	res, err := p.client.Indices.GetSettings(
+		p.client.Indices.GetSettings.WithContext(ctx),
+		p.client.Indices.GetSettings.WithIndex(indices...),
+		p.client.Indices.GetSettings.WithName(tierPreferenceSetting),
+		p.client.Indices.GetSettings.WithFlatSettings(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("index settings request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var settings map[string]struct {
+		Settings map[string]string `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&settings); err != nil {
+		return nil, fmt.Errorf("failed to parse index settings: %w", err)
+	}
+
+	tiers := make(map[string]string, len(settings))
+	for index, s := range settings {
+		if preference := s.Settings[tierPreferenceSetting]; preference != "" {
+			tiers[index] = tierFromPreference(preference)
+		}
+	}
+	return tiers, nil
+}
@@ -0,0 +1,160 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestParseBackingIndex(t *testing.T) {
+	tests := []struct {
+		index      string
+		dataStream string
+		generation int
+		ok         bool
+	}{
+		{index: ".ds-logs-app-default-2023.10.01-000042", dataStream: "logs-app-default", generation: 42, ok: true},
+		{index: ".ds-logs-app-default-000007", dataStream: "logs-app-default", generation: 7, ok: true},
+		{index: ".ds-logs-2023.10.01-000001", dataStream: "logs", generation: 1, ok: true},
+		{index: "logs-2023.10.01", ok: false},
+		{index: "logs-app-000042", ok: false},
+		{index: ".ds-logs-app-default", ok: false},
+		{index: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.index, func(t *testing.T) {
+			dataStream, generation, ok := parseBackingIndex(tt.index)
+			if ok != tt.ok || dataStream != tt.dataStream || generation != tt.generation {
+				t.Errorf("parseBackingIndex(%q) = %q, %d, %v; want %q, %d, %v",
+					tt.index, dataStream, generation, ok, tt.dataStream, tt.generation, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNormalizeHitDataStreamMetadata(t *testing.T) {
+	entry := normalizeHit(&ElasticProvider{}, esHit{Index: ".ds-logs-app-default-2023.10.01-000042", Source: map[string]interface{}{}})
+	if entry.Metadata[MetadataDataStream] != "logs-app-default" {
+		t.Errorf("metadata[data_stream] = %v, want logs-app-default", entry.Metadata[MetadataDataStream])
+	}
+	if entry.Metadata[MetadataBackingIndexGeneration] != 42 {
+		t.Errorf("metadata[backing_index_generation] = %v, want 42", entry.Metadata[MetadataBackingIndexGeneration])
+	}
+
+	classic := normalizeHit(&ElasticProvider{}, esHit{Index: "logs-2023.10.01", Source: map[string]interface{}{}})
+	if _, ok := classic.Metadata[MetadataDataStream]; ok {
+		t.Error("classic indices should not carry data_stream metadata")
+	}
+}
+
+func TestTierFromPreference(t *testing.T) {
+	tests := map[string]string{
+		"data_hot":                  "hot",
+		"data_warm,data_hot":        "warm",
+		"data_frozen":               "frozen",
+		" data_content , data_hot ": "content",
+	}
+	for preference, want := range tests {
+		if got := tierFromPreference(preference); got != want {
+			t.Errorf("tierFromPreference(%q) = %q, want %q", preference, got, want)
+		}
+	}
+}
+
+func TestAnnotateIndexTiersCachesLookups(t *testing.T) {
+	p, transport := newTestProvider(t, Config{ResolveIndexTier: true}, func(req recordedRequest) (int, string) {
+		return 200, `{
+			".ds-logs-2023.10.01-000001": {"settings": {"index.routing.allocation.include._tier_preference": "data_warm,data_hot"}},
+			".ds-logs-2023.10.02-000002": {"settings": {"index.routing.allocation.include._tier_preference": "data_hot"}}
+		}`
+	})
+
+	hits := []esHit{
+		{Index: ".ds-logs-2023.10.01-000001"},
+		{Index: ".ds-logs-2023.10.02-000002"},
+		{Index: ".ds-logs-2023.10.01-000001"},
+	}
+	entries := func() []schema.LogEntry {
+		return []schema.LogEntry{{Metadata: map[string]any{}}, {Metadata: map[string]any{}}, {Metadata: map[string]any{}}}
+	}
+
+	first := entries()
+	p.annotateIndexTiers(context.Background(), hits, first)
+	if first[0].Metadata[MetadataIndexTier] != "warm" || first[2].Metadata[MetadataIndexTier] != "warm" {
+		t.Errorf("tiers = %v, %v; want warm", first[0].Metadata[MetadataIndexTier], first[2].Metadata[MetadataIndexTier])
+	}
+	if first[1].Metadata[MetadataIndexTier] != "hot" {
+		t.Errorf("tier = %v, want hot", first[1].Metadata[MetadataIndexTier])
+	}
+
+	second := entries()
+	p.annotateIndexTiers(context.Background(), hits, second)
+	if second[1].Metadata[MetadataIndexTier] != "hot" {
+		t.Errorf("cached tier = %v, want hot", second[1].Metadata[MetadataIndexTier])
+	}
+
+	requests := transport.recorded()
+	if len(requests) != 1 {
+		t.Fatalf("settings requests = %d, want 1 for two queries", len(requests))
+	}
+	if !strings.Contains(requests[0].Path, ".ds-logs-2023.10.01-000001,.ds-logs-2023.10.02-000002") {
+		t.Errorf("path = %s, want both distinct indices in one request", requests[0].Path)
+	}
+	if !strings.HasSuffix(requests[0].Path, "/_settings/"+tierPreferenceSetting) {
+		t.Errorf("path = %s, want the tier preference setting", requests[0].Path)
+	}
+}
+
+func TestAnnotateIndexTiersHoldsOffAfterFailure(t *testing.T) {
+	failing := true
+	p, transport := newTestProvider(t, Config{ResolveIndexTier: true}, func(req recordedRequest) (int, string) {
+		if failing {
+			return 500, `{"error":{"type":"exception","reason":"boom"},"status":500}`
+		}
+		return 200, `{"logs-1": {"settings": {"index.routing.allocation.include._tier_preference": "data_hot"}}}`
+	})
+	hits := []esHit{{Index: "logs-1"}}
+
+	for range 3 {
+		entries := []schema.LogEntry{{Metadata: map[string]any{}}}
+		p.annotateIndexTiers(context.Background(), hits, entries)
+		if _, ok := entries[0].Metadata[MetadataIndexTier]; ok {
+			t.Errorf("metadata = %v, want no tier after a failed lookup", entries[0].Metadata)
+		}
+	}
+	if n := len(transport.recorded()); n != 1 {
+		t.Errorf("settings requests = %d, want 1 until the retry delay passes", n)
+	}
+
+	failing = false
+	p.tierFailed = time.Now().Add(-tierRetryDelay)
+	entries := []schema.LogEntry{{Metadata: map[string]any{}}}
+	p.annotateIndexTiers(context.Background(), hits, entries)
+	if entries[0].Metadata[MetadataIndexTier] != "hot" {
+		t.Errorf("tier = %v, want hot once looked up again", entries[0].Metadata[MetadataIndexTier])
+	}
+}
+
+func TestAnnotateIndexTiersCacheBounded(t *testing.T) {
+	p, _ := newTestProvider(t, Config{ResolveIndexTier: true}, func(req recordedRequest) (int, string) {
+		return 200, `{"logs-new": {"settings": {"index.routing.allocation.include._tier_preference": "data_hot"}}}`
+	})
+	p.tierCache = make(map[string]string, maxTierCacheSize)
+	for i := range maxTierCacheSize {
+		p.tierCache[fmt.Sprintf("logs-%d", i)] = "warm"
+	}
+
+	entries := []schema.LogEntry{{Metadata: map[string]any{}}}
+	p.annotateIndexTiers(context.Background(), []esHit{{Index: "logs-new"}}, entries)
+	if entries[0].Metadata[MetadataIndexTier] != "hot" {
+		t.Errorf("tier = %v, want hot", entries[0].Metadata[MetadataIndexTier])
+	}
+	if len(p.tierCache) != 1 {
+		t.Errorf("cached tiers = %d, want the full cache started over", len(p.tierCache))
+	}
+}
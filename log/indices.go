@@ -0,0 +1,147 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// IndexInfo describes a concrete index matched by an index pattern.
+type IndexInfo struct {
+	Name string `json:"name"`
+	// DataStream is set for data stream backing indices.
+	DataStream     string    `json:"dataStream,omitempty"`
+	Health         string    `json:"health"`
+	Status         string    `json:"status"`
+	DocsCount      int64     `json:"docsCount"`
+	StoreSizeBytes int64     `json:"storeSizeBytes"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// ListIndices returns the indices matching pattern, newest first, using the
+// _cat/indices API. An empty pattern means the configured index pattern.
+// Indices outside AllowedIndexPatterns are left out.
+func (p *ElasticProvider) ListIndices(ctx context.Context, pattern string) ([]IndexInfo, error) {
+	ctx = withAudit(ctx, "log.indices", schema.QueryScope{})
+	if pattern == "" {
+		pattern = p.cfg.IndexPattern
+	}
+
+	res, err := p.client.Cat.Indices(
+		p.client.Cat.Indices.WithContext(ctx),
+		p.client.Cat.Indices.WithIndex(pattern),
+		p.client.Cat.Indices.WithFormat("json"),
+		p.client.Cat.Indices.WithBytes("b"),
+		p.client.Cat.Indices.WithH("index", "health", "status", "docs.count", "store.size", "creation.date"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch cat indices failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	// A pattern matching nothing is an empty list, not an error
+	if res.StatusCode == 404 {
+		return []IndexInfo{}, nil
+	}
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var rows []map[string]*string
+	if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	indices := make([]IndexInfo, 0, len(rows))
+	for _, row := range rows {
+		info := IndexInfo{
+			Name:           catValue(row, "index"),
+			Health:         catValue(row, "health"),
+			Status:         catValue(row, "status"),
+			DocsCount:      catInt(row, "docs.count"),
+			StoreSizeBytes: catInt(row, "store.size"),
+		}
+		if millis := catInt(row, "creation.date"); millis > 0 {
+			info.CreatedAt = time.UnixMilli(millis).UTC()
+		}
+		if dataStream, _, ok := parseBackingIndex(info.Name); ok {
+			info.DataStream = dataStream
+		}
+		if p.indexAllowed(info) {
+			indices = append(indices, info)
+		}
+	}
+
+	sort.SliceStable(indices, func(i, j int) bool {
+		if !indices[i].CreatedAt.Equal(indices[j].CreatedAt) {
+			return indices[i].CreatedAt.After(indices[j].CreatedAt)
+		}
+		return indices[i].Name < indices[j].Name
+	})
+	return indices, nil
+}
+
+// catValue returns a _cat column; closed indices report null for most.
+func catValue(row map[string]*string, column string) string {
+	if v := row[column]; v != nil {
+		return *v
+	}
+	return ""
+}
+
+func catInt(row map[string]*string, column string) int64 {
+	n, _ := strconv.ParseInt(catValue(row, column), 10, 64)
+	return n
+}
+
+func (p *ElasticProvider) allowedIndexPatterns() []string {
+	if len(p.cfg.AllowedIndexPatterns) > 0 {
+		return p.cfg.AllowedIndexPatterns
+	}
+	return []string{p.cfg.IndexPattern}
+}
+
+// indexAllowed reports whether an index, or the data stream it backs,
+// matches the allowed index patterns and none of their exclusions.
+func (p *ElasticProvider) indexAllowed(info IndexInfo) bool {
+	return patternsAllow(p.allowedIndexPatterns(), info)
+}
+
+// patternsAllow reports whether an index, or the data stream it backs,
+// matches patterns and none of their exclusions.
+func patternsAllow(list []string, info IndexInfo) bool {
+	allowed := false
+	for _, patterns := range list {
+		for _, pattern := range strings.Split(patterns, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if exclude, ok := strings.CutPrefix(pattern, "-"); ok {
+				if indexMatches(exclude, info) {
+					return false
+				}
+				continue
+			}
+			if indexMatches(pattern, info) {
+				allowed = true
+			}
+		}
+	}
+	return allowed
+}
+
+func indexMatches(pattern string, info IndexInfo) bool {
+	if ok, _ := path.Match(pattern, info.Name); ok {
+		return true
+	}
+	if info.DataStream == "" {
+		return false
+	}
+	ok, _ := path.Match(pattern, info.DataStream)
+	return ok
+}
@@ -0,0 +1,99 @@
+package log
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const catIndicesResponse = `[
+	{"index":"logs-app-2023.10.01","health":"green","status":"open","docs.count":"1200","store.size":"734003","creation.date":"1696118400000"},
+	{"index":".ds-logs-edge-default-2023.10.02-000002","health":"yellow","status":"open","docs.count":"88","store.size":"40960","creation.date":"1696204800000"},
+	{"index":"logs-app-2023.09.30","health":null,"status":"close","docs.count":null,"store.size":null,"creation.date":"1696032000000"},
+	{"index":"logs-audit-2023.10.02","health":"green","status":"open","docs.count":"5","store.size":"2048","creation.date":"1696204800001"}
+]`
+
+func TestListIndices(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, catIndicesResponse
+	})
+
+	indices, err := p.ListIndices(context.Background(), "")
+	if err != nil {
+		t.Fatalf("list indices failed: %v", err)
+	}
+
+	names := make([]string, 0, len(indices))
+	for _, index := range indices {
+		names = append(names, index.Name)
+	}
+	want := "logs-audit-2023.10.02,.ds-logs-edge-default-2023.10.02-000002,logs-app-2023.10.01,logs-app-2023.09.30"
+	if got := strings.Join(names, ","); got != want {
+		t.Fatalf("indices = %s, want newest first %s", got, want)
+	}
+
+	app := indices[2]
+	if app.Health != "green" || app.Status != "open" || app.DocsCount != 1200 || app.StoreSizeBytes != 734003 {
+		t.Errorf("app index = %+v, want health, status, docs and size", app)
+	}
+	if !app.CreatedAt.Equal(time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("created = %s, want 2023-10-01", app.CreatedAt)
+	}
+	if indices[1].DataStream != "logs-edge-default" {
+		t.Errorf("data stream = %q, want logs-edge-default", indices[1].DataStream)
+	}
+	if closed := indices[3]; closed.Status != "close" || closed.Health != "" || closed.DocsCount != 0 {
+		t.Errorf("closed index = %+v, want status close and no stats", closed)
+	}
+
+	req := transport.recorded()[0]
+	if req.Path != "/_cat/indices/logs-*" || req.Query.Get("format") != "json" || req.Query.Get("bytes") != "b" {
+		t.Errorf("request = %s?%s, want JSON _cat/indices in bytes", req.Path, req.Query.Encode())
+	}
+}
+
+func TestListIndicesAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		want    string
+	}{
+		{name: "default index pattern", want: "logs-audit-2023.10.02,.ds-logs-edge-default-2023.10.02-000002,logs-app-2023.10.01,logs-app-2023.09.30"},
+		{name: "one family", allowed: []string{"logs-app-*"}, want: "logs-app-2023.10.01,logs-app-2023.09.30"},
+		{name: "data stream", allowed: []string{"logs-edge-*"}, want: ".ds-logs-edge-default-2023.10.02-000002"},
+		{name: "exclusion", allowed: []string{"logs-*,-logs-audit-*"}, want: ".ds-logs-edge-default-2023.10.02-000002,logs-app-2023.10.01,logs-app-2023.09.30"},
+		{name: "nothing allowed", allowed: []string{"metrics-*"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newTestProvider(t, Config{AllowedIndexPatterns: tt.allowed}, func(req recordedRequest) (int, string) {
+				return 200, catIndicesResponse
+			})
+
+			indices, err := p.ListIndices(context.Background(), "*")
+			if err != nil {
+				t.Fatalf("list indices failed: %v", err)
+			}
+			names := make([]string, 0, len(indices))
+			for _, index := range indices {
+				names = append(names, index.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("indices = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListIndicesNoMatch(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 404, `{"error":{"type":"index_not_found_exception","reason":"no such index [nope]"},"status":404}`
+	})
+
+	indices, err := p.ListIndices(context.Background(), "nope")
+	if err != nil || indices == nil || len(indices) != 0 {
+		t.Errorf("indices = %v, err = %v; want an empty list", indices, err)
+	}
+}
@@ -0,0 +1,161 @@
+package log
+
+import (
+	"errors"
+	"time"
+)
+
+// Instrumentation receives what happens inside the provider, for programs
+// embedding it to export as metrics. Its methods are called on the
+// request's goroutine, so they must be quick and safe for concurrent use.
+// Embed NopInstrumentation to implement only some of them.
+type Instrumentation interface {
+	// ObserveQuery is called when a QueryWithStats or Query call returns,
+	// with the entries it returned and its error.
+	ObserveQuery(took time.Duration, hits int, err error)
+	// ObserveRetry is called before a failed search is sent again as the
+	// attempt-th try, with the error that failed the previous one.
+	ObserveRetry(attempt int, err error)
+	// ObserveCacheHit and ObserveCacheMiss are called for each query the
+	// result cache is consulted for, as it answers it or not.
+	ObserveCacheHit()
+	ObserveCacheMiss()
+}
+
+// NopInstrumentation ignores everything.
+type NopInstrumentation struct{}
+
+func (NopInstrumentation) ObserveQuery(time.Duration, int, error) {}
+func (NopInstrumentation) ObserveRetry(int, error)                {}
+func (NopInstrumentation) ObserveCacheHit()                       {}
+func (NopInstrumentation) ObserveCacheMiss()                      {}
+
+// Option configures a provider built by NewFromConfig.
+type Option func(*options)
+
+type options struct {
+	instrumentation Instrumentation
+	decoders        map[string]Decoder
+}
+
+// WithInstrumentation reports the provider's queries, retries and cache
+// lookups to i.
+func WithInstrumentation(i Instrumentation) Option {
+	return func(o *options) { o.instrumentation = i }
+}
+
+// instrument returns the provider's instrumentation, which ignores
+// everything unless WithInstrumentation set one.
+func (p *ElasticProvider) instrument() Instrumentation {
+	if p.instrumentation == nil {
+		return NopInstrumentation{}
+	}
+	return p.instrumentation
+}
+
+// errorLabel names err's category for metric labels: "ok" without an
+// error, "other" for errors in none.
+func errorLabel(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	for _, c := range errorLabels {
+		if errors.Is(err, c.category) {
+			return c.label
+		}
+	}
+	return "other"
+}
+
+var errorLabels = []struct {
+	category error
+	label    string
+}{
+	{ErrInvalidQuery, "invalid_query"},
+	{ErrConnection, "connection"},
+	{ErrAuth, "auth"},
+	{ErrTimeout, "timeout"},
+	{ErrCancelled, "cancelled"},
+	{ErrTooLarge, "too_large"},
+	{ErrUnsupported, "unsupported"},
+	{ErrRateLimited, "rate_limited"},
+}
+
+// CounterVec and HistogramVec are the parts of Prometheus's CounterVec and
+// HistogramVec the provider's metrics use, taking label values in the
+// order their labels were declared.
+type CounterVec interface {
+	Add(value float64, labelValues ...string)
+}
+
+type HistogramVec interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// MetricsRegisterer creates and registers labelled metrics. A few lines
+// wrapping a prometheus.Registerer implement it, which keeps Prometheus
+// out of the provider's dependencies.
+type MetricsRegisterer interface {
+	NewCounterVec(name, help string, labels ...string) (CounterVec, error)
+	NewHistogramVec(name, help string, buckets []float64, labels ...string) (HistogramVec, error)
+}
+
+// queryDurationBuckets are the upper bounds, in seconds, of the query
+// duration histogram.
+var queryDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metricsInstrumentation records into metrics created by a
+// MetricsRegisterer.
+type metricsInstrumentation struct {
+	queries  CounterVec
+	duration HistogramVec
+	hits     CounterVec
+	retries  CounterVec
+	cache    CounterVec
+}
+
+// NewMetricsInstrumentation registers the provider's metrics with reg and
+// returns the Instrumentation recording them:
+//
+//   - opsorch_elastic_queries_total{outcome}: queries by "ok" or error
+//     category
+//   - opsorch_elastic_query_duration_seconds{outcome}: their latency
+//   - opsorch_elastic_query_hits_total: entries returned
+//   - opsorch_elastic_retries_total{reason}: searches sent again, by the
+//     error category that failed them
+//   - opsorch_elastic_query_cache_total{result}: result cache "hit"s and
+//     "miss"es
+func NewMetricsInstrumentation(reg MetricsRegisterer) (Instrumentation, error) {
+	var m metricsInstrumentation
+	var err error
+	if m.queries, err = reg.NewCounterVec("opsorch_elastic_queries_total", "Log queries served, by outcome.", "outcome"); err != nil {
+		return nil, err
+	}
+	if m.duration, err = reg.NewHistogramVec("opsorch_elastic_query_duration_seconds", "Log query latency, by outcome.", queryDurationBuckets, "outcome"); err != nil {
+		return nil, err
+	}
+	if m.hits, err = reg.NewCounterVec("opsorch_elastic_query_hits_total", "Log entries returned by queries."); err != nil {
+		return nil, err
+	}
+	if m.retries, err = reg.NewCounterVec("opsorch_elastic_retries_total", "Searches sent again after failing, by the failure's category.", "reason"); err != nil {
+		return nil, err
+	}
+	if m.cache, err = reg.NewCounterVec("opsorch_elastic_query_cache_total", "Query result cache lookups, by result.", "result"); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *metricsInstrumentation) ObserveQuery(took time.Duration, hits int, err error) {
+	outcome := errorLabel(err)
+	m.queries.Add(1, outcome)
+	m.duration.Observe(took.Seconds(), outcome)
+	m.hits.Add(float64(hits))
+}
+
+func (m *metricsInstrumentation) ObserveRetry(attempt int, err error) {
+	m.retries.Add(1, errorLabel(err))
+}
+
+func (m *metricsInstrumentation) ObserveCacheHit()  { m.cache.Add(1, "hit") }
+func (m *metricsInstrumentation) ObserveCacheMiss() { m.cache.Add(1, "miss") }
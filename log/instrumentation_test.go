@@ -0,0 +1,186 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// recordingInstrumentation records the calls it receives.
+type recordingInstrumentation struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recordingInstrumentation) record(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingInstrumentation) ObserveQuery(took time.Duration, hits int, err error) {
+	r.record("query hits=%d outcome=%s", hits, errorLabel(err))
+}
+
+func (r *recordingInstrumentation) ObserveRetry(attempt int, err error) {
+	r.record("retry attempt=%d reason=%s", attempt, errorLabel(err))
+}
+
+func (r *recordingInstrumentation) ObserveCacheHit()  { r.record("cache hit") }
+func (r *recordingInstrumentation) ObserveCacheMiss() { r.record("cache miss") }
+
+func (r *recordingInstrumentation) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+func TestInstrumentationQueries(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		statuses []int
+		want     []string
+	}{
+		{
+			name: "success",
+			want: []string{"query hits=2 outcome=ok"},
+		},
+		{
+			name:     "error",
+			statuses: []int{400},
+			want:     []string{"query hits=0 outcome=invalid_query"},
+		},
+		{
+			name:     "retried",
+			cfg:      Config{RetryAttempts: 3},
+			statuses: []int{429, 429},
+			want:     []string{"retry attempt=2 reason=connection", "retry attempt=3 reason=connection", "query hits=2 outcome=ok"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := tt.statuses
+			p, _ := newTestProvider(t, tt.cfg, func(req recordedRequest) (int, string) {
+				if len(statuses) > 0 {
+					status := statuses[0]
+					statuses = statuses[1:]
+					return status, fmt.Sprintf(`{"error":{"type":"some_exception","reason":"failed"},"status":%d}`, status)
+				}
+				return 200, `{"hits":{"total":{"value":2},"hits":[{"_id":"1","_source":{}},{"_id":"2","_source":{}}]}}`
+			})
+			recordWaits(p)
+			recorder := &recordingInstrumentation{}
+			p.instrumentation = recorder
+
+			_, _ = p.Query(context.Background(), schema.LogQuery{Limit: 10})
+			if got := recorder.recorded(); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("calls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstrumentationCache(t *testing.T) {
+	p, _ := newTestProvider(t, Config{QueryCacheSize: 10}, func(req recordedRequest) (int, string) {
+		return 200, `{"hits":{"total":{"value":1},"hits":[{"_id":"1","_source":{}}]}}`
+	})
+	recorder := &recordingInstrumentation{}
+	p.instrumentation = recorder
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Query(context.Background(), schema.LogQuery{Limit: 10}); err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	}
+	want := []string{"cache miss", "query hits=1 outcome=ok", "cache hit", "query hits=1 outcome=ok"}
+	if got := recorder.recorded(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestNopInstrumentationDefault(t *testing.T) {
+	if _, ok := (&ElasticProvider{}).instrument().(NopInstrumentation); !ok {
+		t.Error("instrumentation without WithInstrumentation is not a no-op")
+	}
+	var o options
+	WithInstrumentation(&recordingInstrumentation{})(&o)
+	if _, ok := o.instrumentation.(*recordingInstrumentation); !ok {
+		t.Errorf("instrumentation = %T, want the one passed", o.instrumentation)
+	}
+}
+
+// fakeRegisterer keeps metric values by name and label values.
+type fakeRegisterer struct {
+	mu     sync.Mutex
+	values map[string]float64
+	fail   string
+}
+
+type fakeVec struct {
+	reg  *fakeRegisterer
+	name string
+}
+
+func (v fakeVec) Add(value float64, labelValues ...string) {
+	v.reg.mu.Lock()
+	defer v.reg.mu.Unlock()
+	v.reg.values[fmt.Sprint(v.name, labelValues)] += value
+}
+
+func (v fakeVec) Observe(value float64, labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+func (r *fakeRegisterer) NewCounterVec(name, help string, labels ...string) (CounterVec, error) {
+	if name == r.fail {
+		return nil, errors.New("duplicate metrics collector registration attempted")
+	}
+	return fakeVec{reg: r, name: name}, nil
+}
+
+func (r *fakeRegisterer) NewHistogramVec(name, help string, buckets []float64, labels ...string) (HistogramVec, error) {
+	if !sort.Float64sAreSorted(buckets) {
+		return nil, errors.New("buckets not sorted")
+	}
+	return fakeVec{reg: r, name: name}, nil
+}
+
+func TestMetricsInstrumentation(t *testing.T) {
+	reg := &fakeRegisterer{values: map[string]float64{}}
+	m, err := NewMetricsInstrumentation(reg)
+	if err != nil {
+		t.Fatalf("failed to register metrics: %v", err)
+	}
+	m.ObserveQuery(120*time.Millisecond, 40, nil)
+	m.ObserveQuery(time.Second, 0, fmt.Errorf("query failed: %w", &RateLimitedError{RetryAfter: time.Second}))
+	m.ObserveRetry(2, &ResponseError{StatusCode: 429})
+	m.ObserveCacheHit()
+	m.ObserveCacheMiss()
+	m.ObserveCacheMiss()
+
+	want := map[string]float64{
+		"opsorch_elastic_queries_total[ok]":                    1,
+		"opsorch_elastic_queries_total[rate_limited]":          1,
+		"opsorch_elastic_query_duration_seconds[ok]":           1,
+		"opsorch_elastic_query_duration_seconds[rate_limited]": 1,
+		"opsorch_elastic_query_hits_total[]":                   40,
+		"opsorch_elastic_retries_total[connection]":            1,
+		"opsorch_elastic_query_cache_total[hit]":               1,
+		"opsorch_elastic_query_cache_total[miss]":              2,
+	}
+	if fmt.Sprint(reg.values) != fmt.Sprint(want) {
+		t.Errorf("values = %v, want %v", reg.values, want)
+	}
+
+	reg.fail = "opsorch_elastic_retries_total"
+	if _, err := NewMetricsInstrumentation(reg); err == nil {
+		t.Error("registration error was not returned")
+	}
+}
@@ -0,0 +1,31 @@
+package log
+
+import (
+	"log/slog"
+)
+
+// maxRetries is how many times the client retries a request that got no
+// response or a 502, 503 or 504, as it does by default.
+const maxRetries = 3
+
+// SetLogger makes the provider log retried requests and slow queries to l.
+// A nil l stops logging.
+func (p *ElasticProvider) SetLogger(l *slog.Logger) {
+	p.logger.Store(l)
+}
+
+// logRetry logs that a request is about to be retried for the attempt-th
+// time. The client asks once more after its last attempt, which is not
+// logged.
+func (p *ElasticProvider) logRetry(attempt int) {
+	if l := p.logger.Load(); l != nil && attempt <= maxRetries {
+		l.Warn("retrying elasticsearch request", "attempt", attempt, "maxRetries", maxRetries)
+	}
+}
+
+// logSlowQuery logs a recorded slow query, without its DSL.
+func (p *ElasticProvider) logSlowQuery(q SlowQuery) {
+	if l := p.logger.Load(); l != nil {
+		l.Warn("slow query", "tookMs", q.TookMillis, "team", q.Team, "service", q.Service, "index", q.Index, "timedOut", q.TimedOut, "hints", q.Hints, "traceId", q.TraceID)
+	}
+}
@@ -0,0 +1,349 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMeteringInterval is how often usage is flushed to the
+	// metering index.
+	defaultMeteringInterval = time.Minute
+	// meteringFlushTimeout bounds one flush of the metering index.
+	meteringFlushTimeout = 30 * time.Second
+)
+
+// UsageCounters is the log query volume of one team and service.
+type UsageCounters struct {
+	Team    string `json:"team"`
+	Service string `json:"service"`
+	// Queries counts provider calls that sent requests to Elasticsearch.
+	Queries int64 `json:"queries"`
+	// Documents counts the hits and rows returned.
+	Documents int64 `json:"documents"`
+	// Bytes counts the response bytes returned.
+	Bytes int64 `json:"bytes"`
+	// TookMillis sums the took time Elasticsearch reported.
+	TookMillis int64 `json:"tookMs"`
+}
+
+// UsageStats is the usage per scope since Since, sorted by team and
+// service.
+type UsageStats struct {
+	Since  time.Time       `json:"since"`
+	Scopes []UsageCounters `json:"scopes"`
+}
+
+// meterKey identifies the scope usage is counted under.
+type meterKey struct {
+	team    string
+	service string
+}
+
+// meterCall marks whether a provider call has been counted as a query, so
+// that a paged call counts once however many requests it sends.
+type meterCall struct {
+	counted atomic.Bool
+}
+
+// meter accumulates usage per scope. Reads see the usage since the last
+// reset; the metering index receives the usage since the last flush.
+type meter struct {
+	flush bool
+	// stopFlush stops the periodic flushes, and flushDone is closed once
+	// they have stopped; both are nil until they are started.
+	stopFlush context.CancelFunc
+	flushDone chan struct{}
+
+	mu           sync.Mutex
+	since        time.Time
+	counters     map[meterKey]*UsageCounters
+	pendingSince time.Time
+	pending      map[meterKey]*UsageCounters
+}
+
+// newMeter returns the meter configured by cfg, or nil when metering is
+// off. Setting MeteringIndex turns metering on.
+func newMeter(cfg Config) *meter {
+	if !cfg.Metering && cfg.MeteringIndex == "" {
+		return nil
+	}
+	now := time.Now().UTC()
+	return &meter{
+		flush:        cfg.MeteringIndex != "",
+		since:        now,
+		counters:     make(map[meterKey]*UsageCounters),
+		pendingSince: now,
+		pending:      make(map[meterKey]*UsageCounters),
+	}
+}
+
+// record adds the usage of one response.
+func (m *meter) record(key meterKey, usage UsageCounters) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addUsage(m.counters, key, usage)
+	if m.flush {
+		addUsage(m.pending, key, usage)
+	}
+}
+
+func addUsage(counters map[meterKey]*UsageCounters, key meterKey, usage UsageCounters) {
+	c, ok := counters[key]
+	if !ok {
+		c = &UsageCounters{Team: key.team, Service: key.service}
+		counters[key] = c
+	}
+	c.Queries += usage.Queries
+	c.Documents += usage.Documents
+	c.Bytes += usage.Bytes
+	c.TookMillis += usage.TookMillis
+}
+
+// snapshot returns the usage since the last reset, resetting it when asked.
+func (m *meter) snapshot(reset bool) UsageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := UsageStats{Since: m.since, Scopes: sortedUsage(m.counters)}
+	if reset {
+		m.since = time.Now().UTC()
+		m.counters = make(map[meterKey]*UsageCounters)
+	}
+	return stats
+}
+
+// drain returns and clears the usage pending a flush.
+func (m *meter) drain() (time.Time, time.Time, []UsageCounters) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start, end := m.pendingSince, time.Now().UTC()
+	usage := sortedUsage(m.pending)
+	m.pendingSince = end
+	m.pending = make(map[meterKey]*UsageCounters)
+	return start, end, usage
+}
+
+// requeue returns usage that failed to flush, to be sent with the next.
+func (m *meter) requeue(start time.Time, usage []UsageCounters) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pendingSince = start
+	for _, u := range usage {
+		addUsage(m.pending, meterKey{team: u.Team, service: u.Service}, u)
+	}
+}
+
+func sortedUsage(counters map[meterKey]*UsageCounters) []UsageCounters {
+	out := make([]UsageCounters, 0, len(counters))
+	for _, c := range counters {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Team != out[j].Team {
+			return out[i].Team < out[j].Team
+		}
+		return out[i].Service < out[j].Service
+	})
+	return out
+}
+
+// UsageStats returns the log query volume per team and service since the
+// last reset, and resets the counters when reset is set. It needs Metering
+// or MeteringIndex.
+func (p *ElasticProvider) UsageStats(reset bool) (UsageStats, error) {
+	if p.meter == nil {
+		return UsageStats{}, unsupported("metering is disabled; set metering to enable it")
+	}
+	return p.meter.snapshot(reset), nil
+}
+
+// meteringDocument is the usage of one scope over one flush period, as
+// indexed into the metering index.
+type meteringDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Period    struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"period"`
+	UsageCounters
+}
+
+// flushMetering indexes the usage since the last flush, one document per
+// scope. On failure the usage is kept for the next flush.
+func (p *ElasticProvider) flushMetering(ctx context.Context) error {
+	start, end, usage := p.meter.drain()
+	if len(usage) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, u := range usage {
+		doc := meteringDocument{Timestamp: end, UsageCounters: u}
+		doc.Period.Start, doc.Period.End = start, end
+		if err := enc.Encode(map[string]any{"create": map[string]any{}}); err != nil {
+			return fmt.Errorf("failed to marshal usage: %w", err)
+		}
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("failed to marshal usage: %w", err)
+		}
+	}
+
+	err := func() error {
+		res, err := p.client.Bulk(&body,
+			p.client.Bulk.WithContext(ctx),
+			p.client.Bulk.WithIndex(p.cfg.MeteringIndex),
+		)
+		if err != nil {
+			return fmt.Errorf("elasticsearch bulk request failed: %w", err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return responseError(res)
+		}
+		var result struct {
+			Errors bool `json:"errors"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		if result.Errors {
+			return errors.New("elasticsearch refused usage documents")
+		}
+		return nil
+	}()
+	if err != nil {
+		p.meter.requeue(start, usage)
+	}
+	return err
+}
+
+// startMeteringFlush flushes usage every interval until Close stops it.
+// Failures are reported to stderr and retried on the next tick.
+func (p *ElasticProvider) startMeteringFlush(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.meter.stopFlush, p.meter.flushDone = cancel, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			flushCtx, cancel := context.WithTimeout(ctx, meteringFlushTimeout)
+			if err := p.flushMetering(flushCtx); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(stderr, "warning: elastic adapter failed to flush usage: %v\n", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// stopMeteringFlush stops the periodic flushes and waits for a running one
+// to end. Usage it failed to send is kept for the final flush.
+func (m *meter) stopMeteringFlush() {
+	if m.stopFlush != nil {
+		m.stopFlush()
+		<-m.flushDone
+	}
+}
+
+func (p *ElasticProvider) meteringInterval() time.Duration {
+	if p.cfg.MeteringInterval > 0 {
+		return p.cfg.MeteringInterval
+	}
+	return defaultMeteringInterval
+}
+
+// meterTransport counts the requests and response bytes of every request
+// made for a provider call, as the body is read. The documents and took
+// time are counted by meterResult where the provider decodes the response.
+// Requests outside a call, such as the connection check and metering
+// flushes, are not counted.
+type meterTransport struct {
+	next  http.RoundTripper
+	meter *meter
+}
+
+func (t *meterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scope, ok := req.Context().Value(auditKey{}).(auditScope)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	var usage UsageCounters
+	if scope.call != nil && scope.call.counted.CompareAndSwap(false, true) {
+		usage.Queries = 1
+	}
+	res.Body = &meteredBody{ReadCloser: res.Body, meter: t.meter, key: meterKey{team: scope.team, service: scope.service}, usage: usage}
+	return res, nil
+}
+
+// meteredBody counts the bytes read from a response body, and records them
+// once the body is closed.
+type meteredBody struct {
+	io.ReadCloser
+	meter  *meter
+	key    meterKey
+	usage  UsageCounters
+	closed bool
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.usage.Bytes += int64(n)
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.meter.record(b.key, b.usage)
+	}
+	return b.ReadCloser.Close()
+}
+
+// meterResult records the documents and took time of a response decoded
+// for the call ctx belongs to, as the transport only counts its bytes.
+func (p *ElasticProvider) meterResult(ctx context.Context, documents, took int64) {
+	if p.meter == nil {
+		return
+	}
+	scope, ok := ctx.Value(auditKey{}).(auditScope)
+	if !ok {
+		return
+	}
+	p.meter.record(meterKey{team: scope.team, service: scope.service}, UsageCounters{Documents: documents, TookMillis: took})
+}
+
+// meteredResult is a decoded response that reports the documents it
+// returned and its took time, as esSearchResponse and the aggregation
+// responses embedding it do.
+type meteredResult interface {
+	usage() (documents, took int64)
+}
+
+// meterResponse records the usage of a decoded response, when it reports
+// one.
+func (p *ElasticProvider) meterResponse(ctx context.Context, out any) {
+	if r, ok := out.(meteredResult); ok {
+		documents, took := r.usage()
+		p.meterResult(ctx, documents, took)
+	}
+}
@@ -0,0 +1,260 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// newMeteredProvider builds a provider whose requests pass through a
+// meterTransport in front of a fakeTransport.
+func newMeteredProvider(t *testing.T, cfg Config, handler func(req recordedRequest) (int, string)) (*ElasticProvider, *fakeTransport) {
+	t.Helper()
+
+	cfg.IndexPattern = "logs-*"
+	cfg.Metering = true
+	transport := &fakeTransport{handler: handler}
+	m := newMeter(cfg)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{"http://elastic.test:9200"},
+		Transport: &meterTransport{next: transport, meter: m},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return &ElasticProvider{cfg: cfg, client: client, meter: m}, transport
+}
+
+const meteredResponse = `{"took":4,"hits":{"total":{"value":2,"relation":"eq"},"hits":[` +
+	`{"_id":"a","_source":{"message":"a"},"sort":[2,2]},{"_id":"b","_source":{"message":"b"},"sort":[1,1]}]}}`
+
+func TestMeterAccumulatesConcurrentQueries(t *testing.T) {
+	p, _ := newMeteredProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, meteredResponse
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			scope := schema.QueryScope{Team: "payments", Service: "checkout"}
+			if i%4 == 0 {
+				scope = schema.QueryScope{Team: "sre"}
+			}
+			// Distinct searches, so that none is shared between queries
+			query := schema.LogQuery{Scope: scope, Limit: 10, Expression: &schema.LogExpression{Search: fmt.Sprint("query-", i)}}
+			if _, _, err := p.QueryWithStats(context.Background(), query); err != nil {
+				t.Errorf("query failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats, err := p.UsageStats(true)
+	if err != nil {
+		t.Fatalf("UsageStats failed: %v", err)
+	}
+	size := int64(len(meteredResponse))
+	want := []UsageCounters{
+		{Team: "payments", Service: "checkout", Queries: 15, Documents: 30, Bytes: 15 * size, TookMillis: 60},
+		{Team: "sre", Queries: 5, Documents: 10, Bytes: 5 * size, TookMillis: 20},
+	}
+	if fmt.Sprint(stats.Scopes) != fmt.Sprint(want) {
+		t.Errorf("scopes = %+v, want %+v", stats.Scopes, want)
+	}
+
+	// Reading with reset starts the counters over
+	after, _ := p.UsageStats(false)
+	if len(after.Scopes) != 0 || !after.Since.After(stats.Since) {
+		t.Errorf("after reset = %+v, want empty counters since the reset", after)
+	}
+}
+
+func TestMeterCountsPagedCallOnce(t *testing.T) {
+	p, transport := newMeteredProvider(t, Config{PageSize: 2}, func(req recordedRequest) (int, string) {
+		if req.Path == "/" {
+			return 200, `{"version":{"number":"8.11.1"}}`
+		}
+		return 200, meteredResponse
+	})
+	if _, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Scope: schema.QueryScope{Team: "sre"}, Limit: 6}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	stats, _ := p.UsageStats(false)
+	pages := int64(len(searchRequests(transport.recorded())))
+	if pages != 3 {
+		t.Fatalf("searches = %d, want 3 pages", pages)
+	}
+	if len(stats.Scopes) != 1 || stats.Scopes[0].Queries != 1 || stats.Scopes[0].Documents != 6 || stats.Scopes[0].TookMillis != 12 {
+		t.Errorf("scopes = %+v, want one query returning 6 documents", stats.Scopes)
+	}
+}
+
+func TestFlushMetering(t *testing.T) {
+	fail := true
+	p, transport := newMeteredProvider(t, Config{MeteringIndex: "opsorch-usage"}, func(req recordedRequest) (int, string) {
+		if strings.HasSuffix(req.Path, "/_bulk") {
+			if fail {
+				return 503, `{"error":{"type":"unavailable"}}`
+			}
+			return 200, `{"errors":false,"items":[]}`
+		}
+		return 200, meteredResponse
+	})
+	for _, scope := range []schema.QueryScope{{Team: "sre"}, {Team: "payments", Service: "checkout"}} {
+		if _, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Scope: scope}); err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	}
+
+	// A failed flush keeps the usage for the next one
+	if err := p.flushMetering(context.Background()); err == nil {
+		t.Fatal("flush succeeded against a failing cluster")
+	}
+	fail = false
+	if err := p.flushMetering(context.Background()); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	requests := transport.recorded()
+	bulk := requests[len(requests)-1]
+	if bulk.Path != "/opsorch-usage/_bulk" {
+		t.Fatalf("path = %s, want the metering index", bulk.Path)
+	}
+	lines := strings.Split(strings.TrimSpace(bulk.Body), "\n")
+	if len(lines) != 4 || lines[0] != `{"create":{}}` {
+		t.Fatalf("body = %s, want a create per scope", bulk.Body)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &doc); err != nil {
+		t.Fatalf("failed to decode usage document: %v", err)
+	}
+	period := doc["period"].(map[string]any)
+	if doc["@timestamp"] != period["end"] || period["start"] == period["end"] {
+		t.Errorf("document = %v, want the period ending at the timestamp", doc)
+	}
+	delete(doc, "@timestamp")
+	delete(doc, "period")
+	size := float64(len(meteredResponse))
+	want := map[string]any{"team": "payments", "service": "checkout", "queries": 1.0, "documents": 2.0, "bytes": size, "tookMs": 4.0}
+	if fmt.Sprint(doc) != fmt.Sprint(want) {
+		t.Errorf("document = %v, want %v", doc, want)
+	}
+
+	// Flushing drains the pending usage but not the counters read by stats
+	if err := p.flushMetering(context.Background()); err != nil || len(transport.recorded()) != len(requests) {
+		t.Errorf("second flush sent a request with nothing pending (err %v)", err)
+	}
+	if stats, _ := p.UsageStats(false); len(stats.Scopes) != 2 {
+		t.Errorf("scopes = %+v, want both scopes still counted", stats.Scopes)
+	}
+}
+
+func TestCloseStopsMeteringFlush(t *testing.T) {
+	p, transport := newMeteredProvider(t, Config{MeteringIndex: "opsorch-usage"}, func(req recordedRequest) (int, string) {
+		if strings.HasSuffix(req.Path, "/_bulk") {
+			return 200, `{"errors":false,"items":[]}`
+		}
+		return 200, meteredResponse
+	})
+	bulks := func() int {
+		n := 0
+		for _, req := range transport.recorded() {
+			if strings.HasSuffix(req.Path, "/_bulk") {
+				n++
+			}
+		}
+		return n
+	}
+	p.startMeteringFlush(time.Millisecond)
+
+	// The loop flushes what was counted since the last tick
+	if _, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Scope: schema.QueryScope{Team: "sre"}}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); bulks() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no periodic flush was sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case <-p.meter.flushDone:
+	default:
+		t.Fatal("Close returned with the flush loop still running")
+	}
+	sent := bulks()
+	time.Sleep(10 * time.Millisecond)
+	if bulks() != sent {
+		t.Errorf("bulks = %d after Close, want %d", bulks(), sent)
+	}
+}
+
+func TestResponseUsage(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		call      func(ctx context.Context, p *ElasticProvider) error
+		documents int64
+		took      int64
+	}{
+		{"search", meteredResponse, func(ctx context.Context, p *ElasticProvider) error {
+			_, err := p.search(ctx, "logs-*", map[string]any{})
+			return err
+		}, 2, 4},
+		{"aggregation", `{"took":6,"hits":{"hits":[]},"aggregations":{"values":{"buckets":[{"key":"a","doc_count":3}]}}}`, func(ctx context.Context, p *ElasticProvider) error {
+			_, _, err := p.FieldValues(ctx, schema.LogQuery{}, "service", 5)
+			return err
+		}, 0, 6},
+		{"msearch", `{"took":9,"responses":[{"took":5,"hits":{"hits":[{},{}]}},{"took":4,"hits":{"hits":[{}]}}]}`, func(ctx context.Context, p *ElasticProvider) error {
+			_, err := p.msearch(ctx, []map[string]any{{}, {}})
+			return err
+		}, 3, 9},
+		{"async", `{"id":"x","response":{"took":7,"hits":{"hits":[{}]}}}`, func(ctx context.Context, p *ElasticProvider) error {
+			_, err := p.PollAsync(ctx, "x")
+			return err
+		}, 1, 7},
+		{"sql", `{"columns":[],"rows":[[1],[2],[3]]}`, func(ctx context.Context, p *ElasticProvider) error {
+			_, err := p.sqlRequest(ctx, map[string]any{})
+			return err
+		}, 3, 0},
+	}
+	for _, tt := range tests {
+		p, _ := newMeteredProvider(t, Config{}, func(req recordedRequest) (int, string) {
+			return 200, tt.body
+		})
+		ctx := withAudit(context.Background(), "log.test", schema.QueryScope{Team: "sre"})
+		if err := tt.call(ctx, p); err != nil {
+			t.Errorf("%s: call failed: %v", tt.name, err)
+			continue
+		}
+		stats, _ := p.UsageStats(false)
+		want := UsageCounters{Team: "sre", Queries: 1, Documents: tt.documents, Bytes: int64(len(tt.body)), TookMillis: tt.took}
+		if len(stats.Scopes) != 1 || stats.Scopes[0] != want {
+			t.Errorf("%s: scopes = %+v, want %+v", tt.name, stats.Scopes, want)
+		}
+	}
+}
+
+func TestUsageStatsDisabled(t *testing.T) {
+	p := &ElasticProvider{}
+	if _, err := p.UsageStats(false); err == nil || !strings.Contains(err.Error(), "metering") {
+		t.Errorf("err = %v, want metering disabled", err)
+	}
+	if newMeter(Config{MeteringIndex: "usage"}) == nil {
+		t.Error("a metering index did not turn metering on")
+	}
+}
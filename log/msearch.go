@@ -0,0 +1,70 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// esMsearchItem is one response of a multi search. Error is set instead of
+// the search response when that search failed.
+type esMsearchItem struct {
+	esSearchResponse
+	Error  json.RawMessage `json:"error"`
+	Status int             `json:"status"`
+}
+
+// err returns the item's failure, if any.
+func (i esMsearchItem) err() error {
+	if len(i.Error) == 0 || string(i.Error) == "null" {
+		return nil
+	}
+	return newResponseError(i.Status, i.Error)
+}
+
+// msearch runs searches against the index pattern in one _msearch round
+// trip. The responses are in the order of the searches; each may carry its
+// own error.
+func (p *ElasticProvider) msearch(ctx context.Context, searches []map[string]any) ([]esMsearchItem, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, search := range searches {
+		if err := enc.Encode(map[string]any{"index": p.cfg.IndexPattern}); err != nil {
+			return nil, fmt.Errorf("failed to marshal query: %w", err)
+		}
+		if err := enc.Encode(search); err != nil {
+			return nil, fmt.Errorf("failed to marshal query: %w", err)
+		}
+	}
+
+	res, err := p.client.Msearch(&body, p.client.Msearch.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch msearch failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result struct {
+		Took      int             `json:"took"`
+		Responses []esMsearchItem `json:"responses"`
+	}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(result.Responses) != len(searches) {
+		return nil, fmt.Errorf("msearch returned %d responses for %d searches", len(result.Responses), len(searches))
+	}
+	// The overall took covers the searches' own
+	var documents int64
+	for _, item := range result.Responses {
+		documents += int64(len(item.Hits.Hits))
+	}
+	p.meterResult(ctx, documents, int64(result.Took))
+	return result.Responses, nil
+}
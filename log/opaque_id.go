@@ -0,0 +1,45 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+type traceIDKey struct{}
+
+// WithTraceID tags ctx with the id of the request it serves. Searches run
+// for Query under ctx send it as their X-Opaque-Id header and stats group,
+// so they can be found in the slow log, the task manager and search stats,
+// and audit records and slow queries carry it.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceID returns the trace id ctx is tagged with, or "".
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// NewTraceID returns a random trace id of 32 hex digits, the W3C trace id
+// format.
+func NewTraceID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// traceSearch tags a search for ctx's request with its trace id: it adds
+// the stats group to esQuery and returns the option setting X-Opaque-Id.
+// Without a trace id it changes nothing.
+func (p *ElasticProvider) traceSearch(ctx context.Context, esQuery map[string]any) []func(*esapi.SearchRequest) {
+	id := TraceID(ctx)
+	if id == "" {
+		return nil
+	}
+	esQuery["stats"] = []string{id}
+	return []func(*esapi.SearchRequest){p.client.Search.WithOpaqueID(id)}
+}
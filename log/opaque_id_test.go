@@ -0,0 +1,49 @@
+package log
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestTraceIDSent(t *testing.T) {
+	p, transport := newTestProvider(t, Config{SlowQueryThreshold: time.Second}, slowServer(2500))
+	ctx := WithTraceID(context.Background(), "incident-42")
+	if _, err := p.Query(ctx, schema.LogQuery{}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if _, err := p.Query(context.Background(), schema.LogQuery{}); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	var searches []recordedRequest
+	for _, req := range transport.recorded() {
+		if strings.HasSuffix(req.Path, "/_search") {
+			searches = append(searches, req)
+		}
+	}
+	if len(searches) != 2 {
+		t.Fatalf("searches = %d, want 2", len(searches))
+	}
+	if id := searches[0].Header.Get("X-Opaque-Id"); id != "incident-42" || !strings.Contains(searches[0].Body, `"stats":["incident-42"]`) {
+		t.Errorf("X-Opaque-Id = %q, body = %s; want the trace id in both", id, searches[0].Body)
+	}
+	if _, ok := searches[1].Header["X-Opaque-Id"]; ok || strings.Contains(searches[1].Body, `"stats"`) {
+		t.Errorf("untraced search = %+v, want no trace id", searches[1])
+	}
+
+	slow, _ := p.SlowQueries()
+	if len(slow) != 2 || slow[1].TraceID != "incident-42" || slow[0].TraceID != "" {
+		t.Errorf("slow queries = %+v, want the trace id recorded", slow)
+	}
+}
+
+func TestNewTraceID(t *testing.T) {
+	a, b := NewTraceID(), NewTraceID()
+	if len(a) != 32 || strings.Trim(a, "0123456789abcdef") != "" || a == b {
+		t.Errorf("trace ids = %q, %q; want distinct 32 hex digits", a, b)
+	}
+}
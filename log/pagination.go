@@ -0,0 +1,249 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Pagination strategies for the pagination config. "auto" picks scroll on
+// clusters without point in time (Elasticsearch before 7.10, OpenSearch) and
+// search_after otherwise.
+const (
+	paginationAuto        = "auto"
+	paginationSearchAfter = "search_after"
+	paginationPIT         = "pit"
+	paginationScroll      = "scroll"
+)
+
+// defaultScrollTTL is how long a scroll context stays open between batches.
+const defaultScrollTTL = time.Minute
+
+// usePIT reports whether pages are read through a point in time.
+func (p *ElasticProvider) usePIT() bool {
+	return p.cfg.PointInTime || p.cfg.Pagination == paginationPIT
+}
+
+// useScroll reports whether a query that needs several requests should read
+// them through a scroll. Queries resuming from a cursor or starting at an
+// offset always use search_after, since a scroll can do neither.
+func (p *ElasticProvider) useScroll(ctx context.Context, query schema.LogQuery) bool {
+	if !p.canScroll(query) {
+		return false
+	}
+
+	switch p.cfg.Pagination {
+	case paginationScroll:
+		return true
+	case "", paginationAuto:
+		if p.usePIT() {
+			return false
+		}
+		// Without point in time, only a scroll pages over one snapshot
+		return !p.hasFeature(ctx, featurePIT, true)
+	}
+	return false
+}
+
+// canScroll reports whether a query can be read through a scroll, which
+// always starts at the first result.
+func (p *ElasticProvider) canScroll(query schema.LogQuery) bool {
+	if cursor, _ := p.queryCursor(query); cursor != nil {
+		return false
+	}
+	offset, _ := queryOffset(query)
+	return offset == 0
+}
+
+func (p *ElasticProvider) scrollTTL() time.Duration {
+	if p.cfg.ScrollTTL > 0 {
+		return p.cfg.ScrollTTL
+	}
+	return defaultScrollTTL
+}
+
+// scroll reads a query through a scroll context, passing each batch of
+// normalized entries to fn until limit entries have been delivered (0 means
+// no limit), results run out, maxPages batches have been read (0 means no
+// cap), or fn fails. The scroll is cleared however the iteration ends,
+// including on context cancellation. built is the query's DSL when it was
+// already built, or nil. A non-nil slice restricts the scroll to one slice
+// of a sliced scroll. fn must not retain the batch.
+func (p *ElasticProvider) scroll(ctx context.Context, query schema.LogQuery, built, slice map[string]any, limit, maxPages int, fn func(batch []schema.LogEntry) error) (QueryStats, error) {
+	page := query
+	page.Limit = p.pageSize()
+	if limit > 0 {
+		page.Limit = min(page.Limit, limit)
+	}
+	esQuery := p.pageQuery(page, built)
+	if slice != nil {
+		esQuery["slice"] = slice
+	}
+	trace := p.traceSearch(ctx, esQuery)
+	queryBody, err := json.Marshal(esQuery)
+	if err != nil {
+		return QueryStats{}, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	scrollID := ""
+	defer func() {
+		if scrollID != "" {
+			p.clearScroll(scrollID)
+		}
+	}()
+
+	var stats QueryStats
+	var batch []schema.LogEntry
+	delivered := 0
+	for n := 0; ; n++ {
+		var res *esapi.Response
+		if n == 0 {
+			res, err = p.client.Search(append([]func(*esapi.SearchRequest){
+				p.client.Search.WithContext(ctx),
+				p.client.Search.WithIndex(p.searchIndex(ctx)),
+				p.client.Search.WithBody(bytes.NewReader(queryBody)),
+				p.client.Search.WithScroll(p.scrollTTL()),
+			}, trace...)...)
+		} else {
+			if err := ctx.Err(); err != nil {
+				return QueryStats{}, err
+			}
+			body, _ := json.Marshal(map[string]string{
+				"scroll":    strconv.FormatInt(p.scrollTTL().Milliseconds(), 10) + "ms",
+				"scroll_id": scrollID,
+			})
+			res, err = p.client.Scroll(
+				p.client.Scroll.WithContext(ctx),
+				p.client.Scroll.WithBody(bytes.NewReader(body)),
+			)
+		}
+		if err != nil {
+			return QueryStats{}, fmt.Errorf("elasticsearch query failed: %w", err)
+		}
+
+		result, err := readSearchResponse(res)
+		if err != nil {
+			return QueryStats{}, err
+		}
+		p.meterResponse(ctx, result)
+		if result.ScrollID != "" {
+			p.holdScroll(scrollID, result.ScrollID)
+			scrollID = result.ScrollID
+		}
+		stats = mergeStats(stats, result.stats(), n == 0)
+
+		batch = p.appendResult(ctx, batch[:0], result)
+		if limit > 0 {
+			batch = batch[:min(len(batch), limit-delivered)]
+		}
+		delivered += len(batch)
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				return QueryStats{}, err
+			}
+		}
+
+		if len(result.Hits.Hits) < page.Limit || (limit > 0 && delivered >= limit) || (maxPages > 0 && n+1 >= maxPages) {
+			return stats, nil
+		}
+	}
+}
+
+// search runs one search request against index and decodes the response.
+func (p *ElasticProvider) search(ctx context.Context, index string, esQuery map[string]any) (esSearchResponse, error) {
+	queryBody, err := json.Marshal(esQuery)
+	if err != nil {
+		return esSearchResponse{}, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	res, err := p.client.Search(
+		p.client.Search.WithContext(ctx),
+		p.client.Search.WithIndex(index),
+		p.client.Search.WithBody(bytes.NewReader(queryBody)),
+	)
+	if err != nil {
+		return esSearchResponse{}, fmt.Errorf("elasticsearch search failed: %w", err)
+	}
+	result, err := readSearchResponse(res)
+	if err != nil {
+		return esSearchResponse{}, err
+	}
+	p.meterResponse(ctx, result)
+	return result, nil
+}
+
+// searchInto runs a search against the index pattern, sent again when it
+// fails as retry allows, and decodes the response into out, for
+// aggregation responses.
+func (p *ElasticProvider) searchInto(ctx context.Context, esQuery map[string]any, out any) error {
+	queryBody, err := json.Marshal(esQuery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal query: %w", err)
+	}
+	return p.retry(ctx, func(ctx context.Context) error {
+		return p.searchOnce(ctx, queryBody, out)
+	})
+}
+
+// searchOnce sends one search for searchInto.
+func (p *ElasticProvider) searchOnce(ctx context.Context, queryBody []byte, out any) error {
+	res, err := p.client.Search(
+		p.client.Search.WithContext(ctx),
+		p.client.Search.WithIndex(p.cfg.IndexPattern),
+		p.client.Search.WithBody(bytes.NewReader(queryBody)),
+	)
+	if err != nil {
+		return fmt.Errorf("elasticsearch search failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	p.meterResponse(ctx, out)
+	return nil
+}
+
+// readSearchResponse checks and decodes a search or scroll response.
+func readSearchResponse(res *esapi.Response) (esSearchResponse, error) {
+	defer res.Body.Close()
+	if res.IsError() {
+		return esSearchResponse{}, responseError(res)
+	}
+	result, err := decodeSearchResponse(res.Body)
+	if err != nil {
+		return esSearchResponse{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return result, nil
+}
+
+// clearScroll releases a scroll context. Like closePointInTime it runs on a
+// fresh context so that it still happens after cancellation.
+func (p *ElasticProvider) clearScroll(id string) {
+	p.releasedScroll(id)
+	ctx, cancel := context.WithTimeout(context.Background(), pitCloseTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"scroll_id": id})
+	res, err := p.client.ClearScroll(
+		p.client.ClearScroll.WithContext(ctx),
+		p.client.ClearScroll.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: elastic adapter failed to clear scroll: %v\n", err)
+		return
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+}
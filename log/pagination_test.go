@@ -0,0 +1,170 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// scrollServer fakes a scroll over n documents served two per batch. The
+// scroll request numbered failAt (1-based, 0 for never) fails.
+func scrollServer(t *testing.T, version string, n, failAt int) func(req recordedRequest) (int, string) {
+	next := n
+	scrolls := 0
+	batch := func() string {
+		hits := []string{}
+		for ; next > 0 && len(hits) < 2; next-- {
+			hits = append(hits, fmt.Sprintf(`{"_id":"doc-%d","_source":{"message":"doc-%d"},"sort":[%d]}`, next, next, next))
+		}
+		return fmt.Sprintf(`{"_scroll_id":"scroll-%d","took":1,"hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, scrolls, n, strings.Join(hits, ","))
+	}
+	return func(req recordedRequest) (int, string) {
+		switch {
+		case req.Path == "/":
+			return 200, `{"version":{"number":"` + version + `"}}`
+		case req.Path == "/logs-*/_search":
+			if req.Query.Get("scroll") == "" {
+				t.Errorf("search params = %v, want scroll", req.Query)
+			}
+			return 200, batch()
+		case req.Method == "POST" && req.Path == "/_search/scroll":
+			scrolls++
+			if scrolls == failAt {
+				return 500, `{"error":{"type":"search_phase_execution_exception","reason":"all shards failed"}}`
+			}
+			return 200, batch()
+		case req.Method == "DELETE" && req.Path == "/_search/scroll":
+			return 200, `{"succeeded":true,"num_freed":1}`
+		}
+		t.Errorf("unexpected request %s %s", req.Method, req.Path)
+		return 400, `{}`
+	}
+}
+
+func requestLine(requests []recordedRequest) string {
+	lines := make([]string, 0, len(requests))
+	for _, req := range requests {
+		lines = append(lines, req.Method+" "+req.Path)
+	}
+	return strings.Join(lines, ", ")
+}
+
+func TestQueryScrollReadsAndClears(t *testing.T) {
+	p, transport := newTestProvider(t, Config{Pagination: paginationScroll, PageSize: 2, ScrollTTL: 30 * time.Second}, scrollServer(t, "8.11.1", 6, 0))
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 5})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(entries) != 5 || entries[0].Message != "doc-6" || entries[4].Message != "doc-2" {
+		t.Errorf("entries = %v, want doc-6 through doc-2", entries)
+	}
+	if stats.TotalHits != 6 || stats.TookMillis != 3 {
+		t.Errorf("stats = %+v, want totals from the first batch and summed took", stats)
+	}
+
+	requests := transport.recorded()
+	want := "POST /logs-*/_search, POST /_search/scroll, POST /_search/scroll, DELETE /_search/scroll"
+	if got := requestLine(requests); got != want {
+		t.Fatalf("requests = %s, want %s", got, want)
+	}
+	if requests[0].Query.Get("scroll") != "30000ms" {
+		t.Errorf("scroll = %q, want 30000ms", requests[0].Query.Get("scroll"))
+	}
+	var scroll map[string]string
+	if err := json.Unmarshal([]byte(requests[1].Body), &scroll); err != nil || scroll["scroll_id"] != "scroll-0" {
+		t.Errorf("scroll body = %s, want the scroll id from the search", requests[1].Body)
+	}
+	if !strings.Contains(requests[3].Body, `"scroll-2"`) {
+		t.Errorf("clear body = %s, want the latest scroll id", requests[3].Body)
+	}
+}
+
+func TestQueryScrollClearsOnError(t *testing.T) {
+	p, transport := newTestProvider(t, Config{Pagination: paginationScroll, PageSize: 2}, scrollServer(t, "8.11.1", 6, 1))
+
+	if _, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 5}); err == nil || !strings.Contains(err.Error(), "all shards failed") {
+		t.Fatalf("err = %v, want the scroll error", err)
+	}
+	want := "POST /logs-*/_search, POST /_search/scroll, DELETE /_search/scroll"
+	if got := requestLine(transport.recorded()); got != want {
+		t.Errorf("requests = %s, want %s", got, want)
+	}
+}
+
+func TestQueryScrollMemoryCap(t *testing.T) {
+	p, transport := newTestProvider(t, Config{Pagination: paginationScroll, PageSize: 2, MaxEntriesInMemory: 3}, scrollServer(t, "8.11.1", 6, 0))
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 5})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(entries) != 3 || !stats.Truncated || entries[2].Metadata[MetadataTruncated] != true {
+		t.Errorf("entries = %d, stats = %+v; want 3 and the result flagged truncated", len(entries), stats)
+	}
+	want := "POST /logs-*/_search, POST /_search/scroll, DELETE /_search/scroll"
+	if got := requestLine(transport.recorded()); got != want {
+		t.Errorf("requests = %s, want %s", got, want)
+	}
+}
+
+func TestQueryStreamScrollClearsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, transport := newTestProvider(t, Config{Pagination: paginationScroll, PageSize: 2}, scrollServer(t, "8.11.1", 6, 0))
+
+	err := p.QueryStream(ctx, schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	want := "POST /logs-*/_search, DELETE /_search/scroll"
+	if got := requestLine(transport.recorded()); got != want {
+		t.Errorf("requests = %s, want %s", got, want)
+	}
+}
+
+func TestAutoPaginationByVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		scroll  bool
+	}{
+		{version: "6.8.23", scroll: true},
+		{version: "7.9.3", scroll: true},
+		{version: "7.10.0", scroll: false},
+		{version: "8.11.1", scroll: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			p, transport := newTestProvider(t, Config{}, scrollServer(t, tt.version, 0, 0))
+			if got := p.useScroll(context.Background(), schema.LogQuery{}); got != tt.scroll {
+				t.Errorf("useScroll = %v, want %v", got, tt.scroll)
+			}
+			p.useScroll(context.Background(), schema.LogQuery{})
+			if len(transport.recorded()) != 1 {
+				t.Errorf("info requests = %d, want the version cached after one", len(transport.recorded()))
+			}
+		})
+	}
+}
+
+func TestScrollNotUsedToResume(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{Pagination: paginationScroll}}
+	cursor := mustEncodeCursor(t, p, schema.LogQuery{})
+
+	if p.useScroll(context.Background(), schema.LogQuery{Metadata: map[string]any{QueryOptionCursor: cursor}}) {
+		t.Error("useScroll = true with a cursor, want search_after")
+	}
+	if p.useScroll(context.Background(), schema.LogQuery{Metadata: map[string]any{QueryOptionOffset: 10}}) {
+		t.Error("useScroll = true with an offset, want search_after")
+	}
+}
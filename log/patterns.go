@@ -0,0 +1,214 @@
+package log
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// LogPattern is a group of similar log messages.
+type LogPattern struct {
+	// Pattern is the messages' shared tokens, with "<*>" standing for
+	// tokens that vary.
+	Pattern string           `json:"pattern"`
+	Count   int64            `json:"count"`
+	Sample  *schema.LogEntry `json:"sample,omitempty"`
+}
+
+const (
+	defaultMaxPatterns = 10
+	maxPatternCount    = 100
+	// patternSampleSize is how many entries the client-side grouper reads
+	// when the cluster lacks categorize_text.
+	patternSampleSize = 5000
+	// patternSimilarity is the share of tokens two messages must share to
+	// be grouped client-side.
+	patternSimilarity = 0.5
+	// patternWildcard replaces the tokens that vary within a pattern.
+	patternWildcard = "<*>"
+)
+
+// Patterns groups the messages of the logs matching a query into at most
+// maxPatterns patterns, most frequent first. It uses the categorize_text
+// aggregation, or groups a sample of patternSampleSize entries client-side
+// on clusters older than 7.16; counts then cover the sample only.
+func (p *ElasticProvider) Patterns(ctx context.Context, query schema.LogQuery, maxPatterns int) ([]LogPattern, error) {
+	ctx = withAudit(ctx, "log.patterns", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return nil, err
+	}
+	if maxPatterns <= 0 {
+		maxPatterns = defaultMaxPatterns
+	}
+	maxPatterns = min(maxPatterns, maxPatternCount)
+
+	if p.hasFeature(ctx, featureCategorizeText, true) {
+		return p.categorizeText(ctx, query, maxPatterns)
+	}
+
+	sample := query
+	sample.Limit = patternSampleSize
+	entries, _, err := p.QueryWithStats(ctx, sample)
+	if err != nil {
+		return nil, err
+	}
+	return groupPatterns(entries, maxPatterns), nil
+}
+
+// patternField returns the text field categorized into patterns.
+func (p *ElasticProvider) patternField() string {
+	if len(p.cfg.MessageFields) > 0 {
+		return p.cfg.MessageFields[0]
+	}
+	return defaultMessageFields[0]
+}
+
+// buildPatternsQuery constructs a size 0 search categorizing the messages of
+// the logs matching query, with the newest entry of each category.
+func (p *ElasticProvider) buildPatternsQuery(query schema.LogQuery, maxPatterns int) map[string]any {
+	return map[string]any{
+		"query": p.boolQuery(query),
+		"size":  0,
+		"aggs":  map[string]any{"patterns": p.patternsAggregation(maxPatterns)},
+	}
+}
+
+// patternsAggregation categorizes messages into at most maxPatterns
+// categories, each with its newest entry.
+func (p *ElasticProvider) patternsAggregation(maxPatterns int) map[string]any {
+	return map[string]any{
+		"categorize_text": map[string]any{
+			"field": p.patternField(),
+			"size":  maxPatterns,
+		},
+		"aggs": map[string]any{
+			"sample": map[string]any{
+				"top_hits": map[string]any{
+					"size": 1,
+					"sort": p.sortClause(schema.LogQuery{}),
+				},
+			},
+		},
+	}
+}
+
+// esPatternsAggregation is the response to patternsAggregation.
+type esPatternsAggregation struct {
+	Buckets []struct {
+		Key      string `json:"key"`
+		DocCount int64  `json:"doc_count"`
+		Sample   struct {
+			Hits struct {
+				Hits []esHit `json:"hits"`
+			} `json:"hits"`
+		} `json:"sample"`
+	} `json:"buckets"`
+}
+
+func (a esPatternsAggregation) patterns(p *ElasticProvider) []LogPattern {
+	patterns := make([]LogPattern, 0, len(a.Buckets))
+	for _, b := range a.Buckets {
+		pattern := LogPattern{Pattern: b.Key, Count: b.DocCount}
+		if hits := b.Sample.Hits.Hits; len(hits) > 0 {
+			sample := normalizeHit(p, hits[0])
+			pattern.Sample = &sample
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func (p *ElasticProvider) categorizeText(ctx context.Context, query schema.LogQuery, maxPatterns int) ([]LogPattern, error) {
+	var result struct {
+		esSearchResponse
+		Aggregations struct {
+			Patterns esPatternsAggregation `json:"patterns"`
+		} `json:"aggregations"`
+	}
+	if err := p.searchInto(ctx, p.buildPatternsQuery(query, maxPatterns), &result); err != nil {
+		return nil, err
+	}
+	return result.Aggregations.Patterns.patterns(p), nil
+}
+
+// patternGroup is a pattern being built client-side.
+type patternGroup struct {
+	tokens []string
+	count  int64
+	sample schema.LogEntry
+}
+
+// groupPatterns groups entries by message in the manner of Drain: messages
+// with the same number of tokens join the most similar group sharing at
+// least patternSimilarity of its tokens, and tokens that differ become
+// wildcards. Tokens containing digits are wildcards from the start.
+func groupPatterns(entries []schema.LogEntry, maxPatterns int) []LogPattern {
+	byLength := map[int][]*patternGroup{}
+	var groups []*patternGroup
+
+	for _, entry := range entries {
+		tokens := patternTokens(entry.Message)
+		var best *patternGroup
+		bestScore := patternSimilarity
+		for _, g := range byLength[len(tokens)] {
+			if score := tokenSimilarity(g.tokens, tokens); score >= bestScore {
+				best, bestScore = g, score
+			}
+		}
+		if best == nil {
+			g := &patternGroup{tokens: tokens, count: 1, sample: entry}
+			byLength[len(tokens)] = append(byLength[len(tokens)], g)
+			groups = append(groups, g)
+			continue
+		}
+		for i, token := range tokens {
+			if best.tokens[i] != token {
+				best.tokens[i] = patternWildcard
+			}
+		}
+		best.count++
+	}
+
+	// Stable so that ties keep the order groups were first seen in
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].count > groups[j].count })
+	if len(groups) > maxPatterns {
+		groups = groups[:maxPatterns]
+	}
+
+	patterns := make([]LogPattern, 0, len(groups))
+	for _, g := range groups {
+		sample := g.sample
+		patterns = append(patterns, LogPattern{Pattern: strings.Join(g.tokens, " "), Count: g.count, Sample: &sample})
+	}
+	return patterns
+}
+
+// patternTokens splits a message on whitespace, masking tokens that contain
+// digits, such as ids, counts, durations and addresses.
+func patternTokens(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		if strings.IndexFunc(token, unicode.IsDigit) >= 0 {
+			tokens[i] = patternWildcard
+		}
+	}
+	return tokens
+}
+
+// tokenSimilarity is the share of positions where a group's tokens match a
+// message's. An empty message matches an empty group fully.
+func tokenSimilarity(group, tokens []string) float64 {
+	if len(tokens) == 0 {
+		return 1
+	}
+	same := 0
+	for i, token := range tokens {
+		if group[i] == token || group[i] == patternWildcard {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens))
+}
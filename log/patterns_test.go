@@ -0,0 +1,147 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestPatternsCategorizeText(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		if req.Path == "/" {
+			return 200, `{"version":{"number":"8.11.1"}}`
+		}
+		return 200, `{"took":40,"hits":{"total":{"value":50000,"relation":"eq"},"hits":[]},"aggregations":{"patterns":{"buckets":[
+			{"doc_count":48000,"key":"connection refused to upstream","regex":".*?connection.+?refused.+?to.+?upstream.*?","max_matching_length":60,
+			 "sample":{"hits":{"total":{"value":48000,"relation":"eq"},"hits":[{"_index":"logs-app","_id":"a1","_source":{"@timestamp":"2023-10-01T12:00:00Z","message":"connection refused to upstream 10.0.0.7:8080","severity":"error"},"sort":[1696161600000,1]}]}}},
+			{"doc_count":2000,"key":"timeout after ms","regex":".*?timeout.+?after.+?ms.*?","max_matching_length":30,
+			 "sample":{"hits":{"total":{"value":2000,"relation":"eq"},"hits":[{"_index":"logs-app","_id":"b1","_source":{"message":"timeout after 3000 ms"},"sort":[1696161500000,2]}]}}}
+		]}}}`
+	})
+
+	patterns, err := p.Patterns(context.Background(), schema.LogQuery{Expression: &schema.LogExpression{SeverityIn: []string{"error"}}}, 5)
+	if err != nil {
+		t.Fatalf("patterns failed: %v", err)
+	}
+	if len(patterns) != 2 || patterns[0].Pattern != "connection refused to upstream" || patterns[0].Count != 48000 {
+		t.Fatalf("patterns = %+v, want the categorize_text buckets", patterns)
+	}
+	if patterns[0].Sample == nil || patterns[0].Sample.Message != "connection refused to upstream 10.0.0.7:8080" || patterns[0].Sample.Severity != "error" {
+		t.Errorf("sample = %+v, want the normalized top hit", patterns[0].Sample)
+	}
+
+	requests := searchRequests(transport.recorded())
+	if len(requests) != 1 {
+		t.Fatalf("searches = %d, want 1", len(requests))
+	}
+	var body struct {
+		Size int `json:"size"`
+		Aggs struct {
+			Patterns struct {
+				CategorizeText map[string]any `json:"categorize_text"`
+			} `json:"patterns"`
+		} `json:"aggs"`
+	}
+	if err := json.Unmarshal([]byte(requests[0].Body), &body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	if body.Size != 0 || body.Aggs.Patterns.CategorizeText["field"] != "message" || body.Aggs.Patterns.CategorizeText["size"] != float64(5) {
+		t.Errorf("body = %s, want categorize_text on message with size 5", requests[0].Body)
+	}
+}
+
+func TestPatternsClientSideFallback(t *testing.T) {
+	messages := []string{
+		"connection refused to upstream 10.0.0.7:8080",
+		"user alice logged in",
+		"connection refused to upstream 10.0.0.9:8080",
+		"timeout after 3000 ms",
+		"user bob logged in",
+		"connection refused to upstream 10.0.0.7:9090",
+		"timeout after 150 ms",
+		"user carol logged in",
+		"disk full",
+	}
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		if req.Path == "/" {
+			return 200, `{"version":{"number":"7.10.2"}}`
+		}
+		hits := make([]string, 0, len(messages))
+		for i, msg := range messages {
+			hits = append(hits, fmt.Sprintf(`{"_id":"doc-%d","_source":{"message":%q},"sort":[%d]}`, i, msg, len(messages)-i))
+		}
+		return 200, `{"hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+	})
+
+	patterns, err := p.Patterns(context.Background(), schema.LogQuery{}, 3)
+	if err != nil {
+		t.Fatalf("patterns failed: %v", err)
+	}
+
+	got := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		got = append(got, fmt.Sprintf("%s=%d", pattern.Pattern, pattern.Count))
+	}
+	want := "connection refused to upstream <*>=3,user <*> logged in=3,timeout after <*> ms=2"
+	if strings.Join(got, ",") != want {
+		t.Errorf("patterns = %s, want %s", strings.Join(got, ","), want)
+	}
+	if patterns[1].Sample == nil || patterns[1].Sample.Message != "user alice logged in" {
+		t.Errorf("sample = %+v, want the first entry of the group", patterns[1].Sample)
+	}
+
+	search := searchRequests(transport.recorded())[0].Body
+	if strings.Contains(search, "categorize_text") || !strings.Contains(search, fmt.Sprintf(`"size":%d`, defaultPageSize)) {
+		t.Errorf("body = %s, want a plain search for the sample", search)
+	}
+}
+
+func TestGroupPatterns(t *testing.T) {
+	entry := func(msg string) schema.LogEntry { return schema.LogEntry{Message: msg} }
+	tests := []struct {
+		name     string
+		messages []string
+		want     string
+	}{
+		{
+			name:     "different lengths stay apart",
+			messages: []string{"cache miss", "cache miss for key"},
+			want:     "cache miss=1|cache miss for key=1",
+		},
+		{
+			name:     "below the similarity threshold",
+			messages: []string{"GET /health ok", "POST /orders failed"},
+			want:     "GET /health ok=1|POST /orders failed=1",
+		},
+		{
+			name:     "varying word becomes a wildcard",
+			messages: []string{"job sync finished", "job export finished", "job import finished"},
+			want:     "job <*> finished=3",
+		},
+		{
+			name:     "empty messages",
+			messages: []string{"", ""},
+			want:     "=2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := make([]schema.LogEntry, 0, len(tt.messages))
+			for _, msg := range tt.messages {
+				entries = append(entries, entry(msg))
+			}
+			var got []string
+			for _, pattern := range groupPatterns(entries, 10) {
+				got = append(got, fmt.Sprintf("%s=%d", pattern.Pattern, pattern.Count))
+			}
+			if strings.Join(got, "|") != tt.want {
+				t.Errorf("patterns = %s, want %s", strings.Join(got, "|"), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// defaultPercolatorIndex holds the saved queries registered for matching
+// when no percolatorIndex is configured.
+const defaultPercolatorIndex = ".opsorch-saved-query-percolator"
+
+// percolatorFieldTypes are the field types copied from the log indices
+// into the percolator index mapping. Other types are left unmapped, and
+// queries on them percolate as if on text.
+var percolatorFieldTypes = map[string]string{
+	"keyword":          "keyword",
+	"constant_keyword": "keyword",
+	"wildcard":         "keyword",
+	"text":             "text",
+	"match_only_text":  "text",
+	"long":             "long",
+	"integer":          "integer",
+	"short":            "short",
+	"byte":             "byte",
+	"double":           "double",
+	"float":            "float",
+	"half_float":       "half_float",
+	"date":             "date",
+	"date_nanos":       "date_nanos",
+	"boolean":          "boolean",
+	"ip":               "ip",
+	"geo_point":        "geo_point",
+}
+
+// percolatorDocument is a saved query as registered for matching. It sits
+// under savedQuery so it stays clear of the log fields mapped beside it.
+type percolatorDocument struct {
+	SavedQuery registeredQuery `json:"savedQuery"`
+}
+
+type registeredQuery struct {
+	Team   string         `json:"team"`
+	Name   string         `json:"name"`
+	Filter map[string]any `json:"filter"`
+}
+
+// percolatorRoot is the object holding registered queries in the
+// percolator index.
+const percolatorRoot = "savedQuery"
+
+func (p *ElasticProvider) percolatorIndex() string {
+	if p.cfg.PercolatorIndex != "" {
+		return p.cfg.PercolatorIndex
+	}
+	return defaultPercolatorIndex
+}
+
+// MatchSavedQueries returns the saved queries an entry matches, as
+// "team:name" sorted, for example to route an alert by its log line. The
+// entry is converted back into a document and percolated against the saved
+// queries' filters; their time ranges are ignored. Saved queries are
+// registered for matching when saved with PercolateSavedQueries set.
+func (p *ElasticProvider) MatchSavedQueries(ctx context.Context, entry schema.LogEntry) ([]string, error) {
+	ctx = withAudit(ctx, "log.savedQuery.match", schema.QueryScope{Service: entry.Service})
+	if !p.cfg.PercolateSavedQueries {
+		return nil, unsupported("saved query matching is disabled; set percolateSavedQueries to enable it")
+	}
+
+	body, err := json.Marshal(p.buildPercolateQuery(entry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	res, err := p.client.Search(
+		p.client.Search.WithContext(ctx),
+		p.client.Search.WithIndex(p.percolatorIndex()),
+		p.client.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("matching saved queries failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	// Nothing has been registered yet
+	if res.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	}
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result struct {
+		Took int `json:"took"`
+		Hits struct {
+			Hits []struct {
+				Source percolatorDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	p.meterResult(ctx, int64(len(result.Hits.Hits)), int64(result.Took))
+	matches := make([]string, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		matches = append(matches, hit.Source.SavedQuery.Team+":"+hit.Source.SavedQuery.Name)
+	}
+	return matches, nil
+}
+
+// buildPercolateQuery finds the registered queries matching entry.
+func (p *ElasticProvider) buildPercolateQuery(entry schema.LogEntry) map[string]any {
+	return map[string]any{
+		"query": map[string]any{
+			"percolate": map[string]any{
+				"field":    percolatorRoot + ".filter",
+				"document": p.entryDocument(entry),
+			},
+		},
+		"_source": []string{percolatorRoot + ".team", percolatorRoot + ".name"},
+		"sort": []map[string]any{
+			{percolatorRoot + ".team": map[string]any{"order": orderAsc}},
+			{percolatorRoot + ".name": map[string]any{"order": orderAsc}},
+		},
+		"size": maxSavedQueries,
+	}
+}
+
+// unboundedQuery is the bool query of a query without its time range, as
+// registered for matching or watching, where the window is set elsewhere.
+func (p *ElasticProvider) unboundedQuery(query schema.LogQuery) map[string]any {
+	return p.boolQuery(schema.LogQuery{
+		Expression: query.Expression,
+		Scope:      query.Scope,
+		Metadata:   query.Metadata,
+	})
+}
+
+// registerPercolator registers a saved query for matching.
+func (p *ElasticProvider) registerPercolator(ctx context.Context, team, name string, query schema.LogQuery) error {
+	if err := p.ensurePercolatorIndex(ctx); err != nil {
+		return err
+	}
+	body, err := json.Marshal(percolatorDocument{
+		SavedQuery: registeredQuery{Team: team, Name: name, Filter: p.unboundedQuery(query)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal saved query: %w", err)
+	}
+	res, err := p.client.Index(p.percolatorIndex(), bytes.NewReader(body),
+		p.client.Index.WithContext(ctx),
+		p.client.Index.WithDocumentID(savedQueryID(team, name)),
+		p.client.Index.WithRefresh("wait_for"),
+	)
+	if err != nil {
+		return fmt.Errorf("registering saved query failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError(res)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// unregisterPercolator removes a saved query from matching. A query that
+// was never registered is not an error.
+func (p *ElasticProvider) unregisterPercolator(ctx context.Context, team, name string) error {
+	res, err := p.client.Delete(p.percolatorIndex(), savedQueryID(team, name),
+		p.client.Delete.WithContext(ctx),
+		p.client.Delete.WithRefresh("wait_for"),
+	)
+	if err != nil {
+		return fmt.Errorf("unregistering saved query failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return responseError(res)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+// ensurePercolatorIndex creates the percolator index unless it exists. Its
+// mapping copies the log indices' field types, since a percolator query
+// can only be registered once the fields it names are mapped.
+func (p *ElasticProvider) ensurePercolatorIndex(ctx context.Context) error {
+	p.savedMu.Lock()
+	defer p.savedMu.Unlock()
+	if p.percolatorIndexReady {
+		return nil
+	}
+
+	index := p.percolatorIndex()
+	res, err := p.client.Indices.Exists([]string{index}, p.client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("checking percolator index failed: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		p.percolatorIndexReady = true
+		return nil
+	}
+
+	fields, err := p.ListFields(ctx, p.cfg.IndexPattern)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(p.percolatorMapping(fields))
+	res, err = p.client.Indices.Create(index,
+		p.client.Indices.Create.WithContext(ctx),
+		p.client.Indices.Create.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return fmt.Errorf("creating percolator index failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	// Another writer may have created it in between
+	if res.IsError() {
+		if err := responseError(res); !hasErrorType(err, "resource_already_exists_exception") {
+			return err
+		}
+	}
+	p.percolatorIndexReady = true
+	return nil
+}
+
+// percolatorMapping builds the percolator index mapping from the log
+// fields. Fields conflicting across indices or of other types are left
+// unmapped and treated as text. A field under another leaf field, such as
+// message.keyword under message, becomes a multi-field of it.
+func (p *ElasticProvider) percolatorMapping(fields []FieldInfo) map[string]any {
+	types := make(map[string]string, len(fields)+3)
+	for _, field := range fields {
+		if field.Name == percolatorRoot || strings.HasPrefix(field.Name, percolatorRoot+".") {
+			continue
+		}
+		if typ, ok := percolatorFieldTypes[field.Type]; ok && field.Consistent {
+			types[field.Name] = typ
+		}
+	}
+	// The fields entryDocument writes are always mapped
+	for name, typ := range map[string]string{
+		"@timestamp":                   "date",
+		p.patternField():               "text",
+		"severity":                     "keyword",
+		p.scopeFields(scopeService)[0]: "keyword",
+	} {
+		if _, ok := types[name]; !ok {
+			types[name] = typ
+		}
+	}
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	properties := map[string]any{
+		percolatorRoot: map[string]any{
+			"properties": map[string]any{
+				"team":   map[string]any{"type": "keyword"},
+				"name":   map[string]any{"type": "keyword"},
+				"filter": map[string]any{"type": "percolator"},
+			},
+		},
+	}
+	for _, name := range names {
+		if parent, sub, ok := cutLast(name, "."); ok && types[parent] != "" {
+			if mapping, ok := properties[parent].(map[string]any); ok {
+				multi, _ := mapping["fields"].(map[string]any)
+				if multi == nil {
+					multi = map[string]any{}
+					mapping["fields"] = multi
+				}
+				multi[sub] = map[string]any{"type": types[name]}
+			}
+			continue
+		}
+		properties[name] = map[string]any{"type": types[name]}
+	}
+
+	return map[string]any{
+		"settings": map[string]any{
+			"index.percolator.map_unmapped_fields_as_text": true,
+		},
+		"mappings": map[string]any{
+			"dynamic":    false,
+			"properties": properties,
+		},
+	}
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
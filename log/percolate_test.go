@@ -0,0 +1,163 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestEntryDocumentRoundTrip(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{ScopeFields: map[string][]string{scopeService: {"service.name"}}}}
+	entry := schema.LogEntry{
+		Timestamp: time.Date(2023, 10, 1, 12, 0, 0, 123456789, time.UTC),
+		Message:   "payment declined",
+		Severity:  "error",
+		Service:   "checkout",
+		Labels:    map[string]string{"host.name": "web-1"},
+		Fields:    map[string]any{"http.status_code": "402"},
+	}
+
+	doc := p.entryDocument(entry)
+	got := normalizeHit(p, esHit{Source: doc})
+	if !got.Timestamp.Equal(entry.Timestamp) || got.Message != entry.Message || got.Severity != entry.Severity || got.Service != entry.Service {
+		t.Errorf("round trip = %+v, want %+v", got, entry)
+	}
+	if got.Labels["host.name"] != "web-1" {
+		t.Errorf("labels = %v, want host.name kept", got.Labels)
+	}
+	if got.Fields["http.status_code"] != "402" {
+		t.Errorf("fields = %v, want http.status_code kept", got.Fields)
+	}
+}
+
+func TestMatchSavedQueries(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PercolateSavedQueries: true}, func(req recordedRequest) (int, string) {
+		return 200, `{"hits":{"hits":[
+			{"_id":"payments:checkout-errors","_source":{"savedQuery":{"team":"payments","name":"checkout-errors"}}},
+			{"_id":"sre:all-errors","_source":{"savedQuery":{"team":"sre","name":"all-errors"}}}
+		]}}`
+	})
+
+	matches, err := p.MatchSavedQueries(context.Background(), schema.LogEntry{
+		Timestamp: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+		Message:   "payment declined",
+		Severity:  "error",
+		Service:   "checkout",
+		Labels:    map[string]string{"host.name": "web-1"},
+	})
+	if err != nil {
+		t.Fatalf("MatchSavedQueries failed: %v", err)
+	}
+	if strings.Join(matches, ",") != "payments:checkout-errors,sre:all-errors" {
+		t.Errorf("matches = %v, want both saved queries", matches)
+	}
+
+	req := transport.recorded()[0]
+	if req.Method+" "+req.Path != "POST /.opsorch-saved-query-percolator/_search" {
+		t.Fatalf("request = %s %s, want a search of the percolator index", req.Method, req.Path)
+	}
+	var body struct {
+		Query struct {
+			Percolate struct {
+				Field    string         `json:"field"`
+				Document map[string]any `json:"document"`
+			} `json:"percolate"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	want := map[string]any{
+		"@timestamp": "2023-10-01T12:00:00Z",
+		"message":    "payment declined",
+		"severity":   "error",
+		"service":    "checkout",
+		"host.name":  "web-1",
+	}
+	if body.Query.Percolate.Field != "savedQuery.filter" || !reflect.DeepEqual(body.Query.Percolate.Document, want) {
+		t.Errorf("percolate = %+v, want the entry as a document", body.Query.Percolate)
+	}
+}
+
+func TestMatchSavedQueriesDisabled(t *testing.T) {
+	p := &ElasticProvider{}
+	if _, err := p.MatchSavedQueries(context.Background(), schema.LogEntry{}); err == nil || !strings.Contains(err.Error(), "percolateSavedQueries") {
+		t.Errorf("err = %v, want matching disabled", err)
+	}
+}
+
+func TestSaveQueryRegistersPercolator(t *testing.T) {
+	store := &savedQueryStore{t: t, exists: true, docs: map[string]storedQuery{}}
+	var mapping, registered string
+	p, transport := newTestProvider(t, Config{PercolateSavedQueries: true}, func(req recordedRequest) (int, string) {
+		const index = "/.opsorch-saved-query-percolator"
+		switch {
+		case req.Method == "HEAD" && req.Path == index:
+			return 404, ``
+		case req.Path == "/logs-*/_field_caps":
+			return 200, `{"fields":{
+				"message":{"text":{"type":"text","searchable":true,"aggregatable":false}},
+				"message.keyword":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true}},
+				"http.status_code":{"long":{"type":"long","searchable":true,"aggregatable":true}},
+				"user.id":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true,"indices":["logs-a"]},"long":{"type":"long","searchable":true,"aggregatable":true,"indices":["logs-b"]}},
+				"savedQuery":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true}}
+			}}`
+		case req.Method == "PUT" && req.Path == index:
+			mapping = req.Body
+			return 200, `{"acknowledged":true}`
+		case req.Method == "PUT" && strings.HasPrefix(req.Path, index+"/_doc/"):
+			registered = req.Path + " " + req.Body
+			return 201, `{"result":"created"}`
+		case req.Method == "DELETE" && strings.HasPrefix(req.Path, index+"/_doc/"):
+			registered = ""
+			return 200, `{"result":"deleted"}`
+		}
+		return store.handle(req)
+	})
+
+	query := schema.LogQuery{
+		Start:      time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+		Scope:      schema.QueryScope{Team: "payments", Service: "checkout"},
+		Expression: &schema.LogExpression{SeverityIn: []string{"error"}},
+	}
+	if _, err := p.SaveQuery(context.Background(), SavedQuery{Name: "checkout-errors", Query: query}); err != nil {
+		t.Fatalf("SaveQuery failed: %v", err)
+	}
+
+	for _, want := range []string{
+		`"index.percolator.map_unmapped_fields_as_text":true`,
+		`"message":{"fields":{"keyword":{"type":"keyword"}},"type":"text"}`,
+		`"http.status_code":{"type":"long"}`,
+		`"savedQuery":{"properties":{"filter":{"type":"percolator"},"name":{"type":"keyword"},"team":{"type":"keyword"}}}`,
+		`"@timestamp":{"type":"date"}`,
+	} {
+		if !strings.Contains(mapping, want) {
+			t.Errorf("mapping = %s, want %s", mapping, want)
+		}
+	}
+	if strings.Contains(mapping, "user.id") {
+		t.Errorf("mapping = %s, want conflicting fields left unmapped", mapping)
+	}
+
+	if !strings.HasPrefix(registered, "/.opsorch-saved-query-percolator/_doc/payments:checkout-errors ") {
+		t.Fatalf("registered = %q, want the saved query's id", registered)
+	}
+	if !strings.Contains(registered, `"team":"payments","name":"checkout-errors"`) || !strings.Contains(registered, `{"match_phrase":{"service":"checkout"}}`) {
+		t.Errorf("registered = %s, want the saved query's filter", registered)
+	}
+	if strings.Contains(registered, "@timestamp") {
+		t.Errorf("registered = %s, want no time range", registered)
+	}
+
+	if err := p.DeleteQuery(context.Background(), "payments", "checkout-errors"); err != nil {
+		t.Fatalf("DeleteQuery failed: %v", err)
+	}
+	if registered != "" {
+		t.Errorf("requests = %s, want the percolator document deleted", requestLine(transport.recorded()))
+	}
+}
@@ -0,0 +1,95 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrCursorExpired is returned when a cursor's point in time has expired or
+// was closed. Callers should restart the query without a cursor.
+var ErrCursorExpired = errors.New("cursor expired; restart the query without a cursor")
+
+// defaultPITKeepAlive is how long a point in time stays open between pages.
+const defaultPITKeepAlive = "1m"
+
+// pitCloseTimeout bounds closing a point in time, which runs on a fresh
+// context so that it still happens after the query's context is cancelled.
+const pitCloseTimeout = 5 * time.Second
+
+func (p *ElasticProvider) pitKeepAlive() string {
+	if p.cfg.PITKeepAlive != "" {
+		return p.cfg.PITKeepAlive
+	}
+	return defaultPITKeepAlive
+}
+
+// openPointInTime opens a point in time over the index pattern and returns
+// its id.
+func (p *ElasticProvider) openPointInTime(ctx context.Context) (string, error) {
+	res, err := p.client.OpenPointInTime(
+		[]string{p.cfg.IndexPattern},
+		p.pitKeepAlive(),
+		p.client.OpenPointInTime.WithContext(ctx),
+	)
+	if err != nil {
+		return "", fmt.Errorf("open point in time failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", responseError(res)
+	}
+
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to parse point in time response: %w", err)
+	}
+	if body.ID == "" {
+		return "", errors.New("point in time response has no id")
+	}
+	p.holdPIT(body.ID)
+	return body.ID, nil
+}
+
+// closePointInTime releases a point in time. Failures are reported to stderr
+// only: the point in time expires on its own after the keep-alive.
+func (p *ElasticProvider) closePointInTime(id string) {
+	p.releasedPIT(id)
+	ctx, cancel := context.WithTimeout(context.Background(), pitCloseTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"id": id})
+	res, err := p.client.ClosePointInTime(
+		p.client.ClosePointInTime.WithContext(ctx),
+		p.client.ClosePointInTime.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: elastic adapter failed to close point in time: %v\n", err)
+		return
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+}
+
+// releaseCursor closes the point in time held by an abandoned cursor.
+func (p *ElasticProvider) releaseCursor(cursor string) {
+	if cursor == "" {
+		return
+	}
+	if decoded, err := p.decodeCursor(cursor); err == nil && decoded.PIT != "" {
+		p.closePointInTime(decoded.PIT)
+	}
+}
+
+// isSearchContextMissing reports whether an error response says the point
+// in time no longer exists.
+func isSearchContextMissing(err error) bool {
+	return hasErrorType(err, "search_context_missing_exception")
+}
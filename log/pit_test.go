@@ -0,0 +1,144 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// pitServer fakes the point-in-time endpoints over three documents.
+func pitServer(t *testing.T, searchStatus int, searchBody string) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		switch {
+		case req.Method == "POST" && req.Path == "/logs-*/_pit":
+			return 200, `{"id":"pit-1"}`
+		case req.Method == "DELETE" && req.Path == "/_pit":
+			return 200, `{"succeeded":true,"num_freed":1}`
+		case req.Path == "/_search":
+			if searchStatus != 200 {
+				return searchStatus, searchBody
+			}
+			var body struct {
+				SearchAfter []int64 `json:"search_after"`
+			}
+			if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+				t.Errorf("failed to decode request body: %v", err)
+			}
+			if body.SearchAfter == nil {
+				return 200, `{"pit_id":"pit-2","hits":{"hits":[
+					{"_id":"c","_source":{"message":"c"},"sort":[3000,3]},
+					{"_id":"b","_source":{"message":"b"},"sort":[2000,2]}
+				]}}`
+			}
+			return 200, `{"pit_id":"pit-2","hits":{"hits":[
+				{"_id":"a","_source":{"message":"a"},"sort":[1000,1]}
+			]}}`
+		}
+		t.Errorf("unexpected request %s %s", req.Method, req.Path)
+		return 404, `{}`
+	}
+}
+
+func pitRequests(requests []recordedRequest) []string {
+	out := make([]string, 0, len(requests))
+	for _, req := range requests {
+		out = append(out, req.Method+" "+req.Path)
+	}
+	return out
+}
+
+func TestQueryPointInTimeOpenPageClose(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PointInTime: true, PITKeepAlive: "2m"}, pitServer(t, 200, ""))
+
+	entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("first page failed: %v", err)
+	}
+	if len(entries) != 2 || stats.NextCursor == "" {
+		t.Fatalf("first page = %d entries, cursor %q; want 2 entries and a cursor", len(entries), stats.NextCursor)
+	}
+	cursor, err := p.decodeCursor(stats.NextCursor)
+	if err != nil {
+		t.Fatalf("failed to decode cursor: %v", err)
+	}
+	if cursor.PIT != "pit-2" {
+		t.Errorf("cursor PIT = %q, want the refreshed id pit-2", cursor.PIT)
+	}
+
+	requests := transport.recorded()
+	if got := strings.Join(pitRequests(requests), ", "); got != "POST /logs-*/_pit, POST /_search" {
+		t.Fatalf("first page requests = %s, want open then search", got)
+	}
+	if requests[0].Query.Get("keep_alive") != "2m" {
+		t.Errorf("open keep_alive = %q, want 2m", requests[0].Query.Get("keep_alive"))
+	}
+	if !strings.Contains(requests[1].Body, `"pit":{"id":"pit-1","keep_alive":"2m"}`) {
+		t.Errorf("search body = %s, want the opened pit", requests[1].Body)
+	}
+
+	entries, stats, err = p.QueryWithStats(context.Background(), schema.LogQuery{
+		Limit:    2,
+		Metadata: map[string]any{QueryOptionCursor: stats.NextCursor},
+	})
+	if err != nil {
+		t.Fatalf("second page failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Message != "a" || stats.NextCursor != "" {
+		t.Fatalf("second page = %v, cursor %q; want the last entry and no cursor", entries, stats.NextCursor)
+	}
+
+	requests = transport.recorded()[2:]
+	if got := strings.Join(pitRequests(requests), ", "); got != "POST /_search, DELETE /_pit" {
+		t.Fatalf("second page requests = %s, want search then close", got)
+	}
+	if !strings.Contains(requests[0].Body, `"pit":{"id":"pit-2"`) || !strings.Contains(requests[0].Body, `"search_after":[2000,2]`) {
+		t.Errorf("search body = %s, want the cursor's pit and sort values", requests[0].Body)
+	}
+	if requests[1].Body != `{"id":"pit-2"}` {
+		t.Errorf("close body = %s, want the pit id", requests[1].Body)
+	}
+}
+
+func TestQueryPointInTimeExpired(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PointInTime: true}, pitServer(t, 404,
+		`{"error":{"root_cause":[{"type":"search_context_missing_exception","reason":"No search context found for id [42]"}],"type":"search_phase_execution_exception"},"status":404}`))
+
+	cursor, err := p.encodeCursor(schema.LogQuery{}, pageCursor{PIT: "pit-1", After: []any{json.Number("2000"), json.Number("2")}})
+	if err != nil {
+		t.Fatalf("encodeCursor failed: %v", err)
+	}
+	_, _, err = p.QueryWithStats(context.Background(), schema.LogQuery{Metadata: map[string]any{QueryOptionCursor: cursor}})
+	if !errors.Is(err, ErrCursorExpired) {
+		t.Fatalf("err = %v, want ErrCursorExpired", err)
+	}
+	if got := strings.Join(pitRequests(transport.recorded()), ", "); got != "POST /_search" {
+		t.Errorf("requests = %s, want only the search", got)
+	}
+}
+
+func TestQueryPointInTimeClosedOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := pitServer(t, 200, "")
+	p, transport := newTestProvider(t, Config{PointInTime: true}, func(req recordedRequest) (int, string) {
+		if req.Path == "/_search" {
+			cancel()
+			return 500, `{"error":"request cancelled"}`
+		}
+		return handler(req)
+	})
+
+	if _, _, err := p.QueryWithStats(ctx, schema.LogQuery{Limit: 2}); err == nil {
+		t.Fatal("query succeeded, want an error")
+	}
+	requests := transport.recorded()
+	last := requests[len(requests)-1]
+	if last.Method+" "+last.Path != "DELETE /_pit" || last.Body != `{"id":"pit-1"}` {
+		t.Errorf("last request = %s %s %s, want the pit closed", last.Method, last.Path, last.Body)
+	}
+}
@@ -0,0 +1,281 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// ValidateFields modes.
+const (
+	validateFieldsOff   = "off"
+	validateFieldsWarn  = "warn"
+	validateFieldsError = "error"
+)
+
+// maxFieldSuggestions caps the close matches suggested per unknown field.
+const maxFieldSuggestions = 3
+
+// textFieldTypes are the mapping types analyzed into terms, on which exact
+// and pattern filters rarely match.
+var textFieldTypes = map[string]bool{
+	"text":            true,
+	"match_only_text": true,
+}
+
+// UnknownField is a query field missing from the mapping, with the closest
+// field names.
+type UnknownField struct {
+	Field       string   `json:"field"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// UnknownFieldsError is returned when ValidateFields is "error" and a query
+// filters on fields no index maps.
+type UnknownFieldsError struct {
+	Fields []UnknownField
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return "unknown fields: " + describeUnknownFields(e.Fields)
+}
+
+// Is places the error in ErrInvalidQuery.
+func (e *UnknownFieldsError) Is(target error) bool {
+	return target == ErrInvalidQuery
+}
+
+func describeUnknownFields(fields []UnknownField) string {
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, field.Field+suggestionHint(field.Suggestions))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// planFields checks the fields a query filters on against the mapping of
+// the index pattern, per ValidateFields. Exact and pattern filters on a
+// text field are moved to its keyword sub-field when there is one; other
+// text fields and unknown fields are reported as warnings, and unknown
+// fields fail the query in "error" mode. Scope fields come from the
+// configuration, so they are only reported. When the mapping cannot be
+// read the query runs unchecked with a warning.
+func (p *ElasticProvider) planFields(ctx context.Context, query schema.LogQuery) (schema.LogQuery, []string, error) {
+	mode := p.cfg.ValidateFields
+	if mode == "" || mode == validateFieldsOff {
+		return query, nil, nil
+	}
+	fields, err := p.ListFields(ctx, "")
+	if err != nil {
+		return query, p.warn([]string{fmt.Sprintf("field validation skipped: %v", err)}), nil
+	}
+	plan := newFieldPlan(fields)
+
+	if query.Expression != nil && len(query.Expression.Filters) > 0 {
+		expression := *query.Expression
+		expression.Filters = make([]schema.LogFilter, len(query.Expression.Filters))
+		for i, filter := range query.Expression.Filters {
+			switch filter.Operator {
+			case "script":
+			case "geo_distance":
+				plan.check(filter.Field, false)
+			default:
+				filter.Field = plan.check(filter.Field, true)
+			}
+			expression.Filters[i] = filter
+		}
+		query.Expression = &expression
+	}
+
+	if len(query.Metadata) > 0 {
+		metadata := make(map[string]any, len(query.Metadata))
+		for key, value := range query.Metadata {
+			if !reservedMetadataKeys[key] {
+				key = plan.check(key, true)
+			}
+			metadata[key] = value
+		}
+		query.Metadata = metadata
+	}
+
+	for _, scope := range []struct {
+		name, value string
+	}{
+		{scopeService, query.Scope.Service},
+		{scopeEnvironment, query.Scope.Environment},
+		{scopeTeam, query.Scope.Team},
+	} {
+		if scope.value != "" {
+			plan.checkScope(scope.name, p.scopeFields(scope.name), p.cfg.ExactScopeMatch)
+		}
+	}
+
+	if mode == validateFieldsError && len(plan.unknown) > 0 {
+		return query, nil, &UnknownFieldsError{Fields: plan.unknown}
+	}
+	warnings := plan.warnings
+	if len(plan.unknown) > 0 {
+		warnings = append([]string{"unknown fields: " + describeUnknownFields(plan.unknown)}, warnings...)
+	}
+	return query, p.warn(warnings), nil
+}
+
+// warn writes warnings to stderr and returns them.
+func (p *ElasticProvider) warn(warnings []string) []string {
+	for _, warning := range warnings {
+		fmt.Fprintf(stderr, "warning: elastic adapter: %s\n", warning)
+	}
+	return warnings
+}
+
+// fieldPlan collects the findings of planFields.
+type fieldPlan struct {
+	fields   map[string]FieldInfo
+	names    []string
+	seen     map[string]bool
+	unknown  []UnknownField
+	warnings []string
+}
+
+func newFieldPlan(fields []FieldInfo) *fieldPlan {
+	plan := &fieldPlan{
+		fields: make(map[string]FieldInfo, len(fields)),
+		names:  make([]string, 0, len(fields)),
+		seen:   make(map[string]bool),
+	}
+	for _, field := range fields {
+		plan.fields[field.Name] = field
+		plan.names = append(plan.names, field.Name)
+	}
+	return plan
+}
+
+// check looks up a filtered field and returns the field to query. With
+// exact set, a text field is replaced by its keyword sub-field.
+func (plan *fieldPlan) check(name string, exact bool) string {
+	if name == "" {
+		return name
+	}
+	field, ok := plan.fields[name]
+	if !ok {
+		if !plan.seen[name] {
+			plan.seen[name] = true
+			plan.unknown = append(plan.unknown, UnknownField{Field: name, Suggestions: suggestFields(name, plan.names)})
+		}
+		return name
+	}
+	if !exact || !textFieldTypes[field.Type] {
+		return name
+	}
+	if keyword, ok := plan.fields[name+keywordSuffix]; ok && !textFieldTypes[keyword.Type] {
+		return keyword.Name
+	}
+	plan.warnOnce(name, fmt.Sprintf("field %s is text, so exact and pattern filters on it may match nothing", name))
+	return name
+}
+
+// checkScope reports a scope whose candidate fields are all unmapped, or,
+// with exact set, that matches a text field exactly.
+func (plan *fieldPlan) checkScope(scope string, candidates []string, exact bool) {
+	found := false
+	for _, name := range candidates {
+		field, ok := plan.fields[name]
+		if !ok {
+			continue
+		}
+		found = true
+		if exact && textFieldTypes[field.Type] {
+			hint := ""
+			if _, ok := plan.fields[name+keywordSuffix]; ok {
+				hint = fmt.Sprintf("; set scopeFields %s to %s", scope, name+keywordSuffix)
+			}
+			plan.warnOnce(name, fmt.Sprintf("%s scope field %s is text, so the scope may match nothing%s", scope, name, hint))
+		}
+	}
+	if !found {
+		var suggestions []string
+		for _, name := range candidates {
+			suggestions = append(suggestions, suggestFields(name, plan.names)...)
+		}
+		plan.warnOnce(scope+" scope", fmt.Sprintf("no %s scope field is mapped: %s%s", scope, strings.Join(candidates, ", "), suggestionHint(suggestions)))
+	}
+}
+
+func (plan *fieldPlan) warnOnce(key, warning string) {
+	if plan.seen[key] {
+		return
+	}
+	plan.seen[key] = true
+	plan.warnings = append(plan.warnings, warning)
+}
+
+func suggestionHint(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+	return " (did you mean " + strings.Join(suggestions, ", ") + "?)"
+}
+
+// suggestFields returns the mapped names closest to an unknown field, by
+// edit distance to the whole name or to its last segment, so "status_code"
+// suggests "http.status_code". Keyword sub-fields are left out in favour of
+// their parents.
+func suggestFields(name string, names []string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	lower := strings.ToLower(name)
+	limit := max(1, len(name)/3)
+	var candidates []candidate
+	for _, field := range names {
+		if strings.HasSuffix(field, keywordSuffix) {
+			continue
+		}
+		target := strings.ToLower(field)
+		distance := levenshtein(lower, target)
+		if i := strings.LastIndex(target, "."); i >= 0 && !strings.Contains(lower, ".") {
+			distance = min(distance, levenshtein(lower, target[i+1:]))
+		}
+		if distance <= limit {
+			candidates = append(candidates, candidate{name: field, distance: distance})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	suggestions := make([]string, 0, min(len(candidates), maxFieldSuggestions))
+	for _, c := range candidates[:min(len(candidates), maxFieldSuggestions)] {
+		suggestions = append(suggestions, c.name)
+	}
+	return suggestions
+}
+
+// levenshtein returns the edit distance between a and b in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
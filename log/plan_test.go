@@ -0,0 +1,183 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+const planFieldCaps = `{"fields":{
+	"@timestamp":{"date":{"type":"date","searchable":true,"aggregatable":true}},
+	"message":{"text":{"type":"text","searchable":true,"aggregatable":false}},
+	"error.message":{"text":{"type":"text","searchable":true,"aggregatable":false}},
+	"user.name":{"text":{"type":"text","searchable":true,"aggregatable":false}},
+	"user.name.keyword":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true}},
+	"severity":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true}},
+	"service":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true}},
+	"http.response.status_code":{"long":{"type":"long","searchable":true,"aggregatable":true}},
+	"host.name":{"keyword":{"type":"keyword","searchable":true,"aggregatable":true}}
+}}`
+
+func planServer(req recordedRequest) (int, string) {
+	if strings.HasSuffix(req.Path, "/_field_caps") {
+		return 200, planFieldCaps
+	}
+	return 200, `{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+}
+
+func TestSuggestFields(t *testing.T) {
+	names := []string{
+		"@timestamp", "message", "message.keyword", "severity", "service",
+		"http.response.status_code", "host.name", "hostname_alias", "trace.id",
+	}
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"sevrity", "severity"},
+		{"Severity", "severity"},
+		{"status_code", "http.response.status_code"},
+		{"host.nmae", "host.name"},
+		{"mesage", "message"},
+		{"traceid", "trace.id"},
+		{"servce", "service"},
+		{"region", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(suggestFields(tt.field, names), ","); got != tt.want {
+			t.Errorf("suggestFields(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"severity", "severity", 0},
+		{"naïve", "naive", 1},
+	} {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestValidateFieldsWarn(t *testing.T) {
+	stderr := captureStderr(t)
+	p, transport := newTestProvider(t, Config{ValidateFields: validateFieldsWarn}, planServer)
+
+	_, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+		Scope: schema.QueryScope{Service: "checkout"},
+		Expression: &schema.LogExpression{Filters: []schema.LogFilter{
+			{Field: "user.name", Operator: "=", Value: "alice"},
+			{Field: "error.message", Operator: "=", Value: "timeout"},
+			{Field: "sevrity", Operator: "=", Value: "error"},
+		}},
+		Metadata: map[string]any{"status_code": 500, QueryOptionOrder: "asc"},
+	})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	want := []string{
+		"unknown fields: sevrity (did you mean severity?); status_code (did you mean http.response.status_code?)",
+		"field error.message is text, so exact and pattern filters on it may match nothing",
+	}
+	if strings.Join(stats.Warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings = %q, want %q", stats.Warnings, want)
+	}
+	if !strings.Contains(stderr.String(), "warning: elastic adapter: unknown fields: sevrity") {
+		t.Errorf("stderr = %q, want the warnings", stderr.String())
+	}
+
+	body := searchRequests(transport.recorded())[0].Body
+	if !strings.Contains(body, `{"term":{"user.name.keyword":"alice"}}`) {
+		t.Errorf("body = %s, want the keyword sub-field", body)
+	}
+	if !strings.Contains(body, `{"term":{"sevrity":"error"}}`) {
+		t.Errorf("body = %s, want unknown fields queried as given", body)
+	}
+}
+
+func TestValidateFieldsError(t *testing.T) {
+	p, transport := newTestProvider(t, Config{ValidateFields: validateFieldsError}, planServer)
+
+	_, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+		Expression: &schema.LogExpression{Filters: []schema.LogFilter{
+			{Field: "sevrity", Operator: "=", Value: "error"},
+			{Field: "sevrity", Operator: "!=", Value: "debug"},
+			{Field: "region", Operator: "=", Value: "eu"},
+		}},
+	})
+	var unknown *UnknownFieldsError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("err = %v, want an UnknownFieldsError", err)
+	}
+	if err.Error() != "unknown fields: sevrity (did you mean severity?); region" {
+		t.Errorf("err = %v, want each unknown field once with suggestions", err)
+	}
+	if n := len(searchRequests(transport.recorded())); n != 0 {
+		t.Errorf("searches = %d, want none", n)
+	}
+
+	// Known fields pass, including text ones, which only warn
+	captureStderr(t)
+	_, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+		Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "message", Operator: "contains", Value: "timeout"}}},
+	})
+	if err != nil || len(stats.Warnings) != 1 {
+		t.Errorf("err = %v, warnings = %q; want one text field warning", err, stats.Warnings)
+	}
+}
+
+func TestValidateFieldsScope(t *testing.T) {
+	captureStderr(t)
+	p, _ := newTestProvider(t, Config{
+		ValidateFields:  validateFieldsWarn,
+		ScopeFields:     map[string][]string{scopeTeam: {"team", "owner.team"}, scopeEnvironment: {"user.name"}},
+		ExactScopeMatch: true,
+	}, planServer)
+
+	_, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+		Scope: schema.QueryScope{Service: "checkout", Team: "payments", Environment: "prod"},
+	})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	want := []string{
+		"environment scope field user.name is text, so the scope may match nothing; set scopeFields environment to user.name.keyword",
+		"no team scope field is mapped: team, owner.team",
+	}
+	if strings.Join(stats.Warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings = %q, want %q", stats.Warnings, want)
+	}
+
+	// Matched as a phrase too, a text scope field is fine
+	p.cfg.ExactScopeMatch = false
+	_, stats, _ = p.QueryWithStats(context.Background(), schema.LogQuery{
+		Scope: schema.QueryScope{Service: "checkout", Team: "payments", Environment: "prod"},
+	})
+	if len(stats.Warnings) != 1 || stats.Warnings[0] != want[1] {
+		t.Errorf("warnings = %q, want only the unmapped team", stats.Warnings)
+	}
+}
+
+func TestValidateFieldsOff(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, planServer)
+	_, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+		Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "sevrity", Operator: "=", Value: "error"}}},
+	})
+	if err != nil || stats.Warnings != nil {
+		t.Errorf("err = %v, warnings = %q; want the query run unchecked", err, stats.Warnings)
+	}
+	if got := requestLine(transport.recorded()); strings.Contains(got, "_field_caps") {
+		t.Errorf("requests = %s, want no field caps lookup", got)
+	}
+}
@@ -0,0 +1,118 @@
+package log
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// healthProbeTimeout bounds a background health probe, or the probe
+// interval when shorter.
+const healthProbeTimeout = 10 * time.Second
+
+// healthProber checks the cluster's health every interval in the
+// background. Each probe keeps a pooled connection in use, so the first
+// query after a quiet spell need not open one, and goes through the
+// circuit breaker like any request, so an unreachable cluster opens the
+// circuit, and a reachable one closes it, without a query paying for it.
+// The last result is kept for Health to return at once.
+type healthProber struct {
+	interval time.Duration
+	now      func() time.Time
+	// newTicker returns a channel receiving a time every d, and a function
+	// stopping it.
+	newTicker func(d time.Duration) (<-chan time.Time, func())
+
+	mu        sync.Mutex
+	status    HealthStatus
+	err       error
+	checkedAt time.Time
+	failures  int
+
+	// cancel stops the probes, which close done once stopped.
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newHealthProber(interval time.Duration) *healthProber {
+	return &healthProber{
+		interval: interval,
+		now:      time.Now,
+		newTicker: func(d time.Duration) (<-chan time.Time, func()) {
+			ticker := time.NewTicker(d)
+			return ticker.C, ticker.Stop
+		},
+		done: make(chan struct{}),
+	}
+}
+
+// startHealthProbes probes the cluster at once, then every interval until
+// Close stops the prober.
+func (p *ElasticProvider) startHealthProbes() {
+	pr := p.prober
+	ctx, cancel := context.WithCancel(context.Background())
+	pr.cancel = cancel
+	go func() {
+		defer close(pr.done)
+		ticks, stopTicker := pr.newTicker(pr.interval)
+		defer stopTicker()
+		for {
+			p.probeHealth(ctx)
+			select {
+			case <-ticks:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// probeHealth runs one probe and records its result. Probes carry no
+// audit scope, so they are neither audited nor metered.
+func (p *ElasticProvider) probeHealth(ctx context.Context) {
+	pr := p.prober
+	probeCtx, cancel := context.WithTimeout(ctx, min(pr.interval, healthProbeTimeout))
+	defer cancel()
+	status, err := p.checkHealth(probeCtx)
+	if ctx.Err() != nil {
+		// Stopped mid-probe, which tells nothing of the cluster
+		return
+	}
+
+	pr.mu.Lock()
+	pr.status, pr.err, pr.checkedAt = status, err, pr.now()
+	if err != nil {
+		pr.failures++
+	} else {
+		pr.failures = 0
+	}
+	failures := pr.failures
+	pr.mu.Unlock()
+
+	if l := p.logger.Load(); l != nil && err != nil {
+		l.Warn("health probe failed", "consecutiveFailures", failures, "error", err)
+	}
+}
+
+// cached returns the last probe's result, and false before the first probe
+// ends.
+func (pr *healthProber) cached() (HealthStatus, error, bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.checkedAt.IsZero() {
+		return HealthStatus{}, nil, false
+	}
+	if pr.err != nil {
+		return HealthStatus{}, pr.err, true
+	}
+	status := pr.status
+	status.Cached = true
+	status.AgeMillis = pr.now().Sub(pr.checkedAt).Milliseconds()
+	return status, nil, true
+}
+
+// close stops the prober and waits for a running probe to end.
+func (pr *healthProber) close() {
+	pr.cancel()
+	<-pr.done
+}
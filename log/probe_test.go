@@ -0,0 +1,149 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const healthBody = `{"cluster_name":"logging","status":"green","number_of_nodes":3,"number_of_data_nodes":3,"active_shards_percent_as_number":100}`
+
+// newTestProber sets a prober on p with a fake clock, ticked by sending on
+// the returned channel.
+func newTestProber(p *ElasticProvider, interval time.Duration) (*healthProber, chan<- time.Time, func(time.Duration)) {
+	pr := newHealthProber(interval)
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	pr.now = func() time.Time { return now }
+	ticks := make(chan time.Time)
+	pr.newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		if d != interval {
+			panic("ticker interval is not the probe interval")
+		}
+		return ticks, func() {}
+	}
+	p.prober = pr
+	return pr, ticks, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestHealthProbeCadence(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, healthBody
+	})
+	pr, ticks, _ := newTestProber(p, 30*time.Second)
+	p.startHealthProbes()
+
+	// One probe at start and one per tick; each send returns once the
+	// probe before it ended
+	for i := 0; i < 3; i++ {
+		ticks <- time.Time{}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(transport.recorded()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	select {
+	case <-pr.done:
+	default:
+		t.Error("prober still running after Close")
+	}
+	requests := transport.recorded()
+	if len(requests) != 4 {
+		t.Fatalf("probes = %d, want 4", len(requests))
+	}
+	for _, req := range requests {
+		if req.Path != "/_cluster/health/logs-*" {
+			t.Errorf("path = %s, want a health request", req.Path)
+		}
+	}
+}
+
+func TestHealthReturnsProbe(t *testing.T) {
+	status := 200
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		if status != 200 {
+			return status, `{"error":{"type":"security_exception","reason":"unauthorized"},"status":401}`
+		}
+		return status, healthBody
+	})
+	pr, _, advance := newTestProber(p, time.Minute)
+
+	p.probeHealth(context.Background())
+	advance(1500 * time.Millisecond)
+	health, err := p.Health(context.Background())
+	if err != nil {
+		t.Fatalf("health failed: %v", err)
+	}
+	if !health.Cached || health.AgeMillis != 1500 || health.Status != HealthGreen || health.Nodes != 3 {
+		t.Errorf("health = %+v, want the probe's green status, 1.5s old", health)
+	}
+	if len(transport.recorded()) != 1 {
+		t.Errorf("requests = %d, want Health answered without one", len(transport.recorded()))
+	}
+
+	status = 401
+	p.probeHealth(context.Background())
+	p.probeHealth(context.Background())
+	if _, err := p.Health(context.Background()); !errors.Is(err, ErrAuth) {
+		t.Errorf("err = %v, want the failed probe's error", err)
+	}
+	if pr.failures != 2 {
+		t.Errorf("failures = %d, want 2 probes failed in a row", pr.failures)
+	}
+
+	// A probe cut short by Close tells nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.probeHealth(ctx)
+	if pr.failures != 2 {
+		t.Errorf("failures = %d, want the stopped probe not counted", pr.failures)
+	}
+}
+
+func TestHealthProbeFeedsBreaker(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, nil)
+	b, _ := newTestBreaker(2, time.Minute)
+	p.breaker = b
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{"http://elastic.test:9200"},
+		Transport:    &breakerTransport{next: &countingTransport{status: http.StatusServiceUnavailable}, breaker: b},
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	p.client = client
+	newTestProber(p, time.Minute)
+
+	p.probeHealth(context.Background())
+	p.probeHealth(context.Background())
+	if stats, _ := p.CircuitState(); stats.State != CircuitOpen || stats.ConsecutiveFailures != 2 {
+		t.Errorf("circuit = %+v, want it opened by the failed probes", stats)
+	}
+}
+
+func TestHealthProbeOff(t *testing.T) {
+	if cfg := parseConfig(map[string]any{}); cfg.HealthProbeInterval != 0 {
+		t.Errorf("healthProbeInterval = %v, want off by default", cfg.HealthProbeInterval)
+	}
+	if cfg := parseConfig(map[string]any{"healthProbeInterval": "15s"}); cfg.HealthProbeInterval != 15*time.Second {
+		t.Errorf("healthProbeInterval = %v, want 15s", cfg.HealthProbeInterval)
+	}
+
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, healthBody
+	})
+	health, err := p.Health(context.Background())
+	if err != nil || health.Cached || len(transport.recorded()) != 1 {
+		t.Errorf("health = %+v, err = %v; want it checked on request", health, err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("close without probes failed: %v", err)
+	}
+}
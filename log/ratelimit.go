@@ -0,0 +1,202 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRateLimitMaxWait is how long a request may wait for the rate
+// limiter unless rateLimitMaxWait is configured.
+const defaultRateLimitMaxWait = time.Second
+
+// ErrRateLimited is the category of requests the rate limiter refused, so
+// callers can match them with errors.Is. Such a request may succeed later.
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitedError refuses a request without sending it, because the
+// configured request rate leaves no room for it within rateLimitMaxWait.
+// RetryAfter is the time until it would have been let through.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%s: request rate limit reached, next request in %s", ErrRateLimited, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimitStats is the state of the rate limiter.
+type RateLimitStats struct {
+	// Rate is the requests let through per second, and Burst how many may
+	// be sent at once after a quiet spell.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// Tokens is how many requests may be sent right now without waiting.
+	Tokens float64 `json:"tokens"`
+	// Allowed counts the requests let through, Delayed those of them that
+	// waited, and Refused the requests failed with ErrRateLimited.
+	Allowed int64 `json:"allowed"`
+	Delayed int64 `json:"delayed"`
+	Refused int64 `json:"refused"`
+	// WaitedMs is the time requests spent waiting in all.
+	WaitedMs int64 `json:"waitedMs"`
+}
+
+// rateLimiter is a token bucket: it holds up to burst tokens, refilled at
+// rate per second, and each request takes one. A request finding none
+// waits for its token, unless that would take longer than maxWait.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	maxWait time.Duration
+	now     func() time.Time
+	// sleep waits for d or until ctx is done; tests replace it along with
+	// now.
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	stats  RateLimitStats
+	waited time.Duration
+}
+
+func newRateLimiter(rate float64, burst int, maxWait time.Duration) *rateLimiter {
+	if burst <= 0 {
+		burst = max(int(math.Ceil(rate)), 1)
+	}
+	l := &rateLimiter{rate: rate, burst: float64(burst), maxWait: maxWait, now: time.Now, sleep: sleepContext}
+	l.tokens, l.last = l.burst, l.now()
+	return l
+}
+
+// wait takes a token for a request, waiting until it is due. It fails with
+// a RateLimitedError when the token is further away than maxWait, and with
+// ctx's error when ctx ends first, in which case the token is given back.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	delay, err := l.reserve()
+	if err != nil || delay == 0 {
+		return err
+	}
+	if err := l.sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		l.tokens = min(l.tokens+1, l.burst)
+		l.stats.Allowed--
+		l.stats.Delayed--
+		l.waited -= delay
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// reserve takes a token, possibly not yet refilled, and returns how long
+// until it is.
+func (l *rateLimiter) reserve() (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	if l.tokens >= 1 {
+		l.tokens--
+		l.stats.Allowed++
+		return 0, nil
+	}
+	delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if delay > l.maxWait {
+		l.stats.Refused++
+		return 0, &RateLimitedError{RetryAfter: delay}
+	}
+	l.tokens--
+	l.stats.Allowed++
+	l.stats.Delayed++
+	l.waited += delay
+	return delay, nil
+}
+
+// refill adds the tokens earned since the last call. The caller holds mu.
+func (l *rateLimiter) refill() {
+	now := l.now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed.Seconds()*l.rate, l.burst)
+	}
+	l.last = now
+}
+
+func (l *rateLimiter) snapshot() RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	stats := l.stats
+	stats.Rate, stats.Burst = l.rate, int(l.burst)
+	// Tokens taken ahead of time by waiting requests leave none
+	stats.Tokens = max(l.tokens, 0)
+	stats.WaitedMs = l.waited.Milliseconds()
+	return stats
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedPaths are the endpoints whose requests are rate limited: the
+// searches, counts and aggregations a query sends. Requests managing the
+// cluster's state, such as opening a point in time or clearing a scroll,
+// are not, so that they are never left undone.
+var rateLimitedPaths = []string{"/_search", "/_count", "/_msearch", "/_async_search", "/_sql", "/_query", "/_terms_enum"}
+
+// limitTransport paces requests through the rate limiter.
+type limitTransport struct {
+	next    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rateLimited(req) {
+		if err := t.limiter.wait(req.Context()); err != nil {
+			var limited *RateLimitedError
+			if errors.As(err, &limited) {
+				return nil, err
+			}
+			return nil, transportError(err)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+func rateLimited(req *http.Request) bool {
+	if req.Method == http.MethodDelete {
+		return false
+	}
+	for _, path := range rateLimitedPaths {
+		if strings.Contains(req.URL.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitState returns the state of the rate limiter, which is shared by
+// every method of the provider.
+func (p *ElasticProvider) RateLimitState() (RateLimitStats, error) {
+	if p.limiter == nil {
+		return RateLimitStats{}, unsupported("rate limiting is off; set rateLimit to enable it")
+	}
+	return p.limiter.snapshot(), nil
+}
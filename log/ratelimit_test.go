@@ -0,0 +1,155 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// newTestLimiter returns a limiter on a fake clock, which only moves while
+// requests wait for it. The waits are recorded.
+func newTestLimiter(rate float64, burst int, maxWait time.Duration) (*rateLimiter, *[]time.Duration) {
+	l := newRateLimiter(rate, burst, maxWait)
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	l.last = now
+	var waits []time.Duration
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		now = now.Add(d)
+		return nil
+	}
+	return l, &waits
+}
+
+func TestRateLimiterPacing(t *testing.T) {
+	l, waits := newTestLimiter(10, 2, time.Second)
+
+	// The burst goes at once, then requests are spaced at the rate
+	for i := 0; i < 5; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatalf("request %d: err = %v", i, err)
+		}
+	}
+	want := []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}
+	if !slices.Equal(*waits, want) {
+		t.Errorf("waits = %v, want %v", *waits, want)
+	}
+	if stats := l.snapshot(); stats.Allowed != 5 || stats.Delayed != 3 || stats.WaitedMs != 300 || stats.Rate != 10 || stats.Burst != 2 {
+		t.Errorf("stats = %+v, want 5 allowed, 3 of them after waiting 300ms", stats)
+	}
+}
+
+func TestRateLimiterFailsFast(t *testing.T) {
+	l, waits := newTestLimiter(1, 1, 1500*time.Millisecond)
+	// Hold the clock still, so requests queue up behind each other
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatalf("request %d: err = %v", i, err)
+		}
+	}
+	// The third would wait 2s, past the 1.5s allowed
+	err := l.wait(context.Background())
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) || !errors.Is(err, ErrRateLimited) || limited.RetryAfter != 2*time.Second {
+		t.Fatalf("err = %v, want it refused 2s early", err)
+	}
+	if want := []time.Duration{time.Second}; !slices.Equal(*waits, want) {
+		t.Errorf("waits = %v, want %v", *waits, want)
+	}
+	if stats := l.snapshot(); stats.Allowed != 2 || stats.Refused != 1 || stats.Tokens != 0 {
+		t.Errorf("stats = %+v, want 2 allowed and 1 refused", stats)
+	}
+}
+
+func TestRateLimiterCancelled(t *testing.T) {
+	l, _ := newTestLimiter(1, 1, time.Minute)
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want the wait cancelled", err)
+	}
+	// The cancelled request gave its token back
+	if delay, err := l.reserve(); err != nil || delay != time.Second {
+		t.Errorf("delay = %v, err = %v; want the next token 1s away", delay, err)
+	}
+	if stats := l.snapshot(); stats.Allowed != 2 || stats.Delayed != 1 {
+		t.Errorf("stats = %+v, want the cancelled request not counted", stats)
+	}
+}
+
+func TestRateLimitedRequests(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, nil)
+	next := &countingTransport{status: 200}
+	p.limiter, _ = newTestLimiter(1, 1, 0)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{"http://elastic.test:9200"},
+		Transport: &limitTransport{next: next, limiter: p.limiter},
+		RetryOnError: func(req *http.Request, err error) bool {
+			return !errors.Is(err, ErrRateLimited)
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	p.client = client
+
+	// The empty responses do not parse; what matters is whether they came
+	if _, err := p.Count(context.Background(), schema.LogQuery{}); errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want the first count sent", err)
+	}
+	_, err = p.Count(context.Background(), schema.LogQuery{})
+	if !errors.Is(err, ErrRateLimited) || errors.Is(err, ErrConnection) {
+		t.Fatalf("err = %v, want the second count refused", err)
+	}
+	if next.calls.Load() != 1 {
+		t.Errorf("sent = %d, want the refused count not sent", next.calls.Load())
+	}
+
+	// Requests that release cluster state are never held back
+	req, _ := http.NewRequest(http.MethodDelete, "http://elastic.test:9200/_pit", nil)
+	if rateLimited(req) {
+		t.Error("closing a point in time was rate limited")
+	}
+	if stats, err := p.RateLimitState(); err != nil || stats.Refused != 1 {
+		t.Errorf("stats = %+v, err = %v; want one refusal", stats, err)
+	}
+}
+
+func TestRateLimitStateOff(t *testing.T) {
+	p := &ElasticProvider{}
+	if _, err := p.RateLimitState(); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want rate limiting off", err)
+	}
+}
+
+func TestParseRateLimitConfig(t *testing.T) {
+	if cfg := parseConfig(map[string]any{}); cfg.RateLimit != 0 {
+		t.Errorf("rateLimit = %v, want off by default", cfg.RateLimit)
+	}
+	cfg := parseConfig(map[string]any{"rateLimit": 2.5, "rateLimitBurst": 10, "rateLimitMaxWait": "250ms"})
+	if cfg.RateLimit != 2.5 || cfg.RateLimitBurst != 10 || cfg.RateLimitMaxWait != 250*time.Millisecond {
+		t.Errorf("config = %+v, want the rate limit keys read", cfg)
+	}
+	if l := newRateLimiter(2.5, 0, time.Second); l.burst != 3 {
+		t.Errorf("burst = %v, want the rate rounded up", l.burst)
+	}
+}
@@ -0,0 +1,76 @@
+package log
+
+import "strings"
+
+// Redaction modes for redactFields.
+const (
+	redactModeRemove = "remove"
+	redactModeMask   = "mask"
+)
+
+// redactedValue replaces masked field values.
+const redactedValue = "[REDACTED]"
+
+// fieldMatcher matches dotted field paths against exact names and trailing
+// wildcard patterns such as "http.request.headers.*".
+type fieldMatcher struct {
+	exact    map[string]bool
+	prefixes []string
+}
+
+func newFieldMatcher(patterns []string) fieldMatcher {
+	m := fieldMatcher{exact: make(map[string]bool, len(patterns))}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			m.prefixes = append(m.prefixes, prefix)
+		} else if pattern != "" {
+			m.exact[pattern] = true
+		}
+	}
+	return m
+}
+
+func (m fieldMatcher) empty() bool {
+	return len(m.exact) == 0 && len(m.prefixes) == 0
+}
+
+func (m fieldMatcher) match(path string) bool {
+	if m.exact[path] {
+		return true
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSource returns a copy of source with matching fields removed, or
+// masked when mask is set. Paths are matched whether the document nests
+// objects or uses literal dotted keys. The input is never modified.
+func redactSource(source map[string]any, matcher fieldMatcher, mask bool) map[string]any {
+	return redactInto("", source, matcher, mask)
+}
+
+func redactInto(prefix string, source map[string]any, matcher fieldMatcher, mask bool) map[string]any {
+	out := make(map[string]any, len(source))
+	for key, value := range source {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if matcher.match(path) {
+			if mask {
+				out[key] = redactedValue
+			}
+			continue
+		}
+		if nested, ok := value.(map[string]any); ok {
+			out[key] = redactInto(path, nested, matcher, mask)
+			continue
+		}
+		out[key] = value
+	}
+	return out
+}
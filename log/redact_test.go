@@ -0,0 +1,95 @@
+package log
+
+import "testing"
+
+func TestRedactSource(t *testing.T) {
+	source := map[string]any{
+		"message": "login",
+		"user":    map[string]any{"email": "alice@example.com", "id": "u-1"},
+		"http": map[string]any{
+			"request": map[string]any{
+				"method": "POST",
+				"headers": map[string]any{
+					"authorization": "Bearer secret",
+					"cookie":        "session=abc",
+				},
+			},
+		},
+		"client.ip": "10.0.0.1",
+	}
+	matcher := newFieldMatcher([]string{"user.email", "http.request.headers.*", "client.ip"})
+
+	t.Run("remove", func(t *testing.T) {
+		out := redactSource(source, matcher, false)
+
+		user := out["user"].(map[string]any)
+		if _, ok := user["email"]; ok {
+			t.Error("nested exact path should be removed")
+		}
+		if user["id"] != "u-1" {
+			t.Errorf("user.id = %v, want kept", user["id"])
+		}
+		headers := out["http"].(map[string]any)["request"].(map[string]any)["headers"].(map[string]any)
+		if len(headers) != 0 {
+			t.Errorf("headers = %v, want all removed by wildcard", headers)
+		}
+		if out["http"].(map[string]any)["request"].(map[string]any)["method"] != "POST" {
+			t.Error("siblings of wildcard matches should be kept")
+		}
+		if _, ok := out["client.ip"]; ok {
+			t.Error("literal dotted key should be removed")
+		}
+	})
+
+	t.Run("mask", func(t *testing.T) {
+		out := redactSource(source, matcher, true)
+
+		if out["user"].(map[string]any)["email"] != redactedValue {
+			t.Errorf("user.email = %v, want masked", out["user"].(map[string]any)["email"])
+		}
+		headers := out["http"].(map[string]any)["request"].(map[string]any)["headers"].(map[string]any)
+		if headers["authorization"] != redactedValue || headers["cookie"] != redactedValue {
+			t.Errorf("headers = %v, want masked", headers)
+		}
+	})
+
+	t.Run("input untouched", func(t *testing.T) {
+		redactSource(source, matcher, false)
+		if source["user"].(map[string]any)["email"] != "alice@example.com" {
+			t.Error("redaction must not modify the original source")
+		}
+	})
+}
+
+func TestNormalizeHitRedaction(t *testing.T) {
+	source := map[string]interface{}{
+		"message":     "request",
+		"environment": "production",
+		"user":        map[string]interface{}{"email": "alice@example.com"},
+		"http":        map[string]interface{}{"request": map[string]interface{}{"headers": map[string]interface{}{"authorization": "Bearer secret"}}},
+	}
+
+	removed := normalizeHit(&ElasticProvider{cfg: Config{
+		LabelFields: []string{"user.email", "environment"},
+	}, redact: newFieldMatcher([]string{"user.email", "http.request.headers.*"})}, esHit{Source: source})
+	if _, ok := removed.Fields["user.email"]; ok {
+		t.Error("fields should not contain redacted user.email")
+	}
+	if _, ok := removed.Labels["user.email"]; ok {
+		t.Error("labels should not contain redacted user.email")
+	}
+	if _, ok := removed.Fields["http.request.headers.authorization"]; ok {
+		t.Error("fields should not contain redacted header")
+	}
+	if removed.Labels["environment"] != "production" {
+		t.Errorf("labels[environment] = %q, want production", removed.Labels["environment"])
+	}
+
+	masked := normalizeHit(&ElasticProvider{cfg: Config{
+		RedactMode:  redactModeMask,
+		LabelFields: []string{"user.email"},
+	}, redact: newFieldMatcher([]string{"user.email"})}, esHit{Source: source})
+	if masked.Labels["user.email"] != redactedValue || masked.Fields["user.email"] != redactedValue {
+		t.Errorf("user.email = %q / %v, want masked", masked.Labels["user.email"], masked.Fields["user.email"])
+	}
+}
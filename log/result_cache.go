@@ -0,0 +1,142 @@
+package log
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// defaultQueryCacheTTL is how long query results are reused when
+// queryCacheSize is set without queryCacheTTL.
+const defaultQueryCacheTTL = 30 * time.Second
+
+// MetadataCached is set to true on entries answered from the query result
+// cache rather than by Elasticsearch.
+const MetadataCached = "cached"
+
+// resultCache keeps the results of the most recent queries for a while, so
+// the same query sent again, as a dashboard does on every refresh, is
+// answered without searching. Results are kept until their TTL passes, the
+// least recently used first out once size results are kept.
+type resultCache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+	// order holds the results, most recently used first, and items
+	// indexes them by key.
+	order *list.List
+	items map[string]*list.Element
+}
+
+// cachedQuery is a query's result, as returned by QueryWithStats before
+// warnings and hints are added.
+type cachedQuery struct {
+	key     string
+	entries []schema.LogEntry
+	stats   QueryStats
+	expires time.Time
+}
+
+func newResultCache(size int, ttl time.Duration) *resultCache {
+	return &resultCache{size: size, ttl: ttl, now: time.Now, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *resultCache) get(key string) (cachedQuery, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return cachedQuery{}, false
+	}
+	cached := elem.Value.(*cachedQuery)
+	if !c.now().Before(cached.expires) {
+		c.remove(elem)
+		return cachedQuery{}, false
+	}
+	c.order.MoveToFront(elem)
+	return *cached, true
+}
+
+// put keeps a copy of entries and stats, so that callers changing the
+// result they were given leave the cached one unchanged.
+func (c *resultCache) put(key string, entries []schema.LogEntry, stats QueryStats) {
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	c.items[key] = c.order.PushFront(&cachedQuery{key: key, entries: cloneEntries(entries), stats: cloneStats(stats), expires: c.now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *resultCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*cachedQuery).key)
+}
+
+// cachedResult returns the result cached under key, when the result cache
+// is on and holds it.
+func (p *ElasticProvider) cachedResult(key string) (cachedQuery, bool) {
+	if key == "" || p.cfg.QueryCacheSize <= 0 {
+		return cachedQuery{}, false
+	}
+	p.resultMu.Lock()
+	defer p.resultMu.Unlock()
+	if p.results == nil {
+		return cachedQuery{}, false
+	}
+	return p.results.get(key)
+}
+
+// cacheResult keeps a query's result under key.
+func (p *ElasticProvider) cacheResult(key string, entries []schema.LogEntry, stats QueryStats) {
+	ttl := p.cfg.QueryCacheTTL
+	if ttl <= 0 {
+		ttl = defaultQueryCacheTTL
+	}
+	p.resultMu.Lock()
+	defer p.resultMu.Unlock()
+	if p.results == nil {
+		p.results = newResultCache(p.cfg.QueryCacheSize, ttl)
+	}
+	p.results.put(key, entries, stats)
+}
+
+// cacheable reports whether a query's result may be reused. Samples are
+// random, and results read through a point in time hold cursors to it.
+func (p *ElasticProvider) cacheable(query schema.LogQuery) bool {
+	if sample, _ := boolValue(query.Metadata[QueryOptionSample]); sample || p.usePIT() {
+		return false
+	}
+	cursor, err := p.queryCursor(query)
+	return err == nil && (cursor == nil || cursor.PIT == "")
+}
+
+// resultKey hashes the search a query sends, as buildQuery built it, and
+// the indices it searches, so queries written differently but sending the
+// same search share a key. Maps are encoded with sorted keys, so the
+// encoding is canonical.
+func (p *ElasticProvider) resultKey(esQuery map[string]any) string {
+	dsl, _ := json.Marshal(esQuery)
+	sum := sha256.New()
+	sum.Write([]byte(p.cfg.IndexPattern))
+	sum.Write([]byte{0})
+	sum.Write(dsl)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// markCached returns copies of entries marked with MetadataCached, deep
+// enough that changing their labels, fields or metadata leaves the cached
+// entries unchanged.
+func markCached(entries []schema.LogEntry) []schema.LogEntry {
+	marked := cloneEntries(entries)
+	for i := range marked {
+		if marked[i].Metadata == nil {
+			marked[i].Metadata = make(map[string]any, 1)
+		}
+		marked[i].Metadata[MetadataCached] = true
+	}
+	return marked
+}
@@ -0,0 +1,172 @@
+package log
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// searchCounter answers searches with one hit and counts them.
+func searchCounter(searches *int) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		if strings.HasSuffix(req.Path, "/_search") {
+			*searches++
+		}
+		return 200, `{"took":3,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"a","_source":{"message":"a"}}]}}`
+	}
+}
+
+func TestQueryCacheHit(t *testing.T) {
+	var searches int
+	p, _ := newTestProvider(t, Config{QueryCacheSize: 10}, searchCounter(&searches))
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	query := schema.LogQuery{Start: start, Expression: &schema.LogExpression{Search: "timeout"}}
+
+	first, stats, err := p.QueryWithStats(context.Background(), query)
+	if err != nil || stats.Cached || first[0].Metadata[MetadataCached] != nil {
+		t.Fatalf("stats = %+v, err = %v; want the first query searched", stats, err)
+	}
+	// The same search written in another time zone shares the result
+	again := query
+	again.Start = start.In(time.FixedZone("CEST", 2*60*60))
+	second, stats, err := p.QueryWithStats(context.Background(), again)
+	if err != nil || !stats.Cached || stats.TotalHits != 1 || len(second) != 1 || second[0].Metadata[MetadataCached] != true {
+		t.Errorf("stats = %+v, entries = %+v, err = %v; want the cached result marked", stats, second, err)
+	}
+	if searches != 1 {
+		t.Errorf("searches = %d, want 1", searches)
+	}
+	if first[0].Metadata[MetadataCached] != nil {
+		t.Errorf("metadata = %v, want the cached entries unchanged", first[0].Metadata)
+	}
+
+	// Another window, even by a fraction of a second, misses
+	again.Start = start.Add(300 * time.Millisecond)
+	if _, stats, _ := p.QueryWithStats(context.Background(), again); stats.Cached || searches != 2 {
+		t.Errorf("stats = %+v, searches = %d; want another search sent", stats, searches)
+	}
+
+	// Another search misses
+	query.Expression.Search = "refused"
+	if _, stats, _ := p.QueryWithStats(context.Background(), query); stats.Cached || searches != 3 {
+		t.Errorf("stats = %+v, searches = %d; want another search sent", stats, searches)
+	}
+}
+
+func TestQueryCacheResultsIsolated(t *testing.T) {
+	p, _ := newTestProvider(t, Config{QueryCacheSize: 10, LabelFields: []string{"service"}}, func(req recordedRequest) (int, string) {
+		return 200, `{"took":3,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"a","_source":{"message":"a","service":"checkout","x":"original","tags":["a"]}}]}}`
+	})
+	query := schema.LogQuery{Expression: &schema.LogExpression{Search: "timeout"}}
+
+	// Neither the caller that searched nor one answered from the cache
+	// changes what later cache hits return
+	first, _, err := p.QueryWithStats(context.Background(), query)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("entries = %+v, err = %v; want one entry", first, err)
+	}
+	first[0].Fields["x"] = "changed by the first caller"
+	first[0].Labels["service"] = "changed"
+	second, stats, _ := p.QueryWithStats(context.Background(), query)
+	if !stats.Cached {
+		t.Fatalf("stats = %+v, want a cache hit", stats)
+	}
+	second[0].Fields["x"] = "changed by a cache hit"
+	second[0].Fields["tags"].([]any)[0] = "changed"
+	second[0].Metadata["note"] = "changed"
+
+	third, stats, _ := p.QueryWithStats(context.Background(), query)
+	if !stats.Cached || len(third) != 1 {
+		t.Fatalf("stats = %+v, entries = %+v; want a cache hit", stats, third)
+	}
+	entry := third[0]
+	if entry.Fields["x"] != "original" || entry.Labels["service"] != "checkout" || entry.Fields["tags"].([]any)[0] != "a" || entry.Metadata["note"] != nil {
+		t.Errorf("entry = %+v, want the cached result unchanged", entry)
+	}
+}
+
+func TestQueryCacheExpiry(t *testing.T) {
+	var searches int
+	p, _ := newTestProvider(t, Config{QueryCacheSize: 10, QueryCacheTTL: time.Minute}, searchCounter(&searches))
+	query := schema.LogQuery{Expression: &schema.LogExpression{Search: "timeout"}}
+	if _, _, err := p.QueryWithStats(context.Background(), query); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	now := time.Now()
+	p.results.now = func() time.Time { return now.Add(59 * time.Second) }
+	if _, stats, _ := p.QueryWithStats(context.Background(), query); !stats.Cached {
+		t.Errorf("stats = %+v, want a hit within the TTL", stats)
+	}
+	p.results.now = func() time.Time { return now.Add(time.Minute + time.Second) }
+	if _, stats, _ := p.QueryWithStats(context.Background(), query); stats.Cached || searches != 2 {
+		t.Errorf("stats = %+v, searches = %d; want the expired result searched again", stats, searches)
+	}
+}
+
+func TestQueryCacheEviction(t *testing.T) {
+	var searches int
+	p, _ := newTestProvider(t, Config{QueryCacheSize: 2}, searchCounter(&searches))
+	query := func(search string) bool {
+		_, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{Expression: &schema.LogExpression{Search: search}})
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		return stats.Cached
+	}
+	query("a")
+	query("b")
+	query("c") // evicts a
+	if !query("b") || query("a") || !query("b") || query("c") {
+		t.Errorf("want b kept as most recently used, and a then c evicted")
+	}
+	if searches != 5 || p.results.order.Len() != 2 {
+		t.Errorf("searches = %d, cached = %d; want 5 searches and 2 results kept", searches, p.results.order.Len())
+	}
+}
+
+func TestQueryCacheSkipped(t *testing.T) {
+	var searches int
+	p, _ := newTestProvider(t, Config{}, searchCounter(&searches))
+	query := schema.LogQuery{Expression: &schema.LogExpression{Search: "timeout"}}
+	for i := 0; i < 2; i++ {
+		if _, stats, _ := p.QueryWithStats(context.Background(), query); stats.Cached {
+			t.Errorf("stats = %+v, want no cache by default", stats)
+		}
+	}
+	p.cfg.QueryCacheSize, p.cfg.PointInTime = 10, true
+	if p.cacheable(query) {
+		t.Error("want results read through a point in time not cached")
+	}
+	p.cfg.PointInTime = false
+	if p.cacheable(schema.LogQuery{Metadata: map[string]any{QueryOptionSample: true}}) {
+		t.Error("want samples not cached")
+	}
+	if searches != 2 {
+		t.Errorf("searches = %d, want 2", searches)
+	}
+}
+
+func TestQueryBuildsSearchOnce(t *testing.T) {
+	warnings := captureStderr(t)
+	var searches int
+	p, transport := newTestProvider(t, Config{AllowScriptFilters: true}, searchCounter(&searches))
+	query := schema.LogQuery{Limit: 5, Expression: &schema.LogExpression{Filters: []schema.LogFilter{{
+		Operator: "script",
+		Value:    `{"source": "doc['bytes'].value > 0"}`,
+	}}}}
+	if _, err := p.Query(context.Background(), query); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	// The search keyed for coalescing is the one sent, not built again
+	if n := strings.Count(warnings.String(), "script filter"); n != 1 {
+		t.Errorf("script filter warnings = %d, want 1:\n%s", n, warnings)
+	}
+	body := transport.recorded()[0].Body
+	if !strings.Contains(body, `"script"`) || !strings.Contains(body, `"size":5`) {
+		t.Errorf("body = %s, want the script filter and the page size", body)
+	}
+}
@@ -0,0 +1,112 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Retry defaults, used when the config leaves them unset.
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 2 * time.Second
+	defaultRetryJitter    = 0.2
+	// maxRetryAttempts caps the retryAttempts config.
+	maxRetryAttempts = 10
+)
+
+// retryCategories names the error categories retryOn may list.
+var retryCategories = map[string]error{
+	"connection": ErrConnection,
+	"timeout":    ErrTimeout,
+	"too_large":  ErrTooLarge,
+}
+
+type retryingKey struct{}
+
+// retry runs op until it succeeds, fails with an error not worth retrying,
+// or has been tried RetryAttempts times, and returns its last error. Each
+// retry sends the whole search again, so the client picks a node afresh.
+// Retries wait RetryBaseDelay, doubled after each of them up to
+// RetryMaxDelay and varied by RetryJitter. ctx bounds the whole: no retry
+// starts once ctx is done or would be before its wait ends.
+//
+// op receives the context to send its requests with. Operations retried
+// as a whole do not retry the searches within them again.
+func (p *ElasticProvider) retry(ctx context.Context, op func(ctx context.Context) error) error {
+	if ctx.Value(retryingKey{}) != nil || p.cfg.RetryAttempts <= 1 {
+		return op(ctx)
+	}
+	ctx = context.WithValue(ctx, retryingKey{}, true)
+	delay := p.cfg.RetryBaseDelay
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	maxDelay := p.cfg.RetryMaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	delay = min(delay, maxDelay)
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt == p.cfg.RetryAttempts || !p.retryable(ctx, err) {
+			return err
+		}
+		wait := jitter(delay, p.cfg.RetryJitter)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		p.instrument().ObserveRetry(attempt+1, err)
+		if l := p.logger.Load(); l != nil {
+			l.Warn("retrying elasticsearch search", "attempt", attempt+1, "maxAttempts", p.cfg.RetryAttempts, "delayMs", wait.Milliseconds(), "error", err.Error(), "traceId", TraceID(ctx))
+		}
+		if p.sleep(ctx, wait) != nil {
+			return err
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// retryable reports whether a search that failed with err may succeed if
+// sent again. 429 responses, rate limits and tripped circuit breakers, are
+// retried; other 4xx responses never are, nor are requests the open
+// circuit or a disconnected provider refused. Other errors are retried
+// when their category is in RetryOn.
+func (p *ElasticProvider) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrCancelled) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrDisconnected) {
+		return false
+	}
+	var res *ResponseError
+	if errors.As(err, &res) && res.StatusCode >= 400 && res.StatusCode < 500 {
+		return res.StatusCode == http.StatusTooManyRequests
+	}
+	retryOn := p.cfg.RetryOn
+	if retryOn == nil {
+		retryOn = []error{ErrConnection}
+	}
+	for _, category := range retryOn {
+		if errors.Is(err, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// sleep waits for d, or until ctx is done.
+func (p *ElasticProvider) sleep(ctx context.Context, d time.Duration) error {
+	if p.wait != nil {
+		return p.wait(ctx, d)
+	}
+	return sleepContext(ctx, d)
+}
+
+// jitter varies d at random by up to the share j of itself either way.
+func jitter(d time.Duration, j float64) time.Duration {
+	if j <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + j*(2*rand.Float64()-1)))
+}
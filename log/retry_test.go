@@ -0,0 +1,208 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// scriptedStatuses answers counts with each status in turn, then with a
+// count of 5.
+func scriptedStatuses(statuses ...int) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		if len(statuses) == 0 {
+			return 200, `{"count":5}`
+		}
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == 429 {
+			return status, `{"error":{"type":"circuit_breaking_exception","reason":"[parent] Data too large"},"status":429}`
+		}
+		return status, `{"error":{"type":"parsing_exception","reason":"unknown query"},"status":400}`
+	}
+}
+
+// recordWaits makes p record its waits between retries instead of waiting.
+func recordWaits(p *ElasticProvider) *[]time.Duration {
+	var waits []time.Duration
+	p.wait = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return &waits
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	p, transport := newTestProvider(t, Config{RetryAttempts: 3, RetryBaseDelay: 10 * time.Millisecond}, scriptedStatuses(429, 429))
+	waits := recordWaits(p)
+
+	count, err := p.Count(context.Background(), schema.LogQuery{})
+	if err != nil || count != 5 {
+		t.Fatalf("count = %d, err = %v; want 5 after two retries", count, err)
+	}
+	if n := len(transport.recorded()); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+	if want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}; !slices.Equal(*waits, want) {
+		t.Errorf("waits = %v, want %v", *waits, want)
+	}
+}
+
+func TestRetryBackoffCapped(t *testing.T) {
+	p, transport := newTestProvider(t, Config{RetryAttempts: 5, RetryBaseDelay: 10 * time.Millisecond, RetryMaxDelay: 30 * time.Millisecond}, scriptedStatuses(429, 429, 429, 429, 429))
+	waits := recordWaits(p)
+
+	_, err := p.Count(context.Background(), schema.LogQuery{})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want the last attempt's error", err)
+	}
+	if n := len(transport.recorded()); n != 5 {
+		t.Errorf("requests = %d, want 5", n)
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	if !slices.Equal(*waits, want) {
+		t.Errorf("waits = %v, want %v", *waits, want)
+	}
+}
+
+func TestRetryNotOnBadRequest(t *testing.T) {
+	p, transport := newTestProvider(t, Config{RetryAttempts: 3}, scriptedStatuses(400))
+	waits := recordWaits(p)
+
+	_, err := p.Count(context.Background(), schema.LogQuery{})
+	if !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("err = %v, want invalid query", err)
+	}
+	if n := len(transport.recorded()); n != 1 || len(*waits) != 0 {
+		t.Errorf("requests = %d, waits = %v; want a 400 not retried", n, *waits)
+	}
+}
+
+func TestRetryBoundedByContext(t *testing.T) {
+	p, transport := newTestProvider(t, Config{RetryAttempts: 3, RetryBaseDelay: time.Second}, scriptedStatuses(429))
+	waits := recordWaits(p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := p.Count(ctx, schema.LogQuery{})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("err = %v, want the first attempt's error", err)
+	}
+	if n := len(transport.recorded()); n != 1 || len(*waits) != 0 {
+		t.Errorf("requests = %d, waits = %v; want no retry past the deadline", n, *waits)
+	}
+}
+
+func TestRetryOnCategories(t *testing.T) {
+	p, transport := newTestProvider(t, Config{RetryAttempts: 2, RetryOn: []error{ErrTimeout}}, func(req recordedRequest) (int, string) {
+		return 504, `{"error":{"type":"timeout"}}`
+	})
+	// Without the client's own retries of 504s, to count attempts
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{"http://elastic.test:9200"}, Transport: transport, DisableRetry: true})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	p.client = client
+	recordWaits(p)
+
+	_, err = p.Count(context.Background(), schema.LogQuery{})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if n := len(transport.recorded()); n != 2 {
+		t.Errorf("requests = %d, want the timeout retried once", n)
+	}
+}
+
+func TestRetryNotNested(t *testing.T) {
+	p, transport := newTestProvider(t, Config{RetryAttempts: 3}, func(req recordedRequest) (int, string) {
+		return 429, `{"error":{"type":"es_rejected_execution_exception"}}`
+	})
+	recordWaits(p)
+
+	_, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{Limit: 10, Metadata: map[string]any{QueryOptionSample: true}})
+	if err == nil {
+		t.Fatal("query succeeded, want it rejected")
+	}
+	if n := len(transport.recorded()); n != 3 {
+		t.Errorf("requests = %d, want 3 attempts in all", n)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(100*time.Millisecond, 0.2); d < 80*time.Millisecond || d > 120*time.Millisecond {
+			t.Fatalf("jitter = %v, want within 20%% of 100ms", d)
+		}
+	}
+	if d := jitter(100*time.Millisecond, 0); d != 100*time.Millisecond {
+		t.Errorf("jitter = %v, want none", d)
+	}
+}
+
+func TestParseRetryConfig(t *testing.T) {
+	cfg := parseConfig(map[string]any{})
+	if cfg.RetryAttempts != defaultRetryAttempts || cfg.RetryJitter != defaultRetryJitter || cfg.RetryOn != nil {
+		t.Errorf("config = %+v, want retries on by default", cfg)
+	}
+	cfg = parseConfig(map[string]any{
+		"retryAttempts":  5,
+		"retryBaseDelay": "50ms",
+		"retryMaxDelay":  "1s",
+		"retryJitter":    0.5,
+		"retryOn":        []any{"connection", "timeout", "nope"},
+	})
+	if cfg.RetryAttempts != 5 || cfg.RetryBaseDelay != 50*time.Millisecond || cfg.RetryMaxDelay != time.Second || cfg.RetryJitter != 0.5 {
+		t.Errorf("config = %+v, want the retry keys read", cfg)
+	}
+	if len(cfg.RetryOn) != 2 || cfg.RetryOn[0] != ErrConnection || cfg.RetryOn[1] != ErrTimeout {
+		t.Errorf("retryOn = %v, want the known categories", cfg.RetryOn)
+	}
+	if cfg := parseConfig(map[string]any{"retryAttempts": 1000}); cfg.RetryAttempts != maxRetryAttempts {
+		t.Errorf("retryAttempts = %d, want capped at %d", cfg.RetryAttempts, maxRetryAttempts)
+	}
+}
+
+func TestClientRetriesOffUnderRetry(t *testing.T) {
+	var counts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/_count") {
+			w.Write([]byte(`{"version":{"number":"8.11.1"}}`))
+			return
+		}
+		counts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":{"type":"unavailable","reason":"try later"},"status":503}`))
+	}))
+	defer server.Close()
+
+	// Each attempt of retry is one request, and without retry the client
+	// retries a 503 itself
+	for _, tt := range []struct {
+		attempts any
+		want     int32
+	}{{2, 2}, {1, 1 + maxRetries}} {
+		p, err := NewFromConfig(map[string]any{"addresses": []any{server.URL}, "skipStartupPing": true, "retryAttempts": tt.attempts, "retryBaseDelay": "1ms"})
+		if err != nil {
+			t.Fatalf("NewFromConfig failed: %v", err)
+		}
+		counts.Store(0)
+		if _, err := p.Count(context.Background(), schema.LogQuery{}); !errors.Is(err, ErrConnection) {
+			t.Errorf("err = %v, want a connection error", err)
+		}
+		if n := counts.Load(); n != tt.want {
+			t.Errorf("retryAttempts %v: requests = %d, want %d", tt.attempts, n, tt.want)
+		}
+	}
+}
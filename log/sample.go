@@ -0,0 +1,140 @@
+package log
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+const (
+	// maxRandomSamplerProbability is the highest probability random_sampler
+	// accepts below 1.
+	maxRandomSamplerProbability = 0.5
+	// maxSampleTopHits is the most hits a top_hits aggregation returns by
+	// default (index.max_inner_result_window).
+	maxSampleTopHits = 100
+)
+
+// sampleProbability is the probability of keeping each of count matching
+// documents so that about limit are kept.
+func sampleProbability(count int64, limit int) float64 {
+	if count <= int64(limit) {
+		return 1
+	}
+	return float64(limit) / float64(count)
+}
+
+// sampleSeed derives the random seed from the query, so that repeating a
+// query returns the same sample.
+func sampleSeed(query schema.LogQuery) int64 {
+	seed, _ := strconv.ParseInt(queryHash(query)[:7], 16, 64)
+	return seed
+}
+
+// sampleQuery returns a uniform random sample of about the query's limit
+// entries across the whole window instead of the newest ones. The matching
+// documents are counted first to derive the sampling probability. Samples
+// are taken with the random_sampler aggregation where the cluster supports
+// it and the probability and limit allow, else with a random_score query.
+func (p *ElasticProvider) sampleQuery(ctx context.Context, query schema.LogQuery) ([]schema.LogEntry, QueryStats, error) {
+	limit := min(p.querySize(query), p.pageSize())
+	count, err := p.Count(ctx, query)
+	if err != nil {
+		return nil, QueryStats{}, err
+	}
+
+	probability := sampleProbability(count, limit)
+	if probability >= 1 {
+		// Everything fits, so the sample is the whole result
+		all := query
+		all.Limit = limit
+		all.Metadata = make(map[string]any, len(query.Metadata))
+		for key, value := range query.Metadata {
+			if key != QueryOptionSample {
+				all.Metadata[key] = value
+			}
+		}
+		entries, stats, err := p.QueryWithStats(ctx, all)
+		stats.SampleProbability = 1
+		return entries, stats, err
+	}
+
+	var result esSearchResponse
+	if probability <= maxRandomSamplerProbability && limit <= maxSampleTopHits && p.hasFeature(ctx, featureRandomSampler, false) {
+		var sampled struct {
+			esSearchResponse
+			Aggregations struct {
+				Sample struct {
+					Entries struct {
+						Hits struct {
+							Hits []esHit `json:"hits"`
+						} `json:"hits"`
+					} `json:"entries"`
+				} `json:"sample"`
+			} `json:"aggregations"`
+		}
+		if err := p.searchInto(ctx, p.buildRandomSamplerQuery(query, probability, limit), &sampled); err != nil {
+			return nil, QueryStats{}, err
+		}
+		result.Took = sampled.Took
+		result.Hits.Hits = sampled.Aggregations.Sample.Entries.Hits.Hits
+	} else {
+		if result, err = p.search(ctx, p.cfg.IndexPattern, p.buildRandomScoreQuery(query, probability, limit)); err != nil {
+			return nil, QueryStats{}, err
+		}
+	}
+
+	entries := p.appendResult(ctx, nil, result)
+	stats := result.stats()
+	stats.TotalHits = int(count)
+	stats.TotalHitsRelation = "eq"
+	stats.SampleProbability = probability
+	return entries, stats, nil
+}
+
+// buildRandomSamplerQuery samples the matching documents with probability
+// and returns up to limit of them, in the query's order, from a top_hits
+// aggregation.
+func (p *ElasticProvider) buildRandomSamplerQuery(query schema.LogQuery, probability float64, limit int) map[string]any {
+	return map[string]any{
+		"query": p.boolQuery(query),
+		"size":  0,
+		"aggs": map[string]any{
+			"sample": map[string]any{
+				"random_sampler": map[string]any{
+					"probability": probability,
+					"seed":        sampleSeed(query),
+				},
+				"aggs": map[string]any{
+					"entries": map[string]any{
+						"top_hits": map[string]any{"size": limit, "sort": p.sortClause(query)},
+					},
+				},
+			},
+		},
+	}
+}
+
+// buildRandomScoreQuery keeps each matching document with probability by
+// giving it a random score in [0, 1) and dropping scores below
+// 1-probability. The kept documents are returned in the query's order.
+func (p *ElasticProvider) buildRandomScoreQuery(query schema.LogQuery, probability float64, limit int) map[string]any {
+	return map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": []map[string]any{p.boolQuery(query)},
+				"must": []map[string]any{{
+					"function_score": map[string]any{
+						"random_score": map[string]any{"seed": sampleSeed(query), "field": "_seq_no"},
+						"boost_mode":   "replace",
+						"min_score":    1 - probability,
+					},
+				}},
+			},
+		},
+		"sort":             p.sortClause(query),
+		"size":             limit,
+		"track_total_hits": false,
+	}
+}
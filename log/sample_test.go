@@ -0,0 +1,138 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestSampleProbability(t *testing.T) {
+	tests := []struct {
+		count int64
+		limit int
+		want  float64
+	}{
+		{count: 0, limit: 100, want: 1},
+		{count: 100, limit: 100, want: 1},
+		{count: 1000, limit: 100, want: 0.1},
+		{count: 4_000_000, limit: 200, want: 0.00005},
+	}
+	for _, tt := range tests {
+		if got := sampleProbability(tt.count, tt.limit); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("sampleProbability(%d, %d) = %v, want %v", tt.count, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSampleQueryDSL(t *testing.T) {
+	p := &ElasticProvider{cfg: Config{}}
+	query := schema.LogQuery{Expression: &schema.LogExpression{Search: "timeout"}, Limit: 50}
+	seed := sampleSeed(query)
+	if seed != sampleSeed(query) || seed <= 0 {
+		t.Fatalf("seed = %d, want a stable positive seed", seed)
+	}
+
+	sampler, _ := json.Marshal(p.buildRandomSamplerQuery(query, 0.01, 50))
+	want := `"aggs":{"sample":{"aggs":{"entries":{"top_hits":{"size":50,"sort":`
+	if !strings.Contains(string(sampler), want) || !strings.Contains(string(sampler), `"random_sampler":{"probability":0.01,"seed":`) {
+		t.Errorf("random_sampler body = %s, want top_hits under random_sampler", sampler)
+	}
+	if !strings.Contains(string(sampler), `"size":0`) {
+		t.Errorf("random_sampler body = %s, want no top-level hits", sampler)
+	}
+
+	score, _ := json.Marshal(p.buildRandomScoreQuery(query, 0.25, 50))
+	for _, want := range []string{
+		`"function_score":{"boost_mode":"replace","min_score":0.75,"random_score":{"field":"_seq_no","seed":`,
+		`"filter":[{"bool":`,
+		`"size":50`,
+	} {
+		if !strings.Contains(string(score), want) {
+			t.Errorf("random_score body = %s, want %s", score, want)
+		}
+	}
+}
+
+// sampleServer fakes a cluster of the given version holding count matching
+// documents.
+func sampleServer(t *testing.T, version string, count int) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		switch req.Path {
+		case "/":
+			return 200, `{"version":{"number":"` + version + `"}}`
+		case "/logs-*/_count":
+			return 200, `{"count":` + strconv.Itoa(count) + `}`
+		case "/logs-*/_search":
+			if strings.Contains(req.Body, "random_sampler") {
+				return 200, `{"took":4,"hits":{"hits":[]},"aggregations":{"sample":{"entries":{"hits":{"hits":[
+					{"_id":"b","_source":{"message":"b"}},{"_id":"a","_source":{"message":"a"}}
+				]}}}}}`
+			}
+			return 200, `{"took":3,"hits":{"hits":[{"_id":"c","_source":{"message":"c"}}]}}`
+		}
+		t.Errorf("unexpected request %s %s", req.Method, req.Path)
+		return 404, `{}`
+	}
+}
+
+func TestSampleQueryStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		count    int
+		limit    int
+		want     string
+		strategy string
+	}{
+		{name: "random sampler", version: "8.11.1", count: 10000, limit: 10, want: "b,a", strategy: "random_sampler"},
+		{name: "old cluster", version: "8.1.0", count: 10000, limit: 10, want: "c", strategy: "random_score"},
+		{name: "high probability", version: "8.11.1", count: 15, limit: 10, want: "c", strategy: "random_score"},
+		{name: "beyond top hits", version: "8.11.1", count: 100000, limit: 150, want: "c", strategy: "random_score"},
+		{name: "everything fits", version: "8.11.1", count: 5, limit: 10, want: "c", strategy: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, transport := newTestProvider(t, Config{PageSize: 200}, sampleServer(t, tt.version, tt.count))
+			entries, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+				Limit:    tt.limit,
+				Metadata: map[string]any{QueryOptionSample: true},
+			})
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if got := messages(entries); got != tt.want {
+				t.Errorf("entries = %s, want %s", got, tt.want)
+			}
+			if want := sampleProbability(int64(tt.count), tt.limit); stats.SampleProbability != want {
+				t.Errorf("probability = %v, want %v", stats.SampleProbability, want)
+			}
+
+			requests := transport.recorded()
+			search := requests[len(requests)-1]
+			if tt.strategy != "" && !strings.Contains(search.Body, tt.strategy) {
+				t.Errorf("search body = %s, want %s", search.Body, tt.strategy)
+			}
+			if tt.strategy == "" && strings.Contains(search.Body, "random") {
+				t.Errorf("search body = %s, want a plain search", search.Body)
+			}
+			if tt.strategy != "" && stats.TotalHits != tt.count {
+				t.Errorf("total hits = %d, want the count %d", stats.TotalHits, tt.count)
+			}
+		})
+	}
+}
+
+func TestSampleRejectsCursor(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, sampleServer(t, "8.11.1", 0))
+	_, _, err := p.QueryWithStats(context.Background(), schema.LogQuery{
+		Metadata: map[string]any{QueryOptionSample: true, QueryOptionOffset: 10},
+	})
+	if err == nil || !strings.Contains(err.Error(), QueryOptionOffset) {
+		t.Errorf("err = %v, want sampling refused with an offset", err)
+	}
+}
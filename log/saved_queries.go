@@ -0,0 +1,320 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// defaultSavedQueryIndex holds saved queries when no savedQueryIndex is
+// configured.
+const defaultSavedQueryIndex = ".opsorch-saved-queries"
+
+// maxSavedQueries caps the queries ListQueries returns.
+const maxSavedQueries = 1000
+
+// ErrSavedQueryNotFound is returned when a saved query does not exist.
+var ErrSavedQueryNotFound = errors.New("saved query not found")
+
+// ErrSavedQueryConflict is returned when a saved query was changed since it
+// was read, or already exists when saved as new.
+var ErrSavedQueryConflict = errors.New("saved query was changed or already exists; read it again before saving")
+
+// savedQueryMapping is the mapping of the saved query index. Queries are
+// stored but not indexed, so their fields never clash.
+var savedQueryMapping = map[string]any{
+	"mappings": map[string]any{
+		"dynamic": "strict",
+		"properties": map[string]any{
+			"name":      map[string]any{"type": "keyword"},
+			"team":      map[string]any{"type": "keyword"},
+			"query":     map[string]any{"type": "object", "enabled": false},
+			"updatedAt": map[string]any{"type": "date"},
+		},
+	},
+}
+
+// SavedQuery is a named query kept for reuse, namespaced by the team of its
+// query's scope.
+type SavedQuery struct {
+	Name      string          `json:"name"`
+	Team      string          `json:"team"`
+	Query     schema.LogQuery `json:"query"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	// SeqNo and PrimaryTerm identify the stored revision. Saving with them
+	// set overwrites only that revision; saving without them creates a new
+	// query.
+	SeqNo       int64 `json:"seqNo,omitempty"`
+	PrimaryTerm int64 `json:"primaryTerm,omitempty"`
+}
+
+// savedQueryDocument is a saved query as stored.
+type savedQueryDocument struct {
+	Name      string          `json:"name"`
+	Team      string          `json:"team"`
+	Query     schema.LogQuery `json:"query"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// SaveQuery stores saved.Query under saved.Name, in the namespace of the
+// query's scope team. A query read with GetQuery or ListQueries is
+// overwritten only if nobody saved it in between; a query without a
+// revision is created. Either way a clash returns ErrSavedQueryConflict.
+// The saved query is returned with its new revision. With
+// PercolateSavedQueries set it is also registered for MatchSavedQueries; if
+// that fails, the saved query is returned with the error.
+func (p *ElasticProvider) SaveQuery(ctx context.Context, saved SavedQuery) (SavedQuery, error) {
+	ctx = withAudit(ctx, "log.savedQuery.save", saved.Query.Scope)
+	name, query := saved.Name, saved.Query
+	team := query.Scope.Team
+	if err := validateSavedQueryName(team, name); err != nil {
+		return SavedQuery{}, err
+	}
+	if err := p.validateQuery(query); err != nil {
+		return SavedQuery{}, err
+	}
+	if err := p.ensureSavedQueryIndex(ctx); err != nil {
+		return SavedQuery{}, err
+	}
+
+	doc := savedQueryDocument{Name: name, Team: team, Query: query, UpdatedAt: time.Now().UTC()}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return SavedQuery{}, fmt.Errorf("failed to marshal saved query: %w", err)
+	}
+
+	opts := []func(*esapi.IndexRequest){
+		p.client.Index.WithContext(ctx),
+		p.client.Index.WithDocumentID(savedQueryID(team, name)),
+		p.client.Index.WithRefresh("wait_for"),
+	}
+	if saved.PrimaryTerm > 0 {
+		opts = append(opts, p.client.Index.WithIfSeqNo(int(saved.SeqNo)), p.client.Index.WithIfPrimaryTerm(int(saved.PrimaryTerm)))
+	} else {
+		opts = append(opts, p.client.Index.WithOpType("create"))
+	}
+	res, err := p.client.Index(p.savedQueryIndex(), bytes.NewReader(body), opts...)
+	if err != nil {
+		return SavedQuery{}, fmt.Errorf("saving query failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		return SavedQuery{}, ErrSavedQueryConflict
+	}
+	if res.IsError() {
+		return SavedQuery{}, responseError(res)
+	}
+
+	var result struct {
+		SeqNo       int64 `json:"_seq_no"`
+		PrimaryTerm int64 `json:"_primary_term"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return SavedQuery{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	saved = SavedQuery{
+		Name:        name,
+		Team:        team,
+		Query:       query,
+		UpdatedAt:   doc.UpdatedAt,
+		SeqNo:       result.SeqNo,
+		PrimaryTerm: result.PrimaryTerm,
+	}
+	if p.cfg.PercolateSavedQueries {
+		if err := p.registerPercolator(ctx, team, name, query); err != nil {
+			return saved, fmt.Errorf("query saved but not registered for matching: %w", err)
+		}
+	}
+	return saved, nil
+}
+
+// GetQuery returns a team's saved query by name.
+func (p *ElasticProvider) GetQuery(ctx context.Context, team, name string) (SavedQuery, error) {
+	ctx = withAudit(ctx, "log.savedQuery.get", schema.QueryScope{Team: team})
+	if err := validateSavedQueryName(team, name); err != nil {
+		return SavedQuery{}, err
+	}
+	res, err := p.client.Get(p.savedQueryIndex(), savedQueryID(team, name), p.client.Get.WithContext(ctx))
+	if err != nil {
+		return SavedQuery{}, fmt.Errorf("reading saved query failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return SavedQuery{}, ErrSavedQueryNotFound
+	}
+	if res.IsError() {
+		return SavedQuery{}, responseError(res)
+	}
+
+	var hit savedQueryHit
+	if err := json.NewDecoder(res.Body).Decode(&hit); err != nil {
+		return SavedQuery{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return hit.savedQuery(), nil
+}
+
+// ListQueries returns a team's saved queries sorted by name.
+func (p *ElasticProvider) ListQueries(ctx context.Context, team string) ([]SavedQuery, error) {
+	ctx = withAudit(ctx, "log.savedQuery.list", schema.QueryScope{Team: team})
+	body, err := json.Marshal(map[string]any{
+		"query":               map[string]any{"term": map[string]any{"team": team}},
+		"sort":                []map[string]any{{"name": map[string]any{"order": orderAsc}}},
+		"size":                maxSavedQueries,
+		"seq_no_primary_term": true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	res, err := p.client.Search(
+		p.client.Search.WithContext(ctx),
+		p.client.Search.WithIndex(p.savedQueryIndex()),
+		p.client.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing saved queries failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	// Nothing has been saved yet
+	if res.StatusCode == http.StatusNotFound {
+		return []SavedQuery{}, nil
+	}
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []savedQueryHit `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	queries := make([]SavedQuery, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		queries = append(queries, hit.savedQuery())
+	}
+	return queries, nil
+}
+
+// DeleteQuery removes a team's saved query.
+func (p *ElasticProvider) DeleteQuery(ctx context.Context, team, name string) error {
+	ctx = withAudit(ctx, "log.savedQuery.delete", schema.QueryScope{Team: team})
+	if err := validateSavedQueryName(team, name); err != nil {
+		return err
+	}
+	res, err := p.client.Delete(p.savedQueryIndex(), savedQueryID(team, name),
+		p.client.Delete.WithContext(ctx),
+		p.client.Delete.WithRefresh("wait_for"),
+	)
+	if err != nil {
+		return fmt.Errorf("deleting saved query failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrSavedQueryNotFound
+	}
+	if res.IsError() {
+		return responseError(res)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	if p.cfg.PercolateSavedQueries {
+		return p.unregisterPercolator(ctx, team, name)
+	}
+	return nil
+}
+
+// savedQueryHit is a saved query document as returned by get and search.
+type savedQueryHit struct {
+	SeqNo       int64              `json:"_seq_no"`
+	PrimaryTerm int64              `json:"_primary_term"`
+	Source      savedQueryDocument `json:"_source"`
+}
+
+func (h savedQueryHit) savedQuery() SavedQuery {
+	return SavedQuery{
+		Name:        h.Source.Name,
+		Team:        h.Source.Team,
+		Query:       h.Source.Query,
+		UpdatedAt:   h.Source.UpdatedAt,
+		SeqNo:       h.SeqNo,
+		PrimaryTerm: h.PrimaryTerm,
+	}
+}
+
+func (p *ElasticProvider) savedQueryIndex() string {
+	if p.cfg.SavedQueryIndex != "" {
+		return p.cfg.SavedQueryIndex
+	}
+	return defaultSavedQueryIndex
+}
+
+// ensureSavedQueryIndex creates the saved query index with its mapping
+// unless it exists. Success is remembered so later saves skip the check.
+func (p *ElasticProvider) ensureSavedQueryIndex(ctx context.Context) error {
+	p.savedMu.Lock()
+	defer p.savedMu.Unlock()
+	if p.savedIndexReady {
+		return nil
+	}
+
+	index := p.savedQueryIndex()
+	res, err := p.client.Indices.Exists([]string{index}, p.client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("checking saved query index failed: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		p.savedIndexReady = true
+		return nil
+	}
+
+	body, _ := json.Marshal(savedQueryMapping)
+	res, err = p.client.Indices.Create(index,
+		p.client.Indices.Create.WithContext(ctx),
+		p.client.Indices.Create.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return fmt.Errorf("creating saved query index failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	// Another writer may have created it in between
+	if res.IsError() {
+		if err := responseError(res); !hasErrorType(err, "resource_already_exists_exception") {
+			return err
+		}
+	}
+	p.savedIndexReady = true
+	return nil
+}
+
+// validateSavedQueryName rejects names that cannot identify a saved query.
+func validateSavedQueryName(team, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("saved query name is required")
+	}
+	if strings.Contains(team, ":") {
+		return fmt.Errorf("invalid team %q: must not contain ':'", team)
+	}
+	return nil
+}
+
+// savedQueryID is the document id of a team's saved query.
+func savedQueryID(team, name string) string {
+	return url.PathEscape(team + ":" + name)
+}
@@ -0,0 +1,215 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// savedQueryStore fakes the saved query index in memory, with sequence
+// numbers for optimistic concurrency.
+type savedQueryStore struct {
+	t       *testing.T
+	exists  bool
+	created int
+	seqNo   int
+	docs    map[string]storedQuery
+}
+
+type storedQuery struct {
+	seqNo  int
+	source string
+}
+
+func (s *savedQueryStore) handle(req recordedRequest) (int, string) {
+	const index = "/.opsorch-saved-queries"
+	id, isDoc := strings.CutPrefix(req.Path, index+"/_doc/")
+	switch {
+	case req.Method == "HEAD" && req.Path == index:
+		if s.exists {
+			return 200, ``
+		}
+		return 404, ``
+	case req.Method == "PUT" && req.Path == index:
+		if !strings.Contains(req.Body, `"enabled":false`) {
+			s.t.Errorf("mapping = %s, want the query stored unindexed", req.Body)
+		}
+		s.exists = true
+		s.created++
+		return 200, `{"acknowledged":true}`
+	case req.Method == "PUT" && isDoc:
+		doc, found := s.docs[id]
+		if req.Query.Get("op_type") == "create" && found {
+			return 409, `{"error":{"type":"version_conflict_engine_exception","reason":"document already exists"},"status":409}`
+		}
+		if seq := req.Query.Get("if_seq_no"); seq != "" && (!found || seq != strconv.Itoa(doc.seqNo) || req.Query.Get("if_primary_term") != "1") {
+			return 409, `{"error":{"type":"version_conflict_engine_exception","reason":"required seqNo mismatch"},"status":409}`
+		}
+		s.seqNo++
+		s.docs[id] = storedQuery{seqNo: s.seqNo, source: req.Body}
+		return 201, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1,"result":"created"}`, id, s.seqNo)
+	case req.Method == "GET" && isDoc:
+		doc, found := s.docs[id]
+		if !found {
+			return 404, `{"found":false}`
+		}
+		return 200, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1,"found":true,"_source":%s}`, id, doc.seqNo, doc.source)
+	case req.Method == "DELETE" && isDoc:
+		if _, found := s.docs[id]; !found {
+			return 404, `{"result":"not_found"}`
+		}
+		delete(s.docs, id)
+		return 200, `{"result":"deleted"}`
+	case req.Method == "POST" && req.Path == index+"/_search":
+		if !s.exists {
+			return 404, `{"error":{"type":"index_not_found_exception"},"status":404}`
+		}
+		var body struct {
+			Query struct {
+				Term struct {
+					Team string `json:"team"`
+				} `json:"term"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			s.t.Fatalf("failed to decode search: %v", err)
+		}
+		ids := make([]string, 0, len(s.docs))
+		for id := range s.docs {
+			if strings.HasPrefix(id, body.Query.Term.Team+":") {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		hits := make([]string, 0, len(ids))
+		for _, id := range ids {
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_seq_no":%d,"_primary_term":1,"_source":%s}`, id, s.docs[id].seqNo, s.docs[id].source))
+		}
+		return 200, `{"hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+	}
+	s.t.Errorf("unexpected request %s %s", req.Method, req.Path)
+	return 400, `{}`
+}
+
+func TestSavedQueryRoundTrip(t *testing.T) {
+	store := &savedQueryStore{t: t, docs: map[string]storedQuery{}}
+	p, _ := newTestProvider(t, Config{}, store.handle)
+	ctx := context.Background()
+
+	if queries, err := p.ListQueries(ctx, "sre"); err != nil || len(queries) != 0 {
+		t.Fatalf("list before saving = %v, %v; want none", queries, err)
+	}
+
+	query := schema.LogQuery{
+		Scope:      schema.QueryScope{Team: "sre"},
+		Expression: &schema.LogExpression{Search: "timeout", SeverityIn: []string{"error"}},
+	}
+	saved, err := p.SaveQuery(ctx, SavedQuery{Name: "errors last hour", Query: query})
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if saved.Team != "sre" || saved.SeqNo != 1 || saved.PrimaryTerm != 1 || saved.UpdatedAt.IsZero() {
+		t.Errorf("saved = %+v, want team sre at revision 1", saved)
+	}
+	if _, err := p.SaveQuery(ctx, SavedQuery{Name: "checkout", Query: schema.LogQuery{Scope: schema.QueryScope{Team: "sre"}}}); err != nil {
+		t.Fatalf("second save failed: %v", err)
+	}
+	if _, err := p.SaveQuery(ctx, SavedQuery{Name: "checkout", Query: schema.LogQuery{Scope: schema.QueryScope{Team: "payments"}}}); err != nil {
+		t.Fatalf("save for another team failed: %v", err)
+	}
+	if store.created != 1 {
+		t.Errorf("index created %d times, want once", store.created)
+	}
+
+	got, err := p.GetQuery(ctx, "sre", "errors last hour")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if got.Name != "errors last hour" || got.Query.Expression == nil || got.Query.Expression.Search != "timeout" || got.SeqNo != 1 {
+		t.Errorf("got = %+v, want the saved query", got)
+	}
+
+	queries, err := p.ListQueries(ctx, "sre")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(queries) != 2 || queries[0].Name != "checkout" || queries[1].Name != "errors last hour" {
+		t.Errorf("list = %+v, want the team's two queries by name", queries)
+	}
+
+	if err := p.DeleteQuery(ctx, "sre", "errors last hour"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := p.GetQuery(ctx, "sre", "errors last hour"); !errors.Is(err, ErrSavedQueryNotFound) {
+		t.Errorf("get after delete err = %v, want ErrSavedQueryNotFound", err)
+	}
+	if err := p.DeleteQuery(ctx, "sre", "errors last hour"); !errors.Is(err, ErrSavedQueryNotFound) {
+		t.Errorf("second delete err = %v, want ErrSavedQueryNotFound", err)
+	}
+}
+
+func TestSavedQueryConflicts(t *testing.T) {
+	store := &savedQueryStore{t: t, exists: true, docs: map[string]storedQuery{}}
+	p, transport := newTestProvider(t, Config{}, store.handle)
+	ctx := context.Background()
+	query := schema.LogQuery{Scope: schema.QueryScope{Team: "sre"}}
+
+	if _, err := p.SaveQuery(ctx, SavedQuery{Name: "errors", Query: query}); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if _, err := p.SaveQuery(ctx, SavedQuery{Name: "errors", Query: query}); !errors.Is(err, ErrSavedQueryConflict) {
+		t.Errorf("saving over an existing query err = %v, want ErrSavedQueryConflict", err)
+	}
+
+	first, _ := p.GetQuery(ctx, "sre", "errors")
+	second, _ := p.GetQuery(ctx, "sre", "errors")
+
+	first.Query.Limit = 50
+	updated, err := p.SaveQuery(ctx, first)
+	if err != nil {
+		t.Fatalf("overwrite failed: %v", err)
+	}
+	if updated.SeqNo != 2 {
+		t.Errorf("revision = %d, want 2", updated.SeqNo)
+	}
+	requests := transport.recorded()
+	last := requests[len(requests)-1]
+	if last.Query.Get("if_seq_no") != "1" || last.Query.Get("if_primary_term") != "1" || last.Query.Get("op_type") != "" {
+		t.Errorf("overwrite params = %v, want the read revision", last.Query)
+	}
+
+	second.Query.Limit = 10
+	if _, err := p.SaveQuery(ctx, second); !errors.Is(err, ErrSavedQueryConflict) {
+		t.Errorf("stale overwrite err = %v, want ErrSavedQueryConflict", err)
+	}
+	if got, _ := p.GetQuery(ctx, "sre", "errors"); got.Query.Limit != 50 {
+		t.Errorf("stored limit = %d, want the first writer's 50", got.Query.Limit)
+	}
+}
+
+func TestSavedQueryValidation(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 500, `{}`
+	})
+	ctx := context.Background()
+
+	if _, err := p.SaveQuery(ctx, SavedQuery{Name: " "}); err == nil {
+		t.Error("save accepted an empty name")
+	}
+	if _, err := p.SaveQuery(ctx, SavedQuery{Name: "x", Query: schema.LogQuery{Scope: schema.QueryScope{Team: "a:b"}}}); err == nil {
+		t.Error("save accepted a team containing ':'")
+	}
+	if _, err := p.GetQuery(ctx, "sre", ""); err == nil {
+		t.Error("get accepted an empty name")
+	}
+	if len(transport.recorded()) != 0 {
+		t.Errorf("requests = %s, want none", requestLine(transport.recorded()))
+	}
+}
@@ -0,0 +1,146 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Self-test check statuses. A check is skipped when one it depends on
+// failed.
+const (
+	CheckPass = "pass"
+	CheckFail = "fail"
+	CheckSkip = "skip"
+)
+
+// Self-test check names, in the order they run.
+const (
+	CheckConnectivity   = "connectivity"
+	CheckAuth           = "auth"
+	CheckIndices        = "indices"
+	CheckTimestampField = "timestampField"
+	CheckQuery          = "query"
+)
+
+// selfTestQueryBound is how long the self-test query may take.
+const selfTestQueryBound = 5 * time.Second
+
+// timestampField is the field entries are sorted and filtered by.
+const timestampField = "@timestamp"
+
+// SelfTestCheck is the outcome of one readiness check. Hint says how to fix
+// a failure.
+type SelfTestCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// SelfTestResult lists the readiness checks. Ready is set when all passed.
+type SelfTestResult struct {
+	Ready  bool            `json:"ready"`
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+// SelfTest checks that the adapter is ready to serve queries: the cluster
+// can be reached, accepts the credentials, has indices matching the index
+// pattern mapping the timestamp field, and answers a small query within
+// selfTestQueryBound. Failures are reported in the checks, not returned;
+// checks that cannot run after one failed are skipped.
+func (p *ElasticProvider) SelfTest(ctx context.Context) SelfTestResult {
+	var checks []SelfTestCheck
+	pass := func(name, msg string) {
+		checks = append(checks, SelfTestCheck{Name: name, Status: CheckPass, Message: msg})
+	}
+	fail := func(name, msg, hint string) {
+		checks = append(checks, SelfTestCheck{Name: name, Status: CheckFail, Message: msg, Hint: hint})
+	}
+	skip := func(after string, names ...string) {
+		for _, name := range names {
+			checks = append(checks, SelfTestCheck{Name: name, Status: CheckSkip, Message: "skipped after the " + after + " check failed"})
+		}
+	}
+
+	health, err := p.Health(ctx)
+	switch {
+	case errors.Is(err, ErrAuth):
+		pass(CheckConnectivity, "the cluster answered")
+		fail(CheckAuth, err.Error(), "check username and password, or apiKey, and that the user may monitor the cluster and read "+p.cfg.IndexPattern)
+		skip(CheckAuth, CheckIndices, CheckTimestampField, CheckQuery)
+		return SelfTestResult{Checks: checks}
+	case err != nil:
+		fail(CheckConnectivity, err.Error(), "check addresses, or cloudId, and that the cluster is reachable from the plugin")
+		skip(CheckConnectivity, CheckAuth, CheckIndices, CheckTimestampField, CheckQuery)
+		return SelfTestResult{Checks: checks}
+	}
+	pass(CheckConnectivity, fmt.Sprintf("cluster %s is %s", health.ClusterName, health.Status))
+	pass(CheckAuth, "the credentials were accepted")
+
+	indices, err := p.ListIndices(ctx, "")
+	switch {
+	case err != nil:
+		fail(CheckIndices, err.Error(), "check that the user may read "+p.cfg.IndexPattern)
+		skip(CheckIndices, CheckTimestampField)
+	case len(indices) == 0:
+		fail(CheckIndices, fmt.Sprintf("index pattern %s matches no index", p.cfg.IndexPattern), "set indexPattern to match the log indices or data streams")
+		skip(CheckIndices, CheckTimestampField)
+	default:
+		pass(CheckIndices, fmt.Sprintf("%d indices match %s", len(indices), p.cfg.IndexPattern))
+		if fields, err := p.ListFields(ctx, ""); err != nil {
+			fail(CheckTimestampField, err.Error(), "check that the user may read the mappings of "+p.cfg.IndexPattern)
+		} else {
+			checks = append(checks, timestampCheck(fields, p.cfg.IndexPattern))
+		}
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, selfTestQueryBound)
+	defer cancel()
+	start := time.Now()
+	_, err = p.Query(queryCtx, schema.LogQuery{Start: start.Add(-15 * time.Minute), Limit: 1})
+	took := time.Since(start)
+	switch {
+	case errors.Is(err, ErrTimeout):
+		fail(CheckQuery, fmt.Sprintf("the query took over %s", selfTestQueryBound), "check the cluster's load and shard health")
+	case err != nil:
+		fail(CheckQuery, err.Error(), "check the cluster's logs for the failing search")
+	default:
+		pass(CheckQuery, fmt.Sprintf("the query took %dms", took.Milliseconds()))
+	}
+
+	result := SelfTestResult{Ready: true, Checks: checks}
+	for _, check := range checks {
+		if check.Status != CheckPass {
+			result.Ready = false
+		}
+	}
+	return result
+}
+
+// timestampCheck checks that fields map the timestamp field as a date.
+func timestampCheck(fields []FieldInfo, pattern string) SelfTestCheck {
+	check := SelfTestCheck{Name: CheckTimestampField, Status: CheckFail}
+	for _, field := range fields {
+		if field.Name != timestampField {
+			continue
+		}
+		switch field.Type {
+		case "date", "date_nanos":
+			check.Status, check.Message = CheckPass, fmt.Sprintf("field '%s' is a %s", timestampField, field.Type)
+		case fieldTypeConflict:
+			check.Message = fmt.Sprintf("field '%s' is mapped as %v across %s", timestampField, field.Types, pattern)
+			check.Hint = "map it as date in every index, or narrow indexPattern to the indices that do"
+		default:
+			check.Message = fmt.Sprintf("field '%s' is a %s, not a date", timestampField, field.Type)
+			check.Hint = "map it as date in the index template"
+		}
+		return check
+	}
+	check.Message = fmt.Sprintf("field '%s' not found in %s", timestampField, pattern)
+	check.Hint = "entries are sorted and filtered by " + timestampField + "; map the log time there, or set indexPattern to indices that have it"
+	return check
+}
@@ -0,0 +1,114 @@
+package log
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// selfTestServer answers each request of the self-test, with the response
+// for the failure under test in place of the healthy one.
+func selfTestServer(failing string) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		switch {
+		case strings.HasPrefix(req.Path, "/_cluster/health"):
+			switch failing {
+			case CheckConnectivity:
+				return 503, `{"error":{"type":"master_not_discovered_exception","reason":"no master"}}`
+			case CheckAuth:
+				return 401, `{"error":{"type":"security_exception","reason":"unable to authenticate user [elastic]"}}`
+			}
+			return 200, `{"cluster_name":"logging","status":"green","number_of_nodes":1,"number_of_data_nodes":1}`
+		case strings.HasPrefix(req.Path, "/_cat/indices"):
+			if failing == CheckIndices {
+				return 200, `[]`
+			}
+			return 200, catIndicesResponse
+		case strings.HasSuffix(req.Path, "/_field_caps"):
+			if failing == CheckTimestampField {
+				return 200, `{"fields":{"message":{"text":{"type":"text","searchable":true,"aggregatable":false}}}}`
+			}
+			return 200, planFieldCaps
+		case strings.HasSuffix(req.Path, "/_search"):
+			if failing == CheckQuery {
+				return 500, `{"error":{"type":"search_phase_execution_exception","reason":"all shards failed"}}`
+			}
+			return 200, `{"took":3,"hits":{"hits":[]}}`
+		}
+		return 404, `{}`
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, selfTestServer(""))
+	result := p.SelfTest(context.Background())
+	if !result.Ready || len(result.Checks) != 5 {
+		t.Fatalf("result = %+v, want five passing checks", result)
+	}
+	for _, check := range result.Checks {
+		if check.Status != CheckPass || check.Hint != "" {
+			t.Errorf("check = %+v, want a pass", check)
+		}
+	}
+}
+
+func TestSelfTestFailures(t *testing.T) {
+	tests := []struct {
+		failing string
+		hint    string
+		// skipped are the checks that cannot run after the failure
+		skipped []string
+	}{
+		{failing: CheckConnectivity, hint: "check addresses", skipped: []string{CheckAuth, CheckIndices, CheckTimestampField, CheckQuery}},
+		{failing: CheckAuth, hint: "check username and password", skipped: []string{CheckIndices, CheckTimestampField, CheckQuery}},
+		{failing: CheckIndices, hint: "set indexPattern", skipped: []string{CheckTimestampField}},
+		{failing: CheckTimestampField, hint: "map the log time there"},
+		{failing: CheckQuery, hint: "check the cluster's logs"},
+	}
+	for _, tt := range tests {
+		p, _ := newTestProvider(t, Config{}, selfTestServer(tt.failing))
+		result := p.SelfTest(context.Background())
+		if result.Ready || len(result.Checks) != 5 {
+			t.Errorf("%s: result = %+v, want five checks, not ready", tt.failing, result)
+			continue
+		}
+		skipped := map[string]bool{}
+		for _, name := range tt.skipped {
+			skipped[name] = true
+		}
+		for _, check := range result.Checks {
+			want := CheckPass
+			switch {
+			case check.Name == tt.failing:
+				want = CheckFail
+				if !strings.Contains(check.Hint, tt.hint) {
+					t.Errorf("%s: hint = %q, want %q", tt.failing, check.Hint, tt.hint)
+				}
+			case skipped[check.Name]:
+				want = CheckSkip
+			}
+			if check.Status != want {
+				t.Errorf("%s: check %+v, want %s", tt.failing, check, want)
+			}
+		}
+	}
+}
+
+func TestTimestampCheck(t *testing.T) {
+	tests := []struct {
+		field   FieldInfo
+		status  string
+		message string
+	}{
+		{FieldInfo{Name: "@timestamp", Type: "date_nanos"}, CheckPass, "is a date_nanos"},
+		{FieldInfo{Name: "@timestamp", Type: "keyword"}, CheckFail, "is a keyword, not a date"},
+		{FieldInfo{Name: "@timestamp", Type: fieldTypeConflict, Types: []string{"date", "keyword"}}, CheckFail, "mapped as [date keyword]"},
+		{FieldInfo{Name: "timestamp", Type: "date"}, CheckFail, "field '@timestamp' not found in logs-*"},
+	}
+	for _, tt := range tests {
+		check := timestampCheck([]FieldInfo{tt.field}, "logs-*")
+		if check.Status != tt.status || !strings.Contains(check.Message, tt.message) {
+			t.Errorf("%+v: check = %+v, want %s with %q", tt.field, check, tt.status, tt.message)
+		}
+	}
+}
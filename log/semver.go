@@ -0,0 +1,195 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrIncompatibleCore means OpsOrch Core's version does not satisfy
+// RequiresCore.
+var ErrIncompatibleCore = errors.New("incompatible OpsOrch Core version")
+
+// CoreVersionError reports a core version outside RequiresCore. It is
+// ErrIncompatibleCore.
+type CoreVersionError struct {
+	CoreVersion  string
+	RequiresCore string
+	// err explains a version or constraint that could not be parsed.
+	err error
+}
+
+func (e *CoreVersionError) Error() string {
+	msg := fmt.Sprintf("OpsOrch Core %s is not supported by the elastic adapter %s, which requires core %s", e.CoreVersion, AdapterVersion, e.RequiresCore)
+	if e.err != nil {
+		msg += ": " + e.err.Error()
+	}
+	return msg
+}
+
+func (e *CoreVersionError) Is(target error) bool { return target == ErrIncompatibleCore }
+
+// CheckCoreVersion returns a *CoreVersionError unless coreVersion satisfies
+// RequiresCore.
+func CheckCoreVersion(coreVersion string) error {
+	ok, err := satisfiesConstraint(coreVersion, RequiresCore)
+	if ok {
+		return nil
+	}
+	return &CoreVersionError{CoreVersion: coreVersion, RequiresCore: RequiresCore, err: err}
+}
+
+// semver is a parsed semantic version. Build metadata is dropped, as it
+// does not affect precedence.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses MAJOR.MINOR.PATCH with an optional v prefix,
+// pre-release and build metadata. Missing minor and patch numbers are zero.
+func parseSemver(s string) (semver, error) {
+	var v semver
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.pre = strings.Split(rest[i+1:], ".")
+		rest = rest[:i]
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// compare orders versions by semver precedence, returning -1, 0 or 1.
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	// A pre-release precedes its release
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		if c := comparePreRelease(v.pre[i], o.pre[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(v.pre) - len(o.pre))
+}
+
+// comparePreRelease orders pre-release identifiers: numeric ones
+// numerically and before alphanumeric ones, which are compared as text.
+func comparePreRelease(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return sign(an - bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// satisfiesConstraint reports whether version meets constraint: ranges
+// separated by ||, each a space-separated list of comparisons that must
+// all hold. Comparisons are =, >, >=, < and <=, plus ^ (same major, or
+// same minor before 1.0) and ~ (same minor). A bare version means =.
+func satisfiesConstraint(version, constraint string) (bool, error) {
+	v, err := parseSemver(version)
+	if err != nil {
+		return false, err
+	}
+	for _, alternative := range strings.Split(constraint, "||") {
+		terms := strings.Fields(alternative)
+		if len(terms) == 0 {
+			return false, fmt.Errorf("invalid constraint %q", constraint)
+		}
+		all := true
+		for _, term := range terms {
+			ok, err := satisfiesTerm(v, term)
+			if err != nil {
+				return false, err
+			}
+			all = all && ok
+		}
+		if all {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func satisfiesTerm(v semver, term string) (bool, error) {
+	i := strings.IndexFunc(term, func(r rune) bool { return r == 'v' || unicode.IsDigit(r) })
+	if i < 0 {
+		return false, fmt.Errorf("invalid constraint %q", term)
+	}
+	op := term[:i]
+	bound, err := parseSemver(term[i:])
+	if err != nil {
+		return false, err
+	}
+	c := v.compare(bound)
+	switch op {
+	case "", "=":
+		return c == 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "^":
+		// Changes left of the first non-zero number are breaking
+		if c < 0 {
+			return false, nil
+		}
+		switch {
+		case bound.major > 0:
+			return v.major == bound.major, nil
+		case bound.minor > 0:
+			return v.major == 0 && v.minor == bound.minor, nil
+		}
+		return v.major == 0 && v.minor == 0 && v.patch == bound.patch, nil
+	case "~":
+		return c >= 0 && v.major == bound.major && v.minor == bound.minor, nil
+	}
+	return false, fmt.Errorf("invalid operator %q in constraint", op)
+}
@@ -0,0 +1,78 @@
+package log
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSatisfiesConstraint(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		want       bool
+	}{
+		{"0.1.0", ">=0.1.0", true},
+		{"0.2.3", ">=0.1.0", true},
+		{"v1.0.0", ">=0.1.0", true},
+		{"0.0.9", ">=0.1.0", false},
+		{"0.1.0-rc.1", ">=0.1.0", false},
+		{"0.1.0+build.7", ">=0.1.0", true},
+		{"0.1", ">=0.1.0", true},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.4", "=1.2.3", false},
+		{"1.2.3", ">1.2.3", false},
+		{"1.2.3", "<=1.2.3", true},
+		{"1.2.3", "<1.2.3", false},
+		{"1.5.0", ">=1.2.0 <2.0.0", true},
+		{"2.0.0", ">=1.2.0 <2.0.0", false},
+		{"2.0.0-alpha", ">=1.2.0 <2.0.0", true},
+		{"0.9.0", "<0.5.0 || >=0.8.0", true},
+		{"0.6.0", "<0.5.0 || >=0.8.0", false},
+		{"1.9.0", "^1.2.0", true},
+		{"2.0.0", "^1.2.0", false},
+		{"1.1.0", "^1.2.0", false},
+		{"0.2.5", "^0.2.0", true},
+		{"0.3.0", "^0.2.0", false},
+		{"0.0.3", "^0.0.3", true},
+		{"0.0.4", "^0.0.3", false},
+		{"1.2.9", "~1.2.0", true},
+		{"1.3.0", "~1.2.0", false},
+		{"1.0.0-alpha.1", ">1.0.0-alpha", true},
+		{"1.0.0-alpha.beta", ">1.0.0-alpha.1", true},
+		{"1.0.0-rc.2", "<1.0.0-rc.10", true},
+	}
+	for _, tt := range tests {
+		got, err := satisfiesConstraint(tt.version, tt.constraint)
+		if err != nil || got != tt.want {
+			t.Errorf("satisfiesConstraint(%q, %q) = %v, %v; want %v", tt.version, tt.constraint, got, err, tt.want)
+		}
+	}
+}
+
+func TestSatisfiesConstraintInvalid(t *testing.T) {
+	for _, tt := range []struct{ version, constraint string }{
+		{"latest", ">=0.1.0"},
+		{"1.2.3.4", ">=0.1.0"},
+		{"1.0.0", ">=x"},
+		{"1.0.0", "=>1.0.0"},
+		{"1.0.0", ""},
+	} {
+		if _, err := satisfiesConstraint(tt.version, tt.constraint); err == nil {
+			t.Errorf("satisfiesConstraint(%q, %q): want an error", tt.version, tt.constraint)
+		}
+	}
+}
+
+func TestCheckCoreVersion(t *testing.T) {
+	if err := CheckCoreVersion("0.4.2"); err != nil {
+		t.Errorf("err = %v, want 0.4.2 accepted", err)
+	}
+	for _, version := range []string{"0.0.1", "nightly"} {
+		err := CheckCoreVersion(version)
+		var incompatible *CoreVersionError
+		if !errors.Is(err, ErrIncompatibleCore) || !errors.As(err, &incompatible) || !strings.Contains(err.Error(), version) || !strings.Contains(err.Error(), RequiresCore) {
+			t.Errorf("err = %v, want an incompatible core error naming both versions", err)
+		}
+	}
+}
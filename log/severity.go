@@ -0,0 +1,236 @@
+package log
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Numeric severity schemes supported in severityNumberFields.
+const (
+	severitySchemeOTEL   = "otel"
+	severitySchemeSyslog = "syslog"
+)
+
+// severityRange maps an inclusive range of numeric severities to a canonical name.
+type severityRange struct {
+	Name string
+	Min  int
+	Max  int
+}
+
+// severityTables is the single source of truth for numeric severities. Both
+// normalizeHit (number -> name) and buildQuery (name -> number range) use it,
+// so a severity that is displayed as "error" is also matched by "error".
+var severityTables = map[string][]severityRange{
+	// OpenTelemetry SeverityNumber, see the logs data model.
+	severitySchemeOTEL: {
+		{Name: "trace", Min: 1, Max: 4},
+		{Name: "debug", Min: 5, Max: 8},
+		{Name: "info", Min: 9, Max: 12},
+		{Name: "warn", Min: 13, Max: 16},
+		{Name: "error", Min: 17, Max: 20},
+		{Name: "fatal", Min: 21, Max: 24},
+	},
+	// RFC 5424 syslog severity, where lower numbers are more severe.
+	severitySchemeSyslog: {
+		{Name: "emergency", Min: 0, Max: 0},
+		{Name: "alert", Min: 1, Max: 1},
+		{Name: "critical", Min: 2, Max: 2},
+		{Name: "error", Min: 3, Max: 3},
+		{Name: "warn", Min: 4, Max: 4},
+		{Name: "notice", Min: 5, Max: 5},
+		{Name: "info", Min: 6, Max: 6},
+		{Name: "debug", Min: 7, Max: 7},
+	},
+}
+
+// defaultSeverityNumberFields maps numeric severity fields to their scheme
+// when no severityNumberFields are configured.
+var defaultSeverityNumberFields = map[string]string{
+	"severity_number": severitySchemeOTEL,
+	"syslog.severity": severitySchemeSyslog,
+}
+
+// defaultSeverityFields are consulted in order for the entry severity.
+var defaultSeverityFields = []string{"severity", "level", "log.level", "syslog.severity_label", "severity_number"}
+
+// severityAliases folds common spellings onto the canonical names used in
+// severityTables.
+var severityAliases = map[string]string{
+	"warning": "warn",
+	"err":     "error",
+	"crit":    "critical",
+	"emerg":   "emergency",
+}
+
+// canonicalSeverity lowercases a severity name and resolves aliases.
+func canonicalSeverity(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := severityAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// severityNumberFields returns the configured numeric severity fields and the
+// field names in a stable order.
+func (p *ElasticProvider) severityNumberFields() (map[string]string, []string) {
+	schemes := p.cfg.SeverityNumberFields
+	if len(schemes) == 0 {
+		schemes = defaultSeverityNumberFields
+	}
+	fields := make([]string, 0, len(schemes))
+	for field := range schemes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return schemes, fields
+}
+
+// severityFields returns the candidate fields for the entry severity.
+func (p *ElasticProvider) severityFields() []string {
+	if len(p.cfg.SeverityFields) > 0 {
+		return p.cfg.SeverityFields
+	}
+	return defaultSeverityFields
+}
+
+// entrySeverity returns the severity of a flattened document and the field
+// it was read from. The first candidate field with a severity wins: a field
+// in severityNumberFields is mapped to a name, other strings are folded by
+// canonicalSeverity and other numbers kept as written. Without one, the remaining numeric
+// severity fields are tried.
+func (p *ElasticProvider) entrySeverity(source map[string]any) (string, string) {
+	schemes, numberFields := p.severityNumberFields()
+	for _, field := range p.severityFields() {
+		value := source[field]
+		if scheme, ok := schemes[field]; ok {
+			if name, ok := severityFromNumber(scheme, value); ok {
+				return name, field
+			}
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				return canonicalSeverity(v), field
+			}
+		case json.Number, float64, int, int64:
+			severity, _ := scalarString(v)
+			return severity, field
+		}
+	}
+	for _, field := range numberFields {
+		if name, ok := severityFromNumber(schemes[field], source[field]); ok {
+			return name, field
+		}
+	}
+	return "", ""
+}
+
+// severityFromNumber maps a numeric severity to its canonical name.
+func severityFromNumber(scheme string, value any) (string, bool) {
+	number, ok := severityNumber(value)
+	if !ok {
+		return "", false
+	}
+	for _, r := range severityTables[scheme] {
+		if number >= r.Min && number <= r.Max {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+// severityNumber reads an integral severity from a JSON number or numeric string.
+func severityNumber(value any) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	case json.Number:
+		n, err := strconv.Atoi(v.String())
+		return n, err == nil
+	default:
+		return intValue(v)
+	}
+}
+
+// severityClause matches documents whose severity, read from any string
+// severity field or mapped from a numeric one, folds onto one of names.
+// Terms are case-sensitive, so each string field is matched against every
+// spelling from severitySpellings.
+func (p *ElasticProvider) severityClause(names []string) map[string]any {
+	schemes, fields := p.severityNumberFields()
+	spellings := severitySpellings(names)
+	var should []map[string]any
+	for _, field := range p.severityFields() {
+		if _, ok := schemes[field]; ok {
+			continue
+		}
+		should = append(should, map[string]any{"terms": map[string]any{field: spellings}})
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[canonicalSeverity(name)] = true
+	}
+
+	for _, field := range fields {
+		for _, r := range severityTables[schemes[field]] {
+			if !wanted[r.Name] {
+				continue
+			}
+			should = append(should, map[string]any{
+				"range": map[string]any{
+					field: map[string]any{"gte": r.Min, "lte": r.Max},
+				},
+			})
+		}
+	}
+
+	return map[string]any{
+		"bool": map[string]any{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+// severitySpellings returns names as given plus, for each canonical name, the
+// name and its aliases in lower, upper and title case, so that a filter for
+// "warn" also matches documents that store "WARNING" or "Warn".
+func severitySpellings(names []string) []string {
+	var spellings []string
+	seen := map[string]bool{}
+	add := func(spelling string) {
+		if spelling != "" && !seen[spelling] {
+			seen[spelling] = true
+			spellings = append(spellings, spelling)
+		}
+	}
+	for _, name := range names {
+		add(name)
+	}
+	for _, name := range names {
+		canonical := canonicalSeverity(name)
+		if canonical == "" {
+			continue
+		}
+		forms := []string{canonical}
+		for alias, target := range severityAliases {
+			if target == canonical {
+				forms = append(forms, alias)
+			}
+		}
+		sort.Strings(forms[1:])
+		for _, form := range forms {
+			add(form)
+			add(strings.ToUpper(form))
+			add(strings.ToUpper(form[:1]) + form[1:])
+		}
+	}
+	return spellings
+}
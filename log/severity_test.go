@@ -0,0 +1,250 @@
+package log
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestSeverityFromNumberOTELBoundaries(t *testing.T) {
+	tests := []struct {
+		number int
+		want   string
+		ok     bool
+	}{
+		{number: 0, ok: false},
+		{number: 1, want: "trace", ok: true},
+		{number: 4, want: "trace", ok: true},
+		{number: 5, want: "debug", ok: true},
+		{number: 8, want: "debug", ok: true},
+		{number: 9, want: "info", ok: true},
+		{number: 12, want: "info", ok: true},
+		{number: 13, want: "warn", ok: true},
+		{number: 16, want: "warn", ok: true},
+		{number: 17, want: "error", ok: true},
+		{number: 20, want: "error", ok: true},
+		{number: 21, want: "fatal", ok: true},
+		{number: 24, want: "fatal", ok: true},
+		{number: 25, ok: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := severityFromNumber(severitySchemeOTEL, json.Number(strconv.Itoa(tt.number)))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("severityFromNumber(otel, %d) = %q, %v; want %q, %v", tt.number, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSeverityFromNumberSyslog(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{value: float64(0), want: "emergency"},
+		{value: float64(3), want: "error"},
+		{value: "4", want: "warn"},
+		{value: 7, want: "debug"},
+	}
+
+	for _, tt := range tests {
+		got, ok := severityFromNumber(severitySchemeSyslog, tt.value)
+		if !ok || got != tt.want {
+			t.Errorf("severityFromNumber(syslog, %v) = %q, %v; want %q", tt.value, got, ok, tt.want)
+		}
+	}
+	if _, ok := severityFromNumber(severitySchemeSyslog, float64(8)); ok {
+		t.Error("syslog severity 8 should be out of range")
+	}
+}
+
+func TestNormalizeHitNumericSeverity(t *testing.T) {
+	tests := []struct {
+		name   string
+		source map[string]interface{}
+		want   string
+	}{
+		{name: "otel severity_number", source: map[string]interface{}{"severity_number": json.Number("17")}, want: "error"},
+		{name: "nested syslog severity", source: map[string]interface{}{"syslog": map[string]interface{}{"severity": float64(4)}}, want: "warn"},
+		{name: "string severity wins", source: map[string]interface{}{"severity": "info", "severity_number": json.Number("21")}, want: "info"},
+		{name: "out of range", source: map[string]interface{}{"severity_number": json.Number("99")}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := normalizeHit(&ElasticProvider{}, esHit{Source: tt.source})
+			if entry.Severity != tt.want {
+				t.Errorf("severity = %q, want %q", entry.Severity, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHitSeverityCandidates(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		source    map[string]any
+		want      string
+		wantField string
+	}{
+		{name: "severity string", source: map[string]any{"severity": "ERROR"}, want: "error", wantField: "severity"},
+		{name: "level string", source: map[string]any{"level": "Warning"}, want: "warn", wantField: "level"},
+		{name: "alias upper case", source: map[string]any{"severity": "WARNING"}, want: "warn", wantField: "severity"},
+		{name: "level number", source: map[string]any{"level": json.Number("50")}, want: "50", wantField: "level"},
+		{name: "ecs log.level", source: map[string]any{"log": map[string]any{"level": "INFO"}}, want: "info", wantField: "log.level"},
+		{name: "syslog label", source: map[string]any{"syslog": map[string]any{"severity_label": "Notice"}}, want: "notice", wantField: "syslog.severity_label"},
+		{name: "otel number", source: map[string]any{"severity_number": json.Number("9")}, want: "info", wantField: "severity_number"},
+		{name: "otel numeric string", source: map[string]any{"severity_number": "13"}, want: "warn", wantField: "severity_number"},
+		{name: "precedence", source: map[string]any{"level": "debug", "log": map[string]any{"level": "error"}}, want: "debug", wantField: "level"},
+		{name: "empty string skipped", source: map[string]any{"severity": "", "level": "info"}, want: "info", wantField: "level"},
+		{name: "object skipped", source: map[string]any{"severity": map[string]any{"text": "x"}, "level": "info"}, want: "info", wantField: "level"},
+		{name: "syslog number fallback", source: map[string]any{"syslog": map[string]any{"severity": json.Number("3")}}, want: "error", wantField: "syslog.severity"},
+		{name: "configured", cfg: Config{SeverityFields: []string{"lvl"}}, source: map[string]any{"lvl": "FATAL", "severity": "info"}, want: "fatal", wantField: "lvl"},
+		{name: "none", source: map[string]any{"message": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := normalizeHit(&ElasticProvider{cfg: tt.cfg}, esHit{Source: tt.source})
+			if entry.Severity != tt.want {
+				t.Errorf("severity = %q, want %q", entry.Severity, tt.want)
+			}
+			if _, ok := entry.Fields[tt.wantField]; ok {
+				t.Errorf("fields = %v, want %s removed", entry.Fields, tt.wantField)
+			}
+		})
+	}
+
+	// Candidates the severity did not come from stay in the fields, and
+	// level is a label like any other field
+	p := &ElasticProvider{cfg: Config{LabelFields: []string{"level"}}}
+	entry := normalizeHit(p, esHit{Source: map[string]any{"severity": "error", "level": "30"}})
+	if entry.Severity != "error" || entry.Fields["level"] != "30" || entry.Labels["level"] != "30" {
+		t.Errorf("entry = %+v, want level kept in fields and labels", entry)
+	}
+}
+
+func TestSeverityClauseMatchesNumericRanges(t *testing.T) {
+	p := &ElasticProvider{}
+	esQuery := p.buildQuery(schema.LogQuery{
+		Expression: &schema.LogExpression{SeverityIn: []string{"error", "Warning"}},
+	})
+	must := esQuery["query"].(map[string]any)["bool"].(map[string]any)["must"].([]map[string]any)
+	group := must[0]["bool"].(map[string]any)
+	should := group["should"].([]map[string]any)
+
+	if group["minimum_should_match"] != 1 {
+		t.Errorf("minimum_should_match = %v, want 1", group["minimum_should_match"])
+	}
+	terms := should[0]["terms"].(map[string]any)["severity"].([]string)
+	if len(terms) < 2 || terms[0] != "error" || terms[1] != "Warning" {
+		t.Errorf("terms = %v, want the requested names first", terms)
+	}
+
+	type bounds struct{ field, min, max string }
+	got := map[bounds]bool{}
+	for _, clause := range should {
+		ranges, ok := clause["range"].(map[string]any)
+		if !ok {
+			continue
+		}
+		for field, r := range ranges {
+			rng := r.(map[string]any)
+			got[bounds{field, strconv.Itoa(rng["gte"].(int)), strconv.Itoa(rng["lte"].(int))}] = true
+		}
+	}
+	want := []bounds{
+		{"severity_number", "13", "16"},
+		{"severity_number", "17", "20"},
+		{"syslog.severity", "3", "3"},
+		{"syslog.severity", "4", "4"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("range clauses = %v, want %v", got, want)
+	}
+	for _, w := range want {
+		if !got[w] {
+			t.Errorf("missing range clause %+v", w)
+		}
+	}
+}
+
+func TestSeverityTableRoundTrip(t *testing.T) {
+	// Every number that normalizes to a name must be matched by a query for that name.
+	p := &ElasticProvider{cfg: Config{SeverityNumberFields: map[string]string{"severity_number": severitySchemeOTEL}}}
+	for n := 1; n <= 24; n++ {
+		name, ok := severityFromNumber(severitySchemeOTEL, n)
+		if !ok {
+			t.Fatalf("severity %d did not map to a name", n)
+		}
+		should := p.severityClause([]string{name})["bool"].(map[string]any)["should"].([]map[string]any)
+		matched := false
+		for _, clause := range should {
+			ranges, ok := clause["range"].(map[string]any)
+			if !ok {
+				continue
+			}
+			rng := ranges["severity_number"].(map[string]any)
+			if n >= rng["gte"].(int) && n <= rng["lte"].(int) {
+				matched = true
+			}
+		}
+		if !matched {
+			t.Errorf("query for %q does not match severity_number %d", name, n)
+		}
+	}
+}
+
+func TestSeverityClauseMatchesDisplayedSeverity(t *testing.T) {
+	// A document is found again by the severity it is displayed with, whatever
+	// candidate field and case it stores the severity in.
+	tests := []struct {
+		name   string
+		cfg    Config
+		source map[string]any
+	}{
+		{name: "ecs log.level upper case", source: map[string]any{"log.level": "ERROR"}},
+		{name: "level alias", source: map[string]any{"level": "WARNING"}},
+		{name: "level title case", source: map[string]any{"level": "Info"}},
+		{name: "syslog label", source: map[string]any{"syslog.severity_label": "Crit"}},
+		{name: "configured field", cfg: Config{SeverityFields: []string{"lvl"}}, source: map[string]any{"lvl": "Debug"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ElasticProvider{cfg: tt.cfg}
+			severity, _ := p.entrySeverity(tt.source)
+			if severity == "" {
+				t.Fatalf("no severity read from %v", tt.source)
+			}
+			should := p.severityClause([]string{severity})["bool"].(map[string]any)["should"].([]map[string]any)
+			if !anyTermsMatch(should, tt.source) {
+				t.Errorf("query for %q = %v, does not match %v", severity, should, tt.source)
+			}
+			other := p.severityClause([]string{"fatal"})["bool"].(map[string]any)["should"].([]map[string]any)
+			if anyTermsMatch(other, tt.source) {
+				t.Errorf("query for fatal matches %v", tt.source)
+			}
+		})
+	}
+}
+
+// anyTermsMatch reports whether a terms clause in should matches a keyword
+// value of the flattened source exactly, as Elasticsearch would.
+func anyTermsMatch(should []map[string]any, source map[string]any) bool {
+	for _, clause := range should {
+		terms, ok := clause["terms"].(map[string]any)
+		if !ok {
+			continue
+		}
+		for field, values := range terms {
+			for _, value := range values.([]string) {
+				if source[field] == value {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
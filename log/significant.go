@@ -0,0 +1,109 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// SignificantTerm is a field value over-represented among the logs matching
+// a query compared with the background.
+type SignificantTerm struct {
+	Term any `json:"term"`
+	// DocCount is the number of matching logs with the value, BgCount the
+	// number of background logs with it.
+	DocCount int64   `json:"docCount"`
+	BgCount  int64   `json:"bgCount"`
+	Score    float64 `json:"score"`
+}
+
+// defaultSignificantTerms is how many terms SignificantTerms returns.
+const defaultSignificantTerms = 10
+
+// backgroundQuery returns query without its search and severity
+// constraints: the same time range, scope and filters, against which the
+// foreground is compared.
+func backgroundQuery(query schema.LogQuery) schema.LogQuery {
+	if query.Expression == nil {
+		return query
+	}
+	expression := *query.Expression
+	expression.Search = ""
+	expression.SeverityIn = nil
+	query.Expression = &expression
+	return query
+}
+
+// buildSignificantTermsQuery constructs a size 0 search whose
+// significant_terms aggregation compares field's values among the logs
+// matching query with the background.
+func (p *ElasticProvider) buildSignificantTermsQuery(query schema.LogQuery, field string) map[string]any {
+	return map[string]any{
+		"query": p.boolQuery(query),
+		"size":  0,
+		"aggs": map[string]any{
+			"significant": map[string]any{
+				"significant_terms": map[string]any{
+					"field":             field,
+					"size":              defaultSignificantTerms,
+					"background_filter": p.boolQuery(backgroundQuery(query)),
+				},
+			},
+		},
+	}
+}
+
+// SignificantTerms returns the values of a field that are unusually common
+// among the logs matching a query, such as the one pod behind an error
+// spike, most significant first. The background is the same window and
+// scope without the query's search and severity constraints. Text fields
+// are aggregated on their keyword sub-field.
+func (p *ElasticProvider) SignificantTerms(ctx context.Context, query schema.LogQuery, field string) ([]SignificantTerm, error) {
+	ctx = withAudit(ctx, "log.significantTerms", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return nil, err
+	}
+	if field == "" {
+		return nil, errors.New("field is required")
+	}
+
+	terms, err := p.significantTerms(ctx, query, field)
+	// Text fields cannot be aggregated; dynamic mappings keep a keyword copy
+	if err != nil && isTextFieldError(err) && !strings.HasSuffix(field, keywordSuffix) {
+		return p.significantTerms(ctx, query, field+keywordSuffix)
+	}
+	return terms, err
+}
+
+func (p *ElasticProvider) significantTerms(ctx context.Context, query schema.LogQuery, field string) ([]SignificantTerm, error) {
+	var result struct {
+		esSearchResponse
+		Aggregations struct {
+			Significant struct {
+				Buckets []struct {
+					Key         any     `json:"key"`
+					KeyAsString string  `json:"key_as_string"`
+					DocCount    int64   `json:"doc_count"`
+					BgCount     int64   `json:"bg_count"`
+					Score       float64 `json:"score"`
+				} `json:"buckets"`
+			} `json:"significant"`
+		} `json:"aggregations"`
+	}
+	if err := p.searchInto(ctx, p.buildSignificantTermsQuery(query, field), &result); err != nil {
+		return nil, err
+	}
+
+	buckets := result.Aggregations.Significant.Buckets
+	terms := make([]SignificantTerm, 0, len(buckets))
+	for _, b := range buckets {
+		term := b.Key
+		if b.KeyAsString != "" {
+			term = b.KeyAsString
+		}
+		terms = append(terms, SignificantTerm{Term: term, DocCount: b.DocCount, BgCount: b.BgCount, Score: b.Score})
+	}
+	return terms, nil
+}
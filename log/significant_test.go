@@ -0,0 +1,89 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestBuildSignificantTermsQuery(t *testing.T) {
+	p := &ElasticProvider{}
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	query := schema.LogQuery{
+		Start: start,
+		End:   start.Add(time.Hour),
+		Scope: schema.QueryScope{Service: "checkout"},
+		Expression: &schema.LogExpression{
+			Search:     "timeout",
+			SeverityIn: []string{"error"},
+			Filters:    []schema.LogFilter{{Field: "region", Operator: "=", Value: "eu"}},
+		},
+	}
+
+	body := p.buildSignificantTermsQuery(query, "kubernetes.pod.name")
+	foreground, _ := json.Marshal(body["query"])
+	if want, _ := json.Marshal(p.boolQuery(query)); string(foreground) != string(want) {
+		t.Errorf("query = %s, want the search query %s", foreground, want)
+	}
+	if query.Expression.Search != "timeout" || len(query.Expression.SeverityIn) != 1 {
+		t.Error("building the background changed the query's expression")
+	}
+
+	agg := body["aggs"].(map[string]any)["significant"].(map[string]any)["significant_terms"].(map[string]any)
+	if agg["field"] != "kubernetes.pod.name" {
+		t.Errorf("field = %v, want kubernetes.pod.name", agg["field"])
+	}
+	background, _ := json.Marshal(agg["background_filter"])
+	for _, want := range []string{`"range"`, `"checkout"`, `"region"`} {
+		if !strings.Contains(string(background), want) {
+			t.Errorf("background = %s, want it to keep %s", background, want)
+		}
+	}
+	for _, unwanted := range []string{"timeout", `"error"`} {
+		if strings.Contains(string(background), unwanted) {
+			t.Errorf("background = %s, want it without %s", background, unwanted)
+		}
+	}
+}
+
+func TestSignificantTerms(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		if strings.Contains(req.Body, `"field":"customer"`) {
+			return 400, `{"error":{"type":"illegal_argument_exception","reason":"Text fields are not optimised for operations that require per-document field data"}}`
+		}
+		return 200, `{"hits":{"hits":[]},"aggregations":{"significant":{"doc_count":1200,"bg_count":250000,"buckets":[
+			{"key":"acme","doc_count":900,"score":12.5,"bg_count":1100},
+			{"key":"globex","doc_count":150,"score":0.75,"bg_count":9000}
+		]}}}`
+	})
+
+	terms, err := p.SignificantTerms(context.Background(), schema.LogQuery{}, "customer")
+	if err != nil {
+		t.Fatalf("significant terms failed: %v", err)
+	}
+	want := []SignificantTerm{
+		{Term: "acme", DocCount: 900, BgCount: 1100, Score: 12.5},
+		{Term: "globex", DocCount: 150, BgCount: 9000, Score: 0.75},
+	}
+	if len(terms) != len(want) {
+		t.Fatalf("terms = %+v, want %+v", terms, want)
+	}
+	for i := range want {
+		if terms[i] != want[i] {
+			t.Errorf("term %d = %+v, want %+v", i, terms[i], want[i])
+		}
+	}
+
+	requests := transport.recorded()
+	if len(requests) != 2 || !strings.Contains(requests[1].Body, `"field":"customer.keyword"`) {
+		t.Errorf("requests = %d, want a retry on customer.keyword", len(requests))
+	}
+
+	if _, err := p.SignificantTerms(context.Background(), schema.LogQuery{}, ""); err == nil {
+		t.Error("significant terms accepted an empty field")
+	}
+}
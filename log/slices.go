@@ -0,0 +1,174 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// maxParallelism bounds the number of slices read concurrently.
+const maxParallelism = 32
+
+// parallelism returns the number of slices a stream is split into.
+func (p *ElasticProvider) parallelism() int {
+	return min(p.cfg.Parallelism, maxParallelism)
+}
+
+// streamSlices reads a query as a sliced scroll, one worker per slice. With
+// Ordered set, the slices' batches are merged by timestamp in the query's
+// order and delivered in pageSize batches; otherwise each slice's batches
+// are delivered as they arrive. The first slice or fn error cancels the
+// other slices and is returned.
+func (p *ElasticProvider) streamSlices(parent context.Context, query schema.LogQuery, slices int, fn func(batch []schema.LogEntry) error) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// Ordered merging needs each slice's batches separately; unordered
+	// delivery shares one channel closed once every worker is done.
+	channels := make([]chan []schema.LogEntry, slices)
+	shared := make(chan []schema.LogEntry, slices)
+	for i := range channels {
+		if p.cfg.Ordered {
+			channels[i] = make(chan []schema.LogEntry, 1)
+		} else {
+			channels[i] = shared
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < slices; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			out := channels[id]
+			if p.cfg.Ordered {
+				defer close(out)
+			}
+			slice := map[string]any{"id": id, "max": slices}
+			_, err := p.scroll(ctx, query, nil, slice, query.Limit, 0, func(batch []schema.LogEntry) error {
+				select {
+				case out <- append([]schema.LogEntry(nil), batch...):
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				fail(fmt.Errorf("slice %d: %w", id, err))
+			}
+		}(i)
+	}
+	if !p.cfg.Ordered {
+		go func() {
+			wg.Wait()
+			close(shared)
+		}()
+	}
+
+	delivered := 0
+	deliver := func(batch []schema.LogEntry) bool {
+		if query.Limit > 0 {
+			batch = batch[:min(len(batch), query.Limit-delivered)]
+		}
+		delivered += len(batch)
+		if p.cfg.DedupeResults {
+			batch = dedupeConsecutive(batch)
+		}
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				fail(err)
+				return false
+			}
+		}
+		return query.Limit == 0 || delivered < query.Limit
+	}
+
+	if p.cfg.Ordered {
+		order, _ := queryOrder(query)
+		mergeSlices(channels, p.pageSize(), order == orderAsc, deliver)
+	} else {
+		for batch := range shared {
+			if !deliver(batch) {
+				break
+			}
+		}
+	}
+
+	// Stop any remaining workers and wait for them to clear their scrolls
+	cancel()
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return parent.Err()
+}
+
+// mergeSlices merges the slices' batches, each already sorted by timestamp,
+// into one sequence passed to deliver in batches of batchSize. It stops when
+// every slice is exhausted or deliver returns false.
+func mergeSlices(channels []chan []schema.LogEntry, batchSize int, asc bool, deliver func([]schema.LogEntry) bool) {
+	heads := make([][]schema.LogEntry, len(channels))
+	open := make([]bool, len(channels))
+	refill := func(i int) {
+		for len(heads[i]) == 0 {
+			batch, ok := <-channels[i]
+			if !ok {
+				open[i] = false
+				return
+			}
+			heads[i] = batch
+		}
+	}
+	for i := range channels {
+		open[i] = true
+		refill(i)
+	}
+
+	out := make([]schema.LogEntry, 0, batchSize)
+	for {
+		next := -1
+		for i := range heads {
+			if !open[i] {
+				continue
+			}
+			if next == -1 {
+				next = i
+				continue
+			}
+			ts, best := heads[i][0].Timestamp, heads[next][0].Timestamp
+			if (asc && ts.Before(best)) || (!asc && ts.After(best)) {
+				next = i
+			}
+		}
+		if next == -1 {
+			break
+		}
+
+		out = append(out, heads[next][0])
+		heads[next] = heads[next][1:]
+		if len(heads[next]) == 0 {
+			refill(next)
+		}
+		if len(out) == batchSize {
+			if !deliver(out) {
+				return
+			}
+			out = make([]schema.LogEntry, 0, batchSize)
+		}
+	}
+	if len(out) > 0 {
+		deliver(out)
+	}
+}
@@ -0,0 +1,193 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// slicedScrollServer fakes a three-slice scroll over docs 1..9, where doc d
+// has timestamp 12:00:0d and slice i holds docs 9-i, 6-i, 3-i. Each slice's
+// first search blocks until all three slices have started, so the test
+// fails unless the slices run concurrently. Scrolls of failSlice fail.
+func slicedScrollServer(t *testing.T, failSlice int) (func(req recordedRequest) (int, string), *int32) {
+	const slices = 3
+	var mu sync.Mutex
+	started := 0
+	allStarted := make(chan struct{})
+	positions := map[int]int{}
+	var cleared int32
+
+	batch := func(slice int) string {
+		mu.Lock()
+		defer mu.Unlock()
+		docs := []int{9 - slice, 6 - slice, 3 - slice}
+		hits := []string{}
+		for pos := positions[slice]; pos < len(docs) && len(hits) < 2; pos++ {
+			d := docs[pos]
+			hits = append(hits, fmt.Sprintf(`{"_id":"doc-%d","_source":{"@timestamp":"2023-10-01T12:00:0%dZ","message":"doc-%d"}}`, d, d, d))
+			positions[slice] = pos + 1
+		}
+		return fmt.Sprintf(`{"_scroll_id":"slice-%d","hits":{"hits":[%s]}}`, slice, strings.Join(hits, ","))
+	}
+
+	return func(req recordedRequest) (int, string) {
+		switch {
+		case req.Path == "/logs-*/_search":
+			var body struct {
+				Slice struct {
+					ID  int `json:"id"`
+					Max int `json:"max"`
+				} `json:"slice"`
+			}
+			if err := json.Unmarshal([]byte(req.Body), &body); err != nil || body.Slice.Max != slices {
+				t.Errorf("search body = %s, want a slice of %d", req.Body, slices)
+			}
+
+			mu.Lock()
+			started++
+			if started == slices {
+				close(allStarted)
+			}
+			mu.Unlock()
+			select {
+			case <-allStarted:
+			case <-time.After(2 * time.Second):
+				t.Errorf("slice %d: other slices did not start concurrently", body.Slice.ID)
+			}
+			return 200, batch(body.Slice.ID)
+		case req.Method == "POST" && req.Path == "/_search/scroll":
+			var body struct {
+				ScrollID string `json:"scroll_id"`
+			}
+			_ = json.Unmarshal([]byte(req.Body), &body)
+			var slice int
+			fmt.Sscanf(body.ScrollID, "slice-%d", &slice)
+			if slice == failSlice {
+				return 400, `{"error":{"type":"illegal_argument_exception","reason":"slice failed"}}`
+			}
+			return 200, batch(slice)
+		case req.Method == "DELETE" && req.Path == "/_search/scroll":
+			mu.Lock()
+			cleared++
+			mu.Unlock()
+			return 200, `{"succeeded":true}`
+		}
+		t.Errorf("unexpected request %s %s", req.Method, req.Path)
+		return 400, `{}`
+	}, &cleared
+}
+
+func TestQueryStreamSlicesOrdered(t *testing.T) {
+	handler, cleared := slicedScrollServer(t, -1)
+	p, _ := newTestProvider(t, Config{Parallelism: 3, Ordered: true, PageSize: 2}, handler)
+
+	var sizes []int
+	var messages []string
+	err := p.QueryStream(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		sizes = append(sizes, len(batch))
+		for _, entry := range batch {
+			messages = append(messages, entry.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+
+	want := "doc-9,doc-8,doc-7,doc-6,doc-5,doc-4,doc-3,doc-2,doc-1"
+	if got := strings.Join(messages, ","); got != want {
+		t.Errorf("messages = %s, want %s", got, want)
+	}
+	if fmt.Sprint(sizes) != "[2 2 2 2 1]" {
+		t.Errorf("batch sizes = %v, want merged batches of the page size", sizes)
+	}
+	if *cleared != 3 {
+		t.Errorf("cleared scrolls = %d, want 3", *cleared)
+	}
+}
+
+func TestQueryStreamSlicesUnordered(t *testing.T) {
+	handler, _ := slicedScrollServer(t, -1)
+	p, _ := newTestProvider(t, Config{Parallelism: 3, PageSize: 2}, handler)
+
+	var mu sync.Mutex
+	var messages []string
+	err := p.QueryStream(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, entry := range batch {
+			messages = append(messages, entry.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+
+	sort.Strings(messages)
+	want := "doc-1,doc-2,doc-3,doc-4,doc-5,doc-6,doc-7,doc-8,doc-9"
+	if got := strings.Join(messages, ","); got != want {
+		t.Errorf("messages = %s, want every document once", got)
+	}
+}
+
+func TestQueryStreamSlicesLimit(t *testing.T) {
+	handler, _ := slicedScrollServer(t, -1)
+	p, _ := newTestProvider(t, Config{Parallelism: 3, Ordered: true, PageSize: 2}, handler)
+
+	var messages []string
+	err := p.QueryStream(context.Background(), schema.LogQuery{Limit: 3}, func(batch []schema.LogEntry) error {
+		for _, entry := range batch {
+			messages = append(messages, entry.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if got := strings.Join(messages, ","); got != "doc-9,doc-8,doc-7" {
+		t.Errorf("messages = %s, want the newest three", got)
+	}
+}
+
+func TestQueryStreamSliceErrorCancelsSiblings(t *testing.T) {
+	handler, cleared := slicedScrollServer(t, 1)
+	p, _ := newTestProvider(t, Config{Parallelism: 3, Ordered: true, PageSize: 2}, handler)
+
+	err := p.QueryStream(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "slice 1") || !strings.Contains(err.Error(), "slice failed") {
+		t.Fatalf("err = %v, want slice 1's error", err)
+	}
+	if *cleared != 3 {
+		t.Errorf("cleared scrolls = %d, want every slice's scroll cleared", *cleared)
+	}
+}
+
+func TestQueryStreamSlicesCallbackError(t *testing.T) {
+	handler, cleared := slicedScrollServer(t, -1)
+	p, _ := newTestProvider(t, Config{Parallelism: 3, Ordered: true, PageSize: 2}, handler)
+
+	stop := errors.New("consumer gone")
+	calls := 0
+	err := p.QueryStream(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the callback's error after one", err, calls)
+	}
+	if *cleared != 3 {
+		t.Errorf("cleared scrolls = %d, want 3", *cleared)
+	}
+}
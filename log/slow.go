@@ -0,0 +1,201 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// defaultSlowQueryBuffer is how many slow queries are kept when no
+// slowQueryBuffer is configured.
+const defaultSlowQueryBuffer = 100
+
+// SlowQuery is a query whose searches took longer than SlowQueryThreshold.
+type SlowQuery struct {
+	Time    time.Time `json:"time"`
+	Team    string    `json:"team,omitempty"`
+	Service string    `json:"service,omitempty"`
+	Index   string    `json:"index"`
+	// Query is the search DSL of the first page, with RedactFields masked.
+	Query      json.RawMessage `json:"query"`
+	TookMillis int             `json:"tookMs"`
+	TimedOut   bool            `json:"timedOut,omitempty"`
+	// Shards is the number of shards searched; FailedShards of them
+	// failed.
+	Shards       int      `json:"shards"`
+	FailedShards int      `json:"failedShards,omitempty"`
+	Hints        []string `json:"hints,omitempty"`
+	// TraceID is the trace id of the request that ran the query.
+	TraceID string `json:"traceId,omitempty"`
+}
+
+// slowQueryRule detects a query shape known to be slow, and the hint given
+// when a query with it is slow.
+type slowQueryRule struct {
+	name  string
+	match func(p *ElasticProvider, ctx context.Context, query schema.LogQuery) (string, bool)
+}
+
+// leadingWildcard matches a search term starting with a wildcard.
+var leadingWildcard = regexp.MustCompile(`(^|[\s(:])[*?]`)
+
+// slowQueryRules are checked in order against every slow query. Add a rule
+// here to give a new hint.
+var slowQueryRules = []slowQueryRule{
+	{
+		name: "leading_wildcard",
+		match: func(p *ElasticProvider, ctx context.Context, query schema.LogQuery) (string, bool) {
+			if query.Expression == nil {
+				return "", false
+			}
+			if leadingWildcard.MatchString(query.Expression.Search) {
+				return "search terms starting with a wildcard scan every term of the field; anchor them with a prefix", true
+			}
+			for _, filter := range query.Expression.Filters {
+				if filter.Operator == "contains" {
+					return fmt.Sprintf("contains on %s is a leading wildcard that scans every term; use = or a full-text search instead", filter.Field), true
+				}
+			}
+			return "", false
+		},
+	},
+	{
+		name: "unbounded_time_range",
+		match: func(p *ElasticProvider, ctx context.Context, query schema.LogQuery) (string, bool) {
+			if query.Start.IsZero() {
+				return "the query has no start time, so every index is searched; set start to bound the time range", true
+			}
+			return "", false
+		},
+	},
+	{
+		name: "regex_on_text",
+		match: func(p *ElasticProvider, ctx context.Context, query schema.LogQuery) (string, bool) {
+			if query.Expression == nil {
+				return "", false
+			}
+			for _, filter := range query.Expression.Filters {
+				if filter.Operator == "regex" && textFieldTypes[p.fieldType(ctx, filter.Field)] {
+					return fmt.Sprintf("regex on text field %s runs against every analyzed term; filter on its keyword sub-field or narrow the query", filter.Field), true
+				}
+			}
+			return "", false
+		},
+	},
+}
+
+// fieldType returns the mapping type of a field, or "" when it is unknown
+// or the mapping cannot be read.
+func (p *ElasticProvider) fieldType(ctx context.Context, name string) string {
+	fields, err := p.ListFields(ctx, "")
+	if err != nil {
+		return ""
+	}
+	for _, field := range fields {
+		if field.Name == name {
+			return field.Type
+		}
+	}
+	return ""
+}
+
+// slowQueryHints returns the hints of the rules a query matches.
+func (p *ElasticProvider) slowQueryHints(ctx context.Context, query schema.LogQuery) []string {
+	var hints []string
+	for _, rule := range slowQueryRules {
+		if hint, ok := rule.match(p, ctx, query); ok {
+			hints = append(hints, hint)
+		}
+	}
+	return hints
+}
+
+// recordSlowQuery keeps a query whose searches took longer than
+// SlowQueryThreshold and returns the hints for it. Faster queries, and all
+// queries when no threshold is set, return no hints.
+func (p *ElasticProvider) recordSlowQuery(ctx context.Context, query schema.LogQuery, stats QueryStats) []string {
+	threshold := p.cfg.SlowQueryThreshold
+	if threshold <= 0 || time.Duration(stats.TookMillis)*time.Millisecond < threshold {
+		return nil
+	}
+
+	hints := p.slowQueryHints(ctx, query)
+	dsl, _ := json.Marshal(p.buildQuery(query))
+	record := SlowQuery{
+		Time:         time.Now().UTC(),
+		Team:         query.Scope.Team,
+		Service:      query.Scope.Service,
+		Index:        p.cfg.IndexPattern,
+		Query:        redactDSL(dsl, p.redact),
+		TookMillis:   stats.TookMillis,
+		TimedOut:     stats.TimedOut,
+		Shards:       stats.totalShards,
+		FailedShards: len(stats.ShardFailures),
+		Hints:        hints,
+		TraceID:      TraceID(ctx),
+	}
+
+	p.logSlowQuery(record)
+
+	p.slowMu.Lock()
+	defer p.slowMu.Unlock()
+	if p.slowQueries == nil {
+		size := p.cfg.SlowQueryBuffer
+		if size <= 0 {
+			size = defaultSlowQueryBuffer
+		}
+		p.slowQueries = newSlowQueryRing(size)
+	}
+	p.slowQueries.add(record)
+	return hints
+}
+
+// SlowQueries returns the recorded slow queries, newest first. Only the
+// most recent SlowQueryBuffer are kept.
+func (p *ElasticProvider) SlowQueries() ([]SlowQuery, error) {
+	if p.cfg.SlowQueryThreshold <= 0 {
+		return nil, unsupported("slow query recording is disabled; set slowQueryThreshold to enable it")
+	}
+	p.slowMu.Lock()
+	defer p.slowMu.Unlock()
+	if p.slowQueries == nil {
+		return []SlowQuery{}, nil
+	}
+	return p.slowQueries.list(), nil
+}
+
+// slowQueryRing holds the most recent slow queries.
+type slowQueryRing struct {
+	records []SlowQuery
+	next    int
+	full    bool
+}
+
+func newSlowQueryRing(size int) *slowQueryRing {
+	return &slowQueryRing{records: make([]SlowQuery, size)}
+}
+
+func (r *slowQueryRing) add(record SlowQuery) {
+	r.records[r.next] = record
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the records newest first.
+func (r *slowQueryRing) list() []SlowQuery {
+	n := r.next
+	if r.full {
+		n = len(r.records)
+	}
+	out := make([]SlowQuery, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, r.records[(r.next-i+len(r.records))%len(r.records)])
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// slowServer answers searches as taking took milliseconds over 12 shards,
+// one of them failing.
+func slowServer(took int) func(req recordedRequest) (int, string) {
+	return func(req recordedRequest) (int, string) {
+		if strings.HasSuffix(req.Path, "/_field_caps") {
+			return 200, planFieldCaps
+		}
+		return 200, fmt.Sprintf(`{"took":%d,"_shards":{"total":12,"successful":11,"failed":1,"failures":[{"index":"logs-a","shard":3,"reason":{"type":"timeout"}}]},"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"a","_source":{"message":"a"}}]}}`, took)
+	}
+}
+
+func TestRecordSlowQuery(t *testing.T) {
+	p, _ := newTestProvider(t, Config{SlowQueryThreshold: time.Second, RedactFields: []string{"user.email"}}, slowServer(2500))
+
+	query := schema.LogQuery{
+		Scope: schema.QueryScope{Team: "payments", Service: "checkout"},
+		Expression: &schema.LogExpression{
+			Search:  "*timeout",
+			Filters: []schema.LogFilter{{Field: "user.email", Operator: "=", Value: "alice@example.com"}},
+		},
+	}
+	_, stats, err := p.QueryWithStats(context.Background(), query)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(stats.Hints) != 2 || !strings.HasPrefix(stats.Hints[0], "search terms starting with a wildcard") || !strings.HasPrefix(stats.Hints[1], "the query has no start time") {
+		t.Errorf("hints = %q, want leading wildcard and unbounded time range", stats.Hints)
+	}
+
+	slow, err := p.SlowQueries()
+	if err != nil {
+		t.Fatalf("SlowQueries failed: %v", err)
+	}
+	if len(slow) != 1 {
+		t.Fatalf("slow queries = %d, want 1", len(slow))
+	}
+	got := slow[0]
+	if got.TookMillis != 2500 || got.Shards != 12 || got.FailedShards != 1 || got.Team != "payments" || got.Service != "checkout" || got.Index != "logs-*" {
+		t.Errorf("slow query = %+v, want took, shards and scope recorded", got)
+	}
+	if !strings.Contains(string(got.Query), `"query":"*timeout"`) || strings.Contains(string(got.Query), "alice@example.com") {
+		t.Errorf("query = %s, want the DSL with redacted fields masked", got.Query)
+	}
+	if len(got.Hints) != 2 {
+		t.Errorf("hints = %q, want the hints recorded", got.Hints)
+	}
+}
+
+func TestFastQueryNotRecorded(t *testing.T) {
+	p, _ := newTestProvider(t, Config{SlowQueryThreshold: time.Second}, slowServer(999))
+	_, stats, err := p.QueryWithStats(context.Background(), schema.LogQuery{})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if slow, _ := p.SlowQueries(); len(slow) != 0 || stats.Hints != nil {
+		t.Errorf("slow queries = %+v, hints = %q; want nothing for a fast query", slow, stats.Hints)
+	}
+}
+
+func TestSlowQueryRing(t *testing.T) {
+	p, _ := newTestProvider(t, Config{SlowQueryThreshold: time.Millisecond, SlowQueryBuffer: 3}, slowServer(50))
+	for i := 0; i < 5; i++ {
+		query := schema.LogQuery{Scope: schema.QueryScope{Service: fmt.Sprintf("svc-%d", i)}}
+		if _, _, err := p.QueryWithStats(context.Background(), query); err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+	}
+	slow, _ := p.SlowQueries()
+	var services []string
+	for _, q := range slow {
+		services = append(services, q.Service)
+	}
+	if strings.Join(services, ",") != "svc-4,svc-3,svc-2" {
+		t.Errorf("services = %v, want the last 3 newest first", services)
+	}
+}
+
+func TestSlowQueryHints(t *testing.T) {
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query schema.LogQuery
+		want  string
+	}{
+		{
+			name:  "bounded",
+			query: schema.LogQuery{Start: start, Expression: &schema.LogExpression{Search: "payment AND declined"}},
+		},
+		{
+			name:  "leading wildcard in a field",
+			query: schema.LogQuery{Start: start, Expression: &schema.LogExpression{Search: "message:*declined"}},
+			want:  "search terms starting with a wildcard",
+		},
+		{
+			name:  "trailing wildcard",
+			query: schema.LogQuery{Start: start, Expression: &schema.LogExpression{Search: "pay*"}},
+		},
+		{
+			name:  "contains filter",
+			query: schema.LogQuery{Start: start, Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "host.name", Operator: "contains", Value: "web"}}}},
+			want:  "contains on host.name is a leading wildcard",
+		},
+		{
+			name:  "unbounded",
+			query: schema.LogQuery{End: start},
+			want:  "the query has no start time",
+		},
+		{
+			name:  "regex on text",
+			query: schema.LogQuery{Start: start, Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "message", Operator: "regex", Value: "time.*out"}}}},
+			want:  "regex on text field message",
+		},
+		{
+			name:  "regex on keyword",
+			query: schema.LogQuery{Start: start, Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "host.name", Operator: "regex", Value: "web-[0-9]+"}}}},
+		},
+	}
+	p, _ := newTestProvider(t, Config{}, slowServer(0))
+	for _, tt := range tests {
+		hints := p.slowQueryHints(context.Background(), tt.query)
+		if tt.want == "" && len(hints) != 0 {
+			t.Errorf("%s: hints = %q, want none", tt.name, hints)
+		}
+		if tt.want != "" && (len(hints) != 1 || !strings.HasPrefix(hints[0], tt.want)) {
+			t.Errorf("%s: hints = %q, want %q", tt.name, hints, tt.want)
+		}
+	}
+}
+
+func TestSlowQueriesDisabled(t *testing.T) {
+	p := &ElasticProvider{}
+	if _, err := p.SlowQueries(); err == nil || !strings.Contains(err.Error(), "slowQueryThreshold") {
+		t.Errorf("err = %v, want slow query recording disabled", err)
+	}
+}
@@ -0,0 +1,173 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// SQLColumn names and types one column of an SQL result.
+type SQLColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// SQLResultPage is one page of an SQL result, with each row keyed by column
+// name. Cursor, when set, fetches the next page with QuerySQLNext.
+type SQLResultPage struct {
+	Columns []SQLColumn      `json:"columns"`
+	Rows    []map[string]any `json:"rows"`
+	Cursor  string           `json:"cursor,omitempty"`
+}
+
+// sqlCursor is the state behind an SQLResultPage cursor. Elasticsearch
+// returns the columns only with the first page, so they travel with the
+// cursor to key later pages' rows.
+type sqlCursor struct {
+	Cursor  string      `json:"c"`
+	Columns []SQLColumn `json:"cols"`
+}
+
+// esSQLResponse is one _sql response page.
+type esSQLResponse struct {
+	Columns []SQLColumn `json:"columns"`
+	Rows    [][]any     `json:"rows"`
+	Cursor  string      `json:"cursor"`
+}
+
+// usage reports the rows of the page as its documents, for metering; SQL
+// responses carry no took time.
+func (r esSQLResponse) usage() (documents, took int64) {
+	return int64(len(r.Rows)), 0
+}
+
+// QuerySQL runs an SQL statement such as
+// `SELECT service, count(*) FROM "logs-*" GROUP BY service` and returns its
+// first page of at most fetchSize rows (default pageSize). The statement
+// runs with the adapter's credentials and is not limited to the index
+// pattern.
+func (p *ElasticProvider) QuerySQL(ctx context.Context, sql string, fetchSize int) (SQLResultPage, error) {
+	ctx = withAudit(ctx, "log.sql", schema.QueryScope{})
+	if strings.TrimSpace(sql) == "" {
+		return SQLResultPage{}, errors.New("SQL statement is empty")
+	}
+	if fetchSize <= 0 {
+		fetchSize = p.pageSize()
+	}
+
+	result, err := p.sqlRequest(ctx, map[string]any{"query": sql, "fetch_size": fetchSize})
+	if err != nil {
+		return SQLResultPage{}, err
+	}
+	return p.sqlPage(result.Columns, result)
+}
+
+// QuerySQLNext fetches the page after the one that returned cursor. The
+// last page has no cursor; Elasticsearch releases the cursor once it is
+// exhausted.
+func (p *ElasticProvider) QuerySQLNext(ctx context.Context, cursor string) (SQLResultPage, error) {
+	ctx = withAudit(ctx, "log.sqlNext", schema.QueryScope{})
+	state, err := decodeSQLCursor(cursor)
+	if err != nil {
+		return SQLResultPage{}, err
+	}
+
+	result, err := p.sqlRequest(ctx, map[string]any{"cursor": state.Cursor})
+	if err != nil {
+		p.closeSQLCursor(state.Cursor)
+		return SQLResultPage{}, err
+	}
+	return p.sqlPage(state.Columns, result)
+}
+
+// sqlRequest posts a body to _sql and decodes the response page.
+func (p *ElasticProvider) sqlRequest(ctx context.Context, body map[string]any) (esSQLResponse, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return esSQLResponse{}, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	res, err := p.client.SQL.Query(bytes.NewReader(encoded),
+		p.client.SQL.Query.WithContext(ctx),
+		p.client.SQL.Query.WithFormat("json"),
+	)
+	if err != nil {
+		return esSQLResponse{}, fmt.Errorf("elasticsearch SQL query failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return esSQLResponse{}, responseError(res)
+	}
+
+	var result esSQLResponse
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return esSQLResponse{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	p.meterResponse(ctx, result)
+	return result, nil
+}
+
+// sqlPage converts a response page into rows keyed by columns. A page that
+// cannot be converted closes its cursor, since the caller cannot continue.
+func (p *ElasticProvider) sqlPage(columns []SQLColumn, result esSQLResponse) (SQLResultPage, error) {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	rows, err := tableRows(names, result.Rows)
+	if err != nil {
+		if result.Cursor != "" {
+			p.closeSQLCursor(result.Cursor)
+		}
+		return SQLResultPage{}, err
+	}
+
+	page := SQLResultPage{Columns: columns, Rows: rows}
+	if result.Cursor != "" {
+		encoded, err := json.Marshal(sqlCursor{Cursor: result.Cursor, Columns: columns})
+		if err != nil {
+			p.closeSQLCursor(result.Cursor)
+			return SQLResultPage{}, fmt.Errorf("failed to encode cursor: %w", err)
+		}
+		page.Cursor = base64.RawURLEncoding.EncodeToString(encoded)
+	}
+	return page, nil
+}
+
+// decodeSQLCursor parses a cursor from SQLResultPage.
+func decodeSQLCursor(token string) (sqlCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return sqlCursor{}, errors.New("SQL cursor is not valid base64")
+	}
+	var cursor sqlCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Cursor == "" {
+		return sqlCursor{}, errors.New("SQL cursor is malformed")
+	}
+	return cursor, nil
+}
+
+// closeSQLCursor releases an Elasticsearch SQL cursor. Like clearScroll it
+// runs on a fresh context so that it still happens after cancellation.
+func (p *ElasticProvider) closeSQLCursor(cursor string) {
+	ctx, cancel := context.WithTimeout(context.Background(), pitCloseTimeout)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]string{"cursor": cursor})
+	res, err := p.client.SQL.ClearCursor(bytes.NewReader(body), p.client.SQL.ClearCursor.WithContext(ctx))
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: elastic adapter failed to close SQL cursor: %v\n", err)
+		return
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+}
@@ -0,0 +1,119 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// sqlServer fakes _sql over five rows served two per page. The cursor
+// request numbered failAt (1-based, 0 for never) fails.
+func sqlServer(t *testing.T, failAt int) func(req recordedRequest) (int, string) {
+	pages := []string{
+		`"rows":[["api",3],["web",2]],"cursor":"es-cursor-1"`,
+		`"rows":[["db",1],["cache",1]],"cursor":"es-cursor-2"`,
+		`"rows":[["queue",1]]`,
+	}
+	next := 0
+	return func(req recordedRequest) (int, string) {
+		switch req.Path {
+		case "/_sql":
+			if req.Query.Get("format") != "json" {
+				t.Errorf("format = %q, want json", req.Query.Get("format"))
+			}
+			var body map[string]any
+			if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+				t.Fatalf("failed to decode request body: %v", err)
+			}
+			if _, ok := body["query"]; ok {
+				next = 1
+				return 200, `{"columns":[{"name":"service","type":"keyword"},{"name":"count(*)","type":"long"}],` + pages[0] + `}`
+			}
+			if next == failAt {
+				return 500, `{"error":{"type":"illegal_state_exception","reason":"node closing"},"status":500}`
+			}
+			page := pages[next]
+			next++
+			return 200, `{` + page + `}`
+		case "/_sql/close":
+			return 200, `{"succeeded":true}`
+		}
+		t.Errorf("unexpected request %s %s", req.Method, req.Path)
+		return 404, `{}`
+	}
+}
+
+func TestQuerySQLPages(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, sqlServer(t, 0))
+
+	statement := `SELECT service, count(*) FROM "logs-*" GROUP BY service`
+	page, err := p.QuerySQL(context.Background(), statement, 2)
+	if err != nil {
+		t.Fatalf("first page failed: %v", err)
+	}
+	var rows []map[string]any
+	for {
+		rows = append(rows, page.Rows...)
+		if len(page.Columns) != 2 || page.Columns[1] != (SQLColumn{Name: "count(*)", Type: "long"}) {
+			t.Errorf("columns = %+v, want service and count(*) on every page", page.Columns)
+		}
+		if page.Cursor == "" {
+			break
+		}
+		if page, err = p.QuerySQLNext(context.Background(), page.Cursor); err != nil {
+			t.Fatalf("next page failed: %v", err)
+		}
+	}
+
+	encoded, _ := json.Marshal(rows)
+	want := `[{"count(*)":3,"service":"api"},{"count(*)":2,"service":"web"},{"count(*)":1,"service":"db"},{"count(*)":1,"service":"cache"},{"count(*)":1,"service":"queue"}]`
+	if string(encoded) != want {
+		t.Errorf("rows = %s, want %s", encoded, want)
+	}
+
+	requests := transport.recorded()
+	if got := requestLine(requests); got != "POST /_sql, POST /_sql, POST /_sql" {
+		t.Fatalf("requests = %s, want three pages and no close", got)
+	}
+	if !strings.Contains(requests[0].Body, `"fetch_size":2`) || !strings.Contains(requests[0].Body, `"query":`) {
+		t.Errorf("first body = %s, want the statement and fetch size", requests[0].Body)
+	}
+	if requests[1].Body != `{"cursor":"es-cursor-1"}` || requests[2].Body != `{"cursor":"es-cursor-2"}` {
+		t.Errorf("cursor bodies = %s, %s; want the Elasticsearch cursors", requests[1].Body, requests[2].Body)
+	}
+}
+
+func TestQuerySQLClosesCursorOnError(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, sqlServer(t, 1))
+
+	page, err := p.QuerySQL(context.Background(), `SELECT service FROM "logs-*"`, 2)
+	if err != nil {
+		t.Fatalf("first page failed: %v", err)
+	}
+	if _, err := p.QuerySQLNext(context.Background(), page.Cursor); err == nil || !strings.Contains(err.Error(), "node closing") {
+		t.Fatalf("err = %v, want the page error", err)
+	}
+
+	requests := transport.recorded()
+	if got := requestLine(requests); got != "POST /_sql, POST /_sql, POST /_sql/close" {
+		t.Fatalf("requests = %s, want the cursor closed after the failure", got)
+	}
+	if requests[2].Body != `{"cursor":"es-cursor-1"}` {
+		t.Errorf("close body = %s, want the failed cursor", requests[2].Body)
+	}
+}
+
+func TestQuerySQLInvalidInput(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, sqlServer(t, 0))
+
+	if _, err := p.QuerySQL(context.Background(), " ", 0); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("err = %v, want an empty statement error", err)
+	}
+	if _, err := p.QuerySQLNext(context.Background(), "not a cursor!"); err == nil || !strings.Contains(err.Error(), "cursor") {
+		t.Errorf("err = %v, want a cursor error", err)
+	}
+	if len(transport.recorded()) != 0 {
+		t.Errorf("requests = %s, want none", requestLine(transport.recorded()))
+	}
+}
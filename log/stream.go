@@ -0,0 +1,86 @@
+package log
+
+import (
+	"context"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// QueryStream runs a log query page by page and passes each page to fn as
+// one batch, so callers can consume large results without
+// buffering them. A query limit caps the total number of entries streamed;
+// without one the stream runs until results are exhausted. Streaming stops
+// with fn's error when fn fails, or with ctx's error when it is cancelled.
+// With parallelism configured, the query is read as a sliced scroll. fn is
+// never called concurrently and must not retain the batch after it returns.
+func (p *ElasticProvider) QueryStream(ctx context.Context, query schema.LogQuery, fn func(batch []schema.LogEntry) error) error {
+	ctx = withAudit(ctx, "log.stream", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return err
+	}
+
+	if slices := p.parallelism(); slices > 1 && p.canScroll(query) {
+		return p.streamSlices(ctx, query, slices, fn)
+	}
+	if p.useScroll(ctx, query) {
+		_, err := p.scroll(ctx, query, nil, nil, query.Limit, 0, func(batch []schema.LogEntry) error {
+			if p.cfg.DedupeResults {
+				batch = dedupeConsecutive(batch)
+			}
+			return fn(batch)
+		})
+		return err
+	}
+
+	pageSize := p.pageSize()
+
+	page := query
+	page.Metadata = make(map[string]any, len(query.Metadata)+1)
+	for key, value := range query.Metadata {
+		page.Metadata[key] = value
+	}
+
+	var batch []schema.LogEntry
+	cursor := ""
+	streamed := 0
+	for n := 0; ; n++ {
+		if n > 0 {
+			if err := ctx.Err(); err != nil {
+				p.releaseCursor(cursor)
+				return err
+			}
+			page.Metadata[QueryOptionCursor] = cursor
+		}
+		page.Limit = pageSize
+		if query.Limit > 0 {
+			page.Limit = min(pageSize, query.Limit-streamed)
+		}
+
+		var stats QueryStats
+		var err error
+		batch, stats, err = p.fetchPage(ctx, page, nil, batch[:0])
+		if err != nil {
+			return err
+		}
+		cursor = stats.NextCursor
+		streamed += len(batch)
+
+		if p.cfg.DedupeResults {
+			batch = dedupeConsecutive(batch)
+		}
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				p.releaseCursor(cursor)
+				return err
+			}
+		}
+
+		if cursor == "" {
+			return nil
+		}
+		if query.Limit > 0 && streamed >= query.Limit {
+			p.releaseCursor(cursor)
+			return nil
+		}
+	}
+}
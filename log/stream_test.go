@@ -0,0 +1,103 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestQueryStreamBatches(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4}, searchAfterServer(t, 10))
+
+	var sizes []int
+	var messages []string
+	err := p.QueryStream(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		sizes = append(sizes, len(batch))
+		for _, entry := range batch {
+			messages = append(messages, entry.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+
+	if fmt.Sprint(sizes) != "[4 4 2]" {
+		t.Errorf("batch sizes = %v, want [4 4 2]", sizes)
+	}
+	if len(messages) != 10 || messages[0] != "doc-10" || messages[9] != "doc-1" {
+		t.Errorf("messages = %v, want doc-10 through doc-1", messages)
+	}
+	if len(searchRequests(transport.recorded())) != 3 {
+		t.Errorf("requests = %d, want 3", len(searchRequests(transport.recorded())))
+	}
+}
+
+func TestQueryStreamHonorsLimit(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4}, searchAfterServer(t, 10))
+
+	total := 0
+	err := p.QueryStream(context.Background(), schema.LogQuery{Limit: 6}, func(batch []schema.LogEntry) error {
+		total += len(batch)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	if total != 6 || len(searchRequests(transport.recorded())) != 2 {
+		t.Errorf("streamed %d entries over %d requests, want 6 over 2", total, len(searchRequests(transport.recorded())))
+	}
+}
+
+func TestQueryStreamStopsOnCallbackError(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 4}, searchAfterServer(t, 10))
+
+	stop := errors.New("consumer gone")
+	calls := 0
+	err := p.QueryStream(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("err = %v, want the callback's error", err)
+	}
+	if calls != 1 || len(searchRequests(transport.recorded())) != 1 {
+		t.Errorf("callback calls = %d, requests = %d; want streaming to stop after the first batch", calls, len(searchRequests(transport.recorded())))
+	}
+}
+
+func TestQueryStreamStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, transport := newTestProvider(t, Config{PageSize: 4}, searchAfterServer(t, 10))
+
+	err := p.QueryStream(ctx, schema.LogQuery{}, func(batch []schema.LogEntry) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if len(searchRequests(transport.recorded())) != 1 {
+		t.Errorf("requests = %d, want 1", len(searchRequests(transport.recorded())))
+	}
+}
+
+func TestQueryStreamClosesPointInTimeOnEarlyStop(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PointInTime: true, PageSize: 2}, pitServer(t, 200, ""))
+
+	err := p.QueryStream(context.Background(), schema.LogQuery{Limit: 10, Metadata: map[string]any{}}, func(batch []schema.LogEntry) error {
+		return errors.New("stop")
+	})
+	if err == nil {
+		t.Fatal("stream succeeded, want the callback's error")
+	}
+	if got := strings.Join(pitRequests(transport.recorded()), ", "); got != "POST /logs-*/_pit, POST /_search, DELETE /_pit" {
+		t.Errorf("requests = %s, want the point in time closed after the first batch", got)
+	}
+}
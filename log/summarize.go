@@ -0,0 +1,162 @@
+package log
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Sizes of the sections of a LogSummary.
+const (
+	summaryServices = 5
+	summaryPatterns = 3
+	summarySamples  = 5
+)
+
+// errorSeverities are the canonical severities whose messages make up the
+// summary's patterns.
+var errorSeverities = map[string]bool{
+	"error":     true,
+	"critical":  true,
+	"alert":     true,
+	"fatal":     true,
+	"emergency": true,
+}
+
+// errorSeverityNames lists the spellings of errorSeverities matched in
+// documents: each canonical name and alias, lower and upper case.
+func errorSeverityNames() []string {
+	var names []string
+	for name := range errorSeverities {
+		names = append(names, name)
+	}
+	for alias, name := range severityAliases {
+		if errorSeverities[name] {
+			names = append(names, alias)
+		}
+	}
+	for _, name := range names {
+		names = append(names, strings.ToUpper(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LogSummary is a one-call overview of the logs matching a query, such as
+// an incident's window.
+type LogSummary struct {
+	Total int64 `json:"total"`
+	// Severities counts logs by canonical severity. Logs without a
+	// severity are not counted here.
+	Severities map[string]int64 `json:"severities"`
+	// Services are the services logging most, most first.
+	Services []ValueCount `json:"services"`
+	// Patterns are the most frequent message patterns among logs of error
+	// severity or worse, most frequent first.
+	Patterns []LogPattern `json:"patterns"`
+	// Samples are the newest matching entries.
+	Samples []schema.LogEntry `json:"samples"`
+}
+
+// buildSummaryQuery constructs the single search behind Summarize: the
+// newest entries with the severity, service and error pattern aggregations.
+// Without categorize_text, size is raised so the patterns can be grouped
+// client-side from the newest entries.
+func (p *ElasticProvider) buildSummaryQuery(query schema.LogQuery, categorize bool) map[string]any {
+	aggs := map[string]any{
+		"severity": map[string]any{
+			"terms": map[string]any{"field": "severity", "size": 20},
+		},
+		"services": map[string]any{
+			"terms": map[string]any{"field": p.scopeFields(scopeService)[0], "size": summaryServices},
+		},
+	}
+	size := summarySamples
+	if categorize {
+		aggs["errors"] = map[string]any{
+			"filter": p.severityClause(errorSeverityNames()),
+			"aggs":   map[string]any{"patterns": p.patternsAggregation(summaryPatterns)},
+		}
+	} else {
+		size = max(summarySamples, min(patternSampleSize, p.pageSize()))
+	}
+
+	return map[string]any{
+		"query":            p.boolQuery(query),
+		"size":             size,
+		"sort":             p.sortClause(query),
+		"track_total_hits": true,
+		"aggs":             aggs,
+	}
+}
+
+// Summarize returns the total, the counts by severity, the top services,
+// the top error patterns and sample entries of the logs matching a query,
+// all from one search. On clusters without categorize_text the patterns
+// are grouped from the newest entries, so their counts cover those only.
+func (p *ElasticProvider) Summarize(ctx context.Context, query schema.LogQuery) (LogSummary, error) {
+	ctx = withAudit(ctx, "log.summarize", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return LogSummary{}, err
+	}
+
+	categorize := p.hasFeature(ctx, featureCategorizeText, true)
+	var result struct {
+		esSearchResponse
+		Aggregations struct {
+			Severity struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int64  `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"severity"`
+			Services struct {
+				Buckets []struct {
+					Key      any   `json:"key"`
+					DocCount int64 `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"services"`
+			Errors struct {
+				Patterns esPatternsAggregation `json:"patterns"`
+			} `json:"errors"`
+		} `json:"aggregations"`
+	}
+	if err := p.searchInto(ctx, p.buildSummaryQuery(query, categorize), &result); err != nil {
+		return LogSummary{}, err
+	}
+
+	aggs := result.Aggregations
+	summary := LogSummary{
+		Total:      int64(result.Hits.Total.Value),
+		Severities: make(map[string]int64, len(aggs.Severity.Buckets)),
+		Services:   make([]ValueCount, 0, len(aggs.Services.Buckets)),
+	}
+	for _, b := range aggs.Severity.Buckets {
+		summary.Severities[canonicalSeverity(b.Key)] += b.DocCount
+	}
+	for _, b := range aggs.Services.Buckets {
+		summary.Services = append(summary.Services, ValueCount{Value: b.Key, Count: b.DocCount})
+	}
+
+	entries := p.appendResult(ctx, nil, result.esSearchResponse)
+	if categorize {
+		summary.Patterns = aggs.Errors.Patterns.patterns(p)
+	} else {
+		summary.Patterns = groupPatterns(errorEntries(entries), summaryPatterns)
+	}
+	summary.Samples = entries[:min(len(entries), summarySamples)]
+	return summary, nil
+}
+
+// errorEntries returns the entries of errorSeverities.
+func errorEntries(entries []schema.LogEntry) []schema.LogEntry {
+	var out []schema.LogEntry
+	for _, entry := range entries {
+		if errorSeverities[canonicalSeverity(entry.Severity)] {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestSummarize(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		if req.Path == "/" {
+			return 200, `{"version":{"number":"8.11.1"}}`
+		}
+		return 200, `{"took":35,"hits":{"total":{"value":12840,"relation":"eq"},"hits":[
+			{"_index":"logs-app","_id":"s1","_source":{"@timestamp":"2023-10-01T12:59:59Z","message":"payment declined","severity":"ERROR","service":"checkout"},"sort":[1696165199000,9]},
+			{"_index":"logs-app","_id":"s2","_source":{"@timestamp":"2023-10-01T12:59:58Z","message":"cart updated","severity":"info","service":"cart"},"sort":[1696165198000,8]}
+		]},"aggregations":{
+			"severity":{"doc_count_error_upper_bound":0,"sum_other_doc_count":0,"buckets":[
+				{"key":"info","doc_count":10000},{"key":"ERROR","doc_count":1500},{"key":"error","doc_count":900},{"key":"warning","doc_count":440}
+			]},
+			"services":{"doc_count_error_upper_bound":0,"sum_other_doc_count":340,"buckets":[
+				{"key":"checkout","doc_count":6000},{"key":"cart","doc_count":4000},{"key":"search","doc_count":2500}
+			]},
+			"errors":{"doc_count":2400,"patterns":{"buckets":[
+				{"doc_count":1800,"key":"payment declined","sample":{"hits":{"hits":[{"_index":"logs-app","_id":"s1","_source":{"message":"payment declined","severity":"ERROR"}}]}}},
+				{"doc_count":600,"key":"upstream timeout after ms","sample":{"hits":{"hits":[{"_index":"logs-app","_id":"e7","_source":{"message":"upstream timeout after 3000 ms","severity":"error"}}]}}}
+			]}}
+		}}`
+	})
+
+	summary, err := p.Summarize(context.Background(), schema.LogQuery{Scope: schema.QueryScope{Team: "payments"}})
+	if err != nil {
+		t.Fatalf("summarize failed: %v", err)
+	}
+	if summary.Total != 12840 {
+		t.Errorf("total = %d, want 12840", summary.Total)
+	}
+	if got := fmt.Sprint(summary.Severities); got != "map[error:2400 info:10000 warn:440]" {
+		t.Errorf("severities = %s, want spellings merged", got)
+	}
+	if got := fmt.Sprint(summary.Services); got != "[{checkout 6000} {cart 4000} {search 2500}]" {
+		t.Errorf("services = %s, want the top services", got)
+	}
+	if len(summary.Patterns) != 2 || summary.Patterns[0].Pattern != "payment declined" || summary.Patterns[0].Count != 1800 || summary.Patterns[1].Sample.Message != "upstream timeout after 3000 ms" {
+		t.Errorf("patterns = %+v, want the error categories", summary.Patterns)
+	}
+	if messages(summary.Samples) != "payment declined,cart updated" || summary.Samples[0].Service != "checkout" {
+		t.Errorf("samples = %+v, want the normalized hits", summary.Samples)
+	}
+
+	requests := searchRequests(transport.recorded())
+	if len(requests) != 1 {
+		t.Fatalf("searches = %d, want 1", len(requests))
+	}
+	var body struct {
+		Size           int  `json:"size"`
+		TrackTotalHits bool `json:"track_total_hits"`
+		Aggs           struct {
+			Severity map[string]map[string]any `json:"severity"`
+			Services map[string]map[string]any `json:"services"`
+			Errors   struct {
+				Filter json.RawMessage `json:"filter"`
+				Aggs   struct {
+					Patterns struct {
+						CategorizeText map[string]any `json:"categorize_text"`
+					} `json:"patterns"`
+				} `json:"aggs"`
+			} `json:"errors"`
+		} `json:"aggs"`
+	}
+	if err := json.Unmarshal([]byte(requests[0].Body), &body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	if body.Size != 5 || !body.TrackTotalHits {
+		t.Errorf("body = %s, want 5 hits and exact totals", requests[0].Body)
+	}
+	if body.Aggs.Severity["terms"]["field"] != "severity" || body.Aggs.Services["terms"]["field"] != "service" || body.Aggs.Services["terms"]["size"] != float64(5) {
+		t.Errorf("body = %s, want severity and top 5 service terms", requests[0].Body)
+	}
+	if !strings.Contains(string(body.Aggs.Errors.Filter), `"ERROR"`) || body.Aggs.Errors.Aggs.Patterns.CategorizeText["size"] != float64(3) {
+		t.Errorf("body = %s, want 3 categories of error logs", requests[0].Body)
+	}
+}
+
+func TestSummarizeClientSidePatterns(t *testing.T) {
+	p, transport := newTestProvider(t, Config{PageSize: 100}, func(req recordedRequest) (int, string) {
+		if req.Path == "/" {
+			return 200, `{"version":{"number":"7.10.2"}}`
+		}
+		hits := make([]string, 0, 8)
+		for i := 0; i < 8; i++ {
+			severity, message := "error", fmt.Sprintf("disk full on node-%d", i)
+			if i%4 == 3 {
+				severity, message = "info", "heartbeat"
+			}
+			hits = append(hits, fmt.Sprintf(`{"_id":"h%d","_source":{"message":%q,"severity":%q}}`, i, message, severity))
+		}
+		return 200, `{"hits":{"total":{"value":8,"relation":"eq"},"hits":[` + strings.Join(hits, ",") + `]},"aggregations":{"severity":{"buckets":[]},"services":{"buckets":[]}}}`
+	})
+
+	summary, err := p.Summarize(context.Background(), schema.LogQuery{})
+	if err != nil {
+		t.Fatalf("summarize failed: %v", err)
+	}
+	if len(summary.Patterns) != 1 || summary.Patterns[0].Pattern != "disk full on <*>" || summary.Patterns[0].Count != 6 {
+		t.Errorf("patterns = %+v, want the error messages grouped", summary.Patterns)
+	}
+	if len(summary.Samples) != 5 {
+		t.Errorf("samples = %d, want 5", len(summary.Samples))
+	}
+
+	body := searchRequests(transport.recorded())[0].Body
+	if strings.Contains(body, "categorize_text") || !strings.Contains(body, `"size":100`) {
+		t.Errorf("body = %s, want a page of hits instead of categorize_text", body)
+	}
+}
@@ -0,0 +1,142 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+const (
+	defaultTailInterval = 2 * time.Second
+	defaultTailOverlap  = 5 * time.Second
+)
+
+func (p *ElasticProvider) tailInterval() time.Duration {
+	if p.cfg.TailInterval > 0 {
+		return p.cfg.TailInterval
+	}
+	return defaultTailInterval
+}
+
+func (p *ElasticProvider) tailOverlap() time.Duration {
+	if p.cfg.TailOverlap > 0 {
+		return p.cfg.TailOverlap
+	}
+	return defaultTailOverlap
+}
+
+// tailState tracks what a tail has already delivered.
+type tailState struct {
+	// newest is the timestamp of the newest entry delivered so far.
+	newest time.Time
+	// seen maps delivered documents (index/id) to their timestamps, so
+	// entries in the overlap window are not delivered twice.
+	seen map[string]time.Time
+}
+
+// prune forgets documents that fell out of the overlap window.
+func (s *tailState) prune(overlap time.Duration) {
+	for key, ts := range s.seen {
+		if ts.Before(s.newest.Add(-overlap)) {
+			delete(s.seen, key)
+		}
+	}
+}
+
+// Tail follows a query, calling fn with the entries that arrive, oldest
+// first, every TailInterval until ctx is cancelled. It starts at the query's
+// Start, or now when unset, and ignores End. Each poll reaches back
+// TailOverlap before the newest entry seen and skips entries already
+// delivered. Cancellation ends the tail without an error; an error from fn
+// or a failed poll ends it with that error.
+func (p *ElasticProvider) Tail(ctx context.Context, query schema.LogQuery, fn func([]schema.LogEntry) error) error {
+	ctx = withAudit(ctx, "log.tail", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return err
+	}
+	if !p.canScroll(query) {
+		return fmt.Errorf("tail does not support %s or %s", QueryOptionCursor, QueryOptionOffset)
+	}
+
+	state := &tailState{newest: query.Start, seen: map[string]time.Time{}}
+	if state.newest.IsZero() {
+		state.newest = time.Now()
+	}
+	interval, overlap := p.tailInterval(), p.tailOverlap()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		if err := p.tailPoll(ctx, query, state, overlap, fn); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		state.prune(overlap)
+		timer.Reset(interval)
+	}
+}
+
+// tailPoll delivers the entries newer than state, reading oldest first with
+// search_after on (timestamp, tiebreaker) until a page comes back short.
+func (p *ElasticProvider) tailPoll(ctx context.Context, query schema.LogQuery, state *tailState, overlap time.Duration, fn func([]schema.LogEntry) error) error {
+	pageSize := p.pageSize()
+
+	poll := query
+	poll.Start = state.newest.Add(-overlap)
+	poll.End = time.Time{}
+	poll.Limit = pageSize
+	poll.Metadata = make(map[string]any, len(query.Metadata)+1)
+	for key, value := range query.Metadata {
+		poll.Metadata[key] = value
+	}
+	poll.Metadata[QueryOptionOrder] = orderAsc
+
+	var after []any
+	for {
+		esQuery := p.buildQuery(poll)
+		if after != nil {
+			esQuery["search_after"] = after
+		}
+		result, err := p.search(ctx, p.cfg.IndexPattern, esQuery)
+		if err != nil {
+			return err
+		}
+
+		hits := result.Hits.Hits
+		fresh := hits[:0:0]
+		for _, hit := range hits {
+			if _, ok := state.seen[hit.Index+"/"+hit.ID]; !ok {
+				fresh = append(fresh, hit)
+			}
+		}
+		if len(fresh) > 0 {
+			result.Hits.Hits = fresh
+			entries := p.appendResult(ctx, make([]schema.LogEntry, 0, len(fresh)), result)
+			for i, hit := range fresh {
+				ts := entries[i].Timestamp
+				state.seen[hit.Index+"/"+hit.ID] = ts
+				if ts.After(state.newest) {
+					state.newest = ts
+				}
+			}
+			if err := fn(entries); err != nil {
+				return err
+			}
+		}
+
+		if len(hits) < pageSize || len(hits[len(hits)-1].Sort) == 0 {
+			return nil
+		}
+		after = hits[len(hits)-1].Sort
+	}
+}
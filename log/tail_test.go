@@ -0,0 +1,175 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+type tailDoc struct {
+	id  string
+	ts  time.Time
+	seq int64
+}
+
+// tailServer plays Elasticsearch over documents that arrive poll by poll:
+// arrivals[i] becomes visible from the (i+1)th poll on. It honours the
+// range start, ascending sort, size and search_after.
+type tailServer struct {
+	t *testing.T
+	// done is called on the first poll after the arrivals run out.
+	done     func()
+	mu       sync.Mutex
+	arrivals [][]tailDoc
+	polls    int
+	visible  []tailDoc
+}
+
+func (s *tailServer) handle(req recordedRequest) (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var body struct {
+		Size        int     `json:"size"`
+		SearchAfter []int64 `json:"search_after"`
+		Query       struct {
+			Bool struct {
+				Must []struct {
+					Range map[string]struct {
+						Gte string `json:"gte"`
+					} `json:"range"`
+				} `json:"must"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+		s.t.Errorf("failed to decode request body: %v", err)
+	}
+	if !strings.Contains(req.Body, `"order":"asc"`) {
+		s.t.Errorf("body = %s, want ascending order", req.Body)
+	}
+
+	// A poll starts without search_after; make the next arrivals visible
+	if body.SearchAfter == nil {
+		if s.polls < len(s.arrivals) {
+			s.visible = append(s.visible, s.arrivals[s.polls]...)
+		} else if s.done != nil {
+			s.done()
+		}
+		s.polls++
+	}
+
+	var since time.Time
+	for _, clause := range body.Query.Bool.Must {
+		if r, ok := clause.Range["@timestamp"]; ok {
+			since, _ = time.Parse(time.RFC3339, r.Gte)
+		}
+	}
+
+	hits := []string{}
+	for _, doc := range sortedTailDocs(s.visible) {
+		millis := doc.ts.UnixMilli()
+		if doc.ts.Before(since) {
+			continue
+		}
+		if body.SearchAfter != nil && (millis < body.SearchAfter[0] || (millis == body.SearchAfter[0] && doc.seq <= body.SearchAfter[1])) {
+			continue
+		}
+		if len(hits) == body.Size {
+			break
+		}
+		hits = append(hits, fmt.Sprintf(`{"_index":"logs-app","_id":%q,"_source":{"@timestamp":%q,"message":%q},"sort":[%d,%d]}`,
+			doc.id, doc.ts.Format(time.RFC3339Nano), doc.id, millis, doc.seq))
+	}
+	return 200, `{"hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+}
+
+func sortedTailDocs(docs []tailDoc) []tailDoc {
+	out := append([]tailDoc{}, docs...)
+	for i := 1; i < len(out); i++ {
+		for j := i; j > 0 && (out[j].ts.Before(out[j-1].ts) || (out[j].ts.Equal(out[j-1].ts) && out[j].seq < out[j-1].seq)); j-- {
+			out[j], out[j-1] = out[j-1], out[j]
+		}
+	}
+	return out
+}
+
+func TestTailFollowsNewEntries(t *testing.T) {
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	server := &tailServer{t: t, arrivals: [][]tailDoc{
+		{{id: "a", ts: at(1), seq: 1}, {id: "b", ts: at(2), seq: 2}, {id: "c", ts: at(3), seq: 3}},
+		{{id: "d", ts: at(10), seq: 4}},
+		// e is indexed late with a timestamp before d but within the overlap
+		{{id: "e", ts: at(8), seq: 5}, {id: "f", ts: at(11), seq: 6}},
+		// g is older than the overlap window and is never seen
+		{{id: "g", ts: at(2), seq: 7}},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.done = cancel
+	p, _ := newTestProvider(t, Config{PageSize: 2, TailInterval: time.Millisecond, TailOverlap: 5 * time.Second}, server.handle)
+
+	var got []string
+	err := p.Tail(ctx, schema.LogQuery{Start: start}, func(batch []schema.LogEntry) error {
+		for _, entry := range batch {
+			got = append(got, entry.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("tail failed: %v", err)
+	}
+
+	if want := "a,b,c,d,e,f"; strings.Join(got, ",") != want {
+		t.Errorf("entries = %s, want %s once each", strings.Join(got, ","), want)
+	}
+}
+
+func TestTailStopsOnCallbackError(t *testing.T) {
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	server := &tailServer{t: t, arrivals: [][]tailDoc{{{id: "a", ts: start, seq: 1}}}}
+	p, _ := newTestProvider(t, Config{TailInterval: time.Millisecond}, server.handle)
+
+	stop := errors.New("stop")
+	err := p.Tail(context.Background(), schema.LogQuery{Start: start}, func(batch []schema.LogEntry) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("err = %v, want the callback error", err)
+	}
+}
+
+func TestTailPollError(t *testing.T) {
+	p, _ := newTestProvider(t, Config{TailInterval: time.Millisecond}, func(req recordedRequest) (int, string) {
+		return 500, `{"error":{"type":"search_phase_execution_exception","reason":"all shards failed"}}`
+	})
+
+	err := p.Tail(context.Background(), schema.LogQuery{}, func(batch []schema.LogEntry) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "all shards failed") {
+		t.Errorf("err = %v, want the search error", err)
+	}
+}
+
+func TestTailCancelledIsNotAnError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p, transport := newTestProvider(t, Config{TailInterval: time.Hour}, func(req recordedRequest) (int, string) {
+		cancel()
+		return 200, `{"hits":{"hits":[]}}`
+	})
+
+	if err := p.Tail(ctx, schema.LogQuery{}, func(batch []schema.LogEntry) error { return nil }); err != nil {
+		t.Errorf("err = %v, want none after cancellation", err)
+	}
+	if len(transport.recorded()) != 1 {
+		t.Errorf("requests = %d, want one poll", len(transport.recorded()))
+	}
+}
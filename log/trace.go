@@ -0,0 +1,85 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// TimeWindow bounds a lookup in time. A zero Start or End leaves that side
+// open.
+type TimeWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// maxTraceEntries caps the entries QueryByTrace returns.
+const maxTraceEntries = 10000
+
+// traceIDFields returns the candidate fields holding a trace ID, in
+// precedence order.
+func (p *ElasticProvider) traceIDFields() []string {
+	if len(p.cfg.TraceIDFields) > 0 {
+		return p.cfg.TraceIDFields
+	}
+	return defaultTraceIDFields
+}
+
+// QueryByTrace returns the logs of every service carrying a trace ID within
+// the window, oldest first. A log matches when any of the trace ID
+// candidate fields holds the ID. Pages are read with search_after until the
+// logs run out or maxTraceEntries have been read.
+func (p *ElasticProvider) QueryByTrace(ctx context.Context, traceID string, window TimeWindow) ([]schema.LogEntry, error) {
+	ctx = withAudit(ctx, "log.byTrace", schema.QueryScope{})
+	if strings.TrimSpace(traceID) == "" {
+		return nil, errors.New("trace id is required")
+	}
+	query := schema.LogQuery{
+		Start:    window.Start,
+		End:      window.End,
+		Metadata: map[string]any{QueryOptionOrder: orderAsc},
+	}
+	if err := p.validateQuery(query); err != nil {
+		return nil, err
+	}
+
+	esQuery := p.buildTraceQuery(traceID, query)
+	var entries []schema.LogEntry
+	for {
+		size := min(p.pageSize(), maxTraceEntries-len(entries))
+		esQuery["size"] = size
+		result, err := p.search(ctx, p.cfg.IndexPattern, esQuery)
+		if err != nil {
+			return nil, err
+		}
+		entries = p.appendResult(ctx, entries, result)
+
+		hits := result.Hits.Hits
+		if len(hits) < size || len(hits[len(hits)-1].Sort) == 0 || len(entries) >= maxTraceEntries {
+			return entries, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		esQuery["search_after"] = hits[len(hits)-1].Sort
+	}
+}
+
+// buildTraceQuery matches the logs in query's window whose trace ID
+// candidate fields hold traceID, in query's order.
+func (p *ElasticProvider) buildTraceQuery(traceID string, query schema.LogQuery) map[string]any {
+	return map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": []map[string]any{
+					p.boolQuery(query),
+					scopeClause(p.traceIDFields(), traceID),
+				},
+			},
+		},
+		"sort": p.sortClause(query),
+	}
+}
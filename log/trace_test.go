@@ -0,0 +1,74 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryByTrace(t *testing.T) {
+	// Five logs of the trace across two services, oldest first
+	services := []string{"gateway", "checkout", "checkout", "gateway", "payments"}
+	p, transport := newTestProvider(t, Config{PageSize: 2, TraceIDFields: []string{"trace.id", "traceId"}}, func(req recordedRequest) (int, string) {
+		var body struct {
+			Size        int     `json:"size"`
+			SearchAfter []int64 `json:"search_after"`
+		}
+		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		start := 0
+		if body.SearchAfter != nil {
+			start = int(body.SearchAfter[1]) + 1
+		}
+		hits := []string{}
+		for i := start; i < len(services) && len(hits) < body.Size; i++ {
+			hits = append(hits, fmt.Sprintf(`{"_id":"l%d","_source":{"message":"span %d","service":%q,"trace.id":"4bf92f35"},"sort":[%d,%d]}`,
+				i, i, services[i], 1696161600000+i, i))
+		}
+		return 200, `{"hits":{"hits":[` + strings.Join(hits, ",") + `]}}`
+	})
+
+	start := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	entries, err := p.QueryByTrace(context.Background(), "4bf92f35", TimeWindow{Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("QueryByTrace failed: %v", err)
+	}
+	if got := messages(entries); got != "span 0,span 1,span 2,span 3,span 4" {
+		t.Errorf("entries = %s, want every span oldest first", got)
+	}
+	if entries[4].Service != "payments" {
+		t.Errorf("last service = %q, want payments", entries[4].Service)
+	}
+
+	requests := transport.recorded()
+	if len(requests) != 3 {
+		t.Fatalf("requests = %s, want three pages", requestLine(requests))
+	}
+	first := requests[0].Body
+	for _, want := range []string{
+		`{"bool":{"minimum_should_match":1,"should":[{"term":{"trace.id":"4bf92f35"}},{"term":{"traceId":"4bf92f35"}}]}}`,
+		`"sort":[{"@timestamp":{"order":"asc"`,
+		`"gte":"2023-10-01T12:00:00Z"`,
+	} {
+		if !strings.Contains(first, want) {
+			t.Errorf("first body = %s, want %s", first, want)
+		}
+	}
+	if strings.Contains(first, "search_after") {
+		t.Errorf("first body = %s, want no search_after", first)
+	}
+	if !strings.Contains(requests[2].Body, `"search_after":[1696161600003,3]`) {
+		t.Errorf("third body = %s, want search_after from the second page", requests[2].Body)
+	}
+}
+
+func TestQueryByTraceRequiresID(t *testing.T) {
+	p := &ElasticProvider{}
+	if _, err := p.QueryByTrace(context.Background(), " ", TimeWindow{}); err == nil {
+		t.Error("QueryByTrace succeeded without a trace id")
+	}
+}
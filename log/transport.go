@@ -0,0 +1,37 @@
+package log
+
+import (
+	"net/http"
+	"time"
+)
+
+// Connection pool defaults, used when the config leaves them unset. Go's
+// own default of 2 idle connections per host makes a burst of concurrent
+// searches, as a dashboard or a fanned-out query sends, open and close
+// connections over and over.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// newHTTPTransport returns the transport requests to the cluster are sent
+// over: Go's default, with its connection pool sized by the config. Every
+// transport the provider builds for the cluster starts from it.
+func newHTTPTransport(cfg Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = defaultMaxIdleConns
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if cfg.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.IdleConnTimeout = defaultIdleConnTimeout
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	return transport
+}
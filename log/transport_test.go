@@ -0,0 +1,30 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHTTPTransportPool(t *testing.T) {
+	cfg := parseConfig(map[string]any{
+		"maxIdleConns":        float64(200),
+		"maxIdleConnsPerHost": float64(32),
+		"maxConnsPerHost":     float64(64),
+		"idleConnTimeout":     "45s",
+	})
+	transport := newHTTPTransport(cfg)
+	if transport.MaxIdleConns != 200 || transport.MaxIdleConnsPerHost != 32 || transport.MaxConnsPerHost != 64 || transport.IdleConnTimeout != 45*time.Second {
+		t.Errorf("transport pool = %d idle, %d idle per host, %d per host, %s idle timeout; want the configured ones",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	transport = newHTTPTransport(parseConfig(map[string]any{}))
+	if transport.MaxIdleConns != 100 || transport.MaxIdleConnsPerHost != 10 || transport.MaxConnsPerHost != 0 || transport.IdleConnTimeout != 90*time.Second {
+		t.Errorf("transport pool = %d idle, %d idle per host, %d per host, %s idle timeout; want the defaults",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+	// The rest of Go's defaults are kept
+	if transport.Proxy == nil || transport.TLSHandshakeTimeout != 10*time.Second || !transport.ForceAttemptHTTP2 {
+		t.Error("transport lost Go's default proxy, TLS handshake timeout or HTTP/2 settings")
+	}
+}
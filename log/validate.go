@@ -0,0 +1,73 @@
+package log
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Violation is one reason a query is invalid: the field at fault, as a
+// JSON path such as expression.filters[0].operator, and what is wrong.
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every reason a query is invalid. It is in
+// ErrInvalidQuery.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.Message
+		if v.Field != "" {
+			parts[i] = v.Field + ": " + v.Message
+		}
+	}
+	return "invalid query: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool { return target == ErrInvalidQuery }
+
+// ValidateQuery checks query for mistakes found without the cluster: a
+// negative limit, a start after the end, and unknown filter operators. It
+// returns a *ValidationError listing all of them, or nil. Every query the
+// provider runs is checked; embedded callers can check queries up front.
+func ValidateQuery(query schema.LogQuery) error {
+	var violations []Violation
+	if query.Limit < 0 {
+		violations = append(violations, Violation{Field: "limit", Message: fmt.Sprintf("must not be negative, got %d", query.Limit)})
+	}
+	if !query.Start.IsZero() && !query.End.IsZero() && query.Start.After(query.End) {
+		violations = append(violations, Violation{Field: "start", Message: "must not be after end"})
+	}
+	if query.Expression != nil {
+		for i, filter := range query.Expression.Filters {
+			if _, ok := filterOperators[filter.Operator]; !ok {
+				violations = append(violations, Violation{
+					Field:   fmt.Sprintf("expression.filters[%d].operator", i),
+					Message: fmt.Sprintf("unknown operator %q on field %q; supported operators: %s", filter.Operator, filter.Field, strings.Join(operatorNames(), ", ")),
+				})
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: violations}
+}
+
+// operatorNames lists the filter operators, sorted.
+func operatorNames() []string {
+	names := make([]string, 0, len(filterOperators))
+	for op := range filterOperators {
+		names = append(names, op)
+	}
+	sort.Strings(names)
+	return names
+}
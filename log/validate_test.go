@@ -0,0 +1,134 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestValidateQuery(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query schema.LogQuery
+		want  []Violation
+	}{
+		{
+			name:  "valid",
+			query: schema.LogQuery{Start: start, End: start.Add(time.Hour), Limit: 10, Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "host.name", Operator: "=", Value: "web-1"}}}},
+		},
+		{
+			name:  "open ended",
+			query: schema.LogQuery{Start: start},
+		},
+		{
+			name:  "negative limit",
+			query: schema.LogQuery{Limit: -1},
+			want:  []Violation{{Field: "limit", Message: "must not be negative, got -1"}},
+		},
+		{
+			name:  "start after end",
+			query: schema.LogQuery{Start: start, End: start.Add(-time.Minute)},
+			want:  []Violation{{Field: "start", Message: "must not be after end"}},
+		},
+		{
+			name:  "unknown operator",
+			query: schema.LogQuery{Expression: &schema.LogExpression{Filters: []schema.LogFilter{{Field: "a", Operator: "="}, {Field: "b", Operator: "equals"}}}},
+			want:  []Violation{{Field: "expression.filters[1].operator", Message: `unknown operator "equals"`}},
+		},
+		{
+			name:  "several",
+			query: schema.LogQuery{Limit: -5, Start: start, End: start.Add(-time.Minute)},
+			want:  []Violation{{Field: "limit"}, {Field: "start"}},
+		},
+	}
+	for _, tt := range tests {
+		err := ValidateQuery(tt.query)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: err = %v, want none", tt.name, err)
+			}
+			continue
+		}
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || !errors.Is(err, ErrInvalidQuery) || len(invalid.Violations) != len(tt.want) {
+			t.Errorf("%s: err = %v, want %d violations in ErrInvalidQuery", tt.name, err, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			got := invalid.Violations[i]
+			if got.Field != want.Field || !strings.HasPrefix(got.Message, want.Message) {
+				t.Errorf("%s: violation %d = %+v, want %+v", tt.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestQueryValidatedBeforeSearch(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, `{"hits":{"hits":[]}}`
+	})
+	_, err := p.Query(context.Background(), schema.LogQuery{Limit: -1})
+	if !errors.Is(err, ErrInvalidQuery) || !strings.Contains(err.Error(), "limit: must not be negative") {
+		t.Errorf("err = %v, want the limit rejected", err)
+	}
+	if len(transport.recorded()) != 0 {
+		t.Errorf("requests = %d, want none for an invalid query", len(transport.recorded()))
+	}
+}
+
+func TestUnknownOperatorRejectedBeforeSearch(t *testing.T) {
+	p, transport := newTestProvider(t, Config{AllowWatchManagement: true}, func(req recordedRequest) (int, string) {
+		return 200, `{"hits":{"hits":[]}}`
+	})
+	query := schema.LogQuery{Expression: &schema.LogExpression{Filters: []schema.LogFilter{
+		{Field: "service", Operator: "=", Value: "checkout"},
+		{Field: "http.status", Operator: "equals", Value: "500"},
+	}}}
+	ctx := context.Background()
+	calls := map[string]func() error{
+		"Query": func() error { _, err := p.Query(ctx, query); return err },
+		"Count": func() error { _, err := p.Count(ctx, query); return err },
+		"QueryStream": func() error {
+			return p.QueryStream(ctx, query, func([]schema.LogEntry) error { return nil })
+		},
+		"Tail": func() error {
+			return p.Tail(ctx, query, func([]schema.LogEntry) error { return nil })
+		},
+		"QueryBatch":  func() error { _, errs := p.QueryBatch(ctx, []schema.LogQuery{query}); return errs[0] },
+		"SubmitAsync": func() error { _, err := p.SubmitAsync(ctx, query); return err },
+		"Export":      func() error { _, err := p.Export(ctx, query, "ndjson", io.Discard); return err },
+		"Histogram":   func() error { _, err := p.Histogram(ctx, query, time.Minute); return err },
+		"Compare":     func() error { _, err := p.Compare(ctx, query, time.Hour); return err },
+		"Aggregate": func() error {
+			_, err := p.Aggregate(ctx, query, AggregateSpec{Function: "avg", Field: "duration"})
+			return err
+		},
+		"FieldValues":      func() error { _, _, err := p.FieldValues(ctx, query, "host.name", 10); return err },
+		"Patterns":         func() error { _, err := p.Patterns(ctx, query, 10); return err },
+		"SignificantTerms": func() error { _, err := p.SignificantTerms(ctx, query, "host.name"); return err },
+		"Summarize":        func() error { _, err := p.Summarize(ctx, query); return err },
+		"SaveQuery": func() error {
+			_, err := p.SaveQuery(ctx, SavedQuery{Team: "payments", Name: "errors", Query: query})
+			return err
+		},
+		"CreateWatch": func() error {
+			_, err := p.CreateWatch(ctx, WatchSpec{Name: "errors", Query: query, Threshold: 1, Window: "5m"})
+			return err
+		},
+	}
+	for name, call := range calls {
+		err := call()
+		if !errors.Is(err, ErrInvalidQuery) || !strings.Contains(err.Error(), `unknown operator "equals" on field "http.status"`) {
+			t.Errorf("%s: err = %v, want the operator and field rejected", name, err)
+		}
+	}
+	if requests := transport.recorded(); len(requests) != 0 {
+		t.Errorf("requests = %v, want none for a query with an unknown operator", requests)
+	}
+}
@@ -0,0 +1,132 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// ValueCount is one value of a field and the number of matching logs
+// holding it.
+type ValueCount struct {
+	Value any   `json:"value"`
+	Count int64 `json:"count"`
+}
+
+const (
+	defaultFieldValues = 10
+	maxFieldValues     = 1000
+)
+
+// keywordSuffix names the keyword sub-field that dynamic mappings add to
+// text fields.
+const keywordSuffix = ".keyword"
+
+// buildFieldValuesQuery constructs a size 0 search whose terms aggregation
+// returns the top values of field among the logs matching query.
+func (p *ElasticProvider) buildFieldValuesQuery(query schema.LogQuery, field string, size int) map[string]any {
+	return map[string]any{
+		"query": p.boolQuery(query),
+		"size":  0,
+		"aggs": map[string]any{
+			"values": map[string]any{
+				"terms": map[string]any{"field": field, "size": size},
+			},
+		},
+	}
+}
+
+// FieldValues returns the most common values of a field among the logs
+// matching a query, most frequent first, and the number of logs holding
+// other values. Text fields are aggregated on their keyword sub-field.
+func (p *ElasticProvider) FieldValues(ctx context.Context, query schema.LogQuery, field string, size int) ([]ValueCount, int64, error) {
+	ctx = withAudit(ctx, "log.fieldValues", query.Scope)
+	if err := p.validateQuery(query); err != nil {
+		return nil, 0, err
+	}
+	if field == "" {
+		return nil, 0, errors.New("field is required")
+	}
+	if size <= 0 {
+		size = defaultFieldValues
+	}
+	size = min(size, maxFieldValues)
+
+	var values []ValueCount
+	var other int64
+	err := p.retry(ctx, func(ctx context.Context) (err error) {
+		values, other, err = p.fieldValues(ctx, query, field, size)
+		// Text fields cannot be aggregated; dynamic mappings keep a keyword
+		// copy
+		if err != nil && isTextFieldError(err) && !strings.HasSuffix(field, keywordSuffix) {
+			values, other, err = p.fieldValues(ctx, query, field+keywordSuffix, size)
+		}
+		return err
+	})
+	return values, other, err
+}
+
+func (p *ElasticProvider) fieldValues(ctx context.Context, query schema.LogQuery, field string, size int) ([]ValueCount, int64, error) {
+	queryBody, err := json.Marshal(p.buildFieldValuesQuery(query, field, size))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	res, err := p.client.Search(
+		p.client.Search.WithContext(ctx),
+		p.client.Search.WithIndex(p.cfg.IndexPattern),
+		p.client.Search.WithBody(bytes.NewReader(queryBody)),
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("elasticsearch search failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, 0, responseError(res)
+	}
+
+	var result struct {
+		esSearchResponse
+		Aggregations struct {
+			Values struct {
+				SumOtherDocCount int64 `json:"sum_other_doc_count"`
+				Buckets          []struct {
+					Key         any    `json:"key"`
+					KeyAsString string `json:"key_as_string"`
+					DocCount    int64  `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"values"`
+		} `json:"aggregations"`
+	}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	p.meterResponse(ctx, result)
+
+	agg := result.Aggregations.Values
+	values := make([]ValueCount, 0, len(agg.Buckets))
+	for _, b := range agg.Buckets {
+		// Booleans and dates come back as numbers with a readable string
+		value := b.Key
+		if b.KeyAsString != "" {
+			value = b.KeyAsString
+		}
+		values = append(values, ValueCount{Value: value, Count: b.DocCount})
+	}
+	return values, agg.SumOtherDocCount, nil
+}
+
+// isTextFieldError reports whether a search failed because it aggregated a
+// text field, which has no doc values.
+func isTextFieldError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "Fielddata is disabled") || strings.Contains(msg, "Text fields are not optimised")
+}
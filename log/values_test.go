@@ -0,0 +1,109 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestBuildFieldValuesQuery(t *testing.T) {
+	p := &ElasticProvider{}
+	query := schema.LogQuery{Expression: &schema.LogExpression{Search: "timeout"}, Limit: 50}
+
+	body := p.buildFieldValuesQuery(query, "service", 5)
+	search, _ := json.Marshal(p.buildQuery(query)["query"])
+	filter, _ := json.Marshal(body["query"])
+	if string(search) != string(filter) {
+		t.Errorf("query = %s, want the search query %s", filter, search)
+	}
+	if body["size"] != 0 {
+		t.Errorf("size = %v, want 0", body["size"])
+	}
+	encoded, _ := json.Marshal(body["aggs"])
+	if want := `{"values":{"terms":{"field":"service","size":5}}}`; string(encoded) != want {
+		t.Errorf("aggs = %s, want %s", encoded, want)
+	}
+}
+
+func TestFieldValues(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, `{"hits":{"hits":[]},"aggregations":{"values":{"doc_count_error_upper_bound":0,"sum_other_doc_count":17,"buckets":[
+			{"key":"checkout","doc_count":40},
+			{"key":"payments","doc_count":12}
+		]}}}`
+	})
+
+	values, other, err := p.FieldValues(context.Background(), schema.LogQuery{}, "service", 0)
+	if err != nil {
+		t.Fatalf("field values failed: %v", err)
+	}
+	if len(values) != 2 || values[0] != (ValueCount{Value: "checkout", Count: 40}) || values[1] != (ValueCount{Value: "payments", Count: 12}) {
+		t.Errorf("values = %v, want checkout 40 and payments 12", values)
+	}
+	if other != 17 {
+		t.Errorf("other = %d, want 17", other)
+	}
+	if body := transport.recorded()[0].Body; !strings.Contains(body, `"size":10`) {
+		t.Errorf("body = %s, want the default size", body)
+	}
+}
+
+func TestFieldValuesKeys(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 200, `{"aggregations":{"values":{"sum_other_doc_count":0,"buckets":[
+			{"key":503,"doc_count":4},
+			{"key":1,"key_as_string":"true","doc_count":2}
+		]}}}`
+	})
+
+	values, _, err := p.FieldValues(context.Background(), schema.LogQuery{}, "http.status", 2000)
+	if err != nil {
+		t.Fatalf("field values failed: %v", err)
+	}
+	if values[0].Value != json.Number("503") || values[1].Value != "true" {
+		t.Errorf("values = %v, want the number 503 and the string true", values)
+	}
+	if body := transport.recorded()[0].Body; !strings.Contains(body, `"size":1000}`) {
+		t.Errorf("body = %s, want the size capped at 1000", body)
+	}
+}
+
+func TestFieldValuesFallsBackToKeyword(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		if strings.Contains(req.Body, `"field":"kubernetes.namespace"`) {
+			return 400, `{"error":{"root_cause":[{"type":"illegal_argument_exception","reason":"Text fields are not optimised for operations that require per-document field data like aggregations and sorting, so these operations are disabled by default. Please use a keyword field instead. Alternatively, set fielddata=true on [kubernetes.namespace] in order to load field data by uninverting the inverted index."}],"type":"search_phase_execution_exception"},"status":400}`
+		}
+		return 200, `{"aggregations":{"values":{"sum_other_doc_count":0,"buckets":[{"key":"prod","doc_count":9}]}}}`
+	})
+
+	values, _, err := p.FieldValues(context.Background(), schema.LogQuery{}, "kubernetes.namespace", 5)
+	if err != nil {
+		t.Fatalf("field values failed: %v", err)
+	}
+	if len(values) != 1 || values[0].Value != "prod" {
+		t.Errorf("values = %v, want prod", values)
+	}
+	requests := transport.recorded()
+	if len(requests) != 2 || !strings.Contains(requests[1].Body, `"field":"kubernetes.namespace.keyword"`) {
+		t.Errorf("requests = %d, want a retry on the keyword sub-field", len(requests))
+	}
+}
+
+func TestFieldValuesErrors(t *testing.T) {
+	p, transport := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 400, `{"error":{"type":"illegal_argument_exception","reason":"Fielddata is disabled on [message.keyword]"}}`
+	})
+
+	if _, _, err := p.FieldValues(context.Background(), schema.LogQuery{}, "", 5); err == nil || !strings.Contains(err.Error(), "field is required") {
+		t.Errorf("err = %v, want a missing field error", err)
+	}
+	if _, _, err := p.FieldValues(context.Background(), schema.LogQuery{}, "message.keyword", 5); err == nil || !strings.Contains(err.Error(), "Fielddata is disabled") {
+		t.Errorf("err = %v, want the elasticsearch error", err)
+	}
+	if len(transport.recorded()) != 1 {
+		t.Errorf("requests = %d, want no retry for a keyword field", len(transport.recorded()))
+	}
+}
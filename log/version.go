@@ -0,0 +1,158 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Distributions reported in ServerInfo.
+const (
+	DistributionElasticsearch = "elasticsearch"
+	DistributionOpenSearch    = "opensearch"
+)
+
+// Version-dependent features, as listed in ServerInfo.Features.
+const (
+	featurePIT             = "pit"
+	featureCategorizeText  = "categorize_text"
+	featureESQL            = "esql"
+	featureRandomSampler   = "random_sampler"
+	featureCaseInsensitive = "case_insensitive"
+)
+
+// featureVersions maps each distribution's features to the first version
+// that has them. OpenSearch forked from 7.10 and has its own point in time
+// API, which the adapter does not speak.
+var featureVersions = map[string]map[string][2]int{
+	DistributionElasticsearch: {
+		featurePIT:             {7, 10},
+		featureCategorizeText:  {7, 16},
+		featureESQL:            {8, 11},
+		featureRandomSampler:   {8, 2},
+		featureCaseInsensitive: {7, 10},
+	},
+	DistributionOpenSearch: {
+		featureCaseInsensitive: {1, 0},
+	},
+}
+
+// ServerInfo describes the cluster the provider talks to.
+type ServerInfo struct {
+	Version      string `json:"version"`
+	Distribution string `json:"distribution"`
+	Major        int    `json:"major"`
+	Minor        int    `json:"minor"`
+	// Features lists the version-dependent features the adapter uses with
+	// this cluster; the others fall back or are refused.
+	Features []string `json:"features"`
+}
+
+// supports reports whether the cluster has feature.
+func (i ServerInfo) supports(feature string) bool {
+	since, ok := featureVersions[i.Distribution][feature]
+	if !ok {
+		return false
+	}
+	return i.Major > since[0] || (i.Major == since[0] && i.Minor >= since[1])
+}
+
+// ServerInfo returns the cluster's version and distribution. The root
+// endpoint is asked on first use and the answer cached; with AssumeVersion
+// set the cluster is never asked.
+func (p *ElasticProvider) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	p.versionMu.Lock()
+	defer p.versionMu.Unlock()
+	if p.server != nil {
+		return *p.server, nil
+	}
+
+	var info ServerInfo
+	if p.cfg.AssumeVersion != "" {
+		distribution, version, ok := strings.Cut(p.cfg.AssumeVersion, ":")
+		if !ok {
+			distribution, version = DistributionElasticsearch, p.cfg.AssumeVersion
+		}
+		if _, known := featureVersions[distribution]; !known {
+			return ServerInfo{}, fmt.Errorf("invalid assumeVersion %q: unknown distribution %q", p.cfg.AssumeVersion, distribution)
+		}
+		info = ServerInfo{Version: version, Distribution: distribution}
+	} else {
+		detected, err := p.detectServer(ctx)
+		if err != nil {
+			return ServerInfo{}, err
+		}
+		info = detected
+	}
+
+	major, minor, err := parseVersion(info.Version)
+	if err != nil {
+		return ServerInfo{}, err
+	}
+	info.Major, info.Minor = major, minor
+	for feature := range featureVersions[info.Distribution] {
+		if info.supports(feature) {
+			info.Features = append(info.Features, feature)
+		}
+	}
+	sort.Strings(info.Features)
+	p.server = &info
+	return info, nil
+}
+
+// detectServer reads the version and distribution from the root endpoint.
+func (p *ElasticProvider) detectServer(ctx context.Context) (ServerInfo, error) {
+	res, err := p.client.Info(p.client.Info.WithContext(ctx))
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("cluster info request failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return ServerInfo{}, responseError(res)
+	}
+
+	var banner struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&banner); err != nil {
+		return ServerInfo{}, fmt.Errorf("failed to parse cluster info: %w", err)
+	}
+	info := ServerInfo{Version: banner.Version.Number, Distribution: DistributionElasticsearch}
+	if banner.Version.Distribution == DistributionOpenSearch {
+		info.Distribution = DistributionOpenSearch
+	}
+	return info, nil
+}
+
+// hasFeature reports whether the cluster has feature. When the version
+// cannot be detected it returns unknown instead.
+func (p *ElasticProvider) hasFeature(ctx context.Context, feature string, unknown bool) bool {
+	info, err := p.ServerInfo(ctx)
+	if err != nil {
+		return unknown
+	}
+	return info.supports(feature)
+}
+
+// parseVersion extracts the major and minor numbers from "8.11.1".
+func parseVersion(version string) (int, int, error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unrecognized cluster version %q", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unrecognized cluster version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unrecognized cluster version %q", version)
+	}
+	return major, minor, nil
+}
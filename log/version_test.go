@@ -0,0 +1,124 @@
+package log
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func TestServerInfoGating(t *testing.T) {
+	tests := []struct {
+		name         string
+		banner       string
+		distribution string
+		features     string
+		scroll       bool
+	}{
+		{
+			name:         "6.8",
+			banner:       `{"version":{"number":"6.8.23","build_flavor":"default"},"tagline":"You Know, for Search"}`,
+			distribution: DistributionElasticsearch,
+			features:     "",
+			scroll:       true,
+		},
+		{
+			name:         "7.17",
+			banner:       `{"version":{"number":"7.17.15","build_flavor":"default"},"tagline":"You Know, for Search"}`,
+			distribution: DistributionElasticsearch,
+			features:     "case_insensitive,categorize_text,pit",
+		},
+		{
+			name:         "8.11",
+			banner:       `{"version":{"number":"8.11.1","build_flavor":"default"},"tagline":"You Know, for Search"}`,
+			distribution: DistributionElasticsearch,
+			features:     "case_insensitive,categorize_text,esql,pit,random_sampler",
+		},
+		{
+			name:         "opensearch 2.11",
+			banner:       `{"version":{"distribution":"opensearch","number":"2.11.0"},"tagline":"The OpenSearch Project: https://opensearch.org/"}`,
+			distribution: DistributionOpenSearch,
+			features:     "case_insensitive",
+			scroll:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, transport := newTestProvider(t, Config{AllowESQL: true}, func(req recordedRequest) (int, string) {
+				return 200, tt.banner
+			})
+
+			info, err := p.ServerInfo(context.Background())
+			if err != nil {
+				t.Fatalf("ServerInfo failed: %v", err)
+			}
+			if info.Distribution != tt.distribution || strings.Join(info.Features, ",") != tt.features {
+				t.Errorf("info = %+v, want %s with features %q", info, tt.distribution, tt.features)
+			}
+			if got := p.useScroll(context.Background(), schema.LogQuery{}); got != tt.scroll {
+				t.Errorf("useScroll = %v, want %v", got, tt.scroll)
+			}
+			_, err = p.QueryESQL(context.Background(), "FROM logs-*", nil)
+			if esql := !strings.Contains(tt.features, "esql"); esql != (err != nil && strings.Contains(err.Error(), "ES|QL needs")) {
+				t.Errorf("QueryESQL err = %v, want it refused only without esql", err)
+			}
+			banners := 0
+			for _, req := range transport.recorded() {
+				if req.Path == "/" {
+					banners++
+				}
+			}
+			if banners != 1 {
+				t.Errorf("requests = %s, want the banner read once", requestLine(transport.recorded()))
+			}
+		})
+	}
+}
+
+func TestServerInfoAssumeVersion(t *testing.T) {
+	tests := []struct {
+		assume string
+		want   ServerInfo
+	}{
+		{assume: "7.9.3", want: ServerInfo{Version: "7.9.3", Distribution: DistributionElasticsearch, Major: 7, Minor: 9}},
+		{assume: "opensearch:2.11.0", want: ServerInfo{Version: "2.11.0", Distribution: DistributionOpenSearch, Major: 2, Minor: 11, Features: []string{"case_insensitive"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.assume, func(t *testing.T) {
+			p, transport := newTestProvider(t, Config{AssumeVersion: tt.assume}, func(req recordedRequest) (int, string) {
+				t.Errorf("unexpected request %s %s", req.Method, req.Path)
+				return 500, `{}`
+			})
+			info, err := p.ServerInfo(context.Background())
+			if err != nil {
+				t.Fatalf("ServerInfo failed: %v", err)
+			}
+			if !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("info = %+v, want %+v", info, tt.want)
+			}
+			if len(transport.recorded()) != 0 {
+				t.Errorf("requests = %s, want none", requestLine(transport.recorded()))
+			}
+		})
+	}
+
+	p := &ElasticProvider{cfg: Config{AssumeVersion: "solr:9.0.0"}}
+	if _, err := p.ServerInfo(context.Background()); err == nil || !strings.Contains(err.Error(), "unknown distribution") {
+		t.Errorf("err = %v, want an unknown distribution", err)
+	}
+}
+
+func TestHasFeatureUnknownVersion(t *testing.T) {
+	p, _ := newTestProvider(t, Config{}, func(req recordedRequest) (int, string) {
+		return 503, `{}`
+	})
+	if !p.hasFeature(context.Background(), featureCategorizeText, true) {
+		t.Error("hasFeature = false, want the unknown fallback true")
+	}
+	if p.hasFeature(context.Background(), featureRandomSampler, false) {
+		t.Error("hasFeature = true, want the unknown fallback false")
+	}
+}
@@ -0,0 +1,546 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// Watch backends.
+const (
+	// WatchBackendWatcher evaluates watches with Elasticsearch Watcher,
+	// which needs a gold or higher license.
+	WatchBackendWatcher = "watcher"
+	// WatchBackendKibana evaluates watches as Kibana Elasticsearch query
+	// rules, available on a basic license.
+	WatchBackendKibana = "kibana"
+)
+
+// watchIDPrefix marks the watches and rules managed by the adapter.
+const watchIDPrefix = "opsorch"
+
+// maxWatches caps the watches ListWatches returns.
+const maxWatches = 1000
+
+// watcherLicenses are the license types that include Watcher.
+var watcherLicenses = map[string]bool{
+	"gold":       true,
+	"platinum":   true,
+	"enterprise": true,
+	"trial":      true,
+}
+
+// ErrWatchNotFound is returned when a watch does not exist.
+var ErrWatchNotFound = errors.New("watch not found")
+
+// WatchSpec describes a threshold alert evaluated by Elasticsearch: it
+// fires when more than Threshold logs match Query within Window, checked
+// every Interval. Watches are namespaced by the team of the query's scope;
+// the query's time range is ignored.
+type WatchSpec struct {
+	Name      string          `json:"name"`
+	Query     schema.LogQuery `json:"query"`
+	Threshold int64           `json:"threshold"`
+	// Window is a duration such as "5m", in whole seconds.
+	Window string `json:"window"`
+	// Interval is how often the watch runs (default Window).
+	Interval string `json:"interval,omitempty"`
+}
+
+// Watch is a threshold watch as registered.
+type Watch struct {
+	Name      string `json:"name"`
+	Team      string `json:"team"`
+	Threshold int64  `json:"threshold"`
+	Window    string `json:"window"`
+	Interval  string `json:"interval"`
+	// Backend is WatchBackendWatcher or WatchBackendKibana.
+	Backend string `json:"backend"`
+	Active  bool   `json:"active"`
+}
+
+// watchMetadata is stored with a watch so ListWatches can describe it.
+type watchMetadata struct {
+	Team      string `json:"team"`
+	Name      string `json:"name"`
+	Threshold int64  `json:"threshold"`
+	Window    string `json:"window"`
+	Interval  string `json:"interval"`
+}
+
+// CreateWatch registers a threshold watch, replacing the team's watch of
+// the same name. Watcher is used when the cluster license includes it;
+// otherwise the watch becomes a Kibana rule, which needs KibanaURL.
+func (p *ElasticProvider) CreateWatch(ctx context.Context, spec WatchSpec) (Watch, error) {
+	ctx = withAudit(ctx, "log.watch.create", spec.Query.Scope)
+	if err := p.checkWatchManagement(); err != nil {
+		return Watch{}, err
+	}
+	team := spec.Query.Scope.Team
+	if err := validateWatchName(team, spec.Name); err != nil {
+		return Watch{}, err
+	}
+	if err := p.validateQuery(spec.Query); err != nil {
+		return Watch{}, err
+	}
+	meta, err := spec.metadata()
+	if err != nil {
+		return Watch{}, err
+	}
+	backend, err := p.watchBackend(ctx)
+	if err != nil {
+		return Watch{}, err
+	}
+
+	if backend == WatchBackendWatcher {
+		body, err := json.Marshal(p.buildWatch(spec.Query, meta))
+		if err != nil {
+			return Watch{}, fmt.Errorf("failed to marshal watch: %w", err)
+		}
+		res, err := p.client.Watcher.PutWatch(watchID(team, spec.Name),
+			p.client.Watcher.PutWatch.WithContext(ctx),
+			p.client.Watcher.PutWatch.WithBody(bytes.NewReader(body)),
+		)
+		if err != nil {
+			return Watch{}, fmt.Errorf("creating watch failed: %w", err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return Watch{}, responseError(res)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+	} else {
+		// Kibana rules cannot be overwritten, so an existing one is replaced
+		id := kibanaRuleID(team, spec.Name)
+		if err := p.kibanaDo(ctx, http.MethodDelete, "/api/alerting/rule/"+id, nil, nil); err != nil && !errors.Is(err, ErrWatchNotFound) {
+			return Watch{}, err
+		}
+		if err := p.kibanaDo(ctx, http.MethodPost, "/api/alerting/rule/"+id, p.buildKibanaRule(spec.Query, meta), nil); err != nil {
+			return Watch{}, err
+		}
+	}
+	return meta.watch(backend, true), nil
+}
+
+// ListWatches returns a team's watches sorted by name.
+func (p *ElasticProvider) ListWatches(ctx context.Context, team string) ([]Watch, error) {
+	ctx = withAudit(ctx, "log.watch.list", schema.QueryScope{Team: team})
+	if err := p.checkWatchManagement(); err != nil {
+		return nil, err
+	}
+	backend, err := p.watchBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var watches []Watch
+	if backend == WatchBackendWatcher {
+		watches, err = p.listWatcherWatches(ctx, team)
+	} else {
+		watches, err = p.listKibanaRules(ctx, team)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(watches, func(i, j int) bool { return watches[i].Name < watches[j].Name })
+	return watches, nil
+}
+
+// DeleteWatch removes a team's watch.
+func (p *ElasticProvider) DeleteWatch(ctx context.Context, team, name string) error {
+	ctx = withAudit(ctx, "log.watch.delete", schema.QueryScope{Team: team})
+	if err := p.checkWatchManagement(); err != nil {
+		return err
+	}
+	if err := validateWatchName(team, name); err != nil {
+		return err
+	}
+	backend, err := p.watchBackend(ctx)
+	if err != nil {
+		return err
+	}
+	if backend == WatchBackendKibana {
+		return p.kibanaDo(ctx, http.MethodDelete, "/api/alerting/rule/"+kibanaRuleID(team, name), nil, nil)
+	}
+
+	res, err := p.client.Watcher.DeleteWatch(watchID(team, name), p.client.Watcher.DeleteWatch.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("deleting watch failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return ErrWatchNotFound
+	}
+	if res.IsError() {
+		return responseError(res)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
+
+func (p *ElasticProvider) checkWatchManagement() error {
+	if !p.cfg.AllowWatchManagement {
+		return unsupported("watch management is disabled; set allowWatchManagement to enable it")
+	}
+	return nil
+}
+
+// watchBackend picks Watcher when the active license includes it, and
+// Kibana rules otherwise.
+func (p *ElasticProvider) watchBackend(ctx context.Context) (string, error) {
+	res, err := p.client.License.Get(p.client.License.Get.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("reading license failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", responseError(res)
+	}
+	var result struct {
+		License struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"license"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.License.Status == "active" && watcherLicenses[result.License.Type] {
+		return WatchBackendWatcher, nil
+	}
+	if p.cfg.KibanaURL == "" {
+		return "", fmt.Errorf("watches need Watcher, which the %s license does not include; set kibanaURL to use Kibana rules instead", result.License.Type)
+	}
+	return WatchBackendKibana, nil
+}
+
+// buildWatch constructs the Watcher definition: a count of the query's
+// logs over the window ending at each scheduled run, compared with the
+// threshold. Firing is logged by Elasticsearch; teams add their own actions
+// in Kibana.
+func (p *ElasticProvider) buildWatch(query schema.LogQuery, meta watchMetadata) map[string]any {
+	window := map[string]any{
+		"range": map[string]any{
+			"@timestamp": map[string]any{
+				"gte": "{{ctx.trigger.scheduled_time}}||-" + meta.Window,
+				"lte": "{{ctx.trigger.scheduled_time}}",
+			},
+		},
+	}
+	return map[string]any{
+		"trigger": map[string]any{
+			"schedule": map[string]any{"interval": meta.Interval},
+		},
+		"input": map[string]any{
+			"search": map[string]any{
+				"request": map[string]any{
+					"indices": []string{p.cfg.IndexPattern},
+					"body": map[string]any{
+						"size":             0,
+						"track_total_hits": true,
+						"query": map[string]any{
+							"bool": map[string]any{
+								"filter": []map[string]any{window, p.unboundedQuery(query)},
+							},
+						},
+					},
+				},
+			},
+		},
+		"condition": map[string]any{
+			"compare": map[string]any{
+				"ctx.payload.hits.total": map[string]any{"gt": meta.Threshold},
+			},
+		},
+		"actions": map[string]any{
+			"log": map[string]any{
+				"logging": map[string]any{
+					"text": fmt.Sprintf("%s/%s: {{ctx.payload.hits.total}} logs in %s, above %d", meta.Team, meta.Name, meta.Window, meta.Threshold),
+				},
+			},
+		},
+		"metadata": map[string]any{watchIDPrefix: meta},
+	}
+}
+
+// buildKibanaRule constructs the Kibana Elasticsearch query rule counting
+// the query's logs over the window.
+func (p *ElasticProvider) buildKibanaRule(query schema.LogQuery, meta watchMetadata) map[string]any {
+	esQuery, _ := json.Marshal(map[string]any{"query": p.unboundedQuery(query)})
+	size, unit := splitInterval(meta.Window)
+	return map[string]any{
+		"name":         meta.Team + "/" + meta.Name,
+		"rule_type_id": ".es-query",
+		"consumer":     "alerts",
+		"schedule":     map[string]any{"interval": meta.Interval},
+		"tags":         []string{watchIDPrefix, watchTeamTag(meta.Team), watchNameTag(meta.Name)},
+		"params": map[string]any{
+			"searchType":          "esQuery",
+			"index":               []string{p.cfg.IndexPattern},
+			"timeField":           "@timestamp",
+			"esQuery":             string(esQuery),
+			"size":                0,
+			"threshold":           []int64{meta.Threshold},
+			"thresholdComparator": ">",
+			"timeWindowSize":      size,
+			"timeWindowUnit":      unit,
+		},
+		"actions": []any{},
+	}
+}
+
+// listWatcherWatches queries the adapter's watches and keeps the team's.
+func (p *ElasticProvider) listWatcherWatches(ctx context.Context, team string) ([]Watch, error) {
+	body, err := json.Marshal(map[string]any{
+		"query": map[string]any{"match_all": map[string]any{}},
+		"size":  maxWatches,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	res, err := p.client.Watcher.QueryWatches(
+		p.client.Watcher.QueryWatches.WithContext(ctx),
+		p.client.Watcher.QueryWatches.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing watches failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result struct {
+		Watches []struct {
+			ID    string `json:"_id"`
+			Watch struct {
+				Metadata map[string]watchMetadata `json:"metadata"`
+			} `json:"watch"`
+			Status struct {
+				State struct {
+					Active bool `json:"active"`
+				} `json:"state"`
+			} `json:"status"`
+		} `json:"watches"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	prefix := watchIDPrefix + ":" + team + ":"
+	watches := []Watch{}
+	for _, w := range result.Watches {
+		meta, ok := w.Watch.Metadata[watchIDPrefix]
+		if !ok || !strings.HasPrefix(w.ID, prefix) {
+			continue
+		}
+		watches = append(watches, meta.watch(WatchBackendWatcher, w.Status.State.Active))
+	}
+	return watches, nil
+}
+
+// listKibanaRules finds the adapter's rules tagged with the team.
+func (p *ElasticProvider) listKibanaRules(ctx context.Context, team string) ([]Watch, error) {
+	params := url.Values{
+		"filter":   {fmt.Sprintf("alert.attributes.tags:%q", watchTeamTag(team))},
+		"per_page": {strconv.Itoa(maxWatches)},
+	}
+	var result struct {
+		Data []struct {
+			Tags     []string `json:"tags"`
+			Enabled  bool     `json:"enabled"`
+			Schedule struct {
+				Interval string `json:"interval"`
+			} `json:"schedule"`
+			Params struct {
+				Threshold      []int64 `json:"threshold"`
+				TimeWindowSize int     `json:"timeWindowSize"`
+				TimeWindowUnit string  `json:"timeWindowUnit"`
+			} `json:"params"`
+		} `json:"data"`
+	}
+	if err := p.kibanaDo(ctx, http.MethodGet, "/api/alerting/rules/_find?"+params.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	watches := []Watch{}
+	for _, rule := range result.Data {
+		watch := Watch{
+			Team:     team,
+			Window:   strconv.Itoa(rule.Params.TimeWindowSize) + rule.Params.TimeWindowUnit,
+			Interval: rule.Schedule.Interval,
+			Backend:  WatchBackendKibana,
+			Active:   rule.Enabled,
+		}
+		for _, tag := range rule.Tags {
+			if name, ok := strings.CutPrefix(tag, watchNameTag("")); ok {
+				watch.Name = name
+			}
+		}
+		if len(rule.Params.Threshold) > 0 {
+			watch.Threshold = rule.Params.Threshold[0]
+		}
+		watches = append(watches, watch)
+	}
+	return watches, nil
+}
+
+// kibanaDo sends a request to the Kibana API with the cluster credentials
+// and decodes the response into out when given. A 404 is ErrWatchNotFound.
+func (p *ElasticProvider) kibanaDo(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal rule: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(p.cfg.KibanaURL, "/")+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build Kibana request: %w", err)
+	}
+	req.Header.Set("kbn-xsrf", "true")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+p.cfg.APIKey)
+	} else if p.cfg.Username != "" || p.cfg.Password != "" {
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+
+	client := p.kibana
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kibana request failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return ErrWatchNotFound
+	}
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return fmt.Errorf("kibana returned error: [%d] %s", res.StatusCode, msg)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// metadata validates the spec's threshold and durations and returns it as
+// stored, with the durations normalized.
+func (spec WatchSpec) metadata() (watchMetadata, error) {
+	if spec.Threshold < 0 {
+		return watchMetadata{}, fmt.Errorf("invalid watch threshold %d: must not be negative", spec.Threshold)
+	}
+	window, err := parseWatchDuration("window", spec.Window)
+	if err != nil {
+		return watchMetadata{}, err
+	}
+	interval := window
+	if spec.Interval != "" {
+		if interval, err = parseWatchDuration("interval", spec.Interval); err != nil {
+			return watchMetadata{}, err
+		}
+	}
+	return watchMetadata{
+		Team:      spec.Query.Scope.Team,
+		Name:      spec.Name,
+		Threshold: spec.Threshold,
+		Window:    formatInterval(window),
+		Interval:  formatInterval(interval),
+	}, nil
+}
+
+func (meta watchMetadata) watch(backend string, active bool) Watch {
+	return Watch{
+		Name:      meta.Name,
+		Team:      meta.Team,
+		Threshold: meta.Threshold,
+		Window:    meta.Window,
+		Interval:  meta.Interval,
+		Backend:   backend,
+		Active:    active,
+	}
+}
+
+// parseWatchDuration parses a watch window or interval, which both
+// backends take in whole seconds.
+func parseWatchDuration(name, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid watch %s: %w", name, err)
+	}
+	if d < time.Second || d%time.Second != 0 {
+		return 0, fmt.Errorf("invalid watch %s %s: must be whole seconds", name, d)
+	}
+	return d, nil
+}
+
+// formatInterval writes d in the largest unit of s, m, h and d that
+// divides it, as Watcher and Kibana take intervals.
+func formatInterval(d time.Duration) string {
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}} {
+		if d%unit.size == 0 {
+			return strconv.FormatInt(int64(d/unit.size), 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+}
+
+// splitInterval splits a formatInterval result into its size and unit.
+func splitInterval(interval string) (int, string) {
+	size, _ := strconv.Atoi(interval[:len(interval)-1])
+	return size, interval[len(interval)-1:]
+}
+
+// validateWatchName rejects names that cannot identify a watch.
+func validateWatchName(team, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("watch name is required")
+	}
+	if strings.Contains(team, ":") {
+		return fmt.Errorf("invalid team %q: must not contain ':'", team)
+	}
+	return nil
+}
+
+// watchID is the Watcher id of a team's watch.
+func watchID(team, name string) string {
+	return url.PathEscape(watchIDPrefix + ":" + team + ":" + name)
+}
+
+// kibanaRuleID is the Kibana rule id of a team's watch. Kibana takes only
+// UUIDs, so one is derived from the team and name.
+func kibanaRuleID(team, name string) string {
+	sum := sha256.Sum256([]byte(team + ":" + name))
+	sum[6] = sum[6]&0x0f | 0x40
+	sum[8] = sum[8]&0x3f | 0x80
+	id := hex.EncodeToString(sum[:16])
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+func watchTeamTag(team string) string { return watchIDPrefix + "-team:" + team }
+func watchNameTag(name string) string { return watchIDPrefix + "-name:" + name }
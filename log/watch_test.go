@@ -0,0 +1,215 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func checkoutErrorsWatch() WatchSpec {
+	return WatchSpec{
+		Name: "checkout errors",
+		Query: schema.LogQuery{
+			Scope:      schema.QueryScope{Team: "payments", Service: "checkout"},
+			Expression: &schema.LogExpression{Search: "declined"},
+		},
+		Threshold: 100,
+		Window:    "300s",
+	}
+}
+
+func TestCreateWatchWithWatcher(t *testing.T) {
+	p, transport := newTestProvider(t, Config{AllowWatchManagement: true}, func(req recordedRequest) (int, string) {
+		if req.Path == "/_license" {
+			return 200, `{"license":{"status":"active","type":"platinum"}}`
+		}
+		return 201, `{"_id":"opsorch:payments:checkout errors","created":true}`
+	})
+
+	watch, err := p.CreateWatch(context.Background(), checkoutErrorsWatch())
+	if err != nil {
+		t.Fatalf("CreateWatch failed: %v", err)
+	}
+	want := Watch{Name: "checkout errors", Team: "payments", Threshold: 100, Window: "5m", Interval: "5m", Backend: WatchBackendWatcher, Active: true}
+	if watch != want {
+		t.Errorf("watch = %+v, want %+v", watch, want)
+	}
+
+	requests := transport.recorded()
+	if got := requestLine(requests); !strings.Contains(got, "PUT /_watcher/watch/opsorch:payments:checkout errors") {
+		t.Fatalf("requests = %s, want the watch put", got)
+	}
+	var body struct {
+		Trigger struct {
+			Schedule struct {
+				Interval string `json:"interval"`
+			} `json:"schedule"`
+		} `json:"trigger"`
+		Input struct {
+			Search struct {
+				Request struct {
+					Indices []string       `json:"indices"`
+					Body    map[string]any `json:"body"`
+				} `json:"request"`
+			} `json:"search"`
+		} `json:"input"`
+		Condition struct {
+			Compare map[string]map[string]int64 `json:"compare"`
+		} `json:"condition"`
+		Metadata struct {
+			Opsorch watchMetadata `json:"opsorch"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(requests[1].Body), &body); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	if body.Trigger.Schedule.Interval != "5m" {
+		t.Errorf("interval = %q, want 5m", body.Trigger.Schedule.Interval)
+	}
+	if got := body.Input.Search.Request.Indices; len(got) != 1 || got[0] != "logs-*" {
+		t.Errorf("indices = %v, want the index pattern", got)
+	}
+	search, _ := json.Marshal(body.Input.Search.Request.Body)
+	for _, want := range []string{
+		`"size":0`,
+		`{"range":{"@timestamp":{"gte":"{{ctx.trigger.scheduled_time}}||-5m","lte":"{{ctx.trigger.scheduled_time}}"}}}`,
+		`"query":"declined"`,
+		`{"match_phrase":{"service":"checkout"}}`,
+	} {
+		if !strings.Contains(string(search), want) {
+			t.Errorf("search = %s, want %s", search, want)
+		}
+	}
+	if body.Condition.Compare["ctx.payload.hits.total"]["gt"] != 100 {
+		t.Errorf("condition = %v, want more than 100 hits", body.Condition.Compare)
+	}
+	if body.Metadata.Opsorch.Team != "payments" || body.Metadata.Opsorch.Name != "checkout errors" {
+		t.Errorf("metadata = %+v, want the team and name", body.Metadata.Opsorch)
+	}
+}
+
+func TestCreateWatchWithKibanaRule(t *testing.T) {
+	p, transport := newTestProvider(t, Config{AllowWatchManagement: true, KibanaURL: "http://kibana.test:5601/", APIKey: "secret"}, func(req recordedRequest) (int, string) {
+		switch {
+		case req.Path == "/_license":
+			return 200, `{"license":{"status":"active","type":"basic"}}`
+		case req.Method == "DELETE":
+			return 404, `{"statusCode":404}`
+		}
+		return 200, `{"id":"rule"}`
+	})
+	p.kibana = &http.Client{Transport: transport}
+
+	spec := checkoutErrorsWatch()
+	spec.Interval = "1m"
+	watch, err := p.CreateWatch(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("CreateWatch failed: %v", err)
+	}
+	if watch.Backend != WatchBackendKibana || watch.Interval != "1m" {
+		t.Errorf("watch = %+v, want a Kibana rule every minute", watch)
+	}
+
+	requests := transport.recorded()
+	id := kibanaRuleID("payments", "checkout errors")
+	if got := requestLine(requests); !strings.Contains(got, "DELETE /api/alerting/rule/"+id) || !strings.Contains(got, "POST /api/alerting/rule/"+id) {
+		t.Fatalf("requests = %s, want the rule replaced", got)
+	}
+	post := requests[len(requests)-1]
+	if post.Header.Get("kbn-xsrf") != "true" || post.Header.Get("Authorization") != "ApiKey secret" {
+		t.Errorf("headers = %v, want kbn-xsrf and the API key", post.Header)
+	}
+	var rule struct {
+		RuleTypeID string   `json:"rule_type_id"`
+		Tags       []string `json:"tags"`
+		Params     struct {
+			EsQuery             string  `json:"esQuery"`
+			Threshold           []int64 `json:"threshold"`
+			ThresholdComparator string  `json:"thresholdComparator"`
+			TimeWindowSize      int     `json:"timeWindowSize"`
+			TimeWindowUnit      string  `json:"timeWindowUnit"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(post.Body), &rule); err != nil {
+		t.Fatalf("failed to decode request body: %v", err)
+	}
+	if rule.RuleTypeID != ".es-query" || strings.Join(rule.Tags, ",") != "opsorch,opsorch-team:payments,opsorch-name:checkout errors" {
+		t.Errorf("rule = %s, want a tagged Elasticsearch query rule", post.Body)
+	}
+	if rule.Params.ThresholdComparator != ">" || len(rule.Params.Threshold) != 1 || rule.Params.Threshold[0] != 100 || rule.Params.TimeWindowSize != 5 || rule.Params.TimeWindowUnit != "m" {
+		t.Errorf("params = %+v, want more than 100 in 5m", rule.Params)
+	}
+	if !strings.Contains(rule.Params.EsQuery, `"query":"declined"`) || strings.Contains(rule.Params.EsQuery, "@timestamp") {
+		t.Errorf("esQuery = %s, want the filter without a time range", rule.Params.EsQuery)
+	}
+}
+
+func TestWatchBackend(t *testing.T) {
+	tests := []struct {
+		license   string
+		kibanaURL string
+		want      string
+		wantErr   bool
+	}{
+		{license: `{"status":"active","type":"gold"}`, want: WatchBackendWatcher},
+		{license: `{"status":"active","type":"trial"}`, want: WatchBackendWatcher},
+		{license: `{"status":"expired","type":"platinum"}`, kibanaURL: "http://kibana.test", want: WatchBackendKibana},
+		{license: `{"status":"active","type":"basic"}`, kibanaURL: "http://kibana.test", want: WatchBackendKibana},
+		{license: `{"status":"active","type":"basic"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		p, _ := newTestProvider(t, Config{KibanaURL: tt.kibanaURL}, func(req recordedRequest) (int, string) {
+			return 200, `{"license":` + tt.license + `}`
+		})
+		got, err := p.watchBackend(context.Background())
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("watchBackend(%s, %q) = %q, %v; want %q", tt.license, tt.kibanaURL, got, err, tt.want)
+		}
+	}
+}
+
+func TestListWatches(t *testing.T) {
+	p, _ := newTestProvider(t, Config{AllowWatchManagement: true}, func(req recordedRequest) (int, string) {
+		if req.Path == "/_license" {
+			return 200, `{"license":{"status":"active","type":"enterprise"}}`
+		}
+		return 200, `{"count":3,"watches":[
+			{"_id":"opsorch:payments:slow","watch":{"metadata":{"opsorch":{"team":"payments","name":"slow","threshold":5,"window":"1h","interval":"10m"}}},"status":{"state":{"active":false}}},
+			{"_id":"opsorch:sre:errors","watch":{"metadata":{"opsorch":{"team":"sre","name":"errors","threshold":1,"window":"1m","interval":"1m"}}},"status":{"state":{"active":true}}},
+			{"_id":"opsorch:payments:errors","watch":{"metadata":{"opsorch":{"team":"payments","name":"errors","threshold":100,"window":"5m","interval":"5m"}}},"status":{"state":{"active":true}}},
+			{"_id":"cluster_health","watch":{},"status":{"state":{"active":true}}}
+		]}`
+	})
+
+	watches, err := p.ListWatches(context.Background(), "payments")
+	if err != nil {
+		t.Fatalf("ListWatches failed: %v", err)
+	}
+	if len(watches) != 2 || watches[0].Name != "errors" || watches[1].Name != "slow" || watches[1].Active || watches[1].Window != "1h" {
+		t.Errorf("watches = %+v, want the team's watches by name", watches)
+	}
+}
+
+func TestWatchManagementDisabled(t *testing.T) {
+	p := &ElasticProvider{}
+	if _, err := p.CreateWatch(context.Background(), checkoutErrorsWatch()); err == nil || !strings.Contains(err.Error(), "allowWatchManagement") {
+		t.Errorf("err = %v, want watch management disabled", err)
+	}
+}
+
+func TestWatchSpecValidation(t *testing.T) {
+	for _, spec := range []WatchSpec{
+		{Window: "5m", Threshold: -1},
+		{Window: ""},
+		{Window: "1500ms"},
+		{Window: "5m", Interval: "soon"},
+	} {
+		if _, err := spec.metadata(); err == nil {
+			t.Errorf("metadata(%+v) succeeded, want an error", spec)
+		}
+	}
+}
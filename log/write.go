@@ -0,0 +1,163 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+// defaultWriteBatchSize is how many entries one bulk request carries when no
+// writeBatchSize is configured.
+const defaultWriteBatchSize = 500
+
+// WriteFailure is an entry Elasticsearch refused to index.
+type WriteFailure struct {
+	// Position is the entry's index in the written slice.
+	Position int    `json:"position"`
+	Status   int    `json:"status"`
+	Type     string `json:"type,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// WriteError reports the entries of a WriteEntries call that were not
+// indexed. The other entries were written.
+type WriteError struct {
+	Entries  int
+	Failures []WriteFailure
+}
+
+func (e *WriteError) Error() string {
+	first := e.Failures[0]
+	return fmt.Sprintf("%d of %d entries failed to write; entry %d: [%d] %s: %s",
+		len(e.Failures), e.Entries, first.Position, first.Status, first.Type, first.Reason)
+}
+
+// WriteEntries indexes entries into index with the bulk API, writeBatchSize
+// entries per request, so that annotations and audit events show up next to
+// the logs. Writes must be enabled with AllowWrites and the index must match
+// WriteIndexPatterns. Entries refused by Elasticsearch are reported in a
+// *WriteError; a failed request stops the write, leaving earlier batches
+// written.
+func (p *ElasticProvider) WriteEntries(ctx context.Context, index string, entries []schema.LogEntry) error {
+	ctx = withAudit(ctx, "log.write", schema.QueryScope{})
+	if !p.cfg.AllowWrites {
+		return unsupported("writes are disabled; set allowWrites to enable them")
+	}
+	if index == "" || strings.ContainsAny(index, "*,") {
+		return fmt.Errorf("invalid write index %q: must name one index or data stream", index)
+	}
+	if !patternsAllow(p.cfg.WriteIndexPatterns, IndexInfo{Name: index}) {
+		return fmt.Errorf("index %q is not in writeIndexPatterns", index)
+	}
+
+	batchSize := p.cfg.WriteBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultWriteBatchSize
+	}
+	var failures []WriteFailure
+	for start := 0; start < len(entries); start += batchSize {
+		end := min(start+batchSize, len(entries))
+		batchFailures, err := p.bulkWrite(ctx, index, entries[start:end])
+		if err != nil {
+			return fmt.Errorf("writing entries %d to %d: %w", start, end-1, err)
+		}
+		for _, failure := range batchFailures {
+			failure.Position += start
+			failures = append(failures, failure)
+		}
+	}
+	if len(failures) > 0 {
+		return &WriteError{Entries: len(entries), Failures: failures}
+	}
+	return nil
+}
+
+// bulkWrite indexes one batch and returns the entries it refused.
+func (p *ElasticProvider) bulkWrite(ctx context.Context, index string, entries []schema.LogEntry) ([]WriteFailure, error) {
+	// create works for both indices and data streams
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, entry := range entries {
+		if err := enc.Encode(map[string]any{"create": map[string]any{}}); err != nil {
+			return nil, fmt.Errorf("failed to marshal entry: %w", err)
+		}
+		if err := enc.Encode(p.entryDocument(entry)); err != nil {
+			return nil, fmt.Errorf("failed to marshal entry: %w", err)
+		}
+	}
+
+	res, err := p.client.Bulk(&body,
+		p.client.Bulk.WithContext(ctx),
+		p.client.Bulk.WithIndex(index),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch bulk request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+
+	var failures []WriteFailure
+	for i, item := range result.Items {
+		for _, action := range item {
+			if action.Error != nil {
+				failures = append(failures, WriteFailure{Position: i, Status: action.Status, Type: action.Error.Type, Reason: action.Error.Reason})
+			}
+		}
+	}
+	return failures, nil
+}
+
+// entryDocument is the reverse of normalizeHit: the document an entry is
+// indexed as. Labels and fields are written as they are, then the entry's
+// timestamp (now if unset), message, severity and service under the fields
+// they are read from.
+func (p *ElasticProvider) entryDocument(entry schema.LogEntry) map[string]any {
+	doc := make(map[string]any, len(entry.Labels)+len(entry.Fields)+4)
+	for key, value := range entry.Labels {
+		doc[key] = value
+	}
+	for key, value := range entry.Fields {
+		doc[key] = value
+	}
+
+	timestamp := entry.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	doc["@timestamp"] = timestamp.UTC().Format(time.RFC3339Nano)
+	if entry.Message != "" {
+		doc[p.patternField()] = entry.Message
+	}
+	if entry.Severity != "" {
+		doc["severity"] = entry.Severity
+	}
+	if entry.Service != "" {
+		doc[p.scopeFields(scopeService)[0]] = entry.Service
+	}
+	return doc
+}
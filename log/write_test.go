@@ -0,0 +1,130 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsorch/opsorch-core/schema"
+)
+
+func writeConfig() Config {
+	return Config{AllowWrites: true, WriteIndexPatterns: []string{"opsorch-*", "-opsorch-internal"}, WriteBatchSize: 2}
+}
+
+func TestWriteEntries(t *testing.T) {
+	p, transport := newTestProvider(t, writeConfig(), func(req recordedRequest) (int, string) {
+		if strings.Count(req.Body, "\n") == 4 {
+			return 200, `{"took":3,"errors":true,"items":[
+				{"create":{"_index":"opsorch-annotations","_id":"a","status":201}},
+				{"create":{"_index":"opsorch-annotations","status":400,"error":{"type":"document_parsing_exception","reason":"failed to parse field [count] of type [long]"}}}
+			]}`
+		}
+		return 200, `{"took":1,"errors":false,"items":[{"create":{"_index":"opsorch-annotations","_id":"c","status":201}}]}`
+	})
+
+	at := time.Date(2023, 10, 1, 12, 0, 0, 500, time.UTC)
+	entries := []schema.LogEntry{
+		{
+			Timestamp: at,
+			Message:   "deploy started",
+			Severity:  "info",
+			Service:   "checkout",
+			Labels:    map[string]string{"environment": "prod"},
+			Fields:    map[string]any{"incident.id": "INC-1"},
+			Metadata:  map[string]any{"_id": "ignored"},
+		},
+		{Timestamp: at, Message: "bad", Fields: map[string]any{"count": "many"}},
+		{Timestamp: at, Message: "deploy finished"},
+	}
+	err := p.WriteEntries(context.Background(), "opsorch-annotations", entries)
+
+	var writeErr *WriteError
+	if !errors.As(err, &writeErr) {
+		t.Fatalf("err = %v, want a *WriteError", err)
+	}
+	if writeErr.Entries != 3 || len(writeErr.Failures) != 1 {
+		t.Fatalf("failures = %+v, want one of three entries", writeErr)
+	}
+	want := WriteFailure{Position: 1, Status: 400, Type: "document_parsing_exception", Reason: "failed to parse field [count] of type [long]"}
+	if writeErr.Failures[0] != want {
+		t.Errorf("failure = %+v, want %+v", writeErr.Failures[0], want)
+	}
+
+	requests := transport.recorded()
+	if got := requestLine(requests); got != "POST /opsorch-annotations/_bulk, POST /opsorch-annotations/_bulk" {
+		t.Fatalf("requests = %s, want two batches", got)
+	}
+	lines := strings.Split(strings.TrimSuffix(requests[0].Body, "\n"), "\n")
+	if len(lines) != 4 || lines[0] != `{"create":{}}` || lines[2] != `{"create":{}}` {
+		t.Fatalf("bulk body = %q, want an action and document per entry", requests[0].Body)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &doc); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+	wantDoc := map[string]any{
+		"@timestamp":  "2023-10-01T12:00:00.0000005Z",
+		"message":     "deploy started",
+		"severity":    "info",
+		"service":     "checkout",
+		"environment": "prod",
+		"incident.id": "INC-1",
+	}
+	if len(doc) != len(wantDoc) {
+		t.Errorf("document = %v, want %v", doc, wantDoc)
+	}
+	for key, value := range wantDoc {
+		if doc[key] != value {
+			t.Errorf("document[%s] = %v, want %v", key, doc[key], value)
+		}
+	}
+	if !strings.Contains(requests[1].Body, `"message":"deploy finished"`) {
+		t.Errorf("second batch = %s, want the third entry", requests[1].Body)
+	}
+}
+
+func TestWriteEntriesGuards(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		index string
+		want  string
+	}{
+		{name: "disabled", cfg: Config{WriteIndexPatterns: []string{"opsorch-*"}}, index: "opsorch-annotations", want: "allowWrites"},
+		{name: "no allowlist", cfg: Config{AllowWrites: true}, index: "opsorch-annotations", want: "writeIndexPatterns"},
+		{name: "not allowed", cfg: writeConfig(), index: "logs-app", want: "writeIndexPatterns"},
+		{name: "excluded", cfg: writeConfig(), index: "opsorch-internal", want: "writeIndexPatterns"},
+		{name: "wildcard", cfg: writeConfig(), index: "opsorch-*", want: "one index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, transport := newTestProvider(t, tt.cfg, func(req recordedRequest) (int, string) {
+				return 200, `{"errors":false,"items":[]}`
+			})
+			err := p.WriteEntries(context.Background(), tt.index, []schema.LogEntry{{Message: "x"}})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %s", err, tt.want)
+			}
+			if len(transport.recorded()) != 0 {
+				t.Errorf("requests = %s, want none", requestLine(transport.recorded()))
+			}
+		})
+	}
+}
+
+func TestWriteEntriesRequestFailure(t *testing.T) {
+	p, _ := newTestProvider(t, writeConfig(), func(req recordedRequest) (int, string) {
+		return 403, `{"error":{"type":"security_exception","reason":"action [indices:data/write/bulk] is unauthorized"}}`
+	})
+
+	err := p.WriteEntries(context.Background(), "opsorch-annotations", []schema.LogEntry{{Message: "x"}})
+	var writeErr *WriteError
+	if err == nil || errors.As(err, &writeErr) || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("err = %v, want the request error", err)
+	}
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	adapter "github.com/opsorch/opsorch-elastic-adapter/log"
+)
+
+// cancelRequest is the cancel payload: the id of the request to cancel.
+type cancelRequest struct {
+	ID json.RawMessage `json:"id"`
+}
+
+// cancelResult is the cancel response. Cancelled is false when no request
+// with the id was in flight, usually because it had already finished.
+type cancelResult struct {
+	ID        json.RawMessage `json:"id"`
+	Cancelled bool            `json:"cancelled"`
+}
+
+// inflight tracks the requests being served by id, so cancel can reach
+// them. Requests without an id cannot be cancelled.
+type inflight struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+type inflightCall struct {
+	method string
+	cancel context.CancelFunc
+}
+
+func newInflight() *inflight {
+	return &inflight{calls: make(map[string]*inflightCall)}
+}
+
+// track registers a request's method and cancel func under its id. The
+// returned func removes it and releases the request's context once the
+// request is done.
+func (f *inflight) track(id json.RawMessage, method string, cancel context.CancelFunc) func() {
+	key, ok := requestKey(id)
+	if !ok {
+		return cancel
+	}
+	entry := &inflightCall{method: method, cancel: cancel}
+	f.mu.Lock()
+	f.calls[key] = entry
+	f.mu.Unlock()
+	return func() {
+		f.mu.Lock()
+		// A later request may have reused the id
+		if f.calls[key] == entry {
+			delete(f.calls, key)
+		}
+		f.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the request with id, reporting whether one was in flight.
+// With method set, only a request for that method is cancelled.
+func (f *inflight) cancel(id json.RawMessage, method string) bool {
+	key, ok := requestKey(id)
+	if !ok {
+		return false
+	}
+	f.mu.Lock()
+	entry, found := f.calls[key]
+	f.mu.Unlock()
+	found = found && (method == "" || entry.method == method)
+	if found {
+		entry.cancel()
+	}
+	return found
+}
+
+// requestKey returns id in compact form, so the same id matches however it
+// is spaced.
+func requestKey(id json.RawMessage) (string, bool) {
+	var buf bytes.Buffer
+	if len(id) == 0 || json.Compact(&buf, id) != nil || buf.String() == "null" {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// requestContext returns the context a request runs under, derived from
+// parent, tagged with its trace id and bounded by its timeoutMs when set.
+func requestContext(parent context.Context, req rpcRequest) (context.Context, context.CancelFunc) {
+	parent = adapter.WithTraceID(parent, req.TraceID)
+	if req.TimeoutMillis > 0 {
+		return context.WithTimeout(parent, time.Duration(req.TimeoutMillis)*time.Millisecond)
+	}
+	return context.WithCancel(parent)
+}
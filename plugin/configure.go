@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	corelog "github.com/opsorch/opsorch-core/log"
+	adapter "github.com/opsorch/opsorch-elastic-adapter/log"
+)
+
+// errNotConfigured answers requests sent without config before the
+// provider was built.
+var errNotConfigured = errors.New("no provider configured: send configure, or config with the request")
+
+// configureResult is the configure response: the capabilities of the
+// provider built.
+type configureResult struct {
+	Configured   bool                  `json:"configured"`
+	Capabilities *adapter.Capabilities `json:"capabilities,omitempty"`
+}
+
+// providerBuild is a provider being built from a config, shared by the
+// requests carrying that config. done is closed once prov or err is set.
+type providerBuild struct {
+	done chan struct{}
+	prov corelog.Provider
+	err  error
+}
+
+// ensureProvider returns the provider for a request carrying cfg. Without
+// configure, the provider is built from the first request's config, and
+// built again when a request carries a different config, replacing the
+// current one. Requests without config are served by the current provider,
+// waiting for one being built. A provider given with WithProvider or built
+// by configure serves all requests, whatever config they carry. Concurrent
+// requests carrying the same config build one provider between them, and
+// s.mu is not held while it is built.
+func (s *session) ensureProvider(cfg map[string]any) (corelog.Provider, error) {
+	var key string
+	if len(cfg) > 0 {
+		key = hashConfig(cfg)
+	}
+	s.mu.Lock()
+	for {
+		if s.provider != nil && (key == "" || s.configKey == "" || s.configKey == key) {
+			prov := s.provider
+			s.mu.Unlock()
+			return prov, nil
+		}
+		if b, ok := s.builds[key]; ok {
+			s.mu.Unlock()
+			<-b.done
+			return b.prov, b.err
+		}
+		if key != "" {
+			break
+		}
+		// A request without config waits for a provider being built
+		var pending *providerBuild
+		for _, b := range s.builds {
+			pending = b
+			break
+		}
+		if pending == nil {
+			s.mu.Unlock()
+			return nil, errNotConfigured
+		}
+		s.mu.Unlock()
+		<-pending.done
+		s.mu.Lock()
+	}
+
+	b := &providerBuild{done: make(chan struct{})}
+	if s.builds == nil {
+		s.builds = make(map[string]*providerBuild)
+	}
+	s.builds[key] = b
+	s.mu.Unlock()
+
+	prov, err := s.newProvider(cfg)
+
+	s.mu.Lock()
+	delete(s.builds, key)
+	var unused corelog.Provider
+	switch {
+	case err != nil:
+	case s.provider != nil && s.configKey == "":
+		// configure ran meanwhile, and its provider serves the request
+		unused, prov = prov, s.provider
+	default:
+		s.replaceProvider(prov, key)
+	}
+	b.prov, b.err = prov, err
+	close(b.done)
+	s.mu.Unlock()
+	if unused != nil {
+		s.closeProvider(unused)
+	}
+	return prov, err
+}
+
+// needsBuild reports whether a request carrying cfg waits for a provider
+// to be built, which is then left to the worker serving it rather than
+// the read loop.
+func (s *session) needsBuild(cfg map[string]any) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(cfg) == 0 {
+		return s.provider == nil && len(s.builds) > 0
+	}
+	return s.provider == nil || (s.configKey != "" && s.configKey != hashConfig(cfg))
+}
+
+// configure builds a provider from cfg and serves the requests read from
+// then on with it, whatever config they carry. If the new provider cannot
+// be built, the current one is kept.
+func (s *session) configure(cfg map[string]any) (configureResult, error) {
+	prov, err := s.newProvider(cfg)
+	if err != nil {
+		return configureResult{}, err
+	}
+	s.mu.Lock()
+	s.replaceProvider(prov, "")
+	s.mu.Unlock()
+
+	result := configureResult{Configured: true}
+	if elastic, ok := prov.(*adapter.ElasticProvider); ok {
+		caps := elastic.Capabilities()
+		result.Capabilities = &caps
+	}
+	return result, nil
+}
+
+// replaceProvider serves the requests from then on with prov, built from
+// the config hashed to key, or by configure when key is empty. The
+// provider it replaces is closed once the requests running with it
+// finish. s.mu must be held.
+func (s *session) replaceProvider(prov corelog.Provider, key string) {
+	if old, users := s.provider, s.users; old != nil {
+		s.retiring.Add(1)
+		go func() {
+			defer s.retiring.Done()
+			if users != nil {
+				users.Wait()
+			}
+			s.closeProvider(old)
+		}()
+	}
+	s.provider, s.users, s.configKey = prov, nil, key
+}
+
+// hashConfig hashes cfg. Maps are encoded with sorted keys, so configs
+// equal but for their key order hash alike.
+func hashConfig(cfg map[string]any) string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		data = []byte(fmt.Sprint(cfg))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newProvider builds a provider from cfg, logging with the session's
+// logger at the level cfg sets once built. s.mu must not be held, as
+// building pings the cluster.
+func (s *session) newProvider(cfg map[string]any) (corelog.Provider, error) {
+	build := s.build
+	if build == nil {
+		build = adapter.New
+	}
+	prov, err := build(cfg)
+	if err != nil {
+		s.logger.Error("failed to create provider", "error", err.Error(), "config", redactConfig(cfg))
+		return nil, err
+	}
+	level, _ := cfg["logLevel"].(string)
+	s.setLogLevel(level)
+	if elastic, ok := prov.(*adapter.ElasticProvider); ok {
+		elastic.SetLogger(s.logger)
+	}
+	s.logger.Info("provider created", "config", redactConfig(cfg))
+	return prov, nil
+}
+
+// acquire returns the provider for a request and the func releasing it
+// once the request is done, so that a provider replaced meanwhile is not
+// closed under it.
+func (s *session) acquire() (corelog.Provider, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.provider == nil {
+		return nil, func() {}
+	}
+	if s.users == nil {
+		s.users = new(sync.WaitGroup)
+	}
+	users := s.users
+	users.Add(1)
+	return s.provider, users.Done
+}
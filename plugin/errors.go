@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	adapter "github.com/opsorch/opsorch-elastic-adapter/log"
+)
+
+// Error codes set in rpcResponse.ErrorCode, so the core can tell failures
+// worth retrying from those that are not.
+const (
+	errCodeInvalidQuery        = "invalid_query"
+	errCodeConnection          = "connection"
+	errCodeAuth                = "auth"
+	errCodeTimeout             = "timeout"
+	errCodeCancelled           = "cancelled"
+	errCodeTooLarge            = "too_large"
+	errCodeUnsupported         = "unsupported"
+	errCodeUnsupportedProtocol = "unsupported_protocol_version"
+	errCodeIncompatibleCore    = "incompatible_core"
+	errCodeBusy                = "busy"
+	errCodeRateLimited         = "rate_limited"
+	errCodeInternal            = "internal"
+)
+
+// errorCodes maps the adapter's error categories to codes.
+var errorCodes = []struct {
+	category error
+	code     string
+}{
+	{adapter.ErrInvalidQuery, errCodeInvalidQuery},
+	{adapter.ErrConnection, errCodeConnection},
+	{adapter.ErrAuth, errCodeAuth},
+	{adapter.ErrTimeout, errCodeTimeout},
+	{adapter.ErrCancelled, errCodeCancelled},
+	{adapter.ErrTooLarge, errCodeTooLarge},
+	{adapter.ErrUnsupported, errCodeUnsupported},
+	{adapter.ErrIncompatibleCore, errCodeIncompatibleCore},
+	{adapter.ErrRateLimited, errCodeRateLimited},
+}
+
+// errorCode returns the code of err. Failures in no category are internal.
+func errorCode(err error) string {
+	var (
+		protocol *protocolError
+		ended    *contextError
+	)
+	if errors.As(err, &protocol) {
+		return errCodeUnsupportedProtocol
+	}
+	if errors.Is(err, errBusy) {
+		return errCodeBusy
+	}
+	// However the request failed, it failed because its context ended
+	if errors.As(err, &ended) {
+		if errors.Is(ended.cause, context.DeadlineExceeded) {
+			return errCodeTimeout
+		}
+		return errCodeCancelled
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.category) {
+			return c.code
+		}
+	}
+	return errCodeInternal
+}
+
+// errorDetails returns what is known about err beyond its message, or nil.
+func errorDetails(err error) map[string]any {
+	var (
+		protocol *protocolError
+		panicked *panicError
+		response *adapter.ResponseError
+		unknown  *adapter.UnknownFieldsError
+		invalid  *adapter.ValidationError
+		core     *adapter.CoreVersionError
+		busy     *busyError
+		circuit  *adapter.CircuitOpenError
+		offline  *adapter.DisconnectedError
+		limited  *adapter.RateLimitedError
+	)
+	switch {
+	case errors.As(err, &protocol):
+		return map[string]any{"supportedProtocolVersions": supportedProtocolVersions}
+	case errors.As(err, &busy):
+		return map[string]any{"retryAfterMs": busy.retryAfter.Milliseconds()}
+	case errors.As(err, &circuit):
+		return map[string]any{"retryAfterMs": circuit.RetryAfter.Milliseconds()}
+	case errors.As(err, &offline):
+		return map[string]any{"retryAfterMs": offline.RetryAfter.Milliseconds()}
+	case errors.As(err, &limited):
+		return map[string]any{"retryAfterMs": limited.RetryAfter.Milliseconds()}
+	case errors.As(err, &panicked):
+		return map[string]any{"stack": shortStack(panicked.stack)}
+	case errors.As(err, &unknown):
+		return map[string]any{"fields": unknown.Fields}
+	case errors.As(err, &core):
+		return map[string]any{"coreVersion": core.CoreVersion, "requiresCore": core.RequiresCore, "adapterVersion": adapter.AdapterVersion}
+	case errors.As(err, &invalid):
+		return map[string]any{"violations": invalid.Violations}
+	case errors.As(err, &response):
+		details := map[string]any{"status": response.StatusCode}
+		if response.Type != "" {
+			details["type"] = response.Type
+			details["reason"] = response.Reason
+		}
+		return details
+	}
+	return nil
+}
+
+// contextError is an error of a request whose context ended, by its
+// timeoutMs or a cancel.
+type contextError struct {
+	err   error
+	cause error
+}
+
+func (e *contextError) Error() string { return e.err.Error() }
+func (e *contextError) Unwrap() error { return e.err }
+
+// methodError is a request for a method the plugin does not serve. It is
+// in adapter.ErrUnsupported.
+type methodError struct {
+	msg string
+}
+
+func (e *methodError) Error() string        { return e.msg }
+func (e *methodError) Is(target error) bool { return target == adapter.ErrUnsupported }
+
+// panicStackFrames is how many frames of a panic's stack a response
+// carries; the full stack goes to stderr.
+const panicStackFrames = 8
+
+// panicError is a request whose handling panicked. It is in no category,
+// so it is answered as internal.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string { return fmt.Sprintf("internal error: %v", e.value) }
+
+// shortStack returns the first frames of stack from where it panicked.
+func shortStack(stack []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+	for i, line := range lines {
+		// Each frame is a call line followed by its file line
+		if strings.HasPrefix(line, "panic(") && i+2 <= len(lines) {
+			lines = lines[i+2:]
+			break
+		}
+	}
+	if len(lines) > 2*panicStackFrames {
+		lines = lines[:2*panicStackFrames]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Framings delimit the messages exchanged on stdin and stdout. Sessions
+// start with newline framing; the handshake may switch to another.
+const (
+	// framingNewline ends each message with a newline.
+	framingNewline = "newline"
+	// framingLengthPrefixed precedes each message with its length in
+	// bytes, as 4 bytes big endian.
+	framingLengthPrefixed = "length-prefixed"
+)
+
+// supportedFramings lists the framings the handshake may choose.
+var supportedFramings = []string{framingNewline, framingLengthPrefixed}
+
+// maxFrameSize bounds the length-prefixed messages read, so that a
+// corrupted prefix does not allocate gigabytes.
+const maxFrameSize = 1 << 30
+
+// Framer reads and writes the messages of a session, one JSON value each.
+type Framer interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(msg []byte) error
+}
+
+// newFramer returns a Framer for framing over r and w.
+func newFramer(framing string, r io.Reader, w io.Writer) Framer {
+	if framing == framingLengthPrefixed {
+		return &lengthFramer{r: r, w: w}
+	}
+	return &lineFramer{r: r, dec: json.NewDecoder(r), w: w}
+}
+
+// reframe returns a Framer for framing continuing where f left off.
+func reframe(f Framer, framing string) Framer {
+	switch f := f.(type) {
+	case *lineFramer:
+		if framing == framingNewline {
+			return f
+		}
+		// The decoder may have read past the message that switched, but not
+		// the newline ending it
+		rest := &afterLine{r: io.MultiReader(f.dec.Buffered(), f.r)}
+		return newFramer(framing, rest, f.w)
+	case *lengthFramer:
+		if framing == framingLengthPrefixed {
+			return f
+		}
+		return newFramer(framing, f.r, f.w)
+	}
+	return f
+}
+
+// negotiateFraming returns the first framing the core lists that the
+// plugin supports. A core that lists none keeps newline framing.
+func negotiateFraming(core []string) (string, bool) {
+	if len(core) == 0 {
+		return framingNewline, true
+	}
+	for _, framing := range core {
+		for _, supported := range supportedFramings {
+			if framing == supported {
+				return framing, true
+			}
+		}
+	}
+	return "", false
+}
+
+// lineFramer ends each message with a newline, as json.Encoder does. It
+// reads any whitespace-separated JSON values.
+type lineFramer struct {
+	r   io.Reader
+	dec *json.Decoder
+	w   io.Writer
+}
+
+func (f *lineFramer) ReadMessage() ([]byte, error) {
+	var msg json.RawMessage
+	if err := f.dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (f *lineFramer) WriteMessage(msg []byte) error {
+	_, err := f.w.Write(append(msg, '\n'))
+	return err
+}
+
+// lengthFramer precedes each message with its length.
+type lengthFramer struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (f *lengthFramer) ReadMessage() ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(f.r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the %d byte limit", n, maxFrameSize)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(f.r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (f *lengthFramer) WriteMessage(msg []byte) error {
+	if len(msg) > math.MaxUint32 {
+		return fmt.Errorf("message of %d bytes is too large to frame", len(msg))
+	}
+	// One write, so the prefix is never separated from its message
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	_, err := f.w.Write(frame)
+	return err
+}
+
+// afterLine reads r from the start of its next line.
+type afterLine struct {
+	r       io.Reader
+	skipped bool
+}
+
+func (a *afterLine) Read(p []byte) (int, error) {
+	for !a.skipped {
+		var b [1]byte
+		if _, err := io.ReadFull(a.r, b[:]); err != nil {
+			return 0, err
+		}
+		a.skipped = b[0] == '\n'
+	}
+	return a.r.Read(p)
+}
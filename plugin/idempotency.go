@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultIdempotencyCacheSize is how many completed requests are
+	// remembered by idempotency key unless idempotencyCacheSize is
+	// configured.
+	defaultIdempotencyCacheSize = 256
+	// defaultIdempotencyTTL is how long they are remembered unless
+	// idempotencyTTL is configured.
+	defaultIdempotencyTTL = 5 * time.Minute
+)
+
+// idempotencyCacheSize reads the idempotencyCacheSize config key.
+func idempotencyCacheSize(cfg map[string]any) int {
+	return positiveInt(cfg, "idempotencyCacheSize", defaultIdempotencyCacheSize)
+}
+
+// idempotencyTTL reads the idempotencyTTL config key.
+func idempotencyTTL(cfg map[string]any) time.Duration {
+	if s, ok := cfg["idempotencyTTL"].(string); ok {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultIdempotencyTTL
+}
+
+// idempotencyCache remembers the responses to requests carrying an
+// idempotency key, so a request sent again, as Core does when it retries
+// after a timeout, is answered without running twice. Completed requests
+// are kept, least recently used first out, until their TTL passes; only
+// those that succeeded are kept, so failures are retried. A duplicate of a
+// request still running waits for it and receives its responses, failed
+// or not.
+type idempotencyCache struct {
+	mu   sync.Mutex
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+	// order holds the completed entries, most recently used first, and
+	// done indexes them by key.
+	order   *list.List
+	done    map[string]*list.Element
+	running map[string]*pendingResult
+}
+
+// cachedResult is a completed request's responses.
+type cachedResult struct {
+	key       string
+	responses []rpcResponse
+	expires   time.Time
+}
+
+// pendingResult is a request running under an idempotency key. responses
+// is set before ready is closed.
+type pendingResult struct {
+	ready     chan struct{}
+	responses []rpcResponse
+}
+
+func newIdempotencyCache(size int, ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		done:    make(map[string]*list.Element),
+		running: make(map[string]*pendingResult),
+	}
+}
+
+// claim returns the responses to replay for key, waiting until ctx ends for
+// a request running with it. When no request with key is known, it returns
+// a finish func instead: the caller runs the request and passes its
+// responses to finish.
+func (c *idempotencyCache) claim(ctx context.Context, key string) ([]rpcResponse, func([]rpcResponse), error) {
+	c.mu.Lock()
+	if elem, ok := c.done[key]; ok {
+		cached := elem.Value.(*cachedResult)
+		if c.now().Before(cached.expires) {
+			c.order.MoveToFront(elem)
+			c.mu.Unlock()
+			return cached.responses, nil, nil
+		}
+		c.remove(elem)
+	}
+	if pending, ok := c.running[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-pending.ready:
+			return pending.responses, nil, nil
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	pending := &pendingResult{ready: make(chan struct{})}
+	c.running[key] = pending
+	c.mu.Unlock()
+
+	return nil, func(responses []rpcResponse) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.running, key)
+		pending.responses = responses
+		close(pending.ready)
+		if failed(responses) {
+			return
+		}
+		c.done[key] = c.order.PushFront(&cachedResult{key: key, responses: responses, expires: c.now().Add(c.ttl)})
+		for c.order.Len() > c.size {
+			c.remove(c.order.Back())
+		}
+	}, nil
+}
+
+// remove forgets a completed entry. The caller holds mu.
+func (c *idempotencyCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.done, elem.Value.(*cachedResult).key)
+}
+
+// failed reports whether any of a request's responses is an error.
+func failed(responses []rpcResponse) bool {
+	for _, res := range responses {
+		if res.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// runOnce serves the call unless a request with its idempotency key ran
+// or is running, in which case that request's responses are replayed with
+// the call's id.
+func (c *call) runOnce(serveMethod handler, cache *idempotencyCache) {
+	if c.req.IdempotencyKey == "" || cache == nil {
+		c.run(serveMethod)
+		return
+	}
+	// Keys are per method, so a key reused by another method cannot replay
+	// the wrong result
+	key := c.req.Method + "\x00" + c.req.IdempotencyKey
+	responses, finish, err := cache.claim(c.ctx, key)
+	if finish == nil {
+		c.run(func(c *call) (any, error) {
+			if err != nil {
+				return nil, err
+			}
+			c.s.logger.Debug("replaying idempotent request", "method", c.req.Method, "idempotencyKey", c.req.IdempotencyKey)
+			for _, res := range responses {
+				if err := c.enc.Encode(res); err != nil {
+					return nil, err
+				}
+			}
+			return nil, errWritten
+		})
+		return
+	}
+	c.enc.record, c.enc.finish = true, finish
+	defer func() {
+		c.enc.out.mu.Lock()
+		defer c.enc.out.mu.Unlock()
+		c.enc.finishRecording()
+	}()
+	c.run(serveMethod)
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LogLevelEnv names the environment variable the logplugin command reads
+// the log level from, as passed to WithLogLevel.
+const LogLevelEnv = "OPSORCH_LOG_LEVEL"
+
+// redacted replaces secret config values in logs.
+const redacted = "[REDACTED]"
+
+// secretKeyParts match, ignoring case, the config keys holding secrets.
+var secretKeyParts = []string{"password", "secret", "apikey", "token", "credential"}
+
+// newLogger returns a logger writing JSON lines to w at level.
+func newLogger(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// setLogLevel sets the level from a name such as debug, info, warn or
+// error. Unknown names leave it unchanged, as does any name when the
+// logger was given.
+func (s *session) setLogLevel(name string) {
+	if name == "" || s.level == nil {
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		s.logger.Warn("ignoring unknown log level", "level", name)
+		return
+	}
+	s.level.Set(level)
+}
+
+// logRequest logs a served request at info, or at warn when it failed.
+// Handlers that wrote their own responses report no error code.
+func logRequest(c *call, took time.Duration, err error) {
+	attrs := []any{"method", c.req.Method, "durationMs", took.Milliseconds()}
+	if len(c.req.ID) > 0 {
+		attrs = append(attrs, "id", c.req.ID)
+	}
+	attrs = append(attrs, "traceId", c.req.TraceID)
+	if err == nil {
+		c.s.logger.Info("request served", attrs...)
+		return
+	}
+	err = requestError(c.enc, err)
+	c.s.logger.Warn("request failed", append(attrs, "errorCode", errorCode(err), "error", err.Error())...)
+}
+
+// redactConfig returns a copy of cfg safe to log: values of secret keys
+// are replaced, as are passwords in addresses.
+func redactConfig(cfg map[string]any) map[string]any {
+	safe := make(map[string]any, len(cfg))
+	for key, value := range cfg {
+		switch {
+		case isSecretKey(key):
+			safe[key] = redacted
+		case key == "addresses":
+			safe[key] = redactAddresses(value)
+		default:
+			safe[key] = redactValue(value)
+		}
+	}
+	return safe
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return redactConfig(v)
+	case []any:
+		safe := make([]any, len(v))
+		for i, item := range v {
+			safe[i] = redactValue(item)
+		}
+		return safe
+	}
+	return value
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAddresses masks credentials embedded in address URLs.
+func redactAddresses(value any) any {
+	// Addresses arrive as []any from JSON, or []string in process
+	var addresses []string
+	raw, _ := json.Marshal(value)
+	if json.Unmarshal(raw, &addresses) != nil {
+		return redacted
+	}
+	safe := make([]string, len(addresses))
+	for i, address := range addresses {
+		u, err := url.Parse(address)
+		if err != nil {
+			safe[i] = redacted
+			continue
+		}
+		safe[i] = u.Redacted()
+	}
+	return safe
+}
@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the upper bounds, in milliseconds, of the latency
+// histogram buckets. A last bucket holds the latencies above them.
+var latencyBounds = [...]float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// histogram counts latencies into the latencyBounds buckets. Recording
+// takes no lock, so concurrent requests do not wait on each other.
+type histogram struct {
+	buckets [len(latencyBounds) + 1]atomic.Uint64
+	// sum is the total latency in microseconds.
+	sum atomic.Int64
+}
+
+func (h *histogram) observe(took time.Duration) {
+	ms := float64(took) / float64(time.Millisecond)
+	h.buckets[sort.SearchFloat64s(latencyBounds[:], ms)].Add(1)
+	h.sum.Add(took.Microseconds())
+}
+
+// latencyBucket is a histogram bucket in the stats response. LeMs is its
+// upper bound, or zero for the last bucket, which has none.
+type latencyBucket struct {
+	LeMs  float64 `json:"leMs,omitempty"`
+	Count uint64  `json:"count"`
+}
+
+// latencyStats is a histogram in the stats response. Percentiles are
+// estimated from the buckets, so they are only as precise as the bucket
+// bounds.
+type latencyStats struct {
+	Count   uint64          `json:"count"`
+	MeanMs  float64         `json:"meanMs"`
+	P50Ms   float64         `json:"p50Ms"`
+	P95Ms   float64         `json:"p95Ms"`
+	P99Ms   float64         `json:"p99Ms"`
+	Buckets []latencyBucket `json:"buckets"`
+}
+
+func (h *histogram) snapshot() latencyStats {
+	var counts [len(latencyBounds) + 1]uint64
+	var total uint64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	stats := latencyStats{Count: total, Buckets: make([]latencyBucket, 0, len(counts))}
+	for i, n := range counts {
+		bucket := latencyBucket{Count: n}
+		if i < len(latencyBounds) {
+			bucket.LeMs = latencyBounds[i]
+		}
+		stats.Buckets = append(stats.Buckets, bucket)
+	}
+	if total == 0 {
+		return stats
+	}
+	// The buckets and the sum are read one after another, so the mean may
+	// be off by a request recorded in between
+	stats.MeanMs = float64(h.sum.Load()) / 1000 / float64(total)
+	stats.P50Ms = percentile(counts[:], total, 0.50)
+	stats.P95Ms = percentile(counts[:], total, 0.95)
+	stats.P99Ms = percentile(counts[:], total, 0.99)
+	return stats
+}
+
+// percentile estimates the latency below which the share q of the total
+// recorded in counts fall, interpolating linearly within the bucket it is
+// in. Latencies in the last bucket are reported as its lower bound.
+func percentile(counts []uint64, total uint64, q float64) float64 {
+	rank := q * float64(total)
+	var seen float64
+	for i, n := range counts {
+		if n == 0 || seen+float64(n) < rank {
+			seen += float64(n)
+			continue
+		}
+		if i == len(latencyBounds) {
+			return latencyBounds[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = latencyBounds[i-1]
+		}
+		return lower + (latencyBounds[i]-lower)*(rank-seen)/float64(n)
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
+
+// methodMetrics counts one method's requests, by error code when they
+// failed, and the latency of those served.
+type methodMetrics struct {
+	requests atomic.Uint64
+	latency  histogram
+	// errors holds an *atomic.Uint64 per error code.
+	errors sync.Map
+}
+
+func (m *methodMetrics) fail(code string) {
+	n, ok := m.errors.Load(code)
+	if !ok {
+		n, _ = m.errors.LoadOrStore(code, new(atomic.Uint64))
+	}
+	n.(*atomic.Uint64).Add(1)
+}
+
+// metrics records the requests of a session per method. The methods are
+// known up front, so looking one up takes no lock either.
+type metrics struct {
+	methods map[string]*methodMetrics
+}
+
+func newMetrics() *metrics {
+	m := &metrics{methods: make(map[string]*methodMetrics, len(handlers))}
+	for method := range handlers {
+		m.methods[method] = new(methodMetrics)
+	}
+	return m
+}
+
+// record counts a request to method served in took, failed with the error
+// code when it is set.
+func (m *metrics) record(method string, took time.Duration, code string) {
+	mm, ok := m.methods[method]
+	if !ok {
+		return
+	}
+	mm.requests.Add(1)
+	mm.latency.observe(took)
+	if code != "" {
+		mm.fail(code)
+	}
+}
+
+// refused counts a request to method answered with the error code before
+// it was served, leaving its latency out of the histogram.
+func (m *metrics) refused(method, code string) {
+	mm, ok := m.methods[method]
+	if !ok {
+		return
+	}
+	mm.requests.Add(1)
+	mm.fail(code)
+}
+
+// methodStats is a method's metrics in the stats response.
+type methodStats struct {
+	Requests uint64            `json:"requests"`
+	Errors   map[string]uint64 `json:"errors,omitempty"`
+	Latency  latencyStats      `json:"latency"`
+}
+
+// snapshot returns the metrics of the methods requested so far.
+func (m *metrics) snapshot() map[string]methodStats {
+	stats := make(map[string]methodStats)
+	for method, mm := range m.methods {
+		requests := mm.requests.Load()
+		if requests == 0 {
+			continue
+		}
+		s := methodStats{Requests: requests, Latency: mm.latency.snapshot()}
+		mm.errors.Range(func(code, n any) bool {
+			if s.Errors == nil {
+				s.Errors = make(map[string]uint64)
+			}
+			s.Errors[code.(string)] = n.(*atomic.Uint64).Load()
+			return true
+		})
+		stats[method] = s
+	}
+	return stats
+}
@@ -0,0 +1,92 @@
+// Package plugin serves the elastic log provider over the OpsOrch plugin
+// RPC protocol: JSON requests read from one stream, answered on another.
+// The logplugin command serves it on stdin and stdout; Serve lets other
+// programs and tests do the same over any reader and writer.
+package plugin
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	corelog "github.com/opsorch/opsorch-core/log"
+)
+
+// Option configures Serve.
+type Option func(*options)
+
+type options struct {
+	ctx      context.Context
+	provider corelog.Provider
+	logger   *slog.Logger
+	level    string
+}
+
+// WithContext stops serving once ctx is done, as if the input had ended:
+// no further requests are read and those in flight are drained.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) { o.ctx = ctx }
+}
+
+// WithProvider serves requests with p rather than an elastic provider
+// built from the first request's config. Serve closes p on return when it
+// has a Close method.
+func WithProvider(p corelog.Provider) Option {
+	return func(o *options) { o.provider = p }
+}
+
+// WithLogger logs to l rather than as JSON lines on stderr. The level is
+// then l's own, and the logLevel config key has no effect.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithLogLevel sets the level logged at on stderr: debug, info, warn (the
+// default) or error. The logLevel config key overrides it.
+func WithLogLevel(name string) Option {
+	return func(o *options) { o.level = name }
+}
+
+// Serve answers requests read from r until r ends or the WithContext
+// context is done, writing responses to w. It returns once the requests in
+// flight are answered and the provider is closed.
+func Serve(r io.Reader, w io.Writer, opts ...Option) {
+	o := options{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &session{provider: o.provider, logger: o.logger, metrics: newMetrics()}
+	if s.logger == nil {
+		s.level = new(slog.LevelVar)
+		s.level.Set(slog.LevelWarn)
+		s.logger = newLogger(os.Stderr, s.level)
+		s.setLogLevel(o.level)
+	}
+	s.serve(o.ctx, r, w)
+}
+
+// session is the state shared by the requests Serve answers.
+type session struct {
+	// provider serves the requests, once built from the first request's
+	// config unless given, and replaced by configure or a request carrying
+	// another config. configKey hashes the config it was built from, empty
+	// for a provider given or built by configure, which requests carrying
+	// config do not replace. builds holds the providers being built from
+	// request configs by hash. users counts the requests running with the
+	// provider, and retiring the providers replaced but not yet closed. mu
+	// guards provider, configKey, builds and users.
+	mu        sync.Mutex
+	provider  corelog.Provider
+	configKey string
+	builds    map[string]*providerBuild
+	users     *sync.WaitGroup
+	retiring  sync.WaitGroup
+	// build builds providers, adapter.New unless replaced in tests.
+	build  func(cfg map[string]any) (corelog.Provider, error)
+	logger *slog.Logger
+	// level is the level logger logs at, unless the logger was given.
+	level   *slog.LevelVar
+	metrics *metrics
+}
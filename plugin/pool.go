@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxQueuedRequests is how many requests may wait for a worker
+// unless maxQueuedRequests is configured.
+const defaultMaxQueuedRequests = 64
+
+// busyRetryAfter is the delay suggested to requests refused as busy.
+const busyRetryAfter = time.Second
+
+// maxQueuedRequests reads the maxQueuedRequests config key.
+func maxQueuedRequests(cfg map[string]any) int {
+	return positiveInt(cfg, "maxQueuedRequests", defaultMaxQueuedRequests)
+}
+
+// errBusy is in busyError, so callers can match it with errors.Is.
+var errBusy = errors.New("plugin is busy")
+
+// busyError refuses a request because every worker is busy and the queue
+// is full. RetryAfter suggests when to send it again.
+type busyError struct {
+	retryAfter time.Duration
+}
+
+func (e *busyError) Error() string {
+	return fmt.Sprintf("%s: every worker is busy and the queue is full; retry after %s", errBusy, e.retryAfter)
+}
+
+func (e *busyError) Is(target error) bool { return target == errBusy }
+
+// pool bounds how many requests run at once, and how many more may wait
+// for a worker.
+type pool struct {
+	slots chan struct{}
+
+	mu        sync.Mutex
+	maxQueued int
+	queued    int
+	active    int
+}
+
+// poolStats is the worker pool's state, in the stats response.
+type poolStats struct {
+	Workers int `json:"workers"`
+	Active  int `json:"active"`
+	// Utilization is the share of workers busy, from 0 to 1.
+	Utilization float64 `json:"utilization"`
+	Queued      int     `json:"queued"`
+	MaxQueued   int     `json:"maxQueued"`
+}
+
+func newPool(workers, maxQueued int) *pool {
+	return &pool{slots: make(chan struct{}, workers), maxQueued: maxQueued}
+}
+
+// admit reserves a place for a request, running or waiting, and reports
+// false when there is none.
+func (p *pool) admit() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active+p.queued >= cap(p.slots)+p.maxQueued {
+		return false
+	}
+	p.queued++
+	return true
+}
+
+// acquire waits for a worker for an admitted request, until ctx ends. The
+// request calls release once done with the worker.
+func (p *pool) acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.queued--
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	p.queued--
+	p.active++
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *pool) release() {
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	<-p.slots
+}
+
+func (p *pool) stats() poolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return poolStats{
+		Workers:     cap(p.slots),
+		Active:      p.active,
+		Utilization: float64(p.active) / float64(cap(p.slots)),
+		Queued:      p.queued,
+		MaxQueued:   p.maxQueued,
+	}
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	adapter "github.com/opsorch/opsorch-elastic-adapter/log"
+)
+
+// protocolVersion is the newest plugin protocol this build speaks.
+const protocolVersion = 1
+
+// supportedProtocolVersions lists the protocol versions requests may name,
+// oldest first.
+var supportedProtocolVersions = []int{1}
+
+// protocolError is a request for a protocol version this plugin does not
+// speak.
+type protocolError struct {
+	msg string
+}
+
+func (e *protocolError) Error() string { return e.msg }
+
+// handshakeRequest is the optional handshake payload: the protocol
+// versions the core speaks, and the framings it accepts in order of
+// preference. Without it the core is assumed to speak version 1 with
+// newline framing.
+type handshakeRequest struct {
+	ProtocolVersions []int    `json:"protocolVersions"`
+	Framings         []string `json:"framings"`
+	// CoreVersion is checked against adapter.RequiresCore.
+	CoreVersion string `json:"coreVersion"`
+}
+
+// handshakeResult is the handshake response. ProtocolVersion is the version
+// both sides speak, to be sent on every following request, and Framing
+// delimits every message after this response.
+type handshakeResult struct {
+	ProtocolVersion           int                  `json:"protocolVersion"`
+	SupportedProtocolVersions []int                `json:"supportedProtocolVersions"`
+	Framing                   string               `json:"framing"`
+	AdapterVersion            string               `json:"adapterVersion"`
+	RequiresCore              string               `json:"requiresCore"`
+	ProviderName              string               `json:"providerName"`
+	Capabilities              adapter.Capabilities `json:"capabilities"`
+}
+
+// supportsProtocol reports whether a request naming version can be served.
+func supportsProtocol(version int) bool {
+	if version == 0 {
+		return true
+	}
+	for _, v := range supportedProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func unsupportedProtocolError(version int) error {
+	return &protocolError{msg: fmt.Sprintf("unsupported protocol version %d; supported versions: %v", version, supportedProtocolVersions)}
+}
+
+// negotiateProtocol returns the newest version spoken by both the core and
+// the plugin. A core that lists no versions speaks version 1.
+func negotiateProtocol(core []int) (int, bool) {
+	if len(core) == 0 {
+		core = []int{1}
+	}
+	best := 0
+	for _, v := range core {
+		if v > best && v <= protocolVersion && supportsProtocol(v) {
+			best = v
+		}
+	}
+	return best, best > 0
+}
+
+// coreVersion returns the core version a request names, in its
+// coreVersion field or, for a handshake, its payload.
+func coreVersion(req rpcRequest) string {
+	if req.CoreVersion != "" || req.Method != "handshake" || len(req.Payload) == 0 {
+		return req.CoreVersion
+	}
+	var hello handshakeRequest
+	_ = json.Unmarshal(req.Payload, &hello)
+	return hello.CoreVersion
+}
@@ -0,0 +1,1321 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	corelog "github.com/opsorch/opsorch-core/log"
+	"github.com/opsorch/opsorch-core/schema"
+	adapter "github.com/opsorch/opsorch-elastic-adapter/log"
+)
+
+type rpcRequest struct {
+	// ID is echoed in every response to the request, so responses to
+	// concurrent requests can be told apart. It may be any JSON value.
+	ID json.RawMessage `json:"id,omitempty"`
+	// ProtocolVersion is the protocol the request was written for. Zero
+	// means version 1, as sent by cores that predate the handshake.
+	ProtocolVersion int `json:"protocolVersion,omitempty"`
+	// TimeoutMillis bounds how long the request may run. Zero means no
+	// limit.
+	TimeoutMillis int `json:"timeoutMs,omitempty"`
+	// CoreVersion is OpsOrch Core's version, checked against
+	// adapter.RequiresCore on the first request naming it.
+	CoreVersion string `json:"coreVersion,omitempty"`
+	// TraceID identifies the request in Elasticsearch, as the X-Opaque-Id
+	// of its searches, and in logs. One is generated when it is unset.
+	TraceID string `json:"traceId,omitempty"`
+	// IdempotencyKey marks requests that are the same request sent again:
+	// one that ran recently, or is running, is answered with its responses
+	// rather than run twice.
+	IdempotencyKey string          `json:"idempotencyKey,omitempty"`
+	Method         string          `json:"method"`
+	Config         map[string]any  `json:"config"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+type rpcResponse struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Result any             `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// ErrorCode classifies Error, and Details describes it, for callers
+	// that act on failures.
+	ErrorCode string         `json:"errorCode,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+	// More is set on every response of a streaming method except the last.
+	More bool `json:"more,omitempty"`
+	// Seq numbers the parts of a result split by maxResponseBytes from 1,
+	// and Final marks the last part.
+	Seq   int  `json:"seq,omitempty"`
+	Final bool `json:"final,omitempty"`
+	// Meta carries the request's trace id, and describes the plugin when
+	// verbose is configured.
+	Meta *responseMeta `json:"meta,omitempty"`
+}
+
+// responseMeta is the meta field of responses.
+type responseMeta struct {
+	TraceID        string `json:"traceId,omitempty"`
+	AdapterVersion string `json:"adapterVersion,omitempty"`
+}
+
+type queryStatsResult struct {
+	Entries []schema.LogEntry  `json:"entries"`
+	Stats   adapter.QueryStats `json:"stats"`
+}
+
+// batchItem is one query's outcome in a log.queryBatch response.
+type batchItem struct {
+	Entries []schema.LogEntry `json:"entries"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// asyncRequest identifies an async search for log.queryPoll and
+// log.queryCancel.
+type asyncRequest struct {
+	ID string `json:"id"`
+}
+
+// capabilitiesResult is the capabilities handshake response.
+type capabilitiesResult struct {
+	AdapterVersion string `json:"adapterVersion"`
+	RequiresCore   string `json:"requiresCore"`
+	adapter.Capabilities
+}
+
+// countResult is the log.count response.
+type countResult struct {
+	Count int64 `json:"count"`
+}
+
+// histogramRequest is the log.histogram payload. An empty interval is
+// chosen automatically.
+type histogramRequest struct {
+	Query    schema.LogQuery `json:"query"`
+	Interval string          `json:"interval"`
+}
+
+// fieldValuesRequest is the log.fieldValues payload.
+type fieldValuesRequest struct {
+	Query schema.LogQuery `json:"query"`
+	Field string          `json:"field"`
+	Size  int             `json:"size"`
+}
+
+// aggregateRequest is the log.aggregate payload.
+type aggregateRequest struct {
+	Query     schema.LogQuery       `json:"query"`
+	Aggregate adapter.AggregateSpec `json:"aggregate"`
+}
+
+// significantTermsRequest is the log.significantTerms payload.
+type significantTermsRequest struct {
+	Query schema.LogQuery `json:"query"`
+	Field string          `json:"field"`
+}
+
+// fieldValuesResult is the log.fieldValues response. Other counts the logs
+// holding values beyond the returned ones.
+type fieldValuesResult struct {
+	Values []adapter.ValueCount `json:"values"`
+	Other  int64                `json:"other"`
+}
+
+// patternRequest is the log.fields and log.indices payload. An empty
+// pattern means the configured index pattern.
+type patternRequest struct {
+	Pattern string `json:"pattern"`
+}
+
+// contextRequest is the log.context payload. Before and after default to
+// defaultContextEntries when omitted.
+type contextRequest struct {
+	Entry  adapter.EntryRef `json:"entry"`
+	Before *int             `json:"before"`
+	After  *int             `json:"after"`
+}
+
+const defaultContextEntries = 10
+
+// byTraceRequest is the log.byTrace payload.
+type byTraceRequest struct {
+	TraceID string `json:"traceId"`
+	adapter.TimeWindow
+}
+
+// patternsRequest is the log.patterns payload.
+type patternsRequest struct {
+	Query       schema.LogQuery `json:"query"`
+	MaxPatterns int             `json:"maxPatterns"`
+}
+
+// compareRequest is the log.compare payload.
+type compareRequest struct {
+	Query          schema.LogQuery `json:"query"`
+	BaselineOffset string          `json:"baselineOffset"`
+}
+
+// esqlRequest is the log.esql payload.
+type esqlRequest struct {
+	Statement string         `json:"statement"`
+	Params    map[string]any `json:"params"`
+}
+
+// sqlRequest is the log.sql payload.
+type sqlRequest struct {
+	Query     string `json:"query"`
+	FetchSize int    `json:"fetchSize"`
+}
+
+// sqlNextRequest is the log.sqlNext payload.
+type sqlNextRequest struct {
+	Cursor string `json:"cursor"`
+}
+
+// writeRequest is the log.write payload.
+type writeRequest struct {
+	Index   string            `json:"index"`
+	Entries []schema.LogEntry `json:"entries"`
+}
+
+// writeResult is the log.write response. Failures lists the entries
+// Elasticsearch refused; the others were written.
+type writeResult struct {
+	Written  int                    `json:"written"`
+	Failures []adapter.WriteFailure `json:"failures,omitempty"`
+}
+
+// savedQueryRequest is the log.savedQuery.get, .list and .delete payload.
+type savedQueryRequest struct {
+	Team string `json:"team"`
+	Name string `json:"name"`
+}
+
+// statsRequest is the stats payload.
+type statsRequest struct {
+	Reset bool `json:"reset"`
+}
+
+// statsResult is the stats response: the worker pool's state, the
+// requests served per method, the circuit breaker's state unless it is
+// disabled, the rate limiter's when rate limiting is on, and the usage
+// counters when metering is on.
+type statsResult struct {
+	*adapter.UsageStats
+	Workers   poolStats               `json:"workers"`
+	Methods   map[string]methodStats  `json:"methods"`
+	Circuit   *adapter.CircuitStats   `json:"circuit,omitempty"`
+	RateLimit *adapter.RateLimitStats `json:"rateLimit,omitempty"`
+}
+
+// watchRequest is the log.watch.list and .delete payload.
+type watchRequest struct {
+	Team string `json:"team"`
+	Name string `json:"name"`
+}
+
+// exportRequest is the log.export payload. Without a path the export is
+// streamed back in chunks.
+type exportRequest struct {
+	Query  schema.LogQuery `json:"query"`
+	Format string          `json:"format"`
+	Path   string          `json:"path"`
+}
+
+// exportChunk is one intermediate log.export response: base64 encoded
+// export data.
+type exportChunk struct {
+	Chunk string `json:"chunk"`
+}
+
+// exportResult is the terminal log.export response.
+type exportResult struct {
+	Rows int    `json:"rows"`
+	Path string `json:"path,omitempty"`
+}
+
+// exportChunkSize is how many bytes of export data each chunk carries
+// before encoding.
+const exportChunkSize = 64 * 1024
+
+// streamBatch is one intermediate log.stream response.
+type streamBatch struct {
+	Entries []schema.LogEntry `json:"entries"`
+}
+
+// streamSummary is the terminal log.stream response.
+type streamSummary struct {
+	Batches int `json:"batches"`
+	Entries int `json:"entries"`
+}
+
+// defaultMaxConcurrentRequests bounds the requests served at once unless
+// maxConcurrentRequests is configured.
+const defaultMaxConcurrentRequests = 8
+
+// inlineMethods are served by the read loop rather than a worker: the
+// handshake and configure must complete before later requests run, a
+// cancel must not wait behind the requests it cancels, and stats reports
+// on the workers when none is free.
+var inlineMethods = map[string]bool{
+	"handshake":      true,
+	"cancel":         true,
+	"log.tailCancel": true,
+	"configure":      true,
+	"stats":          true,
+}
+
+// call is one request being served.
+type call struct {
+	s    *session
+	ctx  context.Context
+	req  rpcRequest
+	prov corelog.Provider
+	enc  *encoder
+	// reader reads the requests that follow, for the handshake to change
+	// their framing, and ended is closed once no further requests are
+	// read, for methods that run until then.
+	reader *requestReader
+	ended  <-chan struct{}
+	// calls holds the requests in flight, for cancel, and workers the
+	// pool serving them, for stats.
+	calls   *inflight
+	workers *pool
+}
+
+// payload decodes the request payload into v.
+func (c *call) payload(v any) error {
+	return json.Unmarshal(c.req.Payload, v)
+}
+
+// optionalPayload decodes the request payload into v when one was sent,
+// leaving v as is otherwise.
+func (c *call) optionalPayload(v any) error {
+	if len(c.req.Payload) == 0 {
+		return nil
+	}
+	return c.payload(v)
+}
+
+// query decodes the request payload as a log query and validates it. Fields
+// a query does not have are rejected rather than ignored.
+func (c *call) query() (schema.LogQuery, error) {
+	var query schema.LogQuery
+	dec := json.NewDecoder(bytes.NewReader(c.req.Payload))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&query); err != nil {
+		return query, payloadError(err)
+	}
+	return query, adapter.ValidateQuery(query)
+}
+
+func (c *call) elastic() (*adapter.ElasticProvider, error) {
+	return elasticProvider(c.prov, c.req.Method)
+}
+
+// handler serves one method and returns its result. Methods that answer
+// with several responses write them and return errWritten.
+type handler func(c *call) (any, error)
+
+// errWritten reports that a handler wrote its own responses.
+var errWritten = errors.New("responses written")
+
+// serve answers requests read from r until EOF or until ctx ends, writing
+// responses to w. Requests run concurrently on up to maxConcurrentRequests
+// workers, so responses may arrive in any order; each carries its
+// request's id. Once the input ends or ctx is done no further requests are
+// read: serve waits up to drainTimeout for the requests in flight, cancels
+// any still running, closes the provider and returns.
+func (s *session) serve(ctx context.Context, r io.Reader, w io.Writer) {
+	quit := make(chan struct{})
+	defer close(quit)
+	framer := newFramer(framingNewline, r, w)
+	reader := newRequestReader(framer, quit)
+	out := &output{framer: framer}
+	calls := newInflight()
+
+	// work is the parent of every request's context, so requests still
+	// running when the drain period ends can be cancelled together
+	work, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+
+	var (
+		workers *pool
+		once    *idempotencyCache
+		running sync.WaitGroup
+		drain   = defaultDrainTimeout
+		// core is the outcome of the core version check, once a request
+		// names the version
+		coreChecked bool
+		core        error
+	)
+	defer func() {
+		s.shutdown(&running, stopWork, drain)
+	}()
+	// Tails run until the input ends, so they end before the drain
+	ended := make(chan struct{})
+	defer close(ended)
+
+	for {
+		in, ok := reader.read(ctx.Done())
+		if !ok {
+			return
+		}
+		if in.err != nil {
+			if !errors.Is(in.err, io.EOF) {
+				writeErr(out.encoder(nil, nil, ""), in.err)
+			}
+			return
+		}
+		req := in.req
+		if req.TraceID == "" {
+			req.TraceID = adapter.NewTraceID()
+		}
+
+		enc := out.encoder(nil, req.ID, req.TraceID)
+		if !supportsProtocol(req.ProtocolVersion) {
+			writeErr(enc, unsupportedProtocolError(req.ProtocolVersion))
+			continue
+		}
+		if version := coreVersion(req); version != "" && !coreChecked {
+			coreChecked, core = true, adapter.CheckCoreVersion(version)
+		}
+		if core != nil {
+			writeErr(enc, core)
+			continue
+		}
+		serveMethod, ok := handlers[req.Method]
+		if !ok {
+			writeErr(enc, &methodError{msg: "unknown method: " + req.Method})
+			continue
+		}
+		// configure builds the provider itself, and a provider built from
+		// the request's config is built by the worker serving it, leaving
+		// the read loop free
+		build := req.Method != "configure" && !inlineMethods[req.Method] && s.needsBuild(req.Config)
+		if req.Method != "configure" && !build {
+			if _, err := s.ensureProvider(req.Config); err != nil {
+				writeErr(enc, err)
+				continue
+			}
+		}
+		if workers == nil {
+			workers = newPool(maxConcurrentRequests(req.Config), maxQueuedRequests(req.Config))
+			drain = drainTimeout(req.Config)
+			once = newIdempotencyCache(idempotencyCacheSize(req.Config), idempotencyTTL(req.Config))
+			out.maxResponseBytes = maxResponseBytes(req.Config)
+			out.verbose, _ = req.Config["verbose"].(bool)
+		}
+
+		reqCtx, cancel := requestContext(work, req)
+		var prov corelog.Provider
+		release := func() {}
+		if !build {
+			prov, release = s.acquire()
+		}
+		untrack := calls.track(req.ID, req.Method, cancel)
+		done := func() {
+			untrack()
+			release()
+		}
+		c := &call{s: s, ctx: reqCtx, req: req, prov: prov, reader: reader, ended: ended, enc: out.encoder(reqCtx, req.ID, req.TraceID), calls: calls, workers: workers}
+		if inlineMethods[req.Method] {
+			c.run(serveMethod)
+			done()
+			continue
+		}
+
+		if !workers.admit() {
+			s.logger.Warn("request refused, workers busy", "method", req.Method, "traceId", req.TraceID)
+			writeErr(enc, &busyError{retryAfter: busyRetryAfter})
+			s.metrics.refused(req.Method, enc.failed())
+			done()
+			continue
+		}
+		running.Add(1)
+		go func() {
+			defer func() {
+				done()
+				running.Done()
+			}()
+			// A request cancelled while queued never runs
+			if err := workers.acquire(c.ctx); err != nil {
+				writeErr(c.enc, fmt.Errorf("request not started: %w", err))
+				s.metrics.refused(req.Method, c.enc.failed())
+				return
+			}
+			defer workers.release()
+			if build {
+				if _, err := s.ensureProvider(req.Config); err != nil {
+					writeErr(c.enc, err)
+					return
+				}
+				var releaseBuilt func()
+				c.prov, releaseBuilt = s.acquire()
+				defer releaseBuilt()
+			}
+			c.runOnce(serveMethod, once)
+		}()
+	}
+}
+
+// incoming is a request read from the input, or the error that ended it.
+type incoming struct {
+	req rpcRequest
+	err error
+}
+
+// requestReader reads requests on a goroutine of its own, so that serving
+// can stop while a read is pending. It reads one request at a time, only
+// once asked for it, so the framing can change between requests.
+type requestReader struct {
+	// framer is read by the goroutine, and changed only while no request
+	// has been asked for.
+	framer   Framer
+	asked    bool
+	more     chan struct{}
+	requests chan incoming
+}
+
+// newRequestReader starts reading requests with framer until the input
+// ends or quit is closed.
+func newRequestReader(framer Framer, quit <-chan struct{}) *requestReader {
+	r := &requestReader{framer: framer, more: make(chan struct{}, 1), requests: make(chan incoming)}
+	go func() {
+		for {
+			select {
+			case <-r.more:
+			case <-quit:
+				return
+			}
+			var in incoming
+			msg, err := r.framer.ReadMessage()
+			if err == nil {
+				err = json.Unmarshal(msg, &in.req)
+			}
+			in.err = err
+			select {
+			case r.requests <- in:
+			case <-quit:
+				return
+			}
+			if in.err != nil {
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// read returns the next request, or false if stop is closed first. The
+// last request read carries the error that ended the input.
+func (r *requestReader) read(stop <-chan struct{}) (incoming, bool) {
+	if !r.asked {
+		r.asked = true
+		r.more <- struct{}{}
+	}
+	select {
+	case in := <-r.requests:
+		r.asked = false
+		return in, true
+	case <-stop:
+		return incoming{}, false
+	}
+}
+
+// run serves the call and writes its result. A panic while serving is
+// answered as an internal error, so that one faulty request does not end
+// the session.
+func (c *call) run(serveMethod handler) {
+	start := time.Now()
+	var err error
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			c.s.logger.Error("recovered from a panic", "method", c.req.Method, "panic", fmt.Sprint(v), "stack", string(stack))
+			err = &panicError{value: v, stack: stack}
+			writeErr(c.enc, err)
+		}
+		took := time.Since(start)
+		logRequest(c, took, err)
+		c.s.metrics.record(c.req.Method, took, c.enc.failed())
+	}()
+	var res any
+	res, err = serveMethod(c)
+	if errors.Is(err, errWritten) {
+		err = nil
+	} else {
+		write(c.enc, res, err)
+	}
+}
+
+// maxConcurrentRequests reads the maxConcurrentRequests config key.
+func maxConcurrentRequests(cfg map[string]any) int {
+	return positiveInt(cfg, "maxConcurrentRequests", defaultMaxConcurrentRequests)
+}
+
+// positiveInt reads a plugin config key holding a positive number,
+// returning def when it is unset or invalid.
+func positiveInt(cfg map[string]any, key string, def int) int {
+	switch n := cfg[key].(type) {
+	case float64:
+		if n >= 1 {
+			return int(n)
+		}
+	case int:
+		if n >= 1 {
+			return n
+		}
+	}
+	return def
+}
+
+// output serializes responses from concurrent requests, one message at a
+// time.
+type output struct {
+	mu     sync.Mutex
+	framer Framer
+	// maxResponseBytes is the size beyond which results are split; zero
+	// means never. It and verbose are set before any request runs.
+	maxResponseBytes int
+	// verbose adds the adapter version to the meta of every response.
+	verbose bool
+}
+
+// encoder returns an encoder for the responses to the request with id and
+// traceID, running under ctx once it is known.
+func (o *output) encoder(ctx context.Context, id json.RawMessage, traceID string) *encoder {
+	return &encoder{out: o, id: id, traceID: traceID, ctx: ctx}
+}
+
+// encoder writes the responses to one request, tagged with its id.
+type encoder struct {
+	out     *output
+	id      json.RawMessage
+	traceID string
+	// ctx is the request's context, whose end explains its errors.
+	ctx context.Context
+	// record keeps the responses written in recorded, to replay them for
+	// requests with the same idempotency key.
+	record   bool
+	recorded []rpcResponse
+	// finish receives the recorded responses once they are complete: when
+	// an error response, which ends a request, is about to be written, or
+	// else when the request returns.
+	finish func([]rpcResponse)
+	// failure is the error code of the last error response written.
+	failure string
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return (&output{framer: &lineFramer{w: w}}).encoder(nil, nil, "")
+}
+
+// meta returns the meta field of the responses, nil when it is empty.
+func (e *encoder) meta() *responseMeta {
+	meta := responseMeta{TraceID: e.traceID}
+	if e.out.verbose {
+		meta.AdapterVersion = adapter.AdapterVersion
+	}
+	if meta == (responseMeta{}) {
+		return nil
+	}
+	return &meta
+}
+
+func (e *encoder) Encode(res rpcResponse) error {
+	recorded := res
+	res.ID, res.Meta = e.id, e.meta()
+	msg, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	e.out.mu.Lock()
+	defer e.out.mu.Unlock()
+	if e.record {
+		e.recorded = append(e.recorded, recorded)
+		if res.Error != "" {
+			// Before the client sees the failure and sends the request
+			// again, so that it runs again rather than being replayed
+			e.finishRecording()
+		}
+	}
+	if res.ErrorCode != "" {
+		e.failure = res.ErrorCode
+	}
+	return e.out.framer.WriteMessage(msg)
+}
+
+// finishRecording passes the recorded responses to finish, once. The
+// caller holds e.out.mu.
+func (e *encoder) finishRecording() {
+	if e.finish != nil {
+		e.finish(e.recorded)
+		e.finish = nil
+	}
+}
+
+// failed returns the error code of the last error response written, or
+// "" when there was none.
+func (e *encoder) failed() string {
+	e.out.mu.Lock()
+	defer e.out.mu.Unlock()
+	return e.failure
+}
+
+// encodeReframed writes res as the last response in the current framing
+// and switches the session to framing. It returns the framer to read
+// further requests with.
+func (e *encoder) encodeReframed(res rpcResponse, framing string) (Framer, error) {
+	res.ID, res.Meta = e.id, e.meta()
+	msg, err := json.Marshal(res)
+	e.out.mu.Lock()
+	defer e.out.mu.Unlock()
+	if err == nil {
+		err = e.out.framer.WriteMessage(msg)
+	}
+	e.out.framer = reframe(e.out.framer, framing)
+	return e.out.framer, err
+}
+
+// handlers serve each method in adapter.Methods.
+var handlers = map[string]handler{
+	"cancel": func(c *call) (any, error) {
+		var target cancelRequest
+		if err := c.payload(&target); err != nil {
+			return nil, err
+		}
+		return cancelResult{ID: target.ID, Cancelled: c.calls.cancel(target.ID, "")}, nil
+	},
+	"handshake": func(c *call) (any, error) {
+		var hello handshakeRequest
+		if err := c.optionalPayload(&hello); err != nil {
+			return nil, err
+		}
+		version, ok := negotiateProtocol(hello.ProtocolVersions)
+		if !ok {
+			return nil, &protocolError{msg: fmt.Sprintf("no common protocol version: core speaks %v, plugin speaks %v", hello.ProtocolVersions, supportedProtocolVersions)}
+		}
+		framing, ok := negotiateFraming(hello.Framings)
+		if !ok {
+			return nil, &protocolError{msg: fmt.Sprintf("no common framing: core speaks %v, plugin speaks %v", hello.Framings, supportedFramings)}
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		result := handshakeResult{
+			ProtocolVersion:           version,
+			SupportedProtocolVersions: supportedProtocolVersions,
+			Framing:                   framing,
+			AdapterVersion:            adapter.AdapterVersion,
+			RequiresCore:              adapter.RequiresCore,
+			ProviderName:              adapter.ProviderName,
+			Capabilities:              elastic.Capabilities(),
+		}
+		// Requests after the handshake are read in the new framing
+		framer, err := c.enc.encodeReframed(rpcResponse{Result: result}, framing)
+		c.reader.framer = framer
+		if err != nil {
+			return nil, err
+		}
+		return nil, errWritten
+	},
+	"configure": func(c *call) (any, error) {
+		return c.s.configure(c.req.Config)
+	},
+	"capabilities": func(c *call) (any, error) {
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return capabilitiesResult{
+			AdapterVersion: adapter.AdapterVersion,
+			RequiresCore:   adapter.RequiresCore,
+			Capabilities:   elastic.Capabilities(),
+		}, nil
+	},
+	"health": func(c *call) (any, error) {
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Health(c.ctx)
+	},
+	"selftest": func(c *call) (any, error) {
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.SelfTest(c.ctx), nil
+	},
+	"stats": func(c *call) (any, error) {
+		var stats statsRequest
+		if err := c.optionalPayload(&stats); err != nil {
+			return nil, err
+		}
+		result := statsResult{Workers: c.workers.stats(), Methods: c.s.metrics.snapshot()}
+		elastic, ok := c.prov.(*adapter.ElasticProvider)
+		if !ok {
+			return result, nil
+		}
+		if circuit, err := elastic.CircuitState(); err == nil {
+			result.Circuit = &circuit
+		}
+		if limit, err := elastic.RateLimitState(); err == nil {
+			result.RateLimit = &limit
+		}
+		usage, err := elastic.UsageStats(stats.Reset)
+		if errors.Is(err, adapter.ErrUnsupported) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.UsageStats = &usage
+		return result, nil
+	},
+	"log.query": func(c *call) (any, error) {
+		query, err := c.query()
+		if err != nil {
+			return nil, err
+		}
+		return c.prov.Query(c.ctx, query)
+	},
+	"log.queryStats": func(c *call) (any, error) {
+		query, err := c.query()
+		if err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		entries, stats, err := elastic.QueryWithStats(c.ctx, query)
+		return queryStatsResult{Entries: entries, Stats: stats}, err
+	},
+	"log.slowQueries": func(c *call) (any, error) {
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.SlowQueries()
+	},
+	"log.queryBatch": func(c *call) (any, error) {
+		var queries []schema.LogQuery
+		if err := c.payload(&queries); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		results, errs := elastic.QueryBatch(c.ctx, queries)
+		items := make([]batchItem, len(queries))
+		for i := range items {
+			items[i].Entries = results[i]
+			if errs[i] != nil {
+				items[i].Error = errs[i].Error()
+			}
+		}
+		return items, nil
+	},
+	"log.count": func(c *call) (any, error) {
+		query, err := c.query()
+		if err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		n, err := elastic.Count(c.ctx, query)
+		return countResult{Count: n}, err
+	},
+	"log.histogram": func(c *call) (any, error) {
+		var hist histogramRequest
+		if err := c.payload(&hist); err != nil {
+			return nil, err
+		}
+		var interval time.Duration
+		if hist.Interval != "" {
+			var err error
+			if interval, err = time.ParseDuration(hist.Interval); err != nil {
+				return nil, fmt.Errorf("invalid interval: %w", err)
+			}
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Histogram(c.ctx, hist.Query, interval)
+	},
+	"log.fieldValues": func(c *call) (any, error) {
+		var values fieldValuesRequest
+		if err := c.payload(&values); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		res, other, err := elastic.FieldValues(c.ctx, values.Query, values.Field, values.Size)
+		return fieldValuesResult{Values: res, Other: other}, err
+	},
+	"log.aggregate": func(c *call) (any, error) {
+		var agg aggregateRequest
+		if err := c.payload(&agg); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Aggregate(c.ctx, agg.Query, agg.Aggregate)
+	},
+	"log.significantTerms": func(c *call) (any, error) {
+		var significant significantTermsRequest
+		if err := c.payload(&significant); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.SignificantTerms(c.ctx, significant.Query, significant.Field)
+	},
+	"log.fields": func(c *call) (any, error) {
+		var fields patternRequest
+		if err := c.optionalPayload(&fields); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.ListFields(c.ctx, fields.Pattern)
+	},
+	"log.indices": func(c *call) (any, error) {
+		var indices patternRequest
+		if err := c.optionalPayload(&indices); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.ListIndices(c.ctx, indices.Pattern)
+	},
+	"log.context": func(c *call) (any, error) {
+		var around contextRequest
+		if err := c.payload(&around); err != nil {
+			return nil, err
+		}
+		before, after := defaultContextEntries, defaultContextEntries
+		if around.Before != nil {
+			before = *around.Before
+		}
+		if around.After != nil {
+			after = *around.After
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Context(c.ctx, around.Entry, before, after)
+	},
+	"log.byTrace": func(c *call) (any, error) {
+		var trace byTraceRequest
+		if err := c.payload(&trace); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.QueryByTrace(c.ctx, trace.TraceID, trace.TimeWindow)
+	},
+	"log.patterns": func(c *call) (any, error) {
+		var patterns patternsRequest
+		if err := c.payload(&patterns); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Patterns(c.ctx, patterns.Query, patterns.MaxPatterns)
+	},
+	"log.summarize": func(c *call) (any, error) {
+		query, err := c.query()
+		if err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Summarize(c.ctx, query)
+	},
+	"log.compare": func(c *call) (any, error) {
+		var compare compareRequest
+		if err := c.payload(&compare); err != nil {
+			return nil, err
+		}
+		offset, err := time.ParseDuration(compare.BaselineOffset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid baselineOffset: %w", err)
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.Compare(c.ctx, compare.Query, offset)
+	},
+	"log.esql": func(c *call) (any, error) {
+		var esql esqlRequest
+		if err := c.payload(&esql); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.QueryESQL(c.ctx, esql.Statement, esql.Params)
+	},
+	"log.sql": func(c *call) (any, error) {
+		var sql sqlRequest
+		if err := c.payload(&sql); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.QuerySQL(c.ctx, sql.Query, sql.FetchSize)
+	},
+	"log.sqlNext": func(c *call) (any, error) {
+		var next sqlNextRequest
+		if err := c.payload(&next); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.QuerySQLNext(c.ctx, next.Cursor)
+	},
+	"log.write": func(c *call) (any, error) {
+		var w writeRequest
+		if err := c.payload(&w); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		err = elastic.WriteEntries(c.ctx, w.Index, w.Entries)
+		var failed *adapter.WriteError
+		if errors.As(err, &failed) {
+			return writeResult{Written: len(w.Entries) - len(failed.Failures), Failures: failed.Failures}, nil
+		}
+		return writeResult{Written: len(w.Entries)}, err
+	},
+	"log.savedQuery.save": func(c *call) (any, error) {
+		var saved adapter.SavedQuery
+		if err := c.payload(&saved); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.SaveQuery(c.ctx, saved)
+	},
+	"log.savedQuery.get": func(c *call) (any, error) {
+		var saved savedQueryRequest
+		if err := c.payload(&saved); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.GetQuery(c.ctx, saved.Team, saved.Name)
+	},
+	"log.savedQuery.list": func(c *call) (any, error) {
+		var saved savedQueryRequest
+		if err := c.payload(&saved); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.ListQueries(c.ctx, saved.Team)
+	},
+	"log.savedQuery.delete": func(c *call) (any, error) {
+		var saved savedQueryRequest
+		if err := c.payload(&saved); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return saved, elastic.DeleteQuery(c.ctx, saved.Team, saved.Name)
+	},
+	"log.savedQuery.match": func(c *call) (any, error) {
+		var entry schema.LogEntry
+		if err := c.payload(&entry); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.MatchSavedQueries(c.ctx, entry)
+	},
+	"log.watch.create": func(c *call) (any, error) {
+		var spec adapter.WatchSpec
+		if err := c.payload(&spec); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.CreateWatch(c.ctx, spec)
+	},
+	"log.watch.list": func(c *call) (any, error) {
+		var watch watchRequest
+		if err := c.payload(&watch); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.ListWatches(c.ctx, watch.Team)
+	},
+	"log.watch.delete": func(c *call) (any, error) {
+		var watch watchRequest
+		if err := c.payload(&watch); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return watch, elastic.DeleteWatch(c.ctx, watch.Team, watch.Name)
+	},
+	"log.export": func(c *call) (any, error) {
+		var export exportRequest
+		if err := c.payload(&export); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		if export.Path != "" {
+			rows, err := elastic.ExportToFile(c.ctx, export.Query, export.Format, export.Path)
+			return exportResult{Rows: rows, Path: export.Path}, err
+		}
+		chunks := &chunkWriter{enc: c.enc}
+		rows, err := elastic.Export(c.ctx, export.Query, export.Format, chunks)
+		if err == nil {
+			err = chunks.flush()
+		}
+		return exportResult{Rows: rows}, err
+	},
+	"log.stream": func(c *call) (any, error) {
+		query, err := c.query()
+		if err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		stream(c.ctx, c.enc, elastic, query)
+		return nil, errWritten
+	},
+	"log.tail": func(c *call) (any, error) {
+		query, err := c.query()
+		if err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		tail(c.ctx, c.ended, c.enc, elastic, query)
+		return nil, errWritten
+	},
+	"log.tailCancel": func(c *call) (any, error) {
+		var target cancelRequest
+		if err := c.payload(&target); err != nil {
+			return nil, err
+		}
+		if _, ok := requestKey(target.ID); !ok {
+			return nil, errors.New("the id of the log.tail request to end is required")
+		}
+		return cancelResult{ID: target.ID, Cancelled: c.calls.cancel(target.ID, "log.tail")}, nil
+	},
+	"log.querySubmit": func(c *call) (any, error) {
+		query, err := c.query()
+		if err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.SubmitAsync(c.ctx, query)
+	},
+	"log.queryPoll": func(c *call) (any, error) {
+		var async asyncRequest
+		if err := c.payload(&async); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return elastic.PollAsync(c.ctx, async.ID)
+	},
+	"log.queryCancel": func(c *call) (any, error) {
+		var async asyncRequest
+		if err := c.payload(&async); err != nil {
+			return nil, err
+		}
+		elastic, err := c.elastic()
+		if err != nil {
+			return nil, err
+		}
+		return asyncRequest{ID: async.ID}, elastic.CancelAsync(c.ctx, async.ID)
+	},
+}
+
+// stream writes one response with more set per batch, then a terminal
+// response carrying either a summary or the error that ended the stream.
+func stream(ctx context.Context, enc *encoder, elastic *adapter.ElasticProvider, query schema.LogQuery) {
+	var summary streamSummary
+	err := elastic.QueryStream(ctx, query, func(batch []schema.LogEntry) error {
+		summary.Batches++
+		summary.Entries += len(batch)
+		return enc.Encode(rpcResponse{Result: streamBatch{Entries: batch}, More: true})
+	})
+	write(enc, summary, err)
+}
+
+// chunkWriter writes export data as base64 log.export chunks of
+// exportChunkSize bytes with more set.
+type chunkWriter struct {
+	enc *encoder
+	buf []byte
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= exportChunkSize {
+		if err := c.emit(c.buf[:exportChunkSize]); err != nil {
+			return 0, err
+		}
+		c.buf = c.buf[exportChunkSize:]
+	}
+	return len(p), nil
+}
+
+// flush writes any buffered data as a final chunk.
+func (c *chunkWriter) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.emit(c.buf)
+	c.buf = nil
+	return err
+}
+
+func (c *chunkWriter) emit(data []byte) error {
+	return c.enc.Encode(rpcResponse{Result: exportChunk{Chunk: base64.StdEncoding.EncodeToString(data)}, More: true})
+}
+
+// tail follows query, writing one response with more set per batch, until
+// ctx ends, as cancel or log.tailCancel with the tail's id end it, or ended
+// is closed; then it writes the terminal response.
+func tail(ctx context.Context, ended <-chan struct{}, enc *encoder, elastic *adapter.ElasticProvider, query schema.LogQuery) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ended:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var summary streamSummary
+	err := elastic.Tail(ctx, query, func(batch []schema.LogEntry) error {
+		summary.Batches++
+		summary.Entries += len(batch)
+		return enc.Encode(rpcResponse{Result: streamBatch{Entries: batch}, More: true})
+	})
+	write(enc, summary, err)
+}
+
+// elasticProvider returns prov as an ElasticProvider for methods beyond the
+// core log.Provider interface.
+func elasticProvider(prov corelog.Provider, method string) (*adapter.ElasticProvider, error) {
+	elastic, ok := prov.(*adapter.ElasticProvider)
+	if !ok {
+		return nil, &methodError{msg: fmt.Sprintf("method %s not supported by provider", method)}
+	}
+	return elastic, nil
+}
+
+func write(enc *encoder, result any, err error) {
+	if err != nil {
+		writeErr(enc, err)
+		return
+	}
+	parts, err := splitResult(enc.id, result, enc.out.maxResponseBytes)
+	if err != nil {
+		writeErr(enc, err)
+		return
+	}
+	if len(parts) == 1 {
+		_ = enc.Encode(rpcResponse{Result: parts[0]})
+		return
+	}
+	for i, part := range parts {
+		if err := enc.Encode(rpcResponse{Result: part, Seq: i + 1, Final: i == len(parts)-1}); err != nil {
+			return
+		}
+	}
+}
+
+// requestError returns err, marked as caused by the end of the request's
+// context if it ended.
+func requestError(enc *encoder, err error) error {
+	if enc.ctx != nil && enc.ctx.Err() != nil {
+		return &contextError{err: err, cause: enc.ctx.Err()}
+	}
+	return err
+}
+
+// writeErr writes err with its code and details. Errors of a request that
+// timed out or was cancelled are reported as such.
+func writeErr(enc *encoder, err error) {
+	err = requestError(enc, err)
+	_ = enc.Encode(rpcResponse{Error: err.Error(), ErrorCode: errorCode(err), Details: errorDetails(err)})
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corelog "github.com/opsorch/opsorch-core/log"
+)
+
+// defaultDrainTimeout is how long requests in flight may run on shutdown
+// unless drainTimeout is configured.
+const defaultDrainTimeout = 30 * time.Second
+
+// drainTimeout reads the drainTimeout config key.
+func drainTimeout(cfg map[string]any) time.Duration {
+	if s, ok := cfg["drainTimeout"].(string); ok {
+		if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return defaultDrainTimeout
+}
+
+// shutdown waits up to drain for the requests in flight, cancels those
+// still running, and once all have answered closes the provider and those
+// it replaced.
+func (s *session) shutdown(running *sync.WaitGroup, stopWork context.CancelFunc, drain time.Duration) {
+	drained := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(drain)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		s.logger.Warn("cancelling requests still running after the drain period", "drainTimeout", drain.String())
+		stopWork()
+		<-drained
+	}
+	s.mu.Lock()
+	prov := s.provider
+	s.provider = nil
+	s.mu.Unlock()
+	s.retiring.Wait()
+	s.closeProvider(prov)
+}
+
+// closeProvider closes prov when it holds resources, such as open points
+// in time and scrolls.
+func (s *session) closeProvider(prov corelog.Provider) {
+	if closer, ok := prov.(interface{ Close() error }); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Warn("failed to close provider", "error", err.Error())
+		}
+	}
+}
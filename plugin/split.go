@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"encoding/json"
+)
+
+// maxResponseBytes reads the maxResponseBytes config key. Zero means
+// responses are never split.
+func maxResponseBytes(cfg map[string]any) int {
+	return positiveInt(cfg, "maxResponseBytes", 0)
+}
+
+// splitResult encodes result as the results of responses to the request
+// with id, each encoding to at most limit bytes where it can. A result
+// that fits, or that has no entries to split, is returned whole. Otherwise
+// each part has the form of result with a run of its entries: a JSON array
+// is split between its elements, and an object between the elements of its
+// entries field, its other fields repeated in every part.
+func splitResult(id json.RawMessage, result any, limit int) ([]json.RawMessage, error) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	whole := []json.RawMessage{raw}
+	if limit <= 0 || len(raw) <= limit {
+		return whole, nil
+	}
+
+	var (
+		elements []json.RawMessage
+		fields   map[string]json.RawMessage
+	)
+	if json.Unmarshal(raw, &elements) != nil {
+		if json.Unmarshal(raw, &fields) != nil || json.Unmarshal(fields["entries"], &elements) != nil {
+			return whole, nil
+		}
+	}
+	if len(elements) < 2 {
+		return whole, nil
+	}
+	part := func(elements []json.RawMessage) (json.RawMessage, error) {
+		if elements == nil {
+			elements = []json.RawMessage{}
+		}
+		if fields == nil {
+			return json.Marshal(elements)
+		}
+		entries, err := json.Marshal(elements)
+		if err != nil {
+			return nil, err
+		}
+		fields["entries"] = entries
+		return json.Marshal(fields)
+	}
+
+	// Every part carries the envelope of an empty part, and each element
+	// adds itself and a comma
+	empty, err := part(nil)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := json.Marshal(rpcResponse{ID: id, Result: empty, Seq: len(elements), Final: true})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		parts []json.RawMessage
+		start int
+		size  = len(envelope)
+	)
+	for i, element := range elements {
+		if i > start && size+len(element)+1 > limit {
+			p, err := part(elements[start:i])
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, p)
+			start, size = i, len(envelope)
+		}
+		size += len(element) + 1
+	}
+	p, err := part(elements[start:])
+	if err != nil {
+		return nil, err
+	}
+	return append(parts, p), nil
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	adapter "github.com/opsorch/opsorch-elastic-adapter/log"
+)
+
+// payloadError describes a payload that failed to decode as an
+// *adapter.ValidationError naming the field at fault, in place of the
+// decoder's Go-flavored message.
+func payloadError(err error) error {
+	var (
+		typeErr   *json.UnmarshalTypeError
+		syntaxErr *json.SyntaxError
+		timeErr   *time.ParseError
+	)
+	v := adapter.Violation{Field: "payload"}
+	switch {
+	case errors.As(err, &typeErr):
+		v.Field = jsonPath(typeErr.Field)
+		v.Message = fmt.Sprintf("must be %s, got %s", jsonType(typeErr.Type), typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder reports the field name only, not its path
+		name, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		v.Field, v.Message = name, "unknown field"
+	case errors.As(err, &syntaxErr):
+		v.Message = fmt.Sprintf("invalid JSON at byte %d", syntaxErr.Offset)
+	case errors.As(err, &timeErr):
+		v.Field = ""
+		v.Message = fmt.Sprintf("invalid time %q: want RFC 3339, such as 2024-05-01T12:00:00Z", timeErr.Value)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		v.Message = "missing or truncated"
+	default:
+		v.Message = err.Error()
+	}
+	return &adapter.ValidationError{Violations: []adapter.Violation{v}}
+}
+
+// jsonPath turns a decoder field path such as filters.0.value into
+// filters[0].value.
+func jsonPath(field string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			fmt.Fprintf(&b, "[%s]", part)
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// jsonType names the JSON type a Go type decodes from.
+func jsonType(t reflect.Type) string {
+	if t == reflect.TypeOf(time.Time{}) {
+		return "an RFC 3339 time string"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}